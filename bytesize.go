@@ -0,0 +1,49 @@
+package ctrld
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// byteSizeUnits maps units of byte size strings to their multipliers. Decimal units are treated as binary ones,
+// as most DNS tools do, e.g: "32MB" means 32 * 1024 * 1024 bytes.
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseByteSize parses a byte size string, e.g: "32MB", "512KiB" or "1.5G", into number of bytes.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size: %q", s)
+	}
+	unit, ok := byteSizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size unit: %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// validateByteSize validates a byte size string, see ParseByteSize.
+func validateByteSize(fl validator.FieldLevel) bool {
+	_, err := ParseByteSize(fl.Field().String())
+	return err == nil
+}
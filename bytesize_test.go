@@ -0,0 +1,36 @@
+package ctrld
+
+import (
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"32MB", 32 << 20, false},
+		{"32 mb", 32 << 20, false},
+		{"512KiB", 512 << 10, false},
+		{"1.5G", 3 << 29, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"32TB", 0, true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseByteSize(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected size, want: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
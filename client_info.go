@@ -19,4 +19,9 @@ const (
 	Dnsmasq  LeaseFileFormat = "dnsmasq"
 	IscDhcpd LeaseFileFormat = "isc-dhcpd"
 	KeaDHCP4 LeaseFileFormat = "kea-dhcp4"
+
+	// Static leases/reservations formats.
+	DnsmasqConf LeaseFileFormat = "dnsmasq-conf"
+	KeaConf     LeaseFileFormat = "kea-conf"
+	PfsenseXML  LeaseFileFormat = "pfsense-xml"
 )
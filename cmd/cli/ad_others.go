@@ -8,3 +8,10 @@ import (
 
 // addExtraSplitDnsRule adds split DNS rule if present.
 func addExtraSplitDnsRule(_ *ctrld.Config) bool { return false }
+
+// getActiveDirectoryDomain returns AD domain name of this computer.
+// It's only supported on Windows, "ad_domain" config is used on other platforms.
+func getActiveDirectoryDomain() (string, error) { return "", nil }
+
+// getActiveDirectoryForest returns AD forest root domain name of this computer.
+func getActiveDirectoryForest() (string, error) { return "", nil }
@@ -47,3 +47,13 @@ func getActiveDirectoryDomain() (string, error) {
 	}
 	return string(output), nil
 }
+
+// getActiveDirectoryForest returns AD forest root domain name of this computer.
+func getActiveDirectoryForest() (string, error) {
+	cmd := "(Get-WmiObject Win32_NTDomain | Where-Object { $_.DnsForestName } | Select-Object -First 1).DnsForestName"
+	output, err := powershell(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to get forest name: %w, output:\n\n%s", err, string(output))
+	}
+	return string(output), nil
+}
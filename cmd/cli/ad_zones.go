@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// upstreamActiveDirectoryPrefix is the prefix of "ad_domain_controllers" upstream names.
+const upstreamActiveDirectoryPrefix = upstreamPrefix + "ad."
+
+// adZones routes queries for Active Directory zones to the domain controllers. AD clients rely on
+// lots of SRV lookups, e.g: "_ldap._tcp.dc._msdcs.<domain>", misrouting them breaks logons and GPO.
+type adZones struct {
+	zones     []string
+	upstreams []string
+	ucs       map[string]*ctrld.UpstreamConfig
+}
+
+// newADZones returns new adZones using the given service config, or nil if this computer
+// is not part of an AD domain, and there's no "ad_domain" configured.
+func newADZones(cfg *ctrld.ServiceConfig) *adZones {
+	domain, forest := cfg.ADDomain, ""
+	if domain == "" {
+		d, err := getActiveDirectoryDomain()
+		if err != nil {
+			mainLog.Load().Debug().Err(err).Msg("unable to get active directory domain")
+		}
+		if domain = d; domain != "" {
+			if forest, err = getActiveDirectoryForest(); err != nil {
+				mainLog.Load().Debug().Err(err).Msg("unable to get active directory forest")
+			}
+		}
+	}
+	domain = canonicalName(domain)
+	if domain == "" {
+		return nil
+	}
+	az := &adZones{
+		zones: activeDirectoryZones(domain, canonicalName(forest)),
+		ucs:   make(map[string]*ctrld.UpstreamConfig),
+	}
+	for i, dc := range cfg.ADDomainControllers {
+		if _, _, err := net.SplitHostPort(dc); err != nil {
+			dc = net.JoinHostPort(dc, "53")
+		}
+		upstream := upstreamActiveDirectoryPrefix + strconv.Itoa(i)
+		uc := &ctrld.UpstreamConfig{
+			Name:     "Domain controller " + dc,
+			Type:     ctrld.ResolverTypeLegacy,
+			Endpoint: dc,
+			Timeout:  2000,
+		}
+		uc.Init()
+		az.upstreams = append(az.upstreams, upstream)
+		az.ucs[upstream] = uc
+	}
+	mainLog.Load().Debug().Msgf("active directory zones: %v, domain controllers: %v", az.zones, cfg.ADDomainControllers)
+	return az
+}
+
+// activeDirectoryZones returns the zones of AD domain, and of the forest root domain, if different.
+//
+// The "_msdcs", "_sites", "_tcp", "_udp" and "DomainDnsZones" zones of the domain are its sub-zones,
+// while the forest-wide "_msdcs" and "ForestDnsZones" zones belong to the forest root domain.
+func activeDirectoryZones(domain, forest string) []string {
+	zones := []string{domain}
+	if forest != "" && forest != domain {
+		zones = append(zones, "_msdcs."+forest, "forestdnszones."+forest)
+	}
+	return zones
+}
+
+// lookup returns the AD zone of domain, and the upstreams which the query is forwarded to.
+// The zone is empty if domain is not in any AD zones. The upstreams are empty if there's no
+// "ad_domain_controllers" configured, the query is then resolved using OS resolver.
+func (az *adZones) lookup(domain string) (string, []string) {
+	if az == nil {
+		return "", nil
+	}
+	for _, zone := range az.zones {
+		if inZone(domain, zone) {
+			return zone, az.upstreams
+		}
+	}
+	return "", nil
+}
+
+// upstreamConfig returns the upstream config of domain controller upstream name, or nil if not a domain controller one.
+func (az *adZones) upstreamConfig(upstream string) *ctrld.UpstreamConfig {
+	if az == nil {
+		return nil
+	}
+	return az.ucs[upstream]
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_activeDirectoryZones(t *testing.T) {
+	assert.Equal(t, []string{"corp.example.com"}, activeDirectoryZones("corp.example.com", ""))
+	assert.Equal(t, []string{"corp.example.com"}, activeDirectoryZones("corp.example.com", "corp.example.com"))
+	assert.Equal(t,
+		[]string{"child.corp.example.com", "_msdcs.corp.example.com", "forestdnszones.corp.example.com"},
+		activeDirectoryZones("child.corp.example.com", "corp.example.com"),
+	)
+}
+
+func Test_adZones_lookup(t *testing.T) {
+	az := newADZones(&ctrld.ServiceConfig{ADDomain: "Corp.Example.com"})
+	tests := []struct {
+		domain   string
+		wantZone string
+	}{
+		{"corp.example.com", "corp.example.com"},
+		{"_ldap._tcp.dc._msdcs.corp.example.com", "corp.example.com"},
+		{"dc1.corp.example.com", "corp.example.com"},
+		{"example.com", ""},
+		{"notcorp.example.com", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			zone, upstreams := az.lookup(tc.domain)
+			assert.Equal(t, tc.wantZone, zone)
+			assert.Empty(t, upstreams)
+		})
+	}
+
+	az = newADZones(&ctrld.ServiceConfig{ADDomain: "corp.example.com", ADDomainControllers: []string{"10.0.0.10", "10.0.0.11:5353"}})
+	zone, upstreams := az.lookup("_kerberos._udp.corp.example.com")
+	assert.Equal(t, "corp.example.com", zone)
+	assert.Equal(t, []string{upstreamActiveDirectoryPrefix + "0", upstreamActiveDirectoryPrefix + "1"}, upstreams)
+	assert.Equal(t, "10.0.0.10:53", az.upstreamConfig(upstreams[0]).Endpoint)
+	assert.Equal(t, "10.0.0.11:5353", az.upstreamConfig(upstreams[1]).Endpoint)
+	assert.Nil(t, az.upstreamConfig(upstreamOS))
+
+	var nilAz *adZones
+	zone, _ = nilAz.lookup("corp.example.com")
+	assert.Empty(t, zone)
+}
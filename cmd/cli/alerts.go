@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// Operational events sent to webhooks and email.
+const (
+	alertEventUpstreamsDown      = "upstreams_down"
+	alertEventUpstreamsUp        = "upstreams_up"
+	alertEventConfigReloadFailed = "config_reload_failed"
+	alertEventClientJoined       = "client_joined"
+	alertEventAnomaly            = "anomaly"
+	alertEventUpstreamCertExpiry = "upstream_cert_expiring"
+	alertEventUpstreamCertChange = "upstream_cert_changed"
+)
+
+// alertClientJoinedGracePeriod is the time after starting which "client_joined" events are not sent,
+// since all clients are new to a freshly started ctrld.
+const alertClientJoinedGracePeriod = 10 * time.Minute
+
+// alertDefaultEmailEvents are the events sent by email if "smtp_events" is not set. New clients are
+// not critical, and could flood the mailbox of busy networks.
+var alertDefaultEmailEvents = []string{
+	alertEventUpstreamsDown, alertEventUpstreamsUp, alertEventConfigReloadFailed, alertEventAnomaly,
+	alertEventUpstreamCertExpiry, alertEventUpstreamCertChange,
+}
+
+// alertEvent is an operational event, it's also the payload sent to generic JSON webhooks.
+type alertEvent struct {
+	Event    string    `json:"event"`
+	Message  string    `json:"message"`
+	Instance string    `json:"instance,omitempty"`
+	Time     time.Time `json:"time"`
+	Data     any       `json:"data,omitempty"`
+}
+
+// alertClient is the data of "client_joined" events.
+type alertClient struct {
+	ClientIP string `json:"client_ip"`
+	Mac      string `json:"mac,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// alertUpstreamCert is the data of "upstream_cert_expiring" and "upstream_cert_changed" events.
+type alertUpstreamCert struct {
+	Upstream string              `json:"upstream"`
+	Cert     *ctrld.UpstreamCert `json:"cert"`
+	Previous *ctrld.UpstreamCert `json:"previous,omitempty"`
+}
+
+// alerts sends operational events to the configured webhooks and email recipients, so fleet operators
+// get alerted without scraping logs. Events are sent in background, no retry on failures.
+type alerts struct {
+	webhookURLs   []string
+	webhookEvents map[string]bool
+	email         *smtpSender
+	emailEvents   map[string]bool
+	instance      string
+	started       time.Time
+	now           func() time.Time
+	// postWebhook and sendEmail deliver the events, stubbed in tests.
+	postWebhook func(url string, body []byte) error
+	sendEmail   func(subject, body string) error
+
+	allUpstreamsDown atomic.Bool
+}
+
+// newAlerts returns the alerts of given service config, or nil if there's neither webhooks nor SMTP server configured.
+// The start time of old alerts is kept, so reloading does not restart the "client_joined" grace period.
+func newAlerts(cfg *ctrld.ServiceConfig, old *alerts) *alerts {
+	if len(cfg.WebhookURLs) == 0 && cfg.SMTPServer == "" {
+		return nil
+	}
+	a := &alerts{
+		webhookURLs:   cfg.WebhookURLs,
+		webhookEvents: alertEventsSet(cfg.WebhookEvents, nil),
+		now:           time.Now,
+		started:       time.Now(),
+		postWebhook:   postWebhook,
+	}
+	if old != nil {
+		a.started = old.started
+	}
+	if cfg.SMTPServer != "" {
+		a.email = newSMTPSender(cfg)
+		a.emailEvents = alertEventsSet(cfg.SMTPEvents, alertDefaultEmailEvents)
+		a.sendEmail = a.email.send
+	}
+	a.instance, _ = os.Hostname()
+	return a
+}
+
+// alertEventsSet returns the set of events, or of default ones if events is empty. A nil set means all events.
+func alertEventsSet(events, defaultEvents []string) map[string]bool {
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	m := make(map[string]bool)
+	for _, e := range events {
+		m[e] = true
+	}
+	return m
+}
+
+// subscribed reports whether the event is sent to webhooks or by email.
+func (a *alerts) subscribed(event string) bool {
+	return a != nil && (a.webhookSubscribed(event) || a.emailSubscribed(event))
+}
+
+func (a *alerts) webhookSubscribed(event string) bool {
+	return len(a.webhookURLs) > 0 && (a.webhookEvents == nil || a.webhookEvents[event])
+}
+
+func (a *alerts) emailSubscribed(event string) bool {
+	return a.email != nil && (a.emailEvents == nil || a.emailEvents[event])
+}
+
+// notify sends the event to webhooks and email recipients in background.
+func (a *alerts) notify(event, message string, data any) {
+	if !a.subscribed(event) {
+		return
+	}
+	ev := &alertEvent{Event: event, Message: message, Instance: a.instance, Time: a.now(), Data: data}
+	if a.webhookSubscribed(event) {
+		for _, u := range a.webhookURLs {
+			body, err := webhookPayload(webhookFormat(u), ev)
+			if err != nil {
+				continue
+			}
+			go func(u string) {
+				if err := a.postWebhook(u, body); err != nil {
+					mainLog.Load().Warn().Err(err).Msgf("could not send %s event to webhook", event)
+				}
+			}(u)
+		}
+	}
+	if a.emailSubscribed(event) {
+		subject, body := smtpMessage(ev)
+		go func() {
+			if err := a.sendEmail(subject, body); err != nil {
+				mainLog.Load().Warn().Err(err).Msgf("could not send %s event by email", event)
+			}
+		}()
+	}
+}
+
+// upstreamStateChanged sends "upstreams_down" event when all upstreams are down,
+// and "upstreams_up" event when any of them is up again.
+func (a *alerts) upstreamStateChanged(um *upstreamMonitor, upstreams []string) func(upstream string, down bool) {
+	return func(upstream string, down bool) {
+		if len(upstreams) == 0 {
+			return
+		}
+		allDown := true
+		for _, u := range upstreams {
+			if !um.isDown(u) {
+				allDown = false
+				break
+			}
+		}
+		switch {
+		case allDown && a.allUpstreamsDown.CompareAndSwap(false, true):
+			a.notify(alertEventUpstreamsDown, "all upstreams are down", map[string][]string{"upstreams": upstreams})
+		case !allDown && a.allUpstreamsDown.CompareAndSwap(true, false):
+			a.notify(alertEventUpstreamsUp, fmt.Sprintf("upstream %s is up again", upstream), map[string]string{"upstream": upstream})
+		}
+	}
+}
+
+// clientJoined sends "client_joined" event for the first query of ci, unless ctrld was just started.
+func (a *alerts) clientJoined(ci *ctrld.ClientInfo) {
+	if !a.subscribed(alertEventClientJoined) || a.now().Sub(a.started) < alertClientJoinedGracePeriod {
+		return
+	}
+	name := ci.IP
+	if ci.Hostname != "" {
+		name = fmt.Sprintf("%s (%s)", ci.Hostname, ci.IP)
+	}
+	a.notify(alertEventClientJoined, fmt.Sprintf("new client joined: %s", name), &alertClient{ClientIP: ci.IP, Mac: ci.Mac, Hostname: ci.Hostname})
+}
+
+// configReloadFailed sends "config_reload_failed" event.
+func (a *alerts) configReloadFailed(reason string, err error) {
+	a.notify(alertEventConfigReloadFailed, fmt.Sprintf("%s: %v", reason, err), map[string]string{"error": err.Error()})
+}
+
+// anomaly sends "anomaly" event.
+func (a *alerts) anomaly(ev anomalyEvent) {
+	msg := fmt.Sprintf("possible infected client %s: %d NXDOMAIN responses in the last minute", ev.ClientIP, ev.Count)
+	if ev.Reason == anomalyReasonDga {
+		msg = fmt.Sprintf("possible infected client %s: %d random looking domains queried in the last minute", ev.ClientIP, ev.Count)
+	}
+	a.notify(alertEventAnomaly, msg, ev)
+}
+
+// upstreamCertExpiring sends "upstream_cert_expiring" event.
+func (a *alerts) upstreamCertExpiring(upstream string, cert *ctrld.UpstreamCert) {
+	msg := fmt.Sprintf("certificate of %s expires at %s", upstream, cert.NotAfter.Format(time.RFC3339))
+	a.notify(alertEventUpstreamCertExpiry, msg, &alertUpstreamCert{Upstream: upstream, Cert: cert})
+}
+
+// upstreamCertChanged sends "upstream_cert_changed" event.
+func (a *alerts) upstreamCertChanged(upstream string, prev, cert *ctrld.UpstreamCert) {
+	msg := fmt.Sprintf("certificate of %s changed unexpectedly, new one is issued by %s", upstream, cert.Issuer)
+	a.notify(alertEventUpstreamCertChange, msg, &alertUpstreamCert{Upstream: upstream, Cert: cert, Previous: prev})
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+type webhookRequest struct {
+	url  string
+	body map[string]any
+}
+
+func newTestAlerts(t *testing.T, cfg *ctrld.ServiceConfig) (*alerts, chan webhookRequest) {
+	t.Helper()
+	a := newAlerts(cfg, nil)
+	require.NotNil(t, a)
+	a.instance = "router"
+	ch := make(chan webhookRequest, 10)
+	a.postWebhook = func(url string, body []byte) error {
+		req := webhookRequest{url: url}
+		require.NoError(t, json.Unmarshal(body, &req.body))
+		ch <- req
+		return nil
+	}
+	return a, ch
+}
+
+func Test_alerts_notify(t *testing.T) {
+	assert.Nil(t, newAlerts(&ctrld.ServiceConfig{}, nil))
+	var nilAlerts *alerts
+	nilAlerts.configReloadFailed("invalid config", errors.New("boom"))
+
+	a, ch := newTestAlerts(t, &ctrld.ServiceConfig{WebhookURLs: []string{
+		"https://example.com/ctrld",
+		"https://hooks.slack.com/services/T000/B000/XXXX",
+		"https://discord.com/api/webhooks/123/abc",
+	}})
+	a.configReloadFailed("invalid config", errors.New("boom"))
+	got := make(map[string]map[string]any)
+	for i := 0; i < 3; i++ {
+		req := <-ch
+		got[req.url] = req.body
+	}
+	assert.Equal(t, alertEventConfigReloadFailed, got["https://example.com/ctrld"]["event"])
+	assert.Equal(t, "invalid config: boom", got["https://example.com/ctrld"]["message"])
+	assert.Equal(t, "router", got["https://example.com/ctrld"]["instance"])
+	assert.Equal(t, "[ctrld@router] invalid config: boom", got["https://hooks.slack.com/services/T000/B000/XXXX"]["text"])
+	assert.Equal(t, "[ctrld@router] invalid config: boom", got["https://discord.com/api/webhooks/123/abc"]["content"])
+}
+
+func Test_alerts_events(t *testing.T) {
+	a, ch := newTestAlerts(t, &ctrld.ServiceConfig{
+		WebhookURLs:   []string{"https://example.com/ctrld"},
+		WebhookEvents: []string{alertEventClientJoined},
+	})
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	// Not subscribed.
+	a.configReloadFailed("invalid config", errors.New("boom"))
+	// Grace period after starting.
+	a.clientJoined(&ctrld.ClientInfo{IP: "192.168.1.10"})
+	now = now.Add(alertClientJoinedGracePeriod)
+	a.clientJoined(&ctrld.ClientInfo{IP: "192.168.1.11", Hostname: "laptop"})
+	req := <-ch
+	assert.Equal(t, alertEventClientJoined, req.body["event"])
+	assert.Equal(t, "new client joined: laptop (192.168.1.11)", req.body["message"])
+	select {
+	case req := <-ch:
+		t.Fatalf("unexpected webhook request: %v", req.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Start time is kept when reloading.
+	reloaded := newAlerts(&ctrld.ServiceConfig{WebhookURLs: []string{"https://example.com/ctrld"}}, a)
+	assert.Equal(t, a.started, reloaded.started)
+}
+
+func Test_alerts_upstreamStateChanged(t *testing.T) {
+	a, ch := newTestAlerts(t, &ctrld.ServiceConfig{WebhookURLs: []string{"https://example.com/ctrld"}})
+	cfg := &ctrld.Config{Upstream: map[string]*ctrld.UpstreamConfig{"0": {}, "1": {}}}
+	um := newUpstreamMonitor(cfg)
+	um.onStateChange = append(um.onStateChange, a.upstreamStateChanged(um, []string{"upstream.0", "upstream.1"}))
+
+	markDown := func(upstream string) {
+		for i := 0; i < maxFailureRequest; i++ {
+			um.increaseFailureCount(upstream)
+		}
+	}
+	markDown("upstream.0")
+	markDown("upstream.1")
+	req := <-ch
+	assert.Equal(t, alertEventUpstreamsDown, req.body["event"])
+
+	um.reset("upstream.1")
+	req = <-ch
+	assert.Equal(t, alertEventUpstreamsUp, req.body["event"])
+	assert.Equal(t, "upstream upstream.1 is up again", req.body["message"])
+
+	// Only one upstream recovered, no more events.
+	um.reset("upstream.0")
+	select {
+	case req := <-ch:
+		t.Fatalf("unexpected webhook request: %v", req.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_alerts_email(t *testing.T) {
+	a, ch := newTestAlerts(t, &ctrld.ServiceConfig{
+		WebhookURLs: []string{"https://example.com/ctrld"},
+		SMTPServer:  "smtp.example.com:587",
+		SMTPFrom:    "ctrld@example.com",
+		SMTPTo:      []string{"admin@example.com"},
+	})
+	emails := make(chan string, 10)
+	a.sendEmail = func(subject, body string) error {
+		emails <- subject
+		return nil
+	}
+	a.now = func() time.Time { return a.started.Add(alertClientJoinedGracePeriod) }
+
+	a.configReloadFailed("invalid config", errors.New("boom"))
+	assert.Equal(t, "[ctrld@router] invalid config: boom", <-emails)
+	<-ch
+
+	// New clients are only sent to webhooks by default.
+	a.clientJoined(&ctrld.ClientInfo{IP: "192.168.1.10"})
+	<-ch
+	select {
+	case subject := <-emails:
+		t.Fatalf("unexpected email: %s", subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// anomalyWindow is the duration in which NXDOMAIN responses and random looking domains are counted.
+	anomalyWindow = time.Minute
+	// anomalyFlagDuration is the duration a client stays flagged after an anomaly is detected.
+	anomalyFlagDuration = 10 * time.Minute
+	// anomalyDefaultNxdomainThreshold is the default number of NXDOMAIN responses per minute, above which a client is flagged.
+	anomalyDefaultNxdomainThreshold = 100
+	// anomalyDefaultDgaThreshold is the default number of random looking domains per minute, above which a client is flagged.
+	anomalyDefaultDgaThreshold = 50
+	// anomalyWebhookTimeout is the timeout for sending anomaly event to webhook.
+	anomalyWebhookTimeout = 5 * time.Second
+
+	anomalyReasonNxdomain = "nxdomain"
+	anomalyReasonDga      = "dga"
+)
+
+// anomalyEvent is the event sent to webhook when a client is flagged.
+type anomalyEvent struct {
+	Event    string    `json:"event"`
+	Reason   string    `json:"reason"`
+	Count    int       `json:"count"`
+	ClientIP string    `json:"client_ip"`
+	Mac      string    `json:"mac,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// anomalyClient holds the anomaly counters of a client in the current window.
+type anomalyClient struct {
+	windowStart  time.Time
+	nxdomain     int
+	dga          map[string]struct{}
+	flaggedUntil time.Time
+
+	rateStart time.Time
+	rateCount int
+}
+
+// anomalyDetector flags clients generating bursts of NXDOMAIN responses or random looking
+// domains, which are usually signs of malware using domain generation algorithm (DGA).
+type anomalyDetector struct {
+	nxdomainThreshold int
+	dgaThreshold      int
+	rateLimit         int
+	webhookURL        string
+	now               func() time.Time
+	notify            func(ev anomalyEvent)
+
+	mu        sync.Mutex
+	clients   map[string]*anomalyClient
+	lastPrune time.Time
+}
+
+// newAnomalyDetector returns the anomaly detector for given config, or nil if detection is disabled.
+func newAnomalyDetector(cfg *ctrld.Config) *anomalyDetector {
+	sc := cfg.Service
+	if !sc.AnomalyDetection {
+		return nil
+	}
+	ad := &anomalyDetector{
+		nxdomainThreshold: anomalyDefaultNxdomainThreshold,
+		dgaThreshold:      anomalyDefaultDgaThreshold,
+		webhookURL:        sc.AnomalyWebhookURL,
+		now:               time.Now,
+		clients:           make(map[string]*anomalyClient),
+	}
+	if n := sc.AnomalyNxdomainThreshold; n != nil {
+		ad.nxdomainThreshold = *n
+	}
+	if n := sc.AnomalyDgaThreshold; n != nil {
+		ad.dgaThreshold = *n
+	}
+	if n := sc.AnomalyRateLimit; n != nil {
+		ad.rateLimit = *n
+	}
+	ad.notify = ad.sendWebhook
+	return ad
+}
+
+// allow reports whether a query from given ip is allowed. Only flagged clients are rate limited,
+// and only if the rate limit is configured.
+func (ad *anomalyDetector) allow(ip string) bool {
+	if ad == nil || ad.rateLimit <= 0 {
+		return true
+	}
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	c := ad.clients[ip]
+	now := ad.now()
+	if c == nil || !now.Before(c.flaggedUntil) {
+		return true
+	}
+	if now.Sub(c.rateStart) >= time.Second {
+		c.rateStart = now
+		c.rateCount = 0
+	}
+	c.rateCount++
+	return c.rateCount <= ad.rateLimit
+}
+
+// record records the query of a client, flagging it if any threshold is exceeded.
+func (ad *anomalyDetector) record(ci *ctrld.ClientInfo, domain string, rcode int) {
+	if ad == nil || ci == nil || ci.IP == "" {
+		return
+	}
+	nxdomain := rcode == dns.RcodeNameError && ad.nxdomainThreshold > 0
+	dga := ad.dgaThreshold > 0 && isDgaDomain(domain)
+	if !nxdomain && !dga {
+		return
+	}
+
+	ad.mu.Lock()
+	now := ad.now()
+	ad.prune(now)
+	c := ad.clients[ci.IP]
+	if c == nil {
+		c = &anomalyClient{windowStart: now}
+		ad.clients[ci.IP] = c
+	}
+	if now.Sub(c.windowStart) >= anomalyWindow {
+		c.windowStart = now
+		c.nxdomain = 0
+		c.dga = nil
+	}
+	if nxdomain {
+		c.nxdomain++
+	}
+	// Only distinct domains are counted, so a client retrying the same query won't be flagged.
+	if dga && len(c.dga) <= ad.dgaThreshold {
+		if c.dga == nil {
+			c.dga = make(map[string]struct{})
+		}
+		c.dga[domain] = struct{}{}
+	}
+	var ev *anomalyEvent
+	if !now.Before(c.flaggedUntil) {
+		switch {
+		case nxdomain && c.nxdomain > ad.nxdomainThreshold:
+			ev = &anomalyEvent{Reason: anomalyReasonNxdomain, Count: c.nxdomain}
+		case dga && len(c.dga) > ad.dgaThreshold:
+			ev = &anomalyEvent{Reason: anomalyReasonDga, Count: len(c.dga)}
+		}
+		if ev != nil {
+			c.flaggedUntil = now.Add(anomalyFlagDuration)
+		}
+	}
+	ad.mu.Unlock()
+
+	if ev == nil {
+		return
+	}
+	ev.Event = "anomaly"
+	ev.ClientIP = ci.IP
+	ev.Mac = ci.Mac
+	ev.Hostname = ci.Hostname
+	ev.Time = now
+	statsAnomalyClientsFlagged.WithLabelValues(ev.Reason).Inc()
+	switch ev.Reason {
+	case anomalyReasonNxdomain:
+		mainLog.Load().Warn().Msgf("possible infected client %s (%s): %d NXDOMAIN responses in the last minute", ci.IP, ci.Hostname, ev.Count)
+	case anomalyReasonDga:
+		mainLog.Load().Warn().Msgf("possible infected client %s (%s): %d random looking domains queried in the last minute", ci.IP, ci.Hostname, ev.Count)
+	}
+	if ad.rateLimit > 0 {
+		mainLog.Load().Warn().Msgf("client %s is rate limited to %d queries per second for %s", ci.IP, ad.rateLimit, anomalyFlagDuration)
+	}
+	if ad.notify != nil {
+		go ad.notify(*ev)
+	}
+}
+
+// prune removes clients which are neither flagged nor having queries in the current window.
+// The caller must hold ad.mu.
+func (ad *anomalyDetector) prune(now time.Time) {
+	if now.Sub(ad.lastPrune) < anomalyWindow {
+		return
+	}
+	ad.lastPrune = now
+	for ip, c := range ad.clients {
+		if now.Sub(c.windowStart) >= anomalyWindow && !now.Before(c.flaggedUntil) {
+			delete(ad.clients, ip)
+		}
+	}
+}
+
+// sendWebhook posts the anomaly event to the configured webhook, if any.
+func (ad *anomalyDetector) sendWebhook(ev anomalyEvent) {
+	if ad.webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	c := http.Client{Timeout: anomalyWebhookTimeout}
+	resp, err := c.Post(ad.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not send anomaly event to webhook")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		mainLog.Load().Warn().Msgf("could not send anomaly event to webhook, status: %s", resp.Status)
+	}
+}
+
+// isDgaDomain reports whether the registered name of domain looks randomly generated, which is
+// long, has high entropy, and either has too few vowels or mixes many digits with letters.
+func isDgaDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if strings.HasSuffix(domain, ".arpa") {
+		return false
+	}
+	// Names under private suffixes, e.g: cloudfront.net, are usually generated by hosting platforms.
+	if _, icann := publicsuffix.PublicSuffix(domain); !icann {
+		return false
+	}
+	etld1, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return false
+	}
+	label, _, _ := strings.Cut(etld1, ".")
+	if len(label) < 12 || strings.HasPrefix(label, "xn--") {
+		return false
+	}
+	var vowels, digits int
+	freq := make(map[rune]int, len(label))
+	for _, c := range label {
+		switch {
+		case strings.ContainsRune("aeiou", c):
+			vowels++
+		case c >= '0' && c <= '9':
+			digits++
+		case c < 'a' || c > 'z':
+			return false
+		}
+		freq[c]++
+	}
+	var entropy float64
+	for _, n := range freq {
+		p := float64(n) / float64(len(label))
+		entropy -= p * math.Log2(p)
+	}
+	if entropy < 3.5 {
+		return false
+	}
+	return float64(vowels)/float64(len(label)) < 0.25 || (digits >= 3 && digits < len(label))
+}
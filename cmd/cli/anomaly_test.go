@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_isDgaDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		dga    bool
+	}{
+		{"google.com.", false},
+		{"stackoverflow.com", false},
+		{"www.googleapis.com", false},
+		{"d1y2z3abcdefgh.cloudfront.net", false},
+		{"1.0.168.192.in-addr.arpa", false},
+		{"xn--80ak6aa92e.com", false},
+		{"qxvbwrtzpkjmhl.com", true},
+		{"a3f9c0d2e7b1k5.net", true},
+		{"www.kjhgfdsvbnmqwrt.co.uk", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			assert.Equal(t, tc.dga, isDgaDomain(tc.domain))
+		})
+	}
+}
+
+func newTestAnomalyDetector(nxdomain, dga, rateLimit int) (*anomalyDetector, *time.Time, chan anomalyEvent) {
+	cfg := &ctrld.Config{}
+	cfg.Service.AnomalyDetection = true
+	cfg.Service.AnomalyNxdomainThreshold = &nxdomain
+	cfg.Service.AnomalyDgaThreshold = &dga
+	cfg.Service.AnomalyRateLimit = &rateLimit
+	ad := newAnomalyDetector(cfg)
+	now := time.Now()
+	ad.now = func() time.Time { return now }
+	events := make(chan anomalyEvent, 10)
+	ad.notify = func(ev anomalyEvent) { events <- ev }
+	return ad, &now, events
+}
+
+func Test_anomalyDetector_nxdomain(t *testing.T) {
+	ad, now, events := newTestAnomalyDetector(3, 0, 2)
+	ci := &ctrld.ClientInfo{IP: "192.168.1.10", Hostname: "laptop"}
+
+	for i := 0; i < 3; i++ {
+		ad.record(ci, "example.com", dns.RcodeNameError)
+		ad.record(ci, "example.com", dns.RcodeSuccess)
+	}
+	assert.True(t, ad.allow(ci.IP))
+	assert.Empty(t, events)
+
+	// Counters are reset in new window.
+	*now = now.Add(anomalyWindow)
+	for i := 0; i < 4; i++ {
+		ad.record(ci, "example.com", dns.RcodeNameError)
+	}
+	select {
+	case ev := <-events:
+		assert.Equal(t, anomalyReasonNxdomain, ev.Reason)
+		assert.Equal(t, 4, ev.Count)
+		assert.Equal(t, ci.IP, ev.ClientIP)
+	case <-time.After(time.Second):
+		t.Fatal("client was not flagged")
+	}
+
+	// Flagged client is rate limited.
+	assert.True(t, ad.allow(ci.IP))
+	assert.True(t, ad.allow(ci.IP))
+	assert.False(t, ad.allow(ci.IP))
+	assert.True(t, ad.allow("192.168.1.11"))
+	*now = now.Add(time.Second)
+	assert.True(t, ad.allow(ci.IP))
+
+	// Already flagged client is not reported again.
+	ad.record(ci, "example.com", dns.RcodeNameError)
+	assert.Empty(t, events)
+
+	*now = now.Add(anomalyFlagDuration)
+	assert.True(t, ad.allow(ci.IP))
+	assert.True(t, ad.allow(ci.IP))
+	assert.True(t, ad.allow(ci.IP))
+}
+
+func Test_anomalyDetector_dga(t *testing.T) {
+	ad, _, events := newTestAnomalyDetector(0, 2, 0)
+	ci := &ctrld.ClientInfo{IP: "192.168.1.10"}
+
+	// Same domain is only counted once.
+	for i := 0; i < 5; i++ {
+		ad.record(ci, "qxvbwrtzpkjmhl.com", dns.RcodeNameError)
+	}
+	assert.Empty(t, events)
+	for i := 0; i < 2; i++ {
+		ad.record(ci, "qxvbwrtzpkjmh"+strconv.Itoa(i)+".com", dns.RcodeSuccess)
+	}
+	select {
+	case ev := <-events:
+		assert.Equal(t, anomalyReasonDga, ev.Reason)
+		assert.Equal(t, 3, ev.Count)
+	case <-time.After(time.Second):
+		t.Fatal("client was not flagged")
+	}
+	// No rate limit configured.
+	for i := 0; i < 10; i++ {
+		assert.True(t, ad.allow(ci.IP))
+	}
+}
+
+func Test_anomalyDetector_disabled(t *testing.T) {
+	var ad *anomalyDetector
+	ad.record(&ctrld.ClientInfo{IP: "192.168.1.10"}, "example.com", dns.RcodeNameError)
+	assert.True(t, ad.allow("192.168.1.10"))
+	assert.Nil(t, newAnomalyDetector(&ctrld.Config{}))
+}
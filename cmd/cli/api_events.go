@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+const (
+	// apiEventsMinBackoff is the initial wait time before re-connecting to Control D API events channel.
+	apiEventsMinBackoff = 5 * time.Second
+	// apiEventsMaxBackoff is the maximum wait time before re-connecting to Control D API events channel.
+	apiEventsMaxBackoff = 5 * time.Minute
+)
+
+// waitConfigChange is the function used for waiting config change events, stubbed in tests.
+var waitConfigChange = controld.WaitConfigChange
+
+// watchAPIConfigChanges keeps a long-poll connection to Control D API, notifying ch whenever the
+// config changed, so changes could be re-fetched immediately, instead of waiting for the next
+// refetch iteration. It returns when p.stopCh is closed, or the API does not support events.
+func (p *prog) watchAPIConfigChanges(ch chan<- struct{}) {
+	logger := mainLog.Load().With().Str("mode", "api-events").Logger()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+
+	logger.Debug().Msg("watching config change events")
+	since := time.Now().Unix()
+	backoff := apiEventsMinBackoff
+	for {
+		start := time.Now()
+		ev, err := waitConfigChange(ctx, loadCdUID(), rootCmd.Version, cdDev, since)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, controld.ErrEventsUnsupported) {
+			logger.Debug().Msg("config change events are not supported, using refetch interval only")
+			return
+		}
+		if err != nil {
+			logger.Debug().Err(err).Msgf("could not wait for config change events, retrying in %s", backoff)
+			if !wait(backoff) {
+				return
+			}
+			backoff = min(backoff*2, apiEventsMaxBackoff)
+			continue
+		}
+		backoff = apiEventsMinBackoff
+		if !ev.Changed {
+			// Prevent flooding API if it does not hold the request.
+			if time.Since(start) < time.Second && !wait(apiEventsMinBackoff) {
+				return
+			}
+			continue
+		}
+		since = max(ev.CustomLastUpdate, time.Now().Unix())
+		logger.Debug().Msg("config change event received")
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A reload is already pending.
+		}
+	}
+}
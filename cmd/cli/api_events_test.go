@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+func Test_prog_watchAPIConfigChanges(t *testing.T) {
+	t.Cleanup(func() { waitConfigChange = controld.WaitConfigChange })
+
+	calls := 0
+	waitConfigChange = func(ctx context.Context, rawUID, version string, cdDev bool, since int64) (*controld.ConfigChangeEvent, error) {
+		calls++
+		if calls == 1 {
+			return &controld.ConfigChangeEvent{Changed: true, CustomLastUpdate: since + 1}, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	p := &prog{stopCh: make(chan struct{})}
+	ch := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.watchAPIConfigChanges(ch)
+	}()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change event was not notified")
+	}
+	close(p.stopCh)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchAPIConfigChanges did not stop")
+	}
+
+	waitConfigChange = func(ctx context.Context, rawUID, version string, cdDev bool, since int64) (*controld.ConfigChangeEvent, error) {
+		return nil, controld.ErrEventsUnsupported
+	}
+	p = &prog{stopCh: make(chan struct{})}
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		p.watchAPIConfigChanges(ch)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchAPIConfigChanges did not stop when events are not supported")
+	}
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// supportsUDPBatch reports whether UDP sockets could read/write messages in batches.
+	supportsUDPBatch    = true
+	defaultUDPBatchSize = 32
+	// udpReadBufferSize is the size of buffers reading UDP queries, which is the maximum
+	// EDNS0 buffer size ctrld accepts. Larger queries are truncated, so they are dropped.
+	udpReadBufferSize = 4096
+)
+
+// batchConn is implemented by ipv4.PacketConn and ipv6.PacketConn.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// batchPacketConn is a net.PacketConn which reads/writes DNS messages
+// in batches, using recvmmsg/sendmmsg.
+//
+// Writing is asynchronous, replies are queued then flushed by a single
+// goroutine, so replies from concurrent handlers are sent in one syscall.
+type batchPacketConn struct {
+	net.PacketConn
+	bc batchConn
+
+	readMu  sync.Mutex
+	readMsg []ipv4.Message
+	readN   int
+	readIdx int
+
+	writeCh   chan ipv4.Message
+	done      chan struct{}
+	writeDone chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newBatchPacketConn returns new batchPacketConn wrapping the given net.PacketConn.
+func newBatchPacketConn(pc net.PacketConn, size int) net.PacketConn {
+	var bc batchConn = ipv4.NewPacketConn(pc)
+	if addr, ok := pc.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		bc = ipv6.NewPacketConn(pc)
+	}
+	c := &batchPacketConn{
+		PacketConn: pc,
+		bc:         bc,
+		readMsg:    make([]ipv4.Message, size),
+		writeCh:    make(chan ipv4.Message, size),
+		done:       make(chan struct{}),
+		writeDone:  make(chan struct{}),
+	}
+	for i := range c.readMsg {
+		c.readMsg[i].Buffers = [][]byte{make([]byte, udpReadBufferSize)}
+	}
+	go c.writeLoop(size)
+	return c
+}
+
+// ReadFrom implements net.PacketConn, returning messages from the last batch read
+// before reading a new batch.
+func (c *batchPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		if c.readIdx >= c.readN {
+			n, err := c.bc.ReadBatch(c.readMsg, 0)
+			if err != nil {
+				return 0, nil, err
+			}
+			c.readN, c.readIdx = n, 0
+		}
+		m := &c.readMsg[c.readIdx]
+		c.readIdx++
+		if m.Flags&unix.MSG_TRUNC != 0 {
+			continue
+		}
+		return copy(b, m.Buffers[0][:m.N]), m.Addr, nil
+	}
+}
+
+// WriteTo implements net.PacketConn, queuing the message to be sent in next batch write.
+func (c *batchPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	select {
+	case c.writeCh <- ipv4.Message{Buffers: [][]byte{buf}, Addr: addr}:
+		return len(b), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+// Close implements net.PacketConn, flushing queued messages before closing the underlying connection.
+func (c *batchPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		<-c.writeDone
+		c.closeErr = c.PacketConn.Close()
+	})
+	return c.closeErr
+}
+
+func (c *batchPacketConn) writeLoop(size int) {
+	defer close(c.writeDone)
+	msgs := make([]ipv4.Message, 0, size)
+	for {
+		closed := false
+		select {
+		case m := <-c.writeCh:
+			msgs = append(msgs[:0], m)
+		case <-c.done:
+			msgs, closed = msgs[:0], true
+		}
+	drain:
+		for len(msgs) < size {
+			select {
+			case m := <-c.writeCh:
+				msgs = append(msgs, m)
+			default:
+				break drain
+			}
+		}
+		for batch := msgs; len(batch) > 0; {
+			n, err := c.bc.WriteBatch(batch, 0)
+			if err != nil {
+				mainLog.Load().Debug().Err(err).Msg("could not write batch of DNS messages")
+				break
+			}
+			batch = batch[n:]
+		}
+		if closed {
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_batchPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	bpc := newBatchPacketConn(pc, 4)
+
+	s, errCh := runDNSServerWithPacketConn(bpc)
+	defer func() { _ = s.Shutdown() }()
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+
+	c := &dns.Client{Timeout: time.Second}
+	for i := 0; i < 8; i++ {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		answer, _, err := c.Exchange(msg, pc.LocalAddr().String())
+		require.NoError(t, err)
+		assert.Equal(t, msg.Id, answer.Id)
+	}
+}
+
+func Test_batchPacketConn_truncated(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	bpc := newBatchPacketConn(pc, 4)
+	defer bpc.Close()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Write(make([]byte, udpReadBufferSize+1))
+	require.NoError(t, err)
+	_, err = c.Write([]byte("query"))
+	require.NoError(t, err)
+
+	// The truncated message is dropped.
+	require.NoError(t, bpc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, udpReadBufferSize)
+	n, _, err := bpc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "query", string(buf[:n]))
+}
+
+func runDNSServerWithPacketConn(pc net.PacketConn) (*dns.Server, <-chan error) {
+	startedCh := make(chan struct{})
+	s := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+			answer := new(dns.Msg)
+			answer.SetReply(m)
+			_ = w.WriteMsg(answer)
+		}),
+		NotifyStartedFunc: func() { close(startedCh) },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if err := s.ActivateAndServe(); err != nil {
+			errCh <- err
+		}
+	}()
+	<-startedCh
+	return s, errCh
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package cli
+
+import "net"
+
+const (
+	// supportsUDPBatch reports whether UDP sockets could read/write messages in batches.
+	supportsUDPBatch    = false
+	defaultUDPBatchSize = 1
+)
+
+// newBatchPacketConn returns the given net.PacketConn as-is, since
+// batch reads/writes are not supported on this platform.
+func newBatchPacketConn(pc net.PacketConn, _ int) net.PacketConn {
+	return pc
+}
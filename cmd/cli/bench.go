@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	benchDefaultQPS         = 20
+	benchDefaultDuration    = 10 * time.Second
+	benchDefaultTimeout     = 5 * time.Second
+	benchDefaultConcurrency = 100
+)
+
+// benchDefaultDomains is the sample domain list used when no domain list is given.
+var benchDefaultDomains = []string{
+	"google.com", "youtube.com", "facebook.com", "wikipedia.org", "amazon.com",
+	"instagram.com", "reddit.com", "bing.com", "netflix.com", "microsoft.com",
+	"apple.com", "linkedin.com", "github.com", "cloudflare.com", "yahoo.com",
+	"twitch.tv", "zoom.us", "spotify.com", "dropbox.com", "stackoverflow.com",
+}
+
+// benchTarget is a resolver being benchmarked.
+type benchTarget struct {
+	name     string
+	resolver ctrld.Resolver
+}
+
+// benchResult is the benchmark result of a target.
+type benchResult struct {
+	Target   string
+	Sent     int
+	Errors   int
+	Timeouts int
+	Dropped  int
+	Rcodes   map[string]int
+	Min      time.Duration
+	Avg      time.Duration
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// errorRate returns the percentage of queries which did not get a valid answer.
+func (br *benchResult) errorRate() float64 {
+	total := br.Sent + br.Dropped
+	if total == 0 {
+		return 0
+	}
+	failed := br.Errors + br.Timeouts + br.Dropped + br.Rcodes[dns.RcodeToString[dns.RcodeServerFailure]] + br.Rcodes[dns.RcodeToString[dns.RcodeRefused]]
+	return float64(failed) * 100 / float64(total)
+}
+
+// benchOptions controls how targets are benchmarked.
+type benchOptions struct {
+	qps         int
+	duration    time.Duration
+	timeout     time.Duration
+	concurrency int
+	qtype       uint16
+	domains     []string
+}
+
+// readBenchDomains reads domain list from r, one domain per line. Empty lines
+// and lines starting with "#" are ignored.
+func readBenchDomains(r io.Reader) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Hosts file format, e.g: "0.0.0.0 example.com".
+		fields := strings.Fields(line)
+		domains = append(domains, fields[len(fields)-1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no domains found")
+	}
+	return domains, nil
+}
+
+// benchUpstreamTarget returns the bench target of upstream config.
+func benchUpstreamTarget(name string, uc *ctrld.UpstreamConfig) (benchTarget, error) {
+	uc.Init()
+	if uc.BootstrapIP == "" {
+		uc.SetupBootstrapIP()
+	}
+	uc.SetCertPool(rootCertPool)
+	r, err := ctrld.NewResolver(uc)
+	if err != nil {
+		return benchTarget{}, err
+	}
+	return benchTarget{name: name, resolver: r}, nil
+}
+
+// benchEndpointTarget returns the bench target of an endpoint given by user, e.g: "https://dns.controld.com/p2".
+func benchEndpointTarget(endpoint string) (benchTarget, error) {
+	e, typ := upstreamEndpointAndType(endpoint)
+	uc := &ctrld.UpstreamConfig{Name: endpoint, Endpoint: e, Type: typ}
+	return benchUpstreamTarget(endpoint, uc)
+}
+
+// benchListenerTarget returns the bench target of a ctrld listener.
+func benchListenerTarget(name string, lc *ctrld.ListenerConfig) (benchTarget, error) {
+	ip := lc.IP
+	if ip == "" || ip == "0.0.0.0" || ip == "::" {
+		ip = "127.0.0.1"
+	}
+	addr := net.JoinHostPort(ip, strconv.Itoa(lc.Port))
+	uc := &ctrld.UpstreamConfig{Name: name, Endpoint: addr, Type: ctrld.ResolverTypeLegacy}
+	return benchUpstreamTarget(name+" ("+addr+")", uc)
+}
+
+// runBench benchmarks all targets concurrently, each target receives queries at opts.qps rate.
+func runBench(ctx context.Context, targets []benchTarget, opts benchOptions) []benchResult {
+	results := make([]benchResult, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = benchOne(ctx, targets[i], opts)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// benchOne sends queries to target at opts.qps rate for opts.duration, and returns the result.
+func benchOne(ctx context.Context, target benchTarget, opts benchOptions) benchResult {
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+		sem       = make(chan struct{}, opts.concurrency)
+		ticker    = time.NewTicker(time.Second / time.Duration(opts.qps))
+	)
+	defer ticker.Stop()
+	res := benchResult{Target: target.name, Rcodes: make(map[string]int)}
+
+	for n := 0; ; n++ {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			res.setLatencies(latencies)
+			return res
+		case <-ticker.C:
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			// Too many queries in flight, the target could not keep up with the rate.
+			res.Dropped++
+			continue
+		}
+		res.Sent++
+		domain := opts.domains[n%len(opts.domains)]
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			msg := new(dns.Msg)
+			msg.SetQuestion(dns.Fqdn(domain), opts.qtype)
+			msg.RecursionDesired = true
+			qctx, qcancel := context.WithTimeout(context.Background(), opts.timeout)
+			defer qcancel()
+			start := time.Now()
+			answer, err := target.resolver.Resolve(qctx, msg)
+			elapsed := time.Since(start)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil && qctx.Err() != nil:
+				res.Timeouts++
+			case err != nil:
+				res.Errors++
+			default:
+				res.Rcodes[dns.RcodeToString[answer.Rcode]]++
+				latencies = append(latencies, elapsed)
+			}
+		}()
+	}
+}
+
+// setLatencies computes latency stats of answered queries.
+func (br *benchResult) setLatencies(latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	br.Min = latencies[0]
+	br.Max = latencies[len(latencies)-1]
+	br.Avg = total / time.Duration(len(latencies))
+	br.P50 = percentile(latencies, 50)
+	br.P95 = percentile(latencies, 95)
+	br.P99 = percentile(latencies, 99)
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_readBenchDomains(t *testing.T) {
+	domains, err := readBenchDomains(strings.NewReader("# comment\nexample.com\n\n0.0.0.0 ads.example.com\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "ads.example.com"}, domains)
+
+	_, err = readBenchDomains(strings.NewReader("# empty\n"))
+	assert.Error(t, err)
+}
+
+func Test_runBench(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		answer := new(dns.Msg)
+		answer.SetReply(m)
+		if m.Question[0].Name == "nx.example.com." {
+			answer.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(answer)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	target, err := benchListenerTarget("listener.0", &ctrld.ListenerConfig{IP: addr.IP.String(), Port: addr.Port})
+	require.NoError(t, err)
+	results := runBench(context.Background(), []benchTarget{target}, benchOptions{
+		qps:         100,
+		duration:    500 * time.Millisecond,
+		timeout:     time.Second,
+		concurrency: 10,
+		qtype:       dns.TypeA,
+		domains:     []string{"example.com", "nx.example.com"},
+	})
+	require.Len(t, results, 1)
+	r := results[0]
+	assert.Positive(t, r.Sent)
+	assert.Equal(t, r.Sent, r.Rcodes["NOERROR"]+r.Rcodes["NXDOMAIN"])
+	assert.Positive(t, r.Rcodes["NXDOMAIN"])
+	assert.Zero(t, r.errorRate())
+	assert.LessOrEqual(t, r.P50, r.P99)
+}
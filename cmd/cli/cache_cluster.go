@@ -0,0 +1,330 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+)
+
+const (
+	cachePeerPath            = "/cache"
+	cachePeerTimestampHeader = "X-Ctrld-Timestamp"
+	cachePeerSignatureHeader = "X-Ctrld-Signature"
+	// cachePeerBatchInterval is the maximum time which cache entries are queued before sending to peers.
+	cachePeerBatchInterval = time.Second
+	// cachePeerBatchSize is the maximum number of cache entries sent to peers in one request.
+	cachePeerBatchSize = 256
+	// cachePeerQueueSize is the maximum number of cache entries waiting to be sent, new ones are dropped when full.
+	cachePeerQueueSize = 4096
+	// cachePeerMaxClockSkew is the maximum difference between the request timestamp and current time.
+	cachePeerMaxClockSkew = 30 * time.Second
+	cachePeerMaxBodySize  = 4 << 20
+	cachePeerTimeout      = 2 * time.Second
+	cachePeerListenRetry  = 10
+	// cachePeerEncryptionContext is used for deriving the key encrypting entries from the shared secret,
+	// so entries are not encrypted using the signing key.
+	cachePeerEncryptionContext = "ctrld cache peer encryption"
+)
+
+// cachePeerEntry is a cache entry sent to peers.
+type cachePeerEntry struct {
+	Upstream string `json:"upstream"`
+	// TTL is the remaining time in milliseconds, so peers do not depend on synchronized clocks to expire entries.
+	TTL int64  `json:"ttl"`
+	Msg []byte `json:"msg"`
+}
+
+// clusterCache is a Cacher which shares cache entries with other ctrld instances, e.g: primary and backup
+// routers, so failover does not start with a cold cache. Peer requests are encrypted using AES-GCM, and
+// authenticated using HMAC-SHA256 of the shared secret, and their timestamp must be recent, so forged or
+// replayed entries are rejected.
+type clusterCache struct {
+	dnscache.Cacher
+	peers       []string
+	listen      string
+	secret      []byte
+	aead        cipher.AEAD
+	ttlOverride time.Duration
+	queue       chan cachePeerEntry
+	client      *http.Client
+	now         func() time.Time
+}
+
+// newClusterCache returns new clusterCache wrapping cacher, or nil if there's no cache peers configured.
+func newClusterCache(cacher dnscache.Cacher, cfg *ctrld.ServiceConfig) *clusterCache {
+	if len(cfg.CachePeers) == 0 && cfg.CachePeerListen == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.CachePeerSecret))
+	mac.Write([]byte(cachePeerEncryptionContext))
+	// The key is always 32 bytes, so creating AES-256-GCM could not fail.
+	block, _ := aes.NewCipher(mac.Sum(nil))
+	aead, _ := cipher.NewGCM(block)
+	return &clusterCache{
+		Cacher:      cacher,
+		peers:       cfg.CachePeers,
+		listen:      cfg.CachePeerListen,
+		secret:      []byte(cfg.CachePeerSecret),
+		aead:        aead,
+		ttlOverride: time.Duration(cfg.CacheTTLOverride) * time.Second,
+		queue:       make(chan cachePeerEntry, cachePeerQueueSize),
+		client:      &http.Client{Timeout: cachePeerTimeout},
+		now:         time.Now,
+	}
+}
+
+// Add adds a value to cache, and queues it for sending to peers.
+func (cc *clusterCache) Add(key dnscache.Key, value *dnscache.Value) {
+	cc.Cacher.Add(key, value)
+	if len(cc.peers) == 0 {
+		return
+	}
+	ttl := value.Expire.Sub(cc.now())
+	if ttl <= 0 {
+		return
+	}
+	select {
+	case cc.queue <- cachePeerEntry{Upstream: key.Upstream, TTL: ttl.Milliseconds(), Msg: value.Packed()}:
+	default:
+		// Peers are not keeping up, sharing cache is best effort.
+	}
+}
+
+// run starts sending queued entries to peers, and serving peer requests, until stopCh or reloadCh is closed.
+func (cc *clusterCache) run(stopCh, reloadCh chan struct{}) {
+	if cc == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		select {
+		case <-stopCh:
+		case <-reloadCh:
+		}
+	}()
+	if len(cc.peers) > 0 {
+		go cc.sendLoop(ctx)
+	}
+	if cc.listen != "" {
+		go cc.serve(ctx)
+	}
+}
+
+// sendLoop batches queued entries, sending them to peers.
+func (cc *clusterCache) sendLoop(ctx context.Context) {
+	ticker := time.NewTicker(cachePeerBatchInterval)
+	defer ticker.Stop()
+	batch := make([]cachePeerEntry, 0, cachePeerBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		cc.send(ctx, batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-cc.queue:
+			batch = append(batch, e)
+			if len(batch) == cachePeerBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send sends entries to all peers.
+func (cc *clusterCache) send(ctx context.Context, entries []cachePeerEntry) {
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	ts := strconv.FormatInt(cc.now().Unix(), 10)
+	body := cc.seal(ts, plaintext)
+	sig := cc.sign(ts, body)
+	var wg sync.WaitGroup
+	for _, peer := range cc.peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+cachePeerPath, bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set(cachePeerTimestampHeader, ts)
+			req.Header.Set(cachePeerSignatureHeader, sig)
+			resp, err := cc.client.Do(req)
+			if err != nil {
+				mainLog.Load().Debug().Err(err).Msgf("could not send cache entries to peer: %s", peer)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				mainLog.Load().Debug().Msgf("cache peer %s rejected cache entries: %s", peer, resp.Status)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// seal encrypts plaintext, returning the nonce followed by the ciphertext. The timestamp ts is authenticated too,
+// so the body could not be replayed with another timestamp.
+func (cc *clusterCache) seal(ts string, plaintext []byte) []byte {
+	nonce := make([]byte, cc.aead.NonceSize(), cc.aead.NonceSize()+len(plaintext)+cc.aead.Overhead())
+	_, _ = rand.Read(nonce)
+	return cc.aead.Seal(nonce, nonce, plaintext, []byte(ts))
+}
+
+// open decrypts body sealed with timestamp ts.
+func (cc *clusterCache) open(ts string, body []byte) ([]byte, error) {
+	n := cc.aead.NonceSize()
+	if len(body) < n {
+		return nil, errors.New("body too short")
+	}
+	return cc.aead.Open(nil, body[:n], body[n:], []byte(ts))
+}
+
+// entryTTL returns the TTL of entry received from peers, clamped to the smallest TTL of records in msg,
+// and the TTL override, so peers could not keep answers in cache longer than this instance would.
+func (cc *clusterCache) entryTTL(e cachePeerEntry, msg *dns.Msg) time.Duration {
+	ttl := time.Duration(e.TTL) * time.Millisecond
+	rrTTL, ok := minTTLFromMsg(msg)
+	switch {
+	case ok:
+		ttl = min(ttl, time.Duration(rrTTL)*time.Second)
+	case cc.ttlOverride == 0:
+		// Answers without records are not cached, unless the TTL is overridden.
+		return 0
+	}
+	if cc.ttlOverride > 0 {
+		ttl = min(ttl, cc.ttlOverride)
+	}
+	return ttl
+}
+
+// sign returns the signature of request body with timestamp ts.
+func (cc *clusterCache) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, cc.secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether the signature of request body with timestamp ts is valid, and ts is recent.
+func (cc *clusterCache) verify(ts, sig string, body []byte) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := cc.now().Sub(time.Unix(sec, 0)); skew > cachePeerMaxClockSkew || skew < -cachePeerMaxClockSkew {
+		return false
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	got, _ := hex.DecodeString(cc.sign(ts, body))
+	return hmac.Equal(got, want)
+}
+
+// ServeHTTP handles cache entries sent by peers.
+func (cc *clusterCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != cachePeerPath {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, cachePeerMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ts := r.Header.Get(cachePeerTimestampHeader)
+	if !cc.verify(ts, r.Header.Get(cachePeerSignatureHeader), body) {
+		mainLog.Load().Warn().Msgf("rejected cache entries from unauthenticated peer: %s", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	plaintext, err := cc.open(ts, body)
+	if err != nil {
+		http.Error(w, "could not decrypt body", http.StatusBadRequest)
+		return
+	}
+	var entries []cachePeerEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := cc.now()
+	for _, e := range entries {
+		msg := new(dns.Msg)
+		if msg.Unpack(e.Msg) != nil || len(msg.Question) == 0 {
+			continue
+		}
+		ttl := cc.entryTTL(e, msg)
+		if ttl <= 0 {
+			continue
+		}
+		value, err := dnscache.NewValue(msg, now.Add(ttl))
+		if err != nil {
+			continue
+		}
+		// Entries from peers are not sent again, preventing loops between peers.
+		cc.Cacher.Add(dnscache.NewKey(msg, e.Upstream), value)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serve serves peer requests until ctx is done.
+func (cc *clusterCache) serve(ctx context.Context) {
+	var ln net.Listener
+	var err error
+	// The listener of previous run may not be closed yet when reloading.
+	for i := 0; i < cachePeerListenRetry; i++ {
+		if ln, err = net.Listen("tcp", cc.listen); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		mainLog.Load().Error().Err(err).Msgf("could not listen for cache peers on: %s", cc.listen)
+		return
+	}
+	srv := &http.Server{Handler: cc, ReadHeaderTimeout: cachePeerTimeout}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	mainLog.Load().Debug().Msgf("listening for cache peers on: %s", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		mainLog.Load().Error().Err(err).Msg("cache peers server failed")
+	}
+}
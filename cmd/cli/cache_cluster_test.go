@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+)
+
+func newTestClusterCache(t *testing.T, peers []string, secret string) *clusterCache {
+	t.Helper()
+	cacher, err := dnscache.NewLRUCache(64, 0, "")
+	require.NoError(t, err)
+	return newClusterCache(cacher, &ctrld.ServiceConfig{CachePeers: peers, CachePeerListen: "127.0.0.1:0", CachePeerSecret: secret})
+}
+
+func Test_clusterCache(t *testing.T) {
+	cacher, _ := dnscache.NewLRUCache(64, 0, "")
+	assert.Nil(t, newClusterCache(cacher, &ctrld.ServiceConfig{}))
+
+	backup := newTestClusterCache(t, nil, "secret")
+	ts := httptest.NewServer(backup)
+	defer ts.Close()
+	primary := newTestClusterCache(t, []string{strings.TrimPrefix(ts.URL, "http://")}, "secret")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	primary.run(stopCh, make(chan struct{}))
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("Example.com.", dns.TypeA)
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	answer.Answer = append(answer.Answer, &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}})
+	key := dnscache.NewKey(msg, "upstream.0")
+	primary.Add(key, newCacheValue(t, answer, time.Now().Add(time.Minute)))
+	require.NotNil(t, primary.Get(key))
+
+	var got *dnscache.Value
+	for i := 0; i < 50 && got == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+		got = backup.Get(key)
+	}
+	require.NotNil(t, got, "cache entry was not shared with peer")
+	gotMsg, err := got.Msg()
+	require.NoError(t, err)
+	assert.Len(t, gotMsg.Answer, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), got.Expire, 5*time.Second)
+}
+
+func Test_clusterCache_ServeHTTP(t *testing.T) {
+	cc := newTestClusterCache(t, nil, "secret")
+	now := time.Now()
+	cc.now = func() time.Time { return now }
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := cc.seal(ts, []byte(`[]`))
+	post := func(ts, sig string) int {
+		req := httptest.NewRequest(http.MethodPost, cachePeerPath, bytes.NewReader(body))
+		req.Header.Set(cachePeerTimestampHeader, ts)
+		req.Header.Set(cachePeerSignatureHeader, sig)
+		w := httptest.NewRecorder()
+		cc.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, post(ts, cc.sign(ts, body)))
+
+	other := newTestClusterCache(t, nil, "other secret")
+	assert.Equal(t, http.StatusUnauthorized, post(ts, other.sign(ts, body)))
+	assert.Equal(t, http.StatusUnauthorized, post(ts, "not hex"))
+
+	stale := strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)
+	assert.Equal(t, http.StatusUnauthorized, post(stale, cc.sign(stale, body)))
+
+	// The body is sealed with its timestamp, so it could not be re-signed with another one.
+	next := strconv.FormatInt(now.Add(time.Second).Unix(), 10)
+	assert.Equal(t, http.StatusBadRequest, post(next, cc.sign(next, body)))
+	// Plaintext bodies are rejected.
+	body = []byte(`[]`)
+	assert.Equal(t, http.StatusBadRequest, post(ts, cc.sign(ts, body)))
+
+	req := httptest.NewRequest(http.MethodGet, cachePeerPath, nil)
+	w := httptest.NewRecorder()
+	cc.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_clusterCache_seal(t *testing.T) {
+	cc := newTestClusterCache(t, nil, "secret")
+	plaintext := []byte(`[{"upstream":"upstream.0","msg":"example.com"}]`)
+	body := cc.seal("1", plaintext)
+	assert.NotContains(t, string(body), "example.com")
+	got, err := cc.open("1", body)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	_, err = cc.open("2", body)
+	assert.Error(t, err)
+	_, err = newTestClusterCache(t, nil, "other secret").open("1", body)
+	assert.Error(t, err)
+	_, err = cc.open("1", nil)
+	assert.Error(t, err)
+}
+
+func Test_clusterCache_entryTTL(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	answer.SetEdns0(1232, false)
+	noRecords := answer.Copy()
+	answer.Answer = append(answer.Answer,
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}},
+		&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}},
+	)
+
+	tests := []struct {
+		name        string
+		ttlOverride int
+		ttl         time.Duration
+		msg         *dns.Msg
+		want        time.Duration
+	}{
+		{"peer ttl", 0, 30 * time.Second, answer, 30 * time.Second},
+		{"clamped to smallest record ttl", 0, time.Hour, answer, time.Minute},
+		{"clamped to ttl override", 10, time.Hour, answer, 10 * time.Second},
+		{"record ttl less than ttl override", 120, time.Hour, answer, time.Minute},
+		{"no records", 0, time.Hour, noRecords, 0},
+		{"no records with ttl override", 10, time.Hour, noRecords, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cacher, err := dnscache.NewLRUCache(64, 0, "")
+			require.NoError(t, err)
+			cc := newClusterCache(cacher, &ctrld.ServiceConfig{CachePeerListen: "127.0.0.1:0", CachePeerSecret: "secret", CacheTTLOverride: tc.ttlOverride})
+			e := cachePeerEntry{Upstream: "upstream.0", TTL: tc.ttl.Milliseconds()}
+			assert.Equal(t, tc.want, cc.entryTTL(e, tc.msg))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var errCacheDisabled = errors.New("cache is not enabled")
+
+// cacheFlushRequest represents request for flushing the DNS cache of running ctrld.
+type cacheFlushRequest struct {
+	// Domains are the domains whose cached answers are removed, all cached answers are removed if empty.
+	Domains []string `json:"domains,omitempty"`
+}
+
+// flushCache removes cached answers of domains, or all cached answers if domains is empty.
+func (p *prog) flushCache(domains []string) error {
+	if p.cache == nil {
+		return errCacheDisabled
+	}
+	if len(domains) == 0 {
+		p.cache.Purge()
+		return nil
+	}
+	for _, domain := range domains {
+		p.cache.Remove(domain)
+	}
+	return nil
+}
+
+// cachedDomains returns the sorted list of domains which have cached answers.
+func (p *prog) cachedDomains() []string {
+	if p.cache == nil {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	domains := make([]string, 0)
+	for _, key := range p.cache.Keys() {
+		domain := strings.TrimSuffix(key.Name, ".")
+		if _, ok := seen[domain]; ok || domain == "" {
+			continue
+		}
+		seen[domain] = struct{}{}
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+)
+
+func newCacheValue(t *testing.T, msg *dns.Msg, expire time.Time) *dnscache.Value {
+	t.Helper()
+	v, err := dnscache.NewValue(msg, expire)
+	require.NoError(t, err)
+	return v
+}
+
+func Test_prog_flushCache(t *testing.T) {
+	p := &prog{}
+	assert.ErrorIs(t, p.flushCache(nil), errCacheDisabled)
+	assert.Empty(t, p.cachedDomains())
+
+	cache, err := dnscache.NewLRUCache(16, 0, "")
+	require.NoError(t, err)
+	p.cache = cache
+	add := func(name string, qtype uint16, upstream string) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+		cache.Add(dnscache.NewKey(msg, upstream), newCacheValue(t, msg, time.Now().Add(time.Minute)))
+	}
+	add("example.com.", dns.TypeA, "upstream.0")
+	add("Example.com.", dns.TypeAAAA, "upstream.1")
+	add("www.example.com.", dns.TypeA, "upstream.0")
+	add("example.org.", dns.TypeA, "upstream.0")
+	assert.Equal(t, []string{"example.com", "example.org", "www.example.com"}, p.cachedDomains())
+
+	require.NoError(t, p.flushCache([]string{"EXAMPLE.com", "example.org."}))
+	assert.Equal(t, []string{"www.example.com"}, p.cachedDomains())
+
+	require.NoError(t, p.flushCache(nil))
+	assert.Empty(t, p.cachedDomains())
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+// cdConfigCacheFileName is the file caching the resolver configs fetched from Control D API,
+// which are used when the API is unreachable.
+const cdConfigCacheFileName = "cd_config_cache.json"
+
+// cdConfigCacheRetryInterval is the interval for re-fetching resolver config while the cached config is in use.
+const cdConfigCacheRetryInterval = time.Minute
+
+// cdConfigFromCache reports whether the current resolver config was loaded from cache.
+var cdConfigFromCache atomic.Bool
+
+// cdConfigCache represents the cached resolver config of a Control D resolver uid.
+type cdConfigCache struct {
+	FetchedAt time.Time                `json:"fetched_at"`
+	Config    *controld.ResolverConfig `json:"config"`
+}
+
+// cdConfigCacheKey returns the key of cached resolver config of given uid. The uid is hashed,
+// so it does not appear in plaintext in the cache file.
+func cdConfigCacheKey(uid string) string {
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceCdUID returns a copy of resolver config rc with from replaced by to, or rc itself on error.
+func replaceCdUID(rc *controld.ResolverConfig, from, to string) *controld.ResolverConfig {
+	buf, err := json.Marshal(rc)
+	if err != nil {
+		return rc
+	}
+	replaced := &controld.ResolverConfig{}
+	if err := json.Unmarshal(bytes.ReplaceAll(buf, []byte(from), []byte(to)), replaced); err != nil {
+		return rc
+	}
+	return replaced
+}
+
+// readCdConfigCache returns all cached resolver configs, keyed by cdConfigCacheKey of resolver uid.
+func readCdConfigCache() map[string]*cdConfigCache {
+	buf, err := os.ReadFile(absHomeDir(cdConfigCacheFileName))
+	if err != nil {
+		return nil
+	}
+	var m map[string]*cdConfigCache
+	if err := json.Unmarshal(buf, &m); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not parse cached resolver config")
+		return nil
+	}
+	return m
+}
+
+// loadCachedResolverConfig returns the cached resolver config for given uid, or nil if none.
+func loadCachedResolverConfig(uid string) *controld.ResolverConfig {
+	c := readCdConfigCache()[cdConfigCacheKey(uid)]
+	if c == nil || c.Config == nil {
+		return nil
+	}
+	mainLog.Load().Debug().Msgf("found cached resolver config, fetched at: %s", c.FetchedAt.Format(time.RFC3339))
+	return replaceCdUID(c.Config, cdUIDPlaceholder, uid)
+}
+
+// saveCachedResolverConfig caches the resolver config of given uid to disk. Cached configs
+// of uids other than the given one and the ones of Control D profiles are discarded.
+// If the uid is kept in the secret store, it's replaced with a placeholder in the cached config.
+func saveCachedResolverConfig(uid string, rc *controld.ResolverConfig) {
+	m := readCdConfigCache()
+	key := cdConfigCacheKey(uid)
+	keep := map[string]bool{key: true}
+	for _, profileUID := range cdProfiles {
+		keep[cdConfigCacheKey(profileUID)] = true
+	}
+	for k := range m {
+		if !keep[k] {
+			delete(m, k)
+		}
+	}
+	if m == nil {
+		m = make(map[string]*cdConfigCache)
+	}
+	if isCdSecretUID(uid) {
+		rc = replaceCdUID(rc, uid, cdUIDPlaceholder)
+	}
+	m[key] = &cdConfigCache{FetchedAt: time.Now(), Config: rc}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not marshal resolver config")
+		return
+	}
+	// The custom config and deactivation pin must not be readable by other users.
+	if err := os.WriteFile(absHomeDir(cdConfigCacheFileName), buf, 0600); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not cache resolver config")
+	}
+}
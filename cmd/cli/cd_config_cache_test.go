@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+func Test_cachedResolverConfig(t *testing.T) {
+	oldHomedir := homedir
+	t.Cleanup(func() { homedir = oldHomedir })
+	homedir = t.TempDir()
+
+	if rc := loadCachedResolverConfig("uid"); rc != nil {
+		t.Fatalf("unexpected cached config: %+v", rc)
+	}
+	saveCachedResolverConfig("uid", &controld.ResolverConfig{DOH: "https://freedns.controld.com/uid", UID: "uid"})
+	rc := loadCachedResolverConfig("uid")
+	if rc == nil || rc.DOH != "https://freedns.controld.com/uid" {
+		t.Fatalf("unexpected cached config: %+v", rc)
+	}
+	// Cached config of other uid must not be used.
+	if rc := loadCachedResolverConfig("other-uid"); rc != nil {
+		t.Fatalf("unexpected cached config for other uid: %+v", rc)
+	}
+}
+
+func Test_cachedResolverConfig_secretUID(t *testing.T) {
+	oldHomedir := homedir
+	t.Cleanup(func() {
+		homedir = oldHomedir
+		cdSecretUIDs = nil
+	})
+	homedir = t.TempDir()
+	cdSecretUIDs = []string{"secretuid"}
+
+	saveCachedResolverConfig("secretuid", &controld.ResolverConfig{DOH: "https://freedns.controld.com/secretuid", UID: "secretuid"})
+	buf, err := os.ReadFile(absHomeDir(cdConfigCacheFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The uid kept in the secret store must not appear in the cache file, neither as key, nor in the config.
+	if strings.Contains(string(buf), "secretuid") {
+		t.Fatalf("resolver uid is written to cache file: %s", buf)
+	}
+	rc := loadCachedResolverConfig("secretuid")
+	if rc == nil || rc.DOH != "https://freedns.controld.com/secretuid" || rc.UID != "secretuid" {
+		t.Fatalf("unexpected cached config: %+v", rc)
+	}
+}
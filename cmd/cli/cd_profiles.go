@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+// cdActiveProfileFileName is the file storing name of the active Control D profile,
+// so the switched profile is still in effect after ctrld restarts.
+const cdActiveProfileFileName = "cd_profile"
+
+var (
+	// cdProfiles maps Control D profile name to its resolver uid.
+	cdProfiles map[string]string
+	// cdUIDMu guards cdUID, which could be changed at runtime by switching profile.
+	cdUIDMu sync.RWMutex
+)
+
+var errCdProfileNotFound = errors.New("profile not found")
+
+// cdProfile represents a Control D profile.
+type cdProfile struct {
+	Name   string `json:"name"`
+	UID    string `json:"uid"`
+	Active bool   `json:"active"`
+}
+
+// cdSwitchRequest represents request for switching Control D profile.
+type cdSwitchRequest struct {
+	Profile string `json:"profile"`
+}
+
+// loadCdUID returns the current Control D resolver uid.
+func loadCdUID() string {
+	cdUIDMu.RLock()
+	defer cdUIDMu.RUnlock()
+	return cdUID
+}
+
+// storeCdUID sets the current Control D resolver uid.
+func storeCdUID(uid string) {
+	cdUIDMu.Lock()
+	defer cdUIDMu.Unlock()
+	cdUID = uid
+}
+
+// cdProfileList returns list of configured Control D profiles, sorted by name.
+func cdProfileList() []cdProfile {
+	uid := loadCdUID()
+	profiles := make([]cdProfile, 0, len(cdProfiles))
+	for name, profileUID := range cdProfiles {
+		profiles = append(profiles, cdProfile{Name: name, UID: profileUID, Active: profileUID == uid})
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// activeCdProfileUID returns resolver uid of the profile which was switched to, if any.
+func activeCdProfileUID() string {
+	buf, err := os.ReadFile(absHomeDir(cdActiveProfileFileName))
+	if err != nil {
+		return ""
+	}
+	return cdProfiles[strings.TrimSpace(string(buf))]
+}
+
+// saveActiveCdProfile persists name of the active Control D profile.
+func saveActiveCdProfile(name string) error {
+	return os.WriteFile(absHomeDir(cdActiveProfileFileName), []byte(name), 0600)
+}
+
+// switchCdProfile switches ctrld to use the resolver uid of the given profile, then
+// reloads ctrld with the resolver config of new profile.
+func (p *prog) switchCdProfile(name string) error {
+	uid, ok := cdProfiles[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errCdProfileNotFound, name)
+	}
+	if err := p.switchCdUID(uid, name); err != nil {
+		return err
+	}
+	return saveActiveCdProfile(name)
+}
+
+// switchCdUID switches ctrld to use the given resolver uid, then reloads ctrld with its
+// resolver config. The resolver config is fetched from API, or from cache if the API is
+// unreachable. The name is the profile name of uid, used for logging.
+func (p *prog) switchCdUID(uid, name string) error {
+	oldUID := loadCdUID()
+	if oldUID == "" {
+		return errors.New("ctrld is not running in cd mode")
+	}
+	if uid == oldUID {
+		return nil
+	}
+	if _, err := controld.FetchResolverConfig(uid, rootCmd.Version, cdDev); err != nil {
+		if loadCachedResolverConfig(uid) == nil {
+			return fmt.Errorf("could not fetch resolver config for profile %s: %w", name, err)
+		}
+		mainLog.Load().Warn().Err(err).Msgf("could not fetch resolver config for profile %s, using cached config", name)
+	}
+	mainLog.Load().Notice().Msgf("switching to Control D profile %q", name)
+	storeCdUID(uid)
+	if err := p.sendReloadSignal(); err != nil {
+		storeCdUID(oldUID)
+		return err
+	}
+	select {
+	case <-p.reloadDoneCh:
+	case <-time.After(10 * time.Second):
+		storeCdUID(oldUID)
+		return errors.New("timeout waiting for ctrld reload")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"testing"
+)
+
+func Test_cdProfiles(t *testing.T) {
+	oldHomedir, oldProfiles, oldUID := homedir, cdProfiles, loadCdUID()
+	t.Cleanup(func() {
+		homedir, cdProfiles = oldHomedir, oldProfiles
+		storeCdUID(oldUID)
+	})
+	homedir = t.TempDir()
+	cdProfiles = map[string]string{"work": "uid-work", "gaming": "uid-gaming"}
+	storeCdUID("uid-work")
+
+	profiles := cdProfileList()
+	if len(profiles) != 2 {
+		t.Fatalf("unexpected number of profiles: %d", len(profiles))
+	}
+	if p := profiles[0]; p.Name != "gaming" || p.Active {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+	if p := profiles[1]; p.Name != "work" || !p.Active {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+
+	if uid := activeCdProfileUID(); uid != "" {
+		t.Errorf("unexpected active profile uid: %s", uid)
+	}
+	if err := saveActiveCdProfile("gaming"); err != nil {
+		t.Fatal(err)
+	}
+	if uid := activeCdProfileUID(); uid != "uid-gaming" {
+		t.Errorf("unexpected active profile uid, want: uid-gaming, got: %s", uid)
+	}
+	// Removed profile must not be used.
+	delete(cdProfiles, "gaming")
+	if uid := activeCdProfileUID(); uid != "" {
+		t.Errorf("unexpected active profile uid: %s", uid)
+	}
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cdScheduleCheckInterval is the interval for checking which Control D profile is scheduled.
+const cdScheduleCheckInterval = time.Minute
+
+var (
+	// cdScheduleFlags contains raw values of --cd-schedule flags.
+	cdScheduleFlags []string
+	// cdDefaultUID is the resolver uid used when no schedule is active.
+	cdDefaultUID string
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// cdSchedule represents a time window in which a Control D profile is used.
+type cdSchedule struct {
+	profile string
+	days    [7]bool
+	start   int // minutes since midnight.
+	end     int // minutes since midnight, end < start means the window spans midnight.
+}
+
+// parseCdSchedule parses schedule in format "<profile>=<days> <HH:MM>-<HH:MM>", where days is
+// "*" for every day, or comma separated list of week days or ranges, e.g. "mon-fri", "sat,sun".
+func parseCdSchedule(s string) (*cdSchedule, error) {
+	profile, spec, ok := strings.Cut(s, "=")
+	profile = strings.TrimSpace(profile)
+	if !ok || profile == "" {
+		return nil, fmt.Errorf("invalid schedule %q: missing profile", s)
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid schedule %q: want format <profile>=<days> <HH:MM>-<HH:MM>", s)
+	}
+	sched := &cdSchedule{profile: profile}
+	if err := sched.parseDays(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", s, err)
+	}
+	startStr, endStr, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule %q: invalid time range %q", s, fields[1])
+	}
+	var err error
+	if sched.start, err = parseClock(startStr); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", s, err)
+	}
+	if sched.end, err = parseClock(endStr); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", s, err)
+	}
+	if sched.start == sched.end {
+		return nil, fmt.Errorf("invalid schedule %q: empty time range", s)
+	}
+	return sched, nil
+}
+
+func (cs *cdSchedule) parseDays(s string) error {
+	if s == "*" {
+		for i := range cs.days {
+			cs.days[i] = true
+		}
+		return nil
+	}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		fromDay, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("invalid day %q", from)
+		}
+		if !isRange {
+			cs.days[fromDay] = true
+			continue
+		}
+		toDay, ok := weekdays[to]
+		if !ok {
+			return fmt.Errorf("invalid day %q", to)
+		}
+		for d := fromDay; ; d = (d + 1) % 7 {
+			cs.days[d] = true
+			if d == toDay {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses time in "HH:MM" format, returning minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether t is in the schedule time window.
+func (cs *cdSchedule) active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	wd := t.Weekday()
+	if cs.start < cs.end {
+		return cs.days[wd] && m >= cs.start && m < cs.end
+	}
+	// The window spans midnight, so the part after midnight belongs to the previous day.
+	prev := (wd + 6) % 7
+	return (cs.days[wd] && m >= cs.start) || (cs.days[prev] && m < cs.end)
+}
+
+// parseCdSchedules parses all schedules, checking that their profiles are defined.
+func parseCdSchedules(raw []string, profiles map[string]string) ([]*cdSchedule, error) {
+	schedules := make([]*cdSchedule, 0, len(raw))
+	for _, s := range raw {
+		sched, err := parseCdSchedule(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := profiles[sched.profile]; !ok {
+			return nil, fmt.Errorf("invalid schedule %q: %w: %s", s, errCdProfileNotFound, sched.profile)
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, nil
+}
+
+// scheduledCdProfile returns the profile of the first schedule active at t, or empty string if none.
+func scheduledCdProfile(schedules []*cdSchedule, t time.Time) string {
+	for _, sched := range schedules {
+		if sched.active(t) {
+			return sched.profile
+		}
+	}
+	return ""
+}
+
+// cdScheduleLoop switches Control D profile following the schedules. The switch only happens
+// when the scheduled profile changes, so manual switching is kept until the next schedule change.
+func (p *prog) cdScheduleLoop(schedules []*cdSchedule) {
+	logger := mainLog.Load().With().Str("mode", "cd-schedule").Logger()
+	logger.Debug().Msg("starting Control D profile schedule")
+	ticker := time.NewTicker(cdScheduleCheckInterval)
+	defer ticker.Stop()
+
+	// The scheduled profile was already applied at startup.
+	scheduled := scheduledCdProfile(schedules, time.Now())
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			return
+		}
+		profile := scheduledCdProfile(schedules, time.Now())
+		if profile == scheduled {
+			continue
+		}
+		uid, name := cdDefaultUID, "default"
+		if profile != "" {
+			uid, name = cdProfiles[profile], profile
+		}
+		if err := p.switchCdUID(uid, name); err != nil {
+			// Keep the old scheduled profile, so switching is retried in next check.
+			logger.Warn().Err(err).Msgf("could not switch to scheduled profile %q", name)
+			continue
+		}
+		scheduled = profile
+	}
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCdSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"every day", "work=* 09:00-17:00", false},
+		{"day range", "work=mon-fri 09:00-17:00", false},
+		{"day list", "gaming=sat,sun 10:00-23:00", false},
+		{"spans midnight", "night=* 22:00-06:00", false},
+		{"missing profile", "=* 09:00-17:00", true},
+		{"missing time", "work=mon-fri", true},
+		{"invalid day", "work=monday 09:00-17:00", true},
+		{"invalid time", "work=* 9am-5pm", true},
+		{"empty range", "work=* 09:00-09:00", true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := parseCdSchedule(tc.input)
+			assert.Equal(t, tc.wantErr, err != nil, err)
+		})
+	}
+}
+
+func Test_scheduledCdProfile(t *testing.T) {
+	profiles := map[string]string{"work": "uid-work", "night": "uid-night"}
+	schedules, err := parseCdSchedules([]string{"work=mon-fri 09:00-17:00", "night=fri 22:00-06:00"}, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-01-05 is a Friday.
+	friday := func(hour, minute int) time.Time { return time.Date(2024, 1, 5, hour, minute, 0, 0, time.Local) }
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"before work", friday(8, 59), ""},
+		{"work hours", friday(9, 0), "work"},
+		{"end of work", friday(17, 0), ""},
+		{"friday night", friday(23, 0), "night"},
+		{"saturday early morning", friday(23, 0).Add(6 * time.Hour), "night"},
+		{"saturday morning", friday(23, 0).Add(8 * time.Hour), ""},
+		{"thursday night", friday(23, 0).Add(-24 * time.Hour), ""},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, scheduledCdProfile(schedules, tc.t))
+		})
+	}
+
+	_, err = parseCdSchedules([]string{"gaming=* 10:00-11:00"}, profiles)
+	assert.ErrorIs(t, err, errCdProfileNotFound)
+}
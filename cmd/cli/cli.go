@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -26,7 +27,6 @@ import (
 	"time"
 
 	"github.com/Masterminds/semver"
-	"github.com/cuonglm/osinfo"
 	"github.com/go-playground/validator/v10"
 	"github.com/kardianos/service"
 	"github.com/miekg/dns"
@@ -44,7 +44,9 @@ import (
 	"github.com/Control-D-Inc/ctrld/internal/clientinfo"
 	"github.com/Control-D-Inc/ctrld/internal/controld"
 	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
+	"github.com/Control-D-Inc/ctrld/internal/osinfo"
 	"github.com/Control-D-Inc/ctrld/internal/router"
+	"github.com/Control-D-Inc/ctrld/internal/router/android"
 )
 
 // selfCheckInternalTestDomain is used for testing ctrld self response to clients.
@@ -126,6 +128,9 @@ func initCLI() {
 		false,
 		`do not write any log output`,
 	)
+	rootCmd.Flags().BoolVarP(&versionJSON, "json", "", false, `print build info as JSON, used with "--version"`)
+	cobra.AddTemplateFunc("versionOutput", versionOutput)
+	rootCmd.SetVersionTemplate("{{versionOutput}}")
 	rootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
 	rootCmd.CompletionOptions.HiddenDefaultCmd = true
 
@@ -148,6 +153,9 @@ func initCLI() {
 	runCmd.Flags().IntVarP(&cacheSize, "cache_size", "", 0, "Enable cache with size items")
 	runCmd.Flags().StringVarP(&cdUID, cdUidFlagName, "", "", "Control D resolver uid")
 	runCmd.Flags().StringVarP(&cdOrg, cdOrgFlagName, "", "", "Control D provision token")
+	runCmd.Flags().StringToStringVarP(&cdProfiles, cdProfilesFlagName, "", nil, "Control D profiles to switch between, in format: name=uid")
+	runCmd.Flags().StringVarP(&cdAPIURL, cdAPIURLFlagName, "", "", "Control D API base URL")
+	runCmd.Flags().StringArrayVarP(&cdScheduleFlags, cdScheduleFlagName, "", nil, `Schedule for using Control D profile, in format: "name=<days> <HH:MM>-<HH:MM>"`)
 	runCmd.Flags().StringVarP(&customHostname, customHostnameFlagName, "", "", "Custom hostname passed to ControlD API")
 	runCmd.Flags().BoolVarP(&cdDev, "dev", "", false, "Use Control D dev resolver/domain")
 	_ = runCmd.Flags().MarkHidden("dev")
@@ -289,7 +297,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 					resetDnsTask(p, s, isCtrldInstalled, currentIface),
 					{func() error {
 						// Save current DNS so we can restore later.
-						withEachPhysicalInterfaces("", "", func(i *net.Interface) error {
+						withEachManagedInterfaces("", "", func(i *net.Interface) error {
 							if err := saveCurrentStaticDNS(i); !errors.Is(err, errSaveCurrentStaticDNSNotSupported) && err != nil {
 								return err
 							}
@@ -316,6 +324,17 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 				return
 			}
 
+			if _, err := parseCdSchedules(cdScheduleFlags, cdProfiles); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("invalid Control D profile schedule")
+			}
+			initCdAPIURL()
+			// Explicit --cd flag takes precedence over previously switched profile.
+			_ = os.Remove(absHomeDir(cdActiveProfileFileName))
+			if err := resolveCdSecret(); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to read resolver uid from secret store")
+			}
+			removeSecretStoreFlagFromArgs(sc)
+			removePairFlagFromArgs(sc)
 			if cdUID != "" {
 				doValidateCdRemoteConfig(cdUID)
 			} else if uid := cdUIDFromProvToken(); uid != "" {
@@ -324,10 +343,27 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 				removeOrgFlagsFromArgs(sc)
 				// Pass --cd flag to "ctrld run" command, so the provision token takes no effect.
 				sc.Arguments = append(sc.Arguments, "--cd="+cdUID)
+			} else if uid := cdUIDFromPairing(ctx); uid != "" {
+				cdUID = uid
+				mainLog.Load().Debug().Msg("using uid from device pairing")
+				sc.Arguments = append(sc.Arguments, "--cd="+cdUID)
 			}
 			if cdUID != "" {
 				validateCdUpstreamProtocol()
 			}
+			if cdUID != "" && useSecretStore {
+				store, err := newSecretStore()
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not use secret store")
+				}
+				if err := storeCdSecret(store, sc, cdUID); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not use secret store")
+				}
+				if err := storeCdProfilesSecret(store, sc, cdProfiles); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not use secret store")
+				}
+				mainLog.Load().Debug().Msg("stored resolver uid in secret store")
+			}
 
 			if err := p.router.ConfigureService(sc); err != nil {
 				mainLog.Load().Fatal().Err(err).Msg("failed to configure service on router")
@@ -368,7 +404,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 				resetDnsTask(p, s, isCtrldInstalled, currentIface),
 				{func() error {
 					// Save current DNS so we can restore later.
-					withEachPhysicalInterfaces("", "", func(i *net.Interface) error {
+					withEachManagedInterfaces("", "", func(i *net.Interface) error {
 						if err := saveCurrentStaticDNS(i); !errors.Is(err, errSaveCurrentStaticDNSNotSupported) && err != nil {
 							return err
 						}
@@ -377,6 +413,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 					return nil
 				}, false},
 				{s.Install, false},
+				{func() error { return setServiceRecovery(sc.Name) }, false},
 				{s.Start, true},
 				// Note that startCmd do not actually write ControlD config, but the config file was
 				// generated after s.Start, so we notice users here for consistent with nextdns mode.
@@ -442,6 +479,10 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 	startCmd.Flags().IntVarP(&cacheSize, "cache_size", "", 0, "Enable cache with size items")
 	startCmd.Flags().StringVarP(&cdUID, cdUidFlagName, "", "", "Control D resolver uid")
 	startCmd.Flags().StringVarP(&cdOrg, cdOrgFlagName, "", "", "Control D provision token")
+	startCmd.Flags().BoolVarP(&cdPair, cdPairFlagName, "", false, "Pair with Control D account by scanning QR code, instead of using resolver uid")
+	startCmd.Flags().StringToStringVarP(&cdProfiles, cdProfilesFlagName, "", nil, "Control D profiles to switch between, in format: name=uid")
+	startCmd.Flags().StringVarP(&cdAPIURL, cdAPIURLFlagName, "", "", "Control D API base URL")
+	startCmd.Flags().StringArrayVarP(&cdScheduleFlags, cdScheduleFlagName, "", nil, `Schedule for using Control D profile, in format: "name=<days> <HH:MM>-<HH:MM>"`)
 	startCmd.Flags().StringVarP(&customHostname, customHostnameFlagName, "", "", "Custom hostname passed to ControlD API")
 	startCmd.Flags().BoolVarP(&cdDev, "dev", "", false, "Use Control D dev resolver/domain")
 	_ = startCmd.Flags().MarkHidden("dev")
@@ -450,6 +491,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 	startCmd.Flags().StringVarP(&cdUpstreamProto, "proto", "", ctrld.ResolverTypeDOH, `Control D upstream type, either "doh" or "doh3"`)
 	startCmd.Flags().BoolVarP(&skipSelfChecks, "skip_self_checks", "", false, `Skip self checks after installing ctrld service`)
 	startCmd.Flags().BoolVarP(&startOnly, "start_only", "", false, "Do not install new service")
+	startCmd.Flags().BoolVarP(&useSecretStore, secretStoreFlagName, "", false, "Store Control D resolver uid in OS secret store, instead of service config")
 	_ = startCmd.Flags().MarkHidden("start_only")
 
 	routerCmd := &cobra.Command{
@@ -525,7 +567,6 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 	}
 	stopCmd.Flags().StringVarP(&iface, "iface", "", "", `Reset DNS setting for iface, "auto" means the default interface gateway`)
 	stopCmd.Flags().Int64VarP(&deactivationPin, "pin", "", defaultDeactivationPin, `Pin code for stopping ctrld`)
-	_ = stopCmd.Flags().MarkHidden("pin")
 
 	restartCmd := &cobra.Command{
 		PreRun: func(cmd *cobra.Command, args []string) {
@@ -556,6 +597,11 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 				doValidateCdRemoteConfig(cdUID)
 			}
 
+			// Verifying the pin authorizes the stop, so it is not reported as failure
+			// to the service manager, which could otherwise start ctrld on its own.
+			if err := checkDeactivationPin(s, nil); isCheckDeactivationPinErr(err) {
+				os.Exit(deactivationPinInvalidExitCode)
+			}
 			iface = runningIface(s)
 			tasks := []task{
 				{s.Stop, false},
@@ -578,6 +624,8 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 		},
 	}
 
+	restartCmd.Flags().Int64VarP(&deactivationPin, "pin", "", defaultDeactivationPin, `Pin code for restarting ctrld`)
+
 	reloadCmd := &cobra.Command{
 		PreRun: func(cmd *cobra.Command, args []string) {
 			checkHasElevatedPrivilege()
@@ -621,32 +669,63 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 			}
 		},
 	}
+	reloadCmd.Flags().Int64VarP(&deactivationPin, "pin", "", defaultDeactivationPin, `Pin code for restarting ctrld if the new config requires it`)
 	statusCmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show status of the ctrld service",
-		Args:  cobra.NoArgs,
+		Long: `Show status of the ctrld service.
+
+The exit code reports the service status, so monitoring systems could use it as a check:
+
+  0: running, and able to resolve queries
+  1: running, but degraded, there's no healthy upstream, or listeners were not bound
+  2: stopped
+  3: unknown status, e.g: the service readiness could not be read without privileges
+  4: not installed`,
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			s, err := newService(&prog{}, svcConfig)
 			if err != nil {
 				mainLog.Load().Error().Msg(err.Error())
-				return
+				os.Exit(statusUnknownExitCode)
 			}
 			status, err := s.Status()
-			if err != nil {
-				mainLog.Load().Error().Msg(err.Error())
-				os.Exit(1)
+			var hs *healthStatus
+			var hsErr error
+			if err == nil && status == service.StatusRunning {
+				hs, hsErr = serviceReadiness()
 			}
-			switch status {
-			case service.StatusUnknown:
-				mainLog.Load().Notice().Msg("Unknown status")
-				os.Exit(2)
-			case service.StatusRunning:
+			code := statusExitCode(status, err, hs, hsErr)
+			switch code {
+			case statusRunningExitCode, statusDegradedExitCode:
 				mainLog.Load().Notice().Msg("Service is running")
-				os.Exit(0)
-			case service.StatusStopped:
+				switch {
+				case code == statusRunningExitCode:
+				case !hs.ListenersBound:
+					mainLog.Load().Warn().Msg("Service is degraded: listeners were not bound")
+				default:
+					mainLog.Load().Warn().Msg("Service is degraded: no healthy upstreams")
+				}
+				printUpstreamLatency()
+				printUpstreamCerts()
+				printResourceStats()
+				printDnsEvents()
+			case statusStoppedExitCode:
 				mainLog.Load().Notice().Msg("Service is stopped")
-				os.Exit(1)
+			case statusNotInstalledExitCode:
+				mainLog.Load().Notice().Msg("Service is not installed")
+			default:
+				switch {
+				case err != nil:
+					mainLog.Load().Error().Msg(err.Error())
+				case hsErr != nil:
+					mainLog.Load().Notice().Msg("Service is running")
+					mainLog.Load().Error().Err(hsErr).Msg("Unknown status: could not get service readiness")
+				default:
+					mainLog.Load().Notice().Msg("Unknown status")
+				}
 			}
+			os.Exit(code)
 		},
 	}
 	if runtime.GOOS == "darwin" {
@@ -698,6 +777,7 @@ NOTE: Uninstalling will set DNS to values provided by DHCP.`,
 				// Socket files.
 				if dir, _ := socketDir(); dir != "" {
 					files = append(files, filepath.Join(dir, ctrldControlUnixSock))
+					files = append(files, controlTokenFile(filepath.Join(dir, ctrldControlUnixSock)))
 					files = append(files, filepath.Join(dir, ctrldLogUnixSock))
 				}
 				// Static DNS settings files.
@@ -708,6 +788,13 @@ NOTE: Uninstalling will set DNS to values provided by DHCP.`,
 					}
 					return nil
 				})
+				// Control D state files.
+				for _, name := range []string{cdConfigCacheFileName, cdActiveProfileFileName} {
+					file := absHomeDir(name)
+					if _, err := os.Stat(file); err == nil {
+						files = append(files, file)
+					}
+				}
 				// Windows forwarders file.
 				if windowsHasLocalDnsServerRunning() {
 					files = append(files, absHomeDir(windowsForwardersFilename))
@@ -747,7 +834,6 @@ NOTE: Uninstalling will set DNS to values provided by DHCP.`,
 	}
 	uninstallCmd.Flags().StringVarP(&iface, "iface", "", "", `Reset DNS setting for iface, use "auto" for the default gateway interface`)
 	uninstallCmd.Flags().Int64VarP(&deactivationPin, "pin", "", defaultDeactivationPin, `Pin code for uninstalling ctrld`)
-	_ = uninstallCmd.Flags().MarkHidden("pin")
 	uninstallCmd.Flags().BoolVarP(&cleanup, "cleanup", "", false, `Removing ctrld binary and config files`)
 
 	listIfacesCmd := &cobra.Command{
@@ -835,6 +921,62 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 	startCmdAlias.Flags().StringVarP(&ifaceStartStop, "iface", "", "auto", `Update DNS setting for iface, "auto" means the default interface gateway`)
 	startCmdAlias.Flags().AddFlagSet(startCmd.Flags())
 	rootCmd.AddCommand(startCmdAlias)
+	var provisionOrg, provisionHostnameTemplate string
+	provisionCmd := &cobra.Command{
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Use:   "provision",
+		Short: "Register device with Control D organization and start service",
+		Long: `Register device with Control D organization and start service
+
+The device is registered using organization provision token, the resolver uid
+obtained from Control D API is passed to the service using "--cd" flag (or kept
+in the secret store with "--secret-store"), then the service is started. This is
+suitable for provisioning many devices using MDM/Ansible.
+
+The hostname template supports these placeholders:
+
+  {hostname}  OS hostname, without domain part
+  {mac}       MAC address of default interface, without separators
+  {os}        OS name
+  {arch}      CPU architecture`,
+		Example: `  ctrld provision --org <token> --hostname-template "{hostname}-{mac}"`,
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if provisionOrg == "" {
+				mainLog.Load().Fatal().Msgf(`flag "--%s" is required`, provisionOrgFlagName)
+			}
+			if cdUID != "" {
+				mainLog.Load().Fatal().Msgf(`flag "--%s" could not be used with "--%s"`, cdUidFlagName, provisionOrgFlagName)
+			}
+			cdOrg = provisionOrg
+			if provisionHostnameTemplate != "" {
+				customHostname = expandHostnameTemplate(provisionHostnameTemplate)
+				if !validHostname(customHostname) {
+					mainLog.Load().Fatal().Msgf("invalid hostname %q generated from template: %q", customHostname, provisionHostnameTemplate)
+				}
+				mainLog.Load().Notice().Msgf("Provisioning device with hostname: %s", customHostname)
+			}
+			// Re-write arguments as "ctrld start" command was invoked with organization flags.
+			startArgs := removeFlagsFromArgs(os.Args[2:], provisionOrgFlagName, provisionHostnameTemplateFlagName)
+			startArgs = append(startArgs, "--"+cdOrgFlagName+"="+cdOrg)
+			if customHostname != "" {
+				startArgs = append(startArgs, "--"+customHostnameFlagName+"="+customHostname)
+			}
+			if !cmd.Flags().Changed("iface") {
+				startArgs = append(startArgs, "--iface="+ifaceStartStop)
+			}
+			os.Args = append([]string{os.Args[0], "start"}, startArgs...)
+			iface = ifaceStartStop
+			startCmd.Run(cmd, args)
+		},
+	}
+	provisionCmd.Flags().StringVarP(&provisionOrg, provisionOrgFlagName, "", "", "Control D organization provision token")
+	provisionCmd.Flags().StringVarP(&provisionHostnameTemplate, provisionHostnameTemplateFlagName, "", "", "Template for generating hostname of the device")
+	provisionCmd.Flags().StringVarP(&ifaceStartStop, "iface", "", "auto", `Update DNS setting for iface, "auto" means the default interface gateway`)
+	provisionCmd.Flags().AddFlagSet(startCmd.Flags())
+	rootCmd.AddCommand(provisionCmd)
 	stopCmdAlias := &cobra.Command{
 		PreRun: func(cmd *cobra.Command, args []string) {
 			checkHasElevatedPrivilege()
@@ -863,6 +1005,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 			restartCmd.Run(cmd, args)
 		},
 	}
+	restartCmdAlias.Flags().AddFlagSet(restartCmd.Flags())
 	rootCmd.AddCommand(restartCmdAlias)
 
 	reloadCmdAlias := &cobra.Command{
@@ -875,6 +1018,7 @@ NOTE: running "ctrld start" without any arguments will start already installed c
 			reloadCmd.Run(cmd, args)
 		},
 	}
+	reloadCmdAlias.Flags().AddFlagSet(reloadCmd.Flags())
 	rootCmd.AddCommand(reloadCmdAlias)
 
 	statusCmdAlias := &cobra.Command{
@@ -898,17 +1042,861 @@ NOTE: Uninstalling will set DNS to values provided by DHCP.`,
 			if !cmd.Flags().Changed("iface") {
 				os.Args = append(os.Args, "--iface="+ifaceStartStop)
 			}
-			iface = ifaceStartStop
-			uninstallCmd.Run(cmd, args)
+			iface = ifaceStartStop
+			uninstallCmd.Run(cmd, args)
+		},
+	}
+	uninstallCmdAlias.Flags().StringVarP(&ifaceStartStop, "iface", "", "auto", `Reset DNS setting for iface, "auto" means the default interface gateway`)
+	uninstallCmdAlias.Flags().AddFlagSet(uninstallCmd.Flags())
+	rootCmd.AddCommand(uninstallCmdAlias)
+
+	listClientsCmd := &cobra.Command{
+		Use:               "list [client...]",
+		Short:             "List clients that ctrld discovered",
+		Long:              "List clients that ctrld discovered, or only the given clients, identified by IP, MAC or hostname",
+		Args:              cobra.ArbitraryArgs,
+		ValidArgsFunction: completeClients(0),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			resp, err := cc.post(listClientsPath, nil)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to get clients list")
+			}
+			defer resp.Body.Close()
+
+			var clients []*clientinfo.Client
+			if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to decode clients list result")
+			}
+			if len(args) > 0 {
+				clients = slices.DeleteFunc(clients, func(c *clientinfo.Client) bool {
+					return !slices.ContainsFunc(args, func(s string) bool { return matchClient(c, s) })
+				})
+			}
+			map2Slice := func(m map[string]struct{}) []string {
+				s := make([]string, 0, len(m))
+				for k := range m {
+					if k == "" { // skip empty source from output.
+						continue
+					}
+					s = append(s, k)
+				}
+				sort.Strings(s)
+				return s
+			}
+			// If metrics is enabled, server set this for all clients, so we can check only the first one.
+			// Ideally, we may have a field in response to indicate that query count should be shown, but
+			// it would break earlier version of ctrld, which only look list of clients in response.
+			withQueryCount := len(clients) > 0 && clients[0].IncludeQueryCount
+			data := make([][]string, len(clients))
+			for i, c := range clients {
+				lastSeen := "-"
+				if !c.LastSeen.IsZero() {
+					lastSeen = time.Since(c.LastSeen).Round(time.Second).String() + " ago"
+				}
+				row := []string{
+					c.IP.String(),
+					c.Hostname,
+					c.Mac,
+					c.DeviceType,
+					strings.Join(map2Slice(c.Source), ","),
+					lastSeen,
+					strconv.FormatInt(c.QueriesToday, 10),
+				}
+				if withQueryCount {
+					row = append(row, strconv.FormatInt(c.QueryCount, 10))
+				}
+				data[i] = row
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			headers := []string{"IP", "Hostname", "Mac", "Device Type", "Discovered", "Last Seen", "Queries Today"}
+			if withQueryCount {
+				headers = append(headers, "Queries")
+			}
+			table.SetHeader(headers)
+			table.SetAutoFormatHeaders(false)
+			table.AppendBulk(data)
+			table.Render()
+		},
+	}
+	clientsCmd := &cobra.Command{
+		Use:   "clients",
+		Short: "Manage clients",
+		Args:  cobra.OnlyValidArgs,
+		ValidArgs: []string{
+			listClientsCmd.Name(),
+		},
+	}
+	clientsCmd.AddCommand(listClientsCmd)
+	rootCmd.AddCommand(clientsCmd)
+
+	switchCmd := &cobra.Command{
+		Use:   "switch [profile]",
+		Short: "Switch Control D profile of the running ctrld service",
+		Long: `Switch Control D profile of the running ctrld service.
+
+Profiles are defined using --cd-profiles flag when starting ctrld in cd mode.
+Without argument, the list of profiles is printed.
+
+Profiles could also be switched automatically using --cd-schedule flags, e.g:
+
+  ctrld start --cd=<uid> --cd-profiles work=<uid>,kids=<uid> \
+    --cd-schedule "work=mon-fri 09:00-17:00" --cd-schedule "kids=* 20:00-07:00"
+
+The first matching schedule wins, the --cd uid is used when no schedule matches.
+A manual switch is kept until the scheduled profile changes.`,
+		Example:           "  ctrld switch gaming",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeCdProfiles(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			if len(args) == 0 {
+				resp, err := cc.post(cdProfilesPath, nil)
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to get profiles list")
+				}
+				defer resp.Body.Close()
+				var profiles []cdProfile
+				if err := json.NewDecoder(resp.Body).Decode(&profiles); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to decode profiles list result")
+				}
+				if len(profiles) == 0 {
+					mainLog.Load().Notice().Msg("No Control D profiles defined")
+					return
+				}
+				data := make([][]string, len(profiles))
+				for i, p := range profiles {
+					active := ""
+					if p.Active {
+						active = "*"
+					}
+					data[i] = []string{p.Name, p.UID, active}
+				}
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader([]string{"Profile", "UID", "Active"})
+				table.SetAutoFormatHeaders(false)
+				table.AppendBulk(data)
+				table.Render()
+				return
+			}
+			body, _ := json.Marshal(cdSwitchRequest{Profile: args[0]})
+			resp, err := cc.post(cdSwitchPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send switch request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to switch profile: %s", strings.TrimSpace(string(buf)))
+			}
+			mainLog.Load().Notice().Msgf("Switched to profile: %s", args[0])
+		},
+	}
+	rootCmd.AddCommand(switchCmd)
+
+	var revertLogLevelAfter time.Duration
+	logLevelCmd := &cobra.Command{
+		Use:   "level [level]",
+		Short: "Show or change log level of the running ctrld service",
+		Long: `Show or change log level of the running ctrld service.
+
+Without argument, the current log level is printed. The new log level
+takes effect immediately without restarting ctrld, and is kept until
+ctrld restarts, or the --revert-after duration elapses.`,
+		Example: "  ctrld log level debug --revert-after 30m",
+		Args:    cobra.MaximumNArgs(1),
+		ValidArgs: []string{
+			zerolog.TraceLevel.String(),
+			zerolog.DebugLevel.String(),
+			zerolog.InfoLevel.String(),
+			zerolog.NoticeLevel.String(),
+			zerolog.WarnLevel.String(),
+			zerolog.ErrorLevel.String(),
+		},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			req := logLevelRequest{}
+			if len(args) > 0 {
+				if _, err := zerolog.ParseLevel(args[0]); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("invalid log level")
+				}
+				req.Level = args[0]
+				if revertLogLevelAfter > 0 {
+					req.RevertAfter = revertLogLevelAfter.String()
+				}
+			}
+			body, err := json.Marshal(req)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create log level request")
+			}
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			resp, err := cc.post(logLevelPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send log level request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to change log level: %s", strings.TrimSpace(string(buf)))
+			}
+			var res logLevelResponse
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to decode log level result")
+			}
+			if res.RevertAt.IsZero() {
+				mainLog.Load().Notice().Msgf("Log level: %s", res.Level)
+				return
+			}
+			mainLog.Load().Notice().Msgf("Log level: %s, reverting at %s", res.Level, res.RevertAt.Local().Format(time.DateTime))
+		},
+	}
+	logLevelCmd.Flags().DurationVarP(&revertLogLevelAfter, "revert-after", "", 0, "Revert to previous log level after this duration")
+	var (
+		searchFilter queryLogFilter
+		searchSince  string
+		searchUntil  string
+	)
+	logSearchCmd := &cobra.Command{
+		Use:   "search",
+		Short: "Search the query log of the running ctrld service",
+		Long: `Search the query log of the running ctrld service.
+
+The query log must use the sqlite backend. Most recent queries matching
+all given filters are printed, newest first. Times are either a duration
+before now, e.g: 1h, or a local time, e.g: "2024-01-01 10:00".`,
+		Example: `  ctrld log search --client 192.168.1.10 --since 1h
+  ctrld log search --domain "*.example.com" --rcode NXDOMAIN`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			now := time.Now()
+			var err error
+			if searchSince != "" {
+				if searchFilter.Since, err = parseQueryLogTime(searchSince, now); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("invalid --since value")
+				}
+			}
+			if searchUntil != "" {
+				if searchFilter.Until, err = parseQueryLogTime(searchUntil, now); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("invalid --until value")
+				}
+			}
+			body, err := json.Marshal(searchFilter)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create log search request")
+			}
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			resp, err := cc.post(logSearchPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send log search request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to search query log: %s", strings.TrimSpace(string(buf)))
+			}
+			var entries []queryLogEntry
+			if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to decode log search result")
+			}
+			if len(entries) == 0 {
+				mainLog.Load().Notice().Msg("No queries found")
+				return
+			}
+			data := make([][]string, len(entries))
+			for i, e := range entries {
+				client := e.ClientIP
+				if e.Hostname != "" {
+					client += " (" + e.Hostname + ")"
+				}
+				upstream := e.Upstream
+				if e.Blocked != "" {
+					upstream = "blocked: " + e.Blocked
+				}
+				data[i] = []string{
+					e.Time.Local().Format(time.DateTime),
+					client,
+					e.Domain,
+					e.Qtype,
+					e.Rcode,
+					upstream,
+					strconv.FormatFloat(e.Duration, 'f', 2, 64) + "ms",
+				}
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Time", "Client", "Domain", "Type", "Rcode", "Upstream", "Duration"})
+			table.SetAutoFormatHeaders(false)
+			table.AppendBulk(data)
+			table.Render()
+		},
+	}
+	logSearchCmd.Flags().StringVarP(&searchFilter.Client, "client", "", "", "Client IP, MAC or hostname")
+	_ = logSearchCmd.RegisterFlagCompletionFunc("client", completeClients(0))
+	logSearchCmd.Flags().StringVarP(&searchFilter.Domain, "domain", "", "", `Domain glob pattern, e.g: "*.example.com"`)
+	logSearchCmd.Flags().StringVarP(&searchFilter.Rcode, "rcode", "", "", "Response code, e.g: NXDOMAIN")
+	logSearchCmd.Flags().StringVarP(&searchSince, "since", "", "", "Only queries after this time")
+	logSearchCmd.Flags().StringVarP(&searchUntil, "until", "", "", "Only queries before this time")
+	logSearchCmd.Flags().IntVarP(&searchFilter.Limit, "limit", "", queryLogDefaultSearchLimit, "Maximum number of queries printed")
+	var (
+		exportReq    logExportRequest
+		exportFormat string
+		exportSince  string
+		exportUntil  string
+		exportOutput string
+	)
+	logExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the query log of the running ctrld service",
+		Long: `Export the query log of the running ctrld service, for offline analysis
+in spreadsheets or data tools.
+
+Queries are read from the query log file or database, whichever backend is
+used, oldest first. Times are either a duration before now, e.g: 1h, or a
+local time, e.g: "2024-01-01 10:00".`,
+		Example: `  ctrld log export --format csv --since 24h --output queries.csv
+  ctrld log export --format parquet --since "2024-01-01" --output queries.parquet`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			switch exportFormat {
+			case logExportFormatCSV, logExportFormatParquet:
+			default:
+				mainLog.Load().Fatal().Msgf("invalid --format value: %q, must be %q or %q", exportFormat, logExportFormatCSV, logExportFormatParquet)
+			}
+			now := time.Now()
+			var err error
+			if exportSince != "" {
+				if exportReq.Since, err = parseQueryLogTime(exportSince, now); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("invalid --since value")
+				}
+			}
+			if exportUntil != "" {
+				if exportReq.Until, err = parseQueryLogTime(exportUntil, now); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("invalid --until value")
+				}
+			}
+			out := io.Writer(os.Stdout)
+			if exportOutput != "" {
+				f, err := os.Create(exportOutput)
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to create output file")
+				}
+				defer f.Close()
+				out = f
+			}
+			ew, err := newLogExportWriter(exportFormat, out)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create export writer")
+			}
+			body, err := json.Marshal(exportReq)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create log export request")
+			}
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			// Exporting the whole query log may take longer than other requests.
+			cc.c.Timeout = 0
+			resp, err := cc.post(logExportPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send log export request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to export query log: %s", strings.TrimSpace(string(buf)))
+			}
+			n := 0
+			dec := json.NewDecoder(resp.Body)
+			for {
+				var e queryLogEntry
+				if err := dec.Decode(&e); err == io.EOF {
+					break
+				} else if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to decode exported query log")
+				}
+				if err := ew.write(e); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to write exported query log")
+				}
+				n++
+			}
+			if err := ew.close(); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to write exported query log")
+			}
+			// The console log is written to stdout, which may be the export output.
+			if exportOutput != "" {
+				mainLog.Load().Notice().Msgf("Exported %d queries to %s", n, exportOutput)
+			}
+		},
+	}
+	logExportCmd.Flags().StringVarP(&exportFormat, "format", "", logExportFormatCSV, "Export format, csv or parquet")
+	logExportCmd.Flags().StringVarP(&exportSince, "since", "", "", "Only queries after this time")
+	logExportCmd.Flags().StringVarP(&exportUntil, "until", "", "", "Only queries before this time")
+	logExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Output file, default to stdout")
+	logCmd := &cobra.Command{
+		Use:   "log",
+		Short: "Manage ctrld logging",
+		Args:  cobra.OnlyValidArgs,
+		ValidArgs: []string{
+			logLevelCmd.Name(),
+			logSearchCmd.Name(),
+			logExportCmd.Name(),
+		},
+	}
+	logCmd.AddCommand(logLevelCmd)
+	logCmd.AddCommand(logSearchCmd)
+	logCmd.AddCommand(logExportCmd)
+	rootCmd.AddCommand(logCmd)
+
+	var (
+		reportReq   queryReportRequest
+		reportSince string
+	)
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Show top domains, blocked domains and clients of the running ctrld service",
+		Long: `Show top domains, blocked domains and clients of the running ctrld service.
+
+The report is computed from the query log if it uses the sqlite backend,
+or from in-memory stats of the last 24 hours otherwise. The start time is
+either a duration before now, e.g: 1h, or a local time, e.g: "2024-01-01 10:00".`,
+		Example: `  ctrld report
+  ctrld report --since 1h --top 20`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			if reportReq.Since, err = parseQueryLogTime(reportSince, time.Now()); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("invalid --since value")
+			}
+			body, err := json.Marshal(reportReq)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create report request")
+			}
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			resp, err := cc.post(reportPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send report request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to get report: %s", strings.TrimSpace(string(buf)))
+			}
+			var r queryReport
+			if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to decode report")
+			}
+			mainLog.Load().Notice().Msgf("Queries since %s (%s): %d, blocked: %d, cache hit rate: %s%%",
+				r.Since.Local().Format(time.DateTime), r.Source, r.Total, r.Blocked, strconv.FormatFloat(r.cacheHitRate(), 'f', 2, 64))
+			render := func(header string, counts []queryReportCount) {
+				data := make([][]string, len(counts))
+				for i, c := range counts {
+					name := c.Name
+					if c.Hostname != "" {
+						name += " (" + c.Hostname + ")"
+					}
+					data[i] = []string{strconv.Itoa(i + 1), name, strconv.FormatInt(c.Count, 10)}
+				}
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader([]string{"#", header, "Queries"})
+				table.SetAutoFormatHeaders(false)
+				table.AppendBulk(data)
+				table.Render()
+			}
+			render("Top domains", r.TopDomains)
+			render("Top blocked domains", r.TopBlocked)
+			render("Top clients", r.TopClients)
+		},
+	}
+	reportCmd.Flags().StringVarP(&reportSince, "since", "", "24h", "Only queries after this time")
+	reportCmd.Flags().IntVarP(&reportReq.Top, "top", "", queryReportDefaultTop, "Number of top domains and clients printed")
+	rootCmd.AddCommand(reportCmd)
+
+	cacheFlushCmd := &cobra.Command{
+		Use:   "flush [domain...]",
+		Short: "Flush DNS cache of the running ctrld service",
+		Long: `Flush DNS cache of the running ctrld service.
+
+Without argument, all cached answers are removed. Otherwise, only cached
+answers of the given domains are removed, for all query types and upstreams.`,
+		Example: `  ctrld cache flush
+  ctrld cache flush example.com www.example.com`,
+		Args:              cobra.ArbitraryArgs,
+		ValidArgsFunction: completeCachedDomains(0),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			body, _ := json.Marshal(cacheFlushRequest{Domains: args})
+			resp, err := cc.post(cacheFlushPath, bytes.NewReader(body))
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send cache flush request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to flush cache: %s", strings.TrimSpace(string(buf)))
+			}
+			if len(args) == 0 {
+				mainLog.Load().Notice().Msg("DNS cache flushed")
+				return
+			}
+			mainLog.Load().Notice().Msgf("DNS cache flushed for: %s", strings.Join(args, ", "))
+		},
+	}
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage DNS cache of the running ctrld service",
+		Args:  cobra.OnlyValidArgs,
+		ValidArgs: []string{
+			cacheFlushCmd.Name(),
+		},
+	}
+	cacheCmd.AddCommand(cacheFlushCmd)
+	rootCmd.AddCommand(cacheCmd)
+
+	configEncryptCmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt ctrld config file",
+		Long: `Encrypt ctrld config file, using a key kept in the OS secret store.
+
+The encrypted config file could only be decrypted on this machine. Config changes
+made by ctrld, e.g: updating listener address, are kept encrypted.`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			path, content := readConfigFileContent()
+			if encryptedConfigBlob(content) != "" {
+				mainLog.Load().Notice().Msgf("config file is already encrypted: %s", path)
+				return
+			}
+			store, err := newSecretStore()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not encrypt config file")
+			}
+			key, err := configKey(store, true)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not encrypt config file")
+			}
+			encrypted, err := encryptConfig(key, content)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not encrypt config file")
+			}
+			if err := os.WriteFile(path, encrypted, 0600); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not write config file")
+			}
+			mainLog.Load().Notice().Msgf("encrypted config file: %s", path)
+		},
+	}
+	configDecryptCmd := &cobra.Command{
+		Use:   "decrypt",
+		Short: "Decrypt ctrld config file",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			path, content := readConfigFileContent()
+			blob := encryptedConfigBlob(content)
+			if blob == "" {
+				mainLog.Load().Notice().Msgf("config file is not encrypted: %s", path)
+				return
+			}
+			store, _ := newSecretStore()
+			key, err := configKey(store, false)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not decrypt config file")
+			}
+			decrypted, err := decryptConfig(key, blob)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not decrypt config file")
+			}
+			if err := os.WriteFile(path, decrypted, 0600); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not write config file")
+			}
+			mainLog.Load().Notice().Msgf("decrypted config file: %s", path)
+		},
+	}
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage ctrld config file",
+		Args:  cobra.OnlyValidArgs,
+		ValidArgs: []string{
+			configEncryptCmd.Name(),
+			configDecryptCmd.Name(),
+		},
+	}
+	configCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "Path to config file")
+	configCmd.AddCommand(configEncryptCmd)
+	configCmd.AddCommand(configDecryptCmd)
+	rootCmd.AddCommand(configCmd)
+
+	var importOutput string
+	// runImport imports the config at path using importer, then writes the imported config.
+	runImport := func(source, path string, importer func(path string) (*ctrld.Config, []string, error)) {
+		cfg, warnings, err := importer(path)
+		if err != nil {
+			mainLog.Load().Fatal().Err(err).Msgf("could not import %s config", source)
+		}
+		if err := writeImportedConfig(cfg, warnings, fmt.Sprintf("%s config: %s", source, path), importOutput); err != nil {
+			mainLog.Load().Fatal().Err(err).Msg("could not write imported config")
+		}
+		// The console log is written to stdout, which may be the import output.
+		if importOutput != "" {
+			for _, w := range warnings {
+				mainLog.Load().Warn().Msg(w)
+			}
+			mainLog.Load().Notice().Msgf("imported %s config to %s", source, importOutput)
+		}
+	}
+	// importFile returns an importer reading the config file at path, then converting it using importer.
+	importFile := func(importer func([]byte) (*ctrld.Config, []string, error)) func(string) (*ctrld.Config, []string, error) {
+		return func(path string) (*ctrld.Config, []string, error) {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			return importer(content)
+		}
+	}
+	importAdGuardCmd := &cobra.Command{
+		Use:   "adguard <path>",
+		Short: "Import AdGuard Home config",
+		Long: `Import AdGuard Home config, AdGuardHome.yaml, as ctrld config.
+
+Upstreams, filter lists, custom filtering rules, persistent clients and DNS
+rewrites are imported. Settings which could not be imported are listed at
+the beginning of the imported config.`,
+		Example: `  ctrld import adguard /opt/AdGuardHome/AdGuardHome.yaml -o ctrld.toml`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runImport("AdGuard Home", args[0], importFile(importAdGuard))
+		},
+	}
+	var piholeDnsmasqDir string
+	importPiholeCmd := &cobra.Command{
+		Use:   "pihole [dir]",
+		Short: "Import Pi-hole config",
+		Long: `Import Pi-hole config in Pi-hole config directory, default to /etc/pihole, as ctrld config.
+
+Upstreams and conditional forwarding are imported from pihole.toml, or from
+setupVars.conf for Pi-hole v5. Adlists and exact domains are imported from
+gravity.db. Local DNS and CNAME records are imported from pihole.toml, or from
+custom.list and 05-pihole-custom-cname.conf for Pi-hole v5. Settings which
+could not be imported are listed at the beginning of the imported config.`,
+		Example: `  ctrld import pihole -o ctrld.toml
+  ctrld import pihole /srv/pihole/etc-pihole --dnsmasq-dir /srv/pihole/etc-dnsmasq.d`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			dir := piholeDefaultDir
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			runImport("Pi-hole", dir, func(dir string) (*ctrld.Config, []string, error) {
+				return importPihole(dir, piholeDnsmasqDir)
+			})
+		},
+	}
+	importPiholeCmd.Flags().StringVarP(&piholeDnsmasqDir, "dnsmasq-dir", "", piholeDefaultDnsmasqDir, "Pi-hole v5 dnsmasq config directory")
+	importUnboundCmd := &cobra.Command{
+		Use:   "unbound <path>",
+		Short: "Import Unbound config",
+		Long: `Import Unbound config, unbound.conf, as ctrld config.
+
+Forward and stub zones, local data and blocking local zones are imported.
+Included config files are not read, import them separately if needed.
+Settings which could not be imported are listed at the beginning of the
+imported config.`,
+		Example: `  ctrld import unbound /etc/unbound/unbound.conf -o ctrld.toml`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runImport("Unbound", args[0], importFile(importUnbound))
+		},
+	}
+	importDnsmasqCmd := &cobra.Command{
+		Use:   "dnsmasq <path>",
+		Short: "Import dnsmasq config",
+		Long: `Import dnsmasq config, dnsmasq.conf, as ctrld config.
+
+Servers, including domain specific ones, addresses, host records and CNAME
+records are imported. Config files in "conf-dir" or "conf-file" are not read,
+import them separately if needed. Settings which could not be imported are
+listed at the beginning of the imported config.`,
+		Example: `  ctrld import dnsmasq /etc/dnsmasq.conf -o ctrld.toml`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runImport("dnsmasq", args[0], importFile(importDnsmasq))
+		},
+	}
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import config of other DNS servers as ctrld config",
+		Args:  cobra.OnlyValidArgs,
+		ValidArgs: []string{
+			importAdGuardCmd.Name(),
+			importPiholeCmd.Name(),
+			importUnboundCmd.Name(),
+			importDnsmasqCmd.Name(),
+		},
+	}
+	importCmd.PersistentFlags().StringVarP(&importOutput, "output", "o", "", "Output file, default to stdout")
+	importCmd.AddCommand(importAdGuardCmd)
+	importCmd.AddCommand(importPiholeCmd)
+	importCmd.AddCommand(importUnboundCmd)
+	importCmd.AddCommand(importDnsmasqCmd)
+	rootCmd.AddCommand(importCmd)
+
+	var (
+		doctorBundle bool
+		doctorOutput string
+	)
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Show diagnostics of ctrld",
+		Long: `Show diagnostics of ctrld: version, service status, network interfaces and DNS settings.
+
+With --bundle, a zip file is created for support tickets, containing diagnostics, logs,
+crash reports, config and runtime state of the running ctrld service. Credentials and
+resolver ids are redacted.`,
+		Example: `  ctrld doctor
+  ctrld doctor --bundle --output /tmp/ctrld.zip`,
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if configPath != "" {
+				v.SetConfigFile(configPath)
+				readConfigFile(false, false)
+			} else {
+				readConfig(false)
+			}
+			_ = v.Unmarshal(&cfg)
+			now := time.Now()
+			if !doctorBundle {
+				os.Stdout.Write(redactSecrets(diagnosticsInfo(now)))
+				fmt.Println()
+				os.Stdout.Write(diagnosticsNetwork())
+				return
+			}
+			output := doctorOutput
+			if output == "" {
+				output = "ctrld-diagnostics-" + now.Format("20060102-150405") + ".zip"
+			}
+			if err := writeDiagnosticsBundle(output, collectDiagnostics(now), now); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("could not write diagnostics bundle")
+			}
+			mainLog.Load().Notice().Msgf("diagnostics bundle was written to: %s", output)
+		},
+	}
+	doctorCmd.Flags().BoolVarP(&doctorBundle, "bundle", "", false, "Create a zip file containing diagnostics for support tickets")
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", "", "Path to the diagnostics bundle, default to ctrld-diagnostics-<time>.zip in current directory")
+	doctorCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to config file")
+	rootCmd.AddCommand(doctorCmd)
+
+	var (
+		pprofSeconds int
+		pprofOutput  string
+	)
+	debugPprofCmd := &cobra.Command{
+		Use:   "pprof <profile>",
+		Short: "Collect pprof profile of the running ctrld service",
+		Long: `Collect pprof profile of the running ctrld service, which could be analyzed using "go tool pprof".
+
+The profile is one of: profile (CPU), heap, allocs, goroutine, block, mutex, threadcreate, trace.
+Debug endpoints must be enabled using "debug_endpoints = true" in [service] config.`,
+		Example: `  ctrld debug pprof heap
+  ctrld debug pprof profile --seconds 60 --output cpu.pprof`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"profile", "heap", "allocs", "goroutine", "block", "mutex", "threadcreate", "trace"},
+		PreRun: func(cmd *cobra.Command, args []string) {
+			checkHasElevatedPrivilege()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := args[0]
+			path := debugPprofPath + profile
+			if profile == "profile" || profile == "trace" {
+				path += "?seconds=" + strconv.Itoa(pprofSeconds)
+			}
+			output := pprofOutput
+			if output == "" {
+				output = profile + ".pprof"
+			}
+			dir, err := socketDir()
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
+			}
+			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+			cc.c.Timeout = time.Duration(pprofSeconds)*time.Second + 30*time.Second
+			resp, err := cc.post(path, nil)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to send pprof request to ctrld")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to collect profile: %s", strings.TrimSpace(string(buf)))
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to create profile file")
+			}
+			defer f.Close()
+			if _, err := io.Copy(f, resp.Body); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to write profile file")
+			}
+			mainLog.Load().Notice().Msgf("profile was written to: %s", output)
 		},
 	}
-	uninstallCmdAlias.Flags().StringVarP(&ifaceStartStop, "iface", "", "auto", `Reset DNS setting for iface, "auto" means the default interface gateway`)
-	uninstallCmdAlias.Flags().AddFlagSet(uninstallCmd.Flags())
-	rootCmd.AddCommand(uninstallCmdAlias)
-
-	listClientsCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List clients that ctrld discovered",
+	debugPprofCmd.Flags().IntVarP(&pprofSeconds, "seconds", "", 30, "Duration of CPU profile and trace, in seconds")
+	debugPprofCmd.Flags().StringVarP(&pprofOutput, "output", "o", "", "Path to the profile file, default to <profile>.pprof in current directory")
+	debugRuntimeCmd := &cobra.Command{
+		Use:   "runtime",
+		Short: "Show runtime stats of the running ctrld service",
 		Args:  cobra.NoArgs,
 		PreRun: func(cmd *cobra.Command, args []string) {
 			checkHasElevatedPrivilege()
@@ -919,65 +1907,193 @@ NOTE: Uninstalling will set DNS to values provided by DHCP.`,
 				mainLog.Load().Fatal().Err(err).Msg("failed to find ctrld home dir")
 			}
 			cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
-			resp, err := cc.post(listClientsPath, nil)
+			resp, err := cc.post(debugRuntimePath, nil)
 			if err != nil {
-				mainLog.Load().Fatal().Err(err).Msg("failed to get clients list")
+				mainLog.Load().Fatal().Err(err).Msg("failed to get runtime stats from ctrld")
 			}
 			defer resp.Body.Close()
-
-			var clients []*clientinfo.Client
-			if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
-				mainLog.Load().Fatal().Err(err).Msg("failed to decode clients list result")
+			if resp.StatusCode != http.StatusOK {
+				buf, _ := io.ReadAll(resp.Body)
+				mainLog.Load().Fatal().Msgf("failed to get runtime stats: %s", strings.TrimSpace(string(buf)))
 			}
-			map2Slice := func(m map[string]struct{}) []string {
-				s := make([]string, 0, len(m))
-				for k := range m {
-					if k == "" { // skip empty source from output.
-						continue
-					}
-					s = append(s, k)
-				}
-				sort.Strings(s)
-				return s
+			var stats runtimeStats
+			if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+				mainLog.Load().Fatal().Err(err).Msg("failed to decode runtime stats")
 			}
-			// If metrics is enabled, server set this for all clients, so we can check only the first one.
-			// Ideally, we may have a field in response to indicate that query count should be shown, but
-			// it would break earlier version of ctrld, which only look list of clients in response.
-			withQueryCount := len(clients) > 0 && clients[0].IncludeQueryCount
-			data := make([][]string, len(clients))
-			for i, c := range clients {
-				row := []string{
-					c.IP.String(),
-					c.Hostname,
-					c.Mac,
-					strings.Join(map2Slice(c.Source), ","),
-				}
-				if withQueryCount {
-					row = append(row, strconv.FormatInt(c.QueryCount, 10))
-				}
-				data[i] = row
+			data := [][]string{
+				{"Version", stats.Version},
+				{"Go version", stats.GoVersion},
+				{"Uptime", stats.Uptime},
+				{"CPUs", strconv.Itoa(stats.NumCPU)},
+				{"GOMAXPROCS", strconv.Itoa(stats.GOMAXPROCS)},
+				{"Goroutines", strconv.Itoa(stats.NumGoroutine)},
+				{"Heap alloc", formatMiB(stats.HeapAlloc)},
+				{"Heap in use", formatMiB(stats.HeapInuse)},
+				{"Heap objects", strconv.FormatUint(stats.HeapObjects, 10)},
+				{"Stack in use", formatMiB(stats.StackInuse)},
+				{"Sys", formatMiB(stats.Sys)},
+				{"Total alloc", formatMiB(stats.TotalAlloc)},
+				{"GC cycles", strconv.FormatUint(uint64(stats.NumGC), 10)},
+				{"GC pause total", stats.PauseTotal},
+				{"GC CPU", strconv.FormatFloat(stats.GCCPUPercent, 'f', 2, 64) + "%"},
 			}
 			table := tablewriter.NewWriter(os.Stdout)
-			headers := []string{"IP", "Hostname", "Mac", "Discovered"}
-			if withQueryCount {
-				headers = append(headers, "Queries")
-			}
-			table.SetHeader(headers)
 			table.SetAutoFormatHeaders(false)
 			table.AppendBulk(data)
 			table.Render()
 		},
 	}
-	clientsCmd := &cobra.Command{
-		Use:   "clients",
-		Short: "Manage clients",
+	debugCmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Debug the running ctrld service",
 		Args:  cobra.OnlyValidArgs,
 		ValidArgs: []string{
-			listClientsCmd.Use,
+			debugPprofCmd.Name(),
+			debugRuntimeCmd.Name(),
 		},
 	}
-	clientsCmd.AddCommand(listClientsCmd)
-	rootCmd.AddCommand(clientsCmd)
+	debugCmd.AddCommand(debugPprofCmd)
+	debugCmd.AddCommand(debugRuntimeCmd)
+	rootCmd.AddCommand(debugCmd)
+
+	var (
+		benchOpts      = benchOptions{}
+		benchUpstreams []string
+		benchEndpoints []string
+		benchListener  bool
+		benchDomains   string
+		benchQtype     string
+	)
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark upstreams and listeners",
+		Long: `Benchmark upstreams and listeners, by sending queries at a given rate,
+reporting latency percentiles and error rates.
+
+By default, all upstreams in config are benchmarked. Queries are sent to all
+targets concurrently, using domains from a sample list, or from the file given
+by --domains, one domain per line.`,
+		Example: `  ctrld bench
+  ctrld bench --upstream 0 --qps 50 --duration 30s
+  ctrld bench --endpoint https://dns.controld.com/p2 --endpoint quic://p2.dns.controld.com
+  ctrld bench --listener --domains domains.txt`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if benchOpts.qps <= 0 || benchOpts.concurrency <= 0 {
+				mainLog.Load().Fatal().Msg("--qps and --concurrency must be positive")
+			}
+			qtype, ok := dns.StringToType[strings.ToUpper(benchQtype)]
+			if !ok {
+				mainLog.Load().Fatal().Msgf("invalid query type: %s", benchQtype)
+			}
+			benchOpts.qtype = qtype
+			benchOpts.domains = benchDefaultDomains
+			if benchDomains != "" {
+				f, err := os.Open(benchDomains)
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not open domain list")
+				}
+				domains, err := readBenchDomains(f)
+				f.Close()
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not read domain list")
+				}
+				benchOpts.domains = domains
+			}
+
+			var targets []benchTarget
+			addTarget := func(t benchTarget, err error) {
+				if err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("could not create bench target")
+				}
+				targets = append(targets, t)
+			}
+			for _, endpoint := range benchEndpoints {
+				addTarget(benchEndpointTarget(endpoint))
+			}
+			if len(benchEndpoints) == 0 || len(benchUpstreams) > 0 || benchListener {
+				if configPath != "" {
+					v.SetConfigFile(configPath)
+					readConfigFile(false, false)
+				} else {
+					readConfig(false)
+				}
+				if err := v.Unmarshal(&cfg); err != nil {
+					mainLog.Load().Fatal().Err(err).Msg("failed to unmarshal config")
+				}
+			}
+			names := benchUpstreams
+			if len(names) == 0 && len(benchEndpoints) == 0 && !benchListener {
+				for n := range cfg.Upstream {
+					names = append(names, n)
+				}
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				n = strings.TrimPrefix(n, upstreamPrefix)
+				uc := cfg.Upstream[n]
+				if uc == nil {
+					mainLog.Load().Fatal().Msgf("upstream not found: %s", n)
+				}
+				addTarget(benchUpstreamTarget(upstreamPrefix+n, uc))
+			}
+			if benchListener {
+				listeners := make([]string, 0, len(cfg.Listener))
+				for n := range cfg.Listener {
+					listeners = append(listeners, n)
+				}
+				sort.Strings(listeners)
+				for _, n := range listeners {
+					addTarget(benchListenerTarget("listener."+n, cfg.Listener[n]))
+				}
+			}
+			if len(targets) == 0 {
+				mainLog.Load().Fatal().Msg("no targets to benchmark")
+			}
+
+			mainLog.Load().Notice().Msgf("benchmarking %d target(s) at %d qps for %s", len(targets), benchOpts.qps, benchOpts.duration)
+			results := runBench(context.Background(), targets, benchOpts)
+			formatMs := func(d time.Duration) string {
+				return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + "ms"
+			}
+			data := make([][]string, len(results))
+			for i, r := range results {
+				rcodes := make([]string, 0, len(r.Rcodes))
+				for rcode, count := range r.Rcodes {
+					rcodes = append(rcodes, rcode+":"+strconv.Itoa(count))
+				}
+				sort.Strings(rcodes)
+				data[i] = []string{
+					r.Target,
+					strconv.Itoa(r.Sent),
+					strconv.FormatFloat(r.errorRate(), 'f', 2, 64) + "%",
+					strconv.Itoa(r.Timeouts),
+					strings.Join(rcodes, " "),
+					formatMs(r.P50),
+					formatMs(r.P95),
+					formatMs(r.P99),
+					formatMs(r.Avg),
+					formatMs(r.Max),
+				}
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Target", "Sent", "Error rate", "Timeouts", "Rcodes", "P50", "P95", "P99", "Avg", "Max"})
+			table.SetAutoFormatHeaders(false)
+			table.AppendBulk(data)
+			table.Render()
+		},
+	}
+	benchCmd.Flags().StringVarP(&configPath, "config", "c", "", "Path to config file")
+	benchCmd.Flags().StringSliceVarP(&benchUpstreams, "upstream", "", nil, `Upstreams in config to benchmark, e.g: "0" or "upstream.0"`)
+	benchCmd.Flags().StringSliceVarP(&benchEndpoints, "endpoint", "", nil, "Upstream endpoints to benchmark, e.g: https://dns.controld.com/p2")
+	benchCmd.Flags().BoolVarP(&benchListener, "listener", "", false, "Benchmark listeners in config")
+	benchCmd.Flags().StringVarP(&benchDomains, "domains", "", "", "Path to domain list file, one domain per line")
+	benchCmd.Flags().StringVarP(&benchQtype, "type", "", "A", "Query type")
+	benchCmd.Flags().IntVarP(&benchOpts.qps, "qps", "", benchDefaultQPS, "Queries per second sent to each target")
+	benchCmd.Flags().DurationVarP(&benchOpts.duration, "duration", "", benchDefaultDuration, "Benchmark duration")
+	benchCmd.Flags().DurationVarP(&benchOpts.timeout, "timeout", "", benchDefaultTimeout, "Timeout of each query")
+	benchCmd.Flags().IntVarP(&benchOpts.concurrency, "concurrency", "", benchDefaultConcurrency, "Maximum number of in-flight queries for each target")
+	rootCmd.AddCommand(benchCmd)
 
 	const (
 		upgradeChannelDev     = "dev"
@@ -1155,6 +2271,7 @@ func CheckDeactivationPin(pin int64, stopCh chan struct{}) int {
 
 // run runs ctrld cli with given app callback and stop channel.
 func run(appCallback *AppCallback, stopCh chan struct{}) {
+	defer recoverCrash()
 	if stopCh == nil {
 		mainLog.Load().Fatal().Msg("stopCh is nil")
 	}
@@ -1169,6 +2286,7 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 		apiForceReloadCh: make(chan struct{}),
 		cfg:              &cfg,
 		appCallback:      appCallback,
+		queryStats:       newQueryStats(),
 	}
 	if homedir == "" {
 		if dir, err := userHomeDir(); err == nil {
@@ -1233,6 +2351,7 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 
 	mainLog.Load().Info().Msgf("starting ctrld %s", curVersion())
 	mainLog.Load().Info().Msgf("os: %s", osVersion())
+	sandbox()
 
 	// Wait for network up.
 	if !ctrldnet.Up() {
@@ -1256,9 +2375,31 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 	}
 
 	oldLogPath := cfg.Service.LogPath
+	initCdAPIURL()
+	if err := resolveCdSecret(); err != nil {
+		notifyExitToLogServer()
+		mainLog.Load().Fatal().Err(err).Msg("failed to read resolver uid from secret store")
+	}
 	if uid := cdUIDFromProvToken(); uid != "" {
 		cdUID = uid
 	}
+	cdDefaultUID = cdUID
+	if uid := activeCdProfileUID(); uid != "" && cdUID != "" {
+		mainLog.Load().Info().Msgf("using uid of switched Control D profile: %s", uid)
+		cdUID = uid
+	}
+	if cdUID != "" && len(cdScheduleFlags) > 0 {
+		schedules, err := parseCdSchedules(cdScheduleFlags, cdProfiles)
+		if err != nil {
+			notifyExitToLogServer()
+			mainLog.Load().Fatal().Err(err).Msg("invalid Control D profile schedule")
+		}
+		p.cdSchedules = schedules
+		if profile := scheduledCdProfile(schedules, time.Now()); profile != "" {
+			mainLog.Load().Info().Msgf("using uid of scheduled Control D profile: %s", profile)
+			cdUID = cdProfiles[profile]
+		}
+	}
 	if cdUID != "" {
 		validateCdUpstreamProtocol()
 		if err := processCDFlags(&cfg); err != nil {
@@ -1356,6 +2497,23 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 			}
 		}
 	})
+	if p.cfg.Service.FirewallRedirect {
+		p.onStarted = append(p.onStarted, p.installFirewall)
+	}
+	// Firewall rules could also be installed after config was reloaded.
+	p.onStopped = append(p.onStopped, func() {
+		if !p.stoppedUnauthorized {
+			p.removeFirewall()
+		}
+	})
+	if p.cfg.Service.Coexist {
+		p.onStarted = append(p.onStarted, p.setupCoexistence)
+		p.onStopped = append(p.onStopped, func() {
+			if !p.stoppedUnauthorized {
+				revertCoexistence()
+			}
+		})
+	}
 	if platform := router.Name(); platform != "" {
 		if cp := router.CertPool(); cp != nil {
 			rootCertPool = cp
@@ -1368,6 +2526,11 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 				}
 			})
 			p.onStopped = append(p.onStopped, func() {
+				// Keep DNS redirection to ctrld, same as darwin, see prog.preRun.
+				if p.stoppedUnauthorized {
+					mainLog.Load().Warn().Msg("stopped without deactivation pin, skipping router cleanup")
+					return
+				}
 				mainLog.Load().Debug().Msg("router cleanup on stop")
 				if err := p.router.Cleanup(); err != nil {
 					mainLog.Load().Error().Err(err).Msg("could not cleanup router")
@@ -1376,10 +2539,16 @@ func run(appCallback *AppCallback, stopCh chan struct{}) {
 			})
 		}
 	}
+	// The Termux wake lock acquired by router pre-run is held until released explicitly.
+	if router.IsTermux() {
+		p.onStopped = append(p.onStopped, android.ReleaseWakeLock)
+	}
 
 	close(waitCh)
 	<-stopCh
 
+	// Restoring system DNS only after all in-flight queries were finished.
+	p.waitListenersDrained()
 	p.stopDnsWatchers()
 	for _, f := range p.onStopped {
 		f()
@@ -1392,18 +2561,24 @@ func writeConfigFile(cfg *ctrld.Config) error {
 	} else if configPath != "" {
 		defaultConfigFile = configPath
 	}
+	var buf bytes.Buffer
+	if cdUID != "" {
+		buf.WriteString("# AUTO-GENERATED VIA CD FLAG - DO NOT MODIFY\n\n")
+	}
+	enc := toml.NewEncoder(&buf).SetIndentTables(true)
+	if err := enc.Encode(&cfg); err != nil {
+		return err
+	}
+	content, err := maybeEncryptConfig([]byte(redactCdUID(buf.String())))
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(defaultConfigFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0o644))
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if cdUID != "" {
-		if _, err := f.WriteString("# AUTO-GENERATED VIA CD FLAG - DO NOT MODIFY\n\n"); err != nil {
-			return err
-		}
-	}
-	enc := toml.NewEncoder(f).SetIndentTables(true)
-	if err := enc.Encode(&cfg); err != nil {
+	if _, err := f.Write(content); err != nil {
 		return err
 	}
 	if err := f.Close(); err != nil {
@@ -1418,7 +2593,7 @@ func writeConfigFile(cfg *ctrld.Config) error {
 // - It emits notice message to user if notice is true.
 func readConfigFile(writeDefaultConfig, notice bool) bool {
 	// If err == nil, there's a config supplied via `--config`, no default config written.
-	err := v.ReadInConfig()
+	err := readInConfig(v)
 	if err == nil {
 		if notice {
 			mainLog.Load().Notice().Msg("Reading config: " + v.ConfigFileUsed())
@@ -1502,6 +2677,16 @@ func readBase64Config(configBase64 string) error {
 	return v.ReadConfig(bytes.NewReader(configStr))
 }
 
+// upstreamEndpointAndType returns the upstream endpoint and type of endpoint given by user.
+func upstreamEndpointAndType(endpoint string) (string, string) {
+	typ := ctrld.ResolverTypeFromEndpoint(endpoint)
+	endpoint = strings.TrimPrefix(endpoint, "quic://")
+	if after, found := strings.CutPrefix(endpoint, "h3://"); found {
+		endpoint = "https://" + after
+	}
+	return endpoint, typ
+}
+
 func processNoConfigFlags(noConfigStart bool) {
 	if !noConfigStart {
 		return
@@ -1511,15 +2696,7 @@ func processNoConfigFlags(noConfigStart bool) {
 	}
 	processListenFlag()
 
-	endpointAndTyp := func(endpoint string) (string, string) {
-		typ := ctrld.ResolverTypeFromEndpoint(endpoint)
-		endpoint = strings.TrimPrefix(endpoint, "quic://")
-		if after, found := strings.CutPrefix(endpoint, "h3://"); found {
-			endpoint = "https://" + after
-		}
-		return endpoint, typ
-	}
-	pEndpoint, pType := endpointAndTyp(primaryUpstream)
+	pEndpoint, pType := upstreamEndpointAndType(primaryUpstream)
 	puc := &ctrld.UpstreamConfig{
 		Name:     pEndpoint,
 		Endpoint: pEndpoint,
@@ -1529,7 +2706,7 @@ func processNoConfigFlags(noConfigStart bool) {
 	puc.Init()
 	upstream := map[string]*ctrld.UpstreamConfig{"0": puc}
 	if secondaryUpstream != "" {
-		sEndpoint, sType := endpointAndTyp(secondaryUpstream)
+		sEndpoint, sType := upstreamEndpointAndType(secondaryUpstream)
 		suc := &ctrld.UpstreamConfig{
 			Name:     sEndpoint,
 			Endpoint: sEndpoint,
@@ -1559,6 +2736,39 @@ func init() {
 	cdDeactivationPin.Store(defaultDeactivationPin)
 }
 
+// deactivationPin returns the pin code required for stopping and uninstalling ctrld. The pin
+// code from Control D API takes precedence over the one defined in config.
+func (p *prog) deactivationPin() int64 {
+	if pin := cdDeactivationPin.Load(); pin != defaultDeactivationPin {
+		return pin
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cfg != nil && p.cfg.Service.DeactivationPin != nil {
+		return *p.cfg.Service.DeactivationPin
+	}
+	return defaultDeactivationPin
+}
+
+// stopAuthorizationTTL is how long a verified deactivation pin authorizes stopping ctrld.
+const stopAuthorizationTTL = time.Minute
+
+// authorizeStop records that a valid deactivation pin was provided, allowing the next Stop
+// within stopAuthorizationTTL to restore DNS settings.
+func (p *prog) authorizeStop() {
+	p.stopAuthorizedAt.Store(time.Now().UnixNano())
+}
+
+// unauthorizedStop reports whether ctrld is being stopped without a valid deactivation pin,
+// i.e. by the service manager instead of "ctrld stop" or "ctrld uninstall" commands.
+func (p *prog) unauthorizedStop() bool {
+	if p.deactivationPin() == defaultDeactivationPin {
+		return false
+	}
+	at := p.stopAuthorizedAt.Load()
+	return at == 0 || time.Since(time.Unix(0, at)) > stopAuthorizationTTL
+}
+
 // deactivationPinNotSet reports whether cdDeactivationPin was not set by processCDFlags.
 func deactivationPinNotSet() bool {
 	return cdDeactivationPin.Load() == defaultDeactivationPin
@@ -1566,13 +2776,16 @@ func deactivationPinNotSet() bool {
 
 func processCDFlags(cfg *ctrld.Config) error {
 	logger := mainLog.Load().With().Str("mode", "cd").Logger()
+	cdUID := loadCdUID()
 	logger.Info().Msgf("fetching Controld D configuration from API: %s", cdUID)
 	bo := backoff.NewBackoff("processCDFlags", logf, 30*time.Second)
 	bo.LogLongerThan = 30 * time.Second
 	ctx := context.Background()
 	resolverConfig, err := controld.FetchResolverConfig(cdUID, rootCmd.Version, cdDev)
+	// If there's cached config, do not wait for the network to be up.
+	cachedConfig := loadCachedResolverConfig(cdUID)
 	for {
-		if errUrlNetworkError(err) {
+		if errUrlNetworkError(err) && cachedConfig == nil {
 			bo.BackOff(ctx, err)
 			logger.Warn().Msg("could not fetch resolver using bootstrap DNS, retrying...")
 			resolverConfig, err = controld.FetchResolverConfig(cdUID, rootCmd.Version, cdDev)
@@ -1580,6 +2793,17 @@ func processCDFlags(cfg *ctrld.Config) error {
 		}
 		break
 	}
+	var uer *controld.UtilityErrorResponse
+	switch {
+	case err == nil:
+		cdConfigFromCache.Store(false)
+		saveCachedResolverConfig(cdUID, resolverConfig)
+	case cachedConfig != nil && !errors.As(err, &uer):
+		// Only use cached config if the API is unreachable, not when it rejects the uid.
+		logger.Warn().Err(err).Msg("could not fetch resolver config, using cached config")
+		resolverConfig, err = cachedConfig, nil
+		cdConfigFromCache.Store(true)
+	}
 	if err != nil {
 		if isMobile() {
 			return err
@@ -1595,11 +2819,14 @@ func processCDFlags(cfg *ctrld.Config) error {
 
 	logger.Info().Msg("generating ctrld config from Control-D configuration")
 
+	// Settings which are only accepted from local config file are kept.
+	localService := cfg.Service
 	*cfg = ctrld.Config{}
 	// Fetch config, unmarshal to cfg.
 	if resolverConfig.Ctrld.CustomConfig != "" {
 		logger.Info().Msg("using defined custom config of Control-D resolver")
 		if err := validateCdRemoteConfig(resolverConfig, cfg); err == nil {
+			keepLocalOnlySettings(&cfg.Service, &localService)
 			setListenerDefaultValue(cfg)
 			return nil
 		}
@@ -1644,6 +2871,7 @@ func processCDFlags(cfg *ctrld.Config) error {
 		},
 	}
 	cfg.Listener["0"] = lc
+	keepLocalOnlySettings(&cfg.Service, &localService)
 
 	// Set default value.
 	setListenerDefaultValue(cfg)
@@ -1651,6 +2879,21 @@ func processCDFlags(cfg *ctrld.Config) error {
 	return nil
 }
 
+// initCdAPIURL sets the Control D API base URL from --cd-api-url flag, or from config if the flag is not set.
+func initCdAPIURL() {
+	apiURL := cdAPIURL
+	if apiURL == "" {
+		apiURL = cfg.Service.CdAPIURL
+	}
+	if apiURL == "" {
+		return
+	}
+	if err := controld.SetAPIURL(apiURL); err != nil {
+		mainLog.Load().Fatal().Err(err).Msgf("invalid Control D API URL: %s", apiURL)
+	}
+	mainLog.Load().Info().Msgf("using Control D API URL: %s", apiURL)
+}
+
 // setListenerDefaultValue sets the default value for cfg.Listener if none existed.
 func setListenerDefaultValue(cfg *ctrld.Config) {
 	if len(cfg.Listener) == 0 {
@@ -1668,7 +2911,11 @@ func validateCdRemoteConfig(rc *controld.ResolverConfig, cfg *ctrld.Config) erro
 	if err := readBase64Config(rc.Ctrld.CustomConfig); err != nil {
 		return err
 	}
-	return v.Unmarshal(&cfg)
+	if err := v.Unmarshal(&cfg); err != nil {
+		return err
+	}
+	stripLocalOnlySettings(&cfg.Service)
+	return nil
 }
 
 func processListenFlag() {
@@ -1779,7 +3026,7 @@ func selfCheckStatus(ctx context.Context, s service.Service, sockDir string) (bo
 	} else {
 		v.SetConfigFile(defaultConfigFile)
 	}
-	if err := v.ReadInConfig(); err != nil {
+	if err := readInConfig(v); err != nil {
 		mainLog.Load().Error().Err(err).Msgf("failed to re-read configuration file: %s", v.ConfigFileUsed())
 		return false, status, err
 	}
@@ -1902,6 +3149,175 @@ func userHomeDir() (string, error) {
 	return dir, nil
 }
 
+// printUpstreamLatency prints latency percentiles of upstreams reported by running ctrld service.
+func printUpstreamLatency() {
+	dir, err := socketDir()
+	if err != nil {
+		return
+	}
+	cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+	resp, err := cc.post(latencyPath, nil)
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get upstreams latency")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Older ctrld version does not support latency stats.
+		return
+	}
+	var stats []upstreamLatencyStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("failed to decode upstreams latency result")
+		return
+	}
+	if len(stats) == 0 {
+		return
+	}
+	formatMs := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) + "ms" }
+	data := make([][]string, len(stats))
+	for i, s := range stats {
+		data[i] = []string{s.Upstream, strconv.Itoa(s.Samples), formatMs(s.P50), formatMs(s.P95), formatMs(s.P99)}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Upstream", "Samples", "P50", "P95", "P99"})
+	table.SetAutoFormatHeaders(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// printResourceStats prints resources used by running ctrld service.
+func printResourceStats() {
+	dir, err := socketDir()
+	if err != nil {
+		return
+	}
+	cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+	resp, err := cc.post(resourcesPath, nil)
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get resource stats")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Older ctrld version does not report resource stats.
+		return
+	}
+	var rs resourceStats
+	if err := json.NewDecoder(resp.Body).Decode(&rs); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("failed to decode resource stats result")
+		return
+	}
+	data := [][]string{
+		{"Heap alloc", formatMiB(rs.HeapAlloc)},
+		{"Sys", formatMiB(rs.Sys)},
+		{"Goroutines", strconv.Itoa(rs.Goroutines)},
+	}
+	if rs.OpenFiles >= 0 {
+		data = append(data, []string{"Open files", strconv.Itoa(rs.OpenFiles)})
+	}
+	if rs.CacheEntries != nil {
+		data = append(data, []string{"Cache entries", strconv.Itoa(*rs.CacheEntries)})
+	}
+	for _, u := range rs.Upstreams {
+		data = append(data, []string{u.Upstream + " connections", strconv.FormatInt(u.OpenConns, 10)})
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Resource", "Usage"})
+	table.SetAutoFormatHeaders(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// printUpstreamCerts prints the certificates presented by upstreams of running ctrld service.
+func printUpstreamCerts() {
+	dir, err := socketDir()
+	if err != nil {
+		return
+	}
+	cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+	resp, err := cc.post(upstreamCertPath, nil)
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get upstreams certificate")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Older ctrld version does not track upstreams certificate.
+		return
+	}
+	var certs []upstreamCertStatus
+	if err := json.NewDecoder(resp.Body).Decode(&certs); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("failed to decode upstreams certificate result")
+		return
+	}
+	if len(certs) == 0 {
+		return
+	}
+	now := time.Now()
+	data := make([][]string, len(certs))
+	for i, c := range certs {
+		expires := c.NotAfter.Format(time.DateOnly)
+		if days := int(c.NotAfter.Sub(now).Hours() / 24); c.NotAfter.After(now) {
+			expires += fmt.Sprintf(" (%d days)", days)
+		} else {
+			expires += " (expired)"
+		}
+		data[i] = []string{c.Upstream, c.Subject, c.Issuer, expires}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Upstream", "Subject", "Issuer", "Expires"})
+	table.SetAutoFormatHeaders(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// dnsEventsStatusLimit is the number of most recent DNS events printed by status command.
+const dnsEventsStatusLimit = 10
+
+// printDnsEvents prints the most recent system DNS changes reported by running ctrld service.
+func printDnsEvents() {
+	dir, err := socketDir()
+	if err != nil {
+		return
+	}
+	cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+	resp, err := cc.post(dnsEventsPath, nil)
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get DNS events")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Older ctrld version does not support DNS events.
+		return
+	}
+	var events []dnsEvent
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("failed to decode DNS events result")
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	if len(events) > dnsEventsStatusLimit {
+		events = events[len(events)-dnsEventsStatusLimit:]
+	}
+	data := make([][]string, len(events))
+	for i, ev := range events {
+		detail := strings.Join(ev.Nameservers, ", ")
+		if ev.Error != "" {
+			detail = ev.Error
+		}
+		data[i] = []string{ev.Time.Local().Format(time.DateTime), ev.Iface, ev.Event, detail}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Interface", "DNS Event", "Detail"})
+	table.SetAutoFormatHeaders(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
 // socketDir returns directory that ctrld will create socket file for running controlServer.
 func socketDir() (string, error) {
 	switch {
@@ -1966,6 +3382,20 @@ func readConfigWithNotice(writeDefaultConfig, notice bool) {
 	}
 }
 
+// readConfigFileContent returns path and content of the config file in use, exiting if there's none.
+func readConfigFileContent() (string, []byte) {
+	readConfig(false)
+	path := v.ConfigFileUsed()
+	if path == "" {
+		mainLog.Load().Fatal().Msg("no config file found")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		mainLog.Load().Fatal().Err(err).Msg("could not read config file")
+	}
+	return path, content
+}
+
 func uninstall(p *prog, s service.Service) {
 	if _, err := s.Status(); err != nil && errors.Is(err, service.ErrNotInstalled) {
 		mainLog.Load().Error().Msg(err.Error())
@@ -1985,12 +3415,17 @@ func uninstall(p *prog, s service.Service) {
 			return
 		}
 		p.resetDNS()
+		// Stop already removed firewall rules, this is for rules left by unclean shutdown.
+		_ = removeFirewallRules()
+		// Same for DNS servers reconfigured to coexist with ctrld.
+		revertCoexistence()
 		if router.Name() != "" {
 			mainLog.Load().Debug().Msg("Router cleanup")
 		}
 		// Stop already did router.Cleanup and report any error if happens,
 		// ignoring error here to prevent false positive.
 		_ = p.router.Cleanup()
+		removeCdSecret()
 		mainLog.Load().Notice().Msg("Service uninstalled")
 		return
 	}
@@ -2046,6 +3481,8 @@ func fieldErrorMsg(fe validator.FieldError) string {
 		return fmt.Sprintf("filed does not exist: %s", fe.Value())
 	case "http_url":
 		return fmt.Sprintf("invalid http/https url: %s", fe.Value())
+	case "record":
+		return fe.Param()
 	}
 	return ""
 }
@@ -2216,6 +3653,9 @@ func tryUpdateListenerConfig(cfg *ctrld.Config, infoLogger *zerolog.Logger, fata
 		tryAllPort53 := true
 		tryOldIPPort5354 := true
 		tryPort5354 := true
+		// Coexisting with other DNS server using port 53 is only done for the first listener,
+		// routers forward queries from their own DNS server to ctrld already.
+		tryCoexist := listener == cfg.FirstListener() && router.Name() == ""
 		if hasLocalDnsServer {
 			tryAllPort53 = false
 			tryOldIPPort5354 = false
@@ -2232,6 +3672,13 @@ func tryUpdateListenerConfig(cfg *ctrld.Config, infoLogger *zerolog.Logger, fata
 			if err == nil {
 				break
 			}
+			if tryCoexist && listener.Port == 53 {
+				tryCoexist = false
+				if coexistListener(cfg, listener) {
+					logMsg(il.Info(), n, "could not listen on address: %s, trying: %s", addr, net.JoinHostPort(listener.IP, strconv.Itoa(listener.Port)))
+					continue
+				}
+			}
 			if !check.IP && !check.Port {
 				if fatal {
 					logMsg(mainLog.Load().Fatal(), n, "failed to listen: %v", err)
@@ -2398,26 +3845,7 @@ func cdUIDFromProvToken() string {
 // already have a valid UID to pass to "ctrld run", so we don't have to force "ctrld run"
 // to re-do the already done job.
 func removeOrgFlagsFromArgs(sc *service.Config) {
-	a := sc.Arguments[:0]
-	skip := false
-	for _, x := range sc.Arguments {
-		if skip {
-			skip = false
-			continue
-		}
-		// For "--cd-org XXX"/"--custom-hostname XXX", skip them and mark next arg skipped.
-		if x == "--"+cdOrgFlagName || x == "--"+customHostnameFlagName {
-			skip = true
-			continue
-		}
-		// For "--cd-org=XXX"/"--custom-hostname=XXX", just skip them.
-		if strings.HasPrefix(x, "--"+cdOrgFlagName+"=") ||
-			strings.HasPrefix(x, "--"+customHostnameFlagName+"=") {
-			continue
-		}
-		a = append(a, x)
-	}
-	sc.Arguments = a
+	sc.Arguments = removeFlagsFromArgs(sc.Arguments, cdOrgFlagName, customHostnameFlagName)
 }
 
 // newSocketControlClient returns new control client after control server was started.
@@ -2500,8 +3928,8 @@ func validateCdUpstreamProtocol() {
 }
 
 func validateCdAndNextDNSFlags() {
-	if (cdUID != "" || cdOrg != "") && nextdns != "" {
-		mainLog.Load().Fatal().Msgf("--%s/--%s could not be used with --%s", cdUidFlagName, cdOrgFlagName, nextdnsFlagName)
+	if (cdUID != "" || cdOrg != "" || cdPair) && nextdns != "" {
+		mainLog.Load().Fatal().Msgf("--%s/--%s/--%s could not be used with --%s", cdUidFlagName, cdOrgFlagName, cdPairFlagName, nextdnsFlagName)
 	}
 }
 
@@ -2552,6 +3980,9 @@ const deactivationPinInvalidExitCode = 126
 // errInvalidDeactivationPin indicates that the deactivation pin is invalid.
 var errInvalidDeactivationPin = errors.New("deactivation pin is invalid")
 
+// errUnauthorizedStop indicates that the service was stopped without the deactivation pin.
+var errUnauthorizedStop = errors.New("service was stopped without deactivation pin")
+
 // errRequiredDeactivationPin indicates that the deactivation pin is required but not provided by users.
 var errRequiredDeactivationPin = errors.New("deactivation pin is required to stop or uninstall the service")
 
@@ -2571,8 +4002,14 @@ func checkDeactivationPin(s service.Service, stopCh chan struct{}) error {
 	if cc == nil {
 		return nil // ctrld is not running.
 	}
-	data, _ := json.Marshal(&deactivationRequest{Pin: deactivationPin})
-	resp, _ := cc.post(deactivationPath, bytes.NewReader(data))
+	return postDeactivationPin(cc, deactivationPin)
+}
+
+// postDeactivationPin sends the pin to the control server. If the pin is valid,
+// the running ctrld authorizes the next stop.
+func postDeactivationPin(cc *controlClient, pin int64) error {
+	data, _ := json.Marshal(&deactivationRequest{Pin: pin})
+	resp, err := cc.post(deactivationPath, bytes.NewReader(data))
 	if resp != nil {
 		switch resp.StatusCode {
 		case http.StatusBadRequest:
@@ -2580,6 +4017,9 @@ func checkDeactivationPin(s service.Service, stopCh chan struct{}) error {
 			return errRequiredDeactivationPin // pin is required
 		case http.StatusOK:
 			return nil // valid pin
+		case http.StatusTooManyRequests:
+			mainLog.Load().Error().Msg("too many invalid deactivation pin attempts, please try again later")
+			return errInvalidDeactivationPin
 		case http.StatusNotFound:
 			return nil // the server is running older version of ctrld
 		}
@@ -2806,3 +4246,8 @@ func uninstallInvalidCdUID(p *prog, logger zerolog.Logger, doStop bool) bool {
 	}
 	return false
 }
+
+// matchClient reports whether client c is identified by s, its IP address, MAC address or hostname.
+func matchClient(c *clientinfo.Client, s string) bool {
+	return s != "" && (c.IP.String() == s || strings.EqualFold(c.Mac, s) || strings.EqualFold(c.Hostname, s))
+}
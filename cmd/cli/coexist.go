@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/router/dnsmasq"
+)
+
+const (
+	// coexistPort is the port ctrld listens on while coexisting with another DNS server using port 53.
+	coexistPort = 5354
+	// coexistDnsmasqConfPath is the dnsmasq config file forwarding queries to ctrld while coexisting.
+	coexistDnsmasqConfPath = "/etc/dnsmasq.d/ctrld.conf"
+	// coexistPiholeStateFileName is the file keeping Pi-hole upstreams replaced by ctrld while coexisting.
+	coexistPiholeStateFileName = "pihole_upstreams.json"
+)
+
+// Known DNS servers which could use port 53 before ctrld.
+const (
+	dnsServerDnsmasq  = "dnsmasq"
+	dnsServerPihole   = "pihole"
+	dnsServerResolved = "systemd-resolved"
+)
+
+// dnsServerFromComm returns the known DNS server of given process command name, or the command name itself.
+func dnsServerFromComm(comm string) string {
+	switch comm {
+	case "dnsmasq":
+		return dnsServerDnsmasq
+	case "pihole-FTL":
+		return dnsServerPihole
+	// Command names are truncated to 15 characters by Linux kernel.
+	case "systemd-resolve", "systemd-resolved":
+		return dnsServerResolved
+	}
+	return comm
+}
+
+// listeningSocketInodes returns inodes of sockets listening on port, read from /proc/net/{tcp,tcp6,udp,udp6} format.
+func listeningSocketInodes(r io.Reader, port int) []string {
+	var inodes []string
+	suffix := fmt.Sprintf(":%04X", port)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		// TCP_LISTEN for TCP sockets, TCP_CLOSE for unconnected UDP sockets.
+		if st := fields[3]; st != "0A" && st != "07" {
+			continue
+		}
+		if fields[9] != "0" {
+			inodes = append(inodes, fields[9])
+		}
+	}
+	return inodes
+}
+
+// coexistence reconfigures a DNS server using port 53 to forward queries to ctrld.
+type coexistence interface {
+	// setup forwards queries to ctrld listener at given address and port.
+	setup(ip string, port int) error
+	// revert restores the DNS server config, it's a no-op if setup was never done.
+	revert() error
+}
+
+// newCoexistence returns the coexistence for given DNS server, or nil if ctrld could not coexist with it.
+func newCoexistence(server string) coexistence {
+	switch server {
+	case dnsServerDnsmasq:
+		return &dnsmasqCoexistence{confPath: coexistDnsmasqConfPath, restart: restartDnsmasq}
+	case dnsServerPihole:
+		return &piholeCoexistence{statePath: absHomeDir(coexistPiholeStateFileName)}
+	}
+	return nil
+}
+
+// coexistListener updates listener lc, which could not listen on port 53, to listen on coexistPort
+// instead, if coexistence is enabled, and the DNS server using port 53 allows it.
+func coexistListener(cfg *ctrld.Config, lc *ctrld.ListenerConfig) bool {
+	server := port53Owner()
+	if server == "" {
+		return false
+	}
+	if newCoexistence(server) == nil {
+		mainLog.Load().Notice().Msgf("port 53 is used by %s", server)
+		return false
+	}
+	if !cfg.Service.Coexist {
+		mainLog.Load().Notice().Msgf("port 53 is used by %s, set coexist = true in [service] config to forward its queries to ctrld", server)
+		return false
+	}
+	mainLog.Load().Notice().Msgf("port 53 is used by %s, coexisting with it using port %d", server, coexistPort)
+	lc.IP = "127.0.0.1"
+	lc.Port = coexistPort
+	return true
+}
+
+// setupCoexistence reconfigures the DNS server using port 53 to forward queries to ctrld,
+// if ctrld could not listen on port 53 because of it.
+func (p *prog) setupCoexistence() {
+	lc := p.cfg.FirstListener()
+	if lc == nil || lc.Port == 53 {
+		return
+	}
+	server := port53Owner()
+	c := newCoexistence(server)
+	if c == nil {
+		return
+	}
+	if err := c.setup(lc.IP, lc.Port); err != nil {
+		mainLog.Load().Error().Err(err).Msgf("could not configure %s to forward queries to ctrld", server)
+		return
+	}
+	mainLog.Load().Notice().Msgf("%s is configured to forward queries to ctrld", server)
+}
+
+// revertCoexistence restores config of DNS servers reconfigured by setupCoexistence.
+func revertCoexistence() {
+	for _, server := range []string{dnsServerDnsmasq, dnsServerPihole} {
+		if err := newCoexistence(server).revert(); err != nil {
+			mainLog.Load().Warn().Err(err).Msgf("could not restore %s config", server)
+		}
+	}
+}
+
+// dnsmasqCoexistence forwards dnsmasq queries to ctrld using a config file in dnsmasq config directory.
+type dnsmasqCoexistence struct {
+	confPath string
+	restart  func() error
+}
+
+func (d *dnsmasqCoexistence) setup(ip string, port int) error {
+	content, err := dnsmasq.ConfTmpl(dnsmasq.ConfigContentTmpl, &ctrld.Config{
+		Listener: map[string]*ctrld.ListenerConfig{"0": {IP: ip, Port: port}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d.confPath, []byte(content), 0644); err != nil {
+		return err
+	}
+	return d.restart()
+}
+
+func (d *dnsmasqCoexistence) revert() error {
+	buf, err := os.ReadFile(d.confPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Never remove config files which were not generated by ctrld.
+	if !strings.HasPrefix(string(buf), dnsmasq.CtrldMarker) {
+		return nil
+	}
+	if err := os.Remove(d.confPath); err != nil {
+		return err
+	}
+	return d.restart()
+}
+
+// restartDnsmasq restarts dnsmasq service, so config changes take effect.
+func restartDnsmasq() error {
+	if out, err := exec.Command("systemctl", "restart", "dnsmasq").CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl restart dnsmasq: %w: %s", err, string(out))
+	}
+	return nil
+}
+
+// piholeCoexistence forwards Pi-hole queries to ctrld by replacing its upstreams. The original
+// upstreams are kept in a state file, so they could be restored.
+type piholeCoexistence struct {
+	statePath string
+}
+
+func (ph *piholeCoexistence) setup(ip string, port int) error {
+	// Keep the original upstreams only once, so they are not overwritten by ctrld ones after restarting.
+	if _, err := os.Stat(ph.statePath); errors.Is(err, os.ErrNotExist) {
+		out, err := exec.Command("pihole-FTL", "--config", "dns.upstreams").Output()
+		if err != nil {
+			return fmt.Errorf("could not get Pi-hole upstreams: %w", err)
+		}
+		upstreams := parsePiholeUpstreams(string(out))
+		buf, err := json.Marshal(upstreams)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(ph.statePath, buf, 0600); err != nil {
+			return err
+		}
+	}
+	return setPiholeUpstreams([]string{ip + "#" + strconv.Itoa(port)})
+}
+
+func (ph *piholeCoexistence) revert() error {
+	buf, err := os.ReadFile(ph.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var upstreams []string
+	if err := json.Unmarshal(buf, &upstreams); err != nil {
+		return err
+	}
+	if err := setPiholeUpstreams(upstreams); err != nil {
+		return err
+	}
+	return os.Remove(ph.statePath)
+}
+
+// parsePiholeUpstreams parses the output of "pihole-FTL --config dns.upstreams", e.g: "[ 8.8.8.8, 8.8.4.4 ]".
+func parsePiholeUpstreams(s string) []string {
+	s = strings.Trim(strings.TrimSpace(s), "[]")
+	upstreams := []string{}
+	for _, upstream := range strings.Split(s, ",") {
+		if upstream = strings.Trim(strings.TrimSpace(upstream), `"`); upstream != "" {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams
+}
+
+// setPiholeUpstreams sets Pi-hole upstreams, Pi-hole applies the change without restarting.
+func setPiholeUpstreams(upstreams []string) error {
+	buf, err := json.Marshal(upstreams)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("pihole-FTL", "--config", "dns.upstreams", string(buf)).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set Pi-hole upstreams: %w: %s", err, string(out))
+	}
+	return nil
+}
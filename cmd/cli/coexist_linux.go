@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// port53Owner returns the DNS server listening on port 53, or empty if there's none.
+func port53Owner() string {
+	inodes := make(map[string]bool)
+	for _, name := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		f, err := os.Open(filepath.Join("/proc/net", name))
+		if err != nil {
+			continue
+		}
+		for _, inode := range listeningSocketInodes(f, 53) {
+			inodes[inode] = true
+		}
+		f.Close()
+	}
+	if len(inodes) == 0 {
+		return ""
+	}
+	procDirs, _ := filepath.Glob("/proc/[0-9]*")
+	self := strconv.Itoa(os.Getpid())
+	for _, dir := range procDirs {
+		if filepath.Base(dir) == self {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, ok := strings.CutPrefix(link, "socket:[")
+			if !ok || !inodes[strings.TrimSuffix(inode, "]")] {
+				continue
+			}
+			comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+			if err != nil {
+				return ""
+			}
+			return dnsServerFromComm(strings.TrimSpace(string(comm)))
+		}
+	}
+	return ""
+}
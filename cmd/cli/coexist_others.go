@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cli
+
+// port53Owner returns the DNS server listening on port 53, it could only be identified on Linux.
+func port53Owner() string {
+	return ""
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dnsServerFromComm(t *testing.T) {
+	assert.Equal(t, dnsServerDnsmasq, dnsServerFromComm("dnsmasq"))
+	assert.Equal(t, dnsServerPihole, dnsServerFromComm("pihole-FTL"))
+	assert.Equal(t, dnsServerResolved, dnsServerFromComm("systemd-resolve"))
+	assert.Equal(t, "unbound", dnsServerFromComm("unbound"))
+}
+
+func Test_listeningSocketInodes(t *testing.T) {
+	procNet := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 3500007F:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000   991        0 20931 1 0000000000000000 100 0 0 10 5
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 18734 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:0035 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 43210 1 0000000000000000 20 4 30 10 -1
+   3: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 20930 2 0000000000000000 0
+`
+	assert.Equal(t, []string{"20931", "20930"}, listeningSocketInodes(strings.NewReader(procNet), 53))
+	assert.Nil(t, listeningSocketInodes(strings.NewReader(procNet), 5354))
+}
+
+func Test_parsePiholeUpstreams(t *testing.T) {
+	assert.Equal(t, []string{"8.8.8.8", "8.8.4.4#53"}, parsePiholeUpstreams("[ 8.8.8.8, 8.8.4.4#53 ]\n"))
+	assert.Equal(t, []string{"1.1.1.1"}, parsePiholeUpstreams(`["1.1.1.1"]`))
+	assert.Equal(t, []string{}, parsePiholeUpstreams("[]"))
+}
+
+func Test_dnsmasqCoexistence(t *testing.T) {
+	restarts := 0
+	d := &dnsmasqCoexistence{
+		confPath: filepath.Join(t.TempDir(), "ctrld.conf"),
+		restart:  func() error { restarts++; return nil },
+	}
+	require.NoError(t, d.revert())
+	assert.Zero(t, restarts)
+
+	require.NoError(t, d.setup("127.0.0.1", coexistPort))
+	buf, err := os.ReadFile(d.confPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), "server=127.0.0.1#5354")
+	assert.Equal(t, 1, restarts)
+
+	require.NoError(t, d.revert())
+	assert.NoFileExists(t, d.confPath)
+	assert.Equal(t, 2, restarts)
+
+	// Config files not generated by ctrld are kept.
+	require.NoError(t, os.WriteFile(d.confPath, []byte("server=8.8.8.8\n"), 0644))
+	require.NoError(t, d.revert())
+	assert.FileExists(t, d.confPath)
+	assert.Equal(t, 2, restarts)
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Control-D-Inc/ctrld/internal/clientinfo"
+)
+
+// completionTimeout is the maximum time waiting for the running ctrld service, so shell
+// completion does not hang if the service is stuck.
+const completionTimeout = 2 * time.Second
+
+// completionFunc is the cobra function for completing arguments and flag values.
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// daemonCompletion returns a completionFunc, which completes with the values fetched from
+// control server path of the running ctrld service. Values which were already given as
+// arguments are skipped, and at most maxArgs arguments are completed, unlimited if zero.
+//
+// Nothing is completed if ctrld is not running, or the control socket is not accessible,
+// e.g: without root privilege, since errors could not be shown while completing.
+func daemonCompletion(path string, maxArgs int, values func(dec *json.Decoder) ([]string, error)) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if maxArgs > 0 && len(args) >= maxArgs {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		dir, err := socketDir()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+		cc.c.Timeout = completionTimeout
+		resp, err := cc.post(path, nil)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		all, err := values(json.NewDecoder(resp.Body))
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return filterCompletions(all, args, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// filterCompletions returns unique values which have prefix toComplete, and are not in args.
+func filterCompletions(values, args []string, toComplete string) []string {
+	completions := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.HasPrefix(v, toComplete) && !slices.Contains(args, v) && !slices.Contains(completions, v) {
+			completions = append(completions, v)
+		}
+	}
+	return completions
+}
+
+// completeCdProfiles completes Control D profile names.
+func completeCdProfiles(maxArgs int) completionFunc {
+	return daemonCompletion(cdProfilesPath, maxArgs, func(dec *json.Decoder) ([]string, error) {
+		var profiles []cdProfile
+		if err := dec.Decode(&profiles); err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(profiles))
+		for _, p := range profiles {
+			names = append(names, p.Name)
+		}
+		return names, nil
+	})
+}
+
+// completeClients completes client hostnames and IP addresses.
+func completeClients(maxArgs int) completionFunc {
+	return daemonCompletion(listClientsPath, maxArgs, func(dec *json.Decoder) ([]string, error) {
+		var clients []*clientinfo.Client
+		if err := dec.Decode(&clients); err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(clients))
+		for _, c := range clients {
+			if c.Hostname != "" {
+				names = append(names, c.Hostname)
+			}
+		}
+		for _, c := range clients {
+			names = append(names, c.IP.String())
+		}
+		return names, nil
+	})
+}
+
+// completeCachedDomains completes domains which have cached answers.
+func completeCachedDomains(maxArgs int) completionFunc {
+	return daemonCompletion(cacheDomainsPath, maxArgs, func(dec *json.Decoder) ([]string, error) {
+		var domains []string
+		err := dec.Decode(&domains)
+		return domains, err
+	})
+}
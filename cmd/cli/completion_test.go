@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld/internal/clientinfo"
+)
+
+func Test_filterCompletions(t *testing.T) {
+	values := []string{"example.com", "example.org", "foo.com", "example.com"}
+	assert.Equal(t, []string{"example.com", "example.org"}, filterCompletions(values, nil, "ex"))
+	assert.Equal(t, []string{"example.org"}, filterCompletions(values, []string{"example.com"}, "ex"))
+	assert.Equal(t, []string{"example.com", "example.org", "foo.com"}, filterCompletions(values, nil, ""))
+	assert.Empty(t, filterCompletions(values, nil, "bar"))
+}
+
+func Test_matchClient(t *testing.T) {
+	c := &clientinfo.Client{IP: netip.MustParseAddr("192.168.1.10"), Mac: "aa:bb:cc:dd:ee:ff", Hostname: "Laptop"}
+	assert.True(t, matchClient(c, "192.168.1.10"))
+	assert.True(t, matchClient(c, "AA:BB:CC:DD:EE:FF"))
+	assert.True(t, matchClient(c, "laptop"))
+	assert.False(t, matchClient(c, "192.168.1.1"))
+	assert.False(t, matchClient(&clientinfo.Client{IP: netip.MustParseAddr("192.168.1.1")}, ""))
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/viper"
+)
+
+const (
+	// configKeySecretName is the name of the secret storing the config encryption key.
+	configKeySecretName = "config_key"
+	// configKeySize is the size of the config encryption key, for AES-256.
+	configKeySize = 32
+	// encryptedConfigKey is the config key containing the encrypted config.
+	encryptedConfigKey = "encrypted_config"
+	// encryptedConfigHeader is written at the beginning of encrypted config files.
+	encryptedConfigHeader = `# Encrypted ctrld config, use "ctrld config decrypt" to view or edit it.`
+)
+
+// configEncrypted reports whether the config file in use is encrypted,
+// so it's kept encrypted when ctrld re-writes it.
+var configEncrypted bool
+
+// encryptedConfig is the content of an encrypted config file. It's a valid TOML file,
+// so it could be found and read like plaintext config files.
+type encryptedConfig struct {
+	EncryptedConfig string `toml:"encrypted_config"`
+}
+
+// configKey returns the config encryption key, stored in the secret store. If there's no key
+// and create is true, a new one is generated.
+func configKey(store secretStore, create bool) ([]byte, error) {
+	if store == nil {
+		return nil, errSecretStoreUnsupported
+	}
+	if s, err := store.get(configKeySecretName); err == nil && s != "" {
+		key, err := hex.DecodeString(s)
+		if err != nil || len(key) != configKeySize {
+			return nil, errors.New("invalid config encryption key")
+		}
+		return key, nil
+	} else if !create {
+		return nil, fmt.Errorf("could not read config encryption key: %w", err)
+	}
+	key := make([]byte, configKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := store.set(configKeySecretName, hex.EncodeToString(key)); err != nil {
+		return nil, fmt.Errorf("could not store config encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// encryptedConfigBlob returns the encrypted config in content, or empty string if content is
+// not an encrypted config.
+func encryptedConfigBlob(content []byte) string {
+	var ec encryptedConfig
+	if err := toml.Unmarshal(content, &ec); err != nil {
+		return ""
+	}
+	return ec.EncryptedConfig
+}
+
+// encryptConfig returns the encrypted config file content of plaintext config content.
+func encryptConfig(key, content []byte) ([]byte, error) {
+	gcm, err := configCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, content, nil)
+	var buf bytes.Buffer
+	buf.WriteString(encryptedConfigHeader + "\n")
+	if err := toml.NewEncoder(&buf).Encode(encryptedConfig{EncryptedConfig: base64.StdEncoding.EncodeToString(sealed)}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptConfig returns the plaintext config content of encrypted config blob.
+func decryptConfig(key []byte, blob string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted config: %w", err)
+	}
+	gcm, err := configCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("invalid encrypted config: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	content, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt config, it may be encrypted on another machine: %w", err)
+	}
+	return content, nil
+}
+
+// configCipher returns the AES-GCM cipher of config encryption key.
+func configCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readInConfig reads in config file like v.ReadInConfig, decrypting it if it's encrypted.
+func readInConfig(v *viper.Viper) error {
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	blob := v.GetString(encryptedConfigKey)
+	configEncrypted = blob != ""
+	if !configEncrypted {
+		return nil
+	}
+	store, _ := newSecretStore()
+	key, err := configKey(store, false)
+	if err != nil {
+		return err
+	}
+	content, err := decryptConfig(key, blob)
+	if err != nil {
+		return err
+	}
+	return v.ReadConfig(bytes.NewReader(content))
+}
+
+// maybeEncryptConfig returns config file content to be written, encrypted if the config file in use is encrypted.
+func maybeEncryptConfig(content []byte) ([]byte, error) {
+	if !configEncrypted {
+		return content, nil
+	}
+	store, _ := newSecretStore()
+	key, err := configKey(store, false)
+	if err != nil {
+		return nil, err
+	}
+	return encryptConfig(key, content)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_configKey(t *testing.T) {
+	store := memSecretStore{}
+	_, err := configKey(store, false)
+	assert.Error(t, err)
+
+	key, err := configKey(store, true)
+	require.NoError(t, err)
+	assert.Len(t, key, configKeySize)
+
+	got, err := configKey(store, false)
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	store[configKeySecretName] = "invalid"
+	_, err = configKey(store, true)
+	assert.Error(t, err)
+
+	_, err = configKey(nil, true)
+	assert.ErrorIs(t, err, errSecretStoreUnsupported)
+}
+
+func Test_encryptConfig(t *testing.T) {
+	store := memSecretStore{}
+	key, err := configKey(store, true)
+	require.NoError(t, err)
+
+	content := []byte("[upstream.0]\n  endpoint = \"https://dns.example.com/dns-query\"\n")
+	assert.Empty(t, encryptedConfigBlob(content))
+
+	encrypted, err := encryptConfig(key, content)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "dns.example.com")
+	blob := encryptedConfigBlob(encrypted)
+	require.NotEmpty(t, blob)
+
+	decrypted, err := decryptConfig(key, blob)
+	require.NoError(t, err)
+	assert.Equal(t, content, decrypted)
+
+	otherKey, err := configKey(memSecretStore{}, true)
+	require.NoError(t, err)
+	_, err = decryptConfig(otherKey, blob)
+	assert.Error(t, err)
+
+	_, err = decryptConfig(key, "not-base64!")
+	assert.Error(t, err)
+}
@@ -5,15 +5,18 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
 type controlClient struct {
-	c *http.Client
+	c    *http.Client
+	addr string
 }
 
 func newControlClient(addr string) *controlClient {
-	return &controlClient{c: &http.Client{
+	return &controlClient{addr: addr, c: &http.Client{
 		Transport: &http.Transport{
 			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
 				d := net.Dialer{}
@@ -25,7 +28,16 @@ func newControlClient(addr string) *controlClient {
 }
 
 func (c *controlClient) post(path string, data io.Reader) (*http.Response, error) {
-	return c.c.Post("http://unix"+path, contentTypeJson, data)
+	req, err := http.NewRequest(http.MethodPost, "http://unix"+path, data)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentTypeJson)
+	// The token is read for every request, since it is re-generated when ctrld restarts.
+	if token, err := os.ReadFile(controlTokenFile(c.addr)); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return c.c.Do(req)
 }
 
 // deactivationRequest represents request for validating deactivation pin.
@@ -2,16 +2,25 @@ package cli
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kardianos/service"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
 
 	"github.com/Control-D-Inc/ctrld"
 	"github.com/Control-D-Inc/ctrld/internal/controld"
@@ -25,39 +34,74 @@ const (
 	deactivationPath = "/deactivation"
 	cdPath           = "/cd"
 	ifacePath        = "/iface"
+	latencyPath      = "/upstreams/latency"
+	upstreamCertPath = "/upstreams/certs"
+	resourcesPath    = "/resources"
+	logLevelPath     = "/log/level"
+	cdProfilesPath   = "/cd/profiles"
+	cdSwitchPath     = "/cd/switch"
+	dnsEventsPath    = "/dns/events"
+	logSearchPath    = "/log/search"
+	recentLogsPath   = "/log/recent"
+	reportPath       = "/report"
+	logExportPath    = "/log/export"
+	cacheDomainsPath = "/cache/domains"
+	cacheFlushPath   = "/cache/flush"
 )
 
 type controlServer struct {
 	server *http.Server
 	mux    *http.ServeMux
 	addr   string
+	token  string
 }
 
 func newControlServer(addr string) (*controlServer, error) {
 	mux := http.NewServeMux()
 	s := &controlServer{
-		server: &http.Server{Handler: mux},
-		mux:    mux,
+		mux: mux,
 	}
+	s.server = &http.Server{Handler: s.authenticate(mux)}
 	s.addr = addr
 	return s, nil
 }
 
 func (s *controlServer) start() error {
 	_ = os.Remove(s.addr)
-	unixListener, err := net.Listen("unix", s.addr)
-	if l, ok := unixListener.(*net.UnixListener); ok {
-		l.SetUnlinkOnClose(true)
+	// On mobile, the socket lives in app sandbox, which is not accessible by other apps.
+	if !isMobile() {
+		token, err := newControlToken()
+		if err != nil {
+			return fmt.Errorf("could not generate control token: %w", err)
+		}
+		if err := writePrivateFile(controlTokenFile(s.addr), []byte(token)); err != nil {
+			return fmt.Errorf("could not write control token: %w", err)
+		}
+		s.token = token
+	}
+	// The control server only listens on a local unix socket, it is never exposed to the network,
+	// so remote administration is done through Control D API instead.
+	var unixListener net.Listener
+	var err error
+	if isMobile() {
+		unixListener, err = net.Listen("unix", s.addr)
+	} else {
+		// Only the owner of ctrld process is allowed to connect to the socket.
+		unixListener, err = listenControlSocket(s.addr)
 	}
 	if err != nil {
 		return err
 	}
+	if l, ok := unixListener.(*net.UnixListener); ok {
+		l.SetUnlinkOnClose(true)
+	}
 	go s.server.Serve(unixListener)
 	return nil
 }
 
 func (s *controlServer) stop() error {
 	_ = os.Remove(s.addr)
+	_ = os.Remove(controlTokenFile(s.addr))
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 	defer cancel()
 	return s.server.Shutdown(ctx)
@@ -67,6 +111,36 @@ func (s *controlServer) register(pattern string, handler http.Handler) {
 	s.mux.Handle(pattern, jsonResponse(handler))
 }
 
+// authenticate rejects requests which do not carry the control token.
+func (s *controlServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newControlToken generates a random token for authenticating control server requests.
+func newControlToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// controlTokenFile returns the path to the file storing control token of the control socket addr.
+// The file is only readable by the owner of ctrld process, so only authorized users could issue
+// commands to the control server.
+func controlTokenFile(addr string) string {
+	return strings.TrimSuffix(addr, filepath.Ext(addr)) + ".token"
+}
+
 func (p *prog) registerControlServerHandler() {
 	p.cs.register(listClientsPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
 		clients := p.ciTable.ListClients()
@@ -152,29 +226,31 @@ func (p *prog) registerControlServerHandler() {
 		w.WriteHeader(http.StatusOK)
 	}))
 	p.cs.register(deactivationPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
-		// Non-cd mode always allowing deactivation.
-		if cdUID == "" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		// Re-fetch pin code from API.
-		if rc, err := controld.FetchResolverConfig(cdUID, rootCmd.Version, cdDev); rc != nil {
-			if rc.DeactivationPin != nil {
-				cdDeactivationPin.Store(*rc.DeactivationPin)
+		if loadCdUID() != "" {
+			// Re-fetch pin code from API.
+			if rc, err := controld.FetchResolverConfig(loadCdUID(), rootCmd.Version, cdDev); rc != nil {
+				if rc.DeactivationPin != nil {
+					cdDeactivationPin.Store(*rc.DeactivationPin)
+				} else {
+					cdDeactivationPin.Store(defaultDeactivationPin)
+				}
 			} else {
-				cdDeactivationPin.Store(defaultDeactivationPin)
+				mainLog.Load().Warn().Err(err).Msg("could not re-fetch deactivation pin code")
 			}
-		} else {
-			mainLog.Load().Warn().Err(err).Msg("could not re-fetch deactivation pin code")
 		}
 
 		// If pin code not set, allowing deactivation.
-		if deactivationPinNotSet() {
+		pin := p.deactivationPin()
+		if pin == defaultDeactivationPin {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
+		if !p.pinThrottle.allow(time.Now()) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
 		var req deactivationRequest
 		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
 			w.WriteHeader(http.StatusPreconditionFailed)
@@ -184,22 +260,70 @@ func (p *prog) registerControlServerHandler() {
 
 		code := http.StatusForbidden
 		switch req.Pin {
-		case cdDeactivationPin.Load():
+		case pin:
 			code = http.StatusOK
+			p.pinThrottle.reset()
+			p.authorizeStop()
 		case defaultDeactivationPin:
 			// If the pin code was set, but users do not provide --pin, return proper code to client.
 			code = http.StatusBadRequest
+		default:
+			mainLog.Load().Warn().Msg("deactivation request with invalid pin code")
+			p.pinThrottle.fail(time.Now())
 		}
 		w.WriteHeader(code)
 	}))
 	p.cs.register(cdPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
-		if cdUID != "" {
+		if uid := loadCdUID(); uid != "" {
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(cdUID))
+			w.Write([]byte(uid))
 			return
 		}
 		w.WriteHeader(http.StatusBadRequest)
 	}))
+	p.cs.register(cdProfilesPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(cdProfileList()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(cdSwitchPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var req cdSwitchRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.switchCdProfile(req.Profile); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, errCdProfileNotFound) {
+				code = http.StatusNotFound
+			}
+			mainLog.Load().Err(err).Msg("could not switch Control D profile")
+			http.Error(w, err.Error(), code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	p.cs.register(cacheDomainsPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(p.cachedDomains()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(cacheFlushPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var req cacheFlushRequest
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := p.flushCache(req.Domains); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
 	p.cs.register(ifacePath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
 		// p.setDNS is only called when running as a service
 		if !service.Interactive() {
@@ -211,6 +335,132 @@ func (p *prog) registerControlServerHandler() {
 		}
 		w.WriteHeader(http.StatusBadRequest)
 	}))
+	p.cs.register(logLevelPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var req logLevelRequest
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Level != "" {
+			level, err := zerolog.ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var revertAfter time.Duration
+			if req.RevertAfter != "" {
+				revertAfter, err = time.ParseDuration(req.RevertAfter)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			p.logLevel.set(level, revertAfter)
+		}
+		if err := json.NewEncoder(w).Encode(p.logLevel.current()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(latencyPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(p.ul.stats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(upstreamCertPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(p.upstreamCerts.stats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(resourcesPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(p.resourceStats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(logSearchPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var f queryLogFilter
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := p.queryLog.search(f)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, errQueryLogSearchUnsupported) {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(logExportPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var req logExportRequest
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		// Entries are streamed as JSON lines, the query log could be too large to be kept in memory.
+		enc := json.NewEncoder(w)
+		written := false
+		err := p.queryLog.read(req.Since, req.Until, func(e queryLogEntry) error {
+			written = true
+			return enc.Encode(e)
+		})
+		switch {
+		case err == nil:
+		case errors.Is(err, errQueryLogReadUnsupported):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case !written:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			mainLog.Load().Warn().Err(err).Msg("could not export query log")
+		}
+	}))
+	p.cs.register(reportPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		var req queryReportRequest
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		r, err := p.report(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(recentLogsPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(recentLogs.recent()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.cs.register(dnsEventsPath, http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(p.dnsEvents.list()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+	p.registerHealthHandlers(p.cs.register)
+	p.registerDebugHandlers()
 }
 
 func jsonResponse(next http.Handler) http.Handler {
@@ -219,3 +469,49 @@ func jsonResponse(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+const (
+	// pinFreeAttempts is the number of invalid deactivation pin attempts allowed before throttling.
+	pinFreeAttempts = 3
+	// pinBaseLockout is the lockout after the first throttled attempt, doubled for each further one.
+	pinBaseLockout = 5 * time.Second
+	// pinMaxLockout caps the lockout duration.
+	pinMaxLockout = 15 * time.Minute
+)
+
+// pinThrottle slows down brute forcing the deactivation pin over the control socket.
+type pinThrottle struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+}
+
+// allow reports whether a deactivation pin attempt is allowed at the given time.
+func (t *pinThrottle) allow(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !now.Before(t.until)
+}
+
+// fail records an invalid attempt, locking out further attempts once pinFreeAttempts is reached.
+func (t *pinThrottle) fail(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures++
+	if t.failures < pinFreeAttempts {
+		return
+	}
+	lockout := pinMaxLockout
+	if shift := t.failures - pinFreeAttempts; shift < 16 {
+		lockout = min(pinBaseLockout<<shift, pinMaxLockout)
+	}
+	t.until = now.Add(lockout)
+}
+
+// reset clears recorded invalid attempts.
+func (t *pinThrottle) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = 0
+	t.until = time.Time{}
+}
@@ -5,7 +5,11 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"runtime"
 	"testing"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
 )
 
 func TestControlServer(t *testing.T) {
@@ -29,6 +33,16 @@ func TestControlServer(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			t.Fatalf("control socket is accessible by others: %v", perm)
+		}
+	}
+
 	c := newControlClient(f.Name())
 	resp, err := c.post(pattern, nil)
 	if err != nil {
@@ -48,7 +62,97 @@ func TestControlServer(t *testing.T) {
 	if !bytes.Equal(buf, respBody) {
 		t.Errorf("unexpected response body, want: %q, got: %q", string(respBody), string(buf))
 	}
+
+	// Requests without valid token must be rejected.
+	if err := os.WriteFile(controlTokenFile(f.Name()), []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	unauthorizedResp, err := c.post(pattern, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unauthorizedResp.Body.Close()
+	if unauthorizedResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected response code for unauthorized request: %d", unauthorizedResp.StatusCode)
+	}
 	if err := s.stop(); err != nil {
 		t.Fatal(err)
 	}
 }
+
+func Test_restartDeactivationPin(t *testing.T) {
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	pin := int64(1234)
+	p := &prog{cfg: &ctrld.Config{}}
+	p.cfg.Service.DeactivationPin = &pin
+	p.cs, err = newControlServer(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.registerControlServerHandler()
+	if err := p.cs.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.cs.stop()
+	cc := newControlClient(f.Name())
+
+	// Restart without pin is rejected before stopping the service.
+	if err := postDeactivationPin(cc, defaultDeactivationPin); err != errRequiredDeactivationPin {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := postDeactivationPin(cc, pin+1); err != errInvalidDeactivationPin {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.unauthorizedStop() {
+		t.Fatal("stop must not be authorized without valid pin")
+	}
+
+	// Restart with valid pin authorizes the stop, so it is not reported as failure.
+	if err := postDeactivationPin(cc, pin); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.unauthorizedStop() {
+		t.Fatal("stop must be authorized after restart with valid pin")
+	}
+}
+
+func Test_pinThrottle(t *testing.T) {
+	var pt pinThrottle
+	now := time.Now()
+	for i := 0; i < pinFreeAttempts-1; i++ {
+		pt.fail(now)
+		if !pt.allow(now) {
+			t.Fatalf("attempt %d should not be throttled", i+1)
+		}
+	}
+	pt.fail(now)
+	if pt.allow(now) {
+		t.Fatal("attempt should be throttled")
+	}
+	if !pt.allow(now.Add(pinBaseLockout)) {
+		t.Fatal("attempt should be allowed after lockout")
+	}
+
+	// Lockout grows with further failures, up to pinMaxLockout.
+	pt.fail(now)
+	if pt.allow(now.Add(pinBaseLockout)) {
+		t.Fatal("lockout should be doubled")
+	}
+	for i := 0; i < 100; i++ {
+		pt.fail(now)
+	}
+	if !pt.allow(now.Add(pinMaxLockout)) {
+		t.Fatal("lockout should be capped")
+	}
+
+	pt.reset()
+	if !pt.allow(now) {
+		t.Fatal("attempt should be allowed after reset")
+	}
+}
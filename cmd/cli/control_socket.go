@@ -0,0 +1,16 @@
+//go:build !windows
+
+package cli
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenControlSocket listens on the unix socket addr, which is only accessible by the owner of ctrld process.
+// The umask is set before the socket is created, so it is never accessible by others, even briefly.
+func listenControlSocket(addr string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", addr)
+}
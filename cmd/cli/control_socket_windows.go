@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// listenControlSocket listens on the unix socket addr, which is only accessible by LocalSystem and Administrators.
+// File modes are ignored on Windows, so an explicit ACL is applied to the socket file instead. Until then, the
+// socket is protected by the control token, which is created with the same ACL.
+func listenControlSocket(addr string) (net.Listener, error) {
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := windows.SecurityDescriptorFromString(privateFileSDDL)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	secInfo := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION | windows.PROTECTED_DACL_SECURITY_INFORMATION)
+	if err := windows.SetNamedSecurityInfo(addr, windows.SE_FILE_OBJECT, secInfo, nil, nil, dacl, nil); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
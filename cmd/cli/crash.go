@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// crashReportPrefix is the prefix of crash report file names, stored in ctrld home dir.
+	crashReportPrefix = "crash-"
+	// crashReportMaxFiles is the maximum number of crash reports kept, older ones are removed.
+	crashReportMaxFiles = 5
+)
+
+// recoverCrash writes a crash report if the current goroutine is panicking, then panics again,
+// so ctrld is still terminated, and restarted by the service manager. It must be called directly
+// by a deferred function.
+func recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if path, err := writeCrashReport(crashReportDir(), r, debug.Stack(), time.Now()); err != nil {
+		mainLog.Load().Error().Err(err).Msg("could not write crash report")
+	} else {
+		mainLog.Load().Error().Msgf("ctrld crashed, crash report was written to: %s", path)
+	}
+	panic(r)
+}
+
+// writeCrashReport writes the crash report of panic value r to dir, returning the crash report path.
+func writeCrashReport(dir string, r any, stack []byte, now time.Time) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&buf, "version: %s\n", curVersion())
+	fmt.Fprintf(&buf, "os: %s\n", osVersion())
+	fmt.Fprintf(&buf, "config: %s\n", configFingerprint())
+	fmt.Fprintf(&buf, "panic: %v\n\n", r)
+	buf.WriteString("stack:\n")
+	buf.Write(stack)
+	buf.WriteString("\nrecent logs:\n")
+	for _, line := range recentLogs.recent().Logs {
+		buf.WriteString(line + "\n")
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, crashReportPrefix+now.Format("20060102-150405")+".log")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	pruneCrashReports(dir)
+	return path, nil
+}
+
+// crashReportDir returns the directory storing crash reports, which is ctrld home dir.
+func crashReportDir() string {
+	return absHomeDir("")
+}
+
+// crashReports returns crash reports in dir, oldest first.
+func crashReports(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, crashReportPrefix+"*.log"))
+	// File names contain crash time, so sorting them sorts by crash time.
+	slices.Sort(files)
+	return files
+}
+
+// pruneCrashReports removes old crash reports in dir, keeping crashReportMaxFiles most recent ones.
+func pruneCrashReports(dir string) {
+	files := crashReports(dir)
+	for len(files) > crashReportMaxFiles {
+		_ = os.Remove(files[0])
+		files = files[1:]
+	}
+}
+
+// configFingerprint returns the path and sha256 checksum of config file in use, so config changes
+// could be detected without including the config content.
+func configFingerprint() string {
+	path := v.ConfigFileUsed()
+	if path == "" {
+		return "none"
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Sprintf("%s (%s)", path, strings.TrimSpace(err.Error()))
+	}
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("%s (sha256:%s)", path, hex.EncodeToString(sum[:]))
+}
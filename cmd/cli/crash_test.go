@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeCrashReport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < crashReportMaxFiles+2; i++ {
+		_, err := writeCrashReport(dir, "boom", []byte("goroutine 1 [running]:"), now.Add(time.Duration(i)*time.Second))
+		require.NoError(t, err)
+	}
+	files := crashReports(dir)
+	require.Len(t, files, crashReportMaxFiles)
+	// Oldest reports are removed.
+	assert.Equal(t, crashReportPrefix+"20240101-100002.log", filepath.Base(files[0]))
+
+	content, err := os.ReadFile(files[len(files)-1])
+	require.NoError(t, err)
+	assert.True(t, strings.Contains(string(content), "panic: boom"))
+	assert.True(t, strings.Contains(string(content), "goroutine 1 [running]:"))
+}
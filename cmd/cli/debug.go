@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+const (
+	debugPprofPath   = "/debug/pprof/"
+	debugRuntimePath = "/debug/runtime"
+)
+
+// processStartTime is the time ctrld process started, used for reporting uptime.
+var processStartTime = time.Now()
+
+// runtimeStats is the response of debugRuntimePath control server endpoint.
+type runtimeStats struct {
+	Version      string  `json:"version"`
+	GoVersion    string  `json:"go_version"`
+	Uptime       string  `json:"uptime"`
+	NumCPU       int     `json:"num_cpu"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	NumGoroutine int     `json:"num_goroutine"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	HeapInuse    uint64  `json:"heap_inuse"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	StackInuse   uint64  `json:"stack_inuse"`
+	Sys          uint64  `json:"sys"`
+	TotalAlloc   uint64  `json:"total_alloc"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotal   string  `json:"gc_pause_total"`
+	GCCPUPercent float64 `json:"gc_cpu_percent"`
+}
+
+// currentRuntimeStats returns runtime stats of ctrld process.
+func currentRuntimeStats() runtimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return runtimeStats{
+		Version:      curVersion(),
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(processStartTime).Round(time.Second).String(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		StackInuse:   ms.StackInuse,
+		Sys:          ms.Sys,
+		TotalAlloc:   ms.TotalAlloc,
+		NumGC:        ms.NumGC,
+		PauseTotal:   time.Duration(ms.PauseTotalNs).String(),
+		GCCPUPercent: ms.GCCPUFraction * 100,
+	}
+}
+
+// registerDebugHandlers registers pprof and runtime stats handlers to control server.
+// The handlers are only served if "debug_endpoints" is enabled in config.
+func (p *prog) registerDebugHandlers() {
+	// pprof handlers write non json responses, so they are not registered using p.cs.register.
+	p.cs.mux.Handle(debugPprofPath, p.debugOnly(http.HandlerFunc(pprof.Index)))
+	p.cs.mux.Handle(debugPprofPath+"cmdline", p.debugOnly(http.HandlerFunc(pprof.Cmdline)))
+	p.cs.mux.Handle(debugPprofPath+"profile", p.debugOnly(http.HandlerFunc(pprof.Profile)))
+	p.cs.mux.Handle(debugPprofPath+"symbol", p.debugOnly(http.HandlerFunc(pprof.Symbol)))
+	p.cs.mux.Handle(debugPprofPath+"trace", p.debugOnly(http.HandlerFunc(pprof.Trace)))
+	p.cs.register(debugRuntimePath, p.debugOnly(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if err := json.NewEncoder(w).Encode(currentRuntimeStats()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})))
+}
+
+// debugOnly returns a handler which serves next only if debug endpoints are enabled.
+func (p *prog) debugOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.debugEndpoints.Load() {
+			http.Error(w, `debug endpoints are disabled, set "debug_endpoints = true" in [service] config to enable`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
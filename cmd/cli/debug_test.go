@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_prog_debugOnly(t *testing.T) {
+	p := &prog{}
+	h := p.debugOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, debugRuntimePath, nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	p.debugEndpoints.Store(true)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, debugRuntimePath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func Test_currentRuntimeStats(t *testing.T) {
+	stats := currentRuntimeStats()
+	assert.Positive(t, stats.NumGoroutine)
+	assert.Positive(t, stats.GOMAXPROCS)
+	assert.Positive(t, stats.HeapAlloc)
+}
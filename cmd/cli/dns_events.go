@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// dnsEventsMaxSize is the maximum number of DNS events kept in memory.
+const dnsEventsMaxSize = 100
+
+const (
+	dnsEventChanged       = "changed"
+	dnsEventReapplied     = "reapplied"
+	dnsEventReapplyFailed = "reapply_failed"
+)
+
+// dnsEvent is an event of system DNS settings being changed by another program, or re-applied by ctrld.
+type dnsEvent struct {
+	Time        time.Time `json:"time"`
+	Iface       string    `json:"iface"`
+	Event       string    `json:"event"`
+	Nameservers []string  `json:"nameservers,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// dnsEventLog holds the most recent DNS events.
+type dnsEventLog struct {
+	mu     sync.Mutex
+	events []dnsEvent
+}
+
+// add logs the event, then records it, dropping the oldest event if the log is full.
+// If l is nil, the event is only logged.
+func (l *dnsEventLog) add(ev dnsEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	logger := mainLog.Load().With().Str("iface", ev.Iface).Logger()
+	switch ev.Event {
+	case dnsEventChanged:
+		logger.Warn().Msgf("DNS settings were changed by another program to: %v", ev.Nameservers)
+	case dnsEventReapplied:
+		logger.Notice().Msg("re-applied ctrld DNS settings")
+	case dnsEventReapplyFailed:
+		logger.Error().Msgf("could not re-apply ctrld DNS settings: %s", ev.Error)
+	}
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) >= dnsEventsMaxSize {
+		l.events = slices.Delete(l.events, 0, len(l.events)-dnsEventsMaxSize+1)
+	}
+	l.events = append(l.events, ev)
+}
+
+// list returns recorded events, oldest first.
+func (l *dnsEventLog) list() []dnsEvent {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.events)
+}
+
+// reapplied records the result of re-applying DNS settings of iface.
+func (l *dnsEventLog) reapplied(iface string, err error) {
+	if err != nil {
+		l.add(dnsEvent{Iface: iface, Event: dnsEventReapplyFailed, Error: err.Error()})
+		return
+	}
+	l.add(dnsEvent{Iface: iface, Event: dnsEventReapplied})
+}
+
+// dnsWatchdogGracePeriod returns the time duration DNS settings changed by another program are kept,
+// before ctrld re-applies its own settings.
+func (p *prog) dnsWatchdogGracePeriod() time.Duration {
+	if ptr := p.cfg.Service.DnsWatchdogGracePeriod; ptr != nil && *ptr > 0 {
+		return *ptr
+	}
+	return 0
+}
+
+// reassertDNS re-applies DNS settings of iface using setFn, if they were changed by another program
+// for longer than grace period. changedAt tracks the time when changes were detected for each interface.
+func (p *prog) reassertDNS(iface *net.Interface, ns []string, grace time.Duration, changedAt map[string]time.Time, setFn func(*net.Interface, []string) error) {
+	if !dnsChanged(iface, ns) {
+		delete(changedAt, iface.Name)
+		return
+	}
+	now := time.Now()
+	since, ok := changedAt[iface.Name]
+	if !ok {
+		since = now
+		changedAt[iface.Name] = now
+		cur, _ := currentStaticDNS(iface)
+		p.dnsEvents.add(dnsEvent{Time: now, Iface: iface.Name, Event: dnsEventChanged, Nameservers: cur})
+	}
+	if now.Sub(since) < grace {
+		return
+	}
+	delete(changedAt, iface.Name)
+	p.dnsEvents.reapplied(iface.Name, setFn(iface, ns))
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_dnsEventLog(t *testing.T) {
+	l := &dnsEventLog{}
+	l.add(dnsEvent{Iface: "en0", Event: dnsEventChanged, Nameservers: []string{"1.1.1.1"}})
+	l.reapplied("en0", nil)
+	l.reapplied("en0", errors.New("access denied"))
+
+	events := l.list()
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, dnsEventChanged, events[0].Event)
+		assert.Equal(t, []string{"1.1.1.1"}, events[0].Nameservers)
+		assert.False(t, events[0].Time.IsZero())
+		assert.Equal(t, dnsEventReapplied, events[1].Event)
+		assert.Equal(t, dnsEventReapplyFailed, events[2].Event)
+		assert.Equal(t, "access denied", events[2].Error)
+	}
+
+	// Oldest events are dropped.
+	for i := 0; i < dnsEventsMaxSize; i++ {
+		l.add(dnsEvent{Iface: strconv.Itoa(i), Event: dnsEventChanged})
+	}
+	events = l.list()
+	assert.Len(t, events, dnsEventsMaxSize)
+	assert.Equal(t, "0", events[0].Iface)
+	assert.Equal(t, strconv.Itoa(dnsEventsMaxSize-1), events[len(events)-1].Iface)
+
+	// Nil log is safe to use.
+	var nl *dnsEventLog
+	nl.add(dnsEvent{Iface: "en0", Event: dnsEventChanged})
+	assert.Nil(t, nl.list())
+}
+
+func Test_prog_dnsWatchdogGracePeriod(t *testing.T) {
+	p := &prog{cfg: &ctrld.Config{}}
+	assert.Equal(t, time.Duration(0), p.dnsWatchdogGracePeriod())
+
+	d := time.Minute
+	p.cfg.Service.DnsWatchdogGracePeriod = &d
+	assert.Equal(t, time.Minute, p.dnsWatchdogGracePeriod())
+
+	d = -time.Minute
+	assert.Equal(t, time.Duration(0), p.dnsWatchdogGracePeriod())
+}
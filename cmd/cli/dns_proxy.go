@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"cmp"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
@@ -23,6 +24,7 @@ import (
 	"github.com/Control-D-Inc/ctrld"
 	"github.com/Control-D-Inc/ctrld/internal/controld"
 	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+	"github.com/Control-D-Inc/ctrld/internal/dnspool"
 	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
 )
 
@@ -50,11 +52,18 @@ var privateUpstreamConfig = &ctrld.UpstreamConfig{
 	Timeout: 2000,
 }
 
+var bypassUpstreamConfig = &ctrld.UpstreamConfig{
+	Name:    "Network resolver",
+	Type:    ctrld.ResolverTypeBypass,
+	Timeout: 2000,
+}
+
 // proxyRequest contains data for proxying a DNS query to upstream.
 type proxyRequest struct {
 	msg            *dns.Msg
 	ci             *ctrld.ClientInfo
 	failoverRcodes []int
+	osResolver     string
 	ufr            *upstreamForResult
 }
 
@@ -78,29 +87,70 @@ type upstreamForResult struct {
 
 func (p *prog) serveDNS(listenerNum string) error {
 	listenerConfig := p.cfg.Listener[listenerNum]
-	// make sure ip is allocated
-	if allocErr := p.allocateIP(listenerConfig.IP); allocErr != nil {
-		mainLog.Load().Error().Err(allocErr).Str("ip", listenerConfig.IP).Msg("serveUDP: failed to allocate listen ip")
-		return allocErr
+	listenAddrs := listenerConfig.ListenAddrs()
+	// make sure ips are allocated
+	for _, addr := range listenAddrs {
+		ip, _, _ := net.SplitHostPort(addr)
+		if allocErr := p.allocateIP(ip); allocErr != nil {
+			mainLog.Load().Error().Err(allocErr).Str("ip", ip).Msg("serveUDP: failed to allocate listen ip")
+			return allocErr
+		}
 	}
 
-	handler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
-		p.sema.acquire()
-		defer p.sema.release()
+	vpnIfaces := newVPNInterfaces(listenerConfig)
+	rrl := newResponseRateLimiter(listenerConfig.RRL)
+	// Original destinations of DNS traffic redirected to the listener by firewall rules.
+	var dsts *origDsts
+	if p.interceptsDNS(listenerNum) {
+		dsts = newOrigDsts()
+	}
+	serve := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		defer recoverCrash()
 		if len(m.Question) == 0 {
 			answer := new(dns.Msg)
 			answer.SetRcode(m, dns.RcodeFormatError)
-			_ = w.WriteMsg(answer)
+			_ = writeMsg(w, answer)
 			return
 		}
 		listenerConfig := p.cfg.Listener[listenerNum]
+		if vpnIfaces.isVPNClient(w.RemoteAddr()) {
+			listenerConfig = vpnListenerConfig(listenerConfig)
+		}
 		reqId := requestID()
 		ctx := context.WithValue(context.Background(), ctrld.ReqIdCtxKey{}, reqId)
+		ctx, span := startSpan(ctx, "dns.query", spanKindServer,
+			stringAttr("dns.question.name", m.Question[0].Name),
+			stringAttr("dns.question.type", dns.TypeToString[m.Question[0].Qtype]),
+			stringAttr("client.address", w.RemoteAddr().String()),
+			stringAttr("ctrld.listener", listenerNum),
+		)
+		defer span.End()
+		// Only UDP responses are rate limited, since TCP clients could not spoof their addresses.
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			switch action := rrl.check(w.RemoteAddr()); action {
+			case rrlDrop:
+				statsResponsesRateLimited.WithLabelValues(action.String()).Inc()
+				return
+			case rrlSlip:
+				statsResponsesRateLimited.WithLabelValues(action.String()).Inc()
+				answer := new(dns.Msg)
+				answer.SetReply(m)
+				answer.Truncated = true
+				_ = writeMsg(w, answer)
+				return
+			}
+		}
 		if !listenerConfig.AllowWanClients && isWanClient(w.RemoteAddr()) {
 			ctrld.Log(ctx, mainLog.Load().Debug(), "query refused, listener does not allow WAN clients: %s", w.RemoteAddr().String())
-			answer := new(dns.Msg)
-			answer.SetRcode(m, dns.RcodeRefused)
-			_ = w.WriteMsg(answer)
+			answer := newErrorAnswer(m, dns.RcodeRefused, dns.ExtendedErrorCodeProhibited, "WAN clients are not allowed")
+			_ = writeMsg(w, answer)
+			return
+		}
+		if answer := refusedQueryTypeAnswer(m, listenerConfig); answer != nil {
+			qtype := dns.TypeToString[m.Question[0].Qtype]
+			ctrld.Log(ctx, mainLog.Load().Debug(), "%s query refused: %s", qtype, w.RemoteAddr().String())
+			statsQueriesRefused.WithLabelValues(qtype).Inc()
+			_ = writeMsg(w, answer)
 			return
 		}
 		go p.detectLoop(m)
@@ -118,12 +168,109 @@ func (p *prog) serveDNS(listenerNum string) error {
 		remoteIP, _, _ := net.SplitHostPort(w.RemoteAddr().String())
 		ci := p.getClientInfo(remoteIP, m)
 		ci.ClientIDPref = p.cfg.Service.ClientIDPref
+		if !p.anomaly.allow(ci.IP) {
+			ctrld.Log(ctx, mainLog.Load().Debug(), "query refused, client %s is rate limited", ci.IP)
+			answer := newErrorAnswer(m, dns.RcodeRefused, dns.ExtendedErrorCodeProhibited, "client is rate limited")
+			_ = writeMsg(w, answer)
+			return
+		}
+		// Only clients passing the listener checks are recorded.
+		if p.ciTable.RecordQuery(ci.IP) {
+			p.alerts.clientJoined(ci)
+		}
 		stripClientSubnet(m)
 		remoteAddr := spoofRemoteAddr(w.RemoteAddr(), ci)
 		fmtSrcToDest := fmtRemoteToLocal(listenerNum, ci.Hostname, remoteAddr.String())
+		if server := intendedServer(dsts, w.RemoteAddr(), listenerConfig); server != "" {
+			fmtSrcToDest += fmt.Sprintf(" (intended server: %s)", server)
+		}
 		t := time.Now()
 		ctrld.Log(ctx, mainLog.Load().Info(), "QUERY: %s: %s %s", fmtSrcToDest, dns.TypeToString[q.Qtype], domain)
-		ur := p.upstreamFor(ctx, listenerNum, listenerConfig, remoteAddr, ci.Mac, domain)
+		logEntry := queryLogEntry{
+			Time:     t,
+			ClientIP: ci.IP,
+			Mac:      ci.Mac,
+			Hostname: ci.Hostname,
+			Domain:   domain,
+			Qtype:    dns.TypeToString[q.Qtype],
+		}
+		if reason := p.canaryBlockReason(domain); reason != "" {
+			ctrld.Log(ctx, mainLog.Load().Debug(), "%s, answering NXDOMAIN for %s", reason, domain)
+			logEntry.Rcode, logEntry.Blocked = dns.RcodeToString[dns.RcodeNameError], "doh_canary"
+			p.recordQuery(logEntry)
+			answer := newErrorAnswer(m, dns.RcodeNameError, dns.ExtendedErrorCodeBlocked, reason)
+			_ = writeMsg(w, answer)
+			return
+		}
+		if feed := p.threatFeeds.lookup(domain); feed != "" {
+			ctrld.Log(ctx, mainLog.Load().Info(), "SECURITY BLOCK: %s: %s %s, feed: %s", fmtSrcToDest, dns.TypeToString[q.Qtype], domain, feed)
+			span.SetAttributes(stringAttr("ctrld.security_block.feed", feed))
+			go p.WithLabelValuesInc(statsSecurityBlocked, feed, ci.IP)
+			logEntry.Rcode, logEntry.Blocked = dns.RcodeToString[dns.RcodeNameError], "threat_feed"
+			logEntry.List = feed
+			p.recordQuery(logEntry)
+			answer := newErrorAnswer(m, dns.RcodeNameError, dns.ExtendedErrorCodeBlocked, "blocked by threat feed")
+			_ = writeMsg(w, answer)
+			return
+		}
+		pq := p.plugin.query(listenerNum, ci, domain, q.Qtype)
+		if reply := p.plugin.onQuery(ctx, pq); reply != nil {
+			if answer := pluginAnswer(m, reply); answer != nil {
+				ctrld.Log(ctx, mainLog.Load().Info(), "PLUGIN %s: %s: %s %s", strings.ToUpper(reply.Action), fmtSrcToDest, dns.TypeToString[q.Qtype], domain)
+				logEntry.Rcode, logEntry.Upstream = dns.RcodeToString[answer.Rcode], "plugin"
+				if reply.Action == pluginActionDeny {
+					logEntry.Blocked = "plugin"
+				}
+				p.recordQuery(logEntry)
+				_ = writeMsg(w, answer)
+				return
+			}
+		}
+		_, policySpan := startSpan(ctx, "dns.policy", spanKindInternal)
+		ur := p.upstreamFor(ctx, listenerNum, listenerConfig, remoteAddr, ci.Mac, domain, q.Qtype)
+		// Local records take precedence over special-use domains and policy blocks, since they are set explicitly.
+		localAnswer, rewrite := p.localRecords.answer(m)
+		var specialUseAnswer *dns.Msg
+		if zone, action := p.specialUseDomain(domain); action != "" && localAnswer == nil && rewrite == "" {
+			ctrld.Log(ctx, mainLog.Load().Debug(), "special-use domain %s, zone: %s, action: %s", domain, zone, action)
+			if strings.HasPrefix(action, upstreamPrefix) {
+				ur = &upstreamForResult{
+					upstreams:     []string{action},
+					matchedPolicy: "special-use domain",
+					matchedRule:   zone,
+					matched:       true,
+					srcAddr:       ur.srcAddr,
+				}
+			} else {
+				specialUseAnswer = p.specialUseDomainAnswer(ctx, m, zone, action)
+			}
+		}
+		// Queries for AD zones follow policy rules, unless domain controllers are configured explicitly.
+		if zone, upstreams := p.adZones.lookup(domain); zone != "" && (!ur.matched || len(upstreams) > 0) {
+			ur = &upstreamForResult{
+				upstreams:     upstreams,
+				matchedPolicy: "active directory",
+				matchedRule:   zone,
+				matched:       true,
+				srcAddr:       ur.srcAddr,
+			}
+		}
+		if zone, upstream := p.overlayDNS.upstreamFor(domain); upstream != "" && !ur.matched {
+			ur = &upstreamForResult{
+				upstreams:     []string{upstream},
+				matchedPolicy: "overlay network",
+				matchedRule:   zone,
+				matched:       true,
+				srcAddr:       ur.srcAddr,
+			}
+		}
+		policySpan.SetAttributes(
+			boolAttr("ctrld.policy.matched", ur.matched),
+			stringAttr("ctrld.policy.name", ur.matchedPolicy),
+			stringAttr("ctrld.policy.rule", ur.matchedRule),
+			stringSliceAttr("ctrld.upstreams", ur.upstreams),
+		)
+		policySpan.End()
 
 		labelValues := make([]string, 0, len(statsQueriesCountLabels))
 		labelValues = append(labelValues, net.JoinHostPort(listenerConfig.IP, strconv.Itoa(listenerConfig.Port)))
@@ -132,28 +279,70 @@ func (p *prog) serveDNS(listenerNum string) error {
 		labelValues = append(labelValues, ci.Hostname)
 
 		var answer *dns.Msg
+		upstream := ""
 		if !ur.matched && listenerConfig.Restricted {
 			ctrld.Log(ctx, mainLog.Load().Info(), "query refused, %s does not match any network policy", remoteAddr.String())
-			answer = new(dns.Msg)
-			answer.SetRcode(m, dns.RcodeRefused)
+			answer = newErrorAnswer(m, dns.RcodeRefused, dns.ExtendedErrorCodeProhibited, "client does not match any network policy")
 			labelValues = append(labelValues, "") // no upstream
+		} else if localAnswer != nil {
+			ctrld.Log(ctx, mainLog.Load().Info(), "LOCAL RECORD: %s: %s %s", fmtSrcToDest, dns.TypeToString[q.Qtype], domain)
+			answer = localAnswer
+			upstream = upstreamLocalRecords
+			labelValues = append(labelValues, upstream)
+		} else if specialUseAnswer != nil {
+			answer = specialUseAnswer
+			upstream = "special_use_domain"
+			labelValues = append(labelValues, upstream)
+		} else if ur.matched && len(ur.upstreams) > 0 && ur.upstreams[0] == upstreamBlock && rewrite == "" {
+			ctrld.Log(ctx, mainLog.Load().Info(), "POLICY BLOCK: %s: %s %s, policy: %s, rule: %s", fmtSrcToDest, dns.TypeToString[q.Qtype], domain, ur.matchedPolicy, ur.matchedRule)
+			answer = newErrorAnswer(m, dns.RcodeNameError, dns.ExtendedErrorCodeBlocked, "blocked by policy")
+			upstream = upstreamBlock
+			logEntry.Blocked = "policy"
+			logEntry.Rule, logEntry.List = ur.blockingRule()
+			labelValues = append(labelValues, upstream)
 		} else {
 			var failoverRcode []int
+			osResolver, dnssec := "", ""
 			if listenerConfig.Policy != nil {
 				failoverRcode = listenerConfig.Policy.FailoverRcodeNumbers
+				osResolver = listenerConfig.Policy.OsResolverFallback
+				dnssec = listenerConfig.Policy.DNSSEC
 			}
+			msg := m
+			cnameTarget := rewrite
+			if cnameTarget != "" {
+				ctrld.Log(ctx, mainLog.Load().Debug(), "local record, rewriting %s to %s", domain, cnameTarget)
+			} else if target := safeSearchTarget(domain, q.Qtype); target != "" && p.safeSearchEnabled(ci.IP) {
+				ctrld.Log(ctx, mainLog.Load().Debug(), "safe search enforced, rewriting %s to %s", domain, target)
+				cnameTarget = target
+			}
+			if cnameTarget != "" {
+				msg = safeSearchRequest(m, cnameTarget)
+			}
+			msg = dnssecRequest(msg, dnssec)
 			pr := p.proxy(ctx, &proxyRequest{
-				msg:            m,
+				msg:            msg,
 				ci:             ci,
 				failoverRcodes: failoverRcode,
+				osResolver:     osResolver,
 				ufr:            ur,
 			})
 			go p.doSelfUninstall(pr.answer)
 
 			answer = pr.answer
+			if cnameTarget != "" {
+				answer = safeSearchAnswer(m, answer, cnameTarget)
+			}
+			answer = dnssecAnswer(m, answer, dnssec)
+			if listenerConfig.Policy != nil && listenerConfig.Policy.StripECH {
+				if stripped, ok := stripECH(answer); ok {
+					ctrld.Log(ctx, mainLog.Load().Info(), "stripped ECH config from %s answer, policy: %s", domain, listenerConfig.Policy.Name)
+					answer = stripped
+				}
+			}
 			rtt := time.Since(t)
 			ctrld.Log(ctx, mainLog.Load().Debug(), "received response of %d bytes in %s", answer.Len(), rtt)
-			upstream := pr.upstream
+			upstream = pr.upstream
 			switch {
 			case pr.cached:
 				upstream = "cache"
@@ -161,26 +350,50 @@ func (p *prog) serveDNS(listenerNum string) error {
 				upstream = "client_info_table"
 			}
 			labelValues = append(labelValues, upstream)
+			span.SetAttributes(stringAttr("ctrld.upstream", upstream))
 		}
 		labelValues = append(labelValues, dns.TypeToString[q.Qtype])
 		labelValues = append(labelValues, dns.RcodeToString[answer.Rcode])
+		span.SetAttributes(stringAttr("dns.response.code", dns.RcodeToString[answer.Rcode]))
+		rcode := answer.Rcode
+		logEntry.Rcode = dns.RcodeToString[rcode]
+		logEntry.Upstream = upstream
+		logEntry.Duration = float64(time.Since(t).Microseconds()) / 1000
+		p.recordQuery(logEntry)
 		go func() {
 			p.WithLabelValuesInc(statsQueriesCount, labelValues...)
 			p.WithLabelValuesInc(statsClientQueriesCount, []string{ci.IP, ci.Mac, ci.Hostname}...)
+			p.anomaly.record(ci, domain, rcode)
 			p.forceFetchingAPI(domain)
 		}()
-		if err := w.WriteMsg(answer); err != nil {
+		p.plugin.onResponse(pq, answer, upstream)
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			answer = fitUDPAnswer(m, answer, listenerConfig.EDNSBufferSizeOrDefault())
+		}
+		if err := writeMsg(w, answer); err != nil {
 			ctrld.Log(ctx, mainLog.Load().Error().Err(err), "serveDNS: failed to send DNS response to client")
 		}
 	})
+	handler := &poolHandler{pool: p.queryPool, next: serve, reject: func(w dns.ResponseWriter, m *dns.Msg) {
+		statsQueriesDropped.Inc()
+		mainLog.Load().Debug().Msgf("too many queued queries, dropping query from: %s", w.RemoteAddr())
+		answer := newErrorAnswer(m, dns.RcodeServerFailure, dns.ExtendedErrorCodeOther, "too many queued queries")
+		_ = writeMsg(w, answer)
+	}}
 
 	g, ctx := errgroup.WithContext(context.Background())
+	if vpnIfaces != nil {
+		g.Go(func() error {
+			p.serveVPNInterfaces(ctx, vpnIfaces, listenerConfig, handler)
+			return nil
+		})
+	}
 	for _, proto := range []string{"udp", "tcp"} {
 		proto := proto
 		if needLocalIPv6Listener() {
 			g.Go(func() error {
 				s, errCh := runDNSServer(net.JoinHostPort("::1", strconv.Itoa(listenerConfig.Port)), proto, handler)
-				defer s.Shutdown()
+				defer p.shutdownDNSServer(s)
 				select {
 				case <-p.stopCh:
 				case <-ctx.Done():
@@ -200,7 +413,7 @@ func (p *prog) serveDNS(listenerNum string) error {
 					func() {
 						listenAddr := net.JoinHostPort(addr, strconv.Itoa(listenerConfig.Port))
 						s, errCh := runDNSServer(listenAddr, proto, handler)
-						defer s.Shutdown()
+						defer p.shutdownDNSServer(s)
 						select {
 						case <-p.stopCh:
 						case <-ctx.Done():
@@ -214,25 +427,84 @@ func (p *prog) serveDNS(listenerNum string) error {
 				return nil
 			})
 		}
-		g.Go(func() error {
-			addr := net.JoinHostPort(listenerConfig.IP, strconv.Itoa(listenerConfig.Port))
-			s, errCh := runDNSServer(addr, proto, handler)
-			defer s.Shutdown()
+		for n, addr := range listenAddrs {
+			addr, primary := addr, n == 0
+			g.Go(func() error {
+				sockets, batchSize := 1, 1
+				// Only original destinations of TCP connections could be recovered from NAT redirected traffic.
+				intercept := dsts != nil && (proto == "tcp" || p.tproxyEnabled())
+				if proto == "udp" && !intercept {
+					sockets = udpSockets(listenerConfig, addr)
+					batchSize = udpBatchSize(listenerConfig, addr)
+				}
+				servers := make([]*dns.Server, 0, sockets)
+				defer func() {
+					var wg sync.WaitGroup
+					for _, s := range servers {
+						wg.Add(1)
+						go func(s *dns.Server) {
+							defer wg.Done()
+							p.shutdownDNSServer(s)
+						}(s)
+					}
+					wg.Wait()
+				}()
+				errCh := make(chan error, sockets)
+				for i := 0; i < sockets; i++ {
+					var s *dns.Server
+					var sErrCh <-chan error
+					switch {
+					case intercept:
+						s, sErrCh = runInterceptDNSServer(addr, proto, handler, dsts, p.tproxyEnabled())
+					case sockets > 1 || batchSize > 1:
+						s, sErrCh = runUDPDNSServer(addr, handler, sockets > 1, batchSize)
+					case proto == "tcp" && listenerConfig.ProxyProtocol:
+						s, sErrCh = runProxyProtocolDNSServer(addr, handler, listenerConfig.ProxyProtocolTrusted)
+					default:
+						s, sErrCh = runDNSServer(addr, proto, handler)
+					}
+					servers = append(servers, s)
+					go func() {
+						if err := <-sErrCh; err != nil {
+							errCh <- err
+						}
+					}()
+				}
+				if sockets > 1 {
+					mainLog.Load().Debug().Msgf("listening on %s with %d udp sockets", addr, sockets)
+				}
 
-			p.started <- struct{}{}
+				// The listener is started once its primary address is served.
+				if primary {
+					p.started <- struct{}{}
+				}
 
-			select {
-			case <-p.stopCh:
-			case <-ctx.Done():
-			case err := <-errCh:
-				return err
-			}
-			return nil
-		})
+				select {
+				case <-p.stopCh:
+				case <-ctx.Done():
+				case err := <-errCh:
+					return err
+				}
+				return nil
+			})
+		}
 	}
 	return g.Wait()
 }
 
+// blockingRule returns the rule of the policy blocking the query, and the domain list it references, if any.
+// Blocking network or MAC rules are returned, if there's no matched domain rule.
+func (ur *upstreamForResult) blockingRule() (rule, list string) {
+	rule = ur.matchedRule
+	if rule == "no rule" {
+		rule = ur.matchedNetwork
+	}
+	if name, ok := strings.CutPrefix(rule, ctrld.DomainListPrefix); ok {
+		list = name
+	}
+	return rule, list
+}
+
 // upstreamFor returns the list of upstreams for resolving the given domain,
 // matching by policies defined in the listener config. The second return value
 // reports whether the domain matches the policy.
@@ -240,7 +512,7 @@ func (p *prog) serveDNS(listenerNum string) error {
 // Though domain policy has higher priority than network policy, it is still
 // processed later, because policy logging want to know whether a network rule
 // is disregarded in favor of the domain level rule.
-func (p *prog) upstreamFor(ctx context.Context, defaultUpstreamNum string, lc *ctrld.ListenerConfig, addr net.Addr, srcMac, domain string) (res *upstreamForResult) {
+func (p *prog) upstreamFor(ctx context.Context, defaultUpstreamNum string, lc *ctrld.ListenerConfig, addr net.Addr, srcMac, domain string, qtype uint16) (res *upstreamForResult) {
 	upstreams := []string{upstreamPrefix + defaultUpstreamNum}
 	matchedPolicy := "no policy"
 	matchedNetwork := "no network"
@@ -264,7 +536,6 @@ func (p *prog) upstreamFor(ctx context.Context, defaultUpstreamNum string, lc *c
 		upstreams = append([]string(nil), policyUpstreams...)
 	}
 
-	var networkTargets []string
 	var sourceIP net.IP
 	switch addr := addr.(type) {
 	case *net.UDPAddr:
@@ -273,6 +544,8 @@ func (p *prog) upstreamFor(ctx context.Context, defaultUpstreamNum string, lc *c
 		sourceIP = addr.IP
 	}
 
+	var networkSource, macSource, ruleSource, exprSource string
+	var networkTargets, macTargets, ruleTargets, exprTargets []string
 networkRules:
 	for _, rule := range lc.Policy.Networks {
 		for source, targets := range rule {
@@ -283,10 +556,7 @@ networkRules:
 			}
 			for _, ipNet := range nc.IPNets {
 				if ipNet.Contains(sourceIP) {
-					matchedPolicy = lc.Policy.Name
-					matchedNetwork = source
-					networkTargets = targets
-					matched = true
+					networkSource, networkTargets = source, targets
 					break networkRules
 				}
 			}
@@ -297,38 +567,97 @@ macRules:
 	for _, rule := range lc.Policy.Macs {
 		for source, targets := range rule {
 			if source != "" && (strings.EqualFold(source, srcMac) || wildcardMatches(strings.ToLower(source), strings.ToLower(srcMac))) {
-				matchedPolicy = lc.Policy.Name
-				matchedNetwork = source
-				networkTargets = targets
-				matched = true
+				macSource, macTargets = source, targets
 				break macRules
 			}
 		}
 	}
 
+domainRules:
 	for _, rule := range lc.Policy.Rules {
 		// There's only one entry per rule, config validation ensures this.
 		for source, targets := range rule {
-			if source == domain || wildcardMatches(source, domain) {
-				matchedPolicy = lc.Policy.Name
-				if len(networkTargets) > 0 {
-					matchedNetwork += " (unenforced)"
+			if source == domain || wildcardMatches(source, domain) || p.domainLists.matches(source, domain) {
+				ruleSource, ruleTargets = source, targets
+				break domainRules
+			}
+		}
+	}
+
+	if len(lc.Policy.Expressions) > 0 {
+		env := &policyExprEnv{p: p, listener: defaultUpstreamNum, domain: domain, qtype: qtype, ip: sourceIP, mac: srcMac}
+	exprRules:
+		for _, rule := range lc.Policy.Expressions {
+			for source, targets := range rule {
+				if e := lc.Policy.CompiledExpressions[source]; e != nil && e.Eval(env) {
+					exprSource, exprTargets = source, targets
+					break exprRules
 				}
-				matchedRule = source
-				do(targets)
-				matched = true
-				return
 			}
 		}
 	}
 
+	// Rule kinds are processed following the policy priority, the first matched rule wins.
+	// Matched rules of lower priority kinds are logged as unenforced.
+	for _, kind := range policyPriority(lc.Policy) {
+		switch {
+		case matched:
+			switch kind {
+			case ctrld.PolicyRuleKindRules, ctrld.PolicyRuleKindExpressions:
+				source := ruleSource
+				if kind == ctrld.PolicyRuleKindExpressions {
+					source = exprSource
+				}
+				if matchedRule == "no rule" && source != "" {
+					matchedRule = source + " (unenforced)"
+				}
+			case ctrld.PolicyRuleKindMacs, ctrld.PolicyRuleKindNetworks:
+				if matchedNetwork == "no network" && (macSource != "" || networkSource != "") {
+					matchedNetwork = cmp.Or(macSource, networkSource) + " (unenforced)"
+				}
+			}
+		case kind == ctrld.PolicyRuleKindExpressions && exprSource != "":
+			matchedRule = exprSource
+			do(exprTargets)
+			matched = true
+		case kind == ctrld.PolicyRuleKindRules && ruleSource != "":
+			matchedRule = ruleSource
+			do(ruleTargets)
+			matched = true
+		case kind == ctrld.PolicyRuleKindMacs && macSource != "":
+			matchedNetwork = macSource
+			do(macTargets)
+			matched = true
+		case kind == ctrld.PolicyRuleKindNetworks && networkSource != "":
+			matchedNetwork = networkSource
+			do(networkTargets)
+			matched = true
+		}
+	}
 	if matched {
-		do(networkTargets)
+		matchedPolicy = lc.Policy.Name
+		return
+	}
+
+	// Default action of the policy, used when there's no matched rule.
+	if len(lc.Policy.Default) > 0 {
+		matchedPolicy = lc.Policy.Name
+		matchedRule = "default"
+		do(lc.Policy.Default)
+		matched = true
 	}
 
 	return
 }
 
+// policyPriority returns the order which rule kinds of the policy are processed.
+func policyPriority(policy *ctrld.ListenerPolicyConfig) []string {
+	if len(policy.Priority) > 0 {
+		return policy.Priority
+	}
+	return []string{ctrld.PolicyRuleKindExpressions, ctrld.PolicyRuleKindRules, ctrld.PolicyRuleKindMacs, ctrld.PolicyRuleKindNetworks}
+}
+
 func (p *prog) proxyPrivatePtrLookup(ctx context.Context, msg *dns.Msg) *dns.Msg {
 	cDomainName := msg.Question[0].Name
 	locked := p.ptrLoopGuard.TryLock(cDomainName)
@@ -361,8 +690,13 @@ func (p *prog) proxyPrivatePtrLookup(ctx context.Context, msg *dns.Msg) *dns.Msg
 }
 
 func (p *prog) proxyLanHostnameQuery(ctx context.Context, msg *dns.Msg) *dns.Msg {
+	return p.lanHostnameAnswer(ctx, msg, strings.TrimSuffix(msg.Question[0].Name, "."))
+}
+
+// lanHostnameAnswer returns the answer for A/AAAA query of LAN hostname using client info table,
+// or nil if there's no record for hostname.
+func (p *prog) lanHostnameAnswer(ctx context.Context, msg *dns.Msg, hostname string) *dns.Msg {
 	q := msg.Question[0]
-	hostname := strings.TrimSuffix(q.Name, ".")
 	locked := p.lanLoopGuard.TryLock(hostname)
 	defer p.lanLoopGuard.Unlock(hostname)
 	if !locked {
@@ -412,9 +746,14 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 	upstreamConfigs := p.upstreamConfigsFromUpstreamNumbers(upstreams)
 
 	leaked := false
+	osResolver := req.osResolver
+	if p.cfg.Service.FailClosed {
+		osResolver = ctrld.OsResolverFallbackNever
+	}
+	neverOS := osResolver == ctrld.OsResolverFallbackNever
 	// If ctrld is going to leak query to OS resolver, check remote upstream in background,
 	// so ctrld could be back to normal operation as long as the network is back online.
-	if len(upstreamConfigs) > 0 && p.leakingQuery.Load() {
+	if len(upstreamConfigs) > 0 && p.leakingQuery.Load() && !neverOS {
 		for n, uc := range upstreamConfigs {
 			go p.checkUpstream(upstreams[n], uc)
 		}
@@ -423,9 +762,12 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 		ctrld.Log(ctx, mainLog.Load().Debug(), "%v is down, leaking query to OS resolver", upstreams)
 	}
 
-	if len(upstreamConfigs) == 0 {
+	switch {
+	case len(upstreamConfigs) == 0 && !neverOS:
 		upstreamConfigs = []*ctrld.UpstreamConfig{osUpstreamConfig}
 		upstreams = []string{upstreamOS}
+	case len(upstreamConfigs) > 0:
+		upstreams, upstreamConfigs = upstreamsWithOsResolver(osResolver, upstreams, upstreamConfigs)
 	}
 
 	res := &proxyResponse{}
@@ -444,8 +786,9 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 			ctrld.Log(ctx, mainLog.Load().Debug(), "%s, %s, %s -> %v", req.ufr.matchedPolicy, req.ufr.matchedNetwork, req.ufr.matchedRule, upstreams)
 		}
 	} else {
+		inReverseZone := p.reverseZones.isPtrLookup(req.msg)
 		switch {
-		case isPrivatePtrLookup(req.msg):
+		case isPrivatePtrLookup(req.msg) || inReverseZone:
 			isLanOrPtrQuery = true
 			if answer := p.proxyPrivatePtrLookup(ctx, req.msg); answer != nil {
 				res.answer = answer
@@ -453,6 +796,9 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 				return res
 			}
 			upstreams, upstreamConfigs = p.upstreamsAndUpstreamConfigForLanAndPtr(upstreams, upstreamConfigs)
+			if inReverseZone {
+				upstreams, upstreamConfigs = p.reverseZones.upstreams(upstreams, upstreamConfigs)
+			}
 			ctrld.Log(ctx, mainLog.Load().Debug(), "private PTR lookup, using upstreams: %v", upstreams)
 		case isLanHostnameQuery(req.msg):
 			isLanOrPtrQuery = true
@@ -470,12 +816,16 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 
 	// Inverse query should not be cached: https://www.rfc-editor.org/rfc/rfc1035#section-7.4
 	if p.cache != nil && req.msg.Question[0].Qtype != dns.TypePTR {
+		_, cacheSpan := startSpan(ctx, "dns.cache.lookup", spanKindInternal)
 		for _, upstream := range upstreams {
 			cachedValue := p.cache.Get(dnscache.NewKey(req.msg, upstream))
 			if cachedValue == nil {
 				continue
 			}
-			answer := cachedValue.Msg.Copy()
+			answer, err := cachedValue.Msg()
+			if err != nil {
+				continue
+			}
 			answer.SetRcode(req.msg, answer.Rcode)
 			now := time.Now()
 			if cachedValue.Expire.After(now) {
@@ -483,32 +833,76 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 				setCachedAnswerTTL(answer, now, cachedValue.Expire)
 				res.answer = answer
 				res.cached = true
+				cacheSpan.SetAttributes(boolAttr("ctrld.cache.hit", true))
+				cacheSpan.End()
+				statsCacheLookups.WithLabelValues(cacheEviction(&p.cfg.Service), "hit").Inc()
 				return res
 			}
 			staleAnswer = answer
 		}
+		cacheSpan.SetAttributes(boolAttr("ctrld.cache.hit", false), boolAttr("ctrld.cache.stale", staleAnswer != nil))
+		cacheSpan.End()
+		result := "miss"
+		if staleAnswer != nil {
+			result = "stale"
+		}
+		statsCacheLookups.WithLabelValues(cacheEviction(&p.cfg.Service), result).Inc()
+	}
+	// Retries for queries which upstreams failed to answer recently are not sent to upstreams again.
+	servfailKey := ""
+	if p.servfailCache != nil {
+		servfailKey = servfailCacheKey(req.msg, upstreams)
+		if p.servfailCache.failed(servfailKey) {
+			statsServfailSuppressed.Inc()
+			if serveStaleCache && staleAnswer != nil {
+				ctrld.Log(ctx, mainLog.Load().Debug(), "upstream failure is cached, serving stale cached response")
+				now := time.Now()
+				setCachedAnswerTTL(staleAnswer, now, now.Add(staleTTL))
+				res.answer = staleAnswer
+				res.cached = true
+				return res
+			}
+			ctrld.Log(ctx, mainLog.Load().Debug(), "upstream failure is cached, answering SERVFAIL")
+			res.answer = newErrorAnswer(req.msg, dns.RcodeServerFailure, dns.ExtendedErrorCodeCachedError, "cached upstream failure")
+			res.upstream = upstreamServfailCache
+			return res
+		}
 	}
 	resolve1 := func(n int, upstreamConfig *ctrld.UpstreamConfig, msg *dns.Msg) (*dns.Msg, error) {
 		ctrld.Log(ctx, mainLog.Load().Debug(), "sending query to %s: %s", upstreams[n], upstreamConfig.Name)
+		resolveCtx, span := startSpan(ctx, "dns.upstream.exchange", spanKindClient,
+			stringAttr("ctrld.upstream", upstreams[n]),
+			stringAttr("ctrld.upstream.type", upstreamConfig.Type),
+			stringAttr("ctrld.upstream.endpoint", upstreamConfig.Endpoint),
+		)
+		defer span.End()
 		dnsResolver, err := ctrld.NewResolver(upstreamConfig)
 		if err != nil {
 			ctrld.Log(ctx, mainLog.Load().Error().Err(err), "failed to create resolver")
+			span.SetError(err, "failed to create resolver")
 			return nil, err
 		}
-		resolveCtx, cancel := context.WithCancel(ctx)
+		resolveCtx, cancel := context.WithCancel(resolveCtx)
 		defer cancel()
 		if upstreamConfig.Timeout > 0 {
 			timeoutCtx, cancel := context.WithTimeout(resolveCtx, time.Millisecond*time.Duration(upstreamConfig.Timeout))
 			defer cancel()
 			resolveCtx = timeoutCtx
 		}
-		return dnsResolver.Resolve(resolveCtx, msg)
+		answer, err := dnsResolver.Resolve(resolveCtx, msg)
+		if err != nil {
+			span.SetError(err, "failed to resolve query")
+		} else if answer != nil {
+			span.SetAttributes(stringAttr("dns.response.code", dns.RcodeToString[answer.Rcode]))
+		}
+		return answer, err
 	}
 	resolve := func(n int, upstreamConfig *ctrld.UpstreamConfig, msg *dns.Msg) *dns.Msg {
 		if upstreamConfig.UpstreamSendClientInfo() && req.ci != nil {
 			ctrld.Log(ctx, mainLog.Load().Debug(), "including client info with the request")
 			ctx = context.WithValue(ctx, ctrld.ClientInfoCtxKey{}, req.ci)
 		}
+		start := time.Now()
 		answer, err := resolve1(n, upstreamConfig, msg)
 		if err != nil {
 			ctrld.Log(ctx, mainLog.Load().Error().Err(err), "failed to resolve query")
@@ -526,18 +920,22 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 			}
 			return nil
 		}
+		p.ul.record(upstreams[n], time.Since(start), p.upstreamLatencyWarnThreshold())
 		return answer
 	}
+	loopDetected, upstreamDown := false, false
 	for n, upstreamConfig := range upstreamConfigs {
 		if upstreamConfig == nil {
 			continue
 		}
 		if p.isLoop(upstreamConfig) {
 			mainLog.Load().Warn().Msgf("dns loop detected, upstream: %q, endpoint: %q", upstreamConfig.Name, upstreamConfig.Endpoint)
+			loopDetected = true
 			continue
 		}
 		if p.um.isDown(upstreams[n]) {
 			ctrld.Log(ctx, mainLog.Load().Warn(), "%s is down", upstreams[n])
+			upstreamDown = true
 			continue
 		}
 		answer := resolve(n, upstreamConfig, req.msg)
@@ -574,20 +972,25 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 				expired = now.Add(time.Duration(cachedTTL) * time.Second)
 			}
 			setCachedAnswerTTL(answer, now, expired)
-			p.cache.Add(dnscache.NewKey(req.msg, upstreams[n]), dnscache.NewValue(answer, expired))
-			ctrld.Log(ctx, mainLog.Load().Debug(), "add cached response")
+			if value, err := dnscache.NewValue(answer, expired); err == nil {
+				p.cache.Add(dnscache.NewKey(req.msg, upstreams[n]), value)
+				ctrld.Log(ctx, mainLog.Load().Debug(), "add cached response")
+			}
 		}
 		hostname := ""
 		if req.ci != nil {
 			hostname = req.ci.Hostname
 		}
 		ctrld.Log(ctx, mainLog.Load().Info(), "REPLY: %s -> %s (%s): %s", upstreams[n], req.ufr.srcAddr, hostname, dns.RcodeToString[answer.Rcode])
+		if answer.Rcode == dns.RcodeServerFailure {
+			p.servfailCache.add(servfailKey)
+		}
 		res.answer = answer
 		res.upstream = upstreamConfig.Endpoint
 		return res
 	}
 	ctrld.Log(ctx, mainLog.Load().Error(), "all %v endpoints failed", upstreams)
-	if cdUID != "" && p.leakOnUpstreamFailure() {
+	if loadCdUID() != "" && p.leakOnUpstreamFailure() {
 		p.leakingQueryMu.Lock()
 		if !p.leakingQueryWasRun {
 			p.leakingQueryWasRun = true
@@ -595,12 +998,92 @@ func (p *prog) proxy(ctx context.Context, req *proxyRequest) *proxyResponse {
 		}
 		p.leakingQueryMu.Unlock()
 	}
-	answer := new(dns.Msg)
-	answer.SetRcode(req.msg, dns.RcodeServerFailure)
+	var answer *dns.Msg
+	switch {
+	case loopDetected:
+		answer = newErrorAnswer(req.msg, dns.RcodeServerFailure, dns.ExtendedErrorCodeOther, "dns loop detected")
+	case upstreamDown:
+		answer = newErrorAnswer(req.msg, dns.RcodeServerFailure, dns.ExtendedErrorCodeNoReachableAuthority, "upstream is down")
+	default:
+		answer = newErrorAnswer(req.msg, dns.RcodeServerFailure, dns.ExtendedErrorCodeNetworkError, "all upstreams failed")
+		p.servfailCache.add(servfailKey)
+	}
 	res.answer = answer
 	return res
 }
 
+// newErrorAnswer returns a new answer for the request with the given rcode. If the
+// request supports EDNS0, an Extended DNS Error (RFC 8914) is attached to the answer,
+// so clients could tell why ctrld generated the failure by itself.
+func newErrorAnswer(req *dns.Msg, rcode int, infoCode uint16, extraText string) *dns.Msg {
+	answer := new(dns.Msg)
+	answer.SetRcode(req, rcode)
+	opt := req.IsEdns0()
+	if opt == nil {
+		return answer
+	}
+	answer.SetEdns0(opt.UDPSize(), opt.Do())
+	answerOpt := answer.IsEdns0()
+	answerOpt.Option = append(answerOpt.Option, &dns.EDNS0_EDE{InfoCode: infoCode, ExtraText: extraText})
+	return answer
+}
+
+// refusedQueryTypeAnswer returns the answer for query types which are not served by listener, or nil
+// if the query should be handled normally. Zone transfers are always refused, since ctrld is not an
+// authoritative server. ANY queries are answered with a synthesized HINFO record as described in
+// RFC 8482 if "refuse_any" is set, so exposed listeners could not be abused for amplification.
+func refusedQueryTypeAnswer(m *dns.Msg, lc *ctrld.ListenerConfig) *dns.Msg {
+	q := m.Question[0]
+	switch q.Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		return newErrorAnswer(m, dns.RcodeRefused, dns.ExtendedErrorCodeProhibited, "zone transfers are not allowed")
+	case dns.TypeANY:
+		if lc == nil || !lc.RefuseAny {
+			return nil
+		}
+		answer := new(dns.Msg)
+		answer.SetReply(m)
+		answer.Answer = []dns.RR{&dns.HINFO{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: 3600},
+			Cpu: "RFC8482",
+		}}
+		return answer
+	}
+	return nil
+}
+
+// writeMsg packs the answer into a pooled buffer, then writes it to the client.
+func writeMsg(w dns.ResponseWriter, answer *dns.Msg) error {
+	packed, err := dnspool.Pack(answer)
+	if err != nil {
+		return err
+	}
+	defer packed.Release()
+	_, err = w.Write(packed.Bytes())
+	return err
+}
+
+// fitUDPAnswer returns the answer fitting the UDP payload size of the request, with the EDNS0
+// buffer size set to the size advertised by the listener. Records which do not fit are removed,
+// and the answer is marked as truncated, so the client retries over TCP. The original answer is
+// left untouched, since it may be shared with the cache.
+func fitUDPAnswer(req, answer *dns.Msg, listenerSize uint16) *dns.Msg {
+	maxSize := uint16(dns.MinMsgSize)
+	if opt := req.IsEdns0(); opt != nil {
+		maxSize = max(min(opt.UDPSize(), listenerSize), dns.MinMsgSize)
+	}
+	opt := answer.IsEdns0()
+	if answer.Len() <= int(maxSize) && (opt == nil || opt.UDPSize() == listenerSize) {
+		return answer
+	}
+	answer = answer.Copy()
+	if opt := answer.IsEdns0(); opt != nil {
+		opt.SetUDPSize(listenerSize)
+	}
+	answer.Truncate(int(maxSize))
+	return answer
+}
+
 func (p *prog) upstreamsAndUpstreamConfigForLanAndPtr(upstreams []string, upstreamConfigs []*ctrld.UpstreamConfig) ([]string, []*ctrld.UpstreamConfig) {
 	if len(p.localUpstreams) > 0 {
 		tmp := make([]string, 0, len(p.localUpstreams)+len(upstreams))
@@ -611,9 +1094,40 @@ func (p *prog) upstreamsAndUpstreamConfigForLanAndPtr(upstreams []string, upstre
 	return append([]string{upstreamOS}, upstreams...), append([]*ctrld.UpstreamConfig{privateUpstreamConfig}, upstreamConfigs...)
 }
 
+// upstreamsWithOsResolver returns the upstreams with OS resolver placed at the position
+// specified by osResolver, which is the policy "os_resolver_fallback" value.
+func upstreamsWithOsResolver(osResolver string, upstreams []string, upstreamConfigs []*ctrld.UpstreamConfig) ([]string, []*ctrld.UpstreamConfig) {
+	switch osResolver {
+	case ctrld.OsResolverFallbackFirst:
+		return append([]string{upstreamOS}, upstreams...), append([]*ctrld.UpstreamConfig{osUpstreamConfig}, upstreamConfigs...)
+	case ctrld.OsResolverFallbackLast:
+		return append(upstreams[:len(upstreams):len(upstreams)], upstreamOS),
+			append(upstreamConfigs[:len(upstreamConfigs):len(upstreamConfigs)], osUpstreamConfig)
+	}
+	return upstreams, upstreamConfigs
+}
+
 func (p *prog) upstreamConfigsFromUpstreamNumbers(upstreams []string) []*ctrld.UpstreamConfig {
 	upstreamConfigs := make([]*ctrld.UpstreamConfig, 0, len(upstreams))
 	for _, upstream := range upstreams {
+		// Bypass rule action forwards queries to nameservers provided by the network.
+		if upstream == upstreamBypass {
+			upstreamConfigs = append(upstreamConfigs, bypassUpstreamConfig)
+			continue
+		}
+		// Block rule action is answered by the handler, it's never forwarded.
+		if upstream == upstreamBlock {
+			continue
+		}
+		if uc := p.adZones.upstreamConfig(upstream); uc != nil {
+			upstreamConfigs = append(upstreamConfigs, uc)
+			continue
+		}
+		// Overlay networks resolvers are detected at runtime.
+		if uc := p.overlayDNS.upstreamConfig(upstream); uc != nil {
+			upstreamConfigs = append(upstreamConfigs, uc)
+			continue
+		}
 		upstreamNum := strings.TrimPrefix(upstream, upstreamPrefix)
 		upstreamConfigs = append(upstreamConfigs, p.cfg.Upstream[upstreamNum])
 	}
@@ -707,6 +1221,23 @@ func ttlFromMsg(msg *dns.Msg) uint32 {
 	return 0
 }
 
+// minTTLFromMsg returns the smallest TTL of records in msg, the OPT record is ignored. The second return
+// value reports whether msg has any records.
+func minTTLFromMsg(msg *dns.Msg) (uint32, bool) {
+	ttl, found := uint32(0), false
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !found || rr.Header().Ttl < ttl {
+				ttl, found = rr.Header().Ttl, true
+			}
+		}
+	}
+	return ttl, found
+}
+
 func needLocalIPv6Listener() bool {
 	// On Windows, there's no easy way for disabling/removing IPv6 DNS resolver, so we check whether we can
 	// listen on ::1, then spawn a listener for receiving DNS requests.
@@ -750,6 +1281,57 @@ func stripClientSubnet(msg *dns.Msg) {
 	}
 }
 
+// stripECH returns a copy of answer with the ECH parameter removed from
+// all HTTPS/SVCB records. The second return value reports whether any ECH
+// parameter was found. The original answer is left untouched, since it
+// may be shared with the cache.
+func stripECH(answer *dns.Msg) (*dns.Msg, bool) {
+	if answer == nil || !hasECH(answer) {
+		return answer, false
+	}
+	stripped := answer.Copy()
+	for _, sections := range [][]dns.RR{stripped.Answer, stripped.Extra} {
+		for _, rr := range sections {
+			var svcb *dns.SVCB
+			switch r := rr.(type) {
+			case *dns.HTTPS:
+				svcb = &r.SVCB
+			case *dns.SVCB:
+				svcb = r
+			default:
+				continue
+			}
+			svcb.Value = slices.DeleteFunc(svcb.Value, func(kv dns.SVCBKeyValue) bool {
+				return kv.Key() == dns.SVCB_ECHCONFIG
+			})
+		}
+	}
+	return stripped, true
+}
+
+// hasECH reports whether the given answer contains any HTTPS/SVCB record with ECH parameter.
+func hasECH(answer *dns.Msg) bool {
+	for _, sections := range [][]dns.RR{answer.Answer, answer.Extra} {
+		for _, rr := range sections {
+			var values []dns.SVCBKeyValue
+			switch r := rr.(type) {
+			case *dns.HTTPS:
+				values = r.Value
+			case *dns.SVCB:
+				values = r.Value
+			default:
+				continue
+			}
+			for _, kv := range values {
+				if kv.Key() == dns.SVCB_ECHCONFIG {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func spoofRemoteAddr(addr net.Addr, ci *ctrld.ClientInfo) net.Addr {
 	if ci != nil && ci.IP != "" {
 		switch addr := addr.(type) {
@@ -772,17 +1354,28 @@ func spoofRemoteAddr(addr net.Addr, ci *ctrld.ClientInfo) net.Addr {
 	return addr
 }
 
+// shutdownDNSServer stops the DNS server from accepting new queries,
+// then waits for in-flight queries to be finished, bounded by shutdown drain timeout.
+func (p *prog) shutdownDNSServer(s *dns.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.shutdownDrainTimeout())
+	defer cancel()
+	if err := s.ShutdownContext(ctx); errors.Is(err, context.DeadlineExceeded) {
+		mainLog.Load().Warn().Msgf("in-flight queries on %s/%s were not finished before drain timeout", s.Net, s.Addr)
+	}
+}
+
 // runDNSServer starts a DNS server for given address and network,
 // with the given handler. It ensures the server has started listening.
 // Any error will be reported to the caller via returned channel.
 //
-// It's the caller responsibility to call Shutdown to close the server.
+// It's the caller responsibility to call Shutdown/ShutdownContext to close the server.
 func runDNSServer(addr, network string, handler dns.Handler) (*dns.Server, <-chan error) {
 	s := &dns.Server{
 		Addr:    addr,
 		Net:     network,
 		Handler: handler,
 	}
+	setPoolReader(s)
 
 	startedCh := make(chan struct{})
 	s.NotifyStartedFunc = func() { sync.OnceFunc(func() { close(startedCh) })() }
@@ -800,6 +1393,87 @@ func runDNSServer(addr, network string, handler dns.Handler) (*dns.Server, <-cha
 	return s, errCh
 }
 
+// runUDPDNSServer is like runDNSServer, but ctrld opens the UDP socket itself.
+//
+// If reusePort is true, the socket is opened with SO_REUSEPORT set, so multiple servers
+// could share the same address, and the kernel distributes queries between them.
+//
+// If batchSize is greater than 1, the socket reads/writes DNS messages in batches.
+func runUDPDNSServer(addr string, handler dns.Handler, reusePort bool, batchSize int) (*dns.Server, <-chan error) {
+	lc := &net.ListenConfig{}
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if batchSize > 1 && err == nil {
+		pc = newBatchPacketConn(pc, batchSize)
+	}
+	if err != nil {
+		return failedDNSServer(addr, "udp", err)
+	}
+	return activateDNSServer(&dns.Server{
+		Addr:       addr,
+		Net:        "udp",
+		PacketConn: pc,
+		Handler:    handler,
+	})
+}
+
+// failedDNSServer returns the server which could not listen on addr, with err sent to the error channel.
+func failedDNSServer(addr, network string, err error) (*dns.Server, <-chan error) {
+	mainLog.Load().Error().Err(err).Msgf("could not listen and serve on: %s", addr)
+	errCh := make(chan error, 1)
+	errCh <- err
+	close(errCh)
+	return &dns.Server{Addr: addr, Net: network}, errCh
+}
+
+// activateDNSServer runs the server s, using its already opened listener or packet conn.
+func activateDNSServer(s *dns.Server) (*dns.Server, <-chan error) {
+	setPoolReader(s)
+	startedCh := make(chan struct{})
+	s.NotifyStartedFunc = func() { sync.OnceFunc(func() { close(startedCh) })() }
+
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
+		if err := s.ActivateAndServe(); err != nil {
+			s.NotifyStartedFunc()
+			mainLog.Load().Error().Err(err).Msgf("could not serve on: %s", s.Addr)
+			errCh <- err
+		}
+	}()
+	<-startedCh
+	return s, errCh
+}
+
+// udpBatchSize returns the number of DNS messages read/written in one syscall
+// by UDP sockets of the given listener bound to addr.
+func udpBatchSize(lc *ctrld.ListenerConfig, addr string) int {
+	// Replies sent by batch writes do not carry the source address of the queries,
+	// so they may be sent from wrong address if listening on all interfaces.
+	host, _, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); !supportsUDPBatch || ip == nil || ip.IsUnspecified() {
+		return 1
+	}
+	if n := lc.UDPBatchSize; n != nil && *n > 0 {
+		return *n
+	}
+	return defaultUDPBatchSize
+}
+
+// udpSockets returns the number of UDP sockets ctrld opens for the given listener bound to addr.
+func udpSockets(lc *ctrld.ListenerConfig, addr string) int {
+	// With port 0, each socket would be bound to different port.
+	if _, port, err := net.SplitHostPort(addr); !supportsReusePort || err != nil || port == "0" {
+		return 1
+	}
+	if n := lc.UDPSockets; n != nil && *n > 0 {
+		return *n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
 func (p *prog) getClientInfo(remoteIP string, msg *dns.Msg) *ctrld.ClientInfo {
 	ci := &ctrld.ClientInfo{}
 	if p.appCallback != nil {
@@ -890,7 +1564,7 @@ func (p *prog) doSelfUninstall(answer *dns.Msg) {
 	logger := mainLog.Load().With().Str("mode", "self-uninstall").Logger()
 	if p.refusedQueryCount > selfUninstallMaxQueries {
 		p.checkingSelfUninstall = true
-		_, err := controld.FetchResolverConfig(cdUID, rootCmd.Version, cdDev)
+		_, err := controld.FetchResolverConfig(loadCdUID(), rootCmd.Version, cdDev)
 		logger.Debug().Msg("maximum number of refused queries reached, checking device status")
 		selfUninstallCheck(err, p, logger)
 
@@ -931,11 +1605,11 @@ func (p *prog) performLeakingQuery() {
 // forceFetchingAPI sends signal to force syncing API config if run in cd mode,
 // and the domain == "cdUID.verify.controld.com"
 func (p *prog) forceFetchingAPI(domain string) {
-	if cdUID == "" {
+	if loadCdUID() == "" {
 		return
 	}
 	resolverID, parent, _ := strings.Cut(domain, ".")
-	if resolverID != cdUID {
+	if resolverID != loadCdUID() {
 		return
 	}
 	switch {
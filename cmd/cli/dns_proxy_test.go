@@ -3,14 +3,19 @@ package cli
 import (
 	"context"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/clientinfo"
 	"github.com/Control-D-Inc/ctrld/internal/dnscache"
 	"github.com/Control-D-Inc/ctrld/testhelper"
 )
@@ -126,7 +131,7 @@ func Test_prog_upstreamFor(t *testing.T) {
 				require.NoError(t, err)
 				require.NotNil(t, addr)
 				ctx := context.WithValue(context.Background(), ctrld.ReqIdCtxKey{}, requestID())
-				ufr := p.upstreamFor(ctx, tc.defaultUpstreamNum, tc.lc, addr, tc.mac, tc.domain)
+				ufr := p.upstreamFor(ctx, tc.defaultUpstreamNum, tc.lc, addr, tc.mac, tc.domain, dns.TypeA)
 				p.proxy(ctx, &proxyRequest{
 					msg: newDnsMsgWithHostname("foo", dns.TypeA),
 					ufr: ufr,
@@ -141,6 +146,116 @@ func Test_prog_upstreamFor(t *testing.T) {
 	}
 }
 
+func Test_prog_upstreamFor_priority(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("192.168.0.0/24")
+	p := &prog{cfg: &ctrld.Config{Network: map[string]*ctrld.NetworkConfig{"0": {IPNets: []*net.IPNet{ipNet}}}}}
+	policy := func(priority []string, def []string) *ctrld.ListenerConfig {
+		return &ctrld.ListenerConfig{Policy: &ctrld.ListenerPolicyConfig{
+			Name:     "My Policy",
+			Networks: []ctrld.Rule{{"network.0": []string{"upstream.1"}}},
+			Macs:     []ctrld.Rule{{"14:45:a0:67:83:0a": []string{"upstream.2"}}},
+			Rules:    []ctrld.Rule{{"*.ru": []string{"upstream.3"}}},
+			Priority: priority,
+			Default:  def,
+		}}
+	}
+	lan := &net.UDPAddr{IP: net.ParseIP("192.168.0.1")}
+	wan := &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}
+	mac := "14:45:A0:67:83:0A"
+
+	tests := []struct {
+		name        string
+		lc          *ctrld.ListenerConfig
+		addr        net.Addr
+		mac         string
+		domain      string
+		upstreams   []string
+		matched     bool
+		wantNetwork string
+		wantRule    string
+	}{
+		{"default priority rules", policy(nil, nil), lan, mac, "abc.ru", []string{"upstream.3"}, true, "14:45:a0:67:83:0a (unenforced)", "*.ru"},
+		{"default priority macs", policy(nil, nil), lan, mac, "abc.xyz", []string{"upstream.2"}, true, "14:45:a0:67:83:0a", "no rule"},
+		{"networks first", policy([]string{"networks", "rules", "macs"}, nil), lan, mac, "abc.ru", []string{"upstream.1"}, true, "network.0", "*.ru (unenforced)"},
+		{"macs first", policy([]string{"macs", "networks", "rules"}, nil), lan, mac, "abc.ru", []string{"upstream.2"}, true, "14:45:a0:67:83:0a", "*.ru (unenforced)"},
+		{"partial priority", policy([]string{"networks"}, nil), lan, mac, "abc.ru", []string{"upstream.1"}, true, "network.0", "no rule"},
+		{"no match", policy(nil, nil), wan, "", "abc.xyz", []string{"upstream.0"}, false, "no network", "no rule"},
+		{"default action", policy(nil, []string{"block"}), wan, "", "abc.xyz", []string{"block"}, true, "no network", "default"},
+		{"default action not used", policy(nil, []string{"block"}), wan, "", "abc.ru", []string{"upstream.3"}, true, "no network", "*.ru"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ufr := p.upstreamFor(context.Background(), "0", tc.lc, tc.addr, tc.mac, tc.domain, dns.TypeA)
+			assert.Equal(t, tc.matched, ufr.matched)
+			assert.Equal(t, tc.upstreams, ufr.upstreams)
+			assert.Equal(t, tc.wantNetwork, ufr.matchedNetwork)
+			assert.Equal(t, tc.wantRule, ufr.matchedRule)
+		})
+	}
+}
+
+func Test_prog_upstreamFor_expressions(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("192.168.0.0/24")
+	p := &prog{
+		cfg:         &ctrld.Config{Network: map[string]*ctrld.NetworkConfig{"0": {Name: "iot", IPNets: []*net.IPNet{ipNet}}}},
+		domainLists: newDomainLists(map[string][]string{"ads": {"*.ads.com"}}),
+	}
+	lc := &ctrld.ListenerConfig{Policy: &ctrld.ListenerPolicyConfig{
+		Name: "My Policy",
+		Expressions: []ctrld.Rule{
+			{`qtype == "A" && client in group("iot") && match(qname, "*.telemetry.*")`: []string{"block"}},
+			{`qname in list("ads") && !(client in cidr("10.0.0.0/8"))`: []string{"upstream.2"}},
+		},
+		Rules: []ctrld.Rule{{"*.com": []string{"upstream.3"}}},
+	}}
+	lc.Init()
+	lan := &net.UDPAddr{IP: net.ParseIP("192.168.0.1")}
+	wan := &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}
+
+	tests := []struct {
+		name      string
+		addr      net.Addr
+		domain    string
+		qtype     uint16
+		upstreams []string
+		wantRule  string
+	}{
+		{"group and match", lan, "a.telemetry.vendor.com", dns.TypeA, []string{"block"}, `qtype == "A" && client in group("iot") && match(qname, "*.telemetry.*")`},
+		{"qtype not matched", lan, "a.telemetry.vendor.com", dns.TypeAAAA, []string{"upstream.3"}, "*.com"},
+		{"group not matched", wan, "a.telemetry.vendor.com", dns.TypeA, []string{"upstream.3"}, "*.com"},
+		{"domain list", lan, "x.ads.com", dns.TypeA, []string{"upstream.2"}, `qname in list("ads") && !(client in cidr("10.0.0.0/8"))`},
+		{"negated cidr", wan, "x.ads.com", dns.TypeA, []string{"upstream.3"}, "*.com"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ufr := p.upstreamFor(context.Background(), "0", lc, tc.addr, "", tc.domain, tc.qtype)
+			assert.True(t, ufr.matched)
+			assert.Equal(t, tc.upstreams, ufr.upstreams)
+			assert.Equal(t, tc.wantRule, ufr.matchedRule)
+		})
+	}
+}
+
+func Test_upstreamForResult_blockingRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		ur       *upstreamForResult
+		wantRule string
+		wantList string
+	}{
+		{"domain rule", &upstreamForResult{matchedRule: "*.ads.com", matchedNetwork: "no network"}, "*.ads.com", ""},
+		{"domain list", &upstreamForResult{matchedRule: "list.ads", matchedNetwork: "network.0 (unenforced)"}, "list.ads", "ads"},
+		{"network rule", &upstreamForResult{matchedRule: "no rule", matchedNetwork: "network.0"}, "network.0", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, list := tc.ur.blockingRule()
+			assert.Equal(t, tc.wantRule, rule)
+			assert.Equal(t, tc.wantList, list)
+		})
+	}
+}
+
 func TestCache(t *testing.T) {
 	cfg := testhelper.SampleConfig(t)
 	prog := &prog{cfg: cfg}
@@ -153,20 +268,20 @@ func TestCache(t *testing.T) {
 			nc.IPNets = append(nc.IPNets, ipNet)
 		}
 	}
-	cacher, err := dnscache.NewLRUCache(4096)
+	cacher, err := dnscache.NewLRUCache(4096, 0, "")
 	require.NoError(t, err)
 	prog.cache = cacher
 
 	msg := new(dns.Msg)
-	msg.SetQuestion("example.com", dns.TypeA)
+	msg.SetQuestion("example.com.", dns.TypeA)
 	msg.MsgHdr.RecursionDesired = true
 	answer1 := new(dns.Msg)
 	answer1.SetRcode(msg, dns.RcodeSuccess)
 
-	prog.cache.Add(dnscache.NewKey(msg, "upstream.1"), dnscache.NewValue(answer1, time.Now().Add(time.Minute)))
+	prog.cache.Add(dnscache.NewKey(msg, "upstream.1"), newCacheValue(t, answer1, time.Now().Add(time.Minute)))
 	answer2 := new(dns.Msg)
 	answer2.SetRcode(msg, dns.RcodeRefused)
-	prog.cache.Add(dnscache.NewKey(msg, "upstream.0"), dnscache.NewValue(answer2, time.Now().Add(time.Minute)))
+	prog.cache.Add(dnscache.NewKey(msg, "upstream.0"), newCacheValue(t, answer2, time.Now().Add(time.Minute)))
 
 	req1 := &proxyRequest{
 		msg:            msg,
@@ -439,3 +554,413 @@ func Test_isWanClient(t *testing.T) {
 		})
 	}
 }
+
+func Test_newErrorAnswer(t *testing.T) {
+	withEdns0 := new(dns.Msg)
+	withEdns0.SetQuestion("example.com.", dns.TypeA)
+	withEdns0.SetEdns0(1232, true)
+	withoutEdns0 := new(dns.Msg)
+	withoutEdns0.SetQuestion("example.com.", dns.TypeA)
+
+	tests := []struct {
+		name    string
+		msg     *dns.Msg
+		hasEDE  bool
+		rcode   int
+		edeCode uint16
+	}{
+		{"with EDNS0", withEdns0, true, dns.RcodeServerFailure, dns.ExtendedErrorCodeNetworkError},
+		{"without EDNS0", withoutEdns0, false, dns.RcodeServerFailure, dns.ExtendedErrorCodeNetworkError},
+		{"refused", withEdns0, true, dns.RcodeRefused, dns.ExtendedErrorCodeProhibited},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			answer := newErrorAnswer(tc.msg, tc.rcode, tc.edeCode, "test")
+			if answer.Rcode != tc.rcode {
+				t.Errorf("unexpected rcode, want: %s, got: %s", dns.RcodeToString[tc.rcode], dns.RcodeToString[answer.Rcode])
+			}
+			opt := answer.IsEdns0()
+			if !tc.hasEDE {
+				if opt != nil {
+					t.Error("unexpected EDNS0 in answer")
+				}
+				return
+			}
+			if opt == nil {
+				t.Fatal("missing EDNS0 in answer")
+			}
+			if opt.Do() != tc.msg.IsEdns0().Do() {
+				t.Error("DO bit mismatched")
+			}
+			var ede *dns.EDNS0_EDE
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_EDE); ok {
+					ede = e
+				}
+			}
+			if ede == nil {
+				t.Fatal("missing extended DNS error")
+			}
+			if ede.InfoCode != tc.edeCode {
+				t.Errorf("unexpected info code, want: %d, got: %d", tc.edeCode, ede.InfoCode)
+			}
+		})
+	}
+}
+
+func Test_fitUDPAnswer(t *testing.T) {
+	bigAnswer := func(udpSize uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeTXT)
+		for range 20 {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{strings.Repeat("a", 100)},
+			})
+		}
+		m.SetEdns0(udpSize, false)
+		return m
+	}
+	reqWithSize := func(udpSize uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeTXT)
+		if udpSize > 0 {
+			m.SetEdns0(udpSize, false)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name          string
+		req           *dns.Msg
+		listenerSize  uint16
+		wantMaxSize   int
+		wantTruncated bool
+	}{
+		{"without EDNS0", reqWithSize(0), ctrld.DefaultEDNSBufferSize, dns.MinMsgSize, true},
+		{"client buffer size", reqWithSize(1000), ctrld.DefaultEDNSBufferSize, 1000, true},
+		{"listener buffer size", reqWithSize(4096), ctrld.DefaultEDNSBufferSize, ctrld.DefaultEDNSBufferSize, true},
+		{"fit", reqWithSize(4096), 4096, 4096, false},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			answer := bigAnswer(4096)
+			size := answer.Len()
+			got := fitUDPAnswer(tc.req, answer, tc.listenerSize)
+			assert.LessOrEqual(t, got.Len(), tc.wantMaxSize)
+			assert.Equal(t, tc.wantTruncated, got.Truncated)
+			if opt := got.IsEdns0(); opt != nil {
+				assert.Equal(t, tc.listenerSize, opt.UDPSize())
+			}
+			// The original answer must not be changed.
+			assert.Equal(t, size, answer.Len())
+		})
+	}
+}
+
+func Test_stripECH(t *testing.T) {
+	newAnswer := func(t *testing.T, answer, extra []string) *dns.Msg {
+		t.Helper()
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeHTTPS)
+		for _, s := range answer {
+			rr, err := dns.NewRR(s)
+			require.NoError(t, err)
+			m.Answer = append(m.Answer, rr)
+		}
+		for _, s := range extra {
+			rr, err := dns.NewRR(s)
+			require.NoError(t, err)
+			m.Extra = append(m.Extra, rr)
+		}
+		return m
+	}
+	const (
+		a               = "example.com. 300 IN A 192.0.2.1"
+		httpsWithECH    = "example.com. 300 IN HTTPS 1 . alpn=h2 ech=AEX+DQBBpQAgACB/mk6YkuaV0qXfl4Bk4XuQJ62H0+wIvEO1wvkQ2hf3VgAEAAEAAQASY2xvdWRmbGFyZS1lY2guY29tAAA="
+		httpsWithoutECH = "example.com. 300 IN HTTPS 1 . alpn=h2 ipv4hint=192.0.2.1"
+		svcbWithECH     = "_dns.example.com. 300 IN SVCB 1 dns.example.com. alpn=dot ech=AEX+DQBBpQAgACB/mk6YkuaV0qXfl4Bk4XuQJ62H0+wIvEO1wvkQ2hf3VgAEAAEAAQASY2xvdWRmbGFyZS1lY2guY29tAAA="
+		svcbWithoutECH  = "_dns.example.com. 300 IN SVCB 1 dns.example.com. alpn=dot"
+	)
+
+	tests := []struct {
+		name    string
+		answer  []string
+		extra   []string
+		wantECH bool
+	}{
+		{"no records", nil, nil, false},
+		{"A record", []string{a}, nil, false},
+		{"HTTPS without ech", []string{httpsWithoutECH}, nil, false},
+		{"SVCB without ech", []string{svcbWithoutECH}, nil, false},
+		{"HTTPS with ech", []string{httpsWithECH}, nil, true},
+		{"SVCB with ech", []string{svcbWithECH}, nil, true},
+		{"ech in additional section", []string{httpsWithoutECH}, []string{svcbWithECH}, true},
+		{"mixed records", []string{a, httpsWithoutECH, httpsWithECH}, []string{svcbWithoutECH}, true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			answer := newAnswer(t, tc.answer, tc.extra)
+			assert.Equal(t, tc.wantECH, hasECH(answer))
+			value, err := dnscache.NewValue(answer, time.Now().Add(time.Minute))
+			require.NoError(t, err)
+			cached, err := value.Msg()
+			require.NoError(t, err)
+			want := cached.String()
+
+			got, stripped := stripECH(cached)
+			assert.Equal(t, tc.wantECH, stripped)
+			assert.False(t, hasECH(got))
+			// The cached answer must not be changed.
+			assert.Equal(t, want, cached.String())
+			assert.Equal(t, tc.wantECH, hasECH(cached))
+			again, err := value.Msg()
+			require.NoError(t, err)
+			assert.Equal(t, want, again.String())
+			if !tc.wantECH {
+				assert.Same(t, cached, got)
+				return
+			}
+			assert.NotSame(t, cached, got)
+			assert.Len(t, got.Answer, len(cached.Answer))
+			assert.Len(t, got.Extra, len(cached.Extra))
+			// Other parameters are kept.
+			for _, rr := range append(slices.Clone(got.Answer), got.Extra...) {
+				switch rr := rr.(type) {
+				case *dns.HTTPS:
+					assert.Contains(t, rr.String(), "alpn=")
+				case *dns.SVCB:
+					assert.Contains(t, rr.String(), "alpn=")
+				}
+			}
+			// Stripping the answer again is a no-op.
+			gotAgain, ok := stripECH(got)
+			assert.False(t, ok)
+			assert.Same(t, got, gotAgain)
+		})
+	}
+
+	got, ok := stripECH(nil)
+	assert.Nil(t, got)
+	assert.False(t, ok)
+}
+
+func Test_udpBatchSize(t *testing.T) {
+	if !supportsUDPBatch {
+		t.Skip("batch reads/writes are not supported")
+	}
+	batchSize := 8
+	lc := &ctrld.ListenerConfig{IP: "192.168.1.1", Port: 53, Addresses: []string{"0.0.0.0", "[::]:5353", "10.0.0.1"}, UDPBatchSize: &batchSize}
+	tests := []struct {
+		addr string
+		want int
+	}{
+		{"192.168.1.1:53", batchSize},
+		{"10.0.0.1:53", batchSize},
+		{"0.0.0.0:53", 1},
+		{"[::]:5353", 1},
+		{":53", 1},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, udpBatchSize(lc, tc.addr), tc.addr)
+	}
+}
+
+func Test_udpSockets(t *testing.T) {
+	if !supportsReusePort {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+	sockets := 4
+	lc := &ctrld.ListenerConfig{IP: "192.168.1.1", Port: 53, UDPSockets: &sockets}
+	assert.Equal(t, sockets, udpSockets(lc, "192.168.1.1:53"))
+	assert.Equal(t, sockets, udpSockets(lc, "0.0.0.0:5353"))
+	assert.Equal(t, 1, udpSockets(lc, "192.168.1.1:0"))
+}
+
+func Test_refusedQueryTypeAnswer(t *testing.T) {
+	query := func(qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", qtype)
+		return m
+	}
+	refuseAny := &ctrld.ListenerConfig{RefuseAny: true}
+
+	tests := []struct {
+		name      string
+		msg       *dns.Msg
+		lc        *ctrld.ListenerConfig
+		refused   bool
+		wantRcode int
+	}{
+		{"AXFR", query(dns.TypeAXFR), &ctrld.ListenerConfig{}, true, dns.RcodeRefused},
+		{"IXFR", query(dns.TypeIXFR), &ctrld.ListenerConfig{}, true, dns.RcodeRefused},
+		{"ANY allowed", query(dns.TypeANY), &ctrld.ListenerConfig{}, false, 0},
+		{"ANY refused", query(dns.TypeANY), refuseAny, true, dns.RcodeSuccess},
+		{"A", query(dns.TypeA), refuseAny, false, 0},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			answer := refusedQueryTypeAnswer(tc.msg, tc.lc)
+			if !tc.refused {
+				assert.Nil(t, answer)
+				return
+			}
+			require.NotNil(t, answer)
+			assert.Equal(t, tc.wantRcode, answer.Rcode)
+			if tc.msg.Question[0].Qtype == dns.TypeANY {
+				require.Len(t, answer.Answer, 1)
+				assert.Equal(t, dns.TypeHINFO, answer.Answer[0].Header().Rrtype)
+			}
+		})
+	}
+}
+
+func Test_upstreamsWithOsResolver(t *testing.T) {
+	uc := &ctrld.UpstreamConfig{Name: "upstream.0"}
+	tests := []struct {
+		name       string
+		osResolver string
+		want       []string
+	}{
+		{"default", "", []string{"upstream.0"}},
+		{"never", ctrld.OsResolverFallbackNever, []string{"upstream.0"}},
+		{"first", ctrld.OsResolverFallbackFirst, []string{upstreamOS, "upstream.0"}},
+		{"last", ctrld.OsResolverFallbackLast, []string{"upstream.0", upstreamOS}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			upstreams, upstreamConfigs := upstreamsWithOsResolver(tc.osResolver, []string{"upstream.0"}, []*ctrld.UpstreamConfig{uc})
+			if !slices.Equal(upstreams, tc.want) {
+				t.Errorf("unexpected upstreams, want: %v, got: %v", tc.want, upstreams)
+			}
+			if len(upstreamConfigs) != len(upstreams) {
+				t.Fatalf("mismatched upstream configs, want: %d, got: %d", len(upstreams), len(upstreamConfigs))
+			}
+			for i, upstream := range upstreams {
+				if upstream == upstreamOS && upstreamConfigs[i] != osUpstreamConfig {
+					t.Errorf("unexpected upstream config at %d: %v", i, upstreamConfigs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProxyFailClosed(t *testing.T) {
+	cfg := testhelper.SampleConfig(t)
+	cfg.Service.FailClosed = true
+	p := &prog{cfg: cfg, um: newUpstreamMonitor(cfg)}
+	p.um.down["upstream.0"] = true
+	// Upstreams are down, ctrld would leak queries to OS resolver if not in fail-closed mode.
+	p.leakingQuery.Store(true)
+
+	msg := newDnsMsgWithHostname("example.com", dns.TypeA)
+	msg.SetEdns0(4096, false)
+	res := p.proxy(context.Background(), &proxyRequest{
+		msg:        msg,
+		osResolver: ctrld.OsResolverFallbackLast,
+		ufr:        &upstreamForResult{upstreams: []string{"upstream.0"}, matched: true},
+	})
+	require.NotNil(t, res.answer)
+	assert.Equal(t, dns.RcodeServerFailure, res.answer.Rcode)
+	assert.Empty(t, res.upstream)
+	opt := res.answer.IsEdns0()
+	require.NotNil(t, opt)
+	require.Len(t, opt.Option, 1)
+	ede, ok := opt.Option[0].(*dns.EDNS0_EDE)
+	require.True(t, ok)
+	assert.Equal(t, dns.ExtendedErrorCodeNoReachableAuthority, ede.InfoCode)
+}
+
+func Test_upstreamConfigsFromUpstreamNumbers_bypass(t *testing.T) {
+	cfg := testhelper.SampleConfig(t)
+	p := &prog{cfg: cfg}
+	upstreamConfigs := p.upstreamConfigsFromUpstreamNumbers([]string{upstreamBypass, "upstream.0"})
+	require.Len(t, upstreamConfigs, 2)
+	assert.Same(t, bypassUpstreamConfig, upstreamConfigs[0])
+	assert.Same(t, cfg.Upstream["0"], upstreamConfigs[1])
+}
+
+// BenchmarkServeDNS measures the cost of serving a cached answer by a running listener,
+// the client side of the exchange is included.
+func BenchmarkServeDNS(b *testing.B) {
+	l := zerolog.Nop()
+	orig := mainLog.Load()
+	mainLog.Store(&l)
+	b.Cleanup(func() { mainLog.Store(orig) })
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(b, err)
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	require.NoError(b, pc.Close())
+
+	cfg := &ctrld.Config{
+		Listener: map[string]*ctrld.ListenerConfig{"0": {IP: "127.0.0.1", Port: port}},
+		Upstream: map[string]*ctrld.UpstreamConfig{"0": {Name: "test", Type: ctrld.ResolverTypeLegacy, Endpoint: "127.0.0.1:1"}},
+	}
+	cfg.Listener["0"].Init()
+	cache, err := dnscache.NewLRUCache(4096, 0, "")
+	require.NoError(b, err)
+	p := &prog{
+		cfg:          cfg,
+		cache:        cache,
+		stopCh:       make(chan struct{}),
+		started:      make(chan struct{}, 2),
+		queryPool:    &noopQueryPool{},
+		lanLoopGuard: newLoopGuard(),
+		ptrLoopGuard: newLoopGuard(),
+		um:           newUpstreamMonitor(cfg),
+		ciTable:      clientinfo.NewTable(cfg, "", "", nil),
+	}
+
+	msg := newDnsMsgWithHostname("example.com.", dns.TypeA)
+	msg.SetEdns0(1232, false)
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	answer.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(192, 0, 2, 1),
+	}}
+	v, err := dnscache.NewValue(answer, time.Now().Add(time.Hour))
+	require.NoError(b, err)
+	cache.Add(dnscache.NewKey(msg, upstreamPrefix+"0"), v)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- p.serveDNS("0") }()
+	b.Cleanup(func() {
+		close(p.stopCh)
+		<-errCh
+	})
+	// Both udp and tcp listeners must be started.
+	<-p.started
+	<-p.started
+
+	c := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	conn, err := c.Dial(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(b, err)
+	defer conn.Close()
+	// The first query populates client info of the benchmark client.
+	_, _, err = c.ExchangeWithConn(msg, conn)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, _, err := c.ExchangeWithConn(msg, conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(res.Answer) != 1 {
+			b.Fatalf("unexpected answer: %v", res)
+		}
+	}
+}
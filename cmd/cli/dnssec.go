@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"slices"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// dnssecRequest returns msg with the DO bit set according to DNSSEC mode of the policy. The msg is copied
+// if it needs to be changed, since the original request is still used for answering the client.
+func dnssecRequest(msg *dns.Msg, mode string) *dns.Msg {
+	opt := msg.IsEdns0()
+	switch mode {
+	case ctrld.DNSSECStrip:
+		if opt == nil || !opt.Do() {
+			return msg
+		}
+		msg = msg.Copy()
+		msg.IsEdns0().SetDo(false)
+	case ctrld.DNSSECRequest:
+		if opt != nil && opt.Do() {
+			return msg
+		}
+		msg = msg.Copy()
+		if opt == nil {
+			msg.SetEdns0(ctrld.DefaultEDNSBufferSize, true)
+		} else {
+			msg.IsEdns0().SetDo()
+		}
+	}
+	return msg
+}
+
+// dnssecAnswer returns the answer of client request req, with DNSSEC records removed if the client did not set
+// the DO bit, following RFC 3225, or if they are stripped by DNSSEC mode of the policy. Records of the queried
+// type are always kept. The original answer is left untouched, since it may be shared with the cache.
+func dnssecAnswer(req, answer *dns.Msg, mode string) *dns.Msg {
+	reqOpt := req.IsEdns0()
+	if answer == nil || len(req.Question) == 0 || (mode != ctrld.DNSSECStrip && reqOpt != nil && reqOpt.Do()) {
+		return answer
+	}
+	qtype := req.Question[0].Qtype
+	isDNSSEC := func(rr dns.RR) bool {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			return t != qtype
+		}
+		return false
+	}
+	// An OPT record was added to the request, it must not be returned to the client.
+	addedOpt := mode == ctrld.DNSSECRequest && reqOpt == nil
+	ansOpt := answer.IsEdns0()
+	if (ansOpt == nil || !ansOpt.Do() && !addedOpt) &&
+		!slices.ContainsFunc(answer.Answer, isDNSSEC) &&
+		!slices.ContainsFunc(answer.Ns, isDNSSEC) &&
+		!slices.ContainsFunc(answer.Extra, isDNSSEC) {
+		return answer
+	}
+	answer = answer.Copy()
+	answer.Answer = slices.DeleteFunc(answer.Answer, isDNSSEC)
+	answer.Ns = slices.DeleteFunc(answer.Ns, isDNSSEC)
+	answer.Extra = slices.DeleteFunc(answer.Extra, func(rr dns.RR) bool {
+		return isDNSSEC(rr) || (addedOpt && rr.Header().Rrtype == dns.TypeOPT)
+	})
+	if opt := answer.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+	return answer
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func newDNSSECQuery(t *testing.T, opt, do bool) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	if opt {
+		msg.SetEdns0(1232, do)
+	}
+	return msg
+}
+
+func Test_dnssecRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    bool
+		do     bool
+		mode   string
+		wantDo bool
+	}{
+		{"passthrough do", true, true, ctrld.DNSSECPassthrough, true},
+		{"passthrough", true, false, "", false},
+		{"strip", true, true, ctrld.DNSSECStrip, false},
+		{"request", true, false, ctrld.DNSSECRequest, true},
+		{"request without edns0", false, false, ctrld.DNSSECRequest, true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			msg := newDNSSECQuery(t, tc.opt, tc.do)
+			req := dnssecRequest(msg, tc.mode)
+			opt := req.IsEdns0()
+			assert.Equal(t, tc.wantDo, opt != nil && opt.Do())
+			// The original request must be left untouched.
+			opt = msg.IsEdns0()
+			assert.Equal(t, tc.do, opt != nil && opt.Do())
+		})
+	}
+}
+
+func Test_dnssecAnswer(t *testing.T) {
+	newAnswer := func(t *testing.T, req *dns.Msg) *dns.Msg {
+		answer := new(dns.Msg)
+		answer.SetReply(req)
+		answer.SetEdns0(1232, true)
+		a, err := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+		require.NoError(t, err)
+		sig, err := dns.NewRR("example.com. 300 IN RRSIG A 13 2 300 20250101000000 20240101000000 12345 example.com. dGVzdA==")
+		require.NoError(t, err)
+		nsec, err := dns.NewRR("example.com. 300 IN NSEC www.example.com. A RRSIG NSEC")
+		require.NoError(t, err)
+		answer.Answer = []dns.RR{a, sig}
+		answer.Ns = []dns.RR{nsec}
+		return answer
+	}
+	tests := []struct {
+		name       string
+		opt        bool
+		do         bool
+		mode       string
+		wantDNSSEC bool
+		wantOpt    bool
+	}{
+		{"passthrough do", true, true, "", true, true},
+		{"passthrough without do", true, false, ctrld.DNSSECPassthrough, false, true},
+		{"strip", true, true, ctrld.DNSSECStrip, false, true},
+		{"request do", true, true, ctrld.DNSSECRequest, true, true},
+		{"request without do", true, false, ctrld.DNSSECRequest, false, true},
+		{"request without edns0", false, false, ctrld.DNSSECRequest, false, false},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := newDNSSECQuery(t, tc.opt, tc.do)
+			answer := newAnswer(t, req)
+			got := dnssecAnswer(req, answer, tc.mode)
+			if tc.wantDNSSEC {
+				assert.Same(t, answer, got)
+				return
+			}
+			require.Len(t, got.Answer, 1)
+			assert.Equal(t, dns.TypeA, got.Answer[0].Header().Rrtype)
+			assert.Empty(t, got.Ns)
+			opt := got.IsEdns0()
+			assert.Equal(t, tc.wantOpt, opt != nil)
+			assert.False(t, opt != nil && opt.Do())
+			// The original answer must be left untouched, since it may be cached.
+			assert.Len(t, answer.Answer, 2)
+			assert.True(t, answer.IsEdns0().Do())
+		})
+	}
+
+	// Records of the queried type are kept.
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeNSEC)
+	answer := newAnswer(t, req)
+	got := dnssecAnswer(req, answer, ctrld.DNSSECStrip)
+	assert.Len(t, got.Answer, 1)
+	assert.Len(t, got.Ns, 1)
+}
@@ -0,0 +1,41 @@
+package cli
+
+import "strings"
+
+const (
+	// dohCanaryDomain is the canary domain which Firefox checks before enabling its own DoH resolver.
+	// Answering NXDOMAIN signals that the network is filtered, so Firefox keeps using ctrld.
+	dohCanaryDomain = "use-application-dns.net"
+
+	// Possible values of icloud_private_relay setting.
+	icloudPrivateRelayAllow = "allow"
+	icloudPrivateRelayBlock = "block"
+)
+
+// icloudPrivateRelayDomains are the iCloud Private Relay mask domains. Answering NXDOMAIN
+// signals that Private Relay is not available, so Apple devices keep using ctrld.
+var icloudPrivateRelayDomains = map[string]struct{}{
+	"mask.icloud.com":    {},
+	"mask-h2.icloud.com": {},
+}
+
+// blockDohCanary reports whether the DoH canary domain should be answered with NXDOMAIN.
+func (p *prog) blockDohCanary() bool {
+	if p.cfg.Service.BlockDohCanary == nil {
+		return true
+	}
+	return *p.cfg.Service.BlockDohCanary
+}
+
+// canaryBlockReason returns the reason why domain should be answered with NXDOMAIN,
+// or empty string if domain is not a canary domain, or canary blocking is disabled.
+func (p *prog) canaryBlockReason(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == dohCanaryDomain && p.blockDohCanary() {
+		return "DoH canary domain"
+	}
+	if _, ok := icloudPrivateRelayDomains[domain]; ok && p.cfg.Service.ICloudPrivateRelay == icloudPrivateRelayBlock {
+		return "iCloud Private Relay is blocked"
+	}
+	return ""
+}
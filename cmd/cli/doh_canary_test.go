@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_prog_canaryBlockReason(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name    string
+		service ctrld.ServiceConfig
+		domain  string
+		blocked bool
+	}{
+		{"doh canary blocked by default", ctrld.ServiceConfig{}, "use-application-dns.net.", true},
+		{"doh canary allowed", ctrld.ServiceConfig{BlockDohCanary: &disabled}, "use-application-dns.net.", false},
+		{"doh canary subdomain", ctrld.ServiceConfig{}, "www.use-application-dns.net.", false},
+		{"private relay allowed by default", ctrld.ServiceConfig{}, "mask.icloud.com.", false},
+		{"private relay blocked", ctrld.ServiceConfig{ICloudPrivateRelay: icloudPrivateRelayBlock}, "Mask-H2.icloud.com.", true},
+		{"private relay allowed", ctrld.ServiceConfig{ICloudPrivateRelay: icloudPrivateRelayAllow}, "mask.icloud.com.", false},
+		{"normal domain", ctrld.ServiceConfig{ICloudPrivateRelay: icloudPrivateRelayBlock}, "icloud.com.", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &prog{cfg: &ctrld.Config{Service: tc.service}}
+			if got := p.canaryBlockReason(tc.domain) != ""; got != tc.blocked {
+				t.Errorf("unexpected result, want: %v, got: %v", tc.blocked, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// domainList is a named domain list, composed of domains, wildcard domains and other lists.
+// A domain is in the list if it matches any included entries, and does not match any excluded ones.
+type domainList struct {
+	domains         []string
+	lists           []*domainList
+	excludedDomains []string
+	excludedLists   []*domainList
+}
+
+// domainLists holds all named domain lists of the config, so policy rules could reference them.
+type domainLists map[string]*domainList
+
+// newDomainLists returns new domainLists built from the given config lists.
+// The lists references were validated when loading config, so undefined
+// references are ignored here, and cyclic ones are only followed once.
+func newDomainLists(lists map[string][]string) domainLists {
+	dl := make(domainLists, len(lists))
+	for name := range lists {
+		dl[name] = &domainList{}
+	}
+	for name, entries := range lists {
+		l := dl[name]
+		for _, entry := range entries {
+			entry, excluded := strings.CutPrefix(entry, ctrld.DomainListExclude)
+			entry = strings.ToLower(entry)
+			if ref, ok := strings.CutPrefix(entry, ctrld.DomainListPrefix); ok {
+				rl := dl[ref]
+				if rl == nil {
+					continue
+				}
+				if excluded {
+					l.excludedLists = append(l.excludedLists, rl)
+				} else {
+					l.lists = append(l.lists, rl)
+				}
+				continue
+			}
+			if excluded {
+				l.excludedDomains = append(l.excludedDomains, entry)
+			} else {
+				l.domains = append(l.domains, entry)
+			}
+		}
+	}
+	return dl
+}
+
+// matches reports whether domain is in the list referenced by the rule source, e.g: "list.streaming".
+// It returns false if source is not a domain list reference.
+func (dl domainLists) matches(source, domain string) bool {
+	name, ok := strings.CutPrefix(source, ctrld.DomainListPrefix)
+	if !ok {
+		return false
+	}
+	l := dl[name]
+	if l == nil {
+		return false
+	}
+	return l.contains(strings.ToLower(domain), make(map[*domainList]bool))
+}
+
+// contains reports whether domain is in the list. The seen map guards against cyclic references.
+func (l *domainList) contains(domain string, seen map[*domainList]bool) bool {
+	if seen[l] {
+		return false
+	}
+	seen[l] = true
+	defer delete(seen, l)
+
+	for _, entry := range l.excludedDomains {
+		if entry == domain || wildcardMatches(entry, domain) {
+			return false
+		}
+	}
+	for _, el := range l.excludedLists {
+		if el.contains(domain, seen) {
+			return false
+		}
+	}
+	for _, entry := range l.domains {
+		if entry == domain || wildcardMatches(entry, domain) {
+			return true
+		}
+	}
+	for _, il := range l.lists {
+		if il.contains(domain, seen) {
+			return true
+		}
+	}
+	return false
+}
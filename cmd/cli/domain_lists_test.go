@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_domainLists_matches(t *testing.T) {
+	dl := newDomainLists(map[string][]string{
+		"streaming": {"*.netflix.com", "youtube.com", "list.music"},
+		"music":     {"*.spotify.com"},
+		"video":     {"list.streaming", "!list.music", "!kids.youtube.com"},
+		"cycle":     {"list.cycle", "example.com"},
+	})
+	tests := []struct {
+		name   string
+		source string
+		domain string
+		want   bool
+	}{
+		{"domain", "list.streaming", "YouTube.com", true},
+		{"wildcard", "list.streaming", "www.netflix.com", true},
+		{"nested list", "list.streaming", "open.spotify.com", true},
+		{"not in list", "list.streaming", "example.com", false},
+		{"excluded list", "list.video", "open.spotify.com", false},
+		{"excluded domain", "list.video", "kids.youtube.com", false},
+		{"union", "list.video", "www.netflix.com", true},
+		{"cyclic list", "list.cycle", "example.com", true},
+		{"cyclic list no match", "list.cycle", "example.net", false},
+		{"undefined list", "list.undefined", "youtube.com", false},
+		{"not list reference", "youtube.com", "youtube.com", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, dl.matches(tc.source, tc.domain))
+		})
+	}
+
+	var nilLists domainLists
+	assert.False(t, nilLists.matches("list.streaming", "youtube.com"))
+}
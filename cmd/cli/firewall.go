@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go4.org/netipx"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// firewallDoHIPv4 and firewallDoHIPv6 are addresses of well-known public resolvers, which devices
+// may use with hard-coded DoH/DoT configurations to bypass ctrld.
+var (
+	firewallDoHIPv4 = []string{
+		"1.1.1.1", "1.0.0.1", "1.1.1.2", "1.0.0.2", "1.1.1.3", "1.0.0.3", // Cloudflare
+		"8.8.8.8", "8.8.4.4", // Google
+		"9.9.9.9", "149.112.112.112", "9.9.9.11", "149.112.112.11", // Quad9
+		"208.67.222.222", "208.67.220.220", // OpenDNS
+		"94.140.14.14", "94.140.15.15", // AdGuard
+		"45.90.28.0", "45.90.30.0", // NextDNS
+		"185.228.168.168", "185.228.169.168", // CleanBrowsing
+	}
+	firewallDoHIPv6 = []string{
+		"2606:4700:4700::1111", "2606:4700:4700::1001", // Cloudflare
+		"2001:4860:4860::8888", "2001:4860:4860::8844", // Google
+		"2620:fe::fe", "2620:fe::9", // Quad9
+		"2620:119:35::35", "2620:119:53::53", // OpenDNS
+		"2a10:50c0::ad1:ff", "2a10:50c0::ad2:ff", // AdGuard
+	}
+)
+
+// firewallConfig describes the firewall rules forcing DNS traffic through ctrld.
+type firewallConfig struct {
+	// ip and port of the listener which DNS traffic is redirected to.
+	ip   string
+	port int
+	// blockDoH reports whether DoT and well-known DoH resolvers are blocked.
+	blockDoH bool
+	// tproxy reports whether DNS traffic is diverted using TPROXY instead of NAT, Linux only.
+	tproxy bool
+	// exempt contains addresses which ctrld itself connects to, so they must not be blocked.
+	exempt []string
+}
+
+// firewallListener returns the number of the listener which DNS traffic is redirected to.
+func (p *prog) firewallListener() string {
+	nums := slices.Sorted(maps.Keys(p.cfg.Listener))
+	if len(nums) == 0 {
+		return ""
+	}
+	return nums[0]
+}
+
+// firewallConfig returns the firewall config for redirecting DNS traffic to the first listener.
+func (p *prog) firewallConfig() *firewallConfig {
+	fc := &firewallConfig{blockDoH: true, tproxy: p.tproxyEnabled()}
+	if b := p.cfg.Service.FirewallBlockDoH; b != nil {
+		fc.blockDoH = *b
+	}
+	fc.exempt = ctrld.OutboundNameservers()
+	for _, uc := range p.cfg.Upstream {
+		fc.exempt = append(fc.exempt, uc.BootstrapIPs()...)
+		if uc.BootstrapIP != "" {
+			fc.exempt = append(fc.exempt, uc.BootstrapIP)
+		}
+		if host, _, err := net.SplitHostPort(uc.Endpoint); err == nil && net.ParseIP(host) != nil {
+			fc.exempt = append(fc.exempt, host)
+		} else if net.ParseIP(uc.Endpoint) != nil {
+			fc.exempt = append(fc.exempt, uc.Endpoint)
+		}
+	}
+	slices.Sort(fc.exempt)
+	fc.exempt = slices.Compact(fc.exempt)
+	lc := p.cfg.Listener[p.firewallListener()]
+	if lc == nil {
+		return fc
+	}
+	fc.ip, fc.port = lc.IP, lc.Port
+	return fc
+}
+
+// blockedDoHIPs returns addresses of well-known DoH resolvers which are not exempted.
+func (fc *firewallConfig) blockedDoHIPs() []string {
+	return slices.DeleteFunc(slices.Concat(firewallDoHIPv4, firewallDoHIPv6), func(ip string) bool {
+		return slices.Contains(fc.exempt, ip)
+	})
+}
+
+// blockRanges returns internet address ranges, formed "from-to", excluding local, private and
+// multicast networks, and exempted addresses.
+func (fc *firewallConfig) blockRanges() []string {
+	var b netipx.IPSetBuilder
+	b.AddPrefix(netip.MustParsePrefix("0.0.0.0/0"))
+	b.AddPrefix(netip.MustParsePrefix("::/0"))
+	for _, prefix := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/3",
+		"::/127", "::ffff:0:0/96", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		b.RemovePrefix(netip.MustParsePrefix(prefix))
+	}
+	for _, ip := range fc.exempt {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			b.Remove(addr.Unmap())
+		}
+	}
+	set, err := b.IPSet()
+	if err != nil {
+		return nil
+	}
+	ranges := make([]string, 0, len(set.Ranges()))
+	for _, r := range set.Ranges() {
+		ranges = append(ranges, r.From().String()+"-"+r.To().String())
+	}
+	return ranges
+}
+
+// redirectIP returns the IPv4 address which DNS traffic is redirected to.
+func (fc *firewallConfig) redirectIP() string {
+	if ip := net.ParseIP(fc.ip); ip != nil && ip.To4() != nil && !ip.IsUnspecified() {
+		return fc.ip
+	}
+	return "127.0.0.1"
+}
+
+// installFirewall installs firewall rules, removing any stale rules left by unclean shutdown first.
+func (p *prog) installFirewall() {
+	p.firewallMu.Lock()
+	defer p.firewallMu.Unlock()
+	p.installFirewallLocked(p.firewallConfig())
+}
+
+func (p *prog) installFirewallLocked(fc *firewallConfig) {
+	// Jails without vnet share the host network stack, so pf rules could only be managed by the host.
+	if jailed, vnet := jailStatus(); jailed && !vnet {
+		mainLog.Load().Warn().Msg("firewall rules could not be installed in jail without vnet, they must be installed on the host")
+		return
+	}
+	if p.cfg.Service.FirewallRedirectMode == ctrld.FirewallRedirectModeTProxy && !fc.tproxy {
+		mainLog.Load().Warn().Msg("tproxy mode is only supported on Linux, redirecting DNS traffic using NAT")
+	}
+	_ = removeFirewallRules()
+	p.firewall = nil
+	if err := installFirewallRules(fc); err != nil {
+		mainLog.Load().Error().Err(err).Msg("could not install firewall rules")
+		// Do not leave partially installed rules.
+		_ = removeFirewallRules()
+		return
+	}
+	p.firewall = fc
+	mainLog.Load().Notice().Msgf("installed firewall rules, redirecting DNS traffic to port: %d", fc.port)
+}
+
+// refreshFirewall updates firewall rules after config was reloaded, so addresses exempted from the rules, e.g: of
+// new upstreams on Windows, and the listener which DNS traffic is redirected to are up to date. The rules are removed
+// if firewall_redirect was disabled.
+func (p *prog) refreshFirewall() {
+	p.firewallMu.Lock()
+	defer p.firewallMu.Unlock()
+	if !p.cfg.Service.FirewallRedirect {
+		p.removeFirewallLocked()
+		return
+	}
+	fc := p.firewallConfig()
+	if p.firewall != nil && reflect.DeepEqual(fc, p.firewall) {
+		return
+	}
+	p.installFirewallLocked(fc)
+}
+
+// removeFirewall removes firewall rules installed by installFirewall.
+func (p *prog) removeFirewall() {
+	p.firewallMu.Lock()
+	defer p.firewallMu.Unlock()
+	p.removeFirewallLocked()
+}
+
+func (p *prog) removeFirewallLocked() {
+	if p.firewall == nil {
+		return
+	}
+	if err := removeFirewallRules(); err != nil {
+		mainLog.Load().Error().Err(err).Msg("could not remove firewall rules")
+		return
+	}
+	p.firewall = nil
+	mainLog.Load().Debug().Msg("removed firewall rules")
+}
+
+// runFirewallCmd runs the firewall command, with input as its stdin if not empty.
+func runFirewallCmd(input, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
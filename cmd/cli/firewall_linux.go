@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// nftTable is the nftables table containing ctrld rules.
+	nftTable = "ctrld"
+	// iptablesChain is the iptables chain containing ctrld rules, in nat, mangle and filter tables.
+	iptablesChain = "CTRLD"
+	// tproxyMark is the firewall mark of DNS traffic diverted by TPROXY rules, which is routed
+	// to the local machine using tproxyRouteTable.
+	tproxyMark       = "0x6c64"
+	tproxyRouteTable = "27748"
+	// routeLocalnetSysctl allows routing traffic of other hosts to loopback addresses, needed for DNAT to
+	// listener on 127.0.0.0/8. Its old value is saved to routeLocalnetStateFile, and restored on removal.
+	routeLocalnetSysctl    = "/proc/sys/net/ipv4/conf/all/route_localnet"
+	routeLocalnetStateFile = "firewall_route_localnet"
+)
+
+// listenerAddr returns the address of the listener which DNS traffic is redirected to, or false if the
+// listener is on wildcard address, so traffic is redirected to the primary address of the incoming interface.
+func (fc *firewallConfig) listenerAddr() (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(fc.ip)
+	if err != nil || addr.IsUnspecified() {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// installFirewallRules installs rules redirecting DNS traffic forwarded by this machine to ctrld,
+// using nftables if available, falling back to iptables.
+func installFirewallRules(fc *firewallConfig) error {
+	if fc.tproxy {
+		if err := installTProxyRoutes(); err != nil {
+			return err
+		}
+	}
+	if addr, ok := fc.listenerAddr(); ok && !fc.tproxy && addr.Is4() && addr.IsLoopback() {
+		if err := enableRouteLocalnet(); err != nil {
+			return err
+		}
+	}
+	if _, err := exec.LookPath("nft"); err == nil {
+		_, err := runFirewallCmd(nftRules(fc), "nft", "-f", "-")
+		return err
+	}
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		for _, args := range iptablesRules(fc, bin == "ip6tables") {
+			if _, err := runFirewallCmd("", bin, args...); err != nil {
+				// IPv6 nat table may not be available.
+				if bin == "ip6tables" {
+					mainLog.Load().Warn().Err(err).Msg("could not install ip6tables rules")
+					break
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeFirewallRules removes rules installed by installFirewallRules.
+func removeFirewallRules() error {
+	removeTProxyRoutes()
+	restoreRouteLocalnet()
+	if _, err := exec.LookPath("nft"); err == nil {
+		if _, err := runFirewallCmd("", "nft", "list", "table", "inet", nftTable); err != nil {
+			return nil
+		}
+		_, err := runFirewallCmd("", "nft", "delete", "table", "inet", nftTable)
+		return err
+	}
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			continue
+		}
+		for _, table := range []string{"nat", "mangle", "filter"} {
+			hook := "PREROUTING"
+			if table == "filter" {
+				hook = "FORWARD"
+			}
+			if _, err := runFirewallCmd("", bin, "-t", table, "-n", "-L", iptablesChain); err != nil {
+				continue
+			}
+			// Delete all jumps to ctrld chain, then the chain itself.
+			for {
+				if _, err := runFirewallCmd("", bin, "-t", table, "-D", hook, "-j", iptablesChain); err != nil {
+					break
+				}
+			}
+			if _, err := runFirewallCmd("", bin, "-t", table, "-F", iptablesChain); err != nil {
+				return err
+			}
+			if _, err := runFirewallCmd("", bin, "-t", table, "-X", iptablesChain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nftRules returns the nftables script installing ctrld rules.
+func nftRules(fc *firewallConfig) string {
+	var sb strings.Builder
+	port := strconv.Itoa(fc.port)
+	fmt.Fprintf(&sb, "table inet %s {\n", nftTable)
+	if fc.blockDoH {
+		fmt.Fprintf(&sb, "\tset doh_ipv4 {\n\t\ttype ipv4_addr\n\t\telements = { %s }\n\t}\n", strings.Join(firewallDoHIPv4, ", "))
+		fmt.Fprintf(&sb, "\tset doh_ipv6 {\n\t\ttype ipv6_addr\n\t\telements = { %s }\n\t}\n", strings.Join(firewallDoHIPv6, ", "))
+	}
+	sb.WriteString("\tchain prerouting {\n")
+	if fc.tproxy {
+		sb.WriteString("\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+		sb.WriteString("\t\tfib daddr type local return\n")
+		// Without address, TPROXY delivers traffic to the primary address of the incoming interface, which the
+		// listener only accepts if it's on wildcard address. Otherwise, traffic of the same address family is
+		// delivered to the listener address.
+		if addr, ok := fc.listenerAddr(); ok {
+			family, nfproto := "ip", "ipv4"
+			if addr.Is6() {
+				family, nfproto = "ip6", "ipv6"
+			}
+			target := net.JoinHostPort(addr.String(), port)
+			fmt.Fprintf(&sb, "\t\tmeta nfproto %s udp dport 53 tproxy %s to %s meta mark set %s accept\n", nfproto, family, target, tproxyMark)
+			fmt.Fprintf(&sb, "\t\tmeta nfproto %s tcp dport 53 tproxy %s to %s meta mark set %s accept\n", nfproto, family, target, tproxyMark)
+		} else {
+			fmt.Fprintf(&sb, "\t\tudp dport 53 tproxy to :%s meta mark set %s accept\n", port, tproxyMark)
+			fmt.Fprintf(&sb, "\t\ttcp dport 53 tproxy to :%s meta mark set %s accept\n", port, tproxyMark)
+		}
+	} else {
+		sb.WriteString("\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+		sb.WriteString("\t\tfib daddr type local return\n")
+		// Redirecting changes the destination to the primary address of the incoming interface, which is only
+		// reachable if the listener is on wildcard address. Otherwise, DNAT to the listener address, which only
+		// applies to traffic of the same address family.
+		if addr, ok := fc.listenerAddr(); ok {
+			family, nfproto := "ip", "ipv4"
+			if addr.Is6() {
+				family, nfproto = "ip6", "ipv6"
+			}
+			target := net.JoinHostPort(addr.String(), port)
+			fmt.Fprintf(&sb, "\t\tmeta nfproto %s udp dport 53 dnat %s to %s\n", nfproto, family, target)
+			fmt.Fprintf(&sb, "\t\tmeta nfproto %s tcp dport 53 dnat %s to %s\n", nfproto, family, target)
+		} else {
+			fmt.Fprintf(&sb, "\t\tudp dport 53 redirect to :%s\n", port)
+			fmt.Fprintf(&sb, "\t\ttcp dport 53 redirect to :%s\n", port)
+		}
+	}
+	sb.WriteString("\t}\n")
+	if fc.blockDoH {
+		sb.WriteString("\tchain forward {\n")
+		sb.WriteString("\t\ttype filter hook forward priority filter; policy accept;\n")
+		sb.WriteString("\t\tmeta l4proto { tcp, udp } th dport 853 reject\n")
+		sb.WriteString("\t\tip daddr @doh_ipv4 meta l4proto { tcp, udp } th dport 443 reject\n")
+		sb.WriteString("\t\tip6 daddr @doh_ipv6 meta l4proto { tcp, udp } th dport 443 reject\n")
+		sb.WriteString("\t}\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// iptablesRules returns the iptables arguments installing ctrld rules.
+func iptablesRules(fc *firewallConfig, v6 bool) [][]string {
+	port := strconv.Itoa(fc.port)
+	table := "nat"
+	if fc.tproxy {
+		table = "mangle"
+	}
+	rules := [][]string{
+		{"-t", table, "-N", iptablesChain},
+		{"-t", table, "-A", iptablesChain, "-m", "addrtype", "--dst-type", "LOCAL", "-j", "RETURN"},
+	}
+	addr, specific := fc.listenerAddr()
+	for _, proto := range []string{"udp", "tcp"} {
+		if fc.tproxy {
+			tproxy := []string{"-t", table, "-A", iptablesChain, "-p", proto, "--dport", "53", "-j", "TPROXY", "--on-port", port}
+			switch {
+			case !specific:
+				rules = append(rules, append(tproxy, "--tproxy-mark", tproxyMark))
+			case addr.Is6() == v6:
+				// See nftRules for why the listener address is needed.
+				rules = append(rules, append(tproxy, "--on-ip", addr.String(), "--tproxy-mark", tproxyMark))
+			}
+			continue
+		}
+		switch {
+		case !specific:
+			rules = append(rules, []string{"-t", table, "-A", iptablesChain, "-p", proto, "--dport", "53", "-j", "REDIRECT", "--to-ports", port})
+		case addr.Is6() == v6:
+			// See nftRules for why DNAT is used for listener on specific address.
+			target := net.JoinHostPort(addr.String(), port)
+			rules = append(rules, []string{"-t", table, "-A", iptablesChain, "-p", proto, "--dport", "53", "-j", "DNAT", "--to-destination", target})
+		}
+	}
+	rules = append(rules, []string{"-t", table, "-I", "PREROUTING", "-j", iptablesChain})
+	if !fc.blockDoH {
+		return rules
+	}
+	ips := firewallDoHIPv4
+	if v6 {
+		ips = firewallDoHIPv6
+	}
+	rules = append(rules, []string{"-t", "filter", "-N", iptablesChain})
+	for _, proto := range []string{"udp", "tcp"} {
+		rules = append(rules, []string{"-t", "filter", "-A", iptablesChain, "-p", proto, "--dport", "853", "-j", "REJECT"})
+		rules = append(rules, []string{"-t", "filter", "-A", iptablesChain, "-p", proto, "-d", strings.Join(ips, ","), "--dport", "443", "-j", "REJECT"})
+	}
+	rules = append(rules, []string{"-t", "filter", "-I", "FORWARD", "-j", iptablesChain})
+	return rules
+}
+
+// enableRouteLocalnet enables routing of forwarded traffic to loopback addresses, so DNS traffic could be
+// DNAT'ed to ctrld listening on 127.0.0.0/8. The old value is saved, to be restored by restoreRouteLocalnet.
+func enableRouteLocalnet() error {
+	old, err := os.ReadFile(routeLocalnetSysctl)
+	if err != nil {
+		return fmt.Errorf("reading route_localnet: %w", err)
+	}
+	if strings.TrimSpace(string(old)) == "1" {
+		return nil
+	}
+	stateFile := absHomeDir(routeLocalnetStateFile)
+	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
+		if err := os.WriteFile(stateFile, old, 0600); err != nil {
+			return fmt.Errorf("saving route_localnet: %w", err)
+		}
+	}
+	if err := os.WriteFile(routeLocalnetSysctl, []byte("1"), 0644); err != nil {
+		return fmt.Errorf("enabling route_localnet: %w", err)
+	}
+	return nil
+}
+
+// restoreRouteLocalnet restores the route_localnet value saved by enableRouteLocalnet, if any.
+func restoreRouteLocalnet() {
+	stateFile := absHomeDir(routeLocalnetStateFile)
+	old, err := os.ReadFile(stateFile)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(routeLocalnetSysctl, []byte(strings.TrimSpace(string(old))), 0644); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not restore route_localnet")
+		return
+	}
+	_ = os.Remove(stateFile)
+}
+
+// installTProxyRoutes routes traffic marked by TPROXY rules to the local machine, so it's delivered to ctrld.
+func installTProxyRoutes() error {
+	removeTProxyRoutes()
+	for _, family := range []string{"-4", "-6"} {
+		dst := "0.0.0.0/0"
+		if family == "-6" {
+			dst = "::/0"
+		}
+		_, err := runFirewallCmd("", "ip", family, "rule", "add", "fwmark", tproxyMark, "lookup", tproxyRouteTable)
+		if err == nil {
+			_, err = runFirewallCmd("", "ip", family, "route", "add", "local", dst, "dev", "lo", "table", tproxyRouteTable)
+		}
+		if err != nil {
+			// IPv6 may not be available.
+			if family == "-6" {
+				mainLog.Load().Warn().Err(err).Msg("could not install ipv6 tproxy routes")
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTProxyRoutes removes routes installed by installTProxyRoutes.
+func removeTProxyRoutes() {
+	if _, err := exec.LookPath("ip"); err != nil {
+		return
+	}
+	for _, family := range []string{"-4", "-6"} {
+		for {
+			if _, err := runFirewallCmd("", "ip", family, "rule", "del", "fwmark", tproxyMark, "lookup", tproxyRouteTable); err != nil {
+				break
+			}
+		}
+		_, _ = runFirewallCmd("", "ip", family, "route", "flush", "table", tproxyRouteTable)
+	}
+}
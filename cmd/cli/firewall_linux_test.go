@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_nftRules(t *testing.T) {
+	rules := nftRules(&firewallConfig{port: 5354, blockDoH: true})
+	for _, want := range []string{
+		"table inet ctrld {",
+		"udp dport 53 redirect to :5354",
+		"tcp dport 53 redirect to :5354",
+		"fib daddr type local return",
+		"ip daddr @doh_ipv4 meta l4proto { tcp, udp } th dport 443 reject",
+		"8.8.8.8",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("missing %q in rules:\n%s", want, rules)
+		}
+	}
+	rules = nftRules(&firewallConfig{port: 53})
+	if strings.Contains(rules, "chain forward") || strings.Contains(rules, "doh_ipv4") {
+		t.Errorf("unexpected DoH blocking rules:\n%s", rules)
+	}
+}
+
+func Test_nftRules_listenerAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want []string
+	}{
+		{"wildcard", "0.0.0.0", []string{"udp dport 53 redirect to :5354", "tcp dport 53 redirect to :5354"}},
+		{"ipv4", "127.0.0.1", []string{
+			"meta nfproto ipv4 udp dport 53 dnat ip to 127.0.0.1:5354",
+			"meta nfproto ipv4 tcp dport 53 dnat ip to 127.0.0.1:5354",
+		}},
+		{"ipv6", "fd00::1", []string{
+			"meta nfproto ipv6 udp dport 53 dnat ip6 to [fd00::1]:5354",
+			"meta nfproto ipv6 tcp dport 53 dnat ip6 to [fd00::1]:5354",
+		}},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rules := nftRules(&firewallConfig{ip: tc.ip, port: 5354})
+			for _, want := range tc.want {
+				if !strings.Contains(rules, want) {
+					t.Errorf("missing %q in rules:\n%s", want, rules)
+				}
+			}
+			if tc.ip != "0.0.0.0" && strings.Contains(rules, "redirect") {
+				t.Errorf("unexpected redirect rules:\n%s", rules)
+			}
+		})
+	}
+}
+
+func Test_iptablesRules_listenerAddr(t *testing.T) {
+	fc := &firewallConfig{ip: "192.168.1.1", port: 5354}
+	dnat := []string{"-t", "nat", "-A", iptablesChain, "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", "192.168.1.1:5354"}
+	if !slices.ContainsFunc(iptablesRules(fc, false), func(r []string) bool { return slices.Equal(r, dnat) }) {
+		t.Errorf("missing dnat rule: %v", iptablesRules(fc, false))
+	}
+	for _, r := range iptablesRules(fc, true) {
+		if slices.Contains(r, "DNAT") || slices.Contains(r, "REDIRECT") {
+			t.Errorf("unexpected ipv6 redirect rule for ipv4 listener: %v", r)
+		}
+	}
+}
+
+func Test_iptablesRules(t *testing.T) {
+	rules := iptablesRules(&firewallConfig{port: 5354, blockDoH: true}, false)
+	redirect := []string{"-t", "nat", "-A", iptablesChain, "-p", "udp", "--dport", "53", "-j", "REDIRECT", "--to-ports", "5354"}
+	if !slices.ContainsFunc(rules, func(r []string) bool { return slices.Equal(r, redirect) }) {
+		t.Errorf("missing redirect rule: %v", rules)
+	}
+	if last := rules[len(rules)-1]; !slices.Equal(last, []string{"-t", "filter", "-I", "FORWARD", "-j", iptablesChain}) {
+		t.Errorf("unexpected last rule: %v", last)
+	}
+	for _, r := range iptablesRules(&firewallConfig{port: 5354}, true) {
+		if slices.Contains(r, "filter") {
+			t.Errorf("unexpected filter rule: %v", r)
+		}
+	}
+}
+
+func Test_firewallRules_tproxy(t *testing.T) {
+	fc := &firewallConfig{port: 5354, tproxy: true}
+	rules := nftRules(fc)
+	for _, want := range []string{
+		"type filter hook prerouting priority mangle; policy accept;",
+		"udp dport 53 tproxy to :5354 meta mark set " + tproxyMark + " accept",
+		"tcp dport 53 tproxy to :5354 meta mark set " + tproxyMark + " accept",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("missing %q in rules:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(rules, "redirect") {
+		t.Errorf("unexpected redirect rules:\n%s", rules)
+	}
+	tproxy := []string{"-t", "mangle", "-A", iptablesChain, "-p", "udp", "--dport", "53", "-j", "TPROXY", "--on-port", "5354", "--tproxy-mark", tproxyMark}
+	if !slices.ContainsFunc(iptablesRules(fc, false), func(r []string) bool { return slices.Equal(r, tproxy) }) {
+		t.Errorf("missing tproxy rule: %v", iptablesRules(fc, false))
+	}
+}
+
+func Test_firewallRules_tproxyListenerAddr(t *testing.T) {
+	fc := &firewallConfig{ip: "127.0.0.1", port: 5354, tproxy: true}
+	rules := nftRules(fc)
+	for _, want := range []string{
+		"meta nfproto ipv4 udp dport 53 tproxy ip to 127.0.0.1:5354 meta mark set " + tproxyMark + " accept",
+		"meta nfproto ipv4 tcp dport 53 tproxy ip to 127.0.0.1:5354 meta mark set " + tproxyMark + " accept",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("missing %q in rules:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(rules, "tproxy to :") {
+		t.Errorf("unexpected wildcard tproxy rules:\n%s", rules)
+	}
+	tproxy := []string{"-t", "mangle", "-A", iptablesChain, "-p", "udp", "--dport", "53", "-j", "TPROXY", "--on-port", "5354", "--on-ip", "127.0.0.1", "--tproxy-mark", tproxyMark}
+	if !slices.ContainsFunc(iptablesRules(fc, false), func(r []string) bool { return slices.Equal(r, tproxy) }) {
+		t.Errorf("missing tproxy rule: %v", iptablesRules(fc, false))
+	}
+	for _, r := range iptablesRules(fc, true) {
+		if slices.Contains(r, "TPROXY") {
+			t.Errorf("unexpected ipv6 tproxy rule for ipv4 listener: %v", r)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package cli
+
+import "errors"
+
+var errFirewallUnsupported = errors.New("firewall rules are not supported on this platform")
+
+func installFirewallRules(fc *firewallConfig) error { return errFirewallUnsupported }
+
+func removeFirewallRules() error { return nil }
@@ -0,0 +1,78 @@
+//go:build darwin || freebsd
+
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	// pfToken is the reference token returned when enabling pf on macOS, used for releasing it.
+	pfToken   string
+	pfTokenMu sync.Mutex
+
+	pfTokenRe = regexp.MustCompile(`Token : (\d+)`)
+)
+
+// pfAnchor returns the pf anchor containing ctrld rules. On macOS, the anchor is put under
+// "com.apple", which is already referenced by the default /etc/pf.conf. On FreeBSD, pf.conf
+// must contain both `rdr-anchor "ctrld"` and `anchor "ctrld"`.
+func pfAnchor() string {
+	if runtime.GOOS == "darwin" {
+		return "com.apple/ctrld"
+	}
+	return "ctrld"
+}
+
+// installFirewallRules installs pf rules redirecting DNS traffic forwarded by this machine to ctrld.
+func installFirewallRules(fc *firewallConfig) error {
+	if _, err := runFirewallCmd(pfRules(fc), "pfctl", "-a", pfAnchor(), "-f", "-"); err != nil {
+		return err
+	}
+	if runtime.GOOS != "darwin" {
+		_, _ = runFirewallCmd("", "pfctl", "-e")
+		return nil
+	}
+	// On macOS, pf is enabled with a reference, so it's kept enabled while ctrld is running.
+	out, err := runFirewallCmd("", "pfctl", "-E")
+	if err != nil {
+		return err
+	}
+	if m := pfTokenRe.FindStringSubmatch(out); len(m) == 2 {
+		pfTokenMu.Lock()
+		pfToken = m[1]
+		pfTokenMu.Unlock()
+	}
+	return nil
+}
+
+// removeFirewallRules removes rules installed by installFirewallRules.
+func removeFirewallRules() error {
+	pfTokenMu.Lock()
+	token := pfToken
+	pfToken = ""
+	pfTokenMu.Unlock()
+	if token != "" {
+		_, _ = runFirewallCmd("", "pfctl", "-X", token)
+	}
+	_, err := runFirewallCmd("", "pfctl", "-a", pfAnchor(), "-F", "all")
+	return err
+}
+
+// pfRules returns the pf rules of ctrld anchor.
+func pfRules(fc *firewallConfig) string {
+	var sb strings.Builder
+	if fc.blockDoH {
+		fmt.Fprintf(&sb, "table <ctrld_doh> const { %s %s }\n", strings.Join(firewallDoHIPv4, " "), strings.Join(firewallDoHIPv6, " "))
+	}
+	fmt.Fprintf(&sb, "rdr pass proto { tcp udp } from any to ! self port 53 -> %s port %d\n", fc.redirectIP(), fc.port)
+	if fc.blockDoH {
+		sb.WriteString("block return in quick proto { tcp udp } from any to any port 853\n")
+		sb.WriteString("block return in quick proto { tcp udp } from any to <ctrld_doh> port 443\n")
+	}
+	return sb.String()
+}
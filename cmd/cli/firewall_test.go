@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_firewallConfig_blockRanges(t *testing.T) {
+	fc := &firewallConfig{exempt: []string{"76.76.2.22", "2606:4700:4700::1111", "192.168.1.1", "invalid"}}
+	ranges := fc.blockRanges()
+	for _, want := range []string{
+		"1.0.0.0-9.255.255.255",
+		"11.0.0.0-76.76.2.21",
+		"76.76.2.23-100.63.255.255",
+		"2606:4700:4700::1112-fbff:ffff:ffff:ffff:ffff:ffff:ffff:ffff",
+	} {
+		if !slices.Contains(ranges, want) {
+			t.Errorf("missing range %q in %v", want, ranges)
+		}
+	}
+	for _, r := range ranges {
+		if r == "0.0.0.0-255.255.255.255" {
+			t.Errorf("unexpected range: %s", r)
+		}
+	}
+}
+
+func Test_firewallConfig_blockedDoHIPs(t *testing.T) {
+	fc := &firewallConfig{exempt: []string{"1.1.1.1"}}
+	ips := fc.blockedDoHIPs()
+	if slices.Contains(ips, "1.1.1.1") {
+		t.Errorf("exempted address is blocked: %v", ips)
+	}
+	if !slices.Contains(ips, "8.8.8.8") {
+		t.Errorf("missing address 8.8.8.8: %v", ips)
+	}
+}
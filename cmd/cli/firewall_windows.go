@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// firewallRuleGroup is the Windows Firewall group containing ctrld rules.
+const firewallRuleGroup = "ctrld"
+
+// installFirewallRules installs Windows Firewall rules blocking outbound DNS traffic to the internet,
+// so applications can't bypass ctrld. Windows Firewall can't redirect traffic, and block rules always
+// take precedence over allow rules, so addresses which ctrld itself uses are excluded from the rules.
+func installFirewallRules(fc *firewallConfig) error {
+	ranges := fc.blockRanges()
+	if len(ranges) == 0 {
+		return fmt.Errorf("no address ranges to block")
+	}
+	for _, cmd := range windowsFirewallRules(fc, ranges) {
+		if out, err := powershell(cmd); err != nil {
+			return fmt.Errorf("%s: %w", string(out), err)
+		}
+	}
+	return nil
+}
+
+// removeFirewallRules removes rules installed by installFirewallRules.
+func removeFirewallRules() error {
+	cmd := fmt.Sprintf("Remove-NetFirewallRule -Group '%s' -ErrorAction SilentlyContinue", firewallRuleGroup)
+	if out, err := powershell(cmd); err != nil {
+		return fmt.Errorf("%s: %w", string(out), err)
+	}
+	return nil
+}
+
+// windowsFirewallRules returns the powershell commands creating ctrld rules.
+func windowsFirewallRules(fc *firewallConfig, ranges []string) []string {
+	var cmds []string
+	add := func(name, port string, addrs []string) {
+		for _, proto := range []string{"UDP", "TCP"} {
+			cmds = append(cmds, fmt.Sprintf(
+				"New-NetFirewallRule -Group '%s' -DisplayName 'ctrld: %s (%s)' -Direction Outbound -Action Block -Protocol %s -RemotePort %s -RemoteAddress %s | Out-Null",
+				firewallRuleGroup, name, proto, proto, port, strings.Join(addrs, ","),
+			))
+		}
+	}
+	add("block DNS", "53", ranges)
+	if fc.blockDoH {
+		add("block DoT", "853", ranges)
+		if ips := fc.blockedDoHIPs(); len(ips) > 0 {
+			add("block DoH", "443", ips)
+		}
+	}
+	return cmds
+}
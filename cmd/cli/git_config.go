@@ -0,0 +1,215 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	gitConfigDirName         = "git_config"
+	gitConfigRevFileName     = "git_config.rev"
+	gitConfigDefaultFile     = "ctrld.toml"
+	gitConfigDefaultInterval = 5 * time.Minute
+	gitConfigCmdTimeout      = 2 * time.Minute
+	// gitConfigReloadTimeout is the maximum time waiting for ctrld to reload with the pulled config.
+	gitConfigReloadTimeout = 30 * time.Second
+)
+
+// gitConfigSync pulls ctrld config from a Git repository, so fleets of devices could be managed
+// by pushing config changes to the repository.
+type gitConfigSync struct {
+	repo     string
+	branch   string
+	file     string
+	dir      string
+	revFile  string
+	interval time.Duration
+	git      func(ctx context.Context, dir string, args ...string) ([]byte, error)
+}
+
+// newGitConfigSync returns new gitConfigSync using the given service config, or nil if
+// there's no "config_git_repo" configured. The repository is checked out in baseDir.
+func newGitConfigSync(cfg *ctrld.ServiceConfig, baseDir string) *gitConfigSync {
+	if cfg.ConfigGitRepo == "" {
+		return nil
+	}
+	gs := &gitConfigSync{
+		repo:     cfg.ConfigGitRepo,
+		branch:   cfg.ConfigGitBranch,
+		file:     gitConfigDefaultFile,
+		dir:      filepath.Join(baseDir, gitConfigDirName),
+		revFile:  filepath.Join(baseDir, gitConfigRevFileName),
+		interval: gitConfigDefaultInterval,
+		git:      runGitCmd,
+	}
+	if cfg.ConfigGitFile != "" {
+		gs.file = cfg.ConfigGitFile
+	}
+	if cfg.ConfigGitInterval != nil && *cfg.ConfigGitInterval > 0 {
+		gs.interval = *cfg.ConfigGitInterval
+	}
+	return gs
+}
+
+// pull updates the local checkout of the repository, returning the current revision.
+func (gs *gitConfigSync) pull(ctx context.Context) (string, error) {
+	if _, err := os.Stat(filepath.Join(gs.dir, ".git")); err != nil {
+		_ = os.RemoveAll(gs.dir)
+		args := []string{"clone", "--depth", "1"}
+		if gs.branch != "" {
+			args = append(args, "--branch", gs.branch)
+		}
+		if _, err := gs.git(ctx, "", append(args, "--", gs.repo, gs.dir)...); err != nil {
+			return "", fmt.Errorf("git clone: %w", err)
+		}
+	} else {
+		ref := "HEAD"
+		if gs.branch != "" {
+			ref = gs.branch
+		}
+		if _, err := gs.git(ctx, gs.dir, "fetch", "--depth", "1", "origin", "--", ref); err != nil {
+			return "", fmt.Errorf("git fetch: %w", err)
+		}
+		if _, err := gs.git(ctx, gs.dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+			return "", fmt.Errorf("git reset: %w", err)
+		}
+	}
+	out, err := gs.git(ctx, gs.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sync pulls the repository, returning the new config if the revision changed since the last
+// applied one, or nil if there's no change. If force is true, the config is returned even if the
+// revision was applied already. The returned config is already validated.
+func (gs *gitConfigSync) sync(ctx context.Context, force bool) (*ctrld.Config, string, error) {
+	rev, err := gs.pull(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if lastRev, _ := os.ReadFile(gs.revFile); !force && strings.TrimSpace(string(lastRev)) == rev {
+		return nil, rev, nil
+	}
+	content, err := os.ReadFile(filepath.Join(gs.dir, filepath.FromSlash(gs.file)))
+	if err != nil {
+		return nil, rev, err
+	}
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	ctrld.InitConfig(v, "ctrld")
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, rev, fmt.Errorf("could not read config: %w", err)
+	}
+	cfg := &ctrld.Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, rev, fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, rev, err
+	}
+	return cfg, rev, nil
+}
+
+// saveRev records rev as the last applied revision, so unchanged config is not reloaded again after restarting.
+func (gs *gitConfigSync) saveRev(rev string) error {
+	return writePrivateFile(gs.revFile, []byte(rev+"\n"))
+}
+
+// gitConfigLoop pulls config from the Git repository periodically, reloading ctrld on changes.
+func (p *prog) gitConfigLoop(gs *gitConfigSync) {
+	logger := mainLog.Load().With().Str("mode", "git-config").Logger()
+	logger.Debug().Msgf("syncing config from %s every %s", gs.repo, gs.interval)
+	ticker := time.NewTicker(gs.interval)
+	defer ticker.Stop()
+
+	// The checked-out config is always applied after starting, since the running config
+	// may not be the one of the last applied revision, e.g: the config file was changed.
+	force := true
+	invalidRev := ""
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), gitConfigCmdTimeout)
+		cfg, rev, err := gs.sync(ctx, force)
+		cancel()
+		switch {
+		case err != nil && rev != "":
+			// Only log once per revision, until a new commit fixes the config.
+			if rev != invalidRev {
+				logger.Warn().Err(err).Msgf("skipping invalid config at revision %s", rev)
+				invalidRev = rev
+			}
+		case err != nil:
+			logger.Warn().Err(err).Msg("could not pull config repository")
+		case cfg != nil && rev == invalidRev:
+			// The config could not be applied, do not reload again until a new commit.
+		case cfg != nil:
+			// Keep the git sync settings of the running config, so the sync continues
+			// even if the pulled config does not contain them. Other than the interval,
+			// they are local only settings.
+			stripLocalOnlySettings(&cfg.Service)
+			p.mu.Lock()
+			cfg.Service.ConfigGitInterval = p.cfg.Service.ConfigGitInterval
+			keepLocalOnlySettings(&cfg.Service, &p.cfg.Service)
+			p.mu.Unlock()
+			setListenerDefaultValue(cfg)
+			logger.Notice().Msgf("config changes detected at revision %s, reloading...", rev)
+			select {
+			case p.apiReloadCh <- cfg:
+			case <-p.stopCh:
+				return
+			}
+			// The revision is only recorded once the config was applied, and written to the config
+			// file, so it is pulled again if the reload failed.
+			select {
+			case <-p.reloadDoneCh:
+				force = false
+				if err := gs.saveRev(rev); err != nil {
+					logger.Warn().Err(err).Msg("could not save config revision")
+				}
+			case <-time.After(gitConfigReloadTimeout):
+				logger.Warn().Msgf("could not reload config at revision %s", rev)
+				invalidRev = rev
+			case <-p.stopCh:
+				return
+			}
+		default:
+			force = false
+		}
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// runGitCmd runs git command with given args in dir, returning its stdout.
+func runGitCmd(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Never prompt for credentials, ctrld runs unattended.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && stderr.Len() > 0 {
+			return out, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return out, err
+	}
+	return out, nil
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const gitTestConfig = `
+[listener.0]
+ip = "127.0.0.1"
+port = 53
+
+[network.0]
+cidrs = ["0.0.0.0/0"]
+
+[upstream.0]
+type = "doh"
+endpoint = "https://freedns.controld.com/p1"
+`
+
+func Test_gitConfigSync_sync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)
+		_, err := runGitCmd(context.Background(), repo, args...)
+		require.NoError(t, err)
+	}
+	commit := func(content string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Join(repo, "routers"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, "routers", "ctrld.toml"), []byte(content), 0644))
+		git("add", "-A")
+		git("commit", "-q", "-m", "update config")
+	}
+	git("init", "-q")
+	commit(gitTestConfig)
+
+	assert.Nil(t, newGitConfigSync(&ctrld.ServiceConfig{}, t.TempDir()))
+	gs := newGitConfigSync(&ctrld.ServiceConfig{ConfigGitRepo: repo, ConfigGitFile: "routers/ctrld.toml"}, t.TempDir())
+	ctx := context.Background()
+
+	cfg, rev, err := gs.sync(ctx, false)
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	assert.Equal(t, "https://freedns.controld.com/p1", cfg.Upstream["0"].Endpoint)
+	require.NoError(t, gs.saveRev(rev))
+
+	// Unchanged revision.
+	cfg, _, err = gs.sync(ctx, false)
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+	// Unchanged revision is still returned if forced.
+	cfg, _, err = gs.sync(ctx, true)
+	require.NoError(t, err)
+	assert.NotNil(t, cfg)
+
+	// Invalid config is not applied.
+	commit(gitTestConfig + "\n[upstream.1]\ntype = \"doh\"\n")
+	cfg, rev2, err := gs.sync(ctx, false)
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.NotEqual(t, rev, rev2)
+
+	commit(gitTestConfig + "\n[upstream.1]\ntype = \"doh\"\nendpoint = \"https://freedns.controld.com/p2\"\n")
+	cfg, _, err = gs.sync(ctx, false)
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	assert.Equal(t, "https://freedns.controld.com/p2", cfg.Upstream["1"].Endpoint)
+}
+
+func Test_prog_gitConfigLoop(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)
+		out, err := runGitCmd(context.Background(), repo, args...)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(content string) string {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(repo, gitConfigDefaultFile), []byte(content), 0644))
+		git("add", "-A")
+		git("commit", "-q", "-m", "update config")
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-q")
+	rev := commit(gitTestConfig)
+
+	gs := newGitConfigSync(&ctrld.ServiceConfig{ConfigGitRepo: repo}, t.TempDir())
+	gs.interval = 50 * time.Millisecond
+	savedRev := func() string {
+		content, _ := os.ReadFile(gs.revFile)
+		return strings.TrimSpace(string(content))
+	}
+	p := &prog{
+		cfg:          &ctrld.Config{},
+		stopCh:       make(chan struct{}),
+		apiReloadCh:  make(chan *ctrld.Config),
+		reloadDoneCh: make(chan struct{}),
+	}
+	defer close(p.stopCh)
+	reloaded := func(endpoint string) {
+		t.Helper()
+		select {
+		case cfg := <-p.apiReloadCh:
+			assert.Equal(t, endpoint, cfg.Upstream["0"].Endpoint)
+		case <-time.After(time.Minute):
+			t.Fatal("config was not applied")
+		}
+	}
+
+	// The revision was applied before restarting, the config is still applied after starting.
+	require.NoError(t, gs.saveRev(rev))
+	go p.gitConfigLoop(gs)
+	reloaded("https://freedns.controld.com/p1")
+	p.reloadDoneCh <- struct{}{}
+
+	rev2 := commit(strings.ReplaceAll(gitTestConfig, "/p1", "/p2"))
+	reloaded("https://freedns.controld.com/p2")
+	// The revision is not recorded until the reload is done.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, rev, savedRev())
+	p.reloadDoneCh <- struct{}{}
+	assert.Eventually(t, func() bool { return savedRev() == rev2 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,370 @@
+package cli
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os/exec"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	haDefaultPriority        = 100
+	haDefaultFailoverTimeout = 3 * time.Second
+	haHeartbeatInterval      = time.Second
+	// haClientsSyncInterval is the interval which the active instance sends its clients to the standby one.
+	haClientsSyncInterval = 30 * time.Second
+	// haClientsPerMessage is the maximum number of clients sent in one message, keeping it below common MTU.
+	haClientsPerMessage = 10
+	haMaxClockSkew      = 30 * time.Second
+	haMaxMessageSize    = 64 << 10
+	haNotifyTimeout     = 30 * time.Second
+)
+
+// Type of messages sent between high-availability peers.
+const (
+	haMessageHeartbeat = "heartbeat"
+	haMessageClients   = "clients"
+	// haMessageResign is sent by the active instance when stopping, so the standby one takes over immediately.
+	haMessageResign = "resign"
+)
+
+// haMessage is the message sent between high-availability peers.
+type haMessage struct {
+	Type     string `json:"type"`
+	Priority int    `json:"priority"`
+	Active   bool   `json:"active"`
+	// NodeID is the random id of the sender, breaking the tie between peers of the same priority.
+	NodeID string `json:"node_id,omitempty"`
+	// Seq is incremented for every message sent by the node, so replayed messages are rejected.
+	Seq     uint64     `json:"seq"`
+	Time    int64      `json:"time"`
+	Clients []haClient `json:"clients,omitempty"`
+}
+
+// haClient is the client info synced from the active instance.
+type haClient struct {
+	IP       string `json:"ip"`
+	Mac      string `json:"mac,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// haPair implements active/standby mode of two ctrld instances. Each instance sends heartbeats to its peer,
+// the instance with higher priority is active, the other one takes over if the active one stops sending
+// heartbeats for the failover timeout. The active instance holds the virtual IP which clients use as DNS server.
+type haPair struct {
+	peer            string
+	listen          string
+	secret          []byte
+	priority        int
+	nodeID          string
+	vip             string
+	iface           string
+	notifyCommand   string
+	failoverTimeout time.Duration
+	now             func() time.Time
+	seq             atomic.Uint64
+	// setState applies the new state, e.g: adding/removing virtual IP, stubbed in tests.
+	setState func(active bool)
+
+	mu           sync.Mutex
+	active       bool
+	peerSeen     bool
+	peerLastSeen time.Time
+	peerPriority int
+	peerActive   bool
+	peerNodeID   string
+	// peerSeqs is the last sequence number received from each node id, with the time it was received.
+	peerSeqs map[string]haSeq
+}
+
+// haSeq is the last sequence number received from a node.
+type haSeq struct {
+	seq  uint64
+	seen time.Time
+}
+
+// newHAPair returns new haPair using the given service config, or nil if there's no "ha_peer" configured.
+func newHAPair(cfg *ctrld.ServiceConfig) *haPair {
+	if cfg.HAPeer == "" {
+		return nil
+	}
+	h := &haPair{
+		peer:            cfg.HAPeer,
+		listen:          cfg.HAListen,
+		secret:          []byte(cfg.HASecret),
+		priority:        haDefaultPriority,
+		nodeID:          newHANodeID(),
+		vip:             cfg.HAVirtualIP,
+		iface:           cfg.HAInterface,
+		notifyCommand:   cfg.HANotifyCommand,
+		failoverTimeout: haDefaultFailoverTimeout,
+		now:             time.Now,
+	}
+	if cfg.HAPriority > 0 {
+		h.priority = cfg.HAPriority
+	}
+	if cfg.HAFailoverTimeout != nil && *cfg.HAFailoverTimeout > 0 {
+		h.failoverTimeout = *cfg.HAFailoverTimeout
+	}
+	h.setState = h.applyState
+	return h
+}
+
+// isActive reports whether this instance is the active one.
+func (h *haPair) isActive() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.active
+}
+
+// shouldBeActive reports whether this instance should be the active one, given the peer state.
+// The caller must hold h.mu.
+func (h *haPair) shouldBeActive(started time.Time) bool {
+	now := h.now()
+	if !h.peerSeen || now.Sub(h.peerLastSeen) > h.failoverTimeout {
+		// Wait for peer heartbeats after starting, so a restarted instance does not take over the active one.
+		return now.Sub(started) > h.failoverTimeout
+	}
+	if h.priority != h.peerPriority {
+		return h.priority > h.peerPriority
+	}
+	// Same priority, keep current active instance, or break the tie using node ids. Addresses could not be used,
+	// since both instances may listen on the same wildcard address. Peers running older versions do not send
+	// their node ids, addresses are compared then.
+	if h.active != h.peerActive {
+		return h.active
+	}
+	if h.peerNodeID != "" {
+		return h.nodeID > h.peerNodeID
+	}
+	return h.listen < h.peer
+}
+
+// newHANodeID returns a random node id.
+func newHANodeID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleMessage processes message received from peer.
+func (h *haPair) handleMessage(m *haMessage, storeClient func(ip, mac, hostname string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch m.Type {
+	case haMessageResign:
+		h.peerSeen = false
+		return
+	case haMessageClients:
+		if !h.active {
+			for _, c := range m.Clients {
+				storeClient(c.IP, c.Mac, c.Hostname)
+			}
+		}
+	}
+	h.peerSeen = true
+	h.peerLastSeen = h.now()
+	h.peerPriority = m.Priority
+	h.peerActive = m.Active
+	h.peerNodeID = m.NodeID
+}
+
+// encode returns message m signed using the shared secret.
+func (h *haPair) encode(m *haMessage) ([]byte, error) {
+	m.NodeID = h.nodeID
+	m.Seq = h.seq.Add(1)
+	m.Time = h.now().UnixMilli()
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	return append(mac.Sum(nil), body...), nil
+}
+
+// decode returns the message of packet b, verifying its signature, its time and its sequence number.
+func (h *haPair) decode(b []byte) (*haMessage, error) {
+	if len(b) <= sha256.Size {
+		return nil, errors.New("message too short")
+	}
+	sig, body := b[:sha256.Size], b[sha256.Size:]
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid signature")
+	}
+	m := &haMessage{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	if skew := h.now().Sub(time.UnixMilli(m.Time)); skew > haMaxClockSkew || skew < -haMaxClockSkew {
+		return nil, errors.New("message expired")
+	}
+	if m.NodeID == "" || m.Seq == 0 {
+		return nil, errors.New("message without sequence number")
+	}
+	if !h.acceptSeq(m.NodeID, m.Seq) {
+		return nil, errors.New("message replayed")
+	}
+	return m, nil
+}
+
+// acceptSeq reports whether seq is greater than the last sequence number received from nodeID, recording it if so.
+func (h *haPair) acceptSeq(nodeID string, seq uint64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	if h.peerSeqs == nil {
+		h.peerSeqs = make(map[string]haSeq)
+	}
+	// Messages of nodes not seen for a while are rejected as expired anyway, their sequence numbers are not needed.
+	for id, s := range h.peerSeqs {
+		if now.Sub(s.seen) > 2*haMaxClockSkew {
+			delete(h.peerSeqs, id)
+		}
+	}
+	if last, ok := h.peerSeqs[nodeID]; ok && seq <= last.seq {
+		return false
+	}
+	h.peerSeqs[nodeID] = haSeq{seq: seq, seen: now}
+	return true
+}
+
+// applyState adds or removes the virtual IP, and runs the notify command.
+func (h *haPair) applyState(active bool) {
+	state := "standby"
+	if active {
+		state = "active"
+	}
+	if h.vip != "" {
+		fn := removeVirtualIP
+		if active {
+			fn = addVirtualIP
+		}
+		if err := fn(h.iface, h.vip); err != nil {
+			mainLog.Load().Error().Err(err).Msgf("could not update virtual IP %s on %s", h.vip, h.iface)
+		}
+	}
+	if h.notifyCommand != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), haNotifyTimeout)
+		defer cancel()
+		if out, err := haNotifyCmd(ctx, h.notifyCommand, state).CombinedOutput(); err != nil {
+			mainLog.Load().Error().Err(err).Msgf("ha notify command failed: %s", string(out))
+		}
+	}
+}
+
+// haNotifyCmd returns the command running notifyCommand through the shell, so quoted arguments work,
+// with state appended as the last argument.
+func haNotifyCmd(ctx context.Context, notifyCommand, state string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", notifyCommand+" "+state)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", notifyCommand+` "$@"`, "sh", state)
+}
+
+// haLoop runs the high-availability state machine until p.stopCh is closed.
+func (p *prog) haLoop(h *haPair) {
+	logger := mainLog.Load().With().Str("mode", "ha").Logger()
+	pc, err := net.ListenPacket("udp", h.listen)
+	if err != nil {
+		logger.Error().Err(err).Msgf("could not listen for ha peer on: %s", h.listen)
+		return
+	}
+	defer pc.Close()
+	peerAddr, err := net.ResolveUDPAddr("udp", h.peer)
+	if err != nil {
+		logger.Error().Err(err).Msgf("invalid ha peer: %s", h.peer)
+		return
+	}
+	logger.Notice().Msgf("starting ha mode, peer: %s, priority: %d", h.peer, h.priority)
+
+	storeClient := func(ip, mac, hostname string) {
+		if p.ciTable != nil {
+			p.ciTable.StorePeerClient(ip, mac, hostname)
+		}
+	}
+	go func() {
+		buf := make([]byte, haMaxMessageSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m, err := h.decode(buf[:n])
+			if err != nil {
+				logger.Debug().Err(err).Msgf("dropped ha message from: %s", addr)
+				continue
+			}
+			h.handleMessage(m, storeClient)
+		}
+	}()
+
+	send := func(m *haMessage) {
+		m.Priority = h.priority
+		m.Active = h.isActive()
+		b, err := h.encode(m)
+		if err != nil {
+			return
+		}
+		_, _ = pc.WriteTo(b, peerAddr)
+	}
+
+	// Start as standby, removing virtual IP which may be left if ctrld was not stopped cleanly.
+	h.setState(false)
+	started := h.now()
+	ticker := time.NewTicker(haHeartbeatInterval)
+	defer ticker.Stop()
+	var lastClientsSync time.Time
+	for {
+		h.mu.Lock()
+		active := h.shouldBeActive(started)
+		changed := active != h.active
+		h.active = active
+		h.mu.Unlock()
+		if changed {
+			if active {
+				logger.Notice().Msg("becoming active")
+			} else {
+				logger.Notice().Msg("becoming standby")
+			}
+			h.setState(active)
+		}
+		send(&haMessage{Type: haMessageHeartbeat})
+		if active && p.ciTable != nil && time.Since(lastClientsSync) >= haClientsSyncInterval {
+			lastClientsSync = time.Now()
+			var clients []haClient
+			for _, c := range p.ciTable.ListClients() {
+				clients = append(clients, haClient{IP: c.IP.String(), Mac: c.Mac, Hostname: c.Hostname})
+				if len(clients) == haClientsPerMessage {
+					send(&haMessage{Type: haMessageClients, Clients: clients})
+					clients = nil
+				}
+			}
+			if len(clients) > 0 {
+				send(&haMessage{Type: haMessageClients, Clients: clients})
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			if h.isActive() {
+				send(&haMessage{Type: haMessageResign})
+				h.setState(false)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// addVirtualIP adds the virtual IP to iface, then announces it using gratuitous ARP,
+// so clients and switches learn the new location of the virtual IP without delay.
+func addVirtualIP(iface, vip string) error {
+	out, err := exec.Command("ip", "addr", "add", vip, "dev", iface).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "File exists") {
+		return fmt.Errorf("ip addr add: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if prefix, err := netip.ParsePrefix(vip); err == nil && prefix.Addr().Is4() {
+		// arping may not be installed, clients still learn the virtual IP after their ARP cache expired.
+		if arping, err := exec.LookPath("arping"); err == nil {
+			_ = exec.Command(arping, "-U", "-c", "3", "-I", iface, prefix.Addr().String()).Run()
+		}
+	}
+	return nil
+}
+
+// removeVirtualIP removes the virtual IP from iface.
+func removeVirtualIP(iface, vip string) error {
+	out, err := exec.Command("ip", "addr", "del", vip, "dev", iface).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "Cannot assign requested address") {
+		return fmt.Errorf("ip addr del: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
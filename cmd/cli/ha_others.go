@@ -0,0 +1,17 @@
+//go:build !linux
+
+package cli
+
+import "errors"
+
+var errVirtualIPUnsupported = errors.New("virtual IP is not supported on this platform, use ha_notify_command instead")
+
+// addVirtualIP is not supported on non-linux platforms.
+func addVirtualIP(iface, vip string) error {
+	return errVirtualIPUnsupported
+}
+
+// removeVirtualIP is not supported on non-linux platforms.
+func removeVirtualIP(iface, vip string) error {
+	return errVirtualIPUnsupported
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func newTestHAPair(t *testing.T, priority int, now *time.Time) *haPair {
+	t.Helper()
+	h := newHAPair(&ctrld.ServiceConfig{HAPeer: "192.168.1.2:5381", HAListen: "192.168.1.1:5381", HASecret: "secret", HAPriority: priority})
+	require.NotNil(t, h)
+	h.now = func() time.Time { return *now }
+	return h
+}
+
+func Test_haPair_shouldBeActive(t *testing.T) {
+	assert.Nil(t, newHAPair(&ctrld.ServiceConfig{}))
+
+	now := time.Now()
+	started := now
+	noop := func(ip, mac, hostname string) {}
+	h := newTestHAPair(t, 0, &now)
+	assert.Equal(t, haDefaultPriority, h.priority)
+
+	// Waiting for peer after starting.
+	assert.False(t, h.shouldBeActive(started))
+	now = now.Add(h.failoverTimeout + time.Second)
+	assert.True(t, h.shouldBeActive(started))
+
+	// Peer with higher priority is active.
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: 200, Active: true}, noop)
+	assert.False(t, h.shouldBeActive(started))
+
+	// Peer stopped sending heartbeats.
+	now = now.Add(h.failoverTimeout + time.Second)
+	assert.True(t, h.shouldBeActive(started))
+
+	// Peer resigned.
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: 200, Active: true}, noop)
+	assert.False(t, h.shouldBeActive(started))
+	h.handleMessage(&haMessage{Type: haMessageResign, Priority: 200, Active: true}, noop)
+	assert.True(t, h.shouldBeActive(started))
+
+	// Peer with lower priority.
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: 50, Active: true}, noop)
+	assert.True(t, h.shouldBeActive(started))
+
+	// Same priority, current active instance is kept.
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: haDefaultPriority, Active: true}, noop)
+	assert.False(t, h.shouldBeActive(started))
+	h.active = true
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: haDefaultPriority, Active: false}, noop)
+	assert.True(t, h.shouldBeActive(started))
+	// Both active, tie broken by address.
+	h.handleMessage(&haMessage{Type: haMessageHeartbeat, Priority: haDefaultPriority, Active: true}, noop)
+	assert.True(t, h.shouldBeActive(started))
+}
+
+func Test_haPair_shouldBeActive_samePriority(t *testing.T) {
+	now := time.Now()
+	started := now
+	noop := func(ip, mac, hostname string) {}
+	// Both instances listen on the same wildcard address, the tie must still be broken.
+	newPair := func() *haPair {
+		h := newHAPair(&ctrld.ServiceConfig{HAPeer: "192.168.1.2:5381", HAListen: ":5381", HASecret: "secret"})
+		h.now = func() time.Time { return now }
+		return h
+	}
+	h1, h2 := newPair(), newPair()
+	require.NotEqual(t, h1.nodeID, h2.nodeID)
+	heartbeat := func(h *haPair) *haMessage {
+		return &haMessage{Type: haMessageHeartbeat, Priority: h.priority, Active: h.active, NodeID: h.nodeID}
+	}
+	for range 3 {
+		h1.handleMessage(heartbeat(h2), noop)
+		h2.handleMessage(heartbeat(h1), noop)
+		h1.active, h2.active = h1.shouldBeActive(started), h2.shouldBeActive(started)
+	}
+	assert.NotEqual(t, h1.active, h2.active, "exactly one instance must be active")
+
+	// Both became active, e.g: after a network partition, only one stays active.
+	h1.active, h2.active = true, true
+	h1.handleMessage(heartbeat(h2), noop)
+	h2.handleMessage(heartbeat(h1), noop)
+	assert.NotEqual(t, h1.shouldBeActive(started), h2.shouldBeActive(started))
+}
+
+func Test_haPair_handleMessage_clients(t *testing.T) {
+	now := time.Now()
+	h := newTestHAPair(t, 0, &now)
+	stored := map[string]string{}
+	store := func(ip, mac, hostname string) { stored[ip] = hostname }
+	m := &haMessage{Type: haMessageClients, Clients: []haClient{{IP: "192.168.1.10", Mac: "aa:bb:cc:dd:ee:ff", Hostname: "laptop"}}}
+
+	h.handleMessage(m, store)
+	assert.Equal(t, map[string]string{"192.168.1.10": "laptop"}, stored)
+
+	// Active instance does not take clients from peer.
+	stored = map[string]string{}
+	h.active = true
+	h.handleMessage(m, store)
+	assert.Empty(t, stored)
+}
+
+func Test_haPair_encodeDecode(t *testing.T) {
+	now := time.Now()
+	h := newTestHAPair(t, 150, &now)
+	b, err := h.encode(&haMessage{Type: haMessageHeartbeat, Priority: 150, Active: true})
+	require.NoError(t, err)
+
+	m, err := h.decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, haMessageHeartbeat, m.Type)
+	assert.Equal(t, 150, m.Priority)
+	assert.True(t, m.Active)
+
+	other := newTestHAPair(t, 150, &now)
+	other.secret = []byte("other secret")
+	_, err = other.decode(b)
+	assert.Error(t, err)
+
+	_, err = h.decode(b[:10])
+	assert.Error(t, err)
+
+	// Replayed message.
+	_, err = h.decode(b)
+	assert.Error(t, err)
+
+	// Expired message.
+	b, err = h.encode(&haMessage{Type: haMessageHeartbeat})
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = h.decode(b)
+	assert.Error(t, err)
+}
+
+func Test_haPair_decode_seq(t *testing.T) {
+	now := time.Now()
+	sender := newTestHAPair(t, 150, &now)
+	receiver := newTestHAPair(t, 100, &now)
+
+	first, err := sender.encode(&haMessage{Type: haMessageHeartbeat})
+	require.NoError(t, err)
+	second, err := sender.encode(&haMessage{Type: haMessageResign})
+	require.NoError(t, err)
+
+	m, err := receiver.decode(second)
+	require.NoError(t, err)
+	assert.Equal(t, sender.nodeID, m.NodeID)
+	// Older messages are rejected, even if they were not received before.
+	_, err = receiver.decode(first)
+	assert.Error(t, err)
+	_, err = receiver.decode(second)
+	assert.Error(t, err)
+
+	// Sequence numbers are tracked per node.
+	other := newTestHAPair(t, 150, &now)
+	b, err := other.encode(&haMessage{Type: haMessageHeartbeat})
+	require.NoError(t, err)
+	_, err = receiver.decode(b)
+	assert.NoError(t, err)
+}
+
+func Test_haNotifyCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is required")
+	}
+	out, err := haNotifyCmd(context.Background(), `printf '%s|' "quoted arg"`, "active").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "quoted arg|active|", string(out))
+}
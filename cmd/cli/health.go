@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// healthStatus is the response of health and readiness endpoints.
+type healthStatus struct {
+	Status           string   `json:"status"`
+	ListenersBound   bool     `json:"listeners_bound"`
+	HealthyUpstreams []string `json:"healthy_upstreams,omitempty"`
+}
+
+// listenersBound reports whether all listeners were bound.
+func (p *prog) listenersBound() bool {
+	if p.onStartedDone == nil {
+		return false
+	}
+	select {
+	case <-p.onStartedDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthyUpstreams returns the sorted list of upstreams which are not marked as down.
+func (p *prog) healthyUpstreams() []string {
+	if p.um == nil {
+		return nil
+	}
+	var upstreams []string
+	for n := range p.cfg.Upstream {
+		upstream := upstreamPrefix + n
+		if !p.um.isDown(upstream) {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	sort.Strings(upstreams)
+	return upstreams
+}
+
+// registerHealthHandlers adds health and readiness handlers using register.
+func (p *prog) registerHealthHandlers(register func(pattern string, handler http.Handler)) {
+	register(healthzPath, p.healthzHandler())
+	register(readyzPath, p.readyzHandler())
+}
+
+// healthzHandler reports whether ctrld is alive, and all of its listeners were bound.
+func (p *prog) healthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		hs := &healthStatus{ListenersBound: p.listenersBound()}
+		writeHealthStatus(w, hs, hs.ListenersBound)
+	})
+}
+
+// readyzHandler reports whether ctrld is able to resolve queries, that's its listeners were bound,
+// and at least one upstream is healthy.
+func (p *prog) readyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		hs := &healthStatus{ListenersBound: p.listenersBound(), HealthyUpstreams: p.healthyUpstreams()}
+		writeHealthStatus(w, hs, hs.ListenersBound && len(hs.HealthyUpstreams) > 0)
+	})
+}
+
+// writeHealthStatus writes hs to w, with status code 200 if ok, or 503 otherwise.
+func writeHealthStatus(w http.ResponseWriter, hs *healthStatus, ok bool) {
+	hs.Status = "ok"
+	code := http.StatusOK
+	if !ok {
+		hs.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(hs)
+}
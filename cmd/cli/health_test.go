@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_prog_healthHandlers(t *testing.T) {
+	cfg := &ctrld.Config{Upstream: map[string]*ctrld.UpstreamConfig{"0": {}, "1": {}}}
+	p := &prog{cfg: cfg, um: newUpstreamMonitor(cfg)}
+
+	check := func(handler http.Handler, wantCode int) *healthStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, wantCode, rec.Code)
+		hs := &healthStatus{}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(hs))
+		return hs
+	}
+
+	// Listeners are not bound yet.
+	hs := check(p.healthzHandler(), http.StatusServiceUnavailable)
+	assert.False(t, hs.ListenersBound)
+	check(p.readyzHandler(), http.StatusServiceUnavailable)
+
+	p.onStartedDone = make(chan struct{})
+	close(p.onStartedDone)
+	hs = check(p.healthzHandler(), http.StatusOK)
+	assert.Equal(t, "ok", hs.Status)
+	hs = check(p.readyzHandler(), http.StatusOK)
+	assert.Equal(t, []string{"upstream.0", "upstream.1"}, hs.HealthyUpstreams)
+
+	for i := 0; i < maxFailureRequest; i++ {
+		p.um.increaseFailureCount("upstream.0")
+	}
+	hs = check(p.readyzHandler(), http.StatusOK)
+	assert.Equal(t, []string{"upstream.1"}, hs.HealthyUpstreams)
+
+	// All upstreams are down, ctrld is still alive, but not ready.
+	for i := 0; i < maxFailureRequest; i++ {
+		p.um.increaseFailureCount("upstream.1")
+	}
+	check(p.healthzHandler(), http.StatusOK)
+	hs = check(p.readyzHandler(), http.StatusServiceUnavailable)
+	assert.Equal(t, "unavailable", hs.Status)
+	assert.Empty(t, hs.HealthyUpstreams)
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/router"
+)
+
+// Lifecycle events which hook scripts are run on.
+const (
+	hookEventStart       = "start"
+	hookEventStop        = "stop"
+	hookEventDNSApplied  = "dns_applied"
+	hookEventDNSRestored = "dns_restored"
+)
+
+const hookDefaultTimeout = 30 * time.Second
+
+// hookCommand returns the hook command of event in cfg.
+func hookCommand(cfg *ctrld.ServiceConfig, event string) string {
+	switch event {
+	case hookEventStart:
+		return cfg.HookStart
+	case hookEventStop:
+		return cfg.HookStop
+	case hookEventDNSApplied:
+		return cfg.HookDNSApplied
+	case hookEventDNSRestored:
+		return cfg.HookDNSRestored
+	}
+	return ""
+}
+
+// hookEnv returns the environment variables describing ctrld state, passed to hook scripts of event.
+func hookEnv(cfg *ctrld.Config, event string) []string {
+	env := []string{
+		"CTRLD_EVENT=" + event,
+		"CTRLD_VERSION=" + curVersion(),
+		"CTRLD_PID=" + strconv.Itoa(os.Getpid()),
+	}
+	listeners := make([]string, 0, len(cfg.Listener))
+	for _, lc := range cfg.Listener {
+		listeners = append(listeners, net.JoinHostPort(lc.IP, strconv.Itoa(lc.Port)))
+	}
+	sort.Strings(listeners)
+	env = append(env, "CTRLD_LISTENERS="+strings.Join(listeners, ","))
+	if platform := router.Name(); platform != "" {
+		env = append(env, "CTRLD_ROUTER="+platform)
+	}
+	return env
+}
+
+// runHook runs the hook script of event if configured, waiting for it to be finished.
+// The extra environment variables are passed to the script, in addition to hookEnv ones.
+func (p *prog) runHook(event string, extraEnv ...string) {
+	// DNS may be restored by ctrld commands, which do not have the running config.
+	c := p.cfg
+	if c == nil {
+		c = &cfg
+	}
+	command := strings.Fields(hookCommand(&c.Service, event))
+	if len(command) == 0 {
+		return
+	}
+	timeout := hookDefaultTimeout
+	if c.Service.HookTimeout != nil && *c.Service.HookTimeout > 0 {
+		timeout = *c.Service.HookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(append(os.Environ(), hookEnv(c, event)...), extraEnv...)
+	logger := mainLog.Load().With().Str("event", event).Str("hook", command[0]).Logger()
+	logger.Debug().Msg("running hook script")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Warn().Err(err).Msgf("hook script failed: %s", strings.TrimSpace(string(out)))
+		return
+	}
+	logger.Debug().Msg("hook script finished")
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_hookEnv(t *testing.T) {
+	cfg := &ctrld.Config{Listener: map[string]*ctrld.ListenerConfig{
+		"0": {IP: "127.0.0.1", Port: 53},
+		"1": {IP: "::1", Port: 5354},
+	}}
+	env := hookEnv(cfg, hookEventStart)
+	assert.Contains(t, env, "CTRLD_EVENT=start")
+	assert.Contains(t, env, "CTRLD_LISTENERS=127.0.0.1:53,[::1]:5354")
+}
+
+func Test_prog_runHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script test requires sh")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$CTRLD_EVENT $CTRLD_IFACE $1\" >> " + out + "\n"
+	require.NoError(t, os.WriteFile(script, []byte(content), 0755))
+
+	timeout := time.Second
+	p := &prog{cfg: &ctrld.Config{Service: ctrld.ServiceConfig{
+		HookDNSApplied: script + " applied",
+		HookTimeout:    &timeout,
+	}}}
+	p.runHook(hookEventDNSApplied, "CTRLD_IFACE=eth0")
+	// No hook configured for this event.
+	p.runHook(hookEventDNSRestored, "CTRLD_IFACE=eth0")
+
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "dns_applied eth0 applied", strings.TrimSpace(string(b)))
+}
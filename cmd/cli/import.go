@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/miekg/dns"
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// configImporter builds a ctrld config from the config of another DNS server. Settings which
+// could not be mapped to ctrld config are reported as warnings.
+type configImporter struct {
+	cfg *ctrld.Config
+	// upstreams maps upstream types, endpoints and bootstrap IPs to upstream names, so they are defined once.
+	upstreams map[[3]string]string
+	// defaultUpstreams are the upstreams of queries which do not match any rules.
+	defaultUpstreams []string
+	warnings         []string
+}
+
+// newConfigImporter returns a configImporter, with an empty listener policy, and a network matching all clients.
+func newConfigImporter(policyName string) *configImporter {
+	return &configImporter{
+		cfg: &ctrld.Config{
+			Listener: map[string]*ctrld.ListenerConfig{
+				"0": {Policy: &ctrld.ListenerPolicyConfig{Name: policyName}},
+			},
+			Network: map[string]*ctrld.NetworkConfig{
+				"0": {Name: "Network 0", Cidrs: []string{"0.0.0.0/0", "::/0"}},
+			},
+			Upstream: make(map[string]*ctrld.UpstreamConfig),
+		},
+		upstreams: make(map[[3]string]string),
+	}
+}
+
+// warnf records a warning about a setting which could not be imported.
+func (ci *configImporter) warnf(format string, args ...any) {
+	ci.warnings = append(ci.warnings, fmt.Sprintf(format, args...))
+}
+
+// policy returns the policy of the imported listener.
+func (ci *configImporter) policy() *ctrld.ListenerPolicyConfig {
+	return ci.cfg.Listener["0"].Policy
+}
+
+// addUpstream defines an upstream of given type and endpoint, if not defined yet, and returns its name.
+func (ci *configImporter) addUpstream(name, typ, endpoint string) string {
+	return ci.addUpstreamConfig(&ctrld.UpstreamConfig{Name: name, Type: typ, Endpoint: endpoint})
+}
+
+// addUpstreamConfig is like addUpstream, but defines the given upstream config.
+func (ci *configImporter) addUpstreamConfig(uc *ctrld.UpstreamConfig) string {
+	key := [3]string{uc.Type, uc.Endpoint, uc.BootstrapIP}
+	if upstream, ok := ci.upstreams[key]; ok {
+		return upstream
+	}
+	n := strconv.Itoa(len(ci.cfg.Upstream))
+	ci.cfg.Upstream[n] = uc
+	ci.upstreams[key] = upstreamPrefix + n
+	return ci.upstreams[key]
+}
+
+// addLegacyUpstream defines a plain DNS upstream using nameserver address, which may not have a port.
+func (ci *configImporter) addLegacyUpstream(name, addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+	}
+	return ci.addUpstream(name, ctrld.ResolverTypeLegacy, addr)
+}
+
+// addDomainRule adds a policy rule forwarding queries for domain, and its subdomains, to upstreams.
+func (ci *configImporter) addDomainRule(domain string, upstreams []string) {
+	domain = canonicalName(domain)
+	policy := ci.policy()
+	policy.Rules = append(policy.Rules,
+		ctrld.Rule{domain: upstreams},
+		ctrld.Rule{"*." + domain: upstreams},
+	)
+}
+
+// addConditionalForwarding forwards queries for domain, if set, and reverse lookups of cidr, if set, to the
+// nameserver at addr, usually the router. Only one nameserver is supported for reverse lookups.
+func (ci *configImporter) addConditionalForwarding(cidr, domain, name, addr string) {
+	upstream := ci.addLegacyUpstream(name, addr)
+	if domain != "" {
+		ci.addDomainRule(domain, []string{upstream})
+	}
+	if cidr == "" {
+		return
+	}
+	svc := &ci.cfg.Service
+	if svc.ReverseZoneNameserver != "" && svc.ReverseZoneNameserver != addr {
+		ci.warnf("conditional forwarding: reverse zones of %s could not be forwarded to another nameserver %s", cidr, name)
+		return
+	}
+	if ip, err := netip.ParseAddr(cidr); err == nil {
+		cidr = netip.PrefixFrom(ip, ip.BitLen()).String()
+	}
+	svc.ReverseZoneCidrs = append(svc.ReverseZoneCidrs, cidr)
+	svc.ReverseZoneNameserver = addr
+}
+
+// addNetwork defines a network of clients in cidrs, which queries are forwarded to upstreams if not empty,
+// and returns the network config.
+func (ci *configImporter) addNetwork(name string, cidrs, upstreams []string) *ctrld.NetworkConfig {
+	n := strconv.Itoa(len(ci.cfg.Network))
+	nc := &ctrld.NetworkConfig{Name: name, Cidrs: cidrs}
+	ci.cfg.Network[n] = nc
+	if len(upstreams) > 0 {
+		policy := ci.policy()
+		policy.Networks = append(policy.Networks, ctrld.Rule{"network." + n: upstreams})
+	}
+	return nc
+}
+
+// addListEntry adds entry to domain list name.
+func (ci *configImporter) addListEntry(name, entry string) {
+	if ci.cfg.Lists == nil {
+		ci.cfg.Lists = make(map[string][]string)
+	}
+	ci.cfg.Lists[name] = append(ci.cfg.Lists[name], entry)
+}
+
+// addRecord adds value to local record name. Values of the same name are kept in a single record.
+func (ci *configImporter) addRecord(name, value string) {
+	name = canonicalName(name)
+	for _, record := range ci.cfg.Records {
+		if values, ok := record[name]; ok {
+			record[name] = append(values, value)
+			return
+		}
+	}
+	ci.cfg.Records = append(ci.cfg.Records, ctrld.Rule{name: {value}})
+}
+
+// blockList adds a policy rule blocking queries for domains in list name, the rule is added once.
+// It must be called after blocklists are imported as threat feeds, so allowlist entries of the
+// list which do not apply to them are reported.
+func (ci *configImporter) blockList(name string) {
+	source := ctrld.DomainListPrefix + name
+	policy := ci.policy()
+	for _, rule := range policy.Rules {
+		if _, ok := rule[source]; ok {
+			return
+		}
+	}
+	policy.Rules = append(policy.Rules, ctrld.Rule{source: {upstreamBlock}})
+	excluded := slices.ContainsFunc(ci.cfg.Lists[name], func(entry string) bool {
+		return strings.HasPrefix(entry, ctrld.DomainListExclude)
+	})
+	if excluded && len(ci.cfg.Service.ThreatFeeds) > 0 {
+		ci.warnf("allowlist entries do not unblock domains of blocklists, which are imported as threat feeds")
+	}
+}
+
+// config returns the imported config. It's an error if no default upstreams were imported.
+func (ci *configImporter) config() (*ctrld.Config, error) {
+	if len(ci.defaultUpstreams) == 0 {
+		return nil, errors.New("no upstreams found")
+	}
+	policy := ci.policy()
+	policy.Networks = append(policy.Networks, ctrld.Rule{"network.0": ci.defaultUpstreams})
+	// The first matched policy rule wins, while other DNS servers use the most specific domain, so rules
+	// of more specific domains go first. Domain list rules are kept first, since blocking takes precedence.
+	slices.SortStableFunc(policy.Rules, func(a, b ctrld.Rule) int {
+		return ruleSpecificity(b) - ruleSpecificity(a)
+	})
+	if err := ctrld.ValidateConfig(validator.New(), ci.cfg); err != nil {
+		return nil, fmt.Errorf("invalid imported config: %w", err)
+	}
+	return ci.cfg, nil
+}
+
+// ruleSpecificity returns the number of labels of the domain rule source, ignoring the wildcard label,
+// so rules of a domain and its subdomains are kept together. Domain list rules come first.
+func ruleSpecificity(rule ctrld.Rule) int {
+	for source := range rule {
+		if strings.HasPrefix(source, ctrld.DomainListPrefix) {
+			return math.MaxInt32
+		}
+		return dns.CountLabel(strings.TrimPrefix(source, "*."))
+	}
+	return 0
+}
+
+// writeImportedConfig writes the imported config cfg as TOML to output file, or to stdout if output is empty.
+// Warnings of settings which could not be imported are written as comments at the beginning.
+func writeImportedConfig(cfg *ctrld.Config, warnings []string, source, output string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Imported from %s\n", source)
+	if len(warnings) > 0 {
+		buf.WriteString("#\n# These settings could not be imported:\n")
+		for _, w := range warnings {
+			fmt.Fprintf(&buf, "#  - %s\n", w)
+		}
+	}
+	buf.WriteString("\n")
+	if err := toml.NewEncoder(&buf).SetIndentTables(true).Encode(cfg); err != nil {
+		return err
+	}
+	if output == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(output, buf.Bytes(), 0644)
+}
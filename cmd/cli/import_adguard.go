@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// adguardBlockedList is the domain list of domains blocked by AdGuard Home custom rules.
+	adguardBlockedList = "adguard_blocked"
+	// adguardDefaultUpstreams is the AdGuard Home domain specific upstream, which means
+	// queries for the domain are resolved using the default upstreams.
+	adguardDefaultUpstreams = "#"
+)
+
+// adguardConfig is the part of AdGuard Home config, AdGuardHome.yaml, which could be imported.
+type adguardConfig struct {
+	DNS struct {
+		BindHosts      []string         `yaml:"bind_hosts"`
+		Port           int              `yaml:"port"`
+		UpstreamDNS    []string         `yaml:"upstream_dns"`
+		FallbackDNS    []string         `yaml:"fallback_dns"`
+		UpstreamMode   string           `yaml:"upstream_mode"`
+		BlockedHosts   []string         `yaml:"blocked_hosts"`
+		CacheSize      int              `yaml:"cache_size"`
+		CacheEnabled   *bool            `yaml:"cache_enabled"`
+		Ratelimit      int              `yaml:"ratelimit"`
+		EnableDNSSEC   bool             `yaml:"enable_dnssec"`
+		AllowedClients []string         `yaml:"allowed_clients"`
+		Rewrites       []adguardRewrite `yaml:"rewrites"`
+		// Before config schema version 20, filtering settings were in "dns" section.
+		SafeSearchEnabled bool `yaml:"safesearch_enabled"`
+	} `yaml:"dns"`
+	Filtering struct {
+		Rewrites            []adguardRewrite `yaml:"rewrites"`
+		SafeSearch          adguardToggle    `yaml:"safe_search"`
+		ParentalEnabled     bool             `yaml:"parental_enabled"`
+		SafeBrowsingEnabled bool             `yaml:"safebrowsing_enabled"`
+		BlockedServices     struct {
+			IDs []string `yaml:"ids"`
+		} `yaml:"blocked_services"`
+	} `yaml:"filtering"`
+	Filters          []adguardFilter `yaml:"filters"`
+	WhitelistFilters []adguardFilter `yaml:"whitelist_filters"`
+	UserRules        []string        `yaml:"user_rules"`
+	Clients          struct {
+		Persistent []adguardClient `yaml:"persistent"`
+	} `yaml:"clients"`
+}
+
+// adguardRewrite is an AdGuard Home DNS rewrite, the answer is an IP address, or a domain.
+type adguardRewrite struct {
+	Domain string `yaml:"domain"`
+	Answer string `yaml:"answer"`
+}
+
+// adguardFilter is an AdGuard Home filter list.
+type adguardFilter struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`
+	Name    string `yaml:"name"`
+}
+
+// adguardToggle is an AdGuard Home setting which could be enabled or disabled.
+type adguardToggle struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// adguardClient is an AdGuard Home persistent client.
+type adguardClient struct {
+	Name             string        `yaml:"name"`
+	IDs              []string      `yaml:"ids"`
+	Upstreams        []string      `yaml:"upstreams"`
+	SafeSearch       adguardToggle `yaml:"safe_search"`
+	FilteringEnabled *bool         `yaml:"filtering_enabled"`
+	BlockedServices  struct {
+		IDs []string `yaml:"ids"`
+	} `yaml:"blocked_services"`
+}
+
+// importAdGuard converts AdGuard Home config content to ctrld config. Settings which
+// could not be imported are returned as warnings.
+func importAdGuard(content []byte) (*ctrld.Config, []string, error) {
+	var ag adguardConfig
+	if err := yaml.Unmarshal(content, &ag); err != nil {
+		return nil, nil, fmt.Errorf("invalid AdGuard Home config: %w", err)
+	}
+	ci := newConfigImporter("AdGuard Home")
+	ci.importAdGuardListener(&ag)
+	ci.importAdGuardUpstreams(&ag)
+	ci.importAdGuardFiltering(&ag)
+	ci.importAdGuardClients(&ag)
+	cfg, err := ci.config()
+	return cfg, ci.warnings, err
+}
+
+func (ci *configImporter) importAdGuardListener(ag *adguardConfig) {
+	lc := ci.cfg.Listener["0"]
+	lc.Port = ag.DNS.Port
+	if lc.Port == 0 {
+		lc.Port = 53
+	}
+	for i, host := range ag.DNS.BindHosts {
+		if i == 0 {
+			lc.IP = host
+		} else {
+			lc.Addresses = append(lc.Addresses, host)
+		}
+	}
+	if (ag.DNS.CacheEnabled == nil && ag.DNS.CacheSize > 0) || (ag.DNS.CacheEnabled != nil && *ag.DNS.CacheEnabled) {
+		ci.cfg.Service.CacheEnable = true
+	}
+	if ag.DNS.Ratelimit > 0 {
+		ci.warnf("dns.ratelimit: per client rate limit is not imported, see anomaly detection in ctrld docs")
+	}
+	if ag.DNS.EnableDNSSEC {
+		ci.warnf("dns.enable_dnssec: DNSSEC is validated by upstreams")
+	}
+	if len(ag.DNS.AllowedClients) > 0 {
+		ci.warnf("dns.allowed_clients: use listener restricted mode with network rules instead")
+	}
+}
+
+func (ci *configImporter) importAdGuardUpstreams(ag *adguardConfig) {
+	var domainUpstreams []string
+	for _, line := range ag.DNS.UpstreamDNS {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[/") {
+			domainUpstreams = append(domainUpstreams, line)
+			continue
+		}
+		for _, addr := range strings.Fields(line) {
+			if upstream := ci.adguardUpstream(addr); upstream != "" {
+				ci.defaultUpstreams = append(ci.defaultUpstreams, upstream)
+			}
+		}
+	}
+	// Upstreams in ctrld policy rules are tried in order, so fallback upstreams are used after the main ones.
+	for _, addr := range ag.DNS.FallbackDNS {
+		if upstream := ci.adguardUpstream(addr); upstream != "" {
+			ci.defaultUpstreams = append(ci.defaultUpstreams, upstream)
+		}
+	}
+	// Domain specific upstreams are imported after the default ones, which "#" upstream refers to.
+	for _, line := range domainUpstreams {
+		domains, addrs, found := strings.Cut(strings.TrimPrefix(line, "[/"), "/]")
+		if !found {
+			ci.warnf("dns.upstream_dns: invalid domain specific upstream: %s", line)
+			continue
+		}
+		ci.importAdGuardDomainUpstreams(strings.Split(domains, "/"), strings.Fields(addrs))
+	}
+	switch ag.DNS.UpstreamMode {
+	case "", "load_balance":
+	default:
+		ci.warnf("dns.upstream_mode: %s mode is not supported, upstreams are tried in order", ag.DNS.UpstreamMode)
+	}
+}
+
+// importAdGuardDomainUpstreams imports AdGuard Home domain specific upstreams, e.g: "[/lan/]192.168.1.1".
+func (ci *configImporter) importAdGuardDomainUpstreams(domains, addrs []string) {
+	var upstreams []string
+	for _, addr := range addrs {
+		if addr == adguardDefaultUpstreams {
+			upstreams = append(upstreams, ci.defaultUpstreams...)
+		} else if upstream := ci.adguardUpstream(addr); upstream != "" {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	if len(upstreams) == 0 {
+		return
+	}
+	for _, domain := range domains {
+		if domain == "" {
+			ci.warnf("dns.upstream_dns: upstreams of unqualified names are not supported: %s", strings.Join(addrs, " "))
+			continue
+		}
+		ci.addDomainRule(domain, upstreams)
+	}
+}
+
+// adguardUpstream defines ctrld upstream of AdGuard Home upstream address, and returns its name.
+// The name is empty if the address could not be imported.
+func (ci *configImporter) adguardUpstream(addr string) string {
+	scheme, rest, found := strings.Cut(addr, "://")
+	if !found {
+		scheme, rest = "udp", addr
+	}
+	switch scheme {
+	case "https":
+		return ci.addUpstream(addr, ctrld.ResolverTypeDOH, addr)
+	case "h3":
+		return ci.addUpstream(addr, ctrld.ResolverTypeDOH3, "https://"+rest)
+	case "tls":
+		return ci.addUpstream(addr, ctrld.ResolverTypeDOT, strings.TrimSuffix(rest, "/"))
+	case "quic":
+		return ci.addUpstream(addr, ctrld.ResolverTypeDOQ, strings.TrimSuffix(rest, "/"))
+	case "sdns":
+		return ci.addUpstream(addr, ctrld.ResolverTypeSDNS, addr)
+	case "tcp":
+		ci.warnf("%s: TCP only upstreams are not supported, UDP is used", addr)
+		fallthrough
+	case "udp":
+		return ci.addLegacyUpstream(addr, rest)
+	}
+	ci.warnf("%s: unsupported upstream", addr)
+	return ""
+}
+
+func (ci *configImporter) importAdGuardFiltering(ag *adguardConfig) {
+	for _, f := range ag.Filters {
+		if f.Enabled {
+			ci.cfg.Service.ThreatFeeds = append(ci.cfg.Service.ThreatFeeds, f.URL)
+		}
+	}
+	for _, f := range ag.WhitelistFilters {
+		if f.Enabled {
+			ci.warnf("whitelist_filters: allowlist %s is not supported, add its domains to custom rules instead", f.URL)
+		}
+	}
+	for _, host := range ag.DNS.BlockedHosts {
+		ci.addListEntry(adguardBlockedList, canonicalName(host))
+	}
+	for _, rule := range ag.UserRules {
+		ci.importAdGuardRule(strings.TrimSpace(rule))
+	}
+	if ci.cfg.Lists[adguardBlockedList] != nil {
+		ci.blockList(adguardBlockedList)
+	}
+	for _, rw := range append(ag.DNS.Rewrites, ag.Filtering.Rewrites...) {
+		switch answer := strings.TrimSpace(rw.Answer); {
+		case answer == "A", answer == "AAAA":
+			ci.warnf("rewrites: %s: keeping upstream %s records is not supported", rw.Domain, answer)
+		case answer != "":
+			ci.addRecord(rw.Domain, answer)
+		}
+	}
+	ci.cfg.Service.SafeSearch = ag.Filtering.SafeSearch.Enabled || ag.DNS.SafeSearchEnabled
+	if ag.Filtering.ParentalEnabled {
+		ci.warnf("filtering.parental_enabled: parental control is not supported, use a Control D upstream instead")
+	}
+	if ag.Filtering.SafeBrowsingEnabled {
+		ci.warnf("filtering.safebrowsing_enabled: safe browsing is not supported, use a Control D upstream instead")
+	}
+	if len(ag.Filtering.BlockedServices.IDs) > 0 {
+		ci.warnf("filtering.blocked_services: blocked services are not supported, use a Control D upstream instead")
+	}
+}
+
+// importAdGuardRule imports an AdGuard Home custom filtering rule. Only domain blocking and
+// allowlist rules, and hosts file entries are supported.
+func (ci *configImporter) importAdGuardRule(rule string) {
+	if rule == "" || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "#") {
+		return
+	}
+	if fields := strings.Fields(rule); len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+		for _, host := range fields[1:] {
+			if ip := net.ParseIP(fields[0]); ip.IsUnspecified() || ip.IsLoopback() {
+				ci.addListEntry(adguardBlockedList, canonicalName(host))
+			} else {
+				ci.addRecord(host, fields[0])
+			}
+		}
+		return
+	}
+	allow := false
+	if r, ok := strings.CutPrefix(rule, "@@"); ok {
+		rule, allow = r, true
+	}
+	domain, ok := strings.CutPrefix(rule, "||")
+	if ok {
+		domain, ok = strings.CutSuffix(strings.TrimSuffix(domain, "$important"), "^")
+	}
+	if _, isDomain := dns.IsDomainName(domain); !ok || !isDomain || strings.ContainsAny(domain, "*|^$/") {
+		ci.warnf("user_rules: unsupported rule: %s", rule)
+		return
+	}
+	domain = canonicalName(domain)
+	prefix := ""
+	if allow {
+		prefix = ctrld.DomainListExclude
+	}
+	ci.addListEntry(adguardBlockedList, prefix+domain)
+	ci.addListEntry(adguardBlockedList, prefix+"*."+domain)
+}
+
+func (ci *configImporter) importAdGuardClients(ag *adguardConfig) {
+	for _, c := range ag.Clients.Persistent {
+		var upstreams []string
+		for _, addr := range c.Upstreams {
+			if strings.HasPrefix(addr, "[/") {
+				ci.warnf("clients: %s: domain specific upstreams of clients are not supported: %s", c.Name, addr)
+				continue
+			}
+			if upstream := ci.adguardUpstream(addr); upstream != "" {
+				upstreams = append(upstreams, upstream)
+			}
+		}
+		var cidrs []string
+		for _, id := range c.IDs {
+			if ip, err := netip.ParseAddr(id); err == nil {
+				cidrs = append(cidrs, netip.PrefixFrom(ip, ip.BitLen()).String())
+			} else if prefix, err := netip.ParsePrefix(id); err == nil {
+				cidrs = append(cidrs, prefix.Masked().String())
+			} else if mac, err := net.ParseMAC(id); err == nil {
+				if len(upstreams) > 0 {
+					policy := ci.policy()
+					policy.Macs = append(policy.Macs, ctrld.Rule{mac.String(): upstreams})
+				}
+			} else {
+				ci.warnf("clients: %s: ClientID %s is not supported", c.Name, id)
+			}
+		}
+		if len(cidrs) > 0 && (len(upstreams) > 0 || c.SafeSearch.Enabled) {
+			nc := ci.addNetwork(c.Name, cidrs, upstreams)
+			if c.SafeSearch.Enabled {
+				nc.SafeSearch = &c.SafeSearch.Enabled
+			}
+		}
+		if c.FilteringEnabled != nil && !*c.FilteringEnabled {
+			ci.warnf("clients: %s: disabling filtering per client is not supported", c.Name)
+		}
+		if len(c.BlockedServices.IDs) > 0 {
+			ci.warnf("clients: %s: blocked services are not supported", c.Name)
+		}
+	}
+}
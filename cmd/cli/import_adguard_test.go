@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const adguardTestConfig = `
+dns:
+  bind_hosts:
+    - 0.0.0.0
+    - "::"
+  port: 53
+  upstream_dns:
+    - https://dns10.quad9.net/dns-query
+    - "[/lan/]192.168.1.1"
+    - "[/corp.lan/]#"
+    - tls://1.1.1.1
+  fallback_dns:
+    - 9.9.9.9
+  upstream_mode: parallel
+filtering:
+  safe_search:
+    enabled: true
+  rewrites:
+    - domain: nas.lan
+      answer: 192.168.1.10
+    - domain: nas.lan
+      answer: fd00::10
+    - domain: "*.dev.lan"
+      answer: nas.lan
+    - domain: keep.example
+      answer: A
+filters:
+  - enabled: true
+    url: https://example.com/filter.txt
+  - enabled: false
+    url: https://example.com/disabled.txt
+user_rules:
+  - "! comment"
+  - "||ads.example^"
+  - "@@||good.ads.example^"
+  - "0.0.0.0 tracker.example"
+  - "192.168.1.20 printer.lan"
+  - "/ads[0-9]+/"
+clients:
+  persistent:
+    - name: kids
+      ids: [192.168.1.50, 10.0.0.0/24, "aa:bb:cc:dd:ee:ff", kidsphone]
+      upstreams: [https://family.example/dns-query]
+      safe_search:
+        enabled: true
+`
+
+func Test_importAdGuard(t *testing.T) {
+	cfg, warnings, err := importAdGuard([]byte(adguardTestConfig))
+	require.NoError(t, err)
+
+	lc := cfg.Listener["0"]
+	assert.Equal(t, "0.0.0.0", lc.IP)
+	assert.Equal(t, 53, lc.Port)
+	assert.Equal(t, []string{"::"}, lc.Addresses)
+
+	assert.Equal(t, ctrld.ResolverTypeDOH, cfg.Upstream["0"].Type)
+	assert.Equal(t, "https://dns10.quad9.net/dns-query", cfg.Upstream["0"].Endpoint)
+	assert.Equal(t, ctrld.ResolverTypeDOT, cfg.Upstream["1"].Type)
+	assert.Equal(t, "1.1.1.1", cfg.Upstream["1"].Endpoint)
+	assert.Equal(t, "9.9.9.9:53", cfg.Upstream["2"].Endpoint)
+	assert.Equal(t, "192.168.1.1:53", cfg.Upstream["3"].Endpoint)
+	assert.Equal(t, "https://family.example/dns-query", cfg.Upstream["4"].Endpoint)
+
+	defaultUpstreams := []string{"upstream.0", "upstream.1", "upstream.2"}
+	policy := lc.Policy
+	assert.Equal(t, []ctrld.Rule{
+		{"network.1": {"upstream.4"}},
+		{"network.0": defaultUpstreams},
+	}, policy.Networks)
+	assert.Equal(t, []ctrld.Rule{{"aa:bb:cc:dd:ee:ff": {"upstream.4"}}}, policy.Macs)
+	// More specific domains go first.
+	assert.Equal(t, []ctrld.Rule{
+		{"list.adguard_blocked": {upstreamBlock}},
+		{"corp.lan": defaultUpstreams},
+		{"*.corp.lan": defaultUpstreams},
+		{"lan": {"upstream.3"}},
+		{"*.lan": {"upstream.3"}},
+	}, policy.Rules)
+	assert.Equal(t, []string{"ads.example", "*.ads.example", "!good.ads.example", "!*.good.ads.example", "tracker.example"}, cfg.Lists[adguardBlockedList])
+	assert.Equal(t, []ctrld.Rule{
+		{"printer.lan": {"192.168.1.20"}},
+		{"nas.lan": {"192.168.1.10", "fd00::10"}},
+		{"*.dev.lan": {"nas.lan"}},
+	}, cfg.Records)
+
+	nc := cfg.Network["1"]
+	assert.Equal(t, "kids", nc.Name)
+	assert.Equal(t, []string{"192.168.1.50/32", "10.0.0.0/24"}, nc.Cidrs)
+	require.NotNil(t, nc.SafeSearch)
+	assert.True(t, *nc.SafeSearch)
+	assert.True(t, cfg.Service.SafeSearch)
+	assert.Equal(t, []string{"https://example.com/filter.txt"}, cfg.Service.ThreatFeeds)
+
+	assert.Len(t, warnings, 5)
+	assert.Contains(t, warnings, "user_rules: unsupported rule: /ads[0-9]+/")
+	assert.Contains(t, warnings, "clients: kids: ClientID kidsphone is not supported")
+
+	_, _, err = importAdGuard([]byte("dns:\n  upstream_dns: []\n"))
+	assert.Error(t, err)
+	_, _, err = importAdGuard([]byte("dns: ["))
+	assert.Error(t, err)
+}
+
+func Test_writeImportedConfig(t *testing.T) {
+	cfg, warnings, err := importAdGuard([]byte(adguardTestConfig))
+	require.NoError(t, err)
+	output := filepath.Join(t.TempDir(), "ctrld.toml")
+	require.NoError(t, writeImportedConfig(cfg, warnings, "AdGuard Home config", output))
+
+	// The imported config is loaded like any other config files.
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	v.SetConfigType("toml")
+	require.NoError(t, v.ReadConfig(f))
+	var loaded ctrld.Config
+	require.NoError(t, v.Unmarshal(&loaded))
+	require.NoError(t, ctrld.ValidateConfig(validator.New(), &loaded))
+	assert.Equal(t, cfg.Records, loaded.Records)
+	assert.Equal(t, cfg.Listener["0"].Policy.Rules, loaded.Listener["0"].Policy.Rules)
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// dnsmasqBlockedList is the domain list of domains blocked by dnsmasq "address" directives.
+	dnsmasqBlockedList = "dnsmasq_blocked"
+	// dnsmasqDefaultServers is the dnsmasq domain server, which means queries for the domain are
+	// resolved using the default servers.
+	dnsmasqDefaultServers = "#"
+)
+
+// dnsmasqIgnoredOptions are dnsmasq options which do not need importing, since ctrld behaves the same,
+// or they do not change DNS answers.
+var dnsmasqIgnoredOptions = map[string]bool{
+	"no-resolv":       true,
+	"domain-needed":   true,
+	"bogus-priv":      true,
+	"strict-order":    true,
+	"all-servers":     true,
+	"no-hosts":        true,
+	"no-poll":         true,
+	"bind-interfaces": true,
+	"bind-dynamic":    true,
+	"log-queries":     true,
+	"log-facility":    true,
+	"log-async":       true,
+	"user":            true,
+	"group":           true,
+	"pid-file":        true,
+	"no-negcache":     true,
+}
+
+// importDnsmasq converts dnsmasq config content to ctrld config. Settings which
+// could not be imported are returned as warnings.
+func importDnsmasq(content []byte) (*ctrld.Config, []string, error) {
+	ci := newConfigImporter("dnsmasq")
+	lc := ci.cfg.Listener["0"]
+	lc.Port = 53
+	var domainServers []string
+	dhcp := false
+	for _, line := range configLines(content) {
+		option, value, _ := strings.Cut(line, "=")
+		option, value = strings.TrimSpace(option), strings.TrimSpace(value)
+		switch {
+		case option == "server" && strings.HasPrefix(value, "/"):
+			// Domain servers are imported after the default ones, which "#" server refers to.
+			domainServers = append(domainServers, value)
+		case option == "server":
+			ci.defaultUpstreams = append(ci.defaultUpstreams, ci.addLegacyUpstream(value, dnsmasqAddr(value)))
+		case option == "address":
+			ci.importDnsmasqAddress(value)
+		case option == "host-record":
+			ci.importDnsmasqHostRecord(value)
+		case option == "cname":
+			ci.importDnsmasqCNAME(value)
+		case option == "rev-server":
+			if cidr, server, ok := strings.Cut(value, ","); ok {
+				ci.addConditionalForwarding(cidr, "", server, dnsmasqAddr(server))
+			}
+		case option == "listen-address":
+			for _, ip := range strings.Split(value, ",") {
+				if lc.IP == "" {
+					lc.IP = ip
+				} else {
+					lc.Addresses = append(lc.Addresses, ip)
+				}
+			}
+		case option == "port":
+			if port, err := strconv.Atoi(value); err == nil && port > 0 {
+				lc.Port = port
+			} else {
+				ci.warnf("port=%s: invalid port", value)
+			}
+		case option == "cache-size":
+			if size, err := strconv.Atoi(value); err == nil && size > 0 {
+				ci.cfg.Service.CacheEnable = true
+				ci.cfg.Service.CacheSize = size
+			}
+		case strings.HasPrefix(option, "dhcp"):
+			dhcp = true
+		case dnsmasqIgnoredOptions[option]:
+		default:
+			ci.warnf("%s: unsupported option", line)
+		}
+	}
+	for _, value := range domainServers {
+		ci.importDnsmasqDomainServer(value)
+	}
+	if dhcp {
+		ci.warnf("dhcp: DHCP server is not supported, ctrld could read DHCP leases of other servers instead")
+	}
+	if ci.cfg.Lists[dnsmasqBlockedList] != nil {
+		ci.blockList(dnsmasqBlockedList)
+	}
+	cfg, err := ci.config()
+	return cfg, ci.warnings, err
+}
+
+// dnsmasqAddr returns the address of dnsmasq server, which uses "#" as port separator, e.g: "127.0.0.1#5335".
+// The source address or interface of the server, e.g: "1.1.1.1@eth0", is ignored.
+func dnsmasqAddr(server string) string {
+	server, _, _ = strings.Cut(server, "@")
+	if host, port, ok := strings.Cut(server, "#"); ok {
+		return net.JoinHostPort(host, port)
+	}
+	return server
+}
+
+// dnsmasqDomains splits dnsmasq domain option value, "/<domain>[/<domain>...]/<value>", into domains and value.
+func dnsmasqDomains(value string) ([]string, string) {
+	parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+	return parts[:len(parts)-1], parts[len(parts)-1]
+}
+
+// importDnsmasqDomainServer imports dnsmasq domain server, e.g: "/lan/192.168.1.1".
+func (ci *configImporter) importDnsmasqDomainServer(value string) {
+	domains, server := dnsmasqDomains(value)
+	var upstreams []string
+	switch server {
+	case "":
+		ci.warnf("server=%s: local only domains are not supported", value)
+		return
+	case dnsmasqDefaultServers:
+		upstreams = ci.defaultUpstreams
+	default:
+		upstreams = []string{ci.addLegacyUpstream(server, dnsmasqAddr(server))}
+	}
+	for _, domain := range domains {
+		if domain == "" {
+			ci.warnf("server=%s: servers of unqualified names are not supported", value)
+			continue
+		}
+		ci.addDomainRule(domain, upstreams)
+	}
+}
+
+// importDnsmasqAddress imports dnsmasq address option, e.g: "/nas.lan/192.168.1.10". Domains which
+// are answered with NXDOMAIN, or with unspecified addresses, are blocked.
+func (ci *configImporter) importDnsmasqAddress(value string) {
+	domains, addr := dnsmasqDomains(value)
+	ip := net.ParseIP(addr)
+	blocked := addr == "" || addr == "#" || (ip != nil && ip.IsUnspecified())
+	if !blocked && ip == nil {
+		ci.warnf("address=%s: invalid address", value)
+		return
+	}
+	for _, domain := range domains {
+		domain = canonicalName(domain)
+		if domain == "" || domain == "#" {
+			ci.warnf("address=%s: addresses of all domains are not supported", value)
+			continue
+		}
+		// Like policy rules, address options apply to the domain and its subdomains.
+		for _, name := range []string{domain, "*." + domain} {
+			if blocked {
+				ci.addListEntry(dnsmasqBlockedList, name)
+			} else {
+				ci.addRecord(name, addr)
+			}
+		}
+	}
+}
+
+// importDnsmasqHostRecord imports dnsmasq host record, "<name>[,<name>...],[IPv4-address],[IPv6-address][,<TTL>]".
+func (ci *configImporter) importDnsmasqHostRecord(value string) {
+	var names, ips []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if net.ParseIP(field) != nil {
+			ips = append(ips, field)
+		} else if _, err := strconv.Atoi(field); err != nil && field != "" {
+			names = append(names, field)
+		}
+	}
+	if len(names) == 0 || len(ips) == 0 {
+		ci.warnf("host-record=%s: invalid record", value)
+		return
+	}
+	for _, name := range names {
+		for _, ip := range ips {
+			ci.addRecord(name, ip)
+		}
+	}
+}
+
+// importDnsmasqCNAME imports dnsmasq CNAME record, "<cname>,[<cname>,]<target>[,<TTL>]".
+func (ci *configImporter) importDnsmasqCNAME(value string) {
+	fields := strings.Split(value, ",")
+	if _, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 2 {
+		ci.warnf("cname=%s: invalid record", value)
+		return
+	}
+	target := strings.TrimSpace(fields[len(fields)-1])
+	for _, name := range fields[:len(fields)-1] {
+		ci.addRecord(strings.TrimSpace(name), target)
+	}
+}
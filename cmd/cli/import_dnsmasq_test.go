@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_importDnsmasq(t *testing.T) {
+	cfg, warnings, err := importDnsmasq([]byte(`
+# dnsmasq config
+no-resolv
+domain-needed
+listen-address=127.0.0.1,192.168.1.2
+port=5353
+cache-size=1000
+server=/corp.example/10.0.0.53
+server=/lan/192.168.1.1#5353
+server=/internal.corp.example/#
+server=/local.example/
+server=1.1.1.1
+server=2606:4700:4700::1111
+address=/ads.example/tracker.example/0.0.0.0
+address=/nas.lan/192.168.1.10
+host-record=printer.lan,printer,192.168.1.20,fd00::20,300
+cname=media.lan,nas.lan
+rev-server=192.168.1.0/24,192.168.1.1
+dhcp-range=192.168.1.100,192.168.1.200,12h
+dhcp-option=3,192.168.1.1
+conf-dir=/etc/dnsmasq.d
+`))
+	require.NoError(t, err)
+
+	lc := cfg.Listener["0"]
+	assert.Equal(t, "127.0.0.1", lc.IP)
+	assert.Equal(t, 5353, lc.Port)
+	assert.Equal(t, []string{"192.168.1.2"}, lc.Addresses)
+	assert.True(t, cfg.Service.CacheEnable)
+	assert.Equal(t, 1000, cfg.Service.CacheSize)
+
+	assert.Equal(t, "1.1.1.1:53", cfg.Upstream["0"].Endpoint)
+	assert.Equal(t, "[2606:4700:4700::1111]:53", cfg.Upstream["1"].Endpoint)
+	assert.Equal(t, "192.168.1.1:53", cfg.Upstream["2"].Endpoint)
+	assert.Equal(t, "10.0.0.53:53", cfg.Upstream["3"].Endpoint)
+	assert.Equal(t, "192.168.1.1:5353", cfg.Upstream["4"].Endpoint)
+
+	policy := lc.Policy
+	assert.Equal(t, []ctrld.Rule{{"network.0": {"upstream.0", "upstream.1"}}}, policy.Networks)
+	assert.Equal(t, []ctrld.Rule{
+		{"list.dnsmasq_blocked": {upstreamBlock}},
+		{"internal.corp.example": {"upstream.0", "upstream.1"}},
+		{"*.internal.corp.example": {"upstream.0", "upstream.1"}},
+		{"corp.example": {"upstream.3"}},
+		{"*.corp.example": {"upstream.3"}},
+		{"lan": {"upstream.4"}},
+		{"*.lan": {"upstream.4"}},
+	}, policy.Rules)
+	assert.Equal(t, []string{"192.168.1.0/24"}, cfg.Service.ReverseZoneCidrs)
+	assert.Equal(t, "192.168.1.1", cfg.Service.ReverseZoneNameserver)
+	assert.Equal(t, []string{"ads.example", "*.ads.example", "tracker.example", "*.tracker.example"}, cfg.Lists[dnsmasqBlockedList])
+	assert.Equal(t, []ctrld.Rule{
+		{"nas.lan": {"192.168.1.10"}},
+		{"*.nas.lan": {"192.168.1.10"}},
+		{"printer.lan": {"192.168.1.20", "fd00::20"}},
+		{"printer": {"192.168.1.20", "fd00::20"}},
+		{"media.lan": {"nas.lan"}},
+	}, cfg.Records)
+	assert.Equal(t, []string{
+		"conf-dir=/etc/dnsmasq.d: unsupported option",
+		"server=/local.example/: local only domains are not supported",
+		"dhcp: DHCP server is not supported, ctrld could read DHCP leases of other servers instead",
+	}, warnings)
+}
+
+func Test_dnsmasqAddr(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:5335", dnsmasqAddr("127.0.0.1#5335"))
+	assert.Equal(t, "[::1]:5335", dnsmasqAddr("::1#5335"))
+	assert.Equal(t, "1.1.1.1", dnsmasqAddr("1.1.1.1@eth0"))
+	assert.Equal(t, "8.8.8.8", dnsmasqAddr("8.8.8.8"))
+}
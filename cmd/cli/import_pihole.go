@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// piholeBlockedList is the domain list of domains blocked by Pi-hole domain management.
+	piholeBlockedList = "pihole_blocked"
+	// piholeDefaultDir is the default Pi-hole config directory.
+	piholeDefaultDir = "/etc/pihole"
+	// piholeDefaultDnsmasqDir is the default directory of dnsmasq config files, which Pi-hole v5 CNAME records are in.
+	piholeDefaultDnsmasqDir = "/etc/dnsmasq.d"
+)
+
+// Pi-hole config files, in Pi-hole config directory, except piholeCNAMEConfFileName, which is in dnsmasq one.
+const (
+	piholeGravityFileName    = "gravity.db"
+	piholeTOMLFileName       = "pihole.toml"
+	piholeSetupVarsFileName  = "setupVars.conf"
+	piholeCustomListFileName = "custom.list"
+	piholeCNAMEConfFileName  = "05-pihole-custom-cname.conf"
+)
+
+// Pi-hole gravity database adlist and domainlist types.
+const (
+	piholeAdlistBlock = 0
+	piholeAdlistAllow = 1
+
+	piholeExactAllow = 0
+	piholeExactDeny  = 1
+	piholeRegexAllow = 2
+	piholeRegexDeny  = 3
+)
+
+// piholeEntry is an adlist URL, or a domain, of Pi-hole gravity database, with its type.
+type piholeEntry struct {
+	value string
+	kind  int
+}
+
+// piholeGravity is the part of Pi-hole gravity database which could be imported.
+type piholeGravity struct {
+	adlists []piholeEntry
+	domains []piholeEntry
+	clients int
+}
+
+// piholeTOML is the part of Pi-hole v6 config, pihole.toml, which could be imported.
+type piholeTOML struct {
+	DNS struct {
+		Upstreams    []string `toml:"upstreams"`
+		Hosts        []string `toml:"hosts"`
+		CNAMERecords []string `toml:"cnameRecords"`
+		RevServers   []string `toml:"revServers"`
+	} `toml:"dns"`
+}
+
+// importPihole converts Pi-hole config in dir to ctrld config. Pi-hole v5 CNAME records are read from
+// dnsmasqDir. Settings which could not be imported are returned as warnings.
+func importPihole(dir, dnsmasqDir string) (*ctrld.Config, []string, error) {
+	ci := newConfigImporter("Pi-hole")
+	lc := ci.cfg.Listener["0"]
+	lc.IP, lc.Port = "0.0.0.0", 53
+
+	// Pi-hole v6 keeps all settings in pihole.toml, while Pi-hole v5 uses setupVars.conf and dnsmasq files.
+	content, err := readOptionalFile(filepath.Join(dir, piholeTOMLFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	if content != nil {
+		err = ci.importPiholeTOML(content)
+	} else {
+		err = ci.importPiholeV5(dir, dnsmasqDir)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gravityPath := filepath.Join(dir, piholeGravityFileName)
+	if _, err := os.Stat(gravityPath); err == nil {
+		g, err := readPiholeGravity(gravityPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read Pi-hole gravity database: %w", err)
+		}
+		ci.importPiholeGravity(g)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	cfg, err := ci.config()
+	return cfg, ci.warnings, err
+}
+
+// readOptionalFile returns the content of file at path, or nil if it does not exist.
+func readOptionalFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// configLines returns the lines of config file content, without comment and blank lines.
+func configLines(content []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (ci *configImporter) importPiholeV5(dir, dnsmasqDir string) error {
+	content, err := readOptionalFile(filepath.Join(dir, piholeSetupVarsFileName))
+	if err != nil {
+		return err
+	}
+	ci.importPiholeSetupVars(content)
+	if content, err = readOptionalFile(filepath.Join(dir, piholeCustomListFileName)); err != nil {
+		return err
+	}
+	for _, line := range configLines(content) {
+		ci.importPiholeHost(line)
+	}
+	if content, err = readOptionalFile(filepath.Join(dnsmasqDir, piholeCNAMEConfFileName)); err != nil {
+		return err
+	}
+	for _, line := range configLines(content) {
+		if cname, ok := strings.CutPrefix(line, "cname="); ok {
+			ci.importDnsmasqCNAME(cname)
+		}
+	}
+	return nil
+}
+
+func (ci *configImporter) importPiholeTOML(content []byte) error {
+	var pt piholeTOML
+	if err := toml.Unmarshal(content, &pt); err != nil {
+		return fmt.Errorf("invalid Pi-hole config: %w", err)
+	}
+	for _, upstream := range pt.DNS.Upstreams {
+		ci.defaultUpstreams = append(ci.defaultUpstreams, ci.addLegacyUpstream(upstream, dnsmasqAddr(upstream)))
+	}
+	for _, host := range pt.DNS.Hosts {
+		ci.importPiholeHost(host)
+	}
+	for _, cname := range pt.DNS.CNAMERecords {
+		ci.importDnsmasqCNAME(cname)
+	}
+	for _, rs := range pt.DNS.RevServers {
+		// In form of "<enabled>,<ip-address>[/<prefix-len>],<server>[#<port>][,<domain>]".
+		fields := strings.Split(rs, ",")
+		if len(fields) < 3 {
+			ci.warnf("dns.revServers: invalid conditional forwarding: %s", rs)
+			continue
+		}
+		if fields[0] != "true" {
+			continue
+		}
+		domain := ""
+		if len(fields) > 3 {
+			domain = fields[3]
+		}
+		ci.addConditionalForwarding(fields[1], domain, fields[2], dnsmasqAddr(fields[2]))
+	}
+	return nil
+}
+
+func (ci *configImporter) importPiholeSetupVars(content []byte) {
+	vars := make(map[string]string)
+	for _, line := range configLines(content) {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			vars[k] = strings.Trim(v, `"'`)
+		}
+	}
+	for i := 1; ; i++ {
+		upstream, ok := vars["PIHOLE_DNS_"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		ci.defaultUpstreams = append(ci.defaultUpstreams, ci.addLegacyUpstream(upstream, dnsmasqAddr(upstream)))
+	}
+	if vars["REV_SERVER"] == "true" {
+		target := vars["REV_SERVER_TARGET"]
+		ci.addConditionalForwarding(vars["REV_SERVER_CIDR"], vars["REV_SERVER_DOMAIN"], target, dnsmasqAddr(target))
+	}
+}
+
+// importPiholeHost imports a Pi-hole local DNS record, which is in hosts file format.
+func (ci *configImporter) importPiholeHost(line string) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		ci.warnf("local DNS records: invalid record: %s", line)
+		return
+	}
+	for _, host := range fields[1:] {
+		ci.addRecord(host, fields[0])
+	}
+}
+
+func (ci *configImporter) importPiholeGravity(g *piholeGravity) {
+	for _, l := range g.adlists {
+		switch l.kind {
+		case piholeAdlistBlock:
+			ci.cfg.Service.ThreatFeeds = append(ci.cfg.Service.ThreatFeeds, l.value)
+		case piholeAdlistAllow:
+			ci.warnf("adlists: allowlist %s is not supported, add its domains to the allowlist instead", l.value)
+		}
+	}
+	for _, d := range g.domains {
+		switch d.kind {
+		case piholeExactDeny:
+			ci.addListEntry(piholeBlockedList, canonicalName(d.value))
+		case piholeExactAllow:
+			ci.addListEntry(piholeBlockedList, ctrld.DomainListExclude+canonicalName(d.value))
+		case piholeRegexAllow, piholeRegexDeny:
+			ci.warnf("domains: regex filter is not supported: %s", d.value)
+		}
+	}
+	if ci.cfg.Lists[piholeBlockedList] != nil {
+		ci.blockList(piholeBlockedList)
+	}
+	if g.clients > 0 {
+		ci.warnf("clients: group management is not supported, domains and adlists are applied to all clients")
+	}
+}
@@ -0,0 +1,59 @@
+//go:build sqlite && ((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// readPiholeGravity reads adlists and domains of Pi-hole gravity database file at path.
+func readPiholeGravity(path string) (*piholeGravity, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	g := &piholeGravity{}
+	// Allowlist adlists were added in Pi-hole v6, with the "type" column.
+	rows, err := db.Query("SELECT address, type FROM adlist WHERE enabled = 1 ORDER BY id")
+	if err != nil {
+		rows, err = db.Query("SELECT address, 0 FROM adlist WHERE enabled = 1 ORDER BY id")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read adlists: %w", err)
+	}
+	for rows.Next() {
+		var l piholeEntry
+		if err := rows.Scan(&l.value, &l.kind); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.adlists = append(g.adlists, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = db.Query("SELECT domain, type FROM domainlist WHERE enabled = 1 ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("could not read domains: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d piholeEntry
+		if err := rows.Scan(&d.value, &d.kind); err != nil {
+			return nil, err
+		}
+		g.domains = append(g.domains, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM client").Scan(&g.clients); err != nil {
+		return nil, fmt.Errorf("could not read clients: %w", err)
+	}
+	return g, nil
+}
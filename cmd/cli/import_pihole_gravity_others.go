@@ -0,0 +1,9 @@
+//go:build !sqlite || !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import "fmt"
+
+func readPiholeGravity(path string) (*piholeGravity, error) {
+	return nil, fmt.Errorf("reading Pi-hole gravity database: %w", errSQLiteUnsupported)
+}
@@ -0,0 +1,52 @@
+//go:build sqlite && ((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_importPihole_gravity(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		adlist string
+	}{
+		// Pi-hole v5 adlists have no type column.
+		{"v5", `CREATE TABLE adlist (id INTEGER PRIMARY KEY, address TEXT, enabled BOOLEAN)`},
+		{"v6", `CREATE TABLE adlist (id INTEGER PRIMARY KEY, address TEXT, enabled BOOLEAN, type INTEGER DEFAULT 0)`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, piholeSetupVarsFileName), "PIHOLE_DNS_1=1.1.1.1\n")
+			db, err := sql.Open("sqlite", filepath.Join(dir, piholeGravityFileName))
+			require.NoError(t, err)
+			for _, stmt := range []string{
+				tc.adlist,
+				`CREATE TABLE domainlist (id INTEGER PRIMARY KEY, type INTEGER, domain TEXT, enabled BOOLEAN)`,
+				`CREATE TABLE client (id INTEGER PRIMARY KEY, ip TEXT)`,
+				`INSERT INTO adlist (address, enabled) VALUES ('https://example.com/hosts.txt', 1), ('https://example.com/off.txt', 0)`,
+				`INSERT INTO domainlist (type, domain, enabled) VALUES (1, 'ads.example', 1), (0, 'good.example', 1),
+					(3, '(^|\.)tracker\.', 1), (1, 'disabled.example', 0)`,
+			} {
+				_, err := db.Exec(stmt)
+				require.NoError(t, err)
+			}
+			require.NoError(t, db.Close())
+
+			cfg, warnings, err := importPihole(dir, t.TempDir())
+			require.NoError(t, err)
+			assert.Equal(t, []string{"https://example.com/hosts.txt"}, cfg.Service.ThreatFeeds)
+			assert.Equal(t, []string{"ads.example", "!good.example"}, cfg.Lists[piholeBlockedList])
+			assert.Equal(t, []ctrld.Rule{{"list.pihole_blocked": {upstreamBlock}}}, cfg.Listener["0"].Policy.Rules)
+			assert.Len(t, warnings, 2)
+			assert.Contains(t, warnings, `domains: regex filter is not supported: (^|\.)tracker\.`)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func Test_importPihole_v5(t *testing.T) {
+	dir, dnsmasqDir := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(dir, piholeSetupVarsFileName), `PIHOLE_INTERFACE=eth0
+PIHOLE_DNS_1=8.8.8.8
+PIHOLE_DNS_2=127.0.0.1#5335
+REV_SERVER=true
+REV_SERVER_CIDR=192.168.1.0/24
+REV_SERVER_TARGET=192.168.1.1
+REV_SERVER_DOMAIN=lan
+`)
+	writeTestFile(t, filepath.Join(dir, piholeCustomListFileName), `# local records
+192.168.1.10 nas.lan
+fd00::10 nas.lan
+192.168.1.20 printer.lan printer # office
+`)
+	writeTestFile(t, filepath.Join(dnsmasqDir, piholeCNAMEConfFileName), `cname=media.lan,nas.lan
+cname=a.lan,b.lan,printer.lan,300
+`)
+
+	cfg, warnings, err := importPihole(dir, dnsmasqDir)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	assert.Equal(t, "8.8.8.8:53", cfg.Upstream["0"].Endpoint)
+	assert.Equal(t, ctrld.ResolverTypeLegacy, cfg.Upstream["0"].Type)
+	assert.Equal(t, "127.0.0.1:5335", cfg.Upstream["1"].Endpoint)
+	assert.Equal(t, "192.168.1.1:53", cfg.Upstream["2"].Endpoint)
+	policy := cfg.Listener["0"].Policy
+	assert.Equal(t, []ctrld.Rule{{"network.0": {"upstream.0", "upstream.1"}}}, policy.Networks)
+	assert.Equal(t, []ctrld.Rule{{"lan": {"upstream.2"}}, {"*.lan": {"upstream.2"}}}, policy.Rules)
+	assert.Equal(t, []string{"192.168.1.0/24"}, cfg.Service.ReverseZoneCidrs)
+	assert.Equal(t, "192.168.1.1", cfg.Service.ReverseZoneNameserver)
+	assert.Equal(t, []ctrld.Rule{
+		{"nas.lan": {"192.168.1.10", "fd00::10"}},
+		{"printer.lan": {"192.168.1.20"}},
+		{"printer": {"192.168.1.20"}},
+		{"media.lan": {"nas.lan"}},
+		{"a.lan": {"printer.lan"}},
+		{"b.lan": {"printer.lan"}},
+	}, cfg.Records)
+}
+
+func Test_importPihole_v6(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, piholeTOMLFileName), `[dns]
+  upstreams = ["9.9.9.9", "2620:fe::fe#53"]
+  hosts = ["192.168.1.10 nas.lan"]
+  cnameRecords = ["media.lan,nas.lan"]
+  revServers = ["true,10.0.0.0/8,10.0.0.1#53,corp", "false,172.16.0.0/12,172.16.0.1"]
+`)
+
+	cfg, warnings, err := importPihole(dir, t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, "9.9.9.9:53", cfg.Upstream["0"].Endpoint)
+	assert.Equal(t, "[2620:fe::fe]:53", cfg.Upstream["1"].Endpoint)
+	assert.Equal(t, "10.0.0.1:53", cfg.Upstream["2"].Endpoint)
+	assert.Len(t, cfg.Upstream, 3)
+	assert.Equal(t, []string{"10.0.0.0/8"}, cfg.Service.ReverseZoneCidrs)
+	assert.Equal(t, "10.0.0.1:53", cfg.Service.ReverseZoneNameserver)
+	assert.Equal(t, []ctrld.Rule{{"nas.lan": {"192.168.1.10"}}, {"media.lan": {"nas.lan"}}}, cfg.Records)
+
+	// Upstreams are required.
+	_, _, err = importPihole(t.TempDir(), t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// unboundBlockedList is the domain list of domains blocked by Unbound local zones.
+const unboundBlockedList = "unbound_blocked"
+
+// unboundBlockingZoneTypes are Unbound local zone types, which queries for the zone are not resolved.
+var unboundBlockingZoneTypes = map[string]bool{
+	"deny":            true,
+	"refuse":          true,
+	"always_refuse":   true,
+	"always_nxdomain": true,
+	"always_null":     true,
+	"always_deny":     true,
+}
+
+// unboundForwardZone is an Unbound forward or stub zone.
+type unboundForwardZone struct {
+	name  string
+	addrs []string
+	hosts []string
+	tls   bool
+}
+
+// importUnbound converts Unbound config content, unbound.conf, to ctrld config. Settings which
+// could not be imported are returned as warnings.
+func importUnbound(content []byte) (*ctrld.Config, []string, error) {
+	ci := newConfigImporter("Unbound")
+	lc := ci.cfg.Listener["0"]
+	lc.Port = 53
+	var (
+		section string
+		zone    *unboundForwardZone
+		zones   []*unboundForwardZone
+	)
+	for _, line := range configLines(content) {
+		// Comments start with "#", which is also the TLS name separator of forward addresses.
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		key, value, _ := strings.Cut(line, ":")
+		key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+		if value == "" && !strings.Contains(key, " ") {
+			section = key
+			zone = nil
+			switch section {
+			case "forward-zone", "stub-zone":
+				zone = &unboundForwardZone{}
+				zones = append(zones, zone)
+			case "server", "remote-control":
+			default:
+				ci.warnf("%s: unsupported section", section)
+			}
+			continue
+		}
+		switch {
+		case section == "server":
+			ci.importUnboundServerOption(key, value)
+		case zone != nil:
+			switch key {
+			case "name":
+				zone.name = canonicalName(value)
+			case "forward-addr", "stub-addr":
+				zone.addrs = append(zone.addrs, value)
+			case "forward-host", "stub-host":
+				zone.hosts = append(zone.hosts, value)
+			case "forward-tls-upstream", "forward-ssl-upstream", "stub-tls-upstream", "stub-ssl-upstream":
+				zone.tls = value == "yes"
+			}
+		}
+	}
+	for _, zone := range zones {
+		ci.importUnboundForwardZone(zone)
+	}
+	if ci.cfg.Lists[unboundBlockedList] != nil {
+		ci.blockList(unboundBlockedList)
+	}
+	cfg, err := ci.config()
+	return cfg, ci.warnings, err
+}
+
+func (ci *configImporter) importUnboundServerOption(key, value string) {
+	lc := ci.cfg.Listener["0"]
+	switch key {
+	case "interface":
+		// In form of "<ip-address|interface>[@<port>]".
+		ip, port, hasPort := strings.Cut(value, "@")
+		if net.ParseIP(ip) == nil {
+			ci.warnf("interface: %s: listening on interface names is not supported", value)
+			return
+		}
+		if hasPort {
+			value = net.JoinHostPort(ip, port)
+		}
+		if lc.IP == "" {
+			if host, port, err := net.SplitHostPort(value); err == nil {
+				lc.IP = host
+				lc.Port, _ = strconv.Atoi(port)
+			} else {
+				lc.IP = value
+			}
+		} else {
+			lc.Addresses = append(lc.Addresses, value)
+		}
+	case "port":
+		if port, err := strconv.Atoi(value); err == nil {
+			lc.Port = port
+		}
+	case "local-data":
+		ci.importUnboundLocalData(value)
+	case "local-zone":
+		// In form of "<zone> <type>".
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			ci.warnf("local-zone: %s: invalid zone", value)
+			return
+		}
+		zone := canonicalName(strings.Trim(fields[0], `"`))
+		if unboundBlockingZoneTypes[fields[1]] {
+			ci.addListEntry(unboundBlockedList, zone)
+			ci.addListEntry(unboundBlockedList, "*."+zone)
+		}
+	case "local-data-ptr", "include", "include-toplevel", "access-control", "private-domain", "domain-insecure":
+		ci.warnf("%s: %s: unsupported option", key, value)
+	}
+}
+
+// importUnboundLocalData imports Unbound local data, e.g: "nas.lan. IN A 192.168.1.10". Only A, AAAA and
+// CNAME records are supported.
+func (ci *configImporter) importUnboundLocalData(value string) {
+	rr, err := dns.NewRR(value)
+	if err != nil || rr == nil {
+		ci.warnf("local-data: %s: invalid record", value)
+		return
+	}
+	name := rr.Header().Name
+	switch rr := rr.(type) {
+	case *dns.A:
+		ci.addRecord(name, rr.A.String())
+	case *dns.AAAA:
+		ci.addRecord(name, rr.AAAA.String())
+	case *dns.CNAME:
+		ci.addRecord(name, canonicalName(rr.Target))
+	default:
+		ci.warnf("local-data: %s: unsupported record type", value)
+	}
+}
+
+// importUnboundForwardZone imports Unbound forward zone, the zone of root domain has the default upstreams.
+func (ci *configImporter) importUnboundForwardZone(zone *unboundForwardZone) {
+	var upstreams []string
+	for _, addr := range zone.addrs {
+		upstreams = append(upstreams, ci.unboundUpstream(addr, zone.tls))
+	}
+	for _, host := range zone.hosts {
+		if !zone.tls {
+			ci.warnf("forward-host: %s: nameserver hosts of plain DNS are not supported", host)
+			continue
+		}
+		upstreams = append(upstreams, ci.unboundUpstream(host, true))
+	}
+	if len(upstreams) == 0 {
+		return
+	}
+	if zone.name == "" {
+		ci.defaultUpstreams = append(ci.defaultUpstreams, upstreams...)
+		return
+	}
+	ci.addDomainRule(zone.name, upstreams)
+}
+
+// unboundUpstream defines ctrld upstream of Unbound forward address, "<ip-address|host>[@<port>][#<tls-name>]",
+// and returns its name.
+func (ci *configImporter) unboundUpstream(addr string, tls bool) string {
+	host, tlsName, _ := strings.Cut(addr, "#")
+	host, port, hasPort := strings.Cut(host, "@")
+	if !tls {
+		if hasPort {
+			return ci.addLegacyUpstream(addr, net.JoinHostPort(host, port))
+		}
+		return ci.addLegacyUpstream(addr, host)
+	}
+	uc := &ctrld.UpstreamConfig{Name: addr, Type: ctrld.ResolverTypeDOT}
+	if tlsName != "" {
+		// The TLS name is used to verify the server certificate, while the IP address is used to connect.
+		uc.BootstrapIP = host
+		host = tlsName
+	}
+	if !hasPort {
+		port = "853"
+	}
+	uc.Endpoint = net.JoinHostPort(host, port)
+	return ci.addUpstreamConfig(uc)
+}
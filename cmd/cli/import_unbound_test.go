@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_importUnbound(t *testing.T) {
+	cfg, warnings, err := importUnbound([]byte(`
+# Unbound config
+server:
+    verbosity: 1
+    interface: 127.0.0.1@5335
+    interface: ::1@5335
+    interface: eth0
+    local-data: "nas.lan. IN A 192.168.1.10"
+    local-data: 'nas.lan AAAA fd00::10'
+    local-data: "media.lan. 300 IN CNAME nas.lan."
+    local-data: "lan. IN MX 10 mail.lan."
+    local-zone: "ads.example." always_nxdomain
+    local-zone: "lan." static
+    include: "/etc/unbound/unbound.conf.d/*.conf"
+
+forward-zone:
+    name: "."
+    forward-tls-upstream: yes
+    forward-addr: 1.1.1.1@853#cloudflare-dns.com  # Cloudflare
+    forward-addr: 9.9.9.9
+
+stub-zone:
+    name: "corp.example"
+    stub-addr: 10.0.0.53@5353
+
+remote-control:
+    control-enable: yes
+
+auth-zone:
+    name: "example.org"
+`))
+	require.NoError(t, err)
+
+	lc := cfg.Listener["0"]
+	assert.Equal(t, "127.0.0.1", lc.IP)
+	assert.Equal(t, 5335, lc.Port)
+	assert.Equal(t, []string{"[::1]:5335"}, lc.Addresses)
+
+	uc := cfg.Upstream["0"]
+	assert.Equal(t, ctrld.ResolverTypeDOT, uc.Type)
+	assert.Equal(t, "cloudflare-dns.com:853", uc.Endpoint)
+	assert.Equal(t, "1.1.1.1", uc.BootstrapIP)
+	assert.Equal(t, "9.9.9.9:853", cfg.Upstream["1"].Endpoint)
+	assert.Equal(t, ctrld.ResolverTypeLegacy, cfg.Upstream["2"].Type)
+	assert.Equal(t, "10.0.0.53:5353", cfg.Upstream["2"].Endpoint)
+
+	policy := lc.Policy
+	assert.Equal(t, []ctrld.Rule{{"network.0": {"upstream.0", "upstream.1"}}}, policy.Networks)
+	assert.Equal(t, []ctrld.Rule{
+		{"list.unbound_blocked": {upstreamBlock}},
+		{"corp.example": {"upstream.2"}},
+		{"*.corp.example": {"upstream.2"}},
+	}, policy.Rules)
+	assert.Equal(t, []string{"ads.example", "*.ads.example"}, cfg.Lists[unboundBlockedList])
+	assert.Equal(t, []ctrld.Rule{
+		{"nas.lan": {"192.168.1.10", "fd00::10"}},
+		{"media.lan": {"nas.lan"}},
+	}, cfg.Records)
+	assert.Len(t, warnings, 4)
+	assert.Contains(t, warnings, "auth-zone: unsupported section")
+
+	_, _, err = importUnbound([]byte("server:\n    verbosity: 1\n"))
+	assert.Error(t, err)
+}
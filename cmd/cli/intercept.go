@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// origDstTTL is the time the original destination of an UDP query is kept, if it was not answered.
+	origDstTTL = 10 * time.Second
+	// origDstsPurgeSize is the number of tracked destinations above which expired ones are purged.
+	origDstsPurgeSize = 1024
+)
+
+// origDsts tracks the original destinations of DNS traffic intercepted by firewall rules,
+// that's the DNS servers which clients intended to query, keyed by the client addresses.
+type origDsts struct {
+	mu sync.Mutex
+	m  map[string]origDst
+}
+
+type origDst struct {
+	addr net.Addr
+	// expire is the time the destination is forgotten, zero means it's kept until deleted.
+	expire time.Time
+}
+
+func newOrigDsts() *origDsts {
+	return &origDsts{m: make(map[string]origDst)}
+}
+
+// set records dst as the original destination of traffic from client. If expire is true,
+// the destination is forgotten after origDstTTL, for traffic which may not be answered.
+func (o *origDsts) set(client, dst net.Addr, expire bool) {
+	od := origDst{addr: dst}
+	now := time.Now()
+	if expire {
+		od.expire = now.Add(origDstTTL)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.m) >= origDstsPurgeSize {
+		for k, v := range o.m {
+			if !v.expire.IsZero() && now.After(v.expire) {
+				delete(o.m, k)
+			}
+		}
+	}
+	o.m[client.String()] = od
+}
+
+// get returns the original destination of traffic from client, or nil if unknown.
+func (o *origDsts) get(client net.Addr) net.Addr {
+	if o == nil || client == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.m[client.String()].addr
+}
+
+// del forgets the original destination of traffic from client.
+func (o *origDsts) del(client net.Addr) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.m, client.String())
+}
+
+// interceptsDNS reports whether DNS traffic forwarded by this machine is redirected to the listener.
+func (p *prog) interceptsDNS(listenerNum string) bool {
+	return p.cfg.Service.FirewallRedirect && listenerNum == p.firewallListener()
+}
+
+// tproxyEnabled reports whether DNS traffic is diverted to ctrld using TPROXY, which is only supported on Linux.
+func (p *prog) tproxyEnabled() bool {
+	return supportsTProxy && p.cfg.Service.FirewallRedirectMode == ctrld.FirewallRedirectModeTProxy
+}
+
+// intendedServer returns the DNS server which client intended to query, if it's not the listener itself.
+func intendedServer(dsts *origDsts, client net.Addr, lc *ctrld.ListenerConfig) string {
+	dst := dsts.get(client)
+	if dst == nil {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(dst.String())
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.Equal(net.ParseIP(lc.IP)) && port == strconv.Itoa(lc.Port) {
+		return ""
+	}
+	return dst.String()
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sys/unix"
+)
+
+// supportsTProxy reports whether DNS traffic could be diverted to ctrld using TPROXY.
+const supportsTProxy = true
+
+// ip6tSOOriginalDst is IP6T_SO_ORIGINAL_DST, the SOL_IPV6 option getting the original destination of
+// IPv6 connections redirected by NAT rules.
+const ip6tSOOriginalDst = 80
+
+// transparentControl sets IP_TRANSPARENT on sockets receiving TPROXY'd traffic, so they could
+// accept traffic to non-local addresses, and send replies from them. UDP sockets also receive
+// the original destination of packets, as IP_ORIGDSTADDR control messages.
+func transparentControl(network, _ string, c syscall.RawConn) error {
+	var opErr error
+	if err := c.Control(func(fd uintptr) {
+		// Sockets listening on unspecified addresses are dual-stack, so both IPv4 and IPv6 options are set.
+		errV4 := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		errV6 := unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		if errV4 != nil && errV6 != nil {
+			opErr = errV4
+			return
+		}
+		if network == "udp" || network == "udp4" || network == "udp6" {
+			_ = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1)
+			_ = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+		}
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// runInterceptDNSServer is like runDNSServer, but for the listener which DNS traffic is redirected to
+// by firewall rules. The original destinations of the traffic are recorded in dsts.
+//
+// If tproxy is false, traffic was redirected using NAT, so only the original destinations of TCP
+// connections could be recovered, using SO_ORIGINAL_DST.
+func runInterceptDNSServer(addr, network string, handler dns.Handler, dsts *origDsts, tproxy bool) (*dns.Server, <-chan error) {
+	lc := &net.ListenConfig{}
+	if tproxy {
+		lc.Control = transparentControl
+	}
+	s := &dns.Server{Addr: addr, Net: network, Handler: handler}
+	if network == "udp" {
+		pc, err := lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			return failedDNSServer(addr, network, err)
+		}
+		if tproxy {
+			pc = &tproxyPacketConn{UDPConn: pc.(*net.UDPConn), dsts: dsts}
+		}
+		s.PacketConn = pc
+		return activateDNSServer(s)
+	}
+	l, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return failedDNSServer(addr, network, err)
+	}
+	s.Listener = &interceptListener{Listener: l, dsts: dsts, tproxy: tproxy}
+	return activateDNSServer(s)
+}
+
+// interceptListener is a net.Listener recording the original destinations of accepted connections.
+type interceptListener struct {
+	net.Listener
+	dsts   *origDsts
+	tproxy bool
+}
+
+// Accept implements net.Listener.
+func (l *interceptListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// TPROXY'd connections are not NAT-ed, their local addresses are the original destinations.
+	dst := c.LocalAddr()
+	if !l.tproxy {
+		if dst, err = originalDst(c); err != nil {
+			mainLog.Load().Debug().Err(err).Msgf("could not get original destination of connection from: %s", c.RemoteAddr())
+			return c, nil
+		}
+	}
+	l.dsts.set(c.RemoteAddr(), dst, false)
+	return &interceptConn{Conn: c, dsts: l.dsts}, nil
+}
+
+// interceptConn is a net.Conn forgetting its original destination once closed.
+type interceptConn struct {
+	net.Conn
+	dsts *origDsts
+}
+
+// Close implements net.Conn.
+func (c *interceptConn) Close() error {
+	c.dsts.del(c.RemoteAddr())
+	return c.Conn.Close()
+}
+
+// originalDst returns the destination of TCP connection c before it was redirected by NAT rules.
+func originalDst(c net.Conn) (net.Addr, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("not a tcp connection")
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var addr *net.TCPAddr
+	var opErr error
+	if err := rc.Control(func(fd uintptr) {
+		// IPv4 connections on dual-stack sockets are redirected by iptables, not ip6tables.
+		if la, ok := c.LocalAddr().(*net.TCPAddr); ok && la.IP.To4() == nil {
+			var info *unix.IPv6MTUInfo
+			info, opErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSOOriginalDst)
+			if opErr == nil {
+				addr = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(ntohs(info.Addr.Port))}
+			}
+			return
+		}
+		// The sockaddr_in is returned in the space of an ipv6_mreq.
+		var mreq *unix.IPv6Mreq
+		mreq, opErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+		if opErr == nil {
+			sa := mreq.Multiaddr
+			addr = &net.TCPAddr{IP: net.IPv4(sa[4], sa[5], sa[6], sa[7]), Port: int(binary.BigEndian.Uint16(sa[2:4]))}
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return addr, opErr
+}
+
+// tproxyPacketConn is a net.PacketConn receiving TPROXY'd DNS queries. The original destinations of
+// queries are recorded, and replies are sent from them, as clients expect.
+type tproxyPacketConn struct {
+	*net.UDPConn
+	dsts *origDsts
+}
+
+// ReadFrom implements net.PacketConn.
+func (c *tproxyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	oob := make([]byte, 128)
+	n, oobn, _, addr, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		return n, addr, err
+	}
+	if dst := parseOrigDstAddr(oob[:oobn]); dst != nil {
+		c.dsts.set(addr, dst, true)
+	}
+	return n, addr, nil
+}
+
+// WriteTo implements net.PacketConn.
+func (c *tproxyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, _ := c.dsts.get(addr).(*net.UDPAddr)
+	if dst == nil {
+		return c.UDPConn.WriteTo(b, addr)
+	}
+	c.dsts.del(addr)
+	raddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return c.UDPConn.WriteTo(b, addr)
+	}
+	network := "udp6"
+	if ip4 := dst.IP.To4(); ip4 != nil {
+		network = "udp4"
+		dst = &net.UDPAddr{IP: ip4, Port: dst.Port}
+		raddr = &net.UDPAddr{IP: raddr.IP.To4(), Port: raddr.Port}
+	}
+	// The reply is sent from a transparent socket bound to the original destination.
+	lc := &net.ListenConfig{Control: transparentControl}
+	pc, err := lc.ListenPacket(context.Background(), network, dst.String())
+	if err != nil {
+		return 0, err
+	}
+	defer pc.Close()
+	return pc.WriteTo(b, raddr)
+}
+
+// parseOrigDstAddr returns the original destination carried in IP_ORIGDSTADDR/IPV6_ORIGDSTADDR control messages.
+func parseOrigDstAddr(oob []byte) *net.UDPAddr {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_ORIGDSTADDR && len(msg.Data) >= unix.SizeofSockaddrInet4:
+			// struct sockaddr_in: family, port, address.
+			return &net.UDPAddr{
+				IP:   net.IPv4(msg.Data[4], msg.Data[5], msg.Data[6], msg.Data[7]),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_ORIGDSTADDR && len(msg.Data) >= unix.SizeofSockaddrInet6:
+			// struct sockaddr_in6: family, port, flow info, address.
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[8:24]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}
+		}
+	}
+	return nil
+}
+
+// ntohs converts the port from network byte order, as stored in raw sockaddr structs.
+func ntohs(port uint16) uint16 {
+	b := make([]byte, 2)
+	binary.NativeEndian.PutUint16(b, port)
+	return binary.BigEndian.Uint16(b)
+}
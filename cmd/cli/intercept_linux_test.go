@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func origDstControlMessage(level, typ int32, sa []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(sa)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = level, typ
+	h.SetLen(unix.CmsgLen(len(sa)))
+	copy(b[unix.CmsgLen(0):], sa)
+	return b
+}
+
+func Test_parseOrigDstAddr(t *testing.T) {
+	sa4 := make([]byte, unix.SizeofSockaddrInet4)
+	binary.BigEndian.PutUint16(sa4[2:4], 53)
+	copy(sa4[4:8], net.ParseIP("8.8.8.8").To4())
+	dst := parseOrigDstAddr(origDstControlMessage(unix.SOL_IP, unix.IP_ORIGDSTADDR, sa4))
+	if assert.NotNil(t, dst) {
+		assert.Equal(t, "8.8.8.8:53", dst.String())
+	}
+
+	sa6 := make([]byte, unix.SizeofSockaddrInet6)
+	binary.BigEndian.PutUint16(sa6[2:4], 53)
+	copy(sa6[8:24], net.ParseIP("2001:4860:4860::8888").To16())
+	dst = parseOrigDstAddr(origDstControlMessage(unix.SOL_IPV6, unix.IPV6_ORIGDSTADDR, sa6))
+	if assert.NotNil(t, dst) {
+		assert.Equal(t, "[2001:4860:4860::8888]:53", dst.String())
+	}
+
+	assert.Nil(t, parseOrigDstAddr(origDstControlMessage(unix.SOL_IP, unix.IP_PKTINFO, sa4)))
+	assert.Nil(t, parseOrigDstAddr(nil))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package cli
+
+import "github.com/miekg/dns"
+
+// supportsTProxy reports whether DNS traffic could be diverted to ctrld using TPROXY.
+const supportsTProxy = false
+
+// runInterceptDNSServer is like runDNSServer, the original destinations of redirected traffic
+// could only be recovered on Linux.
+func runInterceptDNSServer(addr, network string, handler dns.Handler, _ *origDsts, _ bool) (*dns.Server, <-chan error) {
+	return runDNSServer(addr, network, handler)
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_origDsts(t *testing.T) {
+	dsts := newOrigDsts()
+	client := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 41234}
+	dst := &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}
+	dsts.set(client, dst, true)
+	assert.Equal(t, dst, dsts.get(client))
+	assert.Nil(t, dsts.get(&net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 41235}))
+	dsts.del(client)
+	assert.Nil(t, dsts.get(client))
+
+	var nilDsts *origDsts
+	assert.Nil(t, nilDsts.get(client))
+}
+
+func Test_intendedServer(t *testing.T) {
+	lc := &ctrld.ListenerConfig{IP: "192.168.1.1", Port: 5354}
+	dsts := newOrigDsts()
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 41234}
+	assert.Empty(t, intendedServer(dsts, client, lc))
+
+	dsts.set(client, &net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}, false)
+	assert.Equal(t, "8.8.8.8:53", intendedServer(dsts, client, lc))
+
+	// Queries sent to the listener itself.
+	dsts.set(client, &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5354}, false)
+	assert.Empty(t, intendedServer(dsts, client, lc))
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// icmpv6TypeRouterAdvertisement is the ICMPv6 type of Router Advertisement message.
+	icmpv6TypeRouterAdvertisement = 134
+	// raHeaderLen is the length of Router Advertisement message before options.
+	raHeaderLen = 16
+	// raOptionRDNSS is the Recursive DNS Server option type, see RFC 8106.
+	raOptionRDNSS = 25
+)
+
+// rdnssServer is a DNS server announced in RDNSS option of Router Advertisement.
+type rdnssServer struct {
+	addr     netip.Addr
+	lifetime time.Duration
+}
+
+// parseRDNSS returns DNS servers in RDNSS options of Router Advertisement message b,
+// starting with ICMPv6 header.
+func parseRDNSS(b []byte) []rdnssServer {
+	if len(b) < raHeaderLen || b[0] != icmpv6TypeRouterAdvertisement {
+		return nil
+	}
+	var servers []rdnssServer
+	for opts := b[raHeaderLen:]; len(opts) >= 2; {
+		// Option length is in units of 8 octets, including type and length fields.
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			break
+		}
+		if opts[0] == raOptionRDNSS && l >= 24 {
+			lifetime := time.Duration(binary.BigEndian.Uint32(opts[4:8])) * time.Second
+			for addrs := opts[8:l]; len(addrs) >= 16; addrs = addrs[16:] {
+				servers = append(servers, rdnssServer{addr: netip.AddrFrom16([16]byte(addrs[:16])), lifetime: lifetime})
+			}
+		}
+		opts = opts[l:]
+	}
+	return servers
+}
+
+// handleRDNSS incorporates DNS servers announced on interface ifaceName into OS resolver nameservers,
+// then re-asserts ctrld as DNS of the interface if the announced servers are used by the system.
+func (p *prog) handleRDNSS(ifaceName string, servers []rdnssServer) {
+	changed := false
+	announced := make([]string, 0, len(servers))
+	for _, s := range servers {
+		addr := s.addr
+		if addr.IsLinkLocalUnicast() {
+			addr = addr.WithZone(ifaceName)
+		}
+		if ctrld.AddIPv6Nameservers([]string{addr.String()}, s.lifetime) {
+			changed = true
+		}
+		if s.lifetime > 0 {
+			announced = append(announced, addr.String())
+		}
+	}
+	if changed {
+		mainLog.Load().Debug().Msgf("IPv6 DNS servers announced on interface %q: %v", ifaceName, announced)
+		ns := ctrld.InitializeOsResolver()
+		mainLog.Load().Debug().Msgf("re-initialized OS resolver with nameservers: %v", ns)
+	}
+	if len(announced) > 0 {
+		p.reassertIPv6DNS(ifaceName, announced)
+	}
+}
+
+// reassertIPv6DNS sets ctrld as DNS of interface ifaceName again, if any of the announced
+// IPv6 DNS servers is currently used by the interface.
+func (p *prog) reassertIPv6DNS(ifaceName string, announced []string) {
+	if iface == "" || p.leakingQuery.Load() || !p.csSetDnsOk {
+		return
+	}
+	if iface == "auto" {
+		if !ifaceAllowed(ifaceName) || (!requiredMultiNICsConfig() && ifaceName != defaultIfaceName()) {
+			return
+		}
+	} else if iface != ifaceName {
+		return
+	}
+	lc := cfg.FirstListener()
+	if lc == nil {
+		return
+	}
+	netIface, err := netInterface(ifaceName)
+	if err != nil {
+		return
+	}
+	leaked := slices.ContainsFunc(currentDNS(netIface), func(ns string) bool {
+		addr, err := netip.ParseAddr(ns)
+		if err != nil {
+			return false
+		}
+		return slices.ContainsFunc(announced, func(s string) bool {
+			a, err := netip.ParseAddr(s)
+			return err == nil && a.WithZone("") == addr.WithZone("")
+		})
+	})
+	if !leaked {
+		return
+	}
+	p.dnsEvents.add(dnsEvent{Iface: ifaceName, Event: dnsEventChanged, Nameservers: announced})
+	p.dnsEvents.reapplied(ifaceName, setDNS(netIface, listenerNameservers(lc)))
+}
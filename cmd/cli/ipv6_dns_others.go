@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package cli
+
+import "context"
+
+func (p *prog) watchRouterAdvertisements(ctx context.Context) {}
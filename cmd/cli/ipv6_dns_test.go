@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRDNSS(t *testing.T) {
+	ra := []byte{
+		134, 0, 0, 0, 64, 0, 0x07, 0x08, 0, 0, 0, 0, 0, 0, 0, 0, // RA header.
+		1, 1, 0x02, 0x11, 0x22, 0x33, 0x44, 0x55, // Source link-layer address option.
+		25, 5, 0, 0, 0, 0, 0x0e, 0x10, // RDNSS option, lifetime 3600s, 2 addresses.
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+	}
+	want := []rdnssServer{
+		{addr: netip.MustParseAddr("2001:db8::53"), lifetime: time.Hour},
+		{addr: netip.MustParseAddr("fe80::1"), lifetime: time.Hour},
+	}
+	assert.Equal(t, want, parseRDNSS(ra))
+
+	// Not a router advertisement.
+	assert.Nil(t, parseRDNSS(append([]byte{135}, ra[1:]...)))
+	// Truncated option is ignored.
+	assert.Nil(t, parseRDNSS(ra[:len(ra)-16]))
+	// Zero length option stops parsing.
+	assert.Nil(t, parseRDNSS(append(ra[:16:16], 25, 0, 0, 0)))
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd
+
+package cli
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// watchRouterAdvertisements listens for Router Advertisement messages, handling DNS servers announced
+// in their RDNSS options, until ctx is done.
+func (p *prog) watchRouterAdvertisements(ctx context.Context) {
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not listen for router advertisements")
+		return
+	}
+	defer c.Close()
+	pc := c.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	if err := pc.SetICMPFilter(&filter); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not set ICMPv6 filter")
+	}
+	if err := pc.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not enable ICMPv6 control message")
+	}
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				mainLog.Load().Warn().Err(err).Msg("could not read router advertisement")
+			}
+			return
+		}
+		servers := parseRDNSS(buf[:n])
+		if len(servers) == 0 || cm == nil {
+			continue
+		}
+		ifi, err := net.InterfaceByIndex(cm.IfIndex)
+		if err != nil {
+			continue
+		}
+		p.handleRDNSS(ifi.Name, servers)
+	}
+}
@@ -0,0 +1,13 @@
+package cli
+
+import "golang.org/x/sys/unix"
+
+// jailStatus reports whether ctrld is running inside a FreeBSD jail,
+// and whether the jail has its own virtual network stack.
+func jailStatus() (jailed, vnet bool) {
+	if v, err := unix.SysctlUint32("security.jail.jailed"); err != nil || v != 1 {
+		return false, false
+	}
+	v, err := unix.SysctlUint32("security.jail.vnet")
+	return true, err == nil && v == 1
+}
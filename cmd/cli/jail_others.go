@@ -0,0 +1,9 @@
+//go:build !freebsd
+
+package cli
+
+// jailStatus reports whether ctrld is running inside a FreeBSD jail,
+// and whether the jail has its own virtual network stack.
+func jailStatus() (jailed, vnet bool) {
+	return false, false
+}
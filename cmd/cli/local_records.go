@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// upstreamLocalRecords is the upstream label of queries answered using local records.
+const upstreamLocalRecords = "local_records"
+
+// localRecord is the IP addresses of a local record name, or the domain which the name is an alias of.
+type localRecord struct {
+	ips    []net.IP
+	target string
+}
+
+// localRecords answers queries using "records" config, for names which are not resolvable
+// by upstreams, or must be resolved differently, like LAN hosts or DNS rewrites.
+type localRecords struct {
+	records map[string]*localRecord
+	// wildcards are records of "*.<zone>" names, keyed by zone.
+	wildcards map[string]*localRecord
+}
+
+// newLocalRecords returns new localRecords using the given records config, or nil if there's none.
+func newLocalRecords(records []ctrld.Rule) *localRecords {
+	if len(records) == 0 {
+		return nil
+	}
+	lr := &localRecords{
+		records:   make(map[string]*localRecord),
+		wildcards: make(map[string]*localRecord),
+	}
+	for _, record := range records {
+		for name, values := range record {
+			r := &localRecord{}
+			for _, value := range values {
+				if ip := net.ParseIP(value); ip != nil {
+					r.ips = append(r.ips, ip)
+				} else {
+					r.target = canonicalName(value)
+				}
+			}
+			name = canonicalName(name)
+			if zone, ok := strings.CutPrefix(name, "*."); ok {
+				lr.wildcards[zone] = r
+			} else {
+				lr.records[name] = r
+			}
+		}
+	}
+	return lr
+}
+
+// lookup returns the record of domain. Exact names take precedence over wildcards, and
+// the wildcard of the closest zone is used if there are many matches.
+func (lr *localRecords) lookup(domain string) *localRecord {
+	if lr == nil {
+		return nil
+	}
+	if r := lr.records[domain]; r != nil {
+		return r
+	}
+	zone := domain
+	for {
+		_, parent, found := strings.Cut(zone, ".")
+		if !found {
+			return nil
+		}
+		if r := lr.wildcards[parent]; r != nil {
+			return r
+		}
+		zone = parent
+	}
+}
+
+// answer returns the answer for msg using local records. If the question name is an alias, and the query
+// is not a CNAME one, the answer is nil, and the alias target is returned, so the query could be rewritten
+// to the target and resolved by upstreams. Both are empty if there's no record for the question name.
+func (lr *localRecords) answer(msg *dns.Msg) (*dns.Msg, string) {
+	q := msg.Question[0]
+	r := lr.lookup(canonicalName(q.Name))
+	if r == nil {
+		return nil, ""
+	}
+	if r.target != "" && q.Qtype != dns.TypeCNAME {
+		return nil, r.target
+	}
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	answer.Authoritative = true
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: uint32(localTTL.Seconds())}
+	if r.target != "" {
+		answer.Answer = append(answer.Answer, &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(r.target)})
+		return answer, ""
+	}
+	// Names having only IPv4 addresses have no AAAA records and vice versa, the answer is then empty.
+	for _, ip := range r.ips {
+		ip4 := ip.To4()
+		switch {
+		case q.Qtype == dns.TypeA && ip4 != nil:
+			answer.Answer = append(answer.Answer, &dns.A{Hdr: hdr, A: ip4})
+		case q.Qtype == dns.TypeAAAA && ip4 == nil:
+			answer.Answer = append(answer.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return answer, ""
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_localRecords_answer(t *testing.T) {
+	lr := newLocalRecords([]ctrld.Rule{
+		{"NAS.lan": {"192.168.1.10", "fd00::10"}},
+		{"*.lan": {"192.168.1.1"}},
+		{"*.dev.lan": {"nas.lan"}},
+		{"v6.dev.lan": {"fd00::20"}},
+	})
+	query := func(name string, qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		return m
+	}
+
+	answer, target := lr.answer(query("nas.lan.", dns.TypeA))
+	require.NotNil(t, answer)
+	assert.Empty(t, target)
+	require.Len(t, answer.Answer, 1)
+	assert.Equal(t, "192.168.1.10", answer.Answer[0].(*dns.A).A.String())
+
+	answer, _ = lr.answer(query("nas.lan.", dns.TypeAAAA))
+	require.Len(t, answer.Answer, 1)
+	assert.Equal(t, "fd00::10", answer.Answer[0].(*dns.AAAA).AAAA.String())
+
+	answer, _ = lr.answer(query("printer.lan.", dns.TypeA))
+	require.Len(t, answer.Answer, 1)
+	assert.Equal(t, "192.168.1.1", answer.Answer[0].(*dns.A).A.String())
+
+	// Names without addresses of the query type have empty answers.
+	answer, _ = lr.answer(query("v6.dev.lan.", dns.TypeA))
+	require.NotNil(t, answer)
+	assert.Equal(t, dns.RcodeSuccess, answer.Rcode)
+	assert.Empty(t, answer.Answer)
+
+	// Aliases are rewritten, unless it's a CNAME query.
+	answer, target = lr.answer(query("app.dev.lan.", dns.TypeA))
+	assert.Nil(t, answer)
+	assert.Equal(t, "nas.lan", target)
+	answer, target = lr.answer(query("app.dev.lan.", dns.TypeCNAME))
+	assert.Empty(t, target)
+	require.Len(t, answer.Answer, 1)
+	assert.Equal(t, "nas.lan.", answer.Answer[0].(*dns.CNAME).Target)
+
+	answer, target = lr.answer(query("example.com.", dns.TypeA))
+	assert.Nil(t, answer)
+	assert.Empty(t, target)
+
+	var nilRecords *localRecords
+	answer, target = nilRecords.answer(query("nas.lan.", dns.TypeA))
+	assert.Nil(t, answer)
+	assert.Empty(t, target)
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// localOnlySettings returns the service settings of sc which must only come from the local config file, keyed by their names.
+// The values are pointers to the settings, either *string or *[]string.
+//
+// Remote configs, like Control D custom config, or config pulled from Git repository, must not be able to run commands
+// on the device, write files at arbitrary paths, change network interfaces, open listeners, nor send queries, cached
+// answers, alerts or the resolver UID to other hosts, since ctrld runs with root privileges.
+func localOnlySettings(sc *ctrld.ServiceConfig) map[string]any {
+	return map[string]any{
+		// Commands.
+		"plugin_command":    &sc.PluginCommand,
+		"hook_start":        &sc.HookStart,
+		"hook_stop":         &sc.HookStop,
+		"hook_dns_applied":  &sc.HookDNSApplied,
+		"hook_dns_restored": &sc.HookDNSRestored,
+		"ha_notify_command": &sc.HANotifyCommand,
+		// File paths.
+		"query_log_path":         &sc.QueryLogPath,
+		"sinkhole_log_path":      &sc.SinkholeLogPath,
+		"tls_session_cache_file": &sc.TLSSessionCacheFile,
+		// Network interfaces.
+		"ha_virtual_ip": &sc.HAVirtualIP,
+		"ha_interface":  &sc.HAInterface,
+		// Exporters and API endpoints.
+		"query_log_export_url":  &sc.QueryLogExportURL,
+		"metrics_push_endpoint": &sc.MetricsPushEndpoint,
+		"otel_traces_endpoint":  &sc.OtelTracesEndpoint,
+		"cd_api_url":            &sc.CdAPIURL,
+		"config_git_repo":       &sc.ConfigGitRepo,
+		"config_git_branch":     &sc.ConfigGitBranch,
+		"config_git_file":       &sc.ConfigGitFile,
+		// Peers, and their listeners.
+		"cache_peers":       &sc.CachePeers,
+		"cache_peer_listen": &sc.CachePeerListen,
+		"cache_peer_secret": &sc.CachePeerSecret,
+		"cache_redis_url":   &sc.CacheRedisURL,
+		"ha_peer":           &sc.HAPeer,
+		"ha_listen":         &sc.HAListen,
+		"ha_secret":         &sc.HASecret,
+		// Alerts.
+		"webhook_urls":        &sc.WebhookURLs,
+		"anomaly_webhook_url": &sc.AnomalyWebhookURL,
+		"smtp_server":         &sc.SMTPServer,
+		"smtp_tls":            &sc.SMTPTLS,
+		"smtp_username":       &sc.SMTPUsername,
+		"smtp_password":       &sc.SMTPPassword,
+		"smtp_from":           &sc.SMTPFrom,
+		"smtp_to":             &sc.SMTPTo,
+	}
+}
+
+// stripLocalOnlySettings clears local only settings of remote service config sc.
+func stripLocalOnlySettings(sc *ctrld.ServiceConfig) {
+	settings := localOnlySettings(sc)
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		set := false
+		switch s := settings[name].(type) {
+		case *string:
+			set, *s = *s != "", ""
+		case *[]string:
+			set, *s = len(*s) > 0, nil
+		}
+		if set {
+			mainLog.Load().Warn().Msgf("ignoring %q setting of remote config, it is only accepted from local config file", name)
+		}
+	}
+}
+
+// keepLocalOnlySettings sets local only settings of remote service config to the ones of local service config.
+func keepLocalOnlySettings(remote, local *ctrld.ServiceConfig) {
+	localSettings := localOnlySettings(local)
+	for name, s := range localOnlySettings(remote) {
+		switch s := s.(type) {
+		case *string:
+			*s = *localSettings[name].(*string)
+		case *[]string:
+			*s = slices.Clone(*localSettings[name].(*[]string))
+		}
+	}
+}
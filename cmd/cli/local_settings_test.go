@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+func Test_validateCdRemoteConfig_localOnlySettings(t *testing.T) {
+	oldV := v
+	t.Cleanup(func() { v = oldV })
+
+	var sb strings.Builder
+	sb.WriteString("[service]\n  log_level = \"debug\"\n")
+	for name, s := range localOnlySettings(&ctrld.ServiceConfig{}) {
+		switch s.(type) {
+		case *string:
+			fmt.Fprintf(&sb, "  %s = \"evil.example:443\"\n", name)
+		case *[]string:
+			fmt.Fprintf(&sb, "  %s = [\"evil.example:443\"]\n", name)
+		default:
+			t.Fatalf("unexpected type of %q: %T", name, s)
+		}
+	}
+	rc := &controld.ResolverConfig{}
+	rc.Ctrld.CustomConfig = base64.StdEncoding.EncodeToString([]byte(sb.String()))
+	cfg := &ctrld.Config{}
+	require.NoError(t, validateCdRemoteConfig(rc, cfg))
+	assert.Equal(t, "debug", cfg.Service.LogLevel)
+	for name, s := range localOnlySettings(&cfg.Service) {
+		assert.Empty(t, s, name)
+	}
+}
+
+// Test_localOnlySettings_names ensures settings sending data to other hosts, or opening listeners, are local only.
+func Test_localOnlySettings_names(t *testing.T) {
+	settings := localOnlySettings(&ctrld.ServiceConfig{})
+	want := []string{
+		"plugin_command", "hook_start", "hook_stop", "hook_dns_applied", "hook_dns_restored", "ha_notify_command",
+		"query_log_path", "sinkhole_log_path", "tls_session_cache_file",
+		"ha_virtual_ip", "ha_interface",
+		"query_log_export_url", "metrics_push_endpoint", "otel_traces_endpoint", "cd_api_url",
+		"config_git_repo", "config_git_branch", "config_git_file",
+		"cache_peers", "cache_peer_listen", "cache_peer_secret", "cache_redis_url", "ha_peer", "ha_listen", "ha_secret",
+		"webhook_urls", "anomaly_webhook_url",
+		"smtp_server", "smtp_tls", "smtp_username", "smtp_password", "smtp_from", "smtp_to",
+	}
+	assert.ElementsMatch(t, want, slices.Collect(maps.Keys(settings)))
+
+	// Names must match the config keys of settings.
+	typ := reflect.TypeOf(ctrld.ServiceConfig{})
+	keys := make(map[string]bool, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		keys[typ.Field(i).Tag.Get("mapstructure")] = true
+	}
+	for name := range settings {
+		assert.True(t, keys[name], name)
+	}
+}
+
+func Test_keepLocalOnlySettings(t *testing.T) {
+	set := func(s any, value string) {
+		switch s := s.(type) {
+		case *string:
+			*s = value
+		case *[]string:
+			*s = []string{value}
+		}
+	}
+	local := &ctrld.ServiceConfig{}
+	for name, s := range localOnlySettings(local) {
+		set(s, "/usr/local/bin/"+name)
+	}
+	remote := &ctrld.ServiceConfig{LogLevel: "debug"}
+	for _, s := range localOnlySettings(remote) {
+		set(s, "/tmp/remote")
+	}
+	keepLocalOnlySettings(remote, local)
+	assert.Equal(t, "debug", remote.LogLevel)
+	for name, s := range localOnlySettings(remote) {
+		want := "/usr/local/bin/" + name
+		switch s := s.(type) {
+		case *string:
+			assert.Equal(t, want, *s)
+		case *[]string:
+			assert.Equal(t, []string{want}, *s)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// recentLogsSize is the number of recent log lines kept in memory.
+	recentLogsSize = 500
+	// recentErrorsSize is the number of recent warning and error log lines kept in memory.
+	recentErrorsSize = 100
+)
+
+// recentLogs keeps recent log lines of ctrld process, used for crash reports and diagnostics bundle.
+var recentLogs = newLogBuffer(recentLogsSize, recentErrorsSize)
+
+// recentLogsResponse is the response of recentLogsPath control server endpoint.
+type recentLogsResponse struct {
+	Logs   []string `json:"logs"`
+	Errors []string `json:"errors"`
+}
+
+// logLines is a fixed size ring buffer of log lines.
+type logLines struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLogLines(size int) *logLines {
+	return &logLines{lines: make([]string, size)}
+}
+
+func (l *logLines) add(line string) {
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns log lines, oldest first.
+func (l *logLines) list() []string {
+	if !l.full {
+		return slices.Clone(l.lines[:l.next])
+	}
+	return append(slices.Clone(l.lines[l.next:]), l.lines[:l.next]...)
+}
+
+// logBuffer is a zerolog.LevelWriter keeping recent log lines in memory,
+// warning and error lines are kept separately, so they are not evicted by noisy logs.
+type logBuffer struct {
+	mu     sync.Mutex
+	logs   *logLines
+	errors *logLines
+}
+
+func newLogBuffer(logsSize, errorsSize int) *logBuffer {
+	return &logBuffer{logs: newLogLines(logsSize), errors: newLogLines(errorsSize)}
+}
+
+// Write implements io.Writer.
+func (lb *logBuffer) Write(p []byte) (int, error) {
+	return lb.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter.
+func (lb *logBuffer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.logs.add(line)
+	if level >= zerolog.WarnLevel && level <= zerolog.PanicLevel {
+		lb.errors.add(line)
+	}
+	return len(p), nil
+}
+
+// recent returns recent log lines, and recent warning and error log lines.
+func (lb *logBuffer) recent() recentLogsResponse {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return recentLogsResponse{Logs: lb.logs.list(), Errors: lb.errors.list()}
+}
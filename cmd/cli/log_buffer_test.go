@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_logBuffer(t *testing.T) {
+	lb := newLogBuffer(3, 2)
+	assert.Empty(t, lb.recent().Logs)
+
+	for i := 0; i < 5; i++ {
+		level := zerolog.InfoLevel
+		if i%2 == 0 {
+			level = zerolog.ErrorLevel
+		}
+		_, _ = lb.WriteLevel(level, []byte(strconv.Itoa(i)+"\n"))
+	}
+	recent := lb.recent()
+	assert.Equal(t, []string{"2", "3", "4"}, recent.Logs)
+	assert.Equal(t, []string{"2", "4"}, recent.Errors)
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	logExportFormatCSV     = "csv"
+	logExportFormatParquet = "parquet"
+
+	// logExportTimeFormat is the time format of CSV exported entries, which is understood by spreadsheets.
+	logExportTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// logExportHeader is the header of CSV exported entries, matching the query log fields.
+var logExportHeader = []string{"time", "client_ip", "mac", "hostname", "domain", "qtype", "rcode", "upstream", "blocked", "duration_ms", "rule", "list"}
+
+// logExportRequest is the request for exporting the query log sent to the control server.
+type logExportRequest struct {
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+}
+
+// logExportWriter writes exported query log entries in a file format.
+type logExportWriter interface {
+	write(e queryLogEntry) error
+	// close flushes pending entries, it does not close the underlying writer.
+	close() error
+}
+
+// newLogExportWriter returns the writer of entries to w, in given format.
+func newLogExportWriter(format string, w io.Writer) (logExportWriter, error) {
+	switch format {
+	case logExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(logExportHeader); err != nil {
+			return nil, err
+		}
+		return &logExportCSV{w: cw}, nil
+	case logExportFormatParquet:
+		return newLogExportParquet(w)
+	}
+	return nil, fmt.Errorf("unsupported export format: %q, must be %q or %q", format, logExportFormatCSV, logExportFormatParquet)
+}
+
+// logExportCSV writes entries as CSV records.
+type logExportCSV struct {
+	w *csv.Writer
+}
+
+func (lc *logExportCSV) write(e queryLogEntry) error {
+	return lc.w.Write([]string{
+		e.Time.Format(logExportTimeFormat),
+		e.ClientIP,
+		e.Mac,
+		e.Hostname,
+		e.Domain,
+		e.Qtype,
+		e.Rcode,
+		e.Upstream,
+		e.Blocked,
+		strconv.FormatFloat(e.Duration, 'f', -1, 64),
+		e.Rule,
+		e.List,
+	})
+}
+
+func (lc *logExportCSV) close() error {
+	lc.w.Flush()
+	return lc.w.Error()
+}
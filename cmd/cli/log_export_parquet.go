@@ -0,0 +1,81 @@
+//go:build parquet
+
+package cli
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetSupported reports whether Parquet export is compiled in.
+const parquetSupported = true
+
+// logExportParquetBatchSize is the number of rows buffered before written to Parquet file.
+const logExportParquetBatchSize = 1024
+
+// logExportParquetRow is the Parquet schema of exported entries. Columns of few distinct values are dictionary encoded.
+type logExportParquetRow struct {
+	Time     time.Time `parquet:"time,timestamp(millisecond)"`
+	ClientIP string    `parquet:"client_ip"`
+	Mac      string    `parquet:"mac"`
+	Hostname string    `parquet:"hostname"`
+	Domain   string    `parquet:"domain"`
+	Qtype    string    `parquet:"qtype,dict"`
+	Rcode    string    `parquet:"rcode,dict"`
+	Upstream string    `parquet:"upstream,dict"`
+	Blocked  string    `parquet:"blocked,dict"`
+	Duration float64   `parquet:"duration_ms"`
+	Rule     string    `parquet:"rule,dict"`
+	List     string    `parquet:"list,dict"`
+}
+
+// logExportParquet writes entries as rows of a Parquet file.
+type logExportParquet struct {
+	w     *parquet.GenericWriter[logExportParquetRow]
+	batch []logExportParquetRow
+}
+
+func (lp *logExportParquet) write(e queryLogEntry) error {
+	lp.batch = append(lp.batch, logExportParquetRow{
+		Time:     e.Time,
+		ClientIP: e.ClientIP,
+		Mac:      e.Mac,
+		Hostname: e.Hostname,
+		Domain:   e.Domain,
+		Qtype:    e.Qtype,
+		Rcode:    e.Rcode,
+		Upstream: e.Upstream,
+		Blocked:  e.Blocked,
+		Duration: e.Duration,
+		Rule:     e.Rule,
+		List:     e.List,
+	})
+	if len(lp.batch) >= logExportParquetBatchSize {
+		return lp.flush()
+	}
+	return nil
+}
+
+// flush writes buffered rows.
+func (lp *logExportParquet) flush() error {
+	if len(lp.batch) == 0 {
+		return nil
+	}
+	_, err := lp.w.Write(lp.batch)
+	lp.batch = lp.batch[:0]
+	return err
+}
+
+func (lp *logExportParquet) close() error {
+	if err := lp.flush(); err != nil {
+		return err
+	}
+	return lp.w.Close()
+}
+
+// newLogExportParquet returns the writer of entries to w as a Parquet file.
+func newLogExportParquet(w io.Writer) (logExportWriter, error) {
+	return &logExportParquet{w: parquet.NewGenericWriter[logExportParquetRow](w, parquet.Compression(&parquet.Snappy))}, nil
+}
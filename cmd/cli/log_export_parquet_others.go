@@ -0,0 +1,15 @@
+//go:build !parquet
+
+package cli
+
+import (
+	"errors"
+	"io"
+)
+
+// parquetSupported reports whether Parquet export is compiled in, which requires the "parquet" build tag.
+const parquetSupported = false
+
+func newLogExportParquet(w io.Writer) (logExportWriter, error) {
+	return nil, errors.New(`parquet export is not supported by this build of ctrld, it requires the "parquet" build tag`)
+}
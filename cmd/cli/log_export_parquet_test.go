@@ -0,0 +1,31 @@
+//go:build parquet
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_logExportParquet(t *testing.T) {
+	var buf bytes.Buffer
+	ew, err := newLogExportWriter(logExportFormatParquet, &buf)
+	require.NoError(t, err)
+	for _, e := range logExportEntries {
+		require.NoError(t, ew.write(e))
+	}
+	require.NoError(t, ew.close())
+
+	rows, err := parquet.Read[logExportParquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.True(t, logExportEntries[0].Time.Equal(rows[0].Time))
+	assert.Equal(t, "laptop", rows[0].Hostname)
+	assert.Equal(t, 1.25, rows[0].Duration)
+	assert.Equal(t, "policy", rows[1].Blocked)
+	assert.Equal(t, "ads", rows[1].List)
+}
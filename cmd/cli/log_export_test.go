@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var logExportEntries = []queryLogEntry{
+	{Time: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), ClientIP: "192.168.1.10", Hostname: "laptop", Domain: "example.com", Qtype: "A", Rcode: "NOERROR", Upstream: "upstream.0", Duration: 1.25},
+	{Time: time.Date(2024, 1, 1, 10, 0, 1, 0, time.UTC), ClientIP: "192.168.1.11", Domain: "ads.example.com", Qtype: "A", Rcode: "NXDOMAIN", Upstream: "block", Blocked: "policy", Rule: "list.ads", List: "ads"},
+}
+
+func Test_logExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	ew, err := newLogExportWriter(logExportFormatCSV, &buf)
+	require.NoError(t, err)
+	for _, e := range logExportEntries {
+		require.NoError(t, ew.write(e))
+	}
+	require.NoError(t, ew.close())
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, logExportHeader, records[0])
+	assert.Equal(t, []string{"2024-01-01T10:00:00.000Z", "192.168.1.10", "", "laptop", "example.com", "A", "NOERROR", "upstream.0", "", "1.25", "", ""}, records[1])
+	assert.Equal(t, "list.ads", records[2][10])
+	assert.Equal(t, "ads", records[2][11])
+}
+
+func Test_newLogExportWriter_invalidFormat(t *testing.T) {
+	_, err := newLogExportWriter("xlsx", &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// logLevelRequest represents request for changing log level of running ctrld.
+type logLevelRequest struct {
+	Level       string `json:"level"`
+	RevertAfter string `json:"revert_after,omitempty"`
+}
+
+// logLevelResponse represents current log level of running ctrld.
+type logLevelResponse struct {
+	Level    string    `json:"level"`
+	RevertAt time.Time `json:"revert_at,omitempty"`
+}
+
+// logLevelOverride manages log level changes made at runtime.
+type logLevelOverride struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	gen      uint64
+	orig     zerolog.Level
+	revertAt time.Time
+}
+
+// set changes the global log level. If revertAfter is positive, the log level which
+// was in effect before the first change is restored after that duration.
+func (o *logLevelOverride) set(level zerolog.Level, revertAfter time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+		o.revertAt = time.Time{}
+	} else {
+		o.orig = zerolog.GlobalLevel()
+	}
+	mainLog.Load().Notice().Msgf("changing log level to %q", level)
+	zerolog.SetGlobalLevel(level)
+	if revertAfter <= 0 {
+		return
+	}
+	o.gen++
+	gen := o.gen
+	o.revertAt = time.Now().Add(revertAfter)
+	o.timer = time.AfterFunc(revertAfter, func() { o.revert(gen) })
+}
+
+// revert restores the log level which was in effect before the override.
+// The gen is used to ignore stale timer, which already fired while the override was being changed.
+func (o *logLevelOverride) revert(gen uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer == nil || o.gen != gen {
+		return
+	}
+	o.timer = nil
+	o.revertAt = time.Time{}
+	mainLog.Load().Notice().Msgf("reverting log level to %q", o.orig)
+	zerolog.SetGlobalLevel(o.orig)
+}
+
+// current returns the current log level, and the time it will be reverted, if any.
+func (o *logLevelOverride) current() logLevelResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return logLevelResponse{Level: zerolog.GlobalLevel().String(), RevertAt: o.revertAt}
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func Test_logLevelOverride(t *testing.T) {
+	orig := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(orig) })
+	zerolog.SetGlobalLevel(zerolog.NoticeLevel)
+
+	o := &logLevelOverride{}
+	o.set(zerolog.DebugLevel, 0)
+	if got := o.current(); got.Level != zerolog.DebugLevel.String() || !got.RevertAt.IsZero() {
+		t.Fatalf("unexpected log level: %+v", got)
+	}
+
+	o.set(zerolog.TraceLevel, 100*time.Millisecond)
+	// Changing level again before reverting must keep the original level and the latest timer only.
+	o.set(zerolog.InfoLevel, 200*time.Millisecond)
+	if got := o.current(); got.Level != zerolog.InfoLevel.String() || got.RevertAt.IsZero() {
+		t.Fatalf("unexpected log level: %+v", got)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := zerolog.GlobalLevel(); got != zerolog.InfoLevel {
+		t.Fatalf("log level reverted too early, got: %s", got)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := o.current(); got.Level != zerolog.DebugLevel.String() || !got.RevertAt.IsZero() {
+		t.Fatalf("unexpected log level after reverting: %+v", got)
+	}
+}
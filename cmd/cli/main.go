@@ -29,8 +29,10 @@ var (
 	silent            bool
 	cdUID             string
 	cdOrg             string
+	cdPair            bool
 	customHostname    string
 	cdDev             bool
+	cdAPIURL          string
 	iface             string
 	ifaceStartStop    string
 	nextdns           string
@@ -39,6 +41,7 @@ var (
 	skipSelfChecks    bool
 	cleanup           bool
 	startOnly         bool
+	useSecretStore    bool
 
 	mainLog       atomic.Pointer[zerolog.Logger]
 	consoleWriter zerolog.ConsoleWriter
@@ -48,8 +51,13 @@ var (
 const (
 	cdUidFlagName          = "cd"
 	cdOrgFlagName          = "cd-org"
+	cdPairFlagName         = "cd-pair"
+	cdProfilesFlagName     = "cd-profiles"
+	cdAPIURLFlagName       = "cd-api-url"
+	cdScheduleFlagName     = "cd-schedule"
 	customHostnameFlagName = "custom-hostname"
 	nextdnsFlagName        = "nextdns"
+	secretStoreFlagName    = "secret-store"
 )
 
 func init() {
@@ -139,7 +147,7 @@ func initLoggingWithBackup(doBackup bool) {
 		}
 		writers = append(writers, logFile)
 	}
-	writers = append(writers, consoleWriter)
+	writers = append(writers, consoleWriter, recentLogs)
 	multi := zerolog.MultiLevelWriter(writers...)
 	l := mainLog.Load().Output(multi).With().Logger()
 	mainLog.Store(&l)
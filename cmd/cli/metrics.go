@@ -113,13 +113,14 @@ func (p *prog) runMetricsServer(ctx context.Context, reloadCh chan struct{}) {
 	}
 
 	addr := p.cfg.Service.MetricsListener
+	pushEndpoint := p.cfg.Service.MetricsPushEndpoint
 	ms, err := newMetricsServer(addr, reg)
 	if err != nil {
 		mainLog.Load().Warn().Err(err).Msg("could not create new metrics server")
 		return
 	}
-	// Only start listener address if defined.
-	if addr != "" {
+	// Only register ctrld stats if metrics are exposed or pushed.
+	if addr != "" || pushEndpoint != "" {
 		// Go runtime stats.
 		reg.MustRegister(collectors.NewBuildInfoCollector())
 		reg.MustRegister(collectors.NewGoCollector(
@@ -130,12 +131,37 @@ func (p *prog) runMetricsServer(ctx context.Context, reloadCh chan struct{}) {
 		statsVersion.WithLabelValues(commit, runtime.Version(), curVersion()).Inc()
 		reg.MustRegister(statsTimeStart)
 		statsTimeStart.Set(float64(time.Now().Unix()))
+		reg.MustRegister(statsQueriesDropped)
+		reg.MustRegister(statsQueriesRefused)
+		reg.MustRegister(statsResponsesRateLimited)
+		reg.MustRegister(statsUpstreamLatency)
+		reg.MustRegister(statsAnomalyClientsFlagged)
+		reg.MustRegister(statsSecurityBlocked)
+		reg.MustRegister(statsServfailSuppressed)
+		reg.MustRegister(statsCacheLookups)
+		reg.MustRegister(newStatsQueriesInflight(p.queryPool))
+		reg.MustRegister(newStatsQueriesQueued(p.queryPool))
+	}
+	// Only start listener address if defined.
+	if addr != "" {
+		p.registerHealthHandlers(func(pattern string, handler http.Handler) {
+			ms.register(pattern, jsonResponse(handler))
+		})
 		mainLog.Load().Debug().Msgf("starting metrics server on: %s", addr)
 		if err := ms.start(); err != nil {
 			mainLog.Load().Warn().Err(err).Msg("could not start metrics server")
 			return
 		}
 	}
+	if pushEndpoint != "" {
+		interval := p.metricsPushInterval()
+		mainLog.Load().Debug().Msgf("pushing metrics to: %s, every %s", pushEndpoint, interval)
+		if pusher, err := newMetricsPusher(ctx, pushEndpoint, interval, reg); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not start pushing metrics")
+		} else {
+			defer pusher.stop()
+		}
+	}
 
 	select {
 	case <-p.stopCh:
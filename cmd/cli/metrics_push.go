@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// metricsPushDefaultInterval is the default interval for pushing metrics.
+	metricsPushDefaultInterval = 10 * time.Second
+	// statsdMaxPacketSize is the maximum size of StatsD packet, which fits in common network MTU.
+	statsdMaxPacketSize = 1432
+)
+
+// metricsPusher pushes metrics gathered from Prometheus registry to a remote endpoint periodically.
+type metricsPusher interface {
+	stop()
+}
+
+// metricsPushInterval returns the interval for pushing metrics.
+func (p *prog) metricsPushInterval() time.Duration {
+	if d := p.cfg.Service.MetricsPushInterval; d != nil && *d > 0 {
+		return *d
+	}
+	return metricsPushDefaultInterval
+}
+
+// newMetricsPusher returns a metricsPusher for given endpoint, which is either "statsd://host:port"
+// for StatsD, or "http(s)://host:port/path" for OTLP/HTTP.
+func newMetricsPusher(ctx context.Context, endpoint string, interval time.Duration, g prometheus.Gatherer) (metricsPusher, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "statsd":
+		return newStatsdPusher(u.Host, interval, g)
+	case "http", "https":
+		return newOtlpMetricsPusher(ctx, endpoint, interval, g)
+	}
+	return nil, fmt.Errorf("unsupported metrics push endpoint scheme: %q", u.Scheme)
+}
+
+// statsdPusher pushes metrics to StatsD endpoint, using DogStatsD tags for metric labels.
+type statsdPusher struct {
+	conn   net.Conn
+	g      prometheus.Gatherer
+	last   map[string]float64 // last counter values, for pushing delta.
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newStatsdPusher(addr string, interval time.Duration, g prometheus.Gatherer) (*statsdPusher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdPusher{
+		conn:   conn,
+		g:      g,
+		last:   make(map[string]float64),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go s.loop(interval)
+	return s, nil
+}
+
+func (s *statsdPusher) loop(interval time.Duration) {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.push()
+		case <-s.stopCh:
+			s.push()
+			return
+		}
+	}
+}
+
+func (s *statsdPusher) push() {
+	mfs, err := s.g.Gather()
+	if err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not gather metrics")
+		return
+	}
+	for _, packet := range statsdPackets(statsdLines(mfs, s.last), statsdMaxPacketSize) {
+		if _, err := s.conn.Write(packet); err != nil {
+			mainLog.Load().Debug().Err(err).Msg("could not push metrics to StatsD")
+			return
+		}
+	}
+}
+
+func (s *statsdPusher) stop() {
+	close(s.stopCh)
+	<-s.doneCh
+	_ = s.conn.Close()
+}
+
+// statsdLines converts metric families to StatsD lines. Counters are sent as delta since the last
+// push, using values in last, which is updated with current values. Summaries and histograms are
+// sent as gauges of their quantiles, sum and count.
+func statsdLines(mfs []*dto.MetricFamily, last map[string]float64) []string {
+	var lines []string
+	gauge := func(name, tags string, v float64) {
+		lines = append(lines, name+":"+strconv.FormatFloat(v, 'f', -1, 64)+"|g"+tags)
+	}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			tags := statsdTags(m.GetLabel())
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				v := m.GetCounter().GetValue()
+				key := name + tags
+				delta := v - last[key]
+				// Counter was reset, e.g: ctrld reloaded.
+				if delta < 0 {
+					delta = v
+				}
+				last[key] = v
+				if delta == 0 {
+					continue
+				}
+				lines = append(lines, name+":"+strconv.FormatFloat(delta, 'f', -1, 64)+"|c"+tags)
+			case dto.MetricType_GAUGE:
+				gauge(name, tags, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				gauge(name, tags, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					gauge(name+"_p"+strconv.FormatFloat(q.GetQuantile()*100, 'f', -1, 64), tags, q.GetValue())
+				}
+				gauge(name+"_sum", tags, summary.GetSampleSum())
+				gauge(name+"_count", tags, float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				gauge(name+"_sum", tags, histogram.GetSampleSum())
+				gauge(name+"_count", tags, float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return lines
+}
+
+// statsdTags returns DogStatsD tags of given labels, e.g: "|#upstream:upstream.0".
+func statsdTags(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labels))
+	r := strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+	for _, l := range labels {
+		tags = append(tags, r.Replace(l.GetName())+":"+r.Replace(l.GetValue()))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// statsdPackets packs lines into packets not exceeding maxSize, unless a single line does.
+func statsdPackets(lines []string, maxSize int) [][]byte {
+	var packets [][]byte
+	var buf []byte
+	for _, line := range lines {
+		if len(buf) > 0 && len(buf)+1+len(line) > maxSize {
+			packets = append(packets, buf)
+			buf = nil
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	if len(buf) > 0 {
+		packets = append(packets, buf)
+	}
+	return packets
+}
@@ -0,0 +1,43 @@
+//go:build otel
+
+package cli
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// otlpMetricsPusher pushes metrics to OTLP/HTTP endpoint.
+type otlpMetricsPusher struct {
+	mp *sdkmetric.MeterProvider
+}
+
+func newOtlpMetricsPusher(ctx context.Context, endpoint string, interval time.Duration, g prometheus.Gatherer) (*otlpMetricsPusher, error) {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := otelResource()
+	if err != nil {
+		return nil, err
+	}
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithProducer(otelprom.NewMetricProducer(otelprom.WithGatherer(g))),
+		sdkmetric.WithInterval(interval),
+	)
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	return &otlpMetricsPusher{mp: mp}, nil
+}
+
+func (o *otlpMetricsPusher) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := o.mp.Shutdown(ctx); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not flush OTLP metrics")
+	}
+}
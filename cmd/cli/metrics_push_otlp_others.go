@@ -0,0 +1,15 @@
+//go:build !otel
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newOtlpMetricsPusher(ctx context.Context, endpoint string, interval time.Duration, g prometheus.Gatherer) (metricsPusher, error) {
+	return nil, errors.New(`pushing metrics to OTLP endpoint is not supported by this build of ctrld, it requires the "otel" build tag`)
+}
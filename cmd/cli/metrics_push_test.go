@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_statsdLines(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_queries"}, []string{"upstream"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_inflight"})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_latency", Objectives: map[float64]float64{0.95: 0.005}})
+	reg.MustRegister(counter, gauge, summary)
+
+	counter.WithLabelValues("upstream.0").Add(3)
+	gauge.Set(2)
+	summary.Observe(0.5)
+
+	last := make(map[string]float64)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	lines := statsdLines(mfs, last)
+	assert.Contains(t, lines, "test_queries:3|c|#upstream:upstream.0")
+	assert.Contains(t, lines, "test_inflight:2|g")
+	assert.Contains(t, lines, "test_latency_p95:0.5|g")
+	assert.Contains(t, lines, "test_latency_count:1|g")
+
+	// Counters are pushed as delta, unchanged ones are skipped.
+	counter.WithLabelValues("upstream.0").Add(2)
+	mfs, err = reg.Gather()
+	require.NoError(t, err)
+	assert.Contains(t, statsdLines(mfs, last), "test_queries:2|c|#upstream:upstream.0")
+	mfs, err = reg.Gather()
+	require.NoError(t, err)
+	for _, line := range statsdLines(mfs, last) {
+		assert.False(t, strings.HasPrefix(line, "test_queries:"), line)
+	}
+}
+
+func Test_statsdPackets(t *testing.T) {
+	lines := []string{"a:1|c", "b:2|c", "c:3|c"}
+	packets := statsdPackets(lines, 11)
+	require.Len(t, packets, 2)
+	assert.Equal(t, "a:1|c\nb:2|c", string(packets[0]))
+	assert.Equal(t, "c:3|c", string(packets[1]))
+}
+
+func Test_statsdPusher(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_inflight"})
+	reg.MustRegister(gauge)
+	gauge.Set(5)
+
+	s, err := newStatsdPusher(pc.LocalAddr().String(), time.Hour, reg)
+	require.NoError(t, err)
+	// Metrics are pushed once more when stopping.
+	s.stop()
+
+	buf := make([]byte, statsdMaxPacketSize)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "test_inflight:5|g", string(buf[:n]))
+}
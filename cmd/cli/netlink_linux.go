@@ -27,6 +27,8 @@ func (p *prog) watchLinkState(ctx context.Context) {
 				mainLog.Load().Debug().Msgf("link state changed, re-bootstrapping")
 				for _, uc := range p.cfg.Upstream {
 					uc.ReBootstrap()
+					// Pre-warm connection, so next queries won't pay for new connection setup.
+					go uc.Ping()
 				}
 			}
 		}
@@ -1,9 +1,10 @@
+//go:build freebsd || openbsd || illumos
+
 package cli
 
 import (
 	"net"
 	"net/netip"
-	"os/exec"
 
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/health"
@@ -12,26 +13,6 @@ import (
 	"github.com/Control-D-Inc/ctrld/internal/resolvconffile"
 )
 
-// allocate loopback ip
-// sudo ifconfig lo0 127.0.0.53 alias
-func allocateIP(ip string) error {
-	cmd := exec.Command("ifconfig", "lo0", ip, "alias")
-	if err := cmd.Run(); err != nil {
-		mainLog.Load().Error().Err(err).Msg("allocateIP failed")
-		return err
-	}
-	return nil
-}
-
-func deAllocateIP(ip string) error {
-	cmd := exec.Command("ifconfig", "lo0", ip, "-alias")
-	if err := cmd.Run(); err != nil {
-		mainLog.Load().Error().Err(err).Msg("deAllocateIP failed")
-		return err
-	}
-	return nil
-}
-
 // setDnsIgnoreUnusableInterface likes setDNS, but return a nil error if the interface is not usable.
 func setDnsIgnoreUnusableInterface(iface *net.Interface, nameservers []string) error {
 	return setDNS(iface, nameservers)
@@ -0,0 +1,23 @@
+package cli
+
+import "os/exec"
+
+// allocate loopback ip
+// sudo ifconfig lo0 addif 127.0.0.53/8 up
+func allocateIP(ip string) error {
+	cmd := exec.Command("ifconfig", "lo0", "addif", ip+"/8", "up")
+	if err := cmd.Run(); err != nil {
+		mainLog.Load().Error().Err(err).Msg("allocateIP failed")
+		return err
+	}
+	return nil
+}
+
+func deAllocateIP(ip string) error {
+	cmd := exec.Command("ifconfig", "lo0", "removeif", ip)
+	if err := cmd.Run(); err != nil {
+		mainLog.Load().Error().Err(err).Msg("deAllocateIP failed")
+		return err
+	}
+	return nil
+}
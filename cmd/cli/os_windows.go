@@ -12,6 +12,7 @@ import (
 
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 
+	"github.com/Control-D-Inc/ctrld"
 	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
 )
 
@@ -44,6 +45,10 @@ func setDNS(iface *net.Interface, nameservers []string) error {
 		return errors.New("empty DNS nameservers")
 	}
 	setDNSOnce.Do(func() {
+		// With DoH required by policy, Windows never sends plain DNS queries, including ones to ctrld listener.
+		if ctrld.SystemDohRequired() {
+			mainLog.Load().Warn().Msg("DNS client policy requires DoH, Windows may not send queries to ctrld")
+		}
 		// If there's a Dns server running, that means we are on AD with Dns feature enabled.
 		// Configuring the Dns server to forward queries to ctrld instead.
 		if windowsHasLocalDnsServerRunning() {
@@ -65,6 +70,11 @@ func setDNS(iface *net.Interface, nameservers []string) error {
 			}
 		}
 	})
+	// System DoH settings are keyed by nameserver IP, so they are kept and used again once the
+	// adapter DNS is restored. Meanwhile, ctrld OS resolver honors them for its own queries.
+	if templates := interfaceDohTemplates(iface); len(templates) > 0 {
+		mainLog.Load().Debug().Msgf("interface %q has system DoH settings: %v", iface.Name, templates)
+	}
 	out, err := powershell(setDnsPowershellCmd(iface, nameservers))
 	if err != nil {
 		return fmt.Errorf("%w: %s", err, string(out))
@@ -72,6 +82,19 @@ func setDNS(iface *net.Interface, nameservers []string) error {
 	return nil
 }
 
+// interfaceDohTemplates returns the system DoH templates configured for nameservers of given interface.
+func interfaceDohTemplates(iface *net.Interface) map[string]string {
+	luid, err := winipcfg.LUIDFromIndex(uint32(iface.Index))
+	if err != nil {
+		return nil
+	}
+	guid, err := luid.GUID()
+	if err != nil {
+		return nil
+	}
+	return ctrld.InterfaceDohTemplates(guid.String())
+}
+
 // resetDnsIgnoreUnusableInterface likes resetDNS, but return a nil error if the interface is not usable.
 func resetDnsIgnoreUnusableInterface(iface *net.Interface) error {
 	return resetDNS(iface)
@@ -0,0 +1,395 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"tailscale.com/net/tsaddr"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// upstreamTailscale is the upstream name of Tailscale MagicDNS resolver.
+	upstreamTailscale = upstreamPrefix + "tailscale"
+	// upstreamZeroTierPrefix is the prefix of ZeroTier networks DNS servers upstream names.
+	upstreamZeroTierPrefix = upstreamPrefix + "zerotier."
+	// upstreamNetbird is the upstream name of Netbird DNS resolver.
+	upstreamNetbird = upstreamPrefix + "netbird"
+	// tailscaleMagicDNSAddr is the address of Tailscale MagicDNS resolver.
+	tailscaleMagicDNSAddr = "100.100.100.100:53"
+	// tailscaleDefaultZone is the zone of all tailnet domains, used when the tailnet domain could not be detected.
+	tailscaleDefaultZone = "ts.net"
+	// overlayDNSInterval is the interval which overlay networks are detected again.
+	overlayDNSInterval = time.Minute
+	// overlayDNSCmdTimeout is the timeout for running overlay networks CLI.
+	overlayDNSCmdTimeout = 5 * time.Second
+)
+
+// overlayNetwork is a DNS domain of an overlay network, and the nameserver which resolves it.
+type overlayNetwork struct {
+	upstream   string
+	zone       string
+	nameserver string
+	prefixes   []netip.Prefix
+}
+
+// overlayDetector detects networks of an overlay software, like Tailscale, running on the machine.
+type overlayDetector struct {
+	name   string
+	detect func() []overlayNetwork
+}
+
+// overlayZone is a split-DNS zone of an overlay network, which queries are forwarded to the network resolver.
+type overlayZone struct {
+	overlayNetwork
+	uc *ctrld.UpstreamConfig
+}
+
+// overlayDNS detects overlay networks, like Tailscale, ZeroTier or Netbird, running on the machine, and routes
+// queries for their domains to the networks resolvers, so both ctrld and the overlay networks DNS keep working.
+type overlayDNS struct {
+	detectors []overlayDetector
+	// ucs caches upstream configs by nameserver, so connections are re-used across refreshes.
+	ucs map[string]*ctrld.UpstreamConfig
+
+	zones atomic.Pointer[[]overlayZone]
+}
+
+// newOverlayDNS returns new overlayDNS using the given service config.
+func newOverlayDNS(cfg *ctrld.ServiceConfig) *overlayDNS {
+	od := &overlayDNS{ucs: make(map[string]*ctrld.UpstreamConfig)}
+	if cfg.TailscaleMagicDNS == nil || *cfg.TailscaleMagicDNS {
+		od.detectors = append(od.detectors, overlayDetector{"Tailscale", detectTailscale})
+	}
+	if cfg.ZeroTierDNS == nil || *cfg.ZeroTierDNS {
+		od.detectors = append(od.detectors, overlayDetector{"ZeroTier", detectZeroTier})
+	}
+	if cfg.NetbirdDNS == nil || *cfg.NetbirdDNS {
+		od.detectors = append(od.detectors, overlayDetector{"Netbird", detectNetbird})
+	}
+	return od
+}
+
+// run detects overlay networks periodically, until stopCh or reloadCh is closed.
+func (od *overlayDNS) run(stopCh, reloadCh chan struct{}) {
+	if od == nil || len(od.detectors) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(overlayDNSInterval)
+		defer ticker.Stop()
+		for {
+			od.refresh()
+			select {
+			case <-ticker.C:
+			case <-stopCh:
+				return
+			case <-reloadCh:
+				return
+			}
+		}
+	}()
+}
+
+// refresh detects overlay networks, replacing current zones.
+func (od *overlayDNS) refresh() {
+	var zones []overlayZone
+	for _, d := range od.detectors {
+		for _, n := range d.detect() {
+			zones = append(zones, overlayZone{overlayNetwork: n, uc: od.upstreamConfigFor(d.name, n.nameserver)})
+		}
+	}
+	old := od.zones.Swap(&zones)
+	for _, z := range zones {
+		if old == nil || !slices.ContainsFunc(*old, func(o overlayZone) bool { return o.zone == z.zone }) {
+			mainLog.Load().Info().Msgf("overlay network detected, forwarding %s to %s", z.zone, z.nameserver)
+		}
+	}
+}
+
+// upstreamConfigFor returns the legacy upstream config for the given overlay network nameserver.
+func (od *overlayDNS) upstreamConfigFor(name, nameserver string) *ctrld.UpstreamConfig {
+	if uc := od.ucs[nameserver]; uc != nil {
+		return uc
+	}
+	uc := &ctrld.UpstreamConfig{
+		Name:     name + " DNS",
+		Type:     ctrld.ResolverTypeLegacy,
+		Endpoint: nameserver,
+		Timeout:  2000,
+	}
+	uc.Init()
+	od.ucs[nameserver] = uc
+	return uc
+}
+
+// upstreamFor returns the zone and the upstream which query for domain is forwarded to.
+// The returned upstream is empty if domain does not belong to any overlay network.
+func (od *overlayDNS) upstreamFor(domain string) (string, string) {
+	if od == nil {
+		return "", ""
+	}
+	zones := od.zones.Load()
+	if zones == nil {
+		return "", ""
+	}
+	var addr netip.Addr
+	if ip := ipFromARPA(dns.Fqdn(domain)); ip != nil {
+		addr, _ = netip.AddrFromSlice(ip)
+		addr = addr.Unmap()
+	}
+	for _, z := range *zones {
+		if z.zone != "" && inZone(domain, z.zone) {
+			return z.zone, z.upstream
+		}
+		if !addr.IsValid() {
+			continue
+		}
+		for _, prefix := range z.prefixes {
+			if prefix.Contains(addr) {
+				return prefix.String(), z.upstream
+			}
+		}
+	}
+	return "", ""
+}
+
+// upstreamConfig returns the upstream config of overlay network upstream name, or nil if not an overlay network one.
+func (od *overlayDNS) upstreamConfig(upstream string) *ctrld.UpstreamConfig {
+	if od == nil {
+		return nil
+	}
+	zones := od.zones.Load()
+	if zones == nil {
+		return nil
+	}
+	for _, z := range *zones {
+		if z.upstream == upstream {
+			return z.uc
+		}
+	}
+	return nil
+}
+
+// detectTailscale returns the tailnet, if Tailscale is running on the machine.
+func detectTailscale() []overlayNetwork {
+	if !hasTailscaleInterface() {
+		return nil
+	}
+	return []overlayNetwork{tailscaleNetwork(tailscaleMagicDNSSuffix())}
+}
+
+// tailscaleNetwork returns the tailnet with the given MagicDNS suffix.
+func tailscaleNetwork(suffix string) overlayNetwork {
+	zone := tailscaleDefaultZone
+	if suffix != "" {
+		zone = suffix
+	}
+	return overlayNetwork{
+		upstream:   upstreamTailscale,
+		zone:       zone,
+		nameserver: tailscaleMagicDNSAddr,
+		prefixes:   []netip.Prefix{tsaddr.CGNATRange(), tsaddr.TailscaleULARange()},
+	}
+}
+
+// hasTailscaleInterface reports whether there is an interface with Tailscale address assigned.
+func hasTailscaleInterface() bool {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(iface.Name)
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ip = ip.Unmap()
+			if isTailscaleAddr(name, ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTailscaleAddr reports whether ip, assigned to interface name, is a Tailscale address.
+//
+// The Tailscale IPv6 range is specific enough. For CGNAT range, which is also used by ISPs,
+// the interface name is checked, too: "tailscale0" on Linux/BSD, "Tailscale" on Windows, "utunX" on macOS.
+func isTailscaleAddr(name string, ip netip.Addr) bool {
+	if tsaddr.TailscaleULARange().Contains(ip) {
+		return true
+	}
+	if !tsaddr.CGNATRange().Contains(ip) {
+		return false
+	}
+	return strings.HasPrefix(name, "tailscale") || strings.HasPrefix(name, "utun")
+}
+
+// tailscaleMagicDNSSuffix returns the MagicDNS suffix of the current tailnet, using "tailscale status" command.
+func tailscaleMagicDNSSuffix() string {
+	out, err := runOverlayCmd(overlayBinary("tailscale", map[string]string{
+		"darwin":  "/Applications/Tailscale.app/Contents/MacOS/Tailscale",
+		"windows": `C:\Program Files\Tailscale\tailscale.exe`,
+	}), "status", "--json")
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get tailscale status")
+		return ""
+	}
+	return parseTailscaleMagicDNSSuffix(out)
+}
+
+// parseTailscaleMagicDNSSuffix returns the MagicDNS suffix from "tailscale status --json" output.
+func parseTailscaleMagicDNSSuffix(out []byte) string {
+	var status struct {
+		MagicDNSSuffix string
+		CurrentTailnet *struct {
+			MagicDNSSuffix string
+		}
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return ""
+	}
+	suffix := status.MagicDNSSuffix
+	if status.CurrentTailnet != nil && status.CurrentTailnet.MagicDNSSuffix != "" {
+		suffix = status.CurrentTailnet.MagicDNSSuffix
+	}
+	return canonicalName(suffix)
+}
+
+// detectZeroTier returns ZeroTier networks which have DNS configured, using "zerotier-cli listnetworks" command.
+func detectZeroTier() []overlayNetwork {
+	out, err := runOverlayCmd(overlayBinary("zerotier-cli", map[string]string{
+		"darwin":  "/Library/Application Support/ZeroTier/One/zerotier-cli",
+		"windows": `C:\Program Files (x86)\ZeroTier\One\zerotier-cli.bat`,
+	}), "-j", "listnetworks")
+	if err != nil {
+		return nil
+	}
+	return parseZeroTierNetworks(out)
+}
+
+// parseZeroTierNetworks returns networks which have DNS configured from "zerotier-cli -j listnetworks" output.
+func parseZeroTierNetworks(out []byte) []overlayNetwork {
+	var networks []struct {
+		ID                string   `json:"id"`
+		Status            string   `json:"status"`
+		AssignedAddresses []string `json:"assignedAddresses"`
+		DNS               struct {
+			Domain  string   `json:"domain"`
+			Servers []string `json:"servers"`
+		} `json:"dns"`
+	}
+	if err := json.Unmarshal(out, &networks); err != nil {
+		return nil
+	}
+	var res []overlayNetwork
+	for _, n := range networks {
+		if n.Status != "OK" || n.DNS.Domain == "" || len(n.DNS.Servers) == 0 {
+			continue
+		}
+		ip, err := netip.ParseAddr(n.DNS.Servers[0])
+		if err != nil {
+			continue
+		}
+		on := overlayNetwork{
+			upstream:   upstreamZeroTierPrefix + n.ID,
+			zone:       canonicalName(n.DNS.Domain),
+			nameserver: netip.AddrPortFrom(ip, 53).String(),
+		}
+		for _, addr := range n.AssignedAddresses {
+			if prefix, err := netip.ParsePrefix(addr); err == nil {
+				on.prefixes = append(on.prefixes, prefix.Masked())
+			}
+		}
+		res = append(res, on)
+	}
+	return res
+}
+
+// detectNetbird returns the Netbird network, using "netbird status" command.
+func detectNetbird() []overlayNetwork {
+	out, err := runOverlayCmd(overlayBinary("netbird", map[string]string{
+		"windows": `C:\Program Files\Netbird\netbird.exe`,
+	}), "status", "--json")
+	if err != nil {
+		return nil
+	}
+	return parseNetbirdNetwork(out)
+}
+
+// parseNetbirdNetwork returns the Netbird network from "netbird status --json" output.
+//
+// Netbird agent runs its DNS resolver on the peer Netbird IP, serving peers names under the
+// domain of the peer FQDN, e.g: "netbird.cloud".
+func parseNetbirdNetwork(out []byte) []overlayNetwork {
+	var status struct {
+		NetbirdIP string `json:"netbirdIp"`
+		FQDN      string `json:"fqdn"`
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(status.NetbirdIP)
+	if err != nil {
+		return nil
+	}
+	_, zone, ok := strings.Cut(canonicalName(status.FQDN), ".")
+	if !ok || zone == "" {
+		return nil
+	}
+	return []overlayNetwork{{
+		upstream:   upstreamNetbird,
+		zone:       zone,
+		nameserver: netip.AddrPortFrom(prefix.Addr(), 53).String(),
+		prefixes:   []netip.Prefix{prefix.Masked()},
+	}}
+}
+
+// runOverlayCmd runs overlay network CLI bin with args, returning its output.
+func runOverlayCmd(bin string, args ...string) ([]byte, error) {
+	if bin == "" {
+		return nil, exec.ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), overlayDNSCmdTimeout)
+	defer cancel()
+	return exec.CommandContext(ctx, bin, args...).Output()
+}
+
+// overlayBinary returns the path to overlay network CLI name, looking up in PATH,
+// then in the default installed location of the current OS, or empty string if not found.
+func overlayBinary(name string, locations map[string]string) string {
+	if bin, err := exec.LookPath(name); err == nil {
+		return bin
+	}
+	if location, ok := locations[runtime.GOOS]; ok {
+		if bin, err := exec.LookPath(location); err == nil {
+			return bin
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_overlayDNS_upstreamFor(t *testing.T) {
+	od := newOverlayDNS(&ctrld.ServiceConfig{})
+	zeroTier := overlayNetwork{
+		upstream:   upstreamZeroTierPrefix + "8056c2e21c000001",
+		zone:       "zt.example",
+		nameserver: "10.147.17.1:53",
+		prefixes:   []netip.Prefix{netip.MustParsePrefix("10.147.17.0/24")},
+	}
+	tests := []struct {
+		name         string
+		networks     []overlayNetwork
+		domain       string
+		wantZone     string
+		wantUpstream string
+	}{
+		{"tailnet domain", []overlayNetwork{tailscaleNetwork("tail1234.ts.net")}, "host.tail1234.ts.net", "tail1234.ts.net", upstreamTailscale},
+		{"other ts.net domain", []overlayNetwork{tailscaleNetwork("tail1234.ts.net")}, "host.other.ts.net", "", ""},
+		{"unknown suffix", []overlayNetwork{tailscaleNetwork("")}, "host.tail1234.ts.net", tailscaleDefaultZone, upstreamTailscale},
+		{"cgnat ptr", []overlayNetwork{tailscaleNetwork("tail1234.ts.net")}, "1.2.64.100.in-addr.arpa", "100.64.0.0/10", upstreamTailscale},
+		{"non tailscale ptr", []overlayNetwork{tailscaleNetwork("tail1234.ts.net")}, "1.1.168.192.in-addr.arpa", "", ""},
+		{"not detected", nil, "host.tail1234.ts.net", "", ""},
+		{"other domain", []overlayNetwork{tailscaleNetwork("tail1234.ts.net")}, "example.com", "", ""},
+		{"zerotier domain", []overlayNetwork{tailscaleNetwork(""), zeroTier}, "nas.zt.example", "zt.example", zeroTier.upstream},
+		{"zerotier ptr", []overlayNetwork{tailscaleNetwork(""), zeroTier}, "5.17.147.10.in-addr.arpa", "10.147.17.0/24", zeroTier.upstream},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			od.detectors = []overlayDetector{{"test", func() []overlayNetwork { return tc.networks }}}
+			od.refresh()
+			zone, upstream := od.upstreamFor(tc.domain)
+			assert.Equal(t, tc.wantZone, zone)
+			assert.Equal(t, tc.wantUpstream, upstream)
+		})
+	}
+	assert.Equal(t, zeroTier.nameserver, od.upstreamConfig(zeroTier.upstream).Endpoint)
+	assert.Equal(t, tailscaleMagicDNSAddr, od.upstreamConfig(upstreamTailscale).Endpoint)
+	assert.Nil(t, od.upstreamConfig(upstreamOS))
+
+	disabled := false
+	od = newOverlayDNS(&ctrld.ServiceConfig{TailscaleMagicDNS: &disabled, ZeroTierDNS: &disabled, NetbirdDNS: &disabled})
+	assert.Empty(t, od.detectors)
+	od.refresh()
+	_, upstream := od.upstreamFor("host.tail1234.ts.net")
+	assert.Empty(t, upstream)
+}
+
+func Test_isTailscaleAddr(t *testing.T) {
+	tests := []struct {
+		iface string
+		ip    string
+		want  bool
+	}{
+		{"tailscale0", "100.101.102.103", true},
+		{"utun4", "100.101.102.103", true},
+		{"eth0", "100.101.102.103", false},
+		{"eth0", "fd7a:115c:a1e0::1", true},
+		{"tailscale0", "192.168.1.1", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.iface+"/"+tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.want, isTailscaleAddr(tc.iface, netip.MustParseAddr(tc.ip)))
+		})
+	}
+}
+
+func Test_parseTailscaleMagicDNSSuffix(t *testing.T) {
+	out := []byte(`{"Version":"1.70.0","MagicDNSSuffix":"old.ts.net","CurrentTailnet":{"Name":"user@example.com","MagicDNSSuffix":"tail1234.ts.net.","MagicDNSEnabled":true}}`)
+	assert.Equal(t, "tail1234.ts.net", parseTailscaleMagicDNSSuffix(out))
+	assert.Equal(t, "", parseTailscaleMagicDNSSuffix([]byte("not json")))
+}
+
+func Test_parseZeroTierNetworks(t *testing.T) {
+	out := []byte(`[
+  {"id": "8056c2e21c000001", "status": "OK", "portDeviceName": "ztmjfcpubr", "assignedAddresses": ["10.147.17.5/24", "fd80:56c2:e21c::1/88"], "dns": {"domain": "ZT.example.", "servers": ["10.147.17.1"]}},
+  {"id": "8056c2e21c000002", "status": "OK", "assignedAddresses": ["10.147.18.5/24"], "dns": {"domain": "", "servers": []}},
+  {"id": "8056c2e21c000003", "status": "REQUESTING_CONFIGURATION", "dns": {"domain": "other.example", "servers": ["10.147.19.1"]}}
+]`)
+	networks := parseZeroTierNetworks(out)
+	if assert.Len(t, networks, 1) {
+		assert.Equal(t, upstreamZeroTierPrefix+"8056c2e21c000001", networks[0].upstream)
+		assert.Equal(t, "zt.example", networks[0].zone)
+		assert.Equal(t, "10.147.17.1:53", networks[0].nameserver)
+		assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.147.17.0/24"), netip.MustParsePrefix("fd80:56c2:e21c::/88")}, networks[0].prefixes)
+	}
+	assert.Empty(t, parseZeroTierNetworks([]byte("not json")))
+}
+
+func Test_parseNetbirdNetwork(t *testing.T) {
+	out := []byte(`{"netbirdIp": "100.119.230.104/16", "fqdn": "laptop.netbird.cloud", "usesKernelInterface": true}`)
+	networks := parseNetbirdNetwork(out)
+	if assert.Len(t, networks, 1) {
+		assert.Equal(t, upstreamNetbird, networks[0].upstream)
+		assert.Equal(t, "netbird.cloud", networks[0].zone)
+		assert.Equal(t, "100.119.230.104:53", networks[0].nameserver)
+		assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("100.119.0.0/16")}, networks[0].prefixes)
+	}
+	assert.Empty(t, parseNetbirdNetwork([]byte(`{"netbirdIp": "", "fqdn": ""}`)))
+}
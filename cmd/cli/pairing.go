@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kardianos/service"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+// cdUIDFromPairing returns the resolver uid of Control D device pairing, if "--cd-pair" flag was set.
+//
+// The pairing QR code and short code are displayed, then the Control D API is polled until
+// the user approves the device in Control D dashboard, so the resolver uid never has to be
+// typed or copied to the device.
+func cdUIDFromPairing(ctx context.Context) string {
+	// --cd and --cd-org flags supersede --cd-pair.
+	if !cdPair || cdUID != "" || cdOrg != "" {
+		return ""
+	}
+	if customHostname != "" && !validHostname(customHostname) {
+		mainLog.Load().Fatal().Msgf("invalid custom hostname: %q", customHostname)
+	}
+	session, err := controld.StartPairing(customHostname, rootCmd.Version, cdDev)
+	if err != nil {
+		mainLog.Load().Fatal().Err(err).Msg("failed to start device pairing")
+	}
+	printPairingSession(os.Stdout, session)
+	rc, err := controld.WaitPairing(ctx, session, rootCmd.Version, cdDev)
+	if err != nil {
+		mainLog.Load().Fatal().Err(err).Msg("failed to pair device")
+	}
+	mainLog.Load().Notice().Msg("Device paired successfully")
+	return rc.UID
+}
+
+// printPairingSession writes the QR code of pairing URL, and the short code for entering manually, to w.
+func printPairingSession(w io.Writer, session *controld.PairingSession) {
+	if session.URL != "" {
+		if code, err := qrcode.New(session.URL, qrcode.Medium); err == nil {
+			fmt.Fprintln(w, "Scan this QR code to pair the device with your Control D account:")
+			fmt.Fprintln(w)
+			fmt.Fprint(w, code.ToSmallString(false))
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Or open %s ", session.URL)
+	} else {
+		fmt.Fprint(w, "Go to Control D dashboard ")
+	}
+	fmt.Fprintf(w, "and enter code: %s\n", session.Code)
+	fmt.Fprintln(w, "Waiting for approval...")
+}
+
+// removePairFlagFromArgs removes "--cd-pair" flag from arguments of service config,
+// since it's only meaningful for "ctrld start" command.
+func removePairFlagFromArgs(sc *service.Config) {
+	sc.Arguments = slices.DeleteFunc(sc.Arguments, func(arg string) bool {
+		return arg == "--"+cdPairFlagName || strings.HasPrefix(arg, "--"+cdPairFlagName+"=")
+	})
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kardianos/service"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld/internal/controld"
+)
+
+func Test_printPairingSession(t *testing.T) {
+	var buf bytes.Buffer
+	printPairingSession(&buf, &controld.PairingSession{Code: "ABCD-1234", URL: "https://controld.com/pair/ABCD-1234"})
+	out := buf.String()
+	assert.Contains(t, out, "█")
+	assert.Contains(t, out, "Or open https://controld.com/pair/ABCD-1234 and enter code: ABCD-1234\n")
+
+	buf.Reset()
+	printPairingSession(&buf, &controld.PairingSession{Code: "ABCD-1234"})
+	assert.NotContains(t, buf.String(), "█")
+	assert.Contains(t, buf.String(), "enter code: ABCD-1234\n")
+}
+
+func Test_removePairFlagFromArgs(t *testing.T) {
+	sc := &service.Config{Arguments: []string{"run", "--cd-pair", "--cd-pair=true", "--iface=auto"}}
+	removePairFlagFromArgs(sc)
+	assert.Equal(t, []string{"run", "--iface=auto"}, sc.Arguments)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// pledgePromises is the set of pledge(2) promises needed by ctrld while running.
+//
+// "exec" is required for commands used to configure the OS (route, ifconfig, pfctl ...),
+// and "route" for reading the routing table when discovering network interfaces.
+const pledgePromises = "stdio rpath wpath cpath fattr flock chown inet mcast dns unix proc exec route getpw"
+
+// sandbox restricts ctrld process using pledge(2) and unveil(2). Failing to do so is not fatal,
+// ctrld keeps running without restriction.
+func sandbox() {
+	if err := unveilPaths(); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not unveil file system")
+	}
+	// Use PledgePromises, so commands run by ctrld are not restricted.
+	if err := unix.PledgePromises(pledgePromises); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not pledge promises")
+	}
+}
+
+// unveilPaths limits the file system view of ctrld to the paths it needs.
+func unveilPaths() error {
+	paths := map[string]string{
+		"/etc":                "rwc",
+		"/var":                "rwc",
+		"/tmp":                "rwc",
+		"/dev":                "rw",
+		"/bin":                "rx",
+		"/sbin":               "rx",
+		"/usr/bin":            "rx",
+		"/usr/sbin":           "rx",
+		"/usr/share/zoneinfo": "r",
+	}
+	if homedir != "" {
+		paths[homedir] = "rwc"
+	}
+	if f := v.ConfigFileUsed(); f != "" {
+		paths[filepath.Dir(f)] = "rwc"
+	}
+	// The executable directory must be writable for upgrading ctrld.
+	if exe, err := os.Executable(); err == nil {
+		paths[filepath.Dir(exe)] = "rwxc"
+	}
+	for path, perms := range paths {
+		if err := unix.Unveil(path, perms); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return unix.UnveilBlock()
+}
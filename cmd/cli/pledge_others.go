@@ -0,0 +1,6 @@
+//go:build !openbsd
+
+package cli
+
+// sandbox restricts ctrld process using pledge(2) and unveil(2) on OpenBSD.
+func sandbox() {}
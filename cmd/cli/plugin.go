@@ -0,0 +1,451 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// Events sent to plugins.
+const (
+	pluginEventQuery    = "query"
+	pluginEventResponse = "response"
+	pluginEventStart    = "start"
+	pluginEventStop     = "stop"
+	pluginEventUpstream = "upstream"
+)
+
+// Actions replied by plugins for "query" events.
+const (
+	pluginActionAllow   = "allow"
+	pluginActionDeny    = "deny"
+	pluginActionRewrite = "rewrite"
+)
+
+const (
+	pluginDefaultTimeout = 100 * time.Millisecond
+	// pluginRestartDelay is the time waiting before restarting a plugin which exited.
+	pluginRestartDelay = 5 * time.Second
+	// pluginStopTimeout is the time waiting for the plugin to exit after the "stop" event, before killing it.
+	pluginStopTimeout = 3 * time.Second
+	// pluginQueueSize is the maximum number of messages waiting to be sent, new ones are dropped when full.
+	pluginQueueSize = 1024
+	// pluginMaxLineSize is the maximum size of a reply line.
+	pluginMaxLineSize = 64 << 10
+	pluginRewriteTTL  = 60
+)
+
+// pluginQuery is the DNS query sent to plugins.
+type pluginQuery struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	ClientIP string `json:"client_ip"`
+	Mac      string `json:"mac,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Listener string `json:"listener"`
+}
+
+// pluginMessage is the message sent to plugins.
+type pluginMessage struct {
+	ID       uint64       `json:"id,omitempty"`
+	Event    string       `json:"event"`
+	Query    *pluginQuery `json:"query,omitempty"`
+	Rcode    string       `json:"rcode,omitempty"`
+	Answers  []string     `json:"answers,omitempty"`
+	Upstream string       `json:"upstream,omitempty"`
+	State    string       `json:"state,omitempty"`
+}
+
+// pluginReply is the reply of plugins for "query" events.
+type pluginReply struct {
+	ID     uint64 `json:"id"`
+	Action string `json:"action"`
+	// Answers are IP addresses, or resource records in zone file format, used by "rewrite" action.
+	Answers []string `json:"answers,omitempty"`
+	TTL     uint32   `json:"ttl,omitempty"`
+}
+
+// pluginProcess is a running plugin program.
+type pluginProcess struct {
+	stdin  io.WriteCloser
+	stdout io.Reader
+	wait   func() error
+	kill   func() error
+}
+
+// plugin runs an external program which receives events at key points of ctrld, and could deny
+// or rewrite queries. Messages are sent to the program stdin, and "query" replies are read from
+// its stdout, both are JSON objects, one per line. The program is restarted if it exits.
+//
+// Plugins never block ctrld: queries are resolved normally if the plugin does not reply within
+// the configured timeout, and events are dropped if the plugin does not keep up reading them.
+type plugin struct {
+	command []string
+	queue   chan []byte
+	done    chan struct{}
+	once    sync.Once
+	nextID  atomic.Uint64
+	// start starts the plugin program, stubbed in tests.
+	start func() (*pluginProcess, error)
+
+	mu      sync.Mutex
+	events  map[string]bool
+	timeout time.Duration
+	pending map[uint64]chan *pluginReply
+}
+
+// newPlugin returns the plugin of given service config, or nil if there's no "plugin_command" configured.
+// The old plugin is reused if its command does not change, otherwise it is stopped.
+func newPlugin(cfg *ctrld.ServiceConfig, old *plugin) *plugin {
+	command := strings.Fields(cfg.PluginCommand)
+	if old != nil && slices.Equal(old.command, command) {
+		old.configure(cfg)
+		return old
+	}
+	old.stop()
+	if len(command) == 0 {
+		return nil
+	}
+	pl := &plugin{
+		command: command,
+		queue:   make(chan []byte, pluginQueueSize),
+		done:    make(chan struct{}),
+		pending: make(map[uint64]chan *pluginReply),
+	}
+	pl.start = func() (*pluginProcess, error) { return startPluginProcess(command) }
+	pl.configure(cfg)
+	return pl
+}
+
+// configure applies the events and timeout of given service config.
+func (pl *plugin) configure(cfg *ctrld.ServiceConfig) {
+	events := make(map[string]bool)
+	for _, e := range cfg.PluginEvents {
+		events[e] = true
+	}
+	if len(events) == 0 {
+		for _, e := range []string{pluginEventQuery, pluginEventResponse, pluginEventStart, pluginEventStop, pluginEventUpstream} {
+			events[e] = true
+		}
+	}
+	timeout := pluginDefaultTimeout
+	if cfg.PluginTimeout != nil && *cfg.PluginTimeout > 0 {
+		timeout = *cfg.PluginTimeout
+	}
+	pl.mu.Lock()
+	pl.events = events
+	pl.timeout = timeout
+	pl.mu.Unlock()
+}
+
+// startPluginProcess starts the plugin program of given command.
+func startPluginProcess(command []string) (*pluginProcess, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pluginProcess{stdin: stdin, stdout: stdout, wait: cmd.Wait, kill: cmd.Process.Kill}, nil
+}
+
+// subscribed reports whether the plugin receives the given event.
+func (pl *plugin) subscribed(event string) bool {
+	if pl == nil {
+		return false
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.events[event]
+}
+
+// stop stops the plugin, sending the "stop" event to its program.
+func (pl *plugin) stop() {
+	if pl == nil {
+		return
+	}
+	pl.once.Do(func() { close(pl.done) })
+}
+
+// run runs the plugin program, restarting it if it exits, until stopCh is closed or the plugin is stopped.
+func (pl *plugin) run(stopCh <-chan struct{}) {
+	if pl == nil {
+		return
+	}
+	logger := mainLog.Load().With().Str("mode", "plugin").Logger()
+	logger.Notice().Msgf("starting plugin: %s", strings.Join(pl.command, " "))
+	for {
+		if err := pl.runOnce(stopCh); err != nil {
+			logger.Error().Err(err).Msgf("plugin exited, restarting in %s", pluginRestartDelay)
+		} else {
+			return
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-pl.done:
+			return
+		case <-time.After(pluginRestartDelay):
+		}
+	}
+}
+
+// runOnce starts the plugin program, sending queued messages to it, and reading its replies.
+// It returns nil if the plugin was stopped, or the error of the program exiting by itself.
+func (pl *plugin) runOnce(stopCh <-chan struct{}) error {
+	proc, err := pl.start()
+	if err != nil {
+		return err
+	}
+	if pl.subscribed(pluginEventStart) {
+		if b, err := encodePluginMessage(&pluginMessage{Event: pluginEventStart}); err == nil {
+			_, _ = proc.stdin.Write(b)
+		}
+	}
+	exited := make(chan error, 1)
+	go func() {
+		pl.readReplies(proc.stdout)
+		exited <- proc.wait()
+	}()
+	for {
+		select {
+		case b := <-pl.queue:
+			if _, err := proc.stdin.Write(b); err != nil {
+				_ = proc.kill()
+				<-exited
+				return err
+			}
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("plugin exited")
+			}
+			return err
+		case <-stopCh:
+			pl.shutdown(proc, exited)
+			return nil
+		case <-pl.done:
+			pl.shutdown(proc, exited)
+			return nil
+		}
+	}
+}
+
+// shutdown sends the "stop" event to the plugin program, and closes its stdin, so it could exit by itself.
+// The program is killed if it does not exit in time.
+func (pl *plugin) shutdown(proc *pluginProcess, exited <-chan error) {
+	if pl.subscribed(pluginEventStop) {
+		if b, err := encodePluginMessage(&pluginMessage{Event: pluginEventStop}); err == nil {
+			_, _ = proc.stdin.Write(b)
+		}
+	}
+	_ = proc.stdin.Close()
+	select {
+	case <-exited:
+	case <-time.After(pluginStopTimeout):
+		_ = proc.kill()
+		<-exited
+	}
+}
+
+// readReplies reads replies from r, delivering them to pending queries.
+func (pl *plugin) readReplies(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), pluginMaxLineSize)
+	for scanner.Scan() {
+		reply := &pluginReply{}
+		if err := json.Unmarshal(scanner.Bytes(), reply); err != nil {
+			mainLog.Load().Debug().Err(err).Msg("invalid plugin reply")
+			continue
+		}
+		pl.mu.Lock()
+		ch := pl.pending[reply.ID]
+		delete(pl.pending, reply.ID)
+		pl.mu.Unlock()
+		if ch != nil {
+			ch <- reply
+		}
+	}
+}
+
+// encodePluginMessage returns m encoded as a JSON line.
+func encodePluginMessage(m *pluginMessage) ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// enqueue queues m for sending to the plugin program, reporting whether it was queued.
+func (pl *plugin) enqueue(m *pluginMessage) bool {
+	b, err := encodePluginMessage(m)
+	if err != nil {
+		return false
+	}
+	select {
+	case pl.queue <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+// notify sends m to the plugin if it subscribes to the event, without waiting for any replies.
+func (pl *plugin) notify(m *pluginMessage) {
+	if pl.subscribed(m.Event) {
+		pl.enqueue(m)
+	}
+}
+
+// query returns the pluginQuery of given query, or nil if the plugin does not subscribe to any query events.
+func (pl *plugin) query(listener string, ci *ctrld.ClientInfo, domain string, qtype uint16) *pluginQuery {
+	if !pl.subscribed(pluginEventQuery) && !pl.subscribed(pluginEventResponse) {
+		return nil
+	}
+	return &pluginQuery{
+		Name:     domain,
+		Type:     dns.TypeToString[qtype],
+		ClientIP: ci.IP,
+		Mac:      ci.Mac,
+		Hostname: ci.Hostname,
+		Listener: listener,
+	}
+}
+
+// onQuery sends the "query" event to the plugin, returning its reply, or nil if the plugin
+// does not reply in time, the query is then resolved normally.
+func (pl *plugin) onQuery(ctx context.Context, q *pluginQuery) *pluginReply {
+	if q == nil || !pl.subscribed(pluginEventQuery) {
+		return nil
+	}
+	id := pl.nextID.Add(1)
+	ch := make(chan *pluginReply, 1)
+	pl.mu.Lock()
+	pl.pending[id] = ch
+	timeout := pl.timeout
+	pl.mu.Unlock()
+	defer func() {
+		pl.mu.Lock()
+		delete(pl.pending, id)
+		pl.mu.Unlock()
+	}()
+	if !pl.enqueue(&pluginMessage{ID: id, Event: pluginEventQuery, Query: q}) {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ch:
+		return reply
+	case <-timer.C:
+		ctrld.Log(ctx, mainLog.Load().Debug(), "plugin did not reply in %s, resolving %s normally", timeout, q.Name)
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// onResponse sends the "response" event to the plugin.
+func (pl *plugin) onResponse(q *pluginQuery, answer *dns.Msg, upstream string) {
+	if q == nil || answer == nil || !pl.subscribed(pluginEventResponse) {
+		return
+	}
+	m := &pluginMessage{Event: pluginEventResponse, Query: q, Rcode: dns.RcodeToString[answer.Rcode], Upstream: upstream}
+	for _, rr := range answer.Answer {
+		m.Answers = append(m.Answers, rr.String())
+	}
+	pl.enqueue(m)
+}
+
+// onUpstreamStateChange sends the "upstream" event to the plugin.
+func (pl *plugin) onUpstreamStateChange(upstream string, down bool) {
+	state := "up"
+	if down {
+		state = "down"
+	}
+	pl.notify(&pluginMessage{Event: pluginEventUpstream, Upstream: upstream, State: state})
+}
+
+// pluginAnswer returns the answer of query m for plugin reply, or nil if the query should be resolved normally.
+func pluginAnswer(m *dns.Msg, reply *pluginReply) *dns.Msg {
+	if reply == nil {
+		return nil
+	}
+	switch reply.Action {
+	case pluginActionDeny:
+		return newErrorAnswer(m, dns.RcodeNameError, dns.ExtendedErrorCodeBlocked, "blocked by plugin")
+	case pluginActionRewrite:
+	default:
+		return nil
+	}
+	q := m.Question[0]
+	ttl := reply.TTL
+	if ttl == 0 {
+		ttl = pluginRewriteTTL
+	}
+	answer := new(dns.Msg)
+	answer.SetReply(m)
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: ttl}
+	var records []dns.RR
+	for _, s := range reply.Answers {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			if rr, err := dns.NewRR(s); err == nil && rr != nil {
+				records = append(records, rr)
+			}
+			continue
+		}
+		switch {
+		case q.Qtype == dns.TypeA && ip.To4() != nil:
+			answer.Answer = append(answer.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+		case q.Qtype == dns.TypeAAAA && ip.To4() == nil:
+			answer.Answer = append(answer.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	answer.Answer = append(answer.Answer, answerChainRecords(q.Name, records)...)
+	return answer
+}
+
+// answerChainRecords returns records which belong to the answer of qname, that's their owner name is qname,
+// or the target of a CNAME record in the chain starting at qname. Other records are dropped, so plugins could
+// not inject records of unrelated names into the answer.
+func answerChainRecords(qname string, records []dns.RR) []dns.RR {
+	owners := map[string]bool{dns.CanonicalName(qname): true}
+	// Records could be in any order, so follow the chain until no new target is found.
+	for found := true; found; {
+		found = false
+		for _, rr := range records {
+			if cname, ok := rr.(*dns.CNAME); ok && owners[dns.CanonicalName(cname.Hdr.Name)] && !owners[dns.CanonicalName(cname.Target)] {
+				owners[dns.CanonicalName(cname.Target)] = true
+				found = true
+			}
+		}
+	}
+	chain := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if owners[dns.CanonicalName(rr.Header().Name)] {
+			chain = append(chain, rr)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// newTestPlugin returns a plugin running handler as its program, handler receives messages
+// sent to the plugin, and returns the reply to write, if any.
+func newTestPlugin(t *testing.T, cfg *ctrld.ServiceConfig, handler func(m *pluginMessage) *pluginReply) *plugin {
+	t.Helper()
+	cfg.PluginCommand = "test-plugin"
+	pl := newPlugin(cfg, nil)
+	require.NotNil(t, pl)
+	pl.start = func() (*pluginProcess, error) {
+		stdinR, stdinW := io.Pipe()
+		stdoutR, stdoutW := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer stdoutW.Close()
+			scanner := bufio.NewScanner(stdinR)
+			for scanner.Scan() {
+				m := &pluginMessage{}
+				if err := json.Unmarshal(scanner.Bytes(), m); err != nil {
+					continue
+				}
+				if reply := handler(m); reply != nil {
+					b, _ := json.Marshal(reply)
+					_, _ = stdoutW.Write(append(b, '\n'))
+				}
+			}
+		}()
+		return &pluginProcess{
+			stdin:  stdinW,
+			stdout: stdoutR,
+			wait:   func() error { <-done; return nil },
+			kill:   func() error { return stdinR.Close() },
+		}, nil
+	}
+	stopCh := make(chan struct{})
+	go pl.run(stopCh)
+	t.Cleanup(func() { close(stopCh) })
+	return pl
+}
+
+func Test_newPlugin(t *testing.T) {
+	assert.Nil(t, newPlugin(&ctrld.ServiceConfig{}, nil))
+
+	pl := newPlugin(&ctrld.ServiceConfig{PluginCommand: "/usr/bin/plugin -v"}, nil)
+	require.NotNil(t, pl)
+	assert.Equal(t, []string{"/usr/bin/plugin", "-v"}, pl.command)
+	assert.Equal(t, pluginDefaultTimeout, pl.timeout)
+	assert.True(t, pl.subscribed(pluginEventQuery))
+	assert.True(t, pl.subscribed(pluginEventUpstream))
+
+	// Same command, plugin is reused with new settings.
+	timeout := time.Second
+	same := newPlugin(&ctrld.ServiceConfig{PluginCommand: "/usr/bin/plugin  -v", PluginEvents: []string{pluginEventStart}, PluginTimeout: &timeout}, pl)
+	assert.Same(t, pl, same)
+	assert.Equal(t, timeout, pl.timeout)
+	assert.True(t, pl.subscribed(pluginEventStart))
+	assert.False(t, pl.subscribed(pluginEventQuery))
+
+	// Command changed, old plugin is stopped.
+	other := newPlugin(&ctrld.ServiceConfig{PluginCommand: "/usr/bin/other"}, pl)
+	assert.NotSame(t, pl, other)
+	select {
+	case <-pl.done:
+	default:
+		t.Fatal("old plugin was not stopped")
+	}
+	assert.Nil(t, newPlugin(&ctrld.ServiceConfig{}, other))
+
+	// Nil plugin is no-op.
+	var nilPlugin *plugin
+	assert.Nil(t, nilPlugin.onQuery(context.Background(), &pluginQuery{Name: "example.com"}))
+	nilPlugin.onUpstreamStateChange("upstream.0", true)
+	nilPlugin.stop()
+}
+
+func Test_plugin_onQuery(t *testing.T) {
+	events := make(chan *pluginMessage, 10)
+	pl := newTestPlugin(t, &ctrld.ServiceConfig{}, func(m *pluginMessage) *pluginReply {
+		events <- m
+		if m.Event != pluginEventQuery {
+			return nil
+		}
+		switch m.Query.Name {
+		case "blocked.com":
+			return &pluginReply{ID: m.ID, Action: pluginActionDeny}
+		case "slow.com":
+			return nil
+		}
+		return &pluginReply{ID: m.ID, Action: pluginActionAllow}
+	})
+	assert.Equal(t, pluginEventStart, (<-events).Event)
+
+	ci := &ctrld.ClientInfo{IP: "192.168.1.10", Mac: "aa:bb:cc:dd:ee:ff", Hostname: "laptop"}
+	q := pl.query("0", ci, "blocked.com", dns.TypeA)
+	reply := pl.onQuery(context.Background(), q)
+	require.NotNil(t, reply)
+	assert.Equal(t, pluginActionDeny, reply.Action)
+	m := <-events
+	assert.Equal(t, "blocked.com", m.Query.Name)
+	assert.Equal(t, "A", m.Query.Type)
+	assert.Equal(t, "192.168.1.10", m.Query.ClientIP)
+	assert.Equal(t, "laptop", m.Query.Hostname)
+
+	reply = pl.onQuery(context.Background(), pl.query("0", ci, "example.com", dns.TypeA))
+	require.NotNil(t, reply)
+	assert.Equal(t, pluginActionAllow, reply.Action)
+	<-events
+
+	// No reply in time, query is resolved normally.
+	assert.Nil(t, pl.onQuery(context.Background(), pl.query("0", ci, "slow.com", dns.TypeA)))
+	<-events
+	pl.mu.Lock()
+	assert.Empty(t, pl.pending)
+	pl.mu.Unlock()
+
+	pl.onUpstreamStateChange("upstream.0", true)
+	m = <-events
+	assert.Equal(t, pluginEventUpstream, m.Event)
+	assert.Equal(t, "upstream.0", m.Upstream)
+	assert.Equal(t, "down", m.State)
+
+	answer := new(dns.Msg)
+	answer.SetQuestion("example.com.", dns.TypeA)
+	answer.Answer = append(answer.Answer, &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("1.2.3.4")})
+	pl.onResponse(q, answer, upstreamPrefix+"0")
+	m = <-events
+	assert.Equal(t, pluginEventResponse, m.Event)
+	assert.Equal(t, "NOERROR", m.Rcode)
+	assert.Equal(t, upstreamPrefix+"0", m.Upstream)
+	assert.Len(t, m.Answers, 1)
+}
+
+func Test_pluginAnswer(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+
+	assert.Nil(t, pluginAnswer(m, nil))
+	assert.Nil(t, pluginAnswer(m, &pluginReply{Action: pluginActionAllow}))
+
+	answer := pluginAnswer(m, &pluginReply{Action: pluginActionDeny})
+	require.NotNil(t, answer)
+	assert.Equal(t, dns.RcodeNameError, answer.Rcode)
+
+	answer = pluginAnswer(m, &pluginReply{Action: pluginActionRewrite, Answers: []string{"10.0.0.1", "::1", "example.com. 300 IN A 10.0.0.2", "invalid rr"}})
+	require.NotNil(t, answer)
+	assert.Equal(t, dns.RcodeSuccess, answer.Rcode)
+	require.Len(t, answer.Answer, 2)
+	a := answer.Answer[0].(*dns.A)
+	assert.Equal(t, "10.0.0.1", a.A.String())
+	assert.Equal(t, uint32(pluginRewriteTTL), a.Hdr.Ttl)
+	assert.Equal(t, uint32(300), answer.Answer[1].Header().Ttl)
+
+	// Records of names outside the CNAME chain of the query are dropped.
+	answer = pluginAnswer(m, &pluginReply{Action: pluginActionRewrite, Answers: []string{
+		"cdn.example.net. 300 IN A 10.0.0.3",
+		"EXAMPLE.com. 300 IN CNAME www.example.org.",
+		"www.example.org. 300 IN CNAME cdn.example.net.",
+		"bank.example. 300 IN A 10.6.6.6",
+		"other.example. 300 IN CNAME cdn.example.net.",
+	}})
+	require.NotNil(t, answer)
+	require.Len(t, answer.Answer, 3)
+	for _, rr := range answer.Answer {
+		assert.Contains(t, []string{"cdn.example.net.", "EXAMPLE.com.", "www.example.org."}, rr.Header().Name)
+	}
+}
+
+func Test_upstreamMonitor_onStateChange(t *testing.T) {
+	um := newUpstreamMonitor(&ctrld.Config{})
+	var changes []bool
+	um.onStateChange = append(um.onStateChange, func(upstream string, down bool) {
+		changes = append(changes, down)
+	})
+	for i := 0; i < maxFailureRequest+1; i++ {
+		um.increaseFailureCount(upstreamOS)
+	}
+	um.reset(upstreamOS)
+	um.reset(upstreamOS)
+	assert.Equal(t, []bool{true, false}, changes)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/expr"
+)
+
+var _ expr.Env = (*policyExprEnv)(nil)
+
+// policyExprEnv is the expr.Env of a query, used for evaluating policy expression rules.
+type policyExprEnv struct {
+	p        *prog
+	listener string
+	domain   string
+	qtype    uint16
+	ip       net.IP
+	mac      string
+}
+
+// Var returns the value of variable name for the query.
+func (e *policyExprEnv) Var(name string) string {
+	switch name {
+	case expr.VarQname:
+		return e.domain
+	case expr.VarQtype:
+		return dns.TypeToString[e.qtype]
+	case expr.VarClient:
+		if e.ip == nil {
+			return ""
+		}
+		return e.ip.String()
+	case expr.VarMac:
+		return e.mac
+	case expr.VarHostname:
+		// Hostname is only looked up if it is used by expressions.
+		if e.p.ciTable == nil || e.ip == nil {
+			return ""
+		}
+		return e.p.ciTable.LookupHostname(e.ip.String(), e.mac)
+	case expr.VarListener:
+		return e.listener
+	}
+	return ""
+}
+
+// InGroup reports whether value is an IP address in the network of group.
+func (e *policyExprEnv) InGroup(group, value string) bool {
+	nc := e.p.cfg.NetworkGroup(group)
+	ip := net.ParseIP(value)
+	if nc == nil || ip == nil {
+		return false
+	}
+	for _, ipNet := range nc.IPNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// InList reports whether value is a domain in the domain list.
+func (e *policyExprEnv) InList(list, value string) bool {
+	return e.p.domainLists.matches(ctrld.DomainListPrefix+list, value)
+}
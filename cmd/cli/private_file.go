@@ -0,0 +1,22 @@
+//go:build !windows
+
+package cli
+
+import "os"
+
+// writePrivateFile writes data to the named file, which is only readable by its owner.
+func writePrivateFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// The mode only applies to new files, so permissions of existing one must be fixed, too.
+	err = f.Chmod(0600)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
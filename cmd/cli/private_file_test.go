@@ -0,0 +1,33 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writePrivateFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "ctrld.token")
+	if err := os.WriteFile(name, []byte("old content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePrivateFile(name, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("unexpected permissions, want: %v, got: %v", os.FileMode(0600), perm)
+	}
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "token" {
+		t.Errorf("unexpected content, want: %q, got: %q", "token", string(buf))
+	}
+}
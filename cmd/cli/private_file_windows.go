@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// privateFileSDDL is the security descriptor of private files, granting access to LocalSystem
+// and Administrators only, without inheriting permissions of the parent directory.
+const privateFileSDDL = "D:P(A;;FA;;;SY)(A;;FA;;;BA)"
+
+// privateSecurityAttributes returns the security attributes of private files and directories.
+func privateSecurityAttributes() (*windows.SecurityAttributes, error) {
+	sd, err := windows.SecurityDescriptorFromString(privateFileSDDL)
+	if err != nil {
+		return nil, err
+	}
+	return &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+// writePrivateFile writes data to the named file, which is only accessible by LocalSystem and Administrators.
+// File modes are ignored on Windows, so the file is created with an explicit ACL instead.
+func writePrivateFile(name string, data []byte) error {
+	sa, err := privateSecurityAttributes()
+	if err != nil {
+		return err
+	}
+	// The security attributes only apply to new files, so existing one is removed first.
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(path, windows.GENERIC_WRITE, 0, sa, windows.CREATE_NEW, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(h), name)
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -11,10 +11,10 @@ import (
 	"net/netip"
 	"net/url"
 	"os"
+	"path"
 	"runtime"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,15 +36,20 @@ import (
 )
 
 const (
-	defaultSemaphoreCap  = 256
-	ctrldLogUnixSock     = "ctrld_start.sock"
-	ctrldControlUnixSock = "ctrld_control.sock"
+	defaultWorkers           = 256
+	defaultMaxQueuedRequests = 1024
+	ctrldLogUnixSock         = "ctrld_start.sock"
+	ctrldControlUnixSock     = "ctrld_control.sock"
 	// iOS unix socket name max length is 11.
-	ctrldControlUnixSockMobile = "cd.sock"
-	upstreamPrefix             = "upstream."
-	upstreamOS                 = upstreamPrefix + "os"
-	upstreamPrivate            = upstreamPrefix + "private"
-	dnsWatchdogDefaultInterval = 20 * time.Second
+	ctrldControlUnixSockMobile  = "cd.sock"
+	upstreamPrefix              = "upstream."
+	upstreamOS                  = upstreamPrefix + "os"
+	upstreamPrivate             = upstreamPrefix + "private"
+	upstreamBypass              = "bypass"
+	upstreamBlock               = "block"
+	dnsWatchdogDefaultInterval  = 20 * time.Second
+	shutdownDrainDefaultTimeout = 5 * time.Second
+	tlsSessionCacheFileName     = "tls_sessions.json"
 )
 
 // ControlSocketName returns name for control unix socket.
@@ -84,20 +89,46 @@ type prog struct {
 	dnsWg                sync.WaitGroup
 	dnsWatcherClosedOnce sync.Once
 	dnsWatcherStopCh     chan struct{}
+	listenersWg          sync.WaitGroup
 
 	cfg                  *ctrld.Config
 	localUpstreams       []string
 	ptrNameservers       []string
 	appCallback          *AppCallback
 	cache                dnscache.Cacher
+	tlsSessionCache      *ctrld.TLSSessionCache
 	cacheFlushDomainsMap map[string]struct{}
-	sema                 semaphore
+	queryPool            queryPool
 	ciTable              *clientinfo.Table
 	um                   *upstreamMonitor
+	ul                   *upstreamLatency
+	upstreamCerts        *upstreamCerts
+	dnsEvents            *dnsEventLog
+	anomaly              *anomalyDetector
+	queryLog             *queryLog
+	sinkholeLog          *queryLog
+	servfailCache        *servfailCache
+	queryStats           *queryStats
+	threatFeeds          *threatFeeds
+	plugin               *plugin
+	alerts               *alerts
+	reverseZones         *reverseZones
+	overlayDNS           *overlayDNS
+	adZones              *adZones
+	localRecords         *localRecords
+	domainLists          domainLists
+	logLevel             logLevelOverride
+	cdSchedules          []*cdSchedule
+	stopAuthorizedAt     atomic.Int64
+	stoppedUnauthorized  bool
+	pinThrottle          pinThrottle
+	firewallMu           sync.Mutex
+	firewall             *firewallConfig // the installed firewall rules, guarded by firewallMu.
 	router               router.Router
 	ptrLoopGuard         *loopGuard
 	lanLoopGuard         *loopGuard
 	metricsQueryStats    atomic.Bool
+	debugEndpoints       atomic.Bool
 	queryFromSelfMap     sync.Map
 
 	selfUninstallMu       sync.Mutex
@@ -161,25 +192,18 @@ func (p *prog) runWait() {
 		}
 
 		if newCfg == nil {
-			newCfg = &ctrld.Config{}
-			v := viper.NewWithOptions(viper.KeyDelimiter("::"))
-			ctrld.InitConfig(v, "ctrld")
-			if configPath != "" {
-				v.SetConfigFile(configPath)
-			}
-			if err := v.ReadInConfig(); err != nil {
-				logger.Err(err).Msg("could not read new config")
-				waitOldRunDone()
-				continue
-			}
-			if err := v.Unmarshal(&newCfg); err != nil {
-				logger.Err(err).Msg("could not unmarshal new config")
+			cfg, err := readNewConfig(configPath)
+			if err != nil {
+				logger.Err(err).Msg("could not load new config")
+				p.alerts.configReloadFailed("could not load new config", err)
 				waitOldRunDone()
 				continue
 			}
-			if cdUID != "" {
+			newCfg = cfg
+			if loadCdUID() != "" {
 				if err := processCDFlags(newCfg); err != nil {
 					logger.Err(err).Msg("could not fetch ControlD config")
+					p.alerts.configReloadFailed("could not fetch ControlD config", err)
 					waitOldRunDone()
 					continue
 				}
@@ -206,6 +230,7 @@ func (p *prog) runWait() {
 		}
 		if err := validateConfig(newCfg); err != nil {
 			logger.Err(err).Msg("invalid config")
+			p.alerts.configReloadFailed("invalid config", err)
 			continue
 		}
 
@@ -232,19 +257,45 @@ func (p *prog) runWait() {
 	}
 }
 
+// readNewConfig reads the config at path, or the default config file if path is empty, for reloading.
+func readNewConfig(path string) (*ctrld.Config, error) {
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	ctrld.InitConfig(v, "ctrld")
+	if path != "" {
+		v.SetConfigFile(path)
+	}
+	if err := readInConfig(v); err != nil {
+		return nil, fmt.Errorf("could not read new config: %w", err)
+	}
+	cfg := &ctrld.Config{}
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("could not unmarshal new config: %w", err)
+	}
+	return cfg, nil
+}
+
 func (p *prog) preRun() {
 	if runtime.GOOS == "darwin" {
 		p.onStopped = append(p.onStopped, func() {
 			if !service.Interactive() {
+				// Keep DNS settings point to ctrld, so filtering could not be bypassed by stopping
+				// ctrld using service manager while deactivation protection is on.
+				if p.stoppedUnauthorized {
+					mainLog.Load().Warn().Msg("stopped without deactivation pin, keeping DNS settings")
+					return
+				}
 				p.resetDNS()
 			}
 		})
 	}
+	// Run last, after DNS settings and router setup were restored.
+	p.onStopped = append(p.onStopped, func() { p.runHook(hookEventStop) })
 }
 
 func (p *prog) postRun() {
 	if !service.Interactive() {
-		p.resetDNS()
+		// DNS is set to ctrld again below, so the restoring hook is not run.
+		p.resetSystemDNS()
 		ns := ctrld.InitializeOsResolver()
 		mainLog.Load().Debug().Msgf("initialized OS resolver with nameservers: %v", ns)
 		p.setDNS()
@@ -255,7 +306,7 @@ func (p *prog) postRun() {
 
 // apiConfigReload calls API to check for latest config update then reload ctrld if necessary.
 func (p *prog) apiConfigReload() {
-	if cdUID == "" {
+	if loadCdUID() == "" {
 		return
 	}
 
@@ -267,12 +318,22 @@ func (p *prog) apiConfigReload() {
 	lastUpdated := time.Now().Unix()
 
 	doReloadApiConfig := func(forced bool, logger zerolog.Logger) {
-		resolverConfig, err := controld.FetchResolverConfig(cdUID, rootCmd.Version, cdDev)
+		uid := loadCdUID()
+		resolverConfig, err := controld.FetchResolverConfig(uid, rootCmd.Version, cdDev)
 		selfUninstallCheck(err, p, logger)
 		if err != nil {
 			logger.Warn().Err(err).Msg("could not fetch resolver config")
 			return
 		}
+		saveCachedResolverConfig(uid, resolverConfig)
+		if cdConfigFromCache.Load() {
+			// The cached config is in use, reload to apply the config fetched from API.
+			logger.Notice().Msg("Control D API is reachable, reloading with fetched config")
+			if err := p.sendReloadSignal(); err != nil {
+				logger.Err(err).Msg("could not send reload signal")
+			}
+			return
+		}
 
 		if resolverConfig.DeactivationPin != nil {
 			newDeactivationPin := *resolverConfig.DeactivationPin
@@ -297,11 +358,15 @@ func (p *prog) apiConfigReload() {
 			cfg := &ctrld.Config{}
 			if err := validateCdRemoteConfig(resolverConfig, cfg); err != nil {
 				logger.Warn().Err(err).Msg("skipping invalid custom config")
-				if _, err := controld.UpdateCustomLastFailed(cdUID, rootCmd.Version, cdDev, true); err != nil {
+				p.alerts.configReloadFailed("skipping invalid custom config", err)
+				if _, err := controld.UpdateCustomLastFailed(loadCdUID(), rootCmd.Version, cdDev, true); err != nil {
 					logger.Error().Err(err).Msg("could not mark custom last update failed")
 				}
 				return
 			}
+			p.mu.Lock()
+			keepLocalOnlySettings(&cfg.Service, &p.cfg.Service)
+			p.mu.Unlock()
 			setListenerDefaultValue(cfg)
 			logger.Debug().Msg("custom config changes detected, reloading...")
 			p.apiReloadCh <- cfg
@@ -309,12 +374,31 @@ func (p *prog) apiConfigReload() {
 			logger.Debug().Msg("custom config does not change")
 		}
 	}
+	// If ctrld started with cached config, re-fetch more frequently until the API is reachable.
+	var offlineRetry <-chan time.Time
+	if cdConfigFromCache.Load() {
+		retryTicker := time.NewTicker(cdConfigCacheRetryInterval)
+		defer retryTicker.Stop()
+		offlineRetry = retryTicker.C
+	}
+	pushCh := make(chan struct{}, 1)
+	if p.cfg.Service.PushUpdates == nil || *p.cfg.Service.PushUpdates {
+		go p.watchAPIConfigChanges(pushCh)
+	}
 	for {
 		select {
 		case <-p.apiForceReloadCh:
 			doReloadApiConfig(true, logger.With().Bool("forced", true).Logger())
+		case <-pushCh:
+			doReloadApiConfig(false, logger.With().Bool("push", true).Logger())
 		case <-ticker.C:
 			doReloadApiConfig(false, logger)
+		case <-offlineRetry:
+			if !cdConfigFromCache.Load() {
+				offlineRetry = nil
+				continue
+			}
+			doReloadApiConfig(false, logger)
 		case <-p.stopCh:
 			return
 		}
@@ -324,7 +408,11 @@ func (p *prog) apiConfigReload() {
 func (p *prog) setupUpstream(cfg *ctrld.Config) {
 	localUpstreams := make([]string, 0, len(cfg.Upstream))
 	ptrNameservers := make([]string, 0, len(cfg.Upstream))
+	upstreams := make([]string, 0, len(cfg.Upstream))
 	isControlDUpstream := false
+	if p.tlsSessionCache == nil {
+		p.tlsSessionCache = ctrld.NewTLSSessionCache(tlsSessionCacheFile(cfg))
+	}
 	for n := range cfg.Upstream {
 		uc := cfg.Upstream[n]
 		sdns := uc.Type == ctrld.ResolverTypeSDNS
@@ -340,6 +428,13 @@ func (p *prog) setupUpstream(cfg *ctrld.Config) {
 			mainLog.Load().Info().Str("bootstrap_ip", uc.BootstrapIP).Msgf("using bootstrap IP for upstream.%s", n)
 		}
 		uc.SetCertPool(rootCertPool)
+		uc.SetTLSSessionCache(p.tlsSessionCache)
+		uc.SetDefaultTLSPolicy(cfg.Service.TLSMinVersion, cfg.Service.TLSCipherSuites)
+		upstream := upstreamPrefix + n
+		upstreams = append(upstreams, upstream)
+		uc.SetCertObserver(func(prev, cert *ctrld.UpstreamCert) {
+			p.upstreamCerts.observe(upstream, prev, cert, p.upstreamCertWarnThreshold(), p.alerts)
+		})
 		go uc.Ping()
 
 		if canBeLocalUpstream(uc.Domain) {
@@ -355,6 +450,7 @@ func (p *prog) setupUpstream(cfg *ctrld.Config) {
 	}
 	p.localUpstreams = localUpstreams
 	p.ptrNameservers = ptrNameservers
+	p.upstreamCerts.retain(upstreams)
 }
 
 // run runs the ctrld main components.
@@ -389,18 +485,24 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 	p.ptrLoopGuard = newLoopGuard()
 	p.cacheFlushDomainsMap = nil
 	p.metricsQueryStats.Store(p.cfg.Service.MetricsQueryStats)
+	p.debugEndpoints.Store(p.cfg.Service.DebugEndpoints)
 	if p.cfg.Service.CacheEnable {
-		cacher, err := dnscache.NewLRUCache(p.cfg.Service.CacheSize)
+		cacher, err := newCacher(&p.cfg.Service)
 		if err != nil {
 			mainLog.Load().Error().Err(err).Msg("failed to create cacher, caching is disabled")
 		} else {
 			p.cache = cacher
+			if cc := newClusterCache(cacher, &p.cfg.Service); cc != nil {
+				cc.run(p.stopCh, reloadCh)
+				p.cache = cc
+			}
 			p.cacheFlushDomainsMap = make(map[string]struct{}, 256)
 			for _, domain := range p.cfg.Service.CacheFlushDomains {
 				p.cacheFlushDomainsMap[canonicalName(domain)] = struct{}{}
 			}
 		}
 	}
+	p.servfailCache = newServfailCache(&p.cfg.Service)
 
 	var wg sync.WaitGroup
 	wg.Add(len(p.cfg.Listener))
@@ -417,16 +519,70 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 	}
 
 	p.um = newUpstreamMonitor(p.cfg)
+	pl := newPlugin(&p.cfg.Service, p.plugin)
+	if pl != nil && pl != p.plugin {
+		go pl.run(p.stopCh)
+	}
+	p.plugin = pl
+	if pl != nil {
+		p.um.onStateChange = append(p.um.onStateChange, pl.onUpstreamStateChange)
+	}
+	p.alerts = newAlerts(&p.cfg.Service, p.alerts)
+	if p.alerts != nil {
+		upstreams := make([]string, 0, len(p.cfg.Upstream))
+		for n := range p.cfg.Upstream {
+			upstreams = append(upstreams, upstreamPrefix+n)
+		}
+		slices.Sort(upstreams)
+		p.um.onStateChange = append(p.um.onStateChange, p.alerts.upstreamStateChanged(p.um, upstreams))
+	}
+	p.anomaly = newAnomalyDetector(p.cfg)
+	if p.anomaly != nil && p.alerts != nil {
+		a, notify := p.alerts, p.anomaly.notify
+		p.anomaly.notify = func(ev anomalyEvent) {
+			notify(ev)
+			a.anomaly(ev)
+		}
+	}
+	p.reverseZones = newReverseZones(&p.cfg.Service)
+	p.overlayDNS = newOverlayDNS(&p.cfg.Service)
+	p.overlayDNS.run(p.stopCh, reloadCh)
+	p.adZones = newADZones(&p.cfg.Service)
+	p.domainLists = newDomainLists(p.cfg.Lists)
+	p.localRecords = newLocalRecords(p.cfg.Records)
+	p.queryLog.close()
+	ql, err := newQueryLog(p.cfg)
+	if err != nil {
+		mainLog.Load().Error().Err(err).Msg("could not open query log")
+	}
+	p.queryLog = ql
+	go p.queryLog.run(p.stopCh)
+	p.sinkholeLog.close()
+	sl, err := newSinkholeLog(p.cfg)
+	if err != nil {
+		mainLog.Load().Error().Err(err).Msg("could not open sinkhole log")
+	}
+	p.sinkholeLog = sl
+	go p.sinkholeLog.run(p.stopCh)
+	p.threatFeeds = newThreatFeeds(p.cfg, p.threatFeeds)
+	p.threatFeeds.run(context.Background(), p.stopCh, reloadCh)
 
 	if !reload {
-		p.sema = &chanSemaphore{ready: make(chan struct{}, defaultSemaphoreCap)}
+		p.ul = newUpstreamLatency()
+		p.upstreamCerts = newUpstreamCerts()
+		p.dnsEvents = &dnsEventLog{}
+		maxQueued := defaultMaxQueuedRequests
+		if mqr := p.cfg.Service.MaxQueuedRequests; mqr != nil {
+			maxQueued = *mqr
+		}
+		workers := defaultWorkers
 		if mcr := p.cfg.Service.MaxConcurrentRequests; mcr != nil {
-			n := *mcr
-			if n == 0 {
-				p.sema = &noopSemaphore{}
-			} else {
-				p.sema = &chanSemaphore{ready: make(chan struct{}, n)}
-			}
+			workers = *mcr
+		}
+		if workers == 0 {
+			p.queryPool = &noopQueryPool{}
+		} else {
+			p.queryPool = newWorkerPool(workers, maxQueued)
 		}
 		p.setupUpstream(p.cfg)
 		p.ciTable = clientinfo.NewTable(&cfg, defaultRouteIP(), cdUID, p.ptrNameservers)
@@ -435,6 +591,10 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 			format := ctrld.LeaseFileFormat(p.cfg.Service.DHCPLeaseFileFormat)
 			p.ciTable.AddLeaseFile(leaseFile, format)
 		}
+		for _, slf := range p.cfg.Service.StaticLeaseFiles {
+			mainLog.Load().Debug().Msgf("watching static lease file: %s", slf.Path)
+			p.ciTable.AddLeaseFile(slf.Path, ctrld.LeaseFileFormat(slf.Format))
+		}
 	}
 
 	// context for managing spawn goroutines.
@@ -450,19 +610,26 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 			p.ciTable.RefreshLoop(ctx)
 		}()
 		go p.watchLinkState(ctx)
+		go p.watchRouterAdvertisements(ctx)
+	}
+	if !reload {
+		go p.watchUpstreamCerts(ctx)
 	}
 
 	for listenerNum := range p.cfg.Listener {
 		p.cfg.Listener[listenerNum].Init()
 		if !reload {
+			p.listenersWg.Add(1)
 			go func(listenerNum string) {
+				defer recoverCrash()
+				defer p.listenersWg.Done()
 				listenerConfig := p.cfg.Listener[listenerNum]
 				upstreamConfig := p.cfg.Upstream[listenerNum]
 				if upstreamConfig == nil {
 					mainLog.Load().Warn().Msgf("no default upstream for: [listener.%s]", listenerNum)
 				}
-				addr := net.JoinHostPort(listenerConfig.IP, strconv.Itoa(listenerConfig.Port))
-				mainLog.Load().Info().Msgf("starting DNS server on listener.%s: %s", listenerNum, addr)
+				addrs := strings.Join(listenerConfig.ListenAddrs(), ", ")
+				mainLog.Load().Info().Msgf("starting DNS server on listener.%s: %s", listenerNum, addrs)
 				if err := p.serveDNS(listenerNum); err != nil {
 					mainLog.Load().Fatal().Err(err).Msgf("unable to start dns proxy on listener.%s", listenerNum)
 				}
@@ -488,6 +655,9 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 		for _, f := range p.onStarted {
 			f()
 		}
+		go p.runHook(hookEventStart)
+	} else {
+		p.refreshFirewall()
 	}
 
 	close(p.onStartedDone)
@@ -508,6 +678,13 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 		p.runMetricsServer(ctx, reloadCh)
 	}()
 
+	wg.Add(1)
+	// OpenTelemetry traces exporter goroutine.
+	go func() {
+		defer wg.Done()
+		p.runTracing(ctx, reloadCh)
+	}()
+
 	if !reload {
 		// Stop writing log to unix socket.
 		consoleWriter.Out = os.Stdout
@@ -516,6 +693,19 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 			_ = p.logConn.Close()
 		}
 		go p.apiConfigReload()
+		if h := newHAPair(&p.cfg.Service); h != nil {
+			go p.haLoop(h)
+		}
+		if gs := newGitConfigSync(&p.cfg.Service, absHomeDir("")); gs != nil {
+			if loadCdUID() != "" {
+				mainLog.Load().Warn().Msg("config_git_repo is ignored in cd mode")
+			} else {
+				go p.gitConfigLoop(gs)
+			}
+		}
+		if len(p.cdSchedules) > 0 && loadCdUID() != "" {
+			go p.cdScheduleLoop(p.cdSchedules)
+		}
 		p.postRun()
 	}
 	wg.Wait()
@@ -523,18 +713,34 @@ func (p *prog) run(reload bool, reloadCh chan struct{}) {
 
 // metricsEnabled reports whether prometheus exporter is enabled/disabled.
 func (p *prog) metricsEnabled() bool {
-	return p.cfg.Service.MetricsQueryStats || p.cfg.Service.MetricsListener != ""
+	return p.cfg.Service.MetricsQueryStats || p.cfg.Service.MetricsListener != "" || p.cfg.Service.MetricsPushEndpoint != ""
 }
 
 func (p *prog) Stop(s service.Service) error {
+	// The authorization is consumed here, so a later stop requires the pin code again.
+	p.stoppedUnauthorized = p.unauthorizedStop()
+	p.stopAuthorizedAt.Store(0)
+	if p.stoppedUnauthorized {
+		mainLog.Load().Warn().Msg("service is being stopped without deactivation pin")
+	}
 	p.stopDnsWatchers()
 	mainLog.Load().Debug().Msg("dns watchers stopped")
-	mainLog.Load().Info().Msg("Service stopped")
 	close(p.stopCh)
+	p.waitListenersDrained()
+	mainLog.Load().Info().Msg("Service stopped")
+	if p.tlsSessionCache != nil {
+		if err := p.tlsSessionCache.Save(); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not save tls session cache")
+		}
+	}
 	if err := p.deAllocateIP(); err != nil {
 		mainLog.Load().Error().Err(err).Msg("de-allocate ip failed")
 		return err
 	}
+	// Reporting failure lets service managers which support it (Windows SCM) restart ctrld.
+	if p.stoppedUnauthorized && !service.Interactive() && runtime.GOOS == "windows" {
+		return errUnauthorizedStop
+	}
 	return nil
 }
 
@@ -563,13 +769,44 @@ func (p *prog) deAllocateIP() error {
 		return nil
 	}
 	for _, lc := range p.cfg.Listener {
-		if err := deAllocateIP(lc.IP); err != nil {
-			return err
+		for _, addr := range lc.ListenAddrs() {
+			ip, _, _ := net.SplitHostPort(addr)
+			if err := deAllocateIP(ip); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// listenerNameservers returns the sorted nameservers which system DNS is set to, for ctrld running on lc.
+func listenerNameservers(lc *ctrld.ListenerConfig) []string {
+	ns := lc.IP
+	switch {
+	case lc.IsDirectDnsListener():
+		// If ctrld is direct listener, use 127.0.0.1 as nameserver.
+		ns = "127.0.0.1"
+	case lc.Port != 53:
+		ns = "127.0.0.1"
+		if resolver := router.LocalResolverIP(); resolver != "" {
+			ns = resolver
+		}
+	default:
+		// If we ever reach here, it means ctrld is running on lc.IP port 53,
+		// so we could just use lc.IP as nameserver.
+	}
+
+	nameservers := []string{ns}
+	if needRFC1918Listeners(lc) {
+		nameservers = append(nameservers, ctrld.Rfc1918Addresses()...)
+	}
+	if needLocalIPv6Listener() {
+		nameservers = append(nameservers, "::1")
+	}
+	slices.Sort(nameservers)
+	return nameservers
+}
+
 func (p *prog) setDNS() {
 	setDnsOK := false
 	defer func() {
@@ -582,15 +819,23 @@ func (p *prog) setDNS() {
 	if iface == "" {
 		return
 	}
+	// Android does not use the OS DNS settings, DNS traffic is redirected to ctrld by router setup.
+	if router.IsAndroid() {
+		setDnsOK = true
+		return
+	}
 	runningIface := iface
 	// allIfaces tracks whether we should set DNS for all physical interfaces.
 	allIfaces := false
+	// skipIface tracks whether the default interface is excluded from DNS takeover.
+	skipIface := false
 	if runningIface == "auto" {
 		runningIface = defaultIfaceName()
 		// If runningIface is "auto", it means user does not specify "--iface" flag.
 		// In this case, ctrld has to set DNS for all physical interfaces, so
 		// thing will still work when user switch from one to the other.
 		allIfaces = requiredMultiNICsConfig()
+		skipIface = !ifaceAllowed(runningIface)
 	}
 	lc := cfg.FirstListener()
 	if lc == nil {
@@ -608,41 +853,28 @@ func (p *prog) setDNS() {
 	}
 
 	logger.Debug().Msg("setting DNS for interface")
-	ns := lc.IP
-	switch {
-	case lc.IsDirectDnsListener():
-		// If ctrld is direct listener, use 127.0.0.1 as nameserver.
-		ns = "127.0.0.1"
-	case lc.Port != 53:
-		ns = "127.0.0.1"
-		if resolver := router.LocalResolverIP(); resolver != "" {
-			ns = resolver
+	nameservers := listenerNameservers(lc)
+	if skipIface {
+		// Other interfaces may still be taken over, see below.
+		logger.Notice().Msg("interface is excluded, not setting DNS")
+		if !allIfaces {
+			return
 		}
-	default:
-		// If we ever reach here, it means ctrld is running on lc.IP port 53,
-		// so we could just use lc.IP as nameserver.
-	}
-
-	nameservers := []string{ns}
-	if needRFC1918Listeners(lc) {
-		nameservers = append(nameservers, ctrld.Rfc1918Addresses()...)
-	}
-	if needLocalIPv6Listener() {
-		nameservers = append(nameservers, "::1")
-	}
-	slices.Sort(nameservers)
-	if err := setDNS(netIface, nameservers); err != nil {
-		logger.Error().Err(err).Msgf("could not set DNS for interface")
-		return
+	} else {
+		if err := setDNS(netIface, nameservers); err != nil {
+			logger.Error().Err(err).Msgf("could not set DNS for interface")
+			return
+		}
+		logger.Debug().Msg("setting DNS successfully")
 	}
 	setDnsOK = true
-	logger.Debug().Msg("setting DNS successfully")
 	if allIfaces {
-		withEachPhysicalInterfaces(netIface.Name, "set DNS", func(i *net.Interface) error {
+		withEachManagedInterfaces(netIface.Name, "set DNS", func(i *net.Interface) error {
 			return setDnsIgnoreUnusableInterface(i, nameservers)
 		})
 	}
-	if shouldWatchResolvconf() {
+	go p.runHook(hookEventDNSApplied, "CTRLD_IFACE="+runningIface, "CTRLD_NAMESERVERS="+strings.Join(nameservers, ","))
+	if shouldWatchResolvconf() && !skipIface {
 		servers := make([]netip.Addr, len(nameservers))
 		for i := range nameservers {
 			servers[i] = netip.MustParseAddr(nameservers[i])
@@ -657,7 +889,7 @@ func (p *prog) setDNS() {
 		p.dnsWg.Add(1)
 		go func() {
 			defer p.dnsWg.Done()
-			p.dnsWatchdog(netIface, nameservers, allIfaces)
+			p.dnsWatchdog(netIface, nameservers, skipIface, allIfaces)
 		}()
 	}
 }
@@ -680,9 +912,83 @@ func (p *prog) dnsWatchdogDuration() time.Duration {
 	return dnsWatchdogDefaultInterval
 }
 
-// dnsWatchdog watches for DNS changes on Darwin and Windows then re-applying ctrld's settings.
-// This is only works when deactivation pin set.
-func (p *prog) dnsWatchdog(iface *net.Interface, nameservers []string, allIfaces bool) {
+// newCacher returns the DNS cache using the configured cache backend.
+func newCacher(cfg *ctrld.ServiceConfig) (dnscache.Cacher, error) {
+	if cfg.CacheBackend == ctrld.CacheBackendRedis {
+		rc, err := dnscache.NewRedisCache(cfg.CacheRedisURL, cfg.CacheSize, cacheMaxMemory(cfg), cfg.CacheEviction)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		// Redis may come up later, the cache works once it's reachable, queries are resolved using upstreams meanwhile.
+		if err := rc.Ping(ctx); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("redis cache backend is not reachable")
+		}
+		return rc, nil
+	}
+	return dnscache.NewLRUCache(cfg.CacheSize, cacheMaxMemory(cfg), cfg.CacheEviction)
+}
+
+// cacheMaxMemory returns the memory limit of cached answers in bytes, or zero if there's no limit.
+func cacheMaxMemory(cfg *ctrld.ServiceConfig) int64 {
+	if cfg.CacheMaxMemory == "" {
+		return 0
+	}
+	n, _ := ctrld.ParseByteSize(cfg.CacheMaxMemory)
+	return n
+}
+
+// cacheEviction returns the eviction policy of the DNS cache.
+func cacheEviction(cfg *ctrld.ServiceConfig) string {
+	if cfg.CacheEviction == "" {
+		return dnscache.EvictionLRU
+	}
+	return cfg.CacheEviction
+}
+
+// tlsSessionCacheFile returns the path of the file where TLS sessions are persisted.
+func tlsSessionCacheFile(cfg *ctrld.Config) string {
+	if path := cfg.Service.TLSSessionCacheFile; path != "" {
+		return path
+	}
+	// Mobile apps keep their connections alive, so in-memory cache is enough.
+	if isMobile() {
+		return ""
+	}
+	return absHomeDir(tlsSessionCacheFileName)
+}
+
+// shutdownDrainTimeout returns the maximum time duration waiting for in-flight queries
+// to be finished when shutting down DNS listeners.
+func (p *prog) shutdownDrainTimeout() time.Duration {
+	if ptr := p.cfg.Service.ShutdownDrainTimeout; ptr != nil {
+		if (*ptr).Seconds() > 0 {
+			return *ptr
+		}
+	}
+	return shutdownDrainDefaultTimeout
+}
+
+// upstreamLatencyWarnThreshold returns the p95 latency above which an upstream is considered degraded.
+// A zero value means degradation warning is disabled.
+func (p *prog) upstreamLatencyWarnThreshold() time.Duration {
+	if ptr := p.cfg.Service.UpstreamLatencyWarnThreshold; ptr != nil && *ptr > 0 {
+		return *ptr
+	}
+	return 0
+}
+
+// waitListenersDrained waits for all DNS listeners to be shutdown.
+func (p *prog) waitListenersDrained() {
+	p.listenersWg.Wait()
+	mainLog.Load().Debug().Msg("dns listeners drained")
+}
+
+// dnsWatchdog watches for DNS changes on Darwin and Windows then re-applying ctrld's settings,
+// after the configured grace period. If skipIface is true, DNS settings of iface itself are not
+// watched, because it's excluded from DNS takeover.
+func (p *prog) dnsWatchdog(iface *net.Interface, nameservers []string, skipIface, allIfaces bool) {
 	if !requiredMultiNICsConfig() {
 		return
 	}
@@ -691,7 +997,8 @@ func (p *prog) dnsWatchdog(iface *net.Interface, nameservers []string, allIfaces
 	ns := nameservers
 	slices.Sort(ns)
 	ticker := time.NewTicker(p.dnsWatchdogDuration())
-	logger := mainLog.Load().With().Str("iface", iface.Name).Logger()
+	grace := p.dnsWatchdogGracePeriod()
+	changedAt := make(map[string]time.Time)
 	for {
 		select {
 		case <-p.dnsWatcherStopCh:
@@ -703,21 +1010,12 @@ func (p *prog) dnsWatchdog(iface *net.Interface, nameservers []string, allIfaces
 			if p.leakingQuery.Load() {
 				return
 			}
-			if dnsChanged(iface, ns) {
-				logger.Debug().Msg("DNS settings were changed, re-applying settings")
-				if err := setDNS(iface, ns); err != nil {
-					mainLog.Load().Error().Err(err).Str("iface", iface.Name).Msgf("could not re-apply DNS settings")
-				}
+			if !skipIface {
+				p.reassertDNS(iface, ns, grace, changedAt, setDNS)
 			}
 			if allIfaces {
-				withEachPhysicalInterfaces(iface.Name, "", func(i *net.Interface) error {
-					if dnsChanged(i, ns) {
-						if err := setDnsIgnoreUnusableInterface(i, nameservers); err != nil {
-							mainLog.Load().Error().Err(err).Str("iface", i.Name).Msgf("could not re-apply DNS settings")
-						} else {
-							mainLog.Load().Debug().Msgf("re-applying DNS for interface %q successfully", i.Name)
-						}
-					}
+				withEachManagedInterfaces(iface.Name, "", func(i *net.Interface) error {
+					p.reassertDNS(i, ns, grace, changedAt, setDnsIgnoreUnusableInterface)
 					return nil
 				})
 			}
@@ -726,39 +1024,60 @@ func (p *prog) dnsWatchdog(iface *net.Interface, nameservers []string, allIfaces
 }
 
 func (p *prog) resetDNS() {
-	if iface == "" {
-		return
+	if runningIface, ok := p.resetSystemDNS(); ok {
+		p.runHook(hookEventDNSRestored, "CTRLD_IFACE="+runningIface)
+	}
+}
+
+// resetSystemDNS restores system DNS settings, reporting the interface and whether DNS was restored.
+func (p *prog) resetSystemDNS() (string, bool) {
+	if iface == "" || router.IsAndroid() {
+		return "", false
 	}
 	runningIface := iface
 	allIfaces := false
+	skipIface := false
 	if runningIface == "auto" {
 		runningIface = defaultIfaceName()
 		// See corresponding comments in (*prog).setDNS function.
 		allIfaces = requiredMultiNICsConfig()
+		skipIface = !ifaceAllowed(runningIface)
 	}
 	logger := mainLog.Load().With().Str("iface", runningIface).Logger()
 	netIface, err := netInterface(runningIface)
 	if err != nil {
 		logger.Error().Err(err).Msg("could not get interface")
-		return
+		return "", false
 	}
 	if err := restoreNetworkManager(); err != nil {
 		logger.Error().Err(err).Msg("could not restore NetworkManager")
-		return
+		return "", false
 	}
-	logger.Debug().Msg("Restoring DNS for interface")
-	if err := resetDNS(netIface); err != nil {
-		logger.Error().Err(err).Msgf("could not reset DNS")
-		return
+	if skipIface {
+		logger.Debug().Msg("interface is excluded, not restoring DNS")
+		if !allIfaces {
+			return "", false
+		}
+	} else {
+		logger.Debug().Msg("Restoring DNS for interface")
+		if err := resetDNS(netIface); err != nil {
+			logger.Error().Err(err).Msgf("could not reset DNS")
+			return "", false
+		}
+		logger.Debug().Msg("Restoring DNS successfully")
 	}
-	logger.Debug().Msg("Restoring DNS successfully")
 	if allIfaces {
-		withEachPhysicalInterfaces(netIface.Name, "reset DNS", resetDnsIgnoreUnusableInterface)
+		withEachManagedInterfaces(netIface.Name, "reset DNS", resetDnsIgnoreUnusableInterface)
 	}
+	return runningIface, true
 }
 
 // leakOnUpstreamFailure reports whether ctrld should leak query to OS resolver when failed to connect all upstreams.
 func (p *prog) leakOnUpstreamFailure() bool {
+	// Never leaking queries to OS resolver in fail-closed mode.
+	if p.cfg.Service.FailClosed {
+		return false
+	}
 	if ptr := p.cfg.Service.LeakOnUpstreamFailure; ptr != nil {
 		return *ptr
 	}
@@ -975,6 +1294,47 @@ func withEachPhysicalInterfaces(excludeIfaceName, context string, f func(i *net.
 	})
 }
 
+// defaultIfaceExclude contains patterns of virtual interfaces which ctrld never takes over DNS by default.
+var defaultIfaceExclude = []string{
+	"docker*", "br-*", "veth*", "virbr*", "vethernet*", "vmnet*", "vboxnet*", "tailscale*", "zt*", "wg*", "wt*",
+}
+
+// ifaceAllowed reports whether ctrld could take over DNS of interface with given name, following the
+// iface_include and iface_exclude patterns. Patterns are matched case-insensitively.
+func ifaceAllowed(name string) bool {
+	if include := cfg.Service.IfaceInclude; len(include) > 0 && !ifaceMatches(include, name) {
+		return false
+	}
+	exclude := cfg.Service.IfaceExclude
+	if exclude == nil {
+		exclude = defaultIfaceExclude
+	}
+	return !ifaceMatches(exclude, name)
+}
+
+// ifaceMatches reports whether interface name matches any of patterns, case-insensitively.
+func ifaceMatches(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// withEachManagedInterfaces is like withEachPhysicalInterfaces, but only runs f with interfaces
+// which ctrld could take over DNS, see ifaceAllowed.
+func withEachManagedInterfaces(excludeIfaceName, context string, f func(i *net.Interface) error) {
+	withEachPhysicalInterfaces(excludeIfaceName, context, func(i *net.Interface) error {
+		if !ifaceAllowed(i.Name) {
+			mainLog.Load().Debug().Msgf("skipping excluded interface %q", i.Name)
+			return nil
+		}
+		return f(i)
+	})
+}
+
 // requiredMultiNicConfig reports whether ctrld needs to set/reset DNS for multiple NICs.
 func requiredMultiNICsConfig() bool {
 	switch runtime.GOOS {
@@ -1,11 +1,14 @@
 package cli
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/Control-D-Inc/ctrld"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_prog_dnsWatchdogEnabled(t *testing.T) {
@@ -55,3 +58,100 @@ func Test_prog_dnsWatchdogInterval(t *testing.T) {
 		})
 	}
 }
+
+func Test_prog_shutdownDrainTimeout(t *testing.T) {
+	p := &prog{cfg: &ctrld.Config{}}
+
+	// Default value is 5s.
+	assert.Equal(t, shutdownDrainDefaultTimeout, p.shutdownDrainTimeout())
+
+	tests := []struct {
+		name     string
+		duration time.Duration
+		expected time.Duration
+	}{
+		{"valid", 10 * time.Second, 10 * time.Second},
+		{"zero", 0, shutdownDrainDefaultTimeout},
+		{"nagative", time.Duration(-1 * time.Second), shutdownDrainDefaultTimeout},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			p.cfg.Service.ShutdownDrainTimeout = &tc.duration
+			assert.Equal(t, tc.expected, p.shutdownDrainTimeout())
+		})
+	}
+}
+
+func Test_prog_deactivationPin(t *testing.T) {
+	p := &prog{cfg: &ctrld.Config{}}
+	t.Cleanup(func() { cdDeactivationPin.Store(defaultDeactivationPin) })
+
+	// No pin code, stopping is always authorized.
+	assert.Equal(t, int64(defaultDeactivationPin), p.deactivationPin())
+	assert.False(t, p.unauthorizedStop())
+
+	pin := int64(1234)
+	p.cfg.Service.DeactivationPin = &pin
+	assert.Equal(t, pin, p.deactivationPin())
+	assert.True(t, p.unauthorizedStop())
+
+	// Pin code from Control D API takes precedence.
+	cdDeactivationPin.Store(5678)
+	assert.Equal(t, int64(5678), p.deactivationPin())
+
+	p.authorizeStop()
+	assert.False(t, p.unauthorizedStop())
+
+	// Authorization expires.
+	p.stopAuthorizedAt.Store(time.Now().Add(-2 * stopAuthorizationTTL).UnixNano())
+	assert.True(t, p.unauthorizedStop())
+}
+
+func Test_ifaceAllowed(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		iface   string
+		allowed bool
+	}{
+		{"default", nil, nil, "eth0", true},
+		{"default excluded docker", nil, nil, "docker0", false},
+		{"default excluded hyper-v", nil, nil, "vEthernet (Default Switch)", false},
+		{"default excluded tailscale", nil, nil, "tailscale0", false},
+		{"empty exclude", nil, []string{}, "docker0", true},
+		{"included", []string{"eth*", "wlan*"}, nil, "wlan0", true},
+		{"not included", []string{"eth*", "wlan*"}, nil, "enp0s3", false},
+		{"included but excluded", []string{"eth*"}, []string{"eth1"}, "eth1", false},
+		{"case insensitive", []string{"wi-fi"}, nil, "Wi-Fi", true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg = ctrld.Config{}
+			cfg.Service.IfaceInclude = tc.include
+			cfg.Service.IfaceExclude = tc.exclude
+			assert.Equal(t, tc.allowed, ifaceAllowed(tc.iface))
+		})
+	}
+}
+
+func Test_readNewConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "ctrld.toml")
+	content := "[upstream.0]\n  type = \"doh\"\n  endpoint = \"https://dns.example.com/dns-query\"\n"
+	require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+
+	cfg, err := readNewConfig(configFile)
+	require.NoError(t, err)
+	require.Contains(t, cfg.Upstream, "0")
+	assert.Equal(t, "https://dns.example.com/dns-query", cfg.Upstream["0"].Endpoint)
+
+	_, err = readNewConfig(filepath.Join(t.TempDir(), "missing.toml"))
+	assert.Error(t, err)
+}
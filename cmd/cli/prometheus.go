@@ -49,6 +49,72 @@ var statsClientQueriesCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Help: "Total number queries of a client.",
 }, []string{metricsLabelClientSourceIP, metricsLabelClientMac, metricsLabelClientHostname})
 
+// statsQueriesDropped counts total number of queries dropped because of too many queued queries.
+var statsQueriesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ctrld_queries_dropped_count",
+	Help: "Total number of queries dropped because of too many queued queries.",
+})
+
+// statsQueriesRefused counts total number of queries refused because of their query type.
+var statsQueriesRefused = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ctrld_queries_refused_count",
+	Help: "Total number of queries refused because of their query type.",
+}, []string{"qtype"})
+
+// statsResponsesRateLimited counts total number of responses dropped or truncated by response rate limiting.
+var statsResponsesRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ctrld_responses_rate_limited_count",
+	Help: "Total number of responses dropped or truncated by response rate limiting.",
+}, []string{"action"})
+
+// statsUpstreamLatency tracks latency of queries sent to upstreams.
+var statsUpstreamLatency = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	Name:       "ctrld_upstream_latency_seconds",
+	Help:       "Latency of queries sent to upstreams.",
+	Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001},
+}, []string{metricsLabelUpstream})
+
+// statsAnomalyClientsFlagged counts total number of clients flagged by anomaly detection.
+var statsAnomalyClientsFlagged = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ctrld_anomaly_clients_flagged_count",
+	Help: "Total number of clients flagged by anomaly detection.",
+}, []string{"reason"})
+
+// statsSecurityBlocked counts total number of queries blocked by threat feeds.
+var statsSecurityBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ctrld_security_blocked_count",
+	Help: "Total number of queries blocked by threat feeds.",
+}, []string{"feed", metricsLabelClientSourceIP})
+
+// statsServfailSuppressed counts total number of queries answered from cached upstream failures.
+var statsServfailSuppressed = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ctrld_servfail_suppressed_count",
+	Help: "Total number of queries answered from cached upstream failures, instead of being sent to upstreams.",
+})
+
+// statsCacheLookups counts total number of cache lookups, by eviction policy and result: "hit", "stale" or "miss".
+// The hit rate of eviction policies can be compared using hit / (hit + stale + miss).
+var statsCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ctrld_cache_lookups_count",
+	Help: "Total number of cache lookups, by eviction policy and result.",
+}, []string{"eviction", "result"})
+
+// newStatsQueriesInflight returns a gauge reporting the number of queries being handled.
+func newStatsQueriesInflight(pool queryPool) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ctrld_queries_inflight",
+		Help: "Number of queries being handled.",
+	}, func() float64 { return float64(pool.inflight()) })
+}
+
+// newStatsQueriesQueued returns a gauge reporting the number of queries waiting to be handled.
+func newStatsQueriesQueued(pool queryPool) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ctrld_queries_queued",
+		Help: "Number of queries waiting to be handled.",
+	}, func() float64 { return float64(pool.queued()) })
+}
+
 // WithLabelValuesInc increases prometheus counter by 1 if query stats is enabled.
 func (p *prog) WithLabelValuesInc(c *prometheus.CounterVec, lvs ...string) {
 	if p.metricsQueryStats.Load() {
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strings"
+)
+
+const (
+	provisionOrgFlagName              = "org"
+	provisionHostnameTemplateFlagName = "hostname-template"
+)
+
+// expandHostnameTemplate returns the hostname generated from tmpl, with these placeholders replaced:
+//
+// - {hostname}: the OS hostname, without domain part.
+// - {mac}: the MAC address of default interface, without separators.
+// - {os}: the OS name.
+// - {arch}: the CPU architecture.
+//
+// Characters which are not valid in hostname are replaced with "-".
+func expandHostnameTemplate(tmpl string) string {
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+	mac := ""
+	if netIface, err := net.InterfaceByName(defaultIfaceName()); err == nil {
+		mac = strings.ReplaceAll(netIface.HardwareAddr.String(), ":", "")
+	}
+	r := strings.NewReplacer(
+		"{hostname}", hostname,
+		"{mac}", mac,
+		"{os}", runtime.GOOS,
+		"{arch}", runtime.GOARCH,
+	)
+	return sanitizeHostname(r.Replace(tmpl))
+}
+
+// sanitizeHostname lower cases s, replaces invalid hostname characters with "-",
+// then trims leading and trailing "-".
+func sanitizeHostname(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, s)
+	return strings.Trim(s, "-.")
+}
+
+// removeFlagsFromArgs returns args without the given flags and their values,
+// in both "--flag value" and "--flag=value" forms.
+func removeFlagsFromArgs(args []string, flags ...string) []string {
+	a := make([]string, 0, len(args))
+	skip := false
+	for _, x := range args {
+		if skip {
+			skip = false
+			continue
+		}
+		matched := false
+		for _, flag := range flags {
+			// For "--flag XXX", skip it and mark next arg skipped.
+			if x == "--"+flag {
+				skip = true
+				matched = true
+				break
+			}
+			// For "--flag=XXX", just skip it.
+			if strings.HasPrefix(x, "--"+flag+"=") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			a = append(a, x)
+		}
+	}
+	return a
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_sanitizeHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"valid", "foo-bar", "foo-bar"},
+		{"upper case", "Foo-Bar", "foo-bar"},
+		{"invalid chars", "foo_bar baz", "foo-bar-baz"},
+		{"leading and trailing", "-foo.", "foo"},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, sanitizeHostname(tc.input))
+		})
+	}
+}
+
+func Test_expandHostnameTemplate(t *testing.T) {
+	assert.Equal(t, "ctrld-"+runtime.GOOS+"-"+runtime.GOARCH, expandHostnameTemplate("ctrld-{os}-{arch}"))
+}
+
+func Test_removeFlagsFromArgs(t *testing.T) {
+	args := []string{"--org", "token", "--iface=auto", "--hostname-template={hostname}", "-vv"}
+	assert.Equal(t, []string{"--iface=auto", "-vv"}, removeFlagsFromArgs(args, "org", "hostname-template"))
+}
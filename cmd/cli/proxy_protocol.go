@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// proxyProtocolV1MaxLen is the maximum length of PROXY protocol v1 header, including CRLF.
+const proxyProtocolV1MaxLen = 107
+
+// proxyProtocolV2Sig is the signature of PROXY protocol v2 header.
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolListener is a net.Listener accepting connections which start with
+// a PROXY protocol v1/v2 header, as sent by HAProxy and other TCP load balancers.
+// The connections report the client address carried in the header as their remote address.
+type proxyProtocolListener struct {
+	net.Listener
+	// trusted is the list of proxies whose connections start with PROXY header.
+	// If empty, all connections are required to start with PROXY header.
+	trusted []netip.Prefix
+}
+
+// newProxyProtocolListener returns new proxyProtocolListener, trusting connections from given IPs or CIDRs.
+func newProxyProtocolListener(l net.Listener, trusted []string) (*proxyProtocolListener, error) {
+	pl := &proxyProtocolListener{Listener: l}
+	for _, s := range trusted {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %q", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		pl.trusted = append(pl.trusted, prefix.Masked())
+	}
+	return pl, nil
+}
+
+// Accept implements net.Listener. The PROXY header is read lazily, on first read
+// from the connection, so a slow client could not block accepting other connections.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &proxyProtocolConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// isTrusted reports whether the connections from addr start with PROXY header.
+func (l *proxyProtocolListener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(ta.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtocolConn is a net.Conn starting with PROXY header.
+type proxyProtocolConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// readHeader reads the PROXY header once. The connection is closed if the header is invalid.
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.remote, c.err = readProxyProtocolHeader(c.r)
+		if c.err != nil {
+			mainLog.Load().Debug().Err(c.err).Msgf("invalid PROXY protocol header from: %s", c.Conn.RemoteAddr())
+			c.Conn.Close()
+		}
+	})
+}
+
+// Read implements net.Conn.
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address carried in the PROXY header, or the address
+// of the proxy itself, if the header does not carry any, for example, health checks.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtocolHeader reads PROXY protocol v1/v2 header from r, returning the source address.
+// A nil address is returned if the header does not carry the source address.
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyProtocolV2Sig))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(sig, proxyProtocolV2Sig):
+		return readProxyProtocolV2Header(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		return readProxyProtocolV1Header(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// readProxyProtocolV1Header reads the human-readable header, e.g: "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func readProxyProtocolV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY protocol v1 header is too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", line)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v1 protocol: %s", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 source address: %s %s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2Header reads the binary header.
+func readProxyProtocolV2Header(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, len(proxyProtocolV2Sig)+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version: %d", verCmd>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch verCmd & 0xf {
+	case 0x0:
+		// LOCAL command, the connection was established by the proxy itself.
+		return nil, nil
+	case 0x1:
+		// PROXY command.
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 command: %d", verCmd&0xf)
+	}
+	switch fam >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errors.New("short PROXY protocol v2 ipv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("short PROXY protocol v2 ipv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// AF_UNSPEC or AF_UNIX, keep the proxy address.
+	return nil, nil
+}
+
+// runProxyProtocolDNSServer is like runDNSServer, but for TCP listener accepting PROXY protocol headers.
+func runProxyProtocolDNSServer(addr string, handler dns.Handler, trusted []string) (*dns.Server, <-chan error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return failedDNSServer(addr, "tcp", err)
+	}
+	pl, err := newProxyProtocolListener(l, trusted)
+	if err != nil {
+		l.Close()
+		return failedDNSServer(addr, "tcp", err)
+	}
+	return activateDNSServer(&dns.Server{
+		Addr:     addr,
+		Net:      "tcp",
+		Listener: pl,
+		Handler:  handler,
+	})
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyProtocolV2Header(cmd, fam byte, addrs []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyProtocolV2Sig)
+	buf.WriteByte(0x20 | cmd)
+	buf.WriteByte(fam)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+func Test_readProxyProtocolHeader(t *testing.T) {
+	v4 := append(append(net.ParseIP("192.168.1.10").To4(), net.ParseIP("10.0.0.1").To4()...), 0xdb, 0xa0, 0x00, 0x35)
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x04, 0xd2, 0x00, 0x35)
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.1.10 10.0.0.1 56224 53\r\n"), "192.168.1.10:56224", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 53\r\n"), "[2001:db8::1]:1234", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 invalid ip", []byte("PROXY TCP4 foo 10.0.0.1 56224 53\r\n"), "", true},
+		{"v1 no crlf", []byte("PROXY TCP4 192.168.1.10 10.0.0.1 56224 53" + strings.Repeat(" ", 100)), "", true},
+		{"v2 ipv4", proxyProtocolV2Header(0x1, 0x11, v4), "192.168.1.10:56224", false},
+		{"v2 ipv6", proxyProtocolV2Header(0x1, 0x21, v6), "[2001:db8::1]:1234", false},
+		{"v2 local", proxyProtocolV2Header(0x0, 0x00, nil), "", false},
+		{"v2 short addresses", proxyProtocolV2Header(0x1, 0x11, v4[:8]), "", true},
+		{"missing header", []byte("\x00\x1d\xab\xcd\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00"), "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(append(tc.header, "payload"...)))
+			addr, err := readProxyProtocolHeader(r)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tc.want == "" {
+				assert.Nil(t, addr)
+			} else {
+				assert.Equal(t, tc.want, addr.String())
+			}
+			rest, _ := r.ReadString(0)
+			assert.Equal(t, "payload", rest)
+		})
+	}
+}
+
+func Test_proxyProtocolListener_isTrusted(t *testing.T) {
+	l, err := newProxyProtocolListener(nil, []string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+	assert.True(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	assert.True(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("::ffff:192.168.1.1")}))
+	assert.False(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}))
+
+	l, err = newProxyProtocolListener(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, l.isTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}))
+}
+
+func Test_runProxyProtocolDNSServer(t *testing.T) {
+	gotRemote := make(chan string, 1)
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		gotRemote <- w.RemoteAddr().String()
+		answer := new(dns.Msg)
+		answer.SetReply(m)
+		_ = w.WriteMsg(answer)
+	})
+	s, errCh := runProxyProtocolDNSServer("127.0.0.1:0", handler, nil)
+	defer s.Shutdown()
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 192.168.1.10 127.0.0.1 56224 53\r\n"))
+	require.NoError(t, err)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	dc := &dns.Conn{Conn: conn}
+	require.NoError(t, dc.WriteMsg(m))
+	_, err = dc.ReadMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.10:56224", <-gotRemote)
+}
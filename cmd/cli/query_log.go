@@ -0,0 +1,442 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// queryLogDefaultMaxAge is the default age after which query log entries are removed.
+	queryLogDefaultMaxAge = 30 * 24 * time.Hour
+	// queryLogDefaultMaxSize is the default maximum size of the query log, in megabytes.
+	queryLogDefaultMaxSize = 50
+	// queryLogQueueSize is the maximum number of entries waiting to be written.
+	queryLogQueueSize = 4096
+	// queryLogBatchSize is the maximum number of entries written at once.
+	queryLogBatchSize = 256
+	// queryLogFlushInterval is the interval for writing pending entries.
+	queryLogFlushInterval = time.Second
+	// queryLogPruneInterval is the interval for applying retention policy.
+	queryLogPruneInterval = time.Hour
+	// queryLogSaltFileName is the name of file storing the salt for hashing client IPs.
+	queryLogSaltFileName = "query_log.salt"
+	// queryLogDefaultSearchLimit is the default maximum number of entries returned by search.
+	queryLogDefaultSearchLimit = 100
+
+	queryLogBackendFile   = "file"
+	queryLogBackendSQLite = "sqlite"
+
+	queryLogAnonymizeHash     = "hash"
+	queryLogAnonymizeTruncate = "truncate"
+)
+
+// queryLogEntry is a record of a DNS query answered by ctrld.
+type queryLogEntry struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	Mac      string    `json:"mac,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	Domain   string    `json:"domain"`
+	Qtype    string    `json:"qtype"`
+	Rcode    string    `json:"rcode"`
+	Upstream string    `json:"upstream,omitempty"`
+	Blocked  string    `json:"blocked,omitempty"`
+	Duration float64   `json:"duration_ms"`
+	// Rule is the policy rule blocking the query, if any.
+	Rule string `json:"rule,omitempty"`
+	// List is the domain list or threat feed which the blocked domain is found in, if any.
+	List string `json:"list,omitempty"`
+}
+
+// queryLogRetention is the retention policy of the query log.
+type queryLogRetention struct {
+	maxAge  time.Duration
+	maxSize int64
+}
+
+// queryLogBackend is the storage of query log entries.
+type queryLogBackend interface {
+	// write stores the entries.
+	write(entries []queryLogEntry) error
+	// prune removes entries violating the retention policy.
+	prune(r queryLogRetention) error
+	// close releases resources used by the backend.
+	close() error
+}
+
+// queryLogSearcher is implemented by backends supporting searching query log entries.
+type queryLogSearcher interface {
+	search(f queryLogFilter) ([]queryLogEntry, error)
+}
+
+// queryLogReader is implemented by backends supporting reading stored query log entries in order.
+type queryLogReader interface {
+	// read calls fn for entries between since and until, oldest first. Zero times are not bounded.
+	read(since, until time.Time, fn func(queryLogEntry) error) error
+}
+
+// errQueryLogReadUnsupported is returned when reading a query log which is not stored locally.
+var errQueryLogReadUnsupported = errors.New("query log export requires query_log_path")
+
+// errQueryLogSearchUnsupported is returned when searching a query log which does not support it.
+var errQueryLogSearchUnsupported = errors.New("query log search requires sqlite query log backend")
+
+// queryLogFilter specifies which query log entries are searched for.
+type queryLogFilter struct {
+	// Client matches client IP, MAC or hostname.
+	Client string `json:"client,omitempty"`
+	// Domain is a glob pattern, e.g: "*.example.com".
+	Domain string    `json:"domain,omitempty"`
+	Rcode  string    `json:"rcode,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+	Until  time.Time `json:"until,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+// parseQueryLogTime parses s as a time of query log search, which is either a duration before now,
+// e.g: "1h", or a local time, e.g: "2024-01-01 10:00", or a RFC 3339 time.
+func parseQueryLogTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be a duration, a local time formed %q, or a RFC 3339 time", s, time.DateTime)
+	}
+	return t, nil
+}
+
+// limit returns the maximum number of entries returned for the filter.
+func (f queryLogFilter) limit() int {
+	if f.Limit > 0 {
+		return f.Limit
+	}
+	return queryLogDefaultSearchLimit
+}
+
+// queryLog records DNS queries to backends, applying privacy settings before
+// they are stored, and the retention policy periodically.
+type queryLog struct {
+	backends    []queryLogBackend
+	retention   queryLogRetention
+	anonymizeIP string
+	salt        []byte
+	domainDepth int
+
+	ch        chan queryLogEntry
+	stopCh    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newQueryLog returns the query log for given config, or nil if query log is disabled.
+func newQueryLog(cfg *ctrld.Config) (*queryLog, error) {
+	sc := cfg.Service
+	if sc.QueryLogPath == "" && sc.QueryLogExportURL == "" {
+		return nil, nil
+	}
+	var backends []queryLogBackend
+	if sc.QueryLogPath != "" {
+		backend, err := openQueryLogBackend(sc.QueryLogPath, sc.QueryLogBackend)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	if sc.QueryLogExportURL != "" {
+		exporter, err := newQueryLogExporter(cfg)
+		if err != nil {
+			for _, b := range backends {
+				b.close()
+			}
+			return nil, err
+		}
+		backends = append(backends, exporter)
+	}
+	return newQueryLogWithBackends(&sc, backends), nil
+}
+
+// newSinkholeLog returns the log of blocked queries for given config, or nil if it is disabled.
+// The sinkhole log is independent of the query log, but shares its retention and privacy settings.
+func newSinkholeLog(cfg *ctrld.Config) (*queryLog, error) {
+	sc := cfg.Service
+	if sc.SinkholeLogPath == "" {
+		return nil, nil
+	}
+	backend, err := openQueryLogBackend(sc.SinkholeLogPath, sc.SinkholeLogBackend)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryLogWithBackends(&sc, []queryLogBackend{backend}), nil
+}
+
+// openQueryLogBackend opens the storage at path, using the given backend type.
+func openQueryLogBackend(path, backend string) (queryLogBackend, error) {
+	path = normalizeLogFilePath(path)
+	switch backend {
+	case queryLogBackendSQLite:
+		return newQueryLogSQLite(path)
+	default:
+		return newQueryLogFile(path)
+	}
+}
+
+// newQueryLogWithBackends returns the query log writing to backends, using the retention and privacy settings of sc.
+func newQueryLogWithBackends(sc *ctrld.ServiceConfig, backends []queryLogBackend) *queryLog {
+	ql := &queryLog{
+		backends:    backends,
+		retention:   queryLogRetention{maxAge: queryLogDefaultMaxAge, maxSize: queryLogDefaultMaxSize << 20},
+		anonymizeIP: sc.QueryLogAnonymizeIP,
+		ch:          make(chan queryLogEntry, queryLogQueueSize),
+		stopCh:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if d := sc.QueryLogMaxAge; d != nil {
+		ql.retention.maxAge = *d
+	}
+	if n := sc.QueryLogMaxSize; n != nil {
+		ql.retention.maxSize = int64(*n) << 20
+	}
+	if n := sc.QueryLogDomainDepth; n != nil {
+		ql.domainDepth = *n
+	}
+	if ql.anonymizeIP == queryLogAnonymizeHash {
+		ql.salt = queryLogSalt(absHomeDir(queryLogSaltFileName))
+	}
+	return ql
+}
+
+// queryLogSalt returns the salt stored in file, generating a new one if there's none, so hashed
+// client IPs are stable across restarts, but can't be reversed without access to the salt.
+func queryLogSalt(file string) []byte {
+	if data, err := os.ReadFile(file); err == nil {
+		if salt, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(salt) > 0 {
+			return salt
+		}
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not generate query log salt")
+	}
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(salt)), 0600); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not save query log salt, hashed client IPs will change after restarting")
+	}
+	return salt
+}
+
+// search returns the most recent entries matching the filter, newest first.
+func (ql *queryLog) search(f queryLogFilter) ([]queryLogEntry, error) {
+	if ql == nil {
+		return nil, errQueryLogSearchUnsupported
+	}
+	for _, b := range ql.backends {
+		if s, ok := b.(queryLogSearcher); ok {
+			return s.search(f)
+		}
+	}
+	return nil, errQueryLogSearchUnsupported
+}
+
+// read calls fn for stored entries between since and until, oldest first.
+func (ql *queryLog) read(since, until time.Time, fn func(queryLogEntry) error) error {
+	if ql == nil {
+		return errQueryLogReadUnsupported
+	}
+	for _, b := range ql.backends {
+		if r, ok := b.(queryLogReader); ok {
+			return r.read(since, until, fn)
+		}
+	}
+	return errQueryLogReadUnsupported
+}
+
+// record queues the entry for writing. The entry is dropped if the queue is full,
+// so slow storage never delays DNS responses.
+func (ql *queryLog) record(e queryLogEntry) {
+	if ql == nil {
+		return
+	}
+	ql.anonymize(&e)
+	select {
+	case ql.ch <- e:
+	default:
+		mainLog.Load().Debug().Msg("query log queue is full, dropping entry")
+	}
+}
+
+// recordQuery records the entry to the query log, and to the sinkhole log if the query was blocked.
+// The query is counted in in-memory query stats, too.
+func (p *prog) recordQuery(e queryLogEntry) {
+	p.queryStats.record(e)
+	p.queryLog.record(e)
+	if e.Blocked != "" {
+		p.sinkholeLog.record(e)
+	}
+}
+
+// anonymize applies privacy settings to the entry.
+func (ql *queryLog) anonymize(e *queryLogEntry) {
+	switch ql.anonymizeIP {
+	case queryLogAnonymizeHash:
+		e.ClientIP = hashIP(e.ClientIP, ql.salt)
+	case queryLogAnonymizeTruncate:
+		e.ClientIP = truncateIP(e.ClientIP)
+	}
+	if ql.anonymizeIP != "" {
+		// MAC and hostname identify the client as well as its IP.
+		e.Mac = ""
+		e.Hostname = ""
+	}
+	e.Domain = truncateDomain(e.Domain, ql.domainDepth)
+}
+
+// hashIP returns the first 16 hex characters of salted SHA-256 hash of ip.
+func hashIP(ip string, salt []byte) string {
+	if ip == "" {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ip))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// truncateIP zeroes the host part of ip, keeping the /24 network for IPv4, or /48 for IPv6.
+func truncateIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.Addr().String()
+}
+
+// truncateDomain strips labels of domain, keeping depth labels below its public suffix.
+// For example, with depth 1, "www.example.co.uk" becomes "example.co.uk".
+// If depth is not positive, domain is returned as-is.
+func truncateDomain(domain string, depth int) string {
+	if depth <= 0 {
+		return domain
+	}
+	name := strings.TrimSuffix(domain, ".")
+	suffix, _ := publicsuffix.PublicSuffix(name)
+	if name == suffix {
+		return domain
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+suffix), ".")
+	if len(labels) <= depth {
+		return domain
+	}
+	return strings.Join(labels[len(labels)-depth:], ".") + "." + suffix
+}
+
+// run writes queued entries to backends in batches, and applies the retention policy
+// periodically, until ctrld stops or the query log is closed.
+func (ql *queryLog) run(stopCh chan struct{}) {
+	if ql == nil {
+		return
+	}
+	defer close(ql.done)
+	ql.prune()
+	flushTicker := time.NewTicker(queryLogFlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(queryLogPruneInterval)
+	defer pruneTicker.Stop()
+	batch := make([]queryLogEntry, 0, queryLogBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ql.write(batch)
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case e := <-ql.ch:
+			batch = append(batch, e)
+			if len(batch) >= queryLogBatchSize {
+				flush()
+			}
+		case <-flushTicker.C:
+			flush()
+		case <-pruneTicker.C:
+			flush()
+			ql.prune()
+		case <-stopCh:
+			ql.drain(batch)
+			return
+		case <-ql.stopCh:
+			ql.drain(batch)
+			return
+		}
+	}
+}
+
+// drain writes batch and all queued entries, then closes backends.
+func (ql *queryLog) drain(batch []queryLogEntry) {
+	for len(ql.ch) > 0 {
+		batch = append(batch, <-ql.ch)
+	}
+	if len(batch) > 0 {
+		ql.write(batch)
+	}
+	for _, b := range ql.backends {
+		if err := b.close(); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not close query log")
+		}
+	}
+}
+
+// write writes entries to all backends.
+func (ql *queryLog) write(entries []queryLogEntry) {
+	for _, b := range ql.backends {
+		if err := b.write(entries); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not write query log")
+		}
+	}
+}
+
+// prune applies the retention policy to all backends.
+func (ql *queryLog) prune() {
+	for _, b := range ql.backends {
+		if err := b.prune(ql.retention); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not apply query log retention policy")
+		}
+	}
+}
+
+// close stops the query log, waiting for pending entries to be written.
+func (ql *queryLog) close() {
+	if ql == nil {
+		return
+	}
+	ql.closeOnce.Do(func() {
+		close(ql.stopCh)
+	})
+	<-ql.done
+}
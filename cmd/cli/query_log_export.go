@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// queryLogExportDefaultBatchSize is the default number of entries sent in one request.
+	queryLogExportDefaultBatchSize = 1000
+	// queryLogExportDefaultInterval is the default interval for sending pending entries.
+	queryLogExportDefaultInterval = 10 * time.Second
+	// queryLogExportDefaultTable is the default ClickHouse table which entries are inserted to.
+	queryLogExportDefaultTable = "ctrld.queries"
+	// queryLogExportTimeout is the timeout for sending a batch of entries.
+	queryLogExportTimeout = 30 * time.Second
+	// queryLogExportMaxPendingBatches is the maximum number of batches kept while the endpoint is
+	// unreachable, oldest entries are dropped when exceeding it.
+	queryLogExportMaxPendingBatches = 10
+
+	queryLogExportHTTP       = "http"
+	queryLogExportClickHouse = "clickhouse"
+)
+
+// queryLogExportRow is a query log entry sent to the export endpoint.
+type queryLogExportRow struct {
+	queryLogEntry
+	// Instance is the hostname of the machine running ctrld, so entries
+	// of multiple instances could be told apart.
+	Instance string `json:"instance"`
+}
+
+// queryLogExporter is the query log backend sending entries in batches as JSON lines to a remote
+// endpoint, either a ClickHouse HTTP interface, or a generic HTTP bulk endpoint. Entries are kept
+// and retried later if they could not be sent.
+type queryLogExporter struct {
+	url       string
+	client    *http.Client
+	instance  string
+	batchSize int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	pending []queryLogEntry
+	dropped int
+
+	flushCh   chan struct{}
+	stopCh    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newQueryLogExporter returns the query log exporter for given config,
+// and starts sending entries in background.
+func newQueryLogExporter(cfg *ctrld.Config) (*queryLogExporter, error) {
+	sc := cfg.Service
+	exportURL, err := queryLogExportURL(sc.QueryLogExportURL, sc.QueryLogExportType, sc.QueryLogExportTable)
+	if err != nil {
+		return nil, err
+	}
+	qe := &queryLogExporter{
+		url:       exportURL,
+		client:    newBootstrapHTTPClient(queryLogExportTimeout),
+		batchSize: queryLogExportDefaultBatchSize,
+		interval:  queryLogExportDefaultInterval,
+		flushCh:   make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	qe.instance, _ = os.Hostname()
+	if n := sc.QueryLogExportBatchSize; n != nil && *n > 0 {
+		qe.batchSize = *n
+	}
+	if d := sc.QueryLogExportInterval; d != nil && *d > 0 {
+		qe.interval = *d
+	}
+	go qe.run()
+	return qe, nil
+}
+
+// queryLogExportURL returns the URL which entries are sent to. For ClickHouse, the INSERT query
+// is added to rawURL, so entries could be sent as request body in JSONEachRow format.
+func queryLogExportURL(rawURL, typ, table string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid query log export url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid query log export url scheme: %q", u.Scheme)
+	}
+	if typ != queryLogExportClickHouse {
+		return u.String(), nil
+	}
+	if table == "" {
+		table = queryLogExportDefaultTable
+	}
+	q := u.Query()
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	q.Set("date_time_input_format", "best_effort")
+	q.Set("input_format_skip_unknown_fields", "1")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// write queues entries to be sent, triggering sending if there are enough entries for a batch.
+func (qe *queryLogExporter) write(entries []queryLogEntry) error {
+	qe.mu.Lock()
+	qe.pending = append(qe.pending, entries...)
+	qe.trimLocked()
+	full := len(qe.pending) >= qe.batchSize
+	qe.mu.Unlock()
+	if full {
+		select {
+		case qe.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// trimLocked drops oldest pending entries when exceeding the maximum number of pending batches.
+// qe.mu must be held.
+func (qe *queryLogExporter) trimLocked() {
+	if n := len(qe.pending) - qe.batchSize*queryLogExportMaxPendingBatches; n > 0 {
+		qe.pending = append(qe.pending[:0], qe.pending[n:]...)
+		qe.dropped += n
+	}
+}
+
+// prune is a no-op, retention of exported entries is managed by the remote endpoint.
+func (qe *queryLogExporter) prune(queryLogRetention) error {
+	return nil
+}
+
+// close stops sending entries in background, then sends all pending entries.
+func (qe *queryLogExporter) close() error {
+	var err error
+	qe.closeOnce.Do(func() {
+		close(qe.stopCh)
+		<-qe.done
+		err = qe.flush()
+	})
+	return err
+}
+
+// run sends pending entries periodically, or when there are enough entries for a batch.
+func (qe *queryLogExporter) run() {
+	defer close(qe.done)
+	ticker := time.NewTicker(qe.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-qe.flushCh:
+		case <-qe.stopCh:
+			return
+		}
+		if err := qe.flush(); err != nil {
+			mainLog.Load().Warn().Err(err).Msg("could not export query log")
+		}
+	}
+}
+
+// flush sends pending entries in batches. If a batch could not be sent, it's put back
+// to pending entries, to be retried later.
+func (qe *queryLogExporter) flush() error {
+	for {
+		qe.mu.Lock()
+		if qe.dropped > 0 {
+			mainLog.Load().Warn().Msgf("query log export endpoint is not keeping up, dropped %d entries", qe.dropped)
+			qe.dropped = 0
+		}
+		n := min(len(qe.pending), qe.batchSize)
+		batch := make([]queryLogEntry, n)
+		copy(batch, qe.pending)
+		qe.pending = append(qe.pending[:0], qe.pending[n:]...)
+		qe.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := qe.send(batch); err != nil {
+			qe.mu.Lock()
+			qe.pending = append(batch, qe.pending...)
+			qe.trimLocked()
+			qe.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// send sends entries as JSON lines in a single request.
+func (qe *queryLogExporter) send(entries []queryLogEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(queryLogExportRow{queryLogEntry: e, Instance: qe.instance}); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, qe.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := qe.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
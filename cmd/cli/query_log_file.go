@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// queryLogFileTimeFormat is the time format of rotated query log files suffix, sorted chronologically.
+	queryLogFileTimeFormat = "20060102T150405.000"
+	// queryLogFileMinRotateSize is the minimum size of query log file before it's rotated.
+	queryLogFileMinRotateSize = 1 << 20
+	// queryLogFileRotateRatio is the ratio between the query log max size and the size of each file.
+	queryLogFileRotateRatio = 10
+)
+
+// queryLogFile is the query log backend writing entries as JSON lines to a file. The file is rotated
+// daily, or when reaching a tenth of the max size, so old entries could be removed by deleting
+// rotated files.
+type queryLogFile struct {
+	path       string
+	f          *os.File
+	size       int64
+	opened     time.Time
+	rotateSize int64
+	now        func() time.Time
+}
+
+// newQueryLogFile returns the query log file backend, writing to path.
+func newQueryLogFile(path string) (*queryLogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	qf := &queryLogFile{path: path, now: time.Now}
+	if err := qf.open(); err != nil {
+		return nil, err
+	}
+	return qf, nil
+}
+
+// open opens the query log file for appending.
+func (qf *queryLogFile) open() error {
+	f, err := os.OpenFile(qf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	qf.f = f
+	qf.size = fi.Size()
+	qf.opened = fi.ModTime()
+	if qf.size == 0 {
+		qf.opened = qf.now()
+	}
+	return nil
+}
+
+func (qf *queryLogFile) write(entries []queryLogEntry) error {
+	if qf.shouldRotate() {
+		if err := qf.rotate(); err != nil {
+			return err
+		}
+	}
+	// The buffer is flushed whenever it's full, so the size is counted by what's actually written to the file.
+	w := bufio.NewWriter(&countingWriter{w: qf.f, n: &qf.size})
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// countingWriter is an io.Writer adding the number of bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	*cw.n += int64(n)
+	return n, err
+}
+
+// shouldRotate reports whether the current file must be rotated before writing new entries.
+func (qf *queryLogFile) shouldRotate() bool {
+	if qf.size == 0 {
+		return false
+	}
+	if qf.rotateSize > 0 && qf.size >= qf.rotateSize {
+		return true
+	}
+	y1, m1, d1 := qf.opened.Date()
+	y2, m2, d2 := qf.now().Date()
+	return y1 != y2 || m1 != m2 || d1 != d2
+}
+
+// rotate renames the current file using current time as suffix, then opens a new one.
+func (qf *queryLogFile) rotate() error {
+	if err := qf.f.Close(); err != nil {
+		return err
+	}
+	rotated := qf.path + "." + qf.now().Format(queryLogFileTimeFormat)
+	if err := os.Rename(qf.path, rotated); err != nil {
+		return err
+	}
+	return qf.open()
+}
+
+// rotatedFiles returns rotated query log files, oldest first.
+func (qf *queryLogFile) rotatedFiles() ([]string, error) {
+	files, err := filepath.Glob(qf.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	files = slices.DeleteFunc(files, func(file string) bool {
+		_, err := time.Parse(queryLogFileTimeFormat, file[len(qf.path)+1:])
+		return err != nil
+	})
+	slices.Sort(files)
+	return files, nil
+}
+
+func (qf *queryLogFile) prune(r queryLogRetention) error {
+	if r.maxSize > 0 {
+		qf.rotateSize = max(r.maxSize/queryLogFileRotateRatio, queryLogFileMinRotateSize)
+	}
+	files, err := qf.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	total := qf.size
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		// Rotated files are never written again, so the modification time is the time of the latest entry.
+		if r.maxAge > 0 && qf.now().Sub(fi.ModTime()) > r.maxAge {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+			continue
+		}
+		sizes[i] = fi.Size()
+		total += fi.Size()
+	}
+	for i, file := range files {
+		if r.maxSize <= 0 || total <= r.maxSize {
+			break
+		}
+		if sizes[i] == 0 {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func (qf *queryLogFile) read(since, until time.Time, fn func(queryLogEntry) error) error {
+	files, err := qf.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	files = append(files, qf.path)
+	for _, file := range files {
+		// Rotated files are never written again, so the modification time is the time of the latest entry.
+		if file != qf.path && !since.IsZero() {
+			if fi, err := os.Stat(file); err == nil && fi.ModTime().Before(since) {
+				continue
+			}
+		}
+		if err := readQueryLogFileEntries(file, since, until, fn); err != nil {
+			// The file was removed by retention policy in the meantime.
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readQueryLogFileEntries calls fn for entries of the query log file between since and until.
+// Lines which are not valid entries, e.g: partially written ones, are skipped.
+func readQueryLogFileEntries(file string, since, until time.Time, fn func(queryLogEntry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e queryLogEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		if (!since.IsZero() && e.Time.Before(since)) || (!until.IsZero() && e.Time.After(until)) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+func (qf *queryLogFile) close() error {
+	return qf.f.Close()
+}
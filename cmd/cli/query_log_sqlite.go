@@ -0,0 +1,305 @@
+//go:build sqlite && ((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSupported reports whether sqlite is compiled in, for the query log backend and reading Pi-hole databases.
+const sqliteSupported = true
+
+// queryLogSQLiteSchema creates the queries table, with indexes for searching by time, client and domain.
+// The auto_vacuum pragma must be set before creating tables, so the database file could be shrunk
+// after old entries are removed.
+const queryLogSQLiteSchema = `
+PRAGMA auto_vacuum = INCREMENTAL;
+CREATE TABLE IF NOT EXISTS queries (
+	time INTEGER NOT NULL,
+	client_ip TEXT NOT NULL,
+	mac TEXT NOT NULL,
+	hostname TEXT NOT NULL,
+	domain TEXT NOT NULL,
+	qtype TEXT NOT NULL,
+	rcode TEXT NOT NULL,
+	upstream TEXT NOT NULL,
+	blocked TEXT NOT NULL,
+	duration_ms REAL NOT NULL,
+	rule TEXT NOT NULL DEFAULT '',
+	list TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS queries_time ON queries (time);
+CREATE INDEX IF NOT EXISTS queries_client_ip ON queries (client_ip, time);
+CREATE INDEX IF NOT EXISTS queries_domain ON queries (domain, time);
+`
+
+// queryLogSQLiteAddedColumns are the columns added after the queries table was introduced,
+// which are added to tables of existing databases.
+var queryLogSQLiteAddedColumns = []string{"rule", "list"}
+
+// queryLogSQLiteSelect selects all columns of entries from the queries table.
+const queryLogSQLiteSelect = "SELECT time, client_ip, mac, hostname, domain, qtype, rcode, upstream, blocked, duration_ms, rule, list FROM queries"
+
+// queryLogSQLitePruneRounds is the maximum number of rounds removing oldest entries
+// when the database exceeds the max size.
+const queryLogSQLitePruneRounds = 10
+
+// queryLogSQLite is the query log backend storing entries in a SQLite database.
+type queryLogSQLite struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// newQueryLogSQLite returns the SQLite query log backend, storing entries in database file at path.
+func newQueryLogSQLite(path string) (queryLogBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(queryLogSQLiteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create query log schema: %w", err)
+	}
+	if err := migrateQueryLogSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate query log schema: %w", err)
+	}
+	_ = os.Chmod(path, 0600)
+	return &queryLogSQLite{db: db, now: time.Now}, nil
+}
+
+// migrateQueryLogSQLite adds missing columns to the queries table of databases created by older ctrld versions.
+func migrateQueryLogSQLite(db *sql.DB) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('queries')")
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range queryLogSQLiteAddedColumns {
+		if columns[column] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE queries ADD COLUMN %s TEXT NOT NULL DEFAULT ''", column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs *queryLogSQLite) write(entries []queryLogEntry) error {
+	tx, err := qs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO queries (time, client_ip, mac, hostname, domain, qtype, rcode, upstream, blocked, duration_ms, rule, list)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err := stmt.Exec(e.Time.UnixMilli(), e.ClientIP, e.Mac, e.Hostname, e.Domain, e.Qtype, e.Rcode, e.Upstream, e.Blocked, e.Duration, e.Rule, e.List); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// usedSize returns the size of database pages in use.
+func (qs *queryLogSQLite) usedSize() (int64, error) {
+	var pageCount, freeCount, pageSize int64
+	if err := qs.db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, err
+	}
+	if err := qs.db.QueryRow("PRAGMA freelist_count").Scan(&freeCount); err != nil {
+		return 0, err
+	}
+	if err := qs.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return (pageCount - freeCount) * pageSize, nil
+}
+
+func (qs *queryLogSQLite) prune(r queryLogRetention) error {
+	if r.maxAge > 0 {
+		if _, err := qs.db.Exec("DELETE FROM queries WHERE time < ?", qs.now().Add(-r.maxAge).UnixMilli()); err != nil {
+			return err
+		}
+	}
+	if r.maxSize > 0 {
+		for i := 0; i < queryLogSQLitePruneRounds; i++ {
+			used, err := qs.usedSize()
+			if err != nil {
+				return err
+			}
+			if used <= r.maxSize {
+				break
+			}
+			var count int64
+			if err := qs.db.QueryRow("SELECT COUNT(*) FROM queries").Scan(&count); err != nil {
+				return err
+			}
+			if count == 0 {
+				break
+			}
+			// Remove the oldest entries proportionally to the exceeded size.
+			n := count*(used-r.maxSize)/used + 1
+			if _, err := qs.db.Exec("DELETE FROM queries WHERE rowid IN (SELECT rowid FROM queries ORDER BY time LIMIT ?)", n); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := qs.db.Exec("PRAGMA incremental_vacuum")
+	return err
+}
+
+func (qs *queryLogSQLite) search(f queryLogFilter) ([]queryLogEntry, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	if f.Client != "" {
+		conds = append(conds, "(client_ip = ? OR mac = ? OR hostname = ?)")
+		args = append(args, f.Client, strings.ToLower(f.Client), f.Client)
+	}
+	if f.Domain != "" {
+		conds = append(conds, "domain GLOB ?")
+		args = append(args, strings.ToLower(strings.TrimSuffix(f.Domain, ".")))
+	}
+	if f.Rcode != "" {
+		conds = append(conds, "rcode = ?")
+		args = append(args, strings.ToUpper(f.Rcode))
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "time <= ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+	query := queryLogSQLiteSelect
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY time DESC LIMIT ?"
+	args = append(args, f.limit())
+	var entries []queryLogEntry
+	err := qs.query(query, args, func(e queryLogEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+func (qs *queryLogSQLite) read(since, until time.Time, fn func(queryLogEntry) error) error {
+	var (
+		conds []string
+		args  []any
+	)
+	if !since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, since.UnixMilli())
+	}
+	if !until.IsZero() {
+		conds = append(conds, "time <= ?")
+		args = append(args, until.UnixMilli())
+	}
+	query := queryLogSQLiteSelect
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return qs.query(query+" ORDER BY time", args, fn)
+}
+
+// query runs the select query of entries, calling fn for each of them.
+func (qs *queryLogSQLite) query(query string, args []any, fn func(queryLogEntry) error) error {
+	rows, err := qs.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e queryLogEntry
+		var ms int64
+		if err := rows.Scan(&ms, &e.ClientIP, &e.Mac, &e.Hostname, &e.Domain, &e.Qtype, &e.Rcode, &e.Upstream, &e.Blocked, &e.Duration, &e.Rule, &e.List); err != nil {
+			return err
+		}
+		e.Time = time.UnixMilli(ms)
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (qs *queryLogSQLite) report(since time.Time, top int) (*queryReport, error) {
+	r := &queryReport{Source: queryReportSourceSQLite, Since: since}
+	ms := since.UnixMilli()
+	if err := qs.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(blocked != ''), 0), COALESCE(SUM(blocked = '' AND upstream = 'cache'), 0)
+		FROM queries WHERE time >= ?`, ms).Scan(&r.Total, &r.Blocked, &r.CacheHits); err != nil {
+		return nil, err
+	}
+	var err error
+	r.TopDomains, err = qs.topCounts(`SELECT domain, '', COUNT(*) AS n FROM queries WHERE time >= ? AND blocked = ''
+		GROUP BY domain ORDER BY n DESC, domain LIMIT ?`, ms, top)
+	if err != nil {
+		return nil, err
+	}
+	r.TopBlocked, err = qs.topCounts(`SELECT domain, '', COUNT(*) AS n FROM queries WHERE time >= ? AND blocked != ''
+		GROUP BY domain ORDER BY n DESC, domain LIMIT ?`, ms, top)
+	if err != nil {
+		return nil, err
+	}
+	r.TopClients, err = qs.topCounts(`SELECT client_ip, MAX(hostname), COUNT(*) AS n FROM queries WHERE time >= ?
+		GROUP BY client_ip ORDER BY n DESC, client_ip LIMIT ?`, ms, top)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// topCounts returns the result of aggregation query, whose rows are name, hostname and count.
+func (qs *queryLogSQLite) topCounts(query string, args ...any) ([]queryReportCount, error) {
+	rows, err := qs.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []queryReportCount{}
+	for rows.Next() {
+		var c queryReportCount
+		if err := rows.Scan(&c.Name, &c.Hostname, &c.Count); err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+func (qs *queryLogSQLite) close() error {
+	return qs.db.Close()
+}
@@ -0,0 +1,19 @@
+//go:build !sqlite || !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// sqliteSupported reports whether sqlite is compiled in, for the query log backend and reading Pi-hole databases.
+// It requires the "sqlite" build tag, and is not available on some platforms.
+const sqliteSupported = false
+
+// errSQLiteUnsupported is returned when sqlite is not compiled in.
+var errSQLiteUnsupported = fmt.Errorf("sqlite is not supported by this build of ctrld (%s/%s), it requires the %q build tag", runtime.GOOS, runtime.GOARCH, "sqlite")
+
+func newQueryLogSQLite(path string) (queryLogBackend, error) {
+	return nil, fmt.Errorf("sqlite query log backend: %w", errSQLiteUnsupported)
+}
@@ -0,0 +1,125 @@
+//go:build sqlite && ((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (windows && (amd64 || arm64)))
+
+package cli
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryLogSQLite(t *testing.T) {
+	backend, err := newQueryLogSQLite(filepath.Join(t.TempDir(), "query_log.db"))
+	require.NoError(t, err)
+	qs := backend.(*queryLogSQLite)
+	defer qs.close()
+
+	now := time.Now().Truncate(time.Millisecond)
+	qs.now = func() time.Time { return now }
+	entries := []queryLogEntry{
+		{Time: now.Add(-3 * time.Hour), ClientIP: "192.168.1.10", Mac: "aa:bb:cc:dd:ee:ff", Hostname: "laptop", Domain: "www.example.com", Qtype: "A", Rcode: "NOERROR", Upstream: "upstream.0", Duration: 1.5},
+		{Time: now.Add(-2 * time.Hour), ClientIP: "192.168.1.10", Domain: "api.example.com", Qtype: "AAAA", Rcode: "NXDOMAIN", Upstream: "upstream.0"},
+		{Time: now.Add(-time.Hour), ClientIP: "192.168.1.11", Hostname: "phone", Domain: "example.org", Qtype: "A", Rcode: "NOERROR", Upstream: "cache"},
+		{Time: now, ClientIP: "192.168.1.11", Domain: "malware.example.net", Qtype: "A", Rcode: "NXDOMAIN", Blocked: "threat_feed", List: "urlhaus"},
+	}
+	require.NoError(t, qs.write(entries))
+
+	domains := func(entries []queryLogEntry) []string {
+		var res []string
+		for _, e := range entries {
+			res = append(res, e.Domain)
+		}
+		return res
+	}
+	tests := []struct {
+		name     string
+		filter   queryLogFilter
+		expected []string
+	}{
+		{"all", queryLogFilter{}, []string{"malware.example.net", "example.org", "api.example.com", "www.example.com"}},
+		{"client ip", queryLogFilter{Client: "192.168.1.10"}, []string{"api.example.com", "www.example.com"}},
+		{"client mac", queryLogFilter{Client: "AA:BB:CC:DD:EE:FF"}, []string{"www.example.com"}},
+		{"client hostname", queryLogFilter{Client: "phone"}, []string{"example.org"}},
+		{"domain glob", queryLogFilter{Domain: "*.example.com"}, []string{"api.example.com", "www.example.com"}},
+		{"rcode", queryLogFilter{Rcode: "nxdomain"}, []string{"malware.example.net", "api.example.com"}},
+		{"time range", queryLogFilter{Since: now.Add(-150 * time.Minute), Until: now.Add(-30 * time.Minute)}, []string{"example.org", "api.example.com"}},
+		{"limit", queryLogFilter{Limit: 1}, []string{"malware.example.net"}},
+		{"no match", queryLogFilter{Client: "192.168.1.12"}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := qs.search(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, domains(res))
+		})
+	}
+
+	res, err := qs.search(queryLogFilter{Client: "laptop"})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, entries[0], res[0])
+
+	res, err = qs.search(queryLogFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, entries[3], res[0])
+
+	var read []string
+	require.NoError(t, qs.read(now.Add(-150*time.Minute), now.Add(-30*time.Minute), func(e queryLogEntry) error {
+		read = append(read, e.Domain)
+		return nil
+	}))
+	// Entries are read oldest first.
+	assert.Equal(t, []string{"api.example.com", "example.org"}, read)
+
+	r, err := qs.report(now.Add(-150*time.Minute), 1)
+	require.NoError(t, err)
+	assert.Equal(t, queryReportSourceSQLite, r.Source)
+	assert.EqualValues(t, 3, r.Total)
+	assert.EqualValues(t, 1, r.Blocked)
+	assert.EqualValues(t, 1, r.CacheHits)
+	assert.Equal(t, []queryReportCount{{Name: "api.example.com", Count: 1}}, r.TopDomains)
+	assert.Equal(t, []queryReportCount{{Name: "malware.example.net", Count: 1}}, r.TopBlocked)
+	assert.Equal(t, []queryReportCount{{Name: "192.168.1.11", Hostname: "phone", Count: 2}}, r.TopClients)
+
+	// Entries older than max age are removed.
+	require.NoError(t, qs.prune(queryLogRetention{maxAge: 90 * time.Minute}))
+	res, err = qs.search(queryLogFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"malware.example.net", "example.org"}, domains(res))
+
+	// Oldest entries are removed when exceeding max size.
+	require.NoError(t, qs.prune(queryLogRetention{maxSize: 1}))
+	res, err = qs.search(queryLogFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func Test_newQueryLogSQLite_migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query_log.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	// Schema of databases created before rule and list columns were added.
+	_, err = db.Exec(`CREATE TABLE queries (time INTEGER NOT NULL, client_ip TEXT NOT NULL, mac TEXT NOT NULL,
+		hostname TEXT NOT NULL, domain TEXT NOT NULL, qtype TEXT NOT NULL, rcode TEXT NOT NULL, upstream TEXT NOT NULL,
+		blocked TEXT NOT NULL, duration_ms REAL NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO queries VALUES (1, '192.168.1.10', '', '', 'example.com', 'A', 'NOERROR', 'upstream.0', '', 1)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	backend, err := newQueryLogSQLite(path)
+	require.NoError(t, err)
+	qs := backend.(*queryLogSQLite)
+	defer qs.close()
+	require.NoError(t, qs.write([]queryLogEntry{{Time: time.Now(), Domain: "ads.example.com", Blocked: "policy", Rule: "list.ads", List: "ads"}}))
+	res, err := qs.search(queryLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "list.ads", res[0].Rule)
+	assert.Equal(t, "", res[1].Rule)
+}
@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_truncateIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"192.168.1.100", "192.168.1.0"},
+		{"::ffff:10.0.0.1", "10.0.0.0"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::"},
+		{"fe80::1%eth0", "fe80::"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, truncateIP(tc.ip), tc.ip)
+	}
+}
+
+func Test_hashIP(t *testing.T) {
+	salt := []byte("salt")
+	h := hashIP("192.168.1.100", salt)
+	assert.Len(t, h, 16)
+	assert.Equal(t, h, hashIP("192.168.1.100", salt))
+	assert.NotEqual(t, h, hashIP("192.168.1.101", salt))
+	assert.NotEqual(t, h, hashIP("192.168.1.100", []byte("other")))
+	assert.Empty(t, hashIP("", salt))
+}
+
+func Test_truncateDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		depth    int
+		expected string
+	}{
+		{"www.example.com", 0, "www.example.com"},
+		{"www.example.com", 1, "example.com"},
+		{"a.b.example.co.uk", 1, "example.co.uk"},
+		{"a.b.example.co.uk", 2, "b.example.co.uk"},
+		{"example.com", 2, "example.com"},
+		{"com", 1, "com"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, truncateDomain(tc.domain, tc.depth), tc.domain)
+	}
+}
+
+func Test_queryLog_anonymize(t *testing.T) {
+	e := queryLogEntry{ClientIP: "192.168.1.100", Mac: "aa:bb:cc:dd:ee:ff", Hostname: "laptop", Domain: "www.example.com"}
+	ql := &queryLog{anonymizeIP: queryLogAnonymizeTruncate, domainDepth: 1}
+	ql.anonymize(&e)
+	assert.Equal(t, queryLogEntry{ClientIP: "192.168.1.0", Domain: "example.com"}, e)
+
+	// No privacy settings.
+	e = queryLogEntry{ClientIP: "192.168.1.100", Mac: "aa:bb:cc:dd:ee:ff", Hostname: "laptop", Domain: "www.example.com"}
+	expected := e
+	(&queryLog{}).anonymize(&e)
+	assert.Equal(t, expected, e)
+}
+
+func Test_queryLogSalt(t *testing.T) {
+	file := filepath.Join(t.TempDir(), queryLogSaltFileName)
+	salt := queryLogSalt(file)
+	assert.Len(t, salt, 32)
+	assert.Equal(t, salt, queryLogSalt(file))
+}
+
+func readQueryLogFile(t *testing.T, file string) []queryLogEntry {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var entries []queryLogEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e queryLogEntry
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func Test_queryLog_run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	backend, err := newQueryLogFile(path)
+	require.NoError(t, err)
+	ql := &queryLog{
+		backends: []queryLogBackend{backend},
+		ch:       make(chan queryLogEntry, queryLogQueueSize),
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go ql.run(make(chan struct{}))
+	ql.record(queryLogEntry{ClientIP: "192.168.1.100", Domain: "example.com", Qtype: "A", Rcode: "NOERROR"})
+	ql.record(queryLogEntry{ClientIP: "192.168.1.101", Domain: "example.org", Qtype: "AAAA", Rcode: "NXDOMAIN"})
+	ql.close()
+	// Closing twice is safe.
+	ql.close()
+
+	entries := readQueryLogFile(t, path)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "example.com", entries[0].Domain)
+		assert.Equal(t, "NXDOMAIN", entries[1].Rcode)
+	}
+}
+
+func Test_prog_recordQuery(t *testing.T) {
+	newLog := func(path string) *queryLog {
+		backend, err := newQueryLogFile(path)
+		require.NoError(t, err)
+		ql := &queryLog{
+			backends: []queryLogBackend{backend},
+			ch:       make(chan queryLogEntry, queryLogQueueSize),
+			stopCh:   make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		go ql.run(make(chan struct{}))
+		return ql
+	}
+	dir := t.TempDir()
+	p := &prog{
+		queryLog:    newLog(filepath.Join(dir, "query.log")),
+		sinkholeLog: newLog(filepath.Join(dir, "sinkhole.log")),
+	}
+	p.recordQuery(queryLogEntry{ClientIP: "192.168.1.100", Domain: "example.com", Qtype: "A", Rcode: "NOERROR"})
+	p.recordQuery(queryLogEntry{ClientIP: "192.168.1.100", Domain: "ads.example.com", Qtype: "A", Rcode: "NXDOMAIN", Blocked: "policy", Rule: "list.ads", List: "ads"})
+	p.queryLog.close()
+	p.sinkholeLog.close()
+
+	assert.Len(t, readQueryLogFile(t, filepath.Join(dir, "query.log")), 2)
+	entries := readQueryLogFile(t, filepath.Join(dir, "sinkhole.log"))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "ads.example.com", entries[0].Domain)
+		assert.Equal(t, "list.ads", entries[0].Rule)
+		assert.Equal(t, "ads", entries[0].List)
+	}
+
+	// The sinkhole log is recorded without the query log.
+	p = &prog{sinkholeLog: newLog(filepath.Join(dir, "sinkhole_only.log"))}
+	p.recordQuery(queryLogEntry{Domain: "malware.example.net", Blocked: "threat_feed", List: "urlhaus"})
+	p.sinkholeLog.close()
+	assert.Len(t, readQueryLogFile(t, filepath.Join(dir, "sinkhole_only.log")), 1)
+}
+
+func Test_queryLogFile_size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	qf, err := newQueryLogFile(path)
+	require.NoError(t, err)
+	defer qf.close()
+
+	// More than the write buffer size, so it's flushed while writing.
+	entries := make([]queryLogEntry, 1000)
+	for i := range entries {
+		entries[i] = queryLogEntry{ClientIP: "192.168.1.100", Domain: "example.com", Qtype: "A", Rcode: "NOERROR"}
+	}
+	require.NoError(t, qf.write(entries))
+	require.NoError(t, qf.write(entries[:1]))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fi.Size(), qf.size)
+}
+
+func Test_queryLogFile_rotateAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	qf, err := newQueryLogFile(path)
+	require.NoError(t, err)
+	defer qf.close()
+	qf.now = func() time.Time { return now }
+	qf.opened = now
+
+	entry := queryLogEntry{Time: now, ClientIP: "192.168.1.100", Domain: "example.com", Qtype: "A", Rcode: "NOERROR"}
+	require.NoError(t, qf.write([]queryLogEntry{entry}))
+
+	// Next day, the file is rotated.
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, qf.write([]queryLogEntry{entry}))
+	files, err := qf.rotatedFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Len(t, readQueryLogFile(t, files[0]), 1)
+	assert.Len(t, readQueryLogFile(t, path), 1)
+
+	// Rotated when reaching the rotate size.
+	qf.rotateSize = qf.size
+	now = now.Add(time.Second)
+	require.NoError(t, qf.write([]queryLogEntry{entry}))
+	files, err = qf.rotatedFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// Rotated files older than max age are removed.
+	old := now.Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(files[0], old, old))
+	require.NoError(t, qf.prune(queryLogRetention{maxAge: 24 * time.Hour}))
+	files, err = qf.rotatedFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// Oldest rotated files are removed when exceeding max size.
+	require.NoError(t, qf.prune(queryLogRetention{maxSize: qf.size}))
+	files, err = qf.rotatedFiles()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	assert.Len(t, readQueryLogFile(t, path), 1)
+}
+
+func Test_queryLogFile_read(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.log")
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	qf, err := newQueryLogFile(path)
+	require.NoError(t, err)
+	defer qf.close()
+	qf.now = func() time.Time { return now }
+	qf.opened = now
+
+	require.NoError(t, qf.write([]queryLogEntry{{Time: now, Domain: "a.example.com"}, {Time: now.Add(time.Hour), Domain: "b.example.com"}}))
+	// Next day, the file is rotated.
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, qf.write([]queryLogEntry{{Time: now, Domain: "c.example.com"}}))
+	files, err := qf.rotatedFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.NoError(t, os.Chtimes(files[0], now.Add(-23*time.Hour), now.Add(-23*time.Hour)))
+
+	read := func(since, until time.Time) []string {
+		var domains []string
+		require.NoError(t, qf.read(since, until, func(e queryLogEntry) error {
+			domains = append(domains, e.Domain)
+			return nil
+		}))
+		return domains
+	}
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com"}, read(time.Time{}, time.Time{}))
+	assert.Equal(t, []string{"b.example.com", "c.example.com"}, read(now.Add(-23*time.Hour), time.Time{}))
+	assert.Equal(t, []string{"a.example.com"}, read(time.Time{}, now.Add(-24*time.Hour)))
+	// Rotated files whose entries are all older than since are skipped.
+	assert.Equal(t, []string{"c.example.com"}, read(now.Add(-time.Hour), time.Time{}))
+}
+
+func Test_queryLog_readUnsupported(t *testing.T) {
+	var ql *queryLog
+	err := ql.read(time.Time{}, time.Time{}, func(queryLogEntry) error { return nil })
+	assert.ErrorIs(t, err, errQueryLogReadUnsupported)
+
+	ql = &queryLog{backends: []queryLogBackend{&queryLogExporter{}}}
+	err = ql.read(time.Time{}, time.Time{}, func(queryLogEntry) error { return nil })
+	assert.ErrorIs(t, err, errQueryLogReadUnsupported)
+}
+
+func Test_parseQueryLogTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		s        string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"1h", now.Add(-time.Hour), false},
+		{"2024-01-01 08:30", time.Date(2024, 1, 1, 8, 30, 0, 0, time.Local), false},
+		{"2024-01-01 08:30:15", time.Date(2024, 1, 1, 8, 30, 15, 0, time.Local), false},
+		{"2023-12-31", time.Date(2023, 12, 31, 0, 0, 0, 0, time.Local), false},
+		{"2024-01-01T08:30:00Z", time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tc := range tests {
+		got, err := parseQueryLogTime(tc.s, now)
+		if tc.wantErr {
+			assert.Error(t, err, tc.s)
+			continue
+		}
+		assert.NoError(t, err, tc.s)
+		assert.True(t, tc.expected.Equal(got), tc.s)
+	}
+}
+
+func Test_queryLog_searchUnsupported(t *testing.T) {
+	var ql *queryLog
+	_, err := ql.search(queryLogFilter{})
+	assert.ErrorIs(t, err, errQueryLogSearchUnsupported)
+
+	backend, err := newQueryLogFile(filepath.Join(t.TempDir(), "query.log"))
+	require.NoError(t, err)
+	defer backend.close()
+	ql = &queryLog{backends: []queryLogBackend{backend}}
+	_, err = ql.search(queryLogFilter{})
+	assert.ErrorIs(t, err, errQueryLogSearchUnsupported)
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryPool runs the handlers of DNS queries.
+type queryPool interface {
+	// serve runs fn, waiting for it to be finished. It reports false without running fn
+	// if there are too many queries waiting to be handled already.
+	serve(fn func()) bool
+	// wait blocks until the pool could accept new queries, if it is bounded.
+	wait()
+	// inflight returns the number of queries being handled.
+	inflight() int
+	// queued returns the number of queries waiting to be handled.
+	queued() int
+}
+
+// noopQueryPool runs queries in the caller goroutine, without limit.
+type noopQueryPool struct {
+	n atomic.Int64
+}
+
+func (n *noopQueryPool) serve(fn func()) bool {
+	n.n.Add(1)
+	defer n.n.Add(-1)
+	fn()
+	return true
+}
+
+func (n *noopQueryPool) wait() {}
+
+func (n *noopQueryPool) inflight() int { return int(n.n.Load()) }
+
+func (n *noopQueryPool) queued() int { return 0 }
+
+// queryJob is a query waiting to be handled by workerPool.
+type queryJob struct {
+	fn   func()
+	done chan struct{}
+}
+
+// workerPool handles queries using a fixed number of workers, fed by a bounded queue.
+// If maxQueued is 0, the number of waiting queries is unlimited.
+type workerPool struct {
+	jobs chan queryJob
+	// slots bounds the number of queries being handled or queued, nil if unlimited.
+	slots   chan struct{}
+	busy    atomic.Int64
+	waiting atomic.Int64
+}
+
+// newWorkerPool returns a workerPool with n workers, which run for the lifetime of ctrld.
+func newWorkerPool(n, maxQueued int) *workerPool {
+	wp := &workerPool{jobs: make(chan queryJob, maxQueued)}
+	if maxQueued > 0 {
+		wp.slots = make(chan struct{}, n+maxQueued)
+	}
+	for range n {
+		go wp.work()
+	}
+	return wp
+}
+
+func (wp *workerPool) work() {
+	for job := range wp.jobs {
+		wp.waiting.Add(-1)
+		wp.busy.Add(1)
+		wp.run(job)
+		wp.busy.Add(-1)
+		if wp.slots != nil {
+			<-wp.slots
+		}
+	}
+}
+
+func (wp *workerPool) run(job queryJob) {
+	defer close(job.done)
+	job.fn()
+}
+
+func (wp *workerPool) serve(fn func()) bool {
+	if wp.slots != nil {
+		select {
+		case wp.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	job := queryJob{fn: fn, done: make(chan struct{})}
+	wp.waiting.Add(1)
+	wp.jobs <- job
+	<-job.done
+	return true
+}
+
+func (wp *workerPool) wait() {
+	if wp.slots != nil {
+		wp.slots <- struct{}{}
+		<-wp.slots
+	}
+}
+
+func (wp *workerPool) inflight() int { return int(wp.busy.Load()) }
+
+func (wp *workerPool) queued() int { return int(wp.waiting.Load()) }
+
+// poolHandler is a dns.Handler running queries in a queryPool.
+type poolHandler struct {
+	pool queryPool
+	next dns.Handler
+	// reject answers the query which was not handled because the pool is full.
+	reject func(w dns.ResponseWriter, m *dns.Msg)
+}
+
+func (h *poolHandler) ServeDNS(w dns.ResponseWriter, m *dns.Msg) {
+	if !h.pool.serve(func() { h.next.ServeDNS(w, m) }) {
+		h.reject(w, m)
+	}
+}
+
+// decorateReader returns a dns.DecorateReader which stops reading queries from UDP sockets while
+// the pool is full, so a flood of queries does not spawn unbounded goroutines in the DNS server.
+// Excess queries are dropped by the kernel once the socket receive buffer is full.
+func (h *poolHandler) decorateReader(r dns.Reader) dns.Reader {
+	if pr, ok := r.(dns.PacketConnReader); ok {
+		return &poolReader{PacketConnReader: pr, pool: h.pool}
+	}
+	return r
+}
+
+// poolReader waits for the pool to accept new queries before reading them.
+type poolReader struct {
+	dns.PacketConnReader
+	pool queryPool
+}
+
+func (r *poolReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	r.pool.wait()
+	return r.PacketConnReader.ReadUDP(conn, timeout)
+}
+
+func (r *poolReader) ReadPacketConn(conn net.PacketConn, timeout time.Duration) ([]byte, net.Addr, error) {
+	r.pool.wait()
+	return r.PacketConnReader.ReadPacketConn(conn, timeout)
+}
+
+// setPoolReader sets the reader of UDP server s, if its handler runs queries in a queryPool.
+func setPoolReader(s *dns.Server) {
+	if h, ok := s.Handler.(*poolHandler); ok && s.Net != "tcp" && s.Net != "tcp-tls" {
+		s.DecorateReader = h.decorateReader
+	}
+}
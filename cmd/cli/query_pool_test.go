@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_workerPool(t *testing.T) {
+	pool := newWorkerPool(1, 1)
+	block := make(chan struct{})
+	served := make(chan bool, 2)
+	go func() { served <- pool.serve(func() { <-block }) }()
+	assert.Eventually(t, func() bool { return pool.inflight() == 1 }, time.Second, 10*time.Millisecond)
+
+	var ran atomic.Bool
+	go func() { served <- pool.serve(func() { ran.Store(true) }) }()
+	assert.Eventually(t, func() bool { return pool.queued() == 1 }, time.Second, 10*time.Millisecond)
+
+	// Queue is full, the query must be dropped.
+	assert.False(t, pool.serve(func() { t.Error("dropped query must not run") }))
+	assert.Equal(t, 1, pool.queued())
+
+	// Waiting for the pool to accept new queries.
+	waited := make(chan struct{})
+	go func() {
+		pool.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait must block while the pool is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block)
+	assert.True(t, <-served)
+	assert.True(t, <-served)
+	assert.True(t, ran.Load())
+	<-waited
+	assert.Equal(t, 0, pool.queued())
+	assert.Equal(t, 0, pool.inflight())
+}
+
+func Test_workerPool_fixedWorkers(t *testing.T) {
+	pool := newWorkerPool(4, 0)
+	before := runtime.NumGoroutine()
+	var n atomic.Int64
+	for range 100 {
+		assert.True(t, pool.serve(func() { n.Add(1) }))
+	}
+	assert.Equal(t, int64(100), n.Load())
+	// Queries are handled by the workers, no goroutines are spawned per query.
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// queryStatsMaxAge is the period covered by in-memory query stats.
+	queryStatsMaxAge = 24 * time.Hour
+	// queryStatsBucketSize is the period of each bucket of in-memory query stats.
+	queryStatsBucketSize = time.Hour
+	// queryStatsMaxKeys is the maximum number of distinct domains or clients counted in each bucket,
+	// so memory usage is bounded. Queries for other domains are still counted in totals.
+	queryStatsMaxKeys = 1024
+	// queryReportDefaultTop is the default number of top domains and clients in reports.
+	queryReportDefaultTop = 10
+
+	queryReportSourceMemory = "memory"
+	queryReportSourceSQLite = "sqlite"
+)
+
+// queryReportRequest is the request for a query report sent to the control server.
+type queryReportRequest struct {
+	Since time.Time `json:"since"`
+	Top   int       `json:"top,omitempty"`
+}
+
+// top returns the number of top domains and clients requested.
+func (r queryReportRequest) top() int {
+	if r.Top > 0 {
+		return r.Top
+	}
+	return queryReportDefaultTop
+}
+
+// queryReport summarizes DNS queries answered by ctrld in a period.
+type queryReport struct {
+	// Source is where the report was computed from, the in-memory stats or the query log database.
+	Source     string             `json:"source"`
+	Since      time.Time          `json:"since"`
+	Total      int64              `json:"total"`
+	Blocked    int64              `json:"blocked"`
+	CacheHits  int64              `json:"cache_hits"`
+	TopDomains []queryReportCount `json:"top_domains"`
+	TopBlocked []queryReportCount `json:"top_blocked"`
+	TopClients []queryReportCount `json:"top_clients"`
+}
+
+// queryReportCount is the number of queries for a domain, or from a client.
+type queryReportCount struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname,omitempty"`
+	Count    int64  `json:"count"`
+}
+
+// cacheHitRate returns the percentage of not blocked queries answered from cache.
+func (r *queryReport) cacheHitRate() float64 {
+	if n := r.Total - r.Blocked; n > 0 {
+		return float64(r.CacheHits) * 100 / float64(n)
+	}
+	return 0
+}
+
+// queryLogReporter is implemented by backends supporting reporting on stored query log entries.
+type queryLogReporter interface {
+	report(since time.Time, top int) (*queryReport, error)
+}
+
+// report returns the report of queries since given time, computed from the query log
+// if its backend supports it, or the in-memory query stats otherwise.
+func (p *prog) report(req queryReportRequest) (*queryReport, error) {
+	if p.queryLog != nil {
+		for _, b := range p.queryLog.backends {
+			if r, ok := b.(queryLogReporter); ok {
+				return r.report(req.Since, req.top())
+			}
+		}
+	}
+	return p.queryStats.report(req.Since, req.top()), nil
+}
+
+// queryStats keeps counts of recent queries in memory, in hourly buckets.
+type queryStats struct {
+	mu      sync.Mutex
+	buckets []*queryStatsBucket
+	now     func() time.Time
+}
+
+// queryStatsBucket is the counts of queries in a period starting at start.
+type queryStatsBucket struct {
+	start          time.Time
+	total          int64
+	blocked        int64
+	cacheHits      int64
+	domains        map[string]int64
+	blockedDomains map[string]int64
+	clients        map[string]int64
+	hostnames      map[string]string
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{now: time.Now}
+}
+
+// record counts the query of entry e.
+func (qs *queryStats) record(e queryLogEntry) {
+	if qs == nil {
+		return
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	b := qs.bucket(e.Time)
+	b.total++
+	switch {
+	case e.Blocked != "":
+		b.blocked++
+		incCount(b.blockedDomains, e.Domain)
+	default:
+		if e.Upstream == "cache" {
+			b.cacheHits++
+		}
+		incCount(b.domains, e.Domain)
+	}
+	if incCount(b.clients, e.ClientIP) && e.Hostname != "" {
+		b.hostnames[e.ClientIP] = e.Hostname
+	}
+}
+
+// bucket returns the bucket for queries at t, removing buckets older than queryStatsMaxAge.
+func (qs *queryStats) bucket(t time.Time) *queryStatsBucket {
+	start := t.Truncate(queryStatsBucketSize)
+	if n := len(qs.buckets); n > 0 && !qs.buckets[n-1].start.Before(start) {
+		return qs.buckets[n-1]
+	}
+	cutoff := qs.now().Add(-queryStatsMaxAge)
+	qs.buckets = slices.DeleteFunc(qs.buckets, func(b *queryStatsBucket) bool {
+		return b.start.Add(queryStatsBucketSize).Before(cutoff)
+	})
+	b := &queryStatsBucket{
+		start:          start,
+		domains:        make(map[string]int64),
+		blockedDomains: make(map[string]int64),
+		clients:        make(map[string]int64),
+		hostnames:      make(map[string]string),
+	}
+	qs.buckets = append(qs.buckets, b)
+	return b
+}
+
+// incCount increases the count of key in m, reporting whether it is counted, which it is not
+// if m already has queryStatsMaxKeys keys.
+func incCount(m map[string]int64, key string) bool {
+	if _, ok := m[key]; !ok && len(m) >= queryStatsMaxKeys {
+		return false
+	}
+	m[key]++
+	return true
+}
+
+// report returns the report of queries since given time. Buckets partially covering the period are
+// included in whole, and the period is limited to queryStatsMaxAge.
+func (qs *queryStats) report(since time.Time, top int) *queryReport {
+	r := &queryReport{Source: queryReportSourceMemory, Since: since}
+	if qs == nil {
+		return r
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if cutoff := qs.now().Add(-queryStatsMaxAge).Truncate(queryStatsBucketSize); r.Since.Before(cutoff) {
+		r.Since = cutoff
+	}
+	domains := make(map[string]int64)
+	blockedDomains := make(map[string]int64)
+	clients := make(map[string]int64)
+	hostnames := make(map[string]string)
+	for _, b := range qs.buckets {
+		if b.start.Add(queryStatsBucketSize).Before(r.Since) {
+			continue
+		}
+		r.Total += b.total
+		r.Blocked += b.blocked
+		r.CacheHits += b.cacheHits
+		for k, v := range b.domains {
+			domains[k] += v
+		}
+		for k, v := range b.blockedDomains {
+			blockedDomains[k] += v
+		}
+		for k, v := range b.clients {
+			clients[k] += v
+		}
+		for k, v := range b.hostnames {
+			hostnames[k] = v
+		}
+	}
+	r.TopDomains = topCounts(domains, top)
+	r.TopBlocked = topCounts(blockedDomains, top)
+	r.TopClients = topCounts(clients, top)
+	for i := range r.TopClients {
+		r.TopClients[i].Hostname = hostnames[r.TopClients[i].Name]
+	}
+	return r
+}
+
+// topCounts returns the n keys of m with highest counts, sorted by count descending, then by key.
+func topCounts(m map[string]int64, n int) []queryReportCount {
+	res := make([]queryReportCount, 0, len(m))
+	for k, v := range m {
+		res = append(res, queryReportCount{Name: k, Count: v})
+	}
+	slices.SortFunc(res, func(a, b queryReportCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
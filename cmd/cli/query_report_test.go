@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_queryStats_report(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	qs := newQueryStats()
+	qs.now = func() time.Time { return now }
+
+	qs.record(queryLogEntry{Time: now.Add(-30 * time.Hour), ClientIP: "192.168.1.12", Domain: "old.example.com"})
+	qs.record(queryLogEntry{Time: now.Add(-3 * time.Hour), ClientIP: "192.168.1.10", Hostname: "laptop", Domain: "example.com", Upstream: "upstream.0"})
+	qs.record(queryLogEntry{Time: now.Add(-time.Hour), ClientIP: "192.168.1.10", Domain: "example.com", Upstream: "cache"})
+	qs.record(queryLogEntry{Time: now, ClientIP: "192.168.1.11", Domain: "example.org", Upstream: "upstream.0"})
+	qs.record(queryLogEntry{Time: now, ClientIP: "192.168.1.11", Domain: "ads.example.net", Blocked: "policy"})
+
+	r := qs.report(now.Add(-48*time.Hour), 10)
+	assert.Equal(t, queryReportSourceMemory, r.Source)
+	// Stats older than queryStatsMaxAge are removed.
+	assert.Equal(t, now.Add(-queryStatsMaxAge).Truncate(time.Hour), r.Since)
+	assert.EqualValues(t, 4, r.Total)
+	assert.EqualValues(t, 1, r.Blocked)
+	assert.EqualValues(t, 1, r.CacheHits)
+	assert.InDelta(t, 33.33, r.cacheHitRate(), 0.01)
+	assert.Equal(t, []queryReportCount{{Name: "example.com", Count: 2}, {Name: "example.org", Count: 1}}, r.TopDomains)
+	assert.Equal(t, []queryReportCount{{Name: "ads.example.net", Count: 1}}, r.TopBlocked)
+	assert.Equal(t, []queryReportCount{
+		{Name: "192.168.1.10", Hostname: "laptop", Count: 2},
+		{Name: "192.168.1.11", Count: 2},
+	}, r.TopClients)
+
+	r = qs.report(now.Add(-90*time.Minute), 1)
+	assert.EqualValues(t, 3, r.Total)
+	assert.Equal(t, []queryReportCount{{Name: "example.com", Count: 1}}, r.TopDomains)
+	assert.Equal(t, []queryReportCount{{Name: "192.168.1.11", Count: 2}}, r.TopClients)
+}
+
+func Test_queryStats_maxKeys(t *testing.T) {
+	now := time.Now()
+	qs := newQueryStats()
+	for i := 0; i < queryStatsMaxKeys+10; i++ {
+		qs.record(queryLogEntry{Time: now, ClientIP: "192.168.1.10", Domain: strconv.Itoa(i) + ".example.com"})
+	}
+	r := qs.report(now.Add(-time.Hour), queryStatsMaxKeys*2)
+	assert.EqualValues(t, queryStatsMaxKeys+10, r.Total)
+	assert.Len(t, r.TopDomains, queryStatsMaxKeys)
+}
+
+func Test_queryReport_cacheHitRate(t *testing.T) {
+	assert.Zero(t, (&queryReport{}).cacheHitRate())
+	assert.Zero(t, (&queryReport{Total: 2, Blocked: 2}).cacheHitRate())
+	assert.Equal(t, 50.0, (&queryReport{Total: 3, Blocked: 1, CacheHits: 1}).cacheHitRate())
+}
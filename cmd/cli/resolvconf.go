@@ -4,12 +4,14 @@ import (
 	"net"
 	"net/netip"
 	"path/filepath"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"tailscale.com/net/dns/resolvconffile"
 )
 
 // watchResolvConf watches any changes to /etc/resolv.conf file,
-// and reverting to the original config set by ctrld.
+// and reverting to the original config set by ctrld, after the configured grace period.
 func (p *prog) watchResolvConf(iface *net.Interface, ns []netip.Addr, setDnsFn func(iface *net.Interface, ns []netip.Addr) error) {
 	resolvConfPath := "/etc/resolv.conf"
 	// Evaluating symbolics link to watch the target file that /etc/resolv.conf point to.
@@ -32,6 +34,28 @@ func (p *prog) watchResolvConf(iface *net.Interface, ns []netip.Addr, setDnsFn f
 		return
 	}
 
+	// revert re-applies ctrld settings, pausing the watcher so our own changes won't be caught.
+	revert := func() bool {
+		if err := watcher.Remove(watchDir); err != nil {
+			mainLog.Load().Error().Err(err).Msg("failed to pause watcher")
+			return true
+		}
+		p.dnsEvents.reapplied(iface.Name, setDnsFn(iface, ns))
+		if err := watcher.Add(watchDir); err != nil {
+			mainLog.Load().Error().Err(err).Msg("failed to continue running watcher")
+			return false
+		}
+		return true
+	}
+	grace := p.dnsWatchdogGracePeriod()
+	var graceTimer *time.Timer
+	var graceC <-chan time.Time
+	defer func() {
+		if graceTimer != nil {
+			graceTimer.Stop()
+		}
+	}()
+
 	for {
 		select {
 		case <-p.dnsWatcherStopCh:
@@ -39,6 +63,14 @@ func (p *prog) watchResolvConf(iface *net.Interface, ns []netip.Addr, setDnsFn f
 		case <-p.stopCh:
 			mainLog.Load().Debug().Msgf("stopping watcher for %s", resolvConfPath)
 			return
+		case <-graceC:
+			graceC = nil
+			if p.leakingQuery.Load() {
+				return
+			}
+			if !revert() {
+				return
+			}
 		case event, ok := <-watcher.Events:
 			if p.leakingQuery.Load() {
 				return
@@ -50,16 +82,25 @@ func (p *prog) watchResolvConf(iface *net.Interface, ns []netip.Addr, setDnsFn f
 				continue
 			}
 			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
-				mainLog.Load().Debug().Msg("/etc/resolv.conf changes detected, reverting to ctrld setting")
-				if err := watcher.Remove(watchDir); err != nil {
-					mainLog.Load().Error().Err(err).Msg("failed to pause watcher")
+				// Changes during grace period are reverted together when it's over.
+				if graceC != nil {
 					continue
 				}
-				if err := setDnsFn(iface, ns); err != nil {
-					mainLog.Load().Error().Err(err).Msg("failed to revert /etc/resolv.conf changes")
+				var cur []string
+				if c, err := resolvconffile.ParseFile(resolvConfPath); err == nil {
+					for _, nameserver := range c.Nameservers {
+						cur = append(cur, nameserver.String())
+					}
 				}
-				if err := watcher.Add(watchDir); err != nil {
-					mainLog.Load().Error().Err(err).Msg("failed to continue running watcher")
+				p.dnsEvents.add(dnsEvent{Iface: iface.Name, Event: dnsEventChanged, Nameservers: cur})
+				if grace > 0 {
+					mainLog.Load().Debug().Msgf("/etc/resolv.conf changes detected, reverting to ctrld setting in %s", grace)
+					graceTimer = time.NewTimer(grace)
+					graceC = graceTimer.C
+					continue
+				}
+				mainLog.Load().Debug().Msg("/etc/resolv.conf changes detected, reverting to ctrld setting")
+				if !revert() {
 					return
 				}
 			}
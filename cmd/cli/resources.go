@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// resourceStats reports resources used by running ctrld service, so users could spot leaks
+// from "ctrld status" output, without enabling debug endpoints for profiling.
+type resourceStats struct {
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	Sys        uint64 `json:"sys"`
+	Goroutines int    `json:"goroutines"`
+	// OpenFiles is the number of open file descriptors, including sockets, -1 if it's unknown on the platform.
+	OpenFiles int `json:"open_files"`
+	// CacheEntries is the number of cached answers, nil if the cache is disabled.
+	CacheEntries *int                `json:"cache_entries,omitempty"`
+	Upstreams    []upstreamConnStats `json:"upstreams"`
+}
+
+// upstreamConnStats is the number of open connections to an upstream.
+type upstreamConnStats struct {
+	Upstream  string `json:"upstream"`
+	OpenConns int64  `json:"open_conns"`
+}
+
+// resourceStats returns the resources currently used by p.
+func (p *prog) resourceStats() *resourceStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rs := &resourceStats{
+		HeapAlloc:  ms.HeapAlloc,
+		Sys:        ms.Sys,
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  openFileCount(),
+		Upstreams:  make([]upstreamConnStats, 0, len(p.cfg.Upstream)),
+	}
+	if p.cache != nil {
+		n := len(p.cache.Keys())
+		rs.CacheEntries = &n
+	}
+	for n, uc := range p.cfg.Upstream {
+		rs.Upstreams = append(rs.Upstreams, upstreamConnStats{Upstream: upstreamPrefix + n, OpenConns: uc.OpenConns()})
+	}
+	sort.Slice(rs.Upstreams, func(i, j int) bool { return rs.Upstreams[i].Upstream < rs.Upstreams[j].Upstream })
+	return rs
+}
+
+// formatMiB formats number of bytes v in MiB.
+func formatMiB(v uint64) string {
+	return strconv.FormatFloat(float64(v)/(1<<20), 'f', 2, 64) + "MiB"
+}
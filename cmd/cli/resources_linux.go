@@ -0,0 +1,13 @@
+package cli
+
+import "os"
+
+// openFileCount returns the number of open file descriptors of ctrld process.
+func openFileCount() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// The directory being read is opened, and counted, too.
+	return len(entries) - 1
+}
@@ -0,0 +1,12 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_openFileCount(t *testing.T) {
+	// Stdin, stdout and stderr are always open.
+	assert.GreaterOrEqual(t, openFileCount(), 3)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cli
+
+// openFileCount returns the number of open file descriptors of ctrld process, it's only known on Linux.
+func openFileCount() int {
+	return -1
+}
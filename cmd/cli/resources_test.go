@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+)
+
+func Test_prog_resourceStats(t *testing.T) {
+	cfg := &ctrld.Config{Upstream: map[string]*ctrld.UpstreamConfig{"1": {}, "0": {}}}
+	p := &prog{cfg: cfg}
+	rs := p.resourceStats()
+	assert.Positive(t, rs.Goroutines)
+	assert.Positive(t, rs.HeapAlloc)
+	assert.NotZero(t, rs.OpenFiles)
+	assert.Nil(t, rs.CacheEntries)
+	assert.Equal(t, []upstreamConnStats{{Upstream: "upstream.0"}, {Upstream: "upstream.1"}}, rs.Upstreams)
+
+	cacher, err := dnscache.NewLRUCache(16, 0, "")
+	require.NoError(t, err)
+	p.cache = cacher
+	rs = p.resourceStats()
+	require.NotNil(t, rs.CacheEntries)
+	assert.Zero(t, *rs.CacheEntries)
+}
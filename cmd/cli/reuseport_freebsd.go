@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// supportsReusePort reports whether the kernel load-balances
+// queries between sockets bound to the same address.
+const supportsReusePort = true
+
+// reusePortControl uses SO_REUSEPORT_LB, since SO_REUSEPORT on FreeBSD
+// does not distribute packets between sockets.
+func reusePortControl(_, _ string, c syscall.RawConn) error {
+	var opErr error
+	if err := c.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT_LB, 1)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
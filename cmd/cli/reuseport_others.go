@@ -0,0 +1,11 @@
+//go:build !linux && !dragonfly && !freebsd
+
+package cli
+
+import "syscall"
+
+// supportsReusePort reports whether the kernel load-balances
+// queries between sockets bound to the same address.
+const supportsReusePort = false
+
+func reusePortControl(_, _ string, _ syscall.RawConn) error { return nil }
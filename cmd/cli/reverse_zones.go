@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// upstreamReverseZone is the upstream name of "reverse_zone_nameserver".
+	upstreamReverseZone = upstreamPrefix + "reverse_zone"
+	// attachedSubnetsTTL is the duration which attached subnets are re-used, before being detected again.
+	attachedSubnetsTTL = time.Minute
+)
+
+// reverseZones reports whether reverse zones of IP addresses are routed to the LAN nameserver,
+// so existing PTR records of local networks keep resolving, instead of being sent to remote upstreams.
+type reverseZones struct {
+	auto   bool
+	cidrs  []netip.Prefix
+	uc     *ctrld.UpstreamConfig
+	lister func() []netip.Prefix
+
+	mu      sync.Mutex
+	subnets []netip.Prefix
+	expire  time.Time
+}
+
+// newReverseZones returns new reverseZones using the given service config.
+func newReverseZones(cfg *ctrld.ServiceConfig) *reverseZones {
+	rz := &reverseZones{
+		auto:   cfg.ReverseZoneAuto == nil || *cfg.ReverseZoneAuto,
+		lister: attachedSubnets,
+	}
+	for _, cidr := range cfg.ReverseZoneCidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			rz.cidrs = append(rz.cidrs, prefix.Masked())
+		}
+	}
+	if ns := cfg.ReverseZoneNameserver; ns != "" {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, "53")
+		}
+		rz.uc = &ctrld.UpstreamConfig{
+			Name:     "Reverse zone nameserver",
+			Type:     ctrld.ResolverTypeLegacy,
+			Endpoint: ns,
+			Timeout:  2000,
+		}
+		rz.uc.Init()
+	}
+	return rz
+}
+
+// contains reports whether the reverse zone of ip is routed to the LAN nameserver.
+func (rz *reverseZones) contains(ip netip.Addr) bool {
+	if rz == nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range rz.cidrs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	if !rz.auto {
+		return false
+	}
+	for _, prefix := range rz.attachedSubnets() {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachedSubnets returns the cached subnets of local interfaces.
+func (rz *reverseZones) attachedSubnets() []netip.Prefix {
+	rz.mu.Lock()
+	defer rz.mu.Unlock()
+	if now := time.Now(); now.After(rz.expire) {
+		rz.subnets = rz.lister()
+		rz.expire = now.Add(attachedSubnetsTTL)
+	}
+	return rz.subnets
+}
+
+// isPtrLookup reports whether msg is a PTR query for ip address, which reverse zone is routed to the LAN nameserver.
+func (rz *reverseZones) isPtrLookup(msg *dns.Msg) bool {
+	if rz == nil || msg == nil || len(msg.Question) == 0 || msg.Question[0].Qtype != dns.TypePTR {
+		return false
+	}
+	if addr, ok := netip.AddrFromSlice(ipFromARPA(msg.Question[0].Name)); ok {
+		return rz.contains(addr)
+	}
+	return false
+}
+
+// upstreams returns the upstreams with "reverse_zone_nameserver" placed first, if configured.
+func (rz *reverseZones) upstreams(upstreams []string, upstreamConfigs []*ctrld.UpstreamConfig) ([]string, []*ctrld.UpstreamConfig) {
+	if rz == nil || rz.uc == nil {
+		return upstreams, upstreamConfigs
+	}
+	return append([]string{upstreamReverseZone}, upstreams...), append([]*ctrld.UpstreamConfig{rz.uc}, upstreamConfigs...)
+}
+
+// attachedSubnets returns subnets of addresses assigned to local interfaces, except loopback ones.
+func attachedSubnets() []netip.Prefix {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not get interface addresses")
+		return nil
+	}
+	var subnets []netip.Prefix
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		ip = ip.Unmap()
+		if ip.Is4() && ones > 32 {
+			ones -= 96
+		}
+		if prefix, err := ip.Prefix(ones); err == nil {
+			subnets = append(subnets, prefix)
+		}
+	}
+	return subnets
+}
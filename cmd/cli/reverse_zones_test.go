@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_reverseZones_isPtrLookup(t *testing.T) {
+	auto := false
+	attached := func() []netip.Prefix {
+		return []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8:1::/64")}
+	}
+	autoRz := newReverseZones(&ctrld.ServiceConfig{})
+	autoRz.lister = attached
+	staticRz := newReverseZones(&ctrld.ServiceConfig{ReverseZoneAuto: &auto, ReverseZoneCidrs: []string{"198.51.100.0/24"}})
+	staticRz.lister = attached
+
+	ptr := func(ip string) *dns.Msg {
+		arpa, _ := dns.ReverseAddr(ip)
+		m := new(dns.Msg)
+		m.SetQuestion(arpa, dns.TypePTR)
+		return m
+	}
+	tests := []struct {
+		name string
+		rz   *reverseZones
+		msg  *dns.Msg
+		want bool
+	}{
+		{"attached v4", autoRz, ptr("203.0.113.10"), true},
+		{"attached v6", autoRz, ptr("2001:db8:1::10"), true},
+		{"not attached", autoRz, ptr("192.0.2.1"), false},
+		{"static cidr", staticRz, ptr("198.51.100.1"), true},
+		{"auto disabled", staticRz, ptr("203.0.113.10"), false},
+		{"nil", nil, ptr("203.0.113.10"), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.rz.isPtrLookup(tc.msg))
+		})
+	}
+}
+
+func Test_reverseZones_upstreams(t *testing.T) {
+	upstreams := []string{upstreamOS}
+	upstreamConfigs := []*ctrld.UpstreamConfig{privateUpstreamConfig}
+
+	rz := newReverseZones(&ctrld.ServiceConfig{})
+	gotUpstreams, gotConfigs := rz.upstreams(upstreams, upstreamConfigs)
+	assert.Equal(t, upstreams, gotUpstreams)
+	assert.Equal(t, upstreamConfigs, gotConfigs)
+
+	rz = newReverseZones(&ctrld.ServiceConfig{ReverseZoneNameserver: "192.168.1.1"})
+	gotUpstreams, gotConfigs = rz.upstreams(upstreams, upstreamConfigs)
+	assert.Equal(t, []string{upstreamReverseZone, upstreamOS}, gotUpstreams)
+	assert.Equal(t, "192.168.1.1:53", gotConfigs[0].Endpoint)
+	assert.Equal(t, ctrld.ResolverTypeLegacy, gotConfigs[0].Type)
+}
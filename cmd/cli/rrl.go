@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// rrlDefaultSlip is the default number of rate limited responses, every which one is sent truncated.
+	rrlDefaultSlip = 2
+	// rrlDefaultIPv4PrefixLength is the default prefix length which IPv4 clients are grouped by.
+	rrlDefaultIPv4PrefixLength = 24
+	// rrlDefaultIPv6PrefixLength is the default prefix length which IPv6 clients are grouped by.
+	rrlDefaultIPv6PrefixLength = 56
+	// rrlPruneInterval is the interval which idle clients are removed from rate limiter.
+	rrlPruneInterval = time.Minute
+)
+
+// rrlAction is the action taken for a response by response rate limiter.
+type rrlAction int
+
+const (
+	rrlAllow rrlAction = iota // send the response as-is.
+	rrlDrop                   // drop the response.
+	rrlSlip                   // send a truncated response, so legitimate clients could retry over TCP.
+)
+
+// String returns the string representation of rrlAction, used as metrics label.
+func (a rrlAction) String() string {
+	switch a {
+	case rrlDrop:
+		return "drop"
+	case rrlSlip:
+		return "slip"
+	default:
+		return "allow"
+	}
+}
+
+// rrlBucket is the token bucket of a client prefix.
+type rrlBucket struct {
+	tokens  float64
+	last    time.Time
+	limited int
+}
+
+// responseRateLimiter implements BIND-style Response Rate Limiting (RRL), limiting UDP responses
+// per second sent to a client prefix, so ctrld could not be abused for DNS amplification attacks,
+// which spoof the source address of the victim.
+type responseRateLimiter struct {
+	rate   int
+	slip   int
+	v4Bits int
+	v6Bits int
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[netip.Prefix]*rrlBucket
+	lastPrune time.Time
+}
+
+// newResponseRateLimiter returns new responseRateLimiter using the given config,
+// or nil if response rate limiting is disabled.
+func newResponseRateLimiter(cfg *ctrld.RRLConfig) *responseRateLimiter {
+	if cfg == nil || cfg.ResponsesPerSecond <= 0 {
+		return nil
+	}
+	rl := &responseRateLimiter{
+		rate:    cfg.ResponsesPerSecond,
+		slip:    rrlDefaultSlip,
+		v4Bits:  rrlDefaultIPv4PrefixLength,
+		v6Bits:  rrlDefaultIPv6PrefixLength,
+		now:     time.Now,
+		buckets: make(map[netip.Prefix]*rrlBucket),
+	}
+	if cfg.Slip != nil {
+		rl.slip = *cfg.Slip
+	}
+	if cfg.IPv4PrefixLength != nil {
+		rl.v4Bits = *cfg.IPv4PrefixLength
+	}
+	if cfg.IPv6PrefixLength != nil {
+		rl.v6Bits = *cfg.IPv6PrefixLength
+	}
+	return rl
+}
+
+// check returns the action for a response sent to addr.
+func (rl *responseRateLimiter) check(addr net.Addr) rrlAction {
+	if rl == nil {
+		return rrlAllow
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return rrlAllow
+	}
+	ip := ap.Addr().Unmap()
+	bits := rl.v6Bits
+	if ip.Is4() {
+		bits = rl.v4Bits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return rrlAllow
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	if now.Sub(rl.lastPrune) >= rrlPruneInterval {
+		rl.prune(now)
+		rl.lastPrune = now
+	}
+	b := rl.buckets[prefix]
+	if b == nil {
+		b = &rrlBucket{tokens: float64(rl.rate), last: now}
+		rl.buckets[prefix] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(rl.rate)
+	if b.tokens > float64(rl.rate) {
+		b.tokens = float64(rl.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = 0
+		return rrlAllow
+	}
+	b.limited++
+	if rl.slip > 0 && b.limited%rl.slip == 0 {
+		return rrlSlip
+	}
+	return rrlDrop
+}
+
+// prune removes buckets of clients which were idle long enough to have their buckets full again.
+// The caller must hold rl.mu.
+func (rl *responseRateLimiter) prune(now time.Time) {
+	for prefix, b := range rl.buckets {
+		if now.Sub(b.last) >= time.Second {
+			delete(rl.buckets, prefix)
+		}
+	}
+}
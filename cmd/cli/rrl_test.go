@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_responseRateLimiter_check(t *testing.T) {
+	assert.Nil(t, newResponseRateLimiter(nil))
+	assert.Nil(t, newResponseRateLimiter(&ctrld.RRLConfig{}))
+
+	now := time.Now()
+	rl := newResponseRateLimiter(&ctrld.RRLConfig{ResponsesPerSecond: 2})
+	rl.now = func() time.Time { return now }
+
+	client := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1234}
+	sameSubnet := &net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 1234}
+	otherSubnet := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1234}
+
+	assert.Equal(t, rrlAllow, rl.check(client))
+	assert.Equal(t, rrlAllow, rl.check(sameSubnet))
+	// Rate limited, every second response slips.
+	assert.Equal(t, rrlDrop, rl.check(client))
+	assert.Equal(t, rrlSlip, rl.check(sameSubnet))
+	assert.Equal(t, rrlDrop, rl.check(client))
+	// Other prefixes are not affected.
+	assert.Equal(t, rrlAllow, rl.check(otherSubnet))
+
+	// Tokens are refilled over time.
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, rrlAllow, rl.check(client))
+	assert.Equal(t, rrlDrop, rl.check(client))
+
+	// Idle clients are pruned.
+	now = now.Add(rrlPruneInterval)
+	assert.Equal(t, rrlAllow, rl.check(otherSubnet))
+	assert.Len(t, rl.buckets, 1)
+}
+
+func Test_responseRateLimiter_noSlip(t *testing.T) {
+	slip, v4Bits := 0, 32
+	rl := newResponseRateLimiter(&ctrld.RRLConfig{ResponsesPerSecond: 1, Slip: &slip, IPv4PrefixLength: &v4Bits})
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+
+	client := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1234}
+	assert.Equal(t, rrlAllow, rl.check(client))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, rrlDrop, rl.check(client))
+	}
+	// With /32 prefix, each client has its own bucket.
+	assert.Equal(t, rrlAllow, rl.check(&net.UDPAddr{IP: net.ParseIP("198.51.100.2"), Port: 1234}))
+}
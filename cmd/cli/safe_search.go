@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// safeSearchCNAMETTL is the TTL of CNAME record pointing to the safe search host.
+const safeSearchCNAMETTL = 300
+
+// safeSearchHosts maps search engine hosts to their safe search hosts.
+// Google hosts are handled separately, since they are present in many country TLDs.
+var safeSearchHosts = map[string]string{
+	// Bing SafeSearch.
+	"bing.com":     "strict.bing.com",
+	"www.bing.com": "strict.bing.com",
+	// YouTube Restricted Mode.
+	"www.youtube.com":          "restrict.youtube.com",
+	"m.youtube.com":            "restrict.youtube.com",
+	"youtubei.googleapis.com":  "restrict.youtube.com",
+	"youtube.googleapis.com":   "restrict.youtube.com",
+	"www.youtube-nocookie.com": "restrict.youtube.com",
+	// DuckDuckGo safe mode.
+	"duckduckgo.com":       "safe.duckduckgo.com",
+	"www.duckduckgo.com":   "safe.duckduckgo.com",
+	"start.duckduckgo.com": "safe.duckduckgo.com",
+}
+
+// safeSearchGoogleHost is the Google SafeSearch host.
+const safeSearchGoogleHost = "forcesafesearch.google.com"
+
+// safeSearchTarget returns the safe search host which query for domain must be rewritten to,
+// or empty string if domain is not a search engine, or qtype is not an address query.
+func safeSearchTarget(domain string, qtype uint16) string {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS:
+	default:
+		return ""
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if target, ok := safeSearchHosts[domain]; ok {
+		return target
+	}
+	// Google search in any country TLDs, e.g: google.com, www.google.co.uk.
+	if suffix, icann := publicsuffix.PublicSuffix(domain); icann {
+		switch strings.TrimSuffix(domain, "."+suffix) {
+		case "google", "www.google":
+			return safeSearchGoogleHost
+		}
+	}
+	return ""
+}
+
+// safeSearchEnabled reports whether safe search is enforced for client with given ip. The setting of
+// the most specific network containing ip is used, falling back to the global setting.
+func (p *prog) safeSearchEnabled(ip string) bool {
+	enabled := p.cfg.Service.SafeSearch
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return enabled
+	}
+	bestPrefix := -1
+	for _, nc := range p.cfg.Network {
+		if nc.SafeSearch == nil {
+			continue
+		}
+		for _, ipNet := range nc.IPNets {
+			if !ipNet.Contains(clientIP) {
+				continue
+			}
+			if ones, _ := ipNet.Mask.Size(); ones > bestPrefix {
+				bestPrefix = ones
+				enabled = *nc.SafeSearch
+			}
+		}
+	}
+	return enabled
+}
+
+// safeSearchRequest returns a copy of msg, with the question rewritten to target.
+func safeSearchRequest(msg *dns.Msg, target string) *dns.Msg {
+	req := msg.Copy()
+	req.Question[0].Name = dns.Fqdn(target)
+	return req
+}
+
+// safeSearchAnswer returns a copy of answer for the rewritten request, as an answer of the original
+// request msg, with a CNAME record pointing to target. The answer itself is left untouched, since
+// it may be shared with the cache.
+func safeSearchAnswer(msg, answer *dns.Msg, target string) *dns.Msg {
+	res := answer.Copy()
+	res.Question = append([]dns.Question(nil), msg.Question...)
+	if res.Rcode != dns.RcodeSuccess {
+		return res
+	}
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   msg.Question[0].Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    safeSearchCNAMETTL,
+		},
+		Target: dns.Fqdn(target),
+	}
+	res.Answer = append([]dns.RR{cname}, res.Answer...)
+	return res
+}
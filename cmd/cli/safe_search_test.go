@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_safeSearchTarget(t *testing.T) {
+	tests := []struct {
+		domain string
+		qtype  uint16
+		target string
+	}{
+		{"www.google.com.", dns.TypeA, safeSearchGoogleHost},
+		{"google.co.uk", dns.TypeAAAA, safeSearchGoogleHost},
+		{"www.google.de", dns.TypeHTTPS, safeSearchGoogleHost},
+		{"mail.google.com", dns.TypeA, ""},
+		{"www.google.com", dns.TypeMX, ""},
+		{"www.bing.com", dns.TypeA, "strict.bing.com"},
+		{"WWW.YouTube.com.", dns.TypeA, "restrict.youtube.com"},
+		{"duckduckgo.com", dns.TypeA, "safe.duckduckgo.com"},
+		{"example.com", dns.TypeA, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			assert.Equal(t, tc.target, safeSearchTarget(tc.domain, tc.qtype))
+		})
+	}
+}
+
+func Test_prog_safeSearchEnabled(t *testing.T) {
+	mustIPNet := func(cidr string) *net.IPNet {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		return ipNet
+	}
+	enabled, disabled := true, false
+	p := &prog{cfg: &ctrld.Config{
+		Service: ctrld.ServiceConfig{SafeSearch: true},
+		Network: map[string]*ctrld.NetworkConfig{
+			"0": {IPNets: []*net.IPNet{mustIPNet("0.0.0.0/0")}},
+			"1": {IPNets: []*net.IPNet{mustIPNet("192.168.0.0/16")}, SafeSearch: &disabled},
+			"2": {IPNets: []*net.IPNet{mustIPNet("192.168.1.0/24")}, SafeSearch: &enabled},
+		},
+	}}
+	assert.True(t, p.safeSearchEnabled("10.0.0.1"))
+	assert.False(t, p.safeSearchEnabled("192.168.2.1"))
+	assert.True(t, p.safeSearchEnabled("192.168.1.1"))
+	assert.True(t, p.safeSearchEnabled(""))
+}
+
+func Test_safeSearchAnswer(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.google.com.", dns.TypeA)
+	req := safeSearchRequest(msg, safeSearchGoogleHost)
+	assert.Equal(t, "www.google.com.", msg.Question[0].Name)
+	assert.Equal(t, "forcesafesearch.google.com.", req.Question[0].Name)
+
+	answer := new(dns.Msg)
+	answer.SetReply(req)
+	rr, err := dns.NewRR("forcesafesearch.google.com. 60 IN A 216.239.38.120")
+	require.NoError(t, err)
+	answer.Answer = append(answer.Answer, rr)
+
+	res := safeSearchAnswer(msg, answer, safeSearchGoogleHost)
+	assert.Equal(t, msg.Question, res.Question)
+	require.Len(t, res.Answer, 2)
+	cname, ok := res.Answer[0].(*dns.CNAME)
+	require.True(t, ok)
+	assert.Equal(t, "www.google.com.", cname.Hdr.Name)
+	assert.Equal(t, "forcesafesearch.google.com.", cname.Target)
+	assert.Equal(t, rr, res.Answer[1])
+	// The original answer is untouched.
+	assert.Len(t, answer.Answer, 1)
+	assert.Equal(t, "forcesafesearch.google.com.", answer.Question[0].Name)
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kardianos/service"
+)
+
+const (
+	// secretRefPrefix is the prefix of flag values referencing a secret in the secret store,
+	// e.g: "--cd=secret:cd_uid".
+	secretRefPrefix = "secret:"
+	// cdUIDSecretName is the name of the secret storing the Control D resolver uid.
+	cdUIDSecretName = "cd_uid"
+	// cdProfilesSecretName is the name of the secret storing resolver uids of Control D profiles,
+	// one "name=uid" per line.
+	cdProfilesSecretName = "cd_profiles"
+	// cdUIDPlaceholder replaces the Control D resolver uid in the config file,
+	// when the uid is kept in the secret store.
+	cdUIDPlaceholder = "{" + cdUIDSecretName + "}"
+)
+
+// errSecretStoreUnsupported is returned when there's no secret store on the current platform.
+var errSecretStoreUnsupported = errors.New("secret store is not supported on this platform")
+
+// cdSecretUIDs are the Control D resolver uids kept in the secret store, including the ones of profiles.
+var cdSecretUIDs []string
+
+// secretStore stores secrets using OS facilities, so they do not appear in plaintext
+// in config files or service definitions.
+type secretStore interface {
+	// get returns the secret with given name.
+	get(name string) (string, error)
+	// set stores value as the secret with given name, replacing existing one.
+	set(name, value string) error
+	// remove removes the secret with given name.
+	remove(name string) error
+}
+
+// secretRef returns the flag value referencing the secret with given name.
+func secretRef(name string) string {
+	return secretRefPrefix + name
+}
+
+// secretFilePath returns the path of the file storing the encrypted secret with given name.
+func secretFilePath(name string) string {
+	return absHomeDir(name + ".cred")
+}
+
+// resolveSecretRef returns the secret referenced by value, or value itself if it's not a reference.
+func resolveSecretRef(store secretStore, value string) (string, error) {
+	name, ok := strings.CutPrefix(value, secretRefPrefix)
+	if !ok {
+		return value, nil
+	}
+	if store == nil {
+		return "", errSecretStoreUnsupported
+	}
+	secret, err := store.get(name)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret %q: %w", name, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("secret %q is empty", name)
+	}
+	return secret, nil
+}
+
+// resolveCdSecret replaces the Control D resolver uids referencing the secret store, the one of
+// --cd flag and the ones of --cd-profiles flag, with their secrets.
+func resolveCdSecret() error {
+	refs := strings.HasPrefix(cdUID, secretRefPrefix)
+	for _, value := range cdProfiles {
+		refs = refs || strings.HasPrefix(value, secretRefPrefix)
+	}
+	if !refs {
+		return nil
+	}
+	store, _ := newSecretStore()
+	if strings.HasPrefix(cdUID, secretRefPrefix) {
+		uid, err := resolveSecretRef(store, cdUID)
+		if err != nil {
+			return err
+		}
+		cdUID = uid
+		cdSecretUIDs = append(cdSecretUIDs, uid)
+	}
+	return resolveCdProfilesSecret(store)
+}
+
+// resolveCdProfilesSecret replaces resolver uids of Control D profiles referencing the secret store with their secrets.
+func resolveCdProfilesSecret(store secretStore) error {
+	// Profiles referencing the same secret are resolved using a single read.
+	secrets := make(map[string]map[string]string)
+	for name, value := range cdProfiles {
+		secretName, ok := strings.CutPrefix(value, secretRefPrefix)
+		if !ok {
+			continue
+		}
+		uids, ok := secrets[secretName]
+		if !ok {
+			secret, err := resolveSecretRef(store, value)
+			if err != nil {
+				return err
+			}
+			uids = parseCdProfilesSecret(secret)
+			secrets[secretName] = uids
+		}
+		uid := uids[name]
+		if uid == "" {
+			return fmt.Errorf("profile %q not found in secret %q", name, secretName)
+		}
+		cdProfiles[name] = uid
+		cdSecretUIDs = append(cdSecretUIDs, uid)
+	}
+	return nil
+}
+
+// parseCdProfilesSecret parses the secret storing resolver uids of Control D profiles, keyed by profile names.
+func parseCdProfilesSecret(secret string) map[string]string {
+	uids := make(map[string]string)
+	for _, line := range strings.Split(secret, "\n") {
+		name, uid, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && name != "" {
+			uids[name] = uid
+		}
+	}
+	return uids
+}
+
+// storeCdSecret stores the Control D resolver uid in the secret store, replacing
+// its value in arguments of service config with a reference to the secret.
+func storeCdSecret(store secretStore, sc *service.Config, uid string) error {
+	if err := store.set(cdUIDSecretName, uid); err != nil {
+		return fmt.Errorf("could not store resolver uid: %w", err)
+	}
+	sc.Arguments = removeFlagsFromArgs(sc.Arguments, cdUidFlagName)
+	sc.Arguments = append(sc.Arguments, "--"+cdUidFlagName+"="+secretRef(cdUIDSecretName))
+	cdSecretUIDs = append(cdSecretUIDs, uid)
+	return nil
+}
+
+// storeCdProfilesSecret stores resolver uids of Control D profiles in the secret store, replacing
+// them in arguments of service config with references to the secret.
+func storeCdProfilesSecret(store secretStore, sc *service.Config, profiles map[string]string) error {
+	if len(profiles) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	lines := make([]string, 0, len(names))
+	refs := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, name+"="+profiles[name])
+		refs = append(refs, name+"="+secretRef(cdProfilesSecretName))
+	}
+	if err := store.set(cdProfilesSecretName, strings.Join(lines, "\n")); err != nil {
+		return fmt.Errorf("could not store resolver uids of profiles: %w", err)
+	}
+	sc.Arguments = removeFlagsFromArgs(sc.Arguments, cdProfilesFlagName)
+	sc.Arguments = append(sc.Arguments, "--"+cdProfilesFlagName+"="+strings.Join(refs, ","))
+	for _, name := range names {
+		cdSecretUIDs = append(cdSecretUIDs, profiles[name])
+	}
+	return nil
+}
+
+// removeSecretStoreFlagFromArgs removes "--secret-store" flag from arguments of service config,
+// since it's only meaningful for "ctrld start" command.
+func removeSecretStoreFlagFromArgs(sc *service.Config) {
+	sc.Arguments = slices.DeleteFunc(sc.Arguments, func(arg string) bool {
+		return arg == "--"+secretStoreFlagName || strings.HasPrefix(arg, "--"+secretStoreFlagName+"=")
+	})
+}
+
+// removeCdSecret removes the Control D resolver uids from the secret store, if any.
+func removeCdSecret() {
+	if store, err := newSecretStore(); err == nil {
+		_ = store.remove(cdUIDSecretName)
+		_ = store.remove(cdProfilesSecretName)
+	}
+}
+
+// isCdSecretUID reports whether the Control D resolver uid is kept in the secret store.
+func isCdSecretUID(uid string) bool {
+	return uid != "" && slices.Contains(cdSecretUIDs, uid)
+}
+
+// redactCdUID replaces the Control D resolver uid in config file content with a placeholder,
+// if the uid is kept in the secret store. The config is re-generated from Control D API
+// at startup in cd mode, so the uid is not needed in the config file.
+func redactCdUID(content string) string {
+	for _, uid := range cdSecretUIDs {
+		if uid != "" {
+			content = strings.ReplaceAll(content, uid, cdUIDPlaceholder)
+		}
+	}
+	return content
+}
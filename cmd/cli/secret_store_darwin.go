@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// keychainService is the Keychain service which secrets are stored under.
+	keychainService = "ctrld"
+	// systemKeychain is the Keychain used for storing secrets, readable by ctrld
+	// service running as root.
+	systemKeychain = "/Library/Keychains/System.keychain"
+)
+
+// keychainSecretStore is the secret store using macOS System Keychain.
+type keychainSecretStore struct{}
+
+// newSecretStore returns the secret store of the current platform.
+func newSecretStore() (secretStore, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errSecretStoreUnsupported
+	}
+	return keychainSecretStore{}, nil
+}
+
+func (keychainSecretStore) get(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w", systemKeychain).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (ks keychainSecretStore) set(name, value string) error {
+	// Use interactive mode, so the secret is passed via stdin, instead of appearing in process list.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q %q\n", keychainService, name, value, systemKeychain))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err)
+	}
+	// In interactive mode, security exits successfully even if the command failed.
+	if _, err := ks.get(name); err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func (keychainSecretStore) remove(name string) error {
+	return exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", name, systemKeychain).Run()
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// systemdCredsSecretStore is the secret store using systemd-creds, which encrypts secrets
+// with the TPM2 chip if available, and the host key otherwise.
+type systemdCredsSecretStore struct{}
+
+// newSecretStore returns the secret store of the current platform.
+func newSecretStore() (secretStore, error) {
+	if _, err := exec.LookPath("systemd-creds"); err != nil {
+		return nil, errSecretStoreUnsupported
+	}
+	return systemdCredsSecretStore{}, nil
+}
+
+func (systemdCredsSecretStore) get(name string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("systemd-creds", "decrypt", "--name="+name, secretFilePath(name), "-")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (systemdCredsSecretStore) set(name, value string) error {
+	cmd := exec.Command("systemd-creds", "encrypt", "--name="+name, "-", secretFilePath(name))
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func (systemdCredsSecretStore) remove(name string) error {
+	if err := os.Remove(secretFilePath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
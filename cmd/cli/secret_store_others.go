@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package cli
+
+// newSecretStore returns the secret store of the current platform.
+func newSecretStore() (secretStore, error) {
+	return nil, errSecretStoreUnsupported
+}
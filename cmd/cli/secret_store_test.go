@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kardianos/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memSecretStore map[string]string
+
+func (m memSecretStore) get(name string) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func (m memSecretStore) set(name, value string) error {
+	m[name] = value
+	return nil
+}
+
+func (m memSecretStore) remove(name string) error {
+	delete(m, name)
+	return nil
+}
+
+func Test_resolveSecretRef(t *testing.T) {
+	store := memSecretStore{cdUIDSecretName: "abcd1234"}
+	tests := []struct {
+		name     string
+		store    secretStore
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"plain value", store, "abcd1234", "abcd1234", false},
+		{"reference", store, "secret:cd_uid", "abcd1234", false},
+		{"missing secret", store, "secret:foo", "", true},
+		{"unsupported store", nil, "secret:cd_uid", "", true},
+		{"plain value without store", nil, "abcd1234", "abcd1234", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveSecretRef(tc.store, tc.value)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func Test_storeCdSecret(t *testing.T) {
+	t.Cleanup(func() { cdSecretUIDs = nil })
+	store := memSecretStore{}
+	sc := &service.Config{Arguments: []string{"run", "--cd", "abcd1234", "--secret-store", "--iface=auto"}}
+	removeSecretStoreFlagFromArgs(sc)
+	require.NoError(t, storeCdSecret(store, sc, "abcd1234"))
+	assert.Equal(t, []string{"run", "--iface=auto", "--cd=secret:cd_uid"}, sc.Arguments)
+	assert.Equal(t, "abcd1234", store[cdUIDSecretName])
+
+	content := `endpoint = "https://dns.controld.com/abcd1234"`
+	assert.Equal(t, `endpoint = "https://dns.controld.com/{cd_uid}"`, redactCdUID(content))
+	cdSecretUIDs = nil
+	assert.Equal(t, content, redactCdUID(content))
+}
+
+func Test_storeCdProfilesSecret(t *testing.T) {
+	oldProfiles := cdProfiles
+	t.Cleanup(func() {
+		cdSecretUIDs = nil
+		cdProfiles = oldProfiles
+	})
+	store := memSecretStore{}
+	sc := &service.Config{Arguments: []string{"run", "--cd-profiles", "work=uid1,kids=uid2", "--iface=auto"}}
+	require.NoError(t, storeCdProfilesSecret(store, sc, map[string]string{"work": "uid1", "kids": "uid2"}))
+	assert.Equal(t, []string{"run", "--iface=auto", "--cd-profiles=kids=secret:cd_profiles,work=secret:cd_profiles"}, sc.Arguments)
+	assert.Equal(t, "kids=uid2\nwork=uid1", store[cdProfilesSecretName])
+	assert.True(t, isCdSecretUID("uid1"))
+	assert.True(t, isCdSecretUID("uid2"))
+
+	cdSecretUIDs = nil
+	cdProfiles = map[string]string{"work": "secret:cd_profiles", "kids": "secret:cd_profiles", "guest": "uid3"}
+	require.NoError(t, resolveCdProfilesSecret(store))
+	assert.Equal(t, map[string]string{"work": "uid1", "kids": "uid2", "guest": "uid3"}, cdProfiles)
+	assert.True(t, isCdSecretUID("uid1"))
+	assert.False(t, isCdSecretUID("uid3"))
+
+	cdProfiles = map[string]string{"unknown": "secret:cd_profiles"}
+	assert.Error(t, resolveCdProfilesSecret(store))
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiSecretStore is the secret store using Windows Data Protection API. Secrets are encrypted
+// with the machine key, so they could be decrypted by ctrld service running as LocalSystem.
+// Since any local user could decrypt data protected with the machine key, secret files are
+// only accessible by LocalSystem and Administrators.
+type dpapiSecretStore struct{}
+
+// newSecretStore returns the secret store of the current platform.
+func newSecretStore() (secretStore, error) {
+	return dpapiSecretStore{}, nil
+}
+
+func (dpapiSecretStore) get(name string) (string, error) {
+	buf, err := os.ReadFile(secretFilePath(name))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) == 0 {
+		return "", nil
+	}
+	in := windows.DataBlob{Size: uint32(len(buf)), Data: &buf[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return "", err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return string(unsafe.Slice(out.Data, out.Size)), nil
+}
+
+func (dpapiSecretStore) set(name, value string) error {
+	if value == "" {
+		return errors.New("empty secret")
+	}
+	buf := []byte(value)
+	in := windows.DataBlob{Size: uint32(len(buf)), Data: &buf[0]}
+	var out windows.DataBlob
+	flags := uint32(windows.CRYPTPROTECT_UI_FORBIDDEN | windows.CRYPTPROTECT_LOCAL_MACHINE)
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, flags, &out); err != nil {
+		return err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return writePrivateFile(secretFilePath(name), unsafe.Slice(out.Data, out.Size))
+}
+
+func (dpapiSecretStore) remove(name string) error {
+	if err := os.Remove(secretFilePath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
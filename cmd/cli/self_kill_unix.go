@@ -32,14 +32,14 @@ func selfUninstall(p *prog, logger zerolog.Logger) {
 	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	logger.Warn().Msgf("service was uninstalled because device %q does not exist", cdUID)
+	logger.Warn().Msgf("service was uninstalled because device %q does not exist", loadCdUID())
 	_ = cmd.Wait()
 	os.Exit(0)
 }
 
 func selfUninstallLinux(p *prog, logger zerolog.Logger) {
 	if uninstallInvalidCdUID(p, logger, true) {
-		logger.Warn().Msgf("service was uninstalled because device %q does not exist", cdUID)
+		logger.Warn().Msgf("service was uninstalled because device %q does not exist", loadCdUID())
 		os.Exit(0)
 	}
 }
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// servfailCacheMaxSize is the maximum number of failures cached, so a flood of random
+	// broken domains could not exhaust memory. Failures are not cached above it.
+	servfailCacheMaxSize = 4096
+	// upstreamServfailCache is the upstream of queries answered from cached upstream failures.
+	upstreamServfailCache = "servfail_cache"
+)
+
+// servfailCache remembers queries which upstreams failed to answer, for a short time, so
+// retries of clients for broken domains are answered SERVFAIL without hammering upstreams.
+type servfailCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu sync.Mutex
+	m  map[string]time.Time
+}
+
+// newServfailCache returns the cache of upstream failures, or nil if it is disabled.
+func newServfailCache(sc *ctrld.ServiceConfig) *servfailCache {
+	if sc.CacheServfailTTL == nil || *sc.CacheServfailTTL <= 0 {
+		return nil
+	}
+	return &servfailCache{ttl: *sc.CacheServfailTTL, now: time.Now, m: make(map[string]time.Time)}
+}
+
+// servfailCacheKey returns the key of failures of query msg sent to given upstreams.
+//
+// The CD and DO bits are part of the key, validating resolvers answer SERVFAIL for bogus DNSSEC answers,
+// so clients could retry with checking disabled, which must not be answered by the cached failure.
+func servfailCacheKey(msg *dns.Msg, upstreams []string) string {
+	q := msg.Question[0]
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(q.Name))
+	sb.WriteByte('|')
+	sb.WriteString(strconv.Itoa(int(q.Qtype)))
+	sb.WriteByte('|')
+	sb.WriteString(strconv.Itoa(int(q.Qclass)))
+	sb.WriteByte('|')
+	if msg.CheckingDisabled {
+		sb.WriteString("cd")
+	}
+	sb.WriteByte('|')
+	if opt := msg.IsEdns0(); opt != nil && opt.Do() {
+		sb.WriteString("do")
+	}
+	sb.WriteByte('|')
+	sb.WriteString(strings.Join(upstreams, ","))
+	return sb.String()
+}
+
+// add caches the failure of key.
+func (sc *servfailCache) add(key string) {
+	if sc == nil {
+		return
+	}
+	now := sc.now()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.m) >= servfailCacheMaxSize {
+		for k, expire := range sc.m {
+			if !now.Before(expire) {
+				delete(sc.m, k)
+			}
+		}
+		if len(sc.m) >= servfailCacheMaxSize {
+			return
+		}
+	}
+	sc.m[key] = now.Add(sc.ttl)
+}
+
+// failed reports whether the failure of key is cached and not expired.
+func (sc *servfailCache) failed(key string) bool {
+	if sc == nil {
+		return false
+	}
+	now := sc.now()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	expire, ok := sc.m[key]
+	if !ok {
+		return false
+	}
+	if !now.Before(expire) {
+		delete(sc.m, key)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_servfailCache(t *testing.T) {
+	assert.Nil(t, newServfailCache(&ctrld.ServiceConfig{}))
+	ttl := 5 * time.Second
+	sc := newServfailCache(&ctrld.ServiceConfig{CacheServfailTTL: &ttl})
+	require.NotNil(t, sc)
+	now := time.Now()
+	sc.now = func() time.Time { return now }
+
+	msg := newDnsMsgWithHostname("Broken.Example.com", dns.TypeA)
+	key := servfailCacheKey(msg, []string{"upstream.0"})
+	assert.False(t, sc.failed(key))
+	sc.add(key)
+	assert.True(t, sc.failed(key))
+	assert.True(t, sc.failed(servfailCacheKey(newDnsMsgWithHostname("broken.example.com", dns.TypeA), []string{"upstream.0"})))
+	// Other query types, or upstreams, are not affected.
+	assert.False(t, sc.failed(servfailCacheKey(newDnsMsgWithHostname("broken.example.com", dns.TypeAAAA), []string{"upstream.0"})))
+	assert.False(t, sc.failed(servfailCacheKey(msg, []string{"upstream.1"})))
+
+	// Retrying with checking disabled, or DNSSEC records requested, is not affected.
+	cd := newDnsMsgWithHostname("broken.example.com", dns.TypeA)
+	cd.CheckingDisabled = true
+	assert.False(t, sc.failed(servfailCacheKey(cd, []string{"upstream.0"})))
+	do := newDnsMsgWithHostname("broken.example.com", dns.TypeA)
+	do.SetEdns0(1232, true)
+	assert.False(t, sc.failed(servfailCacheKey(do, []string{"upstream.0"})))
+	sc.add(servfailCacheKey(cd, []string{"upstream.0"}))
+	assert.True(t, sc.failed(servfailCacheKey(cd, []string{"upstream.0"})))
+
+	now = now.Add(ttl)
+	assert.False(t, sc.failed(key))
+
+	// Failures are not cached when the cache is full of unexpired ones.
+	for i := 0; i < servfailCacheMaxSize; i++ {
+		sc.add(strconv.Itoa(i))
+	}
+	sc.add(key)
+	assert.False(t, sc.failed(key))
+	now = now.Add(ttl)
+	sc.add(key)
+	assert.True(t, sc.failed(key))
+
+	var nilCache *servfailCache
+	nilCache.add(key)
+	assert.False(t, nilCache.failed(key))
+}
+
+func TestProxyServfailCache(t *testing.T) {
+	var queries atomic.Int32
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		queries.Add(1)
+		answer := new(dns.Msg)
+		answer.SetRcode(m, dns.RcodeServerFailure)
+		_ = w.WriteMsg(answer)
+	})}
+	go s.ActivateAndServe()
+	defer s.Shutdown()
+
+	ttl := time.Minute
+	uc := &ctrld.UpstreamConfig{Name: "broken", Type: ctrld.ResolverTypeLegacy, Endpoint: pc.LocalAddr().String(), Timeout: 1000}
+	uc.Init()
+	cfg := &ctrld.Config{
+		Service:  ctrld.ServiceConfig{CacheServfailTTL: &ttl},
+		Upstream: map[string]*ctrld.UpstreamConfig{"0": uc},
+	}
+	p := &prog{cfg: cfg, um: newUpstreamMonitor(cfg), ul: newUpstreamLatency(), servfailCache: newServfailCache(&cfg.Service)}
+
+	proxy := func(cd bool) *proxyResponse {
+		msg := newDnsMsgWithHostname("broken.example.com.", dns.TypeA)
+		msg.CheckingDisabled = cd
+		return p.proxy(context.Background(), &proxyRequest{
+			msg:        msg,
+			osResolver: ctrld.OsResolverFallbackNever,
+			ufr:        &upstreamForResult{upstreams: []string{"upstream.0"}, matched: true},
+		})
+	}
+	res := proxy(false)
+	require.NotNil(t, res.answer)
+	assert.Equal(t, dns.RcodeServerFailure, res.answer.Rcode)
+	res = proxy(false)
+	require.NotNil(t, res.answer)
+	assert.Equal(t, dns.RcodeServerFailure, res.answer.Rcode)
+	assert.Equal(t, upstreamServfailCache, res.upstream)
+	assert.EqualValues(t, 1, queries.Load())
+
+	// Retrying with checking disabled is sent to the upstream.
+	res = proxy(true)
+	require.NotNil(t, res.answer)
+	assert.NotEqual(t, upstreamServfailCache, res.upstream)
+	assert.EqualValues(t, 2, queries.Load())
+}
@@ -30,6 +30,8 @@ func newService(i service.Interface, c *service.Config) (service.Service, error)
 		return &systemd{s}, nil
 	case s.Platform() == "darwin-launchd":
 		return newLaunchd(s), nil
+	case s.Platform() == smfPlatform:
+		return &smf{Service: s, svcConfig: c}, nil
 
 	}
 	return s, nil
@@ -13,3 +13,8 @@ func hasElevatedPrivilege() (bool, error) {
 func openLogFile(path string, flags int) (*os.File, error) {
 	return os.OpenFile(path, flags, os.FileMode(0o600))
 }
+
+// setServiceRecovery is a no-op on non-Windows platforms.
+func setServiceRecovery(name string) error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+
+	"github.com/kardianos/service"
+)
+
+const (
+	// smfPlatform is the platform name of kardianos/service on illumos/Solaris.
+	smfPlatform = "solaris-smf"
+	// smfManifestDir is the directory containing ctrld SMF manifest. Manifests in this directory
+	// are imported again by svc:/system/manifest-import at boot.
+	smfManifestDir = "/lib/svc/manifest/application"
+)
+
+// smf wraps a service.Service, and provides install/uninstall/start/stop/status commands
+// using SMF manifest and commands, since the manifest generated by kardianos/service
+// does not run ctrld with its arguments.
+type smf struct {
+	service.Service
+	svcConfig *service.Config
+}
+
+func (s *smf) fmri() string {
+	return "svc:/application/" + s.svcConfig.Name + ":default"
+}
+
+func (s *smf) manifestPath() string {
+	return filepath.Join(smfManifestDir, s.svcConfig.Name+".xml")
+}
+
+// state returns the SMF state of ctrld service, like "online", "offline", "maintenance" ...
+func (s *smf) state() (string, error) {
+	out, err := exec.Command("svcs", "-H", "-o", "state", s.fmri()).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+func (s *smf) Install() error {
+	manifestPath := s.manifestPath()
+	if _, err := os.Stat(manifestPath); err == nil {
+		return fmt.Errorf("already installed: %s", manifestPath)
+	}
+	exePath := s.svcConfig.Executable
+	if exePath == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		exePath = exe
+	}
+	var to = &struct {
+		*service.Config
+		Path string
+	}{
+		s.svcConfig,
+		exePath,
+	}
+	var buf bytes.Buffer
+	if err := template.Must(template.New("").Parse(smfManifest)).Execute(&buf, to); err != nil {
+		return fmt.Errorf("template.Execute: %w", err)
+	}
+	if err := os.MkdirAll(smfManifestDir, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	if err := os.WriteFile(manifestPath, buf.Bytes(), 0444); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	if out, err := exec.Command("svccfg", "import", manifestPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("svccfg import: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (s *smf) Uninstall() error {
+	_ = exec.Command("svcadm", "disable", "-s", s.fmri()).Run()
+	_ = exec.Command("svccfg", "delete", "-f", s.fmri()).Run()
+	if err := os.Remove(s.manifestPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	return nil
+}
+
+func (s *smf) Start() error {
+	state, err := s.state()
+	if err != nil {
+		return service.ErrNotInstalled
+	}
+	// Service in maintenance state must be cleared before it could be started again.
+	if state == "maintenance" {
+		_ = exec.Command("svcadm", "clear", s.fmri()).Run()
+	}
+	return exec.Command("svcadm", "enable", s.fmri()).Run()
+}
+
+func (s *smf) Stop() error {
+	if _, err := s.state(); err != nil {
+		return service.ErrNotInstalled
+	}
+	return exec.Command("svcadm", "disable", "-s", s.fmri()).Run()
+}
+
+func (s *smf) Restart() error {
+	// We don't care about error returned by s.Stop,
+	// because the service may already be stopped.
+	_ = s.Stop()
+	return s.Start()
+}
+
+func (s *smf) Status() (service.Status, error) {
+	state, err := s.state()
+	if err != nil {
+		return service.StatusUnknown, service.ErrNotInstalled
+	}
+	if state == "online" {
+		return service.StatusRunning, nil
+	}
+	return service.StatusStopped, nil
+}
+
+// smfManifest is the SMF manifest of ctrld service. The start method runs ctrld in background,
+// so svc.startd(8) tracks it using process contract, restarting it if it exits.
+// See: https://illumos.org/man/7/smf_method
+const smfManifest = `<?xml version="1.0"?>
+<!DOCTYPE service_bundle SYSTEM "/usr/share/lib/xml/dtd/service_bundle.dtd.1">
+<service_bundle type="manifest" name="{{.Name}}">
+	<service name="application/{{.Name}}" type="service" version="1">
+		<create_default_instance enabled="false"/>
+		<single_instance/>
+		<dependency name="network" grouping="require_all" restart_on="error" type="service">
+			<service_fmri value="svc:/milestone/network:default"/>
+		</dependency>
+		<dependency name="filesystem-local" grouping="require_all" restart_on="none" type="service">
+			<service_fmri value="svc:/system/filesystem/local:default"/>
+		</dependency>
+		<method_context{{if .WorkingDirectory}} working_directory="{{html .WorkingDirectory}}"{{end}}>
+			<method_credential user="root" group="root"/>
+		</method_context>
+		<exec_method type="method" name="start" exec="{{html .Path}}{{range .Arguments}} {{html .}}{{end}} &amp;" timeout_seconds="60"/>
+		<exec_method type="method" name="stop" exec=":kill" timeout_seconds="60"/>
+		<exec_method type="method" name="refresh" exec="{{html .Path}} reload" timeout_seconds="60"/>
+		<stability value="Unstable"/>
+		<template>
+			<common_name>
+				<loctext xml:lang="C">{{html .DisplayName}}</loctext>
+			</common_name>
+		</template>
+	</service>
+</service_bundle>
+`
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"text/template"
+
+	"github.com/kardianos/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_smfManifest(t *testing.T) {
+	var to = &struct {
+		*service.Config
+		Path string
+	}{
+		&service.Config{
+			Name:             "ctrld",
+			DisplayName:      "Control-D Helper Service",
+			Arguments:        []string{"run", "--config=/opt/ctrld/ctrld.toml", "--cd=<uid>"},
+			WorkingDirectory: "/opt/ctrld",
+		},
+		"/opt/ctrld/ctrld",
+	}
+	var buf bytes.Buffer
+	require.NoError(t, template.Must(template.New("").Parse(smfManifest)).Execute(&buf, to))
+
+	var manifest struct {
+		Service struct {
+			Name    string `xml:"name,attr"`
+			Methods []struct {
+				Name string `xml:"name,attr"`
+				Exec string `xml:"exec,attr"`
+			} `xml:"exec_method"`
+			MethodContext struct {
+				WorkingDirectory string `xml:"working_directory,attr"`
+			} `xml:"method_context"`
+		} `xml:"service"`
+	}
+	d := xml.NewDecoder(&buf)
+	d.Strict = false
+	require.NoError(t, d.Decode(&manifest))
+	assert.Equal(t, "application/ctrld", manifest.Service.Name)
+	assert.Equal(t, "/opt/ctrld", manifest.Service.MethodContext.WorkingDirectory)
+	require.Len(t, manifest.Service.Methods, 3)
+	assert.Equal(t, "/opt/ctrld/ctrld run --config=/opt/ctrld/ctrld.toml --cd=<uid> &", manifest.Service.Methods[0].Exec)
+}
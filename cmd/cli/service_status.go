@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/kardianos/service"
+)
+
+// Exit codes of status command. The first four follow Nagios plugin conventions (OK, WARNING, CRITICAL
+// and UNKNOWN), so monitoring systems, e.g: Nagios or Zabbix, could use "ctrld status" as a check directly.
+const (
+	// statusRunningExitCode indicates the service is running, and able to resolve queries.
+	statusRunningExitCode = 0
+	// statusDegradedExitCode indicates the service is running, but there's no healthy upstream,
+	// or its listeners were not bound yet.
+	statusDegradedExitCode = 1
+	// statusStoppedExitCode indicates the service is installed, but not running.
+	statusStoppedExitCode = 2
+	// statusUnknownExitCode indicates the service status could not be determined, e.g: the readiness
+	// of running service could not be read, because the command was run without privileges.
+	statusUnknownExitCode = 3
+	// statusNotInstalledExitCode indicates the service is not installed.
+	statusNotInstalledExitCode = 4
+)
+
+// statusExitCode returns the exit code of status command, given the service status, the error of
+// getting it, the readiness of running service, and the error of reading it. The readiness is nil
+// without error if the running service does not report it, e.g: older ctrld version.
+func statusExitCode(status service.Status, err error, hs *healthStatus, readyErr error) int {
+	switch {
+	case errors.Is(err, service.ErrNotInstalled):
+		return statusNotInstalledExitCode
+	case err != nil:
+		return statusUnknownExitCode
+	}
+	switch status {
+	case service.StatusRunning:
+		if readyErr != nil {
+			return statusUnknownExitCode
+		}
+		// Without readiness, the running service is assumed healthy.
+		if hs != nil && (!hs.ListenersBound || len(hs.HealthyUpstreams) == 0) {
+			return statusDegradedExitCode
+		}
+		return statusRunningExitCode
+	case service.StatusStopped:
+		return statusStoppedExitCode
+	}
+	return statusUnknownExitCode
+}
+
+// serviceReadiness returns the readiness of running ctrld service. It returns nil without error if
+// the service does not report its readiness, e.g: older ctrld version without readiness endpoint.
+func serviceReadiness() (*healthStatus, error) {
+	dir, err := socketDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not find control socket directory: %w", err)
+	}
+	cc := newControlClient(filepath.Join(dir, ctrldControlUnixSock))
+	resp, err := cc.post(readyzPath, nil)
+	if err != nil {
+		// The control socket is only accessible by root.
+		return nil, fmt.Errorf("could not connect to control server, try running as root/admin: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusServiceUnavailable:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected readiness response status: %s", resp.Status)
+	}
+	hs := &healthStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(hs); err != nil {
+		return nil, fmt.Errorf("failed to decode service readiness: %w", err)
+	}
+	return hs, nil
+}
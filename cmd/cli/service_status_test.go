@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kardianos/service"
+)
+
+func Test_statusExitCode(t *testing.T) {
+	ready := &healthStatus{ListenersBound: true, HealthyUpstreams: []string{"upstream.0"}}
+	tests := []struct {
+		name   string
+		status service.Status
+		err    error
+		hs     *healthStatus
+		hsErr  error
+		want   int
+	}{
+		{"running", service.StatusRunning, nil, ready, nil, statusRunningExitCode},
+		{"running without readiness", service.StatusRunning, nil, nil, nil, statusRunningExitCode},
+		{"no healthy upstream", service.StatusRunning, nil, &healthStatus{ListenersBound: true}, nil, statusDegradedExitCode},
+		{"listeners not bound", service.StatusRunning, nil, &healthStatus{HealthyUpstreams: []string{"upstream.0"}}, nil, statusDegradedExitCode},
+		{"readiness unreadable", service.StatusRunning, nil, nil, errors.New("permission denied"), statusUnknownExitCode},
+		{"stopped", service.StatusStopped, nil, nil, nil, statusStoppedExitCode},
+		{"not installed", service.StatusUnknown, service.ErrNotInstalled, nil, nil, statusNotInstalledExitCode},
+		{"error", service.StatusUnknown, errors.New("failed"), nil, nil, statusUnknownExitCode},
+		{"unknown", service.StatusUnknown, nil, nil, nil, statusUnknownExitCode},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := statusExitCode(tc.status, tc.err, tc.hs, tc.hsErr); got != tc.want {
+				t.Errorf("unexpected exit code, want: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
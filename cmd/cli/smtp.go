@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	smtpTimeout = 30 * time.Second
+	// smtpsPort is the port of SMTP servers using implicit TLS.
+	smtpsPort = "465"
+)
+
+// Possible values of "smtp_tls".
+const (
+	smtpTLSImplicit = "tls"
+	smtpTLSStartTLS = "starttls"
+)
+
+// smtpSender sends emails using an SMTP server, always over TLS: either implicit TLS, or STARTTLS,
+// which the server must support, so credentials and alerts are never sent in plaintext.
+type smtpSender struct {
+	server   string
+	host     string
+	tlsMode  string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// newSMTPSender returns the smtpSender of given service config. Implicit TLS is used for port 465,
+// and STARTTLS for other ports, unless "smtp_tls" is set.
+func newSMTPSender(cfg *ctrld.ServiceConfig) *smtpSender {
+	host, port, _ := net.SplitHostPort(cfg.SMTPServer)
+	s := &smtpSender{
+		server:   cfg.SMTPServer,
+		host:     host,
+		tlsMode:  cfg.SMTPTLS,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+		to:       cfg.SMTPTo,
+	}
+	if s.tlsMode == "" {
+		s.tlsMode = smtpTLSStartTLS
+		if port == smtpsPort {
+			s.tlsMode = smtpTLSImplicit
+		}
+	}
+	return s
+}
+
+// send sends an email with given subject and body to all recipients.
+func (s *smtpSender) send(subject, body string) error {
+	tlsConfig := &tls.Config{ServerName: s.host, RootCAs: rootCertPool, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if s.tlsMode == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.server, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.server)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if s.tlsMode == smtpTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(subject, body, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message returns the email message of given subject and body.
+func (s *smtpSender) message(subject, body string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// smtpMessage returns the email subject and body of event ev.
+func smtpMessage(ev *alertEvent) (subject, body string) {
+	subject = "[ctrld] " + ev.Message
+	if ev.Instance != "" {
+		subject = fmt.Sprintf("[ctrld@%s] %s", ev.Instance, ev.Message)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n\n", ev.Message)
+	fmt.Fprintf(&sb, "Event: %s\n", ev.Event)
+	if ev.Instance != "" {
+		fmt.Fprintf(&sb, "Instance: %s\n", ev.Instance)
+	}
+	fmt.Fprintf(&sb, "Time: %s\n", ev.Time.Format(time.RFC3339))
+	if ev.Data != nil {
+		if b, err := json.MarshalIndent(ev.Data, "", "  "); err == nil {
+			fmt.Fprintf(&sb, "\n%s\n", b)
+		}
+	}
+	return subject, sb.String()
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_newSMTPSender(t *testing.T) {
+	tests := []struct {
+		server string
+		mode   string
+		want   string
+	}{
+		{"smtp.example.com:465", "", smtpTLSImplicit},
+		{"smtp.example.com:587", "", smtpTLSStartTLS},
+		{"smtp.example.com:25", "tls", smtpTLSImplicit},
+	}
+	for _, tc := range tests {
+		s := newSMTPSender(&ctrld.ServiceConfig{SMTPServer: tc.server, SMTPTLS: tc.mode})
+		assert.Equal(t, tc.want, s.tlsMode, tc.server)
+		assert.Equal(t, "smtp.example.com", s.host)
+	}
+}
+
+func Test_smtpSender_message(t *testing.T) {
+	s := newSMTPSender(&ctrld.ServiceConfig{
+		SMTPServer: "smtp.example.com:587",
+		SMTPFrom:   "ctrld@example.com",
+		SMTPTo:     []string{"admin@example.com", "ops@example.com"},
+	})
+	ev := &alertEvent{Event: alertEventConfigReloadFailed, Message: "invalid config: boom", Instance: "router", Time: time.Unix(0, 0).UTC(), Data: map[string]string{"error": "boom"}}
+	subject, body := smtpMessage(ev)
+	assert.Equal(t, "[ctrld@router] invalid config: boom", subject)
+	assert.Contains(t, body, "Event: config_reload_failed\n")
+	assert.Contains(t, body, `"error": "boom"`)
+
+	msg := string(s.message(subject, body, time.Unix(0, 0).UTC()))
+	assert.Contains(t, msg, "From: ctrld@example.com\r\n")
+	assert.Contains(t, msg, "To: admin@example.com, ops@example.com\r\n")
+	assert.Contains(t, msg, "Subject: [ctrld@router] invalid config: boom\r\n")
+	assert.Contains(t, msg, "\r\n\r\ninvalid config: boom\r\n")
+	assert.NotContains(t, strings.ReplaceAll(msg, "\r\n", ""), "\n")
+}
+
+func Test_smtpSender_sendRequiresTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		_, _ = conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				_, _ = conn.Write([]byte("250-smtp.example.com\r\n250 AUTH PLAIN\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				_, _ = conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				_, _ = conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+
+	s := newSMTPSender(&ctrld.ServiceConfig{
+		SMTPServer:   ln.Addr().String(),
+		SMTPUsername: "ctrld",
+		SMTPPassword: "secret",
+		SMTPFrom:     "ctrld@example.com",
+		SMTPTo:       []string{"admin@example.com"},
+	})
+	err = s.send("subject", "body")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+	// Credentials must never be sent without TLS.
+	for {
+		select {
+		case line := <-received:
+			assert.False(t, strings.HasPrefix(line, "AUTH"), line)
+			assert.False(t, strings.HasPrefix(line, "MAIL"), line)
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// mdnsZone is the zone reserved for mDNS, see RFC 6762.
+	mdnsZone = "local"
+	// homeArpaZone is the zone reserved for home networks, see RFC 8375.
+	homeArpaZone = "home.arpa"
+)
+
+// linkLocalReverseZones are reverse zones of IPv4 (169.254.0.0/16) and IPv6 (fe80::/10) link-local addresses.
+var linkLocalReverseZones = []string{
+	"254.169.in-addr.arpa",
+	"8.e.f.ip6.arpa",
+	"9.e.f.ip6.arpa",
+	"a.e.f.ip6.arpa",
+	"b.e.f.ip6.arpa",
+}
+
+// inZone reports whether domain is the zone itself, or a sub-domain of zone.
+func inZone(domain, zone string) bool {
+	return domain == zone || strings.HasSuffix(domain, "."+zone)
+}
+
+// specialUseDomain returns the special-use zone of domain, and the action configured for that zone.
+// The action is empty if domain is not in any special-use zone, or there's no action configured.
+func (p *prog) specialUseDomain(domain string) (string, string) {
+	svc := p.cfg.Service
+	switch {
+	case inZone(domain, mdnsZone):
+		return mdnsZone, svc.LocalDomainAction
+	case inZone(domain, homeArpaZone):
+		return homeArpaZone, svc.HomeArpaDomainAction
+	}
+	for _, zone := range linkLocalReverseZones {
+		if inZone(domain, zone) {
+			return zone, svc.LinkLocalPtrAction
+		}
+	}
+	return "", ""
+}
+
+// specialUseDomainAnswer returns the answer for special-use domain query, which is not forwarded to upstreams.
+// For "local" action, only the client info table is used, NXDOMAIN is returned if the name is not found there.
+func (p *prog) specialUseDomainAnswer(ctx context.Context, msg *dns.Msg, zone, action string) *dns.Msg {
+	if action == ctrld.SpecialUseDomainActionLocal {
+		q := msg.Question[0]
+		switch q.Qtype {
+		case dns.TypePTR:
+			if answer := p.proxyPrivatePtrLookup(ctx, msg); answer != nil {
+				return answer
+			}
+		case dns.TypeA, dns.TypeAAAA:
+			hostname := strings.TrimSuffix(canonicalName(q.Name), "."+zone)
+			if answer := p.lanHostnameAnswer(ctx, msg, hostname); answer != nil {
+				return answer
+			}
+		}
+	}
+	return newErrorAnswer(msg, dns.RcodeNameError, dns.ExtendedErrorCodeOther, "special-use domain is not forwarded")
+}
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_prog_specialUseDomain(t *testing.T) {
+	p := &prog{cfg: &ctrld.Config{Service: ctrld.ServiceConfig{
+		LocalDomainAction:    ctrld.SpecialUseDomainActionNxdomain,
+		HomeArpaDomainAction: ctrld.SpecialUseDomainActionLocal,
+		LinkLocalPtrAction:   "upstream.1",
+	}}}
+
+	tests := []struct {
+		domain     string
+		wantZone   string
+		wantAction string
+	}{
+		{"printer.local", mdnsZone, ctrld.SpecialUseDomainActionNxdomain},
+		{"local", mdnsZone, ctrld.SpecialUseDomainActionNxdomain},
+		{"nas.home.arpa", homeArpaZone, ctrld.SpecialUseDomainActionLocal},
+		{"1.0.254.169.in-addr.arpa", "254.169.in-addr.arpa", "upstream.1"},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa", "8.e.f.ip6.arpa", "upstream.1"},
+		{"notlocal", "", ""},
+		{"example.local.com", "", ""},
+		{"1.0.168.192.in-addr.arpa", "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			zone, action := p.specialUseDomain(tc.domain)
+			assert.Equal(t, tc.wantZone, zone)
+			assert.Equal(t, tc.wantAction, action)
+		})
+	}
+}
+
+func Test_prog_specialUseDomainAnswer(t *testing.T) {
+	p := &prog{lanLoopGuard: newLoopGuard(), ptrLoopGuard: newLoopGuard()}
+	for _, action := range []string{ctrld.SpecialUseDomainActionNxdomain, ctrld.SpecialUseDomainActionLocal} {
+		t.Run(action, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.SetQuestion("printer.local.", dns.TypeA)
+			answer := p.specialUseDomainAnswer(context.Background(), msg, mdnsZone, action)
+			assert.Equal(t, dns.RcodeNameError, answer.Rcode)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
+)
+
+const (
+	// threatFeedDefaultRefreshInterval is the default interval for refreshing threat feeds.
+	threatFeedDefaultRefreshInterval = time.Hour
+	// threatFeedRetryInterval is the interval for retrying threat feeds which could not be fetched.
+	threatFeedRetryInterval = time.Minute
+	// threatFeedFetchTimeout is the timeout for fetching a threat feed.
+	threatFeedFetchTimeout = time.Minute
+	// threatFeedMaxSize is the maximum size of a threat feed.
+	threatFeedMaxSize = 64 << 20
+)
+
+// threatFeed is a list of malicious domains, fetched from an URL.
+type threatFeed struct {
+	name    string
+	url     string
+	domains atomic.Pointer[map[string]struct{}]
+}
+
+// threatFeeds holds all subscribed threat feeds.
+type threatFeeds struct {
+	feeds    []*threatFeed
+	interval time.Duration
+	client   *http.Client
+}
+
+// newThreatFeeds returns threat feeds subscribed in given config, or nil if there's none. Domains of
+// feeds which are already fetched in old are kept, so queries are still blocked while refreshing.
+func newThreatFeeds(cfg *ctrld.Config, old *threatFeeds) *threatFeeds {
+	if len(cfg.Service.ThreatFeeds) == 0 {
+		return nil
+	}
+	tf := &threatFeeds{
+		interval: threatFeedDefaultRefreshInterval,
+		client:   newBootstrapHTTPClient(threatFeedFetchTimeout),
+	}
+	if d := cfg.Service.ThreatFeedRefreshInterval; d != nil && *d > 0 {
+		tf.interval = *d
+	}
+	for _, rawURL := range cfg.Service.ThreatFeeds {
+		f := &threatFeed{name: threatFeedName(rawURL), url: rawURL}
+		if old != nil {
+			for _, of := range old.feeds {
+				if of.url == rawURL {
+					f.domains.Store(of.domains.Load())
+				}
+			}
+		}
+		tf.feeds = append(tf.feeds, f)
+	}
+	return tf
+}
+
+// newBootstrapHTTPClient returns the http client for fetching threat feeds, or exporting query logs.
+// Hosts are resolved using bootstrap resolvers, because the OS resolver may be ctrld itself, which is
+// not ready yet.
+func newBootstrapHTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return ctrldnet.Dialer.DialContext(ctx, network, addr)
+		}
+		ips := ctrld.LookupIP(host)
+		if len(ips) == 0 {
+			return ctrldnet.Dialer.DialContext(ctx, network, addr)
+		}
+		addrs := make([]string, len(ips))
+		for i := range ips {
+			addrs[i] = net.JoinHostPort(ips[i], port)
+		}
+		d := &ctrldnet.ParallelDialer{}
+		return d.DialContext(ctx, network, addrs)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// threatFeedName returns the name of threat feed used in logging and metrics.
+func threatFeedName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host + u.Path
+}
+
+// lookup returns the name of threat feed containing domain or any of its parents,
+// or empty string if domain is not malicious.
+func (tf *threatFeeds) lookup(domain string) string {
+	if tf == nil {
+		return ""
+	}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, f := range tf.feeds {
+		domains := f.domains.Load()
+		if domains == nil {
+			continue
+		}
+		for name := domain; name != ""; {
+			if _, ok := (*domains)[name]; ok {
+				return f.name
+			}
+			_, name, _ = strings.Cut(name, ".")
+		}
+	}
+	return ""
+}
+
+// run refreshes threat feeds periodically, until ctrld is stopped or reloaded.
+func (tf *threatFeeds) run(ctx context.Context, stopCh, reloadCh chan struct{}) {
+	if tf == nil {
+		return
+	}
+	for _, f := range tf.feeds {
+		go func(f *threatFeed) {
+			for {
+				interval := tf.interval
+				if err := tf.refresh(ctx, f); err != nil {
+					mainLog.Load().Warn().Err(err).Msgf("could not refresh threat feed: %s", f.name)
+					// Retry sooner if the feed was never fetched.
+					if f.domains.Load() == nil {
+						interval = threatFeedRetryInterval
+					}
+				}
+				timer := time.NewTimer(interval)
+				select {
+				case <-timer.C:
+				case <-stopCh:
+					timer.Stop()
+					return
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-reloadCh:
+					timer.Stop()
+					return
+				}
+			}
+		}(f)
+	}
+}
+
+// refresh fetches the threat feed, replacing its domains.
+func (tf *threatFeeds) refresh(ctx context.Context, f *threatFeed) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := tf.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	domains, err := parseThreatFeed(io.LimitReader(resp.Body, threatFeedMaxSize))
+	if err != nil {
+		return err
+	}
+	f.domains.Store(&domains)
+	mainLog.Load().Debug().Msgf("loaded %d domains from threat feed: %s", len(domains), f.name)
+	return nil
+}
+
+// parseThreatFeed parses threat feed content, which is a list of domains in one of formats:
+//
+//   - Plain domain, one per line.
+//   - Hosts file, e.g: "0.0.0.0 example.com".
+//   - Adblock filter, e.g: "||example.com^".
+//
+// Empty lines, comments and unsupported entries are ignored.
+func parseThreatFeed(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if domain := threatFeedDomain(scanner.Text()); domain != "" {
+			domains[domain] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
+
+// threatFeedDomain returns the domain of a threat feed line, or empty string if there's none.
+func threatFeedDomain(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "!") {
+		return ""
+	}
+	if strings.HasPrefix(line, "||") {
+		line = strings.TrimPrefix(line, "||")
+		domain, rest, ok := strings.Cut(line, "^")
+		if !ok || (rest != "" && rest != "$important") {
+			return ""
+		}
+		line = domain
+	} else if fields := strings.Fields(line); len(fields) > 1 {
+		// Hosts file entry, using the first hostname.
+		if net.ParseIP(fields[0]) == nil {
+			return ""
+		}
+		line = fields[1]
+	}
+	line = strings.ToLower(strings.TrimSuffix(line, "."))
+	// Single label names, e.g: "localhost" in hosts file, are not domains.
+	if _, ok := dns.IsDomainName(line); !ok || net.ParseIP(line) != nil || !strings.Contains(line, ".") {
+		return ""
+	}
+	if line == "localhost.localdomain" {
+		return ""
+	}
+	return line
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_parseThreatFeed(t *testing.T) {
+	content := `# Malicious domains
+! Adblock comment
+malware.example.com
+0.0.0.0 phishing.example.net # inline comment
+127.0.0.1 localhost
+::1 localhost.localdomain
+||tracker.example.org^
+||ads.example.org^$third-party
+not a domain
+192.168.1.1
+Upper.Example.COM.
+`
+	domains, err := parseThreatFeed(strings.NewReader(content))
+	require.NoError(t, err)
+	want := map[string]struct{}{
+		"malware.example.com":  {},
+		"phishing.example.net": {},
+		"tracker.example.org":  {},
+		"upper.example.com":    {},
+	}
+	assert.Equal(t, want, domains)
+}
+
+func Test_threatFeeds(t *testing.T) {
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintln(w, "malware.example.com")
+	}))
+	defer ts.Close()
+
+	cfg := &ctrld.Config{}
+	cfg.Service.ThreatFeeds = []string{ts.URL + "/feed.txt"}
+	tf := newThreatFeeds(cfg, nil)
+	require.NotNil(t, tf)
+	assert.Empty(t, tf.lookup("malware.example.com."))
+
+	require.NoError(t, tf.refresh(context.Background(), tf.feeds[0]))
+	feed := strings.TrimPrefix(ts.URL, "http://") + "/feed.txt"
+	assert.Equal(t, feed, tf.lookup("malware.example.com."))
+	assert.Equal(t, feed, tf.lookup("www.Malware.example.com."))
+	assert.Empty(t, tf.lookup("example.com."))
+	assert.Empty(t, tf.lookup("notmalware.example.com."))
+
+	// Failed refresh keeps domains.
+	status = http.StatusInternalServerError
+	assert.Error(t, tf.refresh(context.Background(), tf.feeds[0]))
+	assert.Equal(t, feed, tf.lookup("malware.example.com."))
+
+	// Domains are kept for the same feed after reloading.
+	tf = newThreatFeeds(cfg, tf)
+	assert.Equal(t, feed, tf.lookup("malware.example.com."))
+
+	assert.Nil(t, newThreatFeeds(&ctrld.Config{}, tf))
+	var nilTf *threatFeeds
+	assert.Empty(t, nilTf.lookup("malware.example.com."))
+}
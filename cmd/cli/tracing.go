@@ -0,0 +1,40 @@
+package cli
+
+// spanKind is the kind of a traced span.
+type spanKind int
+
+const (
+	spanKindInternal spanKind = iota
+	spanKindServer
+	spanKindClient
+)
+
+// spanAttr is an attribute of a traced span. It's a plain struct, so attributes do not
+// allocate when ctrld is built without OpenTelemetry support.
+type spanAttr struct {
+	key  string
+	str  string
+	b    bool
+	strs []string
+	typ  spanAttrType
+}
+
+type spanAttrType uint8
+
+const (
+	spanAttrString spanAttrType = iota
+	spanAttrBool
+	spanAttrStringSlice
+)
+
+func stringAttr(key, value string) spanAttr {
+	return spanAttr{key: key, str: value, typ: spanAttrString}
+}
+
+func boolAttr(key string, value bool) spanAttr {
+	return spanAttr{key: key, b: value, typ: spanAttrBool}
+}
+
+func stringSliceAttr(key string, value []string) spanAttr {
+	return spanAttr{key: key, strs: value, typ: spanAttrStringSlice}
+}
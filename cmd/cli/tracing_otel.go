@@ -0,0 +1,128 @@
+//go:build otel
+
+package cli
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/Control-D-Inc/ctrld"
+
+// otelSupported reports whether OpenTelemetry traces and OTLP metrics push are compiled in.
+const otelSupported = true
+
+// querySpan is a span of query lifecycle.
+type querySpan struct {
+	span trace.Span
+}
+
+// SetAttributes sets attributes of the span.
+func (s querySpan) SetAttributes(attrs ...spanAttr) {
+	s.span.SetAttributes(otelAttributes(attrs)...)
+}
+
+// SetError records err and marks the span failed, with description desc.
+func (s querySpan) SetError(err error, desc string) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, desc)
+}
+
+// End completes the span.
+func (s querySpan) End() {
+	s.span.End()
+}
+
+// startSpan starts a span of query lifecycle. It's a no-op unless tracing is enabled.
+func startSpan(ctx context.Context, name string, kind spanKind, attrs ...spanAttr) (context.Context, querySpan) {
+	otelKind := trace.SpanKindInternal
+	switch kind {
+	case spanKindServer:
+		otelKind = trace.SpanKindServer
+	case spanKindClient:
+		otelKind = trace.SpanKindClient
+	}
+	ctx, span := otel.GetTracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(otelKind), trace.WithAttributes(otelAttributes(attrs)...))
+	return ctx, querySpan{span: span}
+}
+
+// otelAttributes converts attrs to OpenTelemetry attributes.
+func otelAttributes(attrs []spanAttr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch a.typ {
+		case spanAttrString:
+			kvs = append(kvs, attribute.String(a.key, a.str))
+		case spanAttrBool:
+			kvs = append(kvs, attribute.Bool(a.key, a.b))
+		case spanAttrStringSlice:
+			kvs = append(kvs, attribute.StringSlice(a.key, a.strs))
+		}
+	}
+	return kvs
+}
+
+// tracesSampleRatio returns the ratio of queries being traced.
+func (p *prog) tracesSampleRatio() float64 {
+	if r := p.cfg.Service.OtelTracesSampleRatio; r != nil {
+		return *r
+	}
+	return 1
+}
+
+// otelResource returns the OpenTelemetry resource describing ctrld.
+func otelResource() (*resource.Resource, error) {
+	return resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("ctrld"),
+		semconv.ServiceVersion(curVersion()),
+	))
+}
+
+// runTracing exports traces to the OTLP endpoint if configured, until ctrld is stopped or reloaded.
+func (p *prog) runTracing(ctx context.Context, reloadCh chan struct{}) {
+	endpoint := p.cfg.Service.OtelTracesEndpoint
+	if endpoint == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not create OTLP traces exporter")
+		return
+	}
+	res, err := otelResource()
+	if err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not create OTLP traces resource")
+		return
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(p.tracesSampleRatio()))),
+	)
+	otel.SetTracerProvider(tp)
+	mainLog.Load().Debug().Msgf("exporting traces to: %s", endpoint)
+
+	select {
+	case <-p.stopCh:
+	case <-ctx.Done():
+	case <-reloadCh:
+	}
+
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(shutdownCtx); err != nil {
+		mainLog.Load().Warn().Err(err).Msg("could not flush traces")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !otel
+
+package cli
+
+import "context"
+
+// otelSupported reports whether OpenTelemetry traces and OTLP metrics push are compiled in,
+// which requires the "otel" build tag.
+const otelSupported = false
+
+// querySpan is a no-op span, since OpenTelemetry is not compiled in.
+type querySpan struct{}
+
+func (querySpan) SetAttributes(attrs ...spanAttr) {}
+
+func (querySpan) SetError(err error, desc string) {}
+
+func (querySpan) End() {}
+
+func startSpan(ctx context.Context, name string, kind spanKind, attrs ...spanAttr) (context.Context, querySpan) {
+	return ctx, querySpan{}
+}
+
+// runTracing warns that traces could not be exported if configured, since OpenTelemetry is not compiled in.
+func (p *prog) runTracing(ctx context.Context, reloadCh chan struct{}) {
+	if p.cfg.Service.OtelTracesEndpoint != "" {
+		mainLog.Load().Warn().Msg(`otel_traces_endpoint is ignored, this build of ctrld does not support OpenTelemetry, it requires the "otel" build tag`)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build otel
+
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+	"github.com/Control-D-Inc/ctrld/testhelper"
+)
+
+func Test_prog_tracesSampleRatio(t *testing.T) {
+	p := &prog{cfg: &ctrld.Config{}}
+	assert.Equal(t, float64(1), p.tracesSampleRatio())
+	r := 0.25
+	p.cfg.Service.OtelTracesSampleRatio = &r
+	assert.Equal(t, r, p.tracesSampleRatio())
+}
+
+func TestProxyTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	cfg := testhelper.SampleConfig(t)
+	p := &prog{cfg: cfg}
+	cacher, err := dnscache.NewLRUCache(4096, 0, "")
+	require.NoError(t, err)
+	p.cache = cacher
+
+	msg := newDnsMsgWithHostname("example.com.", dns.TypeA)
+	answer := new(dns.Msg)
+	answer.SetRcode(msg, dns.RcodeSuccess)
+	p.cache.Add(dnscache.NewKey(msg, "upstream.0"), newCacheValue(t, answer, time.Now().Add(time.Minute)))
+
+	res := p.proxy(context.Background(), &proxyRequest{
+		msg: msg,
+		ufr: &upstreamForResult{upstreams: []string{"upstream.0"}, matched: true},
+	})
+	require.True(t, res.cached)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "dns.cache.lookup", spans[0].Name())
+	hit := false
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == "ctrld.cache.hit" {
+			hit = kv.Value.AsBool()
+		}
+	}
+	assert.True(t, hit)
+}
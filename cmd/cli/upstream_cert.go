@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// defaultUpstreamCertWarnThreshold is the remaining validity of an upstream certificate, below which
+	// a warning is sent.
+	defaultUpstreamCertWarnThreshold = 14 * 24 * time.Hour
+	// upstreamCertCheckInterval is the interval between expiry checks of seen certificates. New handshakes
+	// are rare with persistent connections, so expiry is not only checked when a certificate is presented.
+	upstreamCertCheckInterval = time.Hour
+	// upstreamCertRecentMax is the number of recently presented certificates remembered per upstream. Upstreams
+	// behind load balancers may present several valid certificates, switching between them is not a change.
+	upstreamCertRecentMax = 8
+)
+
+// upstreamCertStatus is the last certificate presented by an upstream.
+type upstreamCertStatus struct {
+	Upstream string `json:"upstream"`
+	ctrld.UpstreamCert
+}
+
+type upstreamCertState struct {
+	cert         *ctrld.UpstreamCert
+	expiryWarned bool
+}
+
+// upstreamCerts tracks the certificates presented by encrypted upstreams, warning when they are close
+// to expiry, or changed unexpectedly, e.g: self-hosted DoH servers with broken certificate automation.
+type upstreamCerts struct {
+	mu     sync.Mutex
+	certs  map[string]*upstreamCertState
+	recent map[string][]*upstreamCertState // recently presented certificates, from the oldest one.
+	now    func() time.Time
+}
+
+func newUpstreamCerts() *upstreamCerts {
+	return &upstreamCerts{
+		certs:  make(map[string]*upstreamCertState),
+		recent: make(map[string][]*upstreamCertState),
+		now:    time.Now,
+	}
+}
+
+// observe records cert presented by upstream, which replaced prev. A warning is logged, and "upstream_cert_changed"
+// event is sent, if cert does not look like a renewal of prev, and neither it nor its key was presented recently.
+// Then cert is checked for expiry, see checkExpiry.
+func (uc *upstreamCerts) observe(upstream string, prev, cert *ctrld.UpstreamCert, threshold time.Duration, a *alerts) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	// After config was reloaded, upstreams report their certificates again, as if they were first seen.
+	if st := uc.certs[upstream]; prev == nil && st != nil {
+		if st.cert.Fingerprint == cert.Fingerprint {
+			return
+		}
+		prev = st.cert
+	}
+	recent := uc.recent[upstream]
+	var st *upstreamCertState
+	if i := slices.IndexFunc(recent, func(st *upstreamCertState) bool { return st.cert.Fingerprint == cert.Fingerprint }); i >= 0 {
+		// Keep the expiry warning state of the seen certificate.
+		st = recent[i]
+		recent = slices.Delete(recent, i, i+1)
+	}
+	keySeen := slices.ContainsFunc(recent, func(st *upstreamCertState) bool {
+		return cert.SPKI != "" && st.cert.SPKI == cert.SPKI
+	})
+	switch {
+	case prev == nil:
+		mainLog.Load().Debug().Msgf("certificate of %s: %s, issued by %s, expires at %s",
+			upstream, cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339))
+	case st != nil || keySeen:
+		mainLog.Load().Debug().Msgf("certificate of %s changed to a recently presented one: %s, expires at %s",
+			upstream, cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	case cert.IsRenewalOf(prev):
+		mainLog.Load().Notice().Msgf("certificate of %s was renewed, expires at %s", upstream, cert.NotAfter.Format(time.RFC3339))
+	default:
+		mainLog.Load().Warn().Msgf("certificate of %s changed unexpectedly: %s, issued by %s, expires at %s, previous: %s, issued by %s, expires at %s",
+			upstream, cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339),
+			prev.Subject, prev.Issuer, prev.NotAfter.Format(time.RFC3339))
+		a.upstreamCertChanged(upstream, prev, cert)
+	}
+	if st == nil {
+		st = &upstreamCertState{cert: cert}
+	}
+	recent = append(recent, st)
+	if len(recent) > upstreamCertRecentMax {
+		recent = slices.Delete(recent, 0, len(recent)-upstreamCertRecentMax)
+	}
+	uc.recent[upstream] = recent
+	uc.certs[upstream] = st
+	uc.warnExpiryLocked(upstream, st, threshold, a)
+}
+
+// checkExpiry logs a warning, and sends "upstream_cert_expiring" event, for certificates which expire within
+// threshold. Each certificate is only warned once, no warning if threshold is non-positive.
+func (uc *upstreamCerts) checkExpiry(threshold time.Duration, a *alerts) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	for upstream, st := range uc.certs {
+		uc.warnExpiryLocked(upstream, st, threshold, a)
+	}
+}
+
+func (uc *upstreamCerts) warnExpiryLocked(upstream string, st *upstreamCertState, threshold time.Duration, a *alerts) {
+	if threshold <= 0 || st.expiryWarned {
+		return
+	}
+	if left := st.cert.NotAfter.Sub(uc.now()); left < threshold {
+		st.expiryWarned = true
+		mainLog.Load().Warn().Msgf("certificate of %s expires at %s, in less than %s",
+			upstream, st.cert.NotAfter.Format(time.RFC3339), threshold)
+		a.upstreamCertExpiring(upstream, st.cert)
+	}
+}
+
+// retain removes certificates of upstreams which are not in upstreams, e.g: after config was reloaded.
+func (uc *upstreamCerts) retain(upstreams []string) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	for upstream := range uc.certs {
+		if !slices.Contains(upstreams, upstream) {
+			delete(uc.certs, upstream)
+			delete(uc.recent, upstream)
+		}
+	}
+}
+
+// stats returns the last certificates presented by upstreams, sorted by upstream name.
+func (uc *upstreamCerts) stats() []upstreamCertStatus {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	res := make([]upstreamCertStatus, 0, len(uc.certs))
+	for upstream, st := range uc.certs {
+		res = append(res, upstreamCertStatus{Upstream: upstream, UpstreamCert: *st.cert})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Upstream < res[j].Upstream })
+	return res
+}
+
+// upstreamCertWarnThreshold returns the remaining validity of upstream certificates, below which a warning is sent.
+// A zero value means expiry warning is disabled.
+func (p *prog) upstreamCertWarnThreshold() time.Duration {
+	if ptr := p.cfg.Service.UpstreamCertWarnThreshold; ptr != nil {
+		return max(*ptr, 0)
+	}
+	return defaultUpstreamCertWarnThreshold
+}
+
+// watchUpstreamCerts checks the expiry of upstream certificates periodically, until ctx is done.
+func (p *prog) watchUpstreamCerts(ctx context.Context) {
+	ticker := time.NewTicker(upstreamCertCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.upstreamCerts.checkExpiry(p.upstreamCertWarnThreshold(), p.alerts)
+		}
+	}
+}
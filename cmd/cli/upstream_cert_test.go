@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_upstreamCerts(t *testing.T) {
+	a, ch := newTestAlerts(t, &ctrld.ServiceConfig{WebhookURLs: []string{"https://example.com/ctrld"}})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	uc := newUpstreamCerts()
+	uc.now = func() time.Time { return now }
+	threshold := 14 * 24 * time.Hour
+
+	c1 := &ctrld.UpstreamCert{Subject: "CN=dns.example.com", Issuer: "CN=CA", NotAfter: now.Add(30 * 24 * time.Hour), Fingerprint: "1"}
+	uc.observe("upstream.0", nil, c1, threshold, a)
+	uc.checkExpiry(threshold, a)
+	assert.Empty(t, ch)
+
+	// Close to expiry, warned once.
+	now = now.Add(20 * 24 * time.Hour)
+	uc.checkExpiry(threshold, a)
+	uc.checkExpiry(threshold, a)
+	req := <-ch
+	assert.Equal(t, alertEventUpstreamCertExpiry, req.body["event"])
+	assert.Empty(t, ch)
+
+	// Renewal is expected, and resets expiry warning.
+	c2 := &ctrld.UpstreamCert{Subject: c1.Subject, Issuer: c1.Issuer, NotAfter: now.Add(90 * 24 * time.Hour), Fingerprint: "2"}
+	uc.observe("upstream.0", c1, c2, threshold, a)
+	uc.checkExpiry(threshold, a)
+	assert.Empty(t, ch)
+
+	// Reloading config reports the same certificate again.
+	uc.observe("upstream.0", nil, c2, threshold, a)
+	assert.Empty(t, ch)
+
+	// Rotated intermediate is expected.
+	c3 := &ctrld.UpstreamCert{Subject: c1.Subject, Issuer: "CN=Other CA", NotAfter: now.Add(90 * 24 * time.Hour), Fingerprint: "3", SPKI: "3"}
+	uc.observe("upstream.0", c2, c3, threshold, a)
+	assert.Empty(t, ch)
+
+	// Another valid certificate, e.g: from other server behind a load balancer, which expires earlier.
+	c4 := &ctrld.UpstreamCert{Subject: c1.Subject, Issuer: c1.Issuer, NotAfter: now.Add(60 * 24 * time.Hour), Fingerprint: "4", SPKI: "4"}
+	uc.observe("upstream.0", c3, c4, threshold, a)
+	req = <-ch
+	assert.Equal(t, alertEventUpstreamCertChange, req.body["event"])
+	data := req.body["data"].(map[string]any)
+	assert.Equal(t, "3", data["previous"].(map[string]any)["fingerprint"])
+
+	// Switching between recently presented certificates, or keys, is not a change.
+	uc.observe("upstream.0", c4, c3, threshold, a)
+	uc.observe("upstream.0", c3, c4, threshold, a)
+	c5 := &ctrld.UpstreamCert{Subject: c1.Subject, Issuer: c1.Issuer, NotAfter: now.Add(50 * 24 * time.Hour), Fingerprint: "5", SPKI: "3"}
+	uc.observe("upstream.0", c4, c5, threshold, a)
+	assert.Empty(t, ch)
+	uc.observe("upstream.0", c5, c3, threshold, a)
+
+	uc.observe("upstream.1", nil, c1, 0, a)
+	stats := uc.stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "upstream.0", stats[0].Upstream)
+	assert.Equal(t, "3", stats[0].Fingerprint)
+	assert.Equal(t, "upstream.1", stats[1].Upstream)
+
+	uc.retain([]string{"upstream.1"})
+	stats = uc.stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "upstream.1", stats[0].Upstream)
+}
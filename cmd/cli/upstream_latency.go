@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// upstreamLatencySamples is the number of most recent samples used for computing latency percentiles.
+	upstreamLatencySamples = 1024
+	// upstreamLatencyCheckInterval is the number of samples between each degradation checks.
+	upstreamLatencyCheckInterval = 64
+)
+
+// upstreamLatencyStats represents latency percentiles of an upstream, in milliseconds.
+type upstreamLatencyStats struct {
+	Upstream string  `json:"upstream"`
+	Samples  int     `json:"samples"`
+	P50      float64 `json:"p50_ms"`
+	P95      float64 `json:"p95_ms"`
+	P99      float64 `json:"p99_ms"`
+}
+
+// latencyWindow holds a fixed number of most recent latency samples.
+type latencyWindow struct {
+	samples  []time.Duration
+	next     int
+	count    uint64
+	degraded bool
+}
+
+func (lw *latencyWindow) add(d time.Duration) {
+	if len(lw.samples) < upstreamLatencySamples {
+		lw.samples = append(lw.samples, d)
+	} else {
+		lw.samples[lw.next] = d
+	}
+	lw.next = (lw.next + 1) % upstreamLatencySamples
+	lw.count++
+}
+
+// percentiles returns the p50, p95 and p99 of recorded samples.
+func (lw *latencyWindow) percentiles() (p50, p95, p99 time.Duration) {
+	if len(lw.samples) == 0 {
+		return 0, 0, 0
+	}
+	sorted := slices.Clone(lw.samples)
+	slices.Sort(sorted)
+	return percentile(sorted, 50), percentile(sorted, 95), percentile(sorted, 99)
+}
+
+// percentile returns the p-th percentile of sorted samples, using nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// upstreamLatency tracks queries latency of upstreams.
+type upstreamLatency struct {
+	mu      sync.Mutex
+	windows map[string]*latencyWindow
+}
+
+func newUpstreamLatency() *upstreamLatency {
+	return &upstreamLatency{windows: make(map[string]*latencyWindow)}
+}
+
+// record adds a latency sample for the given upstream. If threshold is positive, the p95 latency
+// of upstream is checked periodically, warning is logged when the upstream becomes degraded or recovers.
+func (ul *upstreamLatency) record(upstream string, d time.Duration, threshold time.Duration) {
+	statsUpstreamLatency.WithLabelValues(upstream).Observe(d.Seconds())
+
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	lw := ul.windows[upstream]
+	if lw == nil {
+		lw = &latencyWindow{}
+		ul.windows[upstream] = lw
+	}
+	lw.add(d)
+	if threshold <= 0 || lw.count%upstreamLatencyCheckInterval != 0 {
+		return
+	}
+	_, p95, _ := lw.percentiles()
+	switch degraded := p95 > threshold; {
+	case degraded && !lw.degraded:
+		mainLog.Load().Warn().Msgf("upstream %s is degraded, p95 latency %s exceeds threshold %s", upstream, p95, threshold)
+	case !degraded && lw.degraded:
+		mainLog.Load().Notice().Msgf("upstream %s recovered, p95 latency %s is within threshold %s", upstream, p95, threshold)
+	}
+	lw.degraded = p95 > threshold
+}
+
+// stats returns latency percentiles of all upstreams, sorted by upstream name.
+func (ul *upstreamLatency) stats() []upstreamLatencyStats {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	res := make([]upstreamLatencyStats, 0, len(ul.windows))
+	for upstream, lw := range ul.windows {
+		p50, p95, p99 := lw.percentiles()
+		res = append(res, upstreamLatencyStats{
+			Upstream: upstream,
+			Samples:  len(lw.samples),
+			P50:      durationToMilliseconds(p50),
+			P95:      durationToMilliseconds(p95),
+			P99:      durationToMilliseconds(p99),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Upstream < res[j].Upstream })
+	return res
+}
+
+func durationToMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
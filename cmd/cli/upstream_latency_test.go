@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_upstreamLatency_stats(t *testing.T) {
+	ul := newUpstreamLatency()
+	for i := 1; i <= 100; i++ {
+		ul.record("upstream.0", time.Duration(i)*time.Millisecond, 0)
+	}
+	ul.record("upstream.1", 5*time.Millisecond, 0)
+
+	stats := ul.stats()
+	if len(stats) != 2 {
+		t.Fatalf("unexpected number of upstreams, want: 2, got: %d", len(stats))
+	}
+	s := stats[0]
+	if s.Upstream != "upstream.0" || s.Samples != 100 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if s.P50 != 50 || s.P95 != 95 || s.P99 != 99 {
+		t.Errorf("unexpected percentiles: %+v", s)
+	}
+	if s := stats[1]; s.P50 != 5 || s.P95 != 5 || s.P99 != 5 {
+		t.Errorf("unexpected percentiles: %+v", s)
+	}
+}
+
+func Test_latencyWindow_add(t *testing.T) {
+	lw := &latencyWindow{}
+	for i := 0; i < upstreamLatencySamples; i++ {
+		lw.add(time.Second)
+	}
+	// Old samples must be replaced by new ones.
+	for i := 0; i < upstreamLatencySamples; i++ {
+		lw.add(time.Millisecond)
+	}
+	if len(lw.samples) != upstreamLatencySamples {
+		t.Fatalf("unexpected number of samples: %d", len(lw.samples))
+	}
+	if _, _, p99 := lw.percentiles(); p99 != time.Millisecond {
+		t.Errorf("unexpected p99, want: %s, got: %s", time.Millisecond, p99)
+	}
+}
+
+func Test_upstreamLatency_degraded(t *testing.T) {
+	ul := newUpstreamLatency()
+	threshold := 10 * time.Millisecond
+	for i := 0; i < upstreamLatencyCheckInterval; i++ {
+		ul.record("upstream.0", 20*time.Millisecond, threshold)
+	}
+	if !ul.windows["upstream.0"].degraded {
+		t.Error("upstream should be marked as degraded")
+	}
+	for i := 0; i < upstreamLatencySamples; i++ {
+		ul.record("upstream.0", time.Millisecond, threshold)
+	}
+	if ul.windows["upstream.0"].degraded {
+		t.Error("upstream should be recovered")
+	}
+}
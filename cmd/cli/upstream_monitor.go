@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -13,8 +14,10 @@ import (
 const (
 	// maxFailureRequest is the maximum failed queries allowed before an upstream is marked as down.
 	maxFailureRequest = 100
-	// checkUpstreamBackoffSleep is the time interval between each upstream checks.
+	// checkUpstreamBackoffSleep is the default time interval between each upstream checks.
 	checkUpstreamBackoffSleep = 2 * time.Second
+	// defaultUpstreamRecoveryChecks is the default number of successful checks before an upstream is marked as up.
+	defaultUpstreamRecoveryChecks = 1
 )
 
 // upstreamMonitor performs monitoring upstreams health.
@@ -25,6 +28,8 @@ type upstreamMonitor struct {
 	checking   map[string]bool
 	down       map[string]bool
 	failureReq map[string]uint64
+	// onStateChange are called when an upstream is marked as down, or up again.
+	onStateChange []func(upstream string, down bool)
 }
 
 func newUpstreamMonitor(cfg *ctrld.Config) *upstreamMonitor {
@@ -45,11 +50,16 @@ func newUpstreamMonitor(cfg *ctrld.Config) *upstreamMonitor {
 // increaseFailureCount increase failed queries count for an upstream by 1.
 func (um *upstreamMonitor) increaseFailureCount(upstream string) {
 	um.mu.Lock()
-	defer um.mu.Unlock()
-
 	um.failureReq[upstream] += 1
 	failedCount := um.failureReq[upstream]
+	wasDown := um.down[upstream]
 	um.down[upstream] = failedCount >= maxFailureRequest
+	changed := !wasDown && um.down[upstream]
+	um.mu.Unlock()
+
+	if changed {
+		um.stateChanged(upstream, true)
+	}
 }
 
 // isDown reports whether the given upstream is being marked as down.
@@ -63,14 +73,47 @@ func (um *upstreamMonitor) isDown(upstream string) bool {
 // reset marks an upstream as up and set failed queries counter to zero.
 func (um *upstreamMonitor) reset(upstream string) {
 	um.mu.Lock()
-	defer um.mu.Unlock()
-
+	wasDown := um.down[upstream]
 	um.failureReq[upstream] = 0
 	um.down[upstream] = false
+	um.mu.Unlock()
+
+	if wasDown {
+		um.stateChanged(upstream, false)
+	}
+}
+
+// checkInterval returns the time to wait before the next check of a down upstream. A random jitter
+// is added, so checks of multiple upstreams, or multiple ctrld instances, are spread over time.
+func (um *upstreamMonitor) checkInterval() time.Duration {
+	interval := checkUpstreamBackoffSleep
+	if ptr := um.cfg.Service.UpstreamCheckInterval; ptr != nil && *ptr > 0 {
+		interval = *ptr
+	}
+	if ptr := um.cfg.Service.UpstreamCheckJitter; ptr != nil && *ptr > 0 {
+		interval += time.Duration(rand.Int63n(int64(*ptr) + 1))
+	}
+	return interval
+}
+
+// recoveryChecks returns the number of consecutive successful checks before a down upstream is marked as up.
+func (um *upstreamMonitor) recoveryChecks() int {
+	if ptr := um.cfg.Service.UpstreamRecoveryChecks; ptr != nil && *ptr > 0 {
+		return *ptr
+	}
+	return defaultUpstreamRecoveryChecks
+}
+
+// stateChanged calls onStateChange functions for the new state of upstream.
+func (um *upstreamMonitor) stateChanged(upstream string, down bool) {
+	for _, fn := range um.onStateChange {
+		fn(upstream, down)
+	}
 }
 
 // checkUpstream checks the given upstream status, periodically sending query to upstream
-// until successfully. An upstream status/counter will be reset once it becomes reachable.
+// until successfully. An upstream status/counter will be reset once it becomes reachable,
+// that's it answers the configured number of consecutive checks.
 func (p *prog) checkUpstream(upstream string, uc *ctrld.UpstreamConfig) {
 	p.um.mu.Lock()
 	isChecking := p.um.checking[upstream]
@@ -101,18 +144,26 @@ func (p *prog) checkUpstream(upstream string, uc *ctrld.UpstreamConfig) {
 		_, err := resolver.Resolve(ctx, msg)
 		return err
 	}
+	successes, required := 0, p.um.recoveryChecks()
 	for {
-		if err := check(); err == nil {
-			mainLog.Load().Debug().Msgf("upstream %q is online", uc.Endpoint)
-			p.um.reset(upstream)
-			if p.leakingQuery.CompareAndSwap(true, false) {
-				p.leakingQueryMu.Lock()
-				p.leakingQueryWasRun = false
-				p.leakingQueryMu.Unlock()
-				mainLog.Load().Warn().Msg("stop leaking query")
+		if err := check(); err != nil {
+			// Flapping upstreams must answer consecutive checks again.
+			successes = 0
+		} else {
+			successes++
+			if successes >= required {
+				mainLog.Load().Debug().Msgf("upstream %q is online", uc.Endpoint)
+				p.um.reset(upstream)
+				if p.leakingQuery.CompareAndSwap(true, false) {
+					p.leakingQueryMu.Lock()
+					p.leakingQueryWasRun = false
+					p.leakingQueryMu.Unlock()
+					mainLog.Load().Warn().Msg("stop leaking query")
+				}
+				return
 			}
-			return
+			mainLog.Load().Debug().Msgf("upstream %q check succeeded (%d/%d)", uc.Endpoint, successes, required)
 		}
-		time.Sleep(checkUpstreamBackoffSleep)
+		time.Sleep(p.um.checkInterval())
 	}
 }
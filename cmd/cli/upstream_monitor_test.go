@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_upstreamMonitor_checkInterval(t *testing.T) {
+	um := newUpstreamMonitor(&ctrld.Config{})
+	assert.Equal(t, checkUpstreamBackoffSleep, um.checkInterval())
+	assert.Equal(t, defaultUpstreamRecoveryChecks, um.recoveryChecks())
+
+	interval, jitter, checks := 10*time.Second, 5*time.Second, 3
+	um = newUpstreamMonitor(&ctrld.Config{Service: ctrld.ServiceConfig{
+		UpstreamCheckInterval:  &interval,
+		UpstreamCheckJitter:    &jitter,
+		UpstreamRecoveryChecks: &checks,
+	}})
+	for i := 0; i < 100; i++ {
+		d := um.checkInterval()
+		assert.GreaterOrEqual(t, d, interval)
+		assert.LessOrEqual(t, d, interval+jitter)
+	}
+	assert.Equal(t, checks, um.recoveryChecks())
+}
+
+func Test_prog_checkUpstream_recoveryChecks(t *testing.T) {
+	var queries atomic.Int32
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		// The first check fails, so the upstream must answer 3 more consecutive checks.
+		if queries.Add(1) == 1 {
+			return
+		}
+		answer := new(dns.Msg)
+		answer.SetReply(m)
+		_ = w.WriteMsg(answer)
+	})}
+	go s.ActivateAndServe()
+	defer s.Shutdown()
+
+	interval, checks := 10*time.Millisecond, 3
+	uc := &ctrld.UpstreamConfig{Name: "flaky", Type: ctrld.ResolverTypeLegacy, Endpoint: pc.LocalAddr().String()}
+	uc.Init()
+	cfg := &ctrld.Config{
+		Service: ctrld.ServiceConfig{
+			UpstreamCheckInterval:  &interval,
+			UpstreamRecoveryChecks: &checks,
+		},
+		Upstream: map[string]*ctrld.UpstreamConfig{"0": uc},
+	}
+	p := &prog{cfg: cfg, um: newUpstreamMonitor(cfg)}
+	for i := 0; i < maxFailureRequest; i++ {
+		p.um.increaseFailureCount("upstream.0")
+	}
+	require.True(t, p.um.isDown("upstream.0"))
+
+	p.checkUpstream("upstream.0", uc)
+	assert.False(t, p.um.isDown("upstream.0"))
+	assert.EqualValues(t, 1+checks, queries.Load())
+}
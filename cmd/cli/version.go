@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/Control-D-Inc/ctrld"
+	"github.com/Control-D-Inc/ctrld/internal/dnscache"
+	"github.com/Control-D-Inc/ctrld/internal/router"
+)
+
+// buildDate is the build time, set using ldflags, e.g: -X github.com/Control-D-Inc/ctrld/cmd/cli.buildDate=2024-01-02T15:04:05Z.
+var buildDate = ""
+
+// versionJSON reports whether "--version" prints build info as JSON.
+var versionJSON bool
+
+// buildInfo describes the ctrld build, for inventory tooling of ctrld fleets.
+type buildInfo struct {
+	Version             string   `json:"version"`
+	Commit              string   `json:"commit"`
+	BuildDate           string   `json:"build_date,omitempty"`
+	GoVersion           string   `json:"go_version"`
+	OS                  string   `json:"os"`
+	Arch                string   `json:"arch"`
+	Features            []string `json:"features"`
+	Routers             []string `json:"routers"`
+	ConfigSchemaVersion int      `json:"config_schema_version"`
+}
+
+// currentBuildInfo returns the build info of running ctrld. The commit and build date are read
+// from the VCS info embedded by Go toolchain, if they were not set using ldflags.
+func currentBuildInfo() buildInfo {
+	bi := buildInfo{
+		Version:             version,
+		Commit:              commit,
+		BuildDate:           buildDate,
+		GoVersion:           runtime.Version(),
+		OS:                  runtime.GOOS,
+		Arch:                runtime.GOARCH,
+		Features:            buildFeatures(),
+		Routers:             router.Platforms(),
+		ConfigSchemaVersion: ctrld.ConfigSchemaVersion,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "none":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			}
+		}
+	}
+	if bi.Routers == nil {
+		bi.Routers = []string{}
+	}
+	return bi
+}
+
+// buildFeatures returns the optional features, which are only compiled in with build tags, or on some platforms.
+func buildFeatures() []string {
+	features := []string{}
+	if sqliteSupported {
+		features = append(features, "sqlite")
+	}
+	if otelSupported {
+		features = append(features, "otel")
+	}
+	if parquetSupported {
+		features = append(features, "parquet")
+	}
+	if dnscache.RedisSupported {
+		features = append(features, "redis")
+	}
+	return features
+}
+
+// versionOutput returns the output of "--version" flag.
+func versionOutput() string {
+	if !versionJSON {
+		return rootCmd.Name() + " version " + rootCmd.Version + "\n"
+	}
+	b, _ := json.MarshalIndent(currentBuildInfo(), "", "  ")
+	return string(b) + "\n"
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_versionOutput(t *testing.T) {
+	t.Cleanup(func() { versionJSON = false })
+
+	assert.Equal(t, "ctrld version "+rootCmd.Version+"\n", versionOutput())
+
+	versionJSON = true
+	var bi buildInfo
+	require.NoError(t, json.Unmarshal([]byte(versionOutput()), &bi))
+	assert.Equal(t, version, bi.Version)
+	assert.Equal(t, runtime.Version(), bi.GoVersion)
+	assert.Equal(t, runtime.GOOS, bi.OS)
+	assert.Equal(t, runtime.GOARCH, bi.Arch)
+	assert.Equal(t, ctrld.ConfigSchemaVersion, bi.ConfigSchemaVersion)
+	assert.Equal(t, sqliteSupported, len(bi.Features) > 0 && bi.Features[0] == "sqlite")
+	assert.NotNil(t, bi.Routers)
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// vpnInterfacesInterval is the interval which VPN interfaces are detected again.
+const vpnInterfacesInterval = 10 * time.Second
+
+// vpnInterfaceAddr is an address assigned to a VPN interface.
+type vpnInterfaceAddr struct {
+	ip     netip.Addr
+	subnet netip.Prefix
+}
+
+// vpnInterfaces tracks VPN interfaces, like WireGuard ones, of a listener, so queries from
+// VPN clients could be served on the VPN interfaces, using a distinct policy.
+type vpnInterfaces struct {
+	patterns []string
+	lister   func(patterns []string) []vpnInterfaceAddr
+
+	addrs atomic.Pointer[[]vpnInterfaceAddr]
+}
+
+// newVPNInterfaces returns new vpnInterfaces for the given listener config,
+// or nil if the listener has no VPN interfaces configured.
+func newVPNInterfaces(lc *ctrld.ListenerConfig) *vpnInterfaces {
+	if len(lc.VPNInterfaces) == 0 {
+		return nil
+	}
+	return &vpnInterfaces{patterns: lc.VPNInterfaces, lister: vpnInterfaceAddrs}
+}
+
+// refresh detects VPN interfaces addresses again, returning the new ones.
+func (vi *vpnInterfaces) refresh() []vpnInterfaceAddr {
+	addrs := vi.lister(vi.patterns)
+	vi.addrs.Store(&addrs)
+	return addrs
+}
+
+// isVPNClient reports whether addr is a VPN client address, that is in the subnet of any VPN interface.
+func (vi *vpnInterfaces) isVPNClient(addr net.Addr) bool {
+	if vi == nil || addr == nil {
+		return false
+	}
+	addrs := vi.addrs.Load()
+	if addrs == nil {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, a := range *addrs {
+		if a.subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// vpnListenerConfig returns the listener config used for queries from VPN clients,
+// which is lc with "vpn_policy" in place of "policy", if set.
+func vpnListenerConfig(lc *ctrld.ListenerConfig) *ctrld.ListenerConfig {
+	if lc.VPNPolicy == nil {
+		return lc
+	}
+	vlc := *lc
+	vlc.Policy = lc.VPNPolicy
+	return &vlc
+}
+
+// serveVPNInterfaces listens on addresses of VPN interfaces, starting/stopping DNS servers
+// as the interfaces coming up/down, until ctx is done or ctrld is stopped.
+//
+// If the listener IP is a wildcard address, it receives queries on VPN interfaces already,
+// so the VPN interfaces are only tracked for matching VPN clients. Same if the VPN interface
+// address is served by RFC1918 listeners, binding failure is logged, the address is not retried.
+func (p *prog) serveVPNInterfaces(ctx context.Context, vi *vpnInterfaces, lc *ctrld.ListenerConfig, handler dns.Handler) {
+	listen := true
+	if ip := net.ParseIP(lc.IP); ip == nil || ip.IsUnspecified() {
+		listen = false
+	}
+	servers := make(map[netip.Addr][]*dns.Server)
+	defer func() {
+		var wg sync.WaitGroup
+		for _, ss := range servers {
+			for _, s := range ss {
+				wg.Add(1)
+				go func(s *dns.Server) {
+					defer wg.Done()
+					p.shutdownDNSServer(s)
+				}(s)
+			}
+		}
+		wg.Wait()
+	}()
+	ticker := time.NewTicker(vpnInterfacesInterval)
+	defer ticker.Stop()
+	for {
+		addrs := vi.refresh()
+		if listen {
+			current := make(map[netip.Addr]struct{}, len(addrs))
+			for _, a := range addrs {
+				current[a.ip] = struct{}{}
+			}
+			for ip, ss := range servers {
+				if _, ok := current[ip]; ok {
+					continue
+				}
+				mainLog.Load().Info().Msgf("stopping DNS server on VPN interface address: %s", ip)
+				for _, s := range ss {
+					p.shutdownDNSServer(s)
+				}
+				delete(servers, ip)
+			}
+			for ip := range current {
+				if _, ok := servers[ip]; ok {
+					continue
+				}
+				addr := net.JoinHostPort(ip.String(), strconv.Itoa(lc.Port))
+				mainLog.Load().Info().Msgf("starting DNS server on VPN interface address: %s", addr)
+				for _, proto := range []string{"udp", "tcp"} {
+					s, errCh := runDNSServer(addr, proto, handler)
+					servers[ip] = append(servers[ip], s)
+					go func() {
+						if err := <-errCh; err != nil {
+							mainLog.Load().Warn().Err(err).Msgf("could not listen on %s: %s", proto, addr)
+						}
+					}()
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-p.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// vpnInterfaceAddrs returns addresses of up interfaces, which names match any of patterns.
+// Link-local addresses are ignored, since they could not be used without zone.
+func vpnInterfaceAddrs(patterns []string) []vpnInterfaceAddr {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		mainLog.Load().Debug().Err(err).Msg("could not list interfaces")
+		return nil
+	}
+	var res []vpnInterfaceAddr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || !ifaceMatches(patterns, iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			ip = ip.Unmap()
+			if ip.IsLinkLocalUnicast() {
+				continue
+			}
+			ones, _ := ipNet.Mask.Size()
+			if ip.Is4() && ones > 32 {
+				ones -= 96
+			}
+			prefix, err := ip.Prefix(ones)
+			if err != nil {
+				continue
+			}
+			res = append(res, vpnInterfaceAddr{ip: ip, subnet: prefix})
+		}
+	}
+	return res
+}
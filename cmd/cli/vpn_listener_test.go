@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+func Test_vpnInterfaces_isVPNClient(t *testing.T) {
+	assert.Nil(t, newVPNInterfaces(&ctrld.ListenerConfig{}))
+
+	vi := newVPNInterfaces(&ctrld.ListenerConfig{VPNInterfaces: []string{"wg*"}})
+	vi.lister = func(patterns []string) []vpnInterfaceAddr {
+		return []vpnInterfaceAddr{{ip: netip.MustParseAddr("10.8.0.1"), subnet: netip.MustParsePrefix("10.8.0.0/24")}}
+	}
+	assert.False(t, vi.isVPNClient(&net.UDPAddr{IP: net.ParseIP("10.8.0.2"), Port: 1234}))
+	vi.refresh()
+	assert.True(t, vi.isVPNClient(&net.UDPAddr{IP: net.ParseIP("10.8.0.2"), Port: 1234}))
+	assert.True(t, vi.isVPNClient(&net.TCPAddr{IP: net.ParseIP("10.8.0.3"), Port: 1234}))
+	assert.False(t, vi.isVPNClient(&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 1234}))
+
+	var nilVi *vpnInterfaces
+	assert.False(t, nilVi.isVPNClient(&net.UDPAddr{IP: net.ParseIP("10.8.0.2"), Port: 1234}))
+}
+
+func Test_vpnListenerConfig(t *testing.T) {
+	policy := &ctrld.ListenerPolicyConfig{Name: "LAN"}
+	vpnPolicy := &ctrld.ListenerPolicyConfig{Name: "VPN"}
+
+	lc := &ctrld.ListenerConfig{Policy: policy}
+	assert.Same(t, lc, vpnListenerConfig(lc))
+
+	lc.VPNPolicy = vpnPolicy
+	vlc := vpnListenerConfig(lc)
+	assert.Same(t, vpnPolicy, vlc.Policy)
+	assert.Same(t, policy, lc.Policy)
+}
+
+func Test_prog_serveVPNInterfaces(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	p := &prog{cfg: &ctrld.Config{}, stopCh: make(chan struct{})}
+	lc := &ctrld.ListenerConfig{IP: "127.0.0.1", Port: port, VPNInterfaces: []string{"wg*"}}
+	vi := newVPNInterfaces(lc)
+	vi.lister = func(patterns []string) []vpnInterfaceAddr {
+		return []vpnInterfaceAddr{{ip: netip.MustParseAddr("127.0.0.1"), subnet: netip.MustParsePrefix("127.0.0.0/8")}}
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		answer := new(dns.Msg)
+		answer.SetReply(m)
+		_ = w.WriteMsg(answer)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.serveVPNInterfaces(ctx, vi, lc, handler)
+	}()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	var answer *dns.Msg
+	for i := 0; i < 20; i++ {
+		if answer, err = dns.Exchange(msg, addr); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("VPN interface address is not served: %v", err)
+	}
+	assert.Equal(t, dns.RcodeSuccess, answer.Rcode)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("serveVPNInterfaces did not stop")
+	}
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Formats of webhook payloads.
+const (
+	webhookFormatJSON    = "json"
+	webhookFormatSlack   = "slack"
+	webhookFormatDiscord = "discord"
+)
+
+// postWebhook posts the JSON body to url.
+func postWebhook(url string, body []byte) error {
+	c := http.Client{Timeout: webhookTimeout}
+	resp, err := c.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookFormat returns the payload format of webhook url. Slack and Discord webhooks
+// are detected from their URLs, and receive a chat message.
+func webhookFormat(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return webhookFormatJSON
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return webhookFormatSlack
+	case (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return webhookFormatDiscord
+	}
+	return webhookFormatJSON
+}
+
+// webhookPayload returns the payload of event ev in given format.
+func webhookPayload(format string, ev *alertEvent) ([]byte, error) {
+	text := ev.Message
+	if ev.Instance != "" {
+		text = fmt.Sprintf("[ctrld@%s] %s", ev.Instance, ev.Message)
+	}
+	switch format {
+	case webhookFormatSlack:
+		return json.Marshal(map[string]string{"text": text})
+	case webhookFormatDiscord:
+		return json.Marshal(map[string]string{"content": text})
+	}
+	return json.Marshal(ev)
+}
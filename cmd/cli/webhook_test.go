@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_webhookFormat(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://hooks.slack.com/services/T000/B000/XXXX", webhookFormatSlack},
+		{"https://discord.com/api/webhooks/123/abc", webhookFormatDiscord},
+		{"https://discordapp.com/api/webhooks/123/abc", webhookFormatDiscord},
+		{"https://discord.com/channels/123", webhookFormatJSON},
+		{"https://example.com/ctrld", webhookFormatJSON},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, webhookFormat(tc.url), tc.url)
+	}
+}
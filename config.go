@@ -16,6 +16,7 @@ import (
 	"net/url"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,6 +34,7 @@ import (
 	"tailscale.com/net/tsaddr"
 
 	"github.com/Control-D-Inc/ctrld/internal/dnsrcode"
+	"github.com/Control-D-Inc/ctrld/internal/expr"
 	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
 )
 
@@ -66,6 +68,10 @@ const (
 	endpointPrefixQUIC  = "quic://"
 	endpointPrefixH3    = "h3://"
 	endpointPrefixSdns  = "sdns://"
+
+	defaultMaxIdleConns      = 100
+	defaultIdleConnTimeout   = 90 * time.Second
+	defaultKeepAliveInterval = 10 * time.Second
 )
 
 var (
@@ -137,14 +143,28 @@ func InitConfig(v *viper.Viper, name string) {
 	})
 }
 
+// ConfigSchemaVersion is the version of config schema understood by this build. It's increased
+// whenever config settings are added or changed, so tools managing many ctrld instances could
+// check which settings are supported before deploying a config.
+const ConfigSchemaVersion = 1
+
 // Config represents ctrld supported configuration.
 type Config struct {
 	Service  ServiceConfig              `mapstructure:"service" toml:"service,omitempty"`
 	Listener map[string]*ListenerConfig `mapstructure:"listener" toml:"listener" validate:"min=1,dive"`
 	Network  map[string]*NetworkConfig  `mapstructure:"network" toml:"network" validate:"min=1,dive"`
 	Upstream map[string]*UpstreamConfig `mapstructure:"upstream" toml:"upstream" validate:"min=1,dive"`
+	Lists    map[string][]string        `mapstructure:"lists" toml:"lists,omitempty"`
+	Records  []Rule                     `mapstructure:"records" toml:"records,omitempty,inline,multiline" validate:"dive,len=1"`
 }
 
+const (
+	// DomainListPrefix is the prefix of domain list references, e.g: "list.streaming".
+	DomainListPrefix = "list."
+	// DomainListExclude is the prefix of domain list entries which are excluded from the list.
+	DomainListExclude = "!"
+)
+
 // HasUpstreamSendClientInfo reports whether the config has any upstream
 // is configured to send client info to Control D DNS server.
 func (c *Config) HasUpstreamSendClientInfo() bool {
@@ -196,39 +216,146 @@ func (c *Config) FirstUpstream() *UpstreamConfig {
 
 // ServiceConfig specifies the general ctrld config.
 type ServiceConfig struct {
-	LogLevel                string         `mapstructure:"log_level" toml:"log_level,omitempty"`
-	LogPath                 string         `mapstructure:"log_path" toml:"log_path,omitempty"`
-	CacheEnable             bool           `mapstructure:"cache_enable" toml:"cache_enable,omitempty"`
-	CacheSize               int            `mapstructure:"cache_size" toml:"cache_size,omitempty"`
-	CacheTTLOverride        int            `mapstructure:"cache_ttl_override" toml:"cache_ttl_override,omitempty"`
-	CacheServeStale         bool           `mapstructure:"cache_serve_stale" toml:"cache_serve_stale,omitempty"`
-	CacheFlushDomains       []string       `mapstructure:"cache_flush_domains" toml:"cache_flush_domains" validate:"max=256"`
-	MaxConcurrentRequests   *int           `mapstructure:"max_concurrent_requests" toml:"max_concurrent_requests,omitempty" validate:"omitempty,gte=0"`
-	DHCPLeaseFile           string         `mapstructure:"dhcp_lease_file_path" toml:"dhcp_lease_file_path" validate:"omitempty,file"`
-	DHCPLeaseFileFormat     string         `mapstructure:"dhcp_lease_file_format" toml:"dhcp_lease_file_format" validate:"required_unless=DHCPLeaseFile '',omitempty,oneof=dnsmasq isc-dhcp"`
-	DiscoverMDNS            *bool          `mapstructure:"discover_mdns" toml:"discover_mdns,omitempty"`
-	DiscoverARP             *bool          `mapstructure:"discover_arp" toml:"discover_arp,omitempty"`
-	DiscoverDHCP            *bool          `mapstructure:"discover_dhcp" toml:"discover_dhcp,omitempty"`
-	DiscoverPtr             *bool          `mapstructure:"discover_ptr" toml:"discover_ptr,omitempty"`
-	DiscoverHosts           *bool          `mapstructure:"discover_hosts" toml:"discover_hosts,omitempty"`
-	DiscoverRefreshInterval int            `mapstructure:"discover_refresh_interval" toml:"discover_refresh_interval,omitempty"`
-	ClientIDPref            string         `mapstructure:"client_id_preference" toml:"client_id_preference,omitempty" validate:"omitempty,oneof=host mac"`
-	MetricsQueryStats       bool           `mapstructure:"metrics_query_stats" toml:"metrics_query_stats,omitempty"`
-	MetricsListener         string         `mapstructure:"metrics_listener" toml:"metrics_listener,omitempty"`
-	DnsWatchdogEnabled      *bool          `mapstructure:"dns_watchdog_enabled" toml:"dns_watchdog_enabled,omitempty"`
-	DnsWatchdogInvterval    *time.Duration `mapstructure:"dns_watchdog_interval" toml:"dns_watchdog_interval,omitempty"`
-	RefetchTime             *int           `mapstructure:"refetch_time" toml:"refetch_time,omitempty"`
-	ForceRefetchWaitTime    *int           `mapstructure:"force_refetch_wait_time" toml:"force_refetch_wait_time,omitempty"`
-	LeakOnUpstreamFailure   *bool          `mapstructure:"leak_on_upstream_failure" toml:"leak_on_upstream_failure,omitempty"`
-	Daemon                  bool           `mapstructure:"-" toml:"-"`
-	AllocateIP              bool           `mapstructure:"-" toml:"-"`
+	LogLevel                     string            `mapstructure:"log_level" toml:"log_level,omitempty"`
+	LogPath                      string            `mapstructure:"log_path" toml:"log_path,omitempty"`
+	QueryLogPath                 string            `mapstructure:"query_log_path" toml:"query_log_path,omitempty"`
+	QueryLogBackend              string            `mapstructure:"query_log_backend" toml:"query_log_backend,omitempty" validate:"omitempty,oneof=file sqlite"`
+	QueryLogMaxAge               *time.Duration    `mapstructure:"query_log_max_age" toml:"query_log_max_age,omitempty"`
+	QueryLogMaxSize              *int              `mapstructure:"query_log_max_size" toml:"query_log_max_size,omitempty" validate:"omitempty,gte=0"`
+	QueryLogAnonymizeIP          string            `mapstructure:"query_log_anonymize_ip" toml:"query_log_anonymize_ip,omitempty" validate:"omitempty,oneof=hash truncate"`
+	QueryLogDomainDepth          *int              `mapstructure:"query_log_domain_depth" toml:"query_log_domain_depth,omitempty" validate:"omitempty,gte=0"`
+	QueryLogExportURL            string            `mapstructure:"query_log_export_url" toml:"query_log_export_url,omitempty" validate:"omitempty,url"`
+	QueryLogExportType           string            `mapstructure:"query_log_export_type" toml:"query_log_export_type,omitempty" validate:"omitempty,oneof=http clickhouse"`
+	QueryLogExportTable          string            `mapstructure:"query_log_export_table" toml:"query_log_export_table,omitempty"`
+	QueryLogExportBatchSize      *int              `mapstructure:"query_log_export_batch_size" toml:"query_log_export_batch_size,omitempty" validate:"omitempty,gt=0"`
+	QueryLogExportInterval       *time.Duration    `mapstructure:"query_log_export_interval" toml:"query_log_export_interval,omitempty"`
+	SinkholeLogPath              string            `mapstructure:"sinkhole_log_path" toml:"sinkhole_log_path,omitempty"`
+	SinkholeLogBackend           string            `mapstructure:"sinkhole_log_backend" toml:"sinkhole_log_backend,omitempty" validate:"omitempty,oneof=file sqlite"`
+	CacheEnable                  bool              `mapstructure:"cache_enable" toml:"cache_enable,omitempty"`
+	CacheSize                    int               `mapstructure:"cache_size" toml:"cache_size,omitempty"`
+	CacheMaxMemory               string            `mapstructure:"cache_max_memory" toml:"cache_max_memory,omitempty" validate:"omitempty,bytesize"`
+	CacheEviction                string            `mapstructure:"cache_eviction" toml:"cache_eviction,omitempty" validate:"omitempty,oneof=lru lfu arc"`
+	CacheTTLOverride             int               `mapstructure:"cache_ttl_override" toml:"cache_ttl_override,omitempty"`
+	CacheServeStale              bool              `mapstructure:"cache_serve_stale" toml:"cache_serve_stale,omitempty"`
+	CacheFlushDomains            []string          `mapstructure:"cache_flush_domains" toml:"cache_flush_domains" validate:"max=256"`
+	CachePeers                   []string          `mapstructure:"cache_peers" toml:"cache_peers,omitempty" validate:"dive,hostname_port"`
+	CachePeerListen              string            `mapstructure:"cache_peer_listen" toml:"cache_peer_listen,omitempty" validate:"omitempty,hostname_port"`
+	CachePeerSecret              string            `mapstructure:"cache_peer_secret" toml:"cache_peer_secret,omitempty" validate:"required_with=CachePeers CachePeerListen,omitempty,min=16"`
+	CacheBackend                 string            `mapstructure:"cache_backend" toml:"cache_backend,omitempty" validate:"omitempty,oneof=memory redis"`
+	CacheRedisURL                string            `mapstructure:"cache_redis_url" toml:"cache_redis_url,omitempty" validate:"required_if=CacheBackend redis"`
+	CacheServfailTTL             *time.Duration    `mapstructure:"cache_servfail_ttl" toml:"cache_servfail_ttl,omitempty"`
+	HAPeer                       string            `mapstructure:"ha_peer" toml:"ha_peer,omitempty" validate:"omitempty,hostname_port"`
+	HAListen                     string            `mapstructure:"ha_listen" toml:"ha_listen,omitempty" validate:"required_with=HAPeer"`
+	HASecret                     string            `mapstructure:"ha_secret" toml:"ha_secret,omitempty" validate:"required_with=HAPeer,omitempty,min=16"`
+	HAPriority                   int               `mapstructure:"ha_priority" toml:"ha_priority,omitempty" validate:"gte=0,lte=255"`
+	HAVirtualIP                  string            `mapstructure:"ha_virtual_ip" toml:"ha_virtual_ip,omitempty" validate:"omitempty,cidr"`
+	HAInterface                  string            `mapstructure:"ha_interface" toml:"ha_interface,omitempty" validate:"required_with=HAVirtualIP"`
+	HANotifyCommand              string            `mapstructure:"ha_notify_command" toml:"ha_notify_command,omitempty"`
+	HAFailoverTimeout            *time.Duration    `mapstructure:"ha_failover_timeout" toml:"ha_failover_timeout,omitempty"`
+	PluginCommand                string            `mapstructure:"plugin_command" toml:"plugin_command,omitempty"`
+	PluginEvents                 []string          `mapstructure:"plugin_events" toml:"plugin_events,omitempty" validate:"unique,dive,oneof=query response start stop upstream"`
+	PluginTimeout                *time.Duration    `mapstructure:"plugin_timeout" toml:"plugin_timeout,omitempty"`
+	WebhookURLs                  []string          `mapstructure:"webhook_urls" toml:"webhook_urls,omitempty" validate:"dive,url"`
+	WebhookEvents                []string          `mapstructure:"webhook_events" toml:"webhook_events,omitempty" validate:"unique,dive,oneof=upstreams_down upstreams_up config_reload_failed client_joined anomaly upstream_cert_expiring upstream_cert_changed"`
+	SMTPServer                   string            `mapstructure:"smtp_server" toml:"smtp_server,omitempty" validate:"omitempty,hostname_port"`
+	SMTPTLS                      string            `mapstructure:"smtp_tls" toml:"smtp_tls,omitempty" validate:"omitempty,oneof=tls starttls"`
+	SMTPUsername                 string            `mapstructure:"smtp_username" toml:"smtp_username,omitempty"`
+	SMTPPassword                 string            `mapstructure:"smtp_password" toml:"smtp_password,omitempty"`
+	SMTPFrom                     string            `mapstructure:"smtp_from" toml:"smtp_from,omitempty" validate:"required_with=SMTPServer,omitempty,email"`
+	SMTPTo                       []string          `mapstructure:"smtp_to" toml:"smtp_to,omitempty" validate:"required_with=SMTPServer,dive,email"`
+	SMTPEvents                   []string          `mapstructure:"smtp_events" toml:"smtp_events,omitempty" validate:"unique,dive,oneof=upstreams_down upstreams_up config_reload_failed client_joined anomaly upstream_cert_expiring upstream_cert_changed"`
+	HookStart                    string            `mapstructure:"hook_start" toml:"hook_start,omitempty"`
+	HookStop                     string            `mapstructure:"hook_stop" toml:"hook_stop,omitempty"`
+	HookDNSApplied               string            `mapstructure:"hook_dns_applied" toml:"hook_dns_applied,omitempty"`
+	HookDNSRestored              string            `mapstructure:"hook_dns_restored" toml:"hook_dns_restored,omitempty"`
+	HookTimeout                  *time.Duration    `mapstructure:"hook_timeout" toml:"hook_timeout,omitempty"`
+	MaxConcurrentRequests        *int              `mapstructure:"max_concurrent_requests" toml:"max_concurrent_requests,omitempty" validate:"omitempty,gte=0"`
+	MaxQueuedRequests            *int              `mapstructure:"max_queued_requests" toml:"max_queued_requests,omitempty" validate:"omitempty,gte=0"`
+	DHCPLeaseFile                string            `mapstructure:"dhcp_lease_file_path" toml:"dhcp_lease_file_path" validate:"omitempty,file"`
+	DHCPLeaseFileFormat          string            `mapstructure:"dhcp_lease_file_format" toml:"dhcp_lease_file_format" validate:"required_unless=DHCPLeaseFile '',omitempty,oneof=dnsmasq isc-dhcp"`
+	StaticLeaseFiles             []StaticLeaseFile `mapstructure:"static_lease_files" toml:"static_lease_files,omitempty" validate:"dive"`
+	DiscoverMDNS                 *bool             `mapstructure:"discover_mdns" toml:"discover_mdns,omitempty"`
+	DiscoverARP                  *bool             `mapstructure:"discover_arp" toml:"discover_arp,omitempty"`
+	DiscoverDHCP                 *bool             `mapstructure:"discover_dhcp" toml:"discover_dhcp,omitempty"`
+	DiscoverPtr                  *bool             `mapstructure:"discover_ptr" toml:"discover_ptr,omitempty"`
+	DiscoverHosts                *bool             `mapstructure:"discover_hosts" toml:"discover_hosts,omitempty"`
+	DiscoverSSDP                 *bool             `mapstructure:"discover_ssdp" toml:"discover_ssdp,omitempty"`
+	DiscoverNetBIOS              *bool             `mapstructure:"discover_netbios" toml:"discover_netbios,omitempty"`
+	DiscoverRefreshInterval      int               `mapstructure:"discover_refresh_interval" toml:"discover_refresh_interval,omitempty"`
+	ClientIDPref                 string            `mapstructure:"client_id_preference" toml:"client_id_preference,omitempty" validate:"omitempty,oneof=host mac"`
+	MetricsQueryStats            bool              `mapstructure:"metrics_query_stats" toml:"metrics_query_stats,omitempty"`
+	MetricsListener              string            `mapstructure:"metrics_listener" toml:"metrics_listener,omitempty"`
+	MetricsPushEndpoint          string            `mapstructure:"metrics_push_endpoint" toml:"metrics_push_endpoint,omitempty" validate:"omitempty,url"`
+	MetricsPushInterval          *time.Duration    `mapstructure:"metrics_push_interval" toml:"metrics_push_interval,omitempty"`
+	OtelTracesEndpoint           string            `mapstructure:"otel_traces_endpoint" toml:"otel_traces_endpoint,omitempty" validate:"omitempty,url"`
+	OtelTracesSampleRatio        *float64          `mapstructure:"otel_traces_sample_ratio" toml:"otel_traces_sample_ratio,omitempty" validate:"omitempty,gte=0,lte=1"`
+	DebugEndpoints               bool              `mapstructure:"debug_endpoints" toml:"debug_endpoints,omitempty"`
+	DnsWatchdogEnabled           *bool             `mapstructure:"dns_watchdog_enabled" toml:"dns_watchdog_enabled,omitempty"`
+	DnsWatchdogInvterval         *time.Duration    `mapstructure:"dns_watchdog_interval" toml:"dns_watchdog_interval,omitempty"`
+	DnsWatchdogGracePeriod       *time.Duration    `mapstructure:"dns_watchdog_grace_period" toml:"dns_watchdog_grace_period,omitempty"`
+	RefetchTime                  *int              `mapstructure:"refetch_time" toml:"refetch_time,omitempty"`
+	ForceRefetchWaitTime         *int              `mapstructure:"force_refetch_wait_time" toml:"force_refetch_wait_time,omitempty"`
+	PushUpdates                  *bool             `mapstructure:"push_updates" toml:"push_updates,omitempty"`
+	ConfigGitRepo                string            `mapstructure:"config_git_repo" toml:"config_git_repo,omitempty"`
+	ConfigGitBranch              string            `mapstructure:"config_git_branch" toml:"config_git_branch,omitempty"`
+	ConfigGitFile                string            `mapstructure:"config_git_file" toml:"config_git_file,omitempty"`
+	ConfigGitInterval            *time.Duration    `mapstructure:"config_git_interval" toml:"config_git_interval,omitempty"`
+	LeakOnUpstreamFailure        *bool             `mapstructure:"leak_on_upstream_failure" toml:"leak_on_upstream_failure,omitempty"`
+	FailClosed                   bool              `mapstructure:"fail_closed" toml:"fail_closed,omitempty"`
+	ShutdownDrainTimeout         *time.Duration    `mapstructure:"shutdown_drain_timeout" toml:"shutdown_drain_timeout,omitempty"`
+	TLSSessionCacheFile          string            `mapstructure:"tls_session_cache_file" toml:"tls_session_cache_file,omitempty"`
+	TLSMinVersion                string            `mapstructure:"tls_min_version" toml:"tls_min_version,omitempty" validate:"omitempty,oneof=1.2 1.3"`
+	TLSCipherSuites              []string          `mapstructure:"tls_cipher_suites" toml:"tls_cipher_suites,omitempty" validate:"dive,tlsciphersuite"`
+	UpstreamLatencyWarnThreshold *time.Duration    `mapstructure:"upstream_latency_warn_threshold" toml:"upstream_latency_warn_threshold,omitempty"`
+	UpstreamCertWarnThreshold    *time.Duration    `mapstructure:"upstream_cert_warn_threshold" toml:"upstream_cert_warn_threshold,omitempty"`
+	UpstreamCheckInterval        *time.Duration    `mapstructure:"upstream_check_interval" toml:"upstream_check_interval,omitempty"`
+	UpstreamCheckJitter          *time.Duration    `mapstructure:"upstream_check_jitter" toml:"upstream_check_jitter,omitempty"`
+	UpstreamRecoveryChecks       *int              `mapstructure:"upstream_recovery_checks" toml:"upstream_recovery_checks,omitempty" validate:"omitempty,gte=1"`
+	CdAPIURL                     string            `mapstructure:"cd_api_url" toml:"cd_api_url,omitempty" validate:"omitempty,url,startswith=https://"`
+	IfaceInclude                 []string          `mapstructure:"iface_include" toml:"iface_include,omitempty"`
+	IfaceExclude                 []string          `mapstructure:"iface_exclude" toml:"iface_exclude,omitempty"`
+	FirewallRedirect             bool              `mapstructure:"firewall_redirect" toml:"firewall_redirect,omitempty"`
+	FirewallRedirectMode         string            `mapstructure:"firewall_redirect_mode" toml:"firewall_redirect_mode,omitempty" validate:"omitempty,oneof=redirect tproxy"`
+	Coexist                      bool              `mapstructure:"coexist" toml:"coexist,omitempty"`
+	FirewallBlockDoH             *bool             `mapstructure:"firewall_block_doh" toml:"firewall_block_doh,omitempty"`
+	BlockDohCanary               *bool             `mapstructure:"block_doh_canary" toml:"block_doh_canary,omitempty"`
+	ICloudPrivateRelay           string            `mapstructure:"icloud_private_relay" toml:"icloud_private_relay,omitempty" validate:"omitempty,oneof=allow block"`
+	SafeSearch                   bool              `mapstructure:"safe_search" toml:"safe_search,omitempty"`
+	ThreatFeeds                  []string          `mapstructure:"threat_feeds" toml:"threat_feeds,omitempty" validate:"dive,url"`
+	ThreatFeedRefreshInterval    *time.Duration    `mapstructure:"threat_feed_refresh_interval" toml:"threat_feed_refresh_interval,omitempty"`
+	AnomalyDetection             bool              `mapstructure:"anomaly_detection" toml:"anomaly_detection,omitempty"`
+	AnomalyNxdomainThreshold     *int              `mapstructure:"anomaly_nxdomain_threshold" toml:"anomaly_nxdomain_threshold,omitempty" validate:"omitempty,gte=0"`
+	AnomalyDgaThreshold          *int              `mapstructure:"anomaly_dga_threshold" toml:"anomaly_dga_threshold,omitempty" validate:"omitempty,gte=0"`
+	AnomalyRateLimit             *int              `mapstructure:"anomaly_rate_limit" toml:"anomaly_rate_limit,omitempty" validate:"omitempty,gte=0"`
+	AnomalyWebhookURL            string            `mapstructure:"anomaly_webhook_url" toml:"anomaly_webhook_url,omitempty" validate:"omitempty,url"`
+	LocalDomainAction            string            `mapstructure:"local_domain_action" toml:"local_domain_action,omitempty" validate:"specialuseaction"`
+	HomeArpaDomainAction         string            `mapstructure:"home_arpa_domain_action" toml:"home_arpa_domain_action,omitempty" validate:"specialuseaction"`
+	LinkLocalPtrAction           string            `mapstructure:"link_local_ptr_action" toml:"link_local_ptr_action,omitempty" validate:"specialuseaction"`
+	ReverseZoneAuto              *bool             `mapstructure:"reverse_zone_auto" toml:"reverse_zone_auto,omitempty"`
+	ReverseZoneCidrs             []string          `mapstructure:"reverse_zone_cidrs" toml:"reverse_zone_cidrs,omitempty" validate:"dive,cidr"`
+	ReverseZoneNameserver        string            `mapstructure:"reverse_zone_nameserver" toml:"reverse_zone_nameserver,omitempty" validate:"omitempty,ip|hostname_port"`
+	TailscaleMagicDNS            *bool             `mapstructure:"tailscale_magicdns" toml:"tailscale_magicdns,omitempty"`
+	ZeroTierDNS                  *bool             `mapstructure:"zerotier_dns" toml:"zerotier_dns,omitempty"`
+	NetbirdDNS                   *bool             `mapstructure:"netbird_dns" toml:"netbird_dns,omitempty"`
+	ADDomain                     string            `mapstructure:"ad_domain" toml:"ad_domain,omitempty" validate:"omitempty,fqdn"`
+	ADDomainControllers          []string          `mapstructure:"ad_domain_controllers" toml:"ad_domain_controllers,omitempty" validate:"dive,ip|hostname_port"`
+	DeactivationPin              *int64            `mapstructure:"deactivation_pin" toml:"deactivation_pin,omitempty" validate:"omitempty,gte=0"`
+	Daemon                       bool              `mapstructure:"-" toml:"-"`
+	AllocateIP                   bool              `mapstructure:"-" toml:"-"`
+}
+
+// StaticLeaseFile specifies a file containing DHCP static leases/reservations.
+type StaticLeaseFile struct {
+	Path   string `mapstructure:"path" toml:"path" validate:"file"`
+	Format string `mapstructure:"format" toml:"format" validate:"oneof=dnsmasq-conf kea-conf pfsense-xml"`
 }
 
 // NetworkConfig specifies configuration for networks where ctrld will handle requests.
 type NetworkConfig struct {
-	Name   string       `mapstructure:"name" toml:"name,omitempty"`
-	Cidrs  []string     `mapstructure:"cidrs" toml:"cidrs,omitempty" validate:"dive,cidr"`
-	IPNets []*net.IPNet `mapstructure:"-" toml:"-"`
+	Name       string       `mapstructure:"name" toml:"name,omitempty"`
+	Cidrs      []string     `mapstructure:"cidrs" toml:"cidrs,omitempty" validate:"dive,cidr"`
+	SafeSearch *bool        `mapstructure:"safe_search" toml:"safe_search,omitempty"`
+	IPNets     []*net.IPNet `mapstructure:"-" toml:"-"`
 }
 
 // UpstreamConfig specifies configuration for upstreams that ctrld will forward requests to.
@@ -240,15 +367,36 @@ type UpstreamConfig struct {
 	Domain      string `mapstructure:"-" toml:"-"`
 	IPStack     string `mapstructure:"ip_stack" toml:"ip_stack,omitempty" validate:"ipstack"`
 	Timeout     int    `mapstructure:"timeout" toml:"timeout,omitempty" validate:"gte=0"`
+	// EDNS0 UDP buffer size advertised to legacy upstreams, defaultEDNSBufferSize is used if not set.
+	EDNSBufferSize *int `mapstructure:"edns_buffer_size" toml:"edns_buffer_size,omitempty" validate:"omitempty,gte=512,lte=4096"`
 	// The caller should not access this field directly.
 	// Use UpstreamSendClientInfo instead.
 	SendClientInfo *bool `mapstructure:"send_client_info" toml:"send_client_info,omitempty"`
 	// The caller should not access this field directly.
 	// Use IsDiscoverable instead.
 	Discoverable *bool `mapstructure:"discoverable" toml:"discoverable"`
+	// DoH transport and DoT/TCP persistent connections settings, default values are used if not set.
+	MaxIdleConns      *int           `mapstructure:"max_idle_conns" toml:"max_idle_conns,omitempty" validate:"omitempty,gte=0"`
+	IdleConnTimeout   *time.Duration `mapstructure:"idle_conn_timeout" toml:"idle_conn_timeout,omitempty"`
+	KeepAliveInterval *time.Duration `mapstructure:"keepalive_interval" toml:"keepalive_interval,omitempty"`
+	// Encrypted Client Hello mode of DoH upstream, ECH is not used if not set.
+	ECH string `mapstructure:"ech" toml:"ech,omitempty" validate:"omitempty,oneof=auto required"`
+	// Base64 encoded ECHConfigList of DoH upstream, required if ECH is ECHModeRequired.
+	ECHConfig string `mapstructure:"ech_config" toml:"ech_config,omitempty" validate:"required_if=ECH required,omitempty,base64"`
+	// HTTP method of DoH/DoH3 queries, DoHMethodGet is used if not set.
+	DoHMethod string `mapstructure:"doh_method" toml:"doh_method,omitempty" validate:"omitempty,oneof=get post"`
+	// Disable HTTP/2, DoH queries are sent over HTTP/1.1.
+	DoHHTTP1 bool `mapstructure:"doh_http1" toml:"doh_http1,omitempty"`
+	// Minimum TLS version and allowed cipher suites of encrypted upstreams, the service ones are used if not set.
+	TLSMinVersion   string   `mapstructure:"tls_min_version" toml:"tls_min_version,omitempty" validate:"omitempty,oneof=1.2 1.3"`
+	TLSCipherSuites []string `mapstructure:"tls_cipher_suites" toml:"tls_cipher_suites,omitempty" validate:"dive,tlsciphersuite"`
 
 	g                  singleflight.Group
 	rebootstrap        atomic.Bool
+	ednsDowngradeUntil atomic.Int64
+	echConfigList      atomic.Pointer[[]byte]
+	echLookupFailures  atomic.Int32
+	echLookupRetryAt   atomic.Int64
 	bootstrapIPs       []string
 	bootstrapIPs4      []string
 	bootstrapIPs6      []string
@@ -260,17 +408,38 @@ type UpstreamConfig struct {
 	http3RoundTripper4 http.RoundTripper
 	http3RoundTripper6 http.RoundTripper
 	certPool           *x509.CertPool
+	sessionCache       *TLSSessionCache
+	dnsConns           dnsConnPool
+	openConns          atomic.Int64
 	u                  *url.URL
 	uid                string
+
+	// TLS policy of the service config, see SetDefaultTLSPolicy.
+	defaultTLSMinVersion   string
+	defaultTLSCipherSuites []string
+
+	// Last certificate presented by the upstream, see SetCertObserver.
+	cert         atomic.Pointer[UpstreamCert]
+	certObserver func(prev, cert *UpstreamCert)
 }
 
 // ListenerConfig specifies the networks configuration that ctrld will run on.
 type ListenerConfig struct {
-	IP              string                `mapstructure:"ip" toml:"ip,omitempty" validate:"iporempty"`
-	Port            int                   `mapstructure:"port" toml:"port,omitempty" validate:"gte=0"`
-	Restricted      bool                  `mapstructure:"restricted" toml:"restricted,omitempty"`
-	AllowWanClients bool                  `mapstructure:"allow_wan_clients" toml:"allow_wan_clients,omitempty"`
-	Policy          *ListenerPolicyConfig `mapstructure:"policy" toml:"policy,omitempty"`
+	IP                   string                `mapstructure:"ip" toml:"ip,omitempty" validate:"iporempty"`
+	Port                 int                   `mapstructure:"port" toml:"port,omitempty" validate:"gte=0"`
+	Addresses            []string              `mapstructure:"addresses" toml:"addresses,omitempty" validate:"dive,listenaddr"`
+	Restricted           bool                  `mapstructure:"restricted" toml:"restricted,omitempty"`
+	AllowWanClients      bool                  `mapstructure:"allow_wan_clients" toml:"allow_wan_clients,omitempty"`
+	RefuseAny            bool                  `mapstructure:"refuse_any" toml:"refuse_any,omitempty"`
+	UDPSockets           *int                  `mapstructure:"udp_sockets" toml:"udp_sockets,omitempty" validate:"omitempty,gte=0"`
+	UDPBatchSize         *int                  `mapstructure:"udp_batch_size" toml:"udp_batch_size,omitempty" validate:"omitempty,gte=0"`
+	EDNSBufferSize       *int                  `mapstructure:"edns_buffer_size" toml:"edns_buffer_size,omitempty" validate:"omitempty,gte=512,lte=4096"`
+	ProxyProtocol        bool                  `mapstructure:"proxy_protocol" toml:"proxy_protocol,omitempty"`
+	ProxyProtocolTrusted []string              `mapstructure:"proxy_protocol_trusted" toml:"proxy_protocol_trusted,omitempty" validate:"dive,ip|cidr"`
+	Policy               *ListenerPolicyConfig `mapstructure:"policy" toml:"policy,omitempty"`
+	VPNInterfaces        []string              `mapstructure:"vpn_interfaces" toml:"vpn_interfaces,omitempty"`
+	VPNPolicy            *ListenerPolicyConfig `mapstructure:"vpn_policy" toml:"vpn_policy,omitempty"`
+	RRL                  *RRLConfig            `mapstructure:"rrl" toml:"rrl,omitempty"`
 }
 
 // IsDirectDnsListener reports whether ctrld can be a direct listener on port 53.
@@ -291,16 +460,106 @@ func (lc *ListenerConfig) IsDirectDnsListener() bool {
 	}
 }
 
+// ListenAddrs returns all addresses the listener listens on, the one of IP and Port first,
+// followed by Addresses. The listener port is used for addresses without port.
+func (lc *ListenerConfig) ListenAddrs() []string {
+	port := strconv.Itoa(lc.Port)
+	addrs := []string{net.JoinHostPort(lc.IP, port)}
+	for _, addr := range lc.Addresses {
+		host, addrPort, err := net.SplitHostPort(addr)
+		if err != nil {
+			host, addrPort = strings.Trim(addr, "[]"), port
+		}
+		if addr := net.JoinHostPort(host, addrPort); !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// RRLConfig specifies the response rate limiting settings of a listener.
+type RRLConfig struct {
+	ResponsesPerSecond int  `mapstructure:"responses_per_second" toml:"responses_per_second,omitempty" validate:"gte=0"`
+	Slip               *int `mapstructure:"slip" toml:"slip,omitempty" validate:"omitempty,gte=0,lte=10"`
+	IPv4PrefixLength   *int `mapstructure:"ipv4_prefix_length" toml:"ipv4_prefix_length,omitempty" validate:"omitempty,gte=8,lte=32"`
+	IPv6PrefixLength   *int `mapstructure:"ipv6_prefix_length" toml:"ipv6_prefix_length,omitempty" validate:"omitempty,gte=16,lte=128"`
+}
+
 // ListenerPolicyConfig specifies the policy rules for ctrld to filter incoming requests.
 type ListenerPolicyConfig struct {
 	Name                 string   `mapstructure:"name" toml:"name,omitempty"`
 	Networks             []Rule   `mapstructure:"networks" toml:"networks,omitempty,inline,multiline" validate:"dive,len=1"`
 	Rules                []Rule   `mapstructure:"rules" toml:"rules,omitempty,inline,multiline" validate:"dive,len=1"`
 	Macs                 []Rule   `mapstructure:"macs" toml:"macs,omitempty,inline,multiline" validate:"dive,len=1"`
+	Expressions          []Rule   `mapstructure:"expressions" toml:"expressions,omitempty,inline,multiline" validate:"dive,len=1"`
 	FailoverRcodes       []string `mapstructure:"failover_rcodes" toml:"failover_rcodes,omitempty" validate:"dive,dnsrcode"`
 	FailoverRcodeNumbers []int    `mapstructure:"-" toml:"-"`
+	StripECH             bool     `mapstructure:"strip_ech" toml:"strip_ech,omitempty"`
+	DNSSEC               string   `mapstructure:"dnssec" toml:"dnssec,omitempty" validate:"omitempty,oneof=passthrough strip request"`
+	OsResolverFallback   string   `mapstructure:"os_resolver_fallback" toml:"os_resolver_fallback,omitempty" validate:"omitempty,oneof=first last never"`
+	Priority             []string `mapstructure:"priority" toml:"priority,omitempty" validate:"unique,dive,oneof=rules macs networks expressions"`
+	Default              []string `mapstructure:"default" toml:"default,omitempty"`
+	// CompiledExpressions are the compiled expressions rules, keyed by their sources.
+	CompiledExpressions map[string]*expr.Expr `mapstructure:"-" toml:"-"`
 }
 
+// Possible values of ListenerPolicyConfig.Priority.
+const (
+	// PolicyRuleKindRules is the kind of domain rules.
+	PolicyRuleKindRules = "rules"
+	// PolicyRuleKindMacs is the kind of MAC address rules.
+	PolicyRuleKindMacs = "macs"
+	// PolicyRuleKindNetworks is the kind of network rules.
+	PolicyRuleKindNetworks = "networks"
+	// PolicyRuleKindExpressions is the kind of expression rules.
+	PolicyRuleKindExpressions = "expressions"
+)
+
+// Possible values of ListenerPolicyConfig.DNSSEC.
+const (
+	// DNSSECPassthrough forwards the DO bit as sent by clients, DNSSEC records are only returned to clients
+	// which set the DO bit.
+	DNSSECPassthrough = "passthrough"
+	// DNSSECStrip clears the DO bit of queries, and removes DNSSEC records from answers.
+	DNSSECStrip = "strip"
+	// DNSSECRequest always sets the DO bit of queries, so upstreams return DNSSEC records and validation
+	// results, DNSSEC records are only returned to clients which set the DO bit.
+	DNSSECRequest = "request"
+)
+
+// Possible values of ServiceConfig.CacheBackend.
+const (
+	// CacheBackendMemory caches answers in memory of ctrld process.
+	CacheBackendMemory = "memory"
+	// CacheBackendRedis caches answers in Redis, shared by multiple ctrld instances.
+	CacheBackendRedis = "redis"
+)
+
+// Possible values of ServiceConfig.FirewallRedirectMode.
+const (
+	// FirewallRedirectModeRedirect redirects DNS traffic to ctrld using NAT.
+	FirewallRedirectModeRedirect = "redirect"
+	// FirewallRedirectModeTProxy diverts DNS traffic to ctrld using TPROXY, keeping the original destination.
+	FirewallRedirectModeTProxy = "tproxy"
+)
+
+// Possible values of ListenerPolicyConfig.OsResolverFallback.
+const (
+	// OsResolverFallbackFirst sends queries to OS resolver before the policy upstreams.
+	OsResolverFallbackFirst = "first"
+	// OsResolverFallbackLast sends queries to OS resolver after all policy upstreams failed.
+	OsResolverFallbackLast = "last"
+	// OsResolverFallbackNever never sends queries to OS resolver, even if all upstreams are down.
+	OsResolverFallbackNever = "never"
+)
+
+const (
+	// SpecialUseDomainActionLocal answers special-use domain queries using the client info table only.
+	SpecialUseDomainActionLocal = "local"
+	// SpecialUseDomainActionNxdomain answers special-use domain queries with NXDOMAIN immediately.
+	SpecialUseDomainActionNxdomain = "nxdomain"
+)
+
 // Rule is a map from source to list of upstreams.
 // ctrld uses rule to perform requests matching and forward
 // the request to corresponding upstreams if it's matched.
@@ -402,6 +661,21 @@ func (uc *UpstreamConfig) SetCertPool(cp *x509.CertPool) {
 	uc.certPool = cp
 }
 
+// SetTLSSessionCache sets the cache used for resuming TLS/QUIC sessions.
+func (uc *UpstreamConfig) SetTLSSessionCache(c *TLSSessionCache) {
+	uc.sessionCache = c
+}
+
+// tlsSessionCache returns the session cache for the given protocol. The sessions are keyed
+// by the upstream endpoint, so each upstream resumes its own sessions only.
+func (uc *UpstreamConfig) tlsSessionCache(proto string) tls.ClientSessionCache {
+	c := uc.sessionCache
+	if c == nil {
+		c = defaultTLSSessionCache
+	}
+	return &prefixedSessionCache{prefix: proto + "|" + uc.Endpoint + "|", cache: c}
+}
+
 // SetupBootstrapIP manually find all available IPs of the upstream.
 // The first usable IP will be used as bootstrap IP of the upstream.
 func (uc *UpstreamConfig) SetupBootstrapIP() {
@@ -459,6 +733,9 @@ func (uc *UpstreamConfig) ReBootstrap() {
 	_, _, _ = uc.g.Do("ReBootstrap", func() (any, error) {
 		if uc.rebootstrap.CompareAndSwap(false, true) {
 			ProxyLogger.Load().Debug().Msg("re-bootstrapping upstream ip")
+			// The ECH config may be rotated, looking it up again.
+			uc.echConfigList.Store(nil)
+			uc.echLookupRetryAt.Store(0)
 		}
 		return true, nil
 	})
@@ -476,6 +753,15 @@ func (uc *UpstreamConfig) SetupTransport() {
 }
 
 func (uc *UpstreamConfig) setupDOHTransport() {
+	uc.newDOHTransports()
+	// The ECH config is looked up through the upstream, using transports without ECH, then they are set up again.
+	if uc.setupECH(uc.transport) {
+		uc.transport.CloseIdleConnections()
+		uc.newDOHTransports()
+	}
+}
+
+func (uc *UpstreamConfig) newDOHTransports() {
 	switch uc.IPStack {
 	case IpStackBoth, "":
 		uc.transport = uc.newDOHTransport(uc.bootstrapIPs)
@@ -496,16 +782,35 @@ func (uc *UpstreamConfig) setupDOHTransport() {
 
 func (uc *UpstreamConfig) newDOHTransport(addrs []string) *http.Transport {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.MaxIdleConnsPerHost = 100
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConns
+	if n := uc.MaxIdleConns; n != nil && *n > 0 {
+		transport.MaxIdleConnsPerHost = *n
+	}
+	transport.IdleConnTimeout = durationOrDefault(uc.IdleConnTimeout, defaultIdleConnTimeout)
 	transport.TLSClientConfig = &tls.Config{
 		RootCAs:            uc.certPool,
-		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		ClientSessionCache: uc.tlsSessionCache(ResolverTypeDOH),
+	}
+	uc.applyTLSPolicy(transport.TLSClientConfig)
+	uc.monitorCert(transport.TLSClientConfig)
+	if list := uc.echConfig(); len(list) > 0 {
+		// ECH requires TLS 1.3, the real server name is sent encrypted in the inner ClientHello.
+		transport.TLSClientConfig.MinVersion = tls.VersionTLS13
+		transport.TLSClientConfig.EncryptedClientHelloConfigList = list
 	}
 
-	// Prevent bad tcp connection hanging the requests for too long.
-	// See: https://github.com/golang/go/issues/36026
-	if t2, err := http2.ConfigureTransports(transport); err == nil {
-		t2.ReadIdleTimeout = 10 * time.Second
+	if uc.DoHHTTP1 {
+		// Some middleboxes mishandle HTTP/2, a non-nil empty TLSNextProto disables it.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	} else if t2, err := http2.ConfigureTransports(transport); err == nil {
+		// Prevent bad tcp connection hanging the requests for too long.
+		// See: https://github.com/golang/go/issues/36026
+		//
+		// The HTTP/2 PING frames sent on idle connections also keep them warm, so
+		// queries after idle periods won't pay for a new TLS handshake.
+		t2.ReadIdleTimeout = durationOrDefault(uc.KeepAliveInterval, defaultKeepAliveInterval)
 		t2.PingTimeout = 5 * time.Second
 	}
 
@@ -520,9 +825,13 @@ func (uc *UpstreamConfig) newDOHTransport(addrs []string) *http.Transport {
 			dialer := net.Dialer{Timeout: dialerTimeout, KeepAlive: dialerTimeout}
 			addr := net.JoinHostPort(uc.BootstrapIP, port)
 			Log(ctx, ProxyLogger.Load().Debug(), "sending doh request to: %s", addr)
-			return dialer.DialContext(ctx, network, addr)
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return uc.trackConn(conn), nil
 		}
-		pd := &ctrldnet.ParallelDialer{}
+		pd := &ctrldnet.HappyEyeballsDialer{}
 		pd.Timeout = dialerTimeout
 		pd.KeepAlive = dialerTimeout
 		dialAddrs := make([]string, len(addrs))
@@ -534,7 +843,7 @@ func (uc *UpstreamConfig) newDOHTransport(addrs []string) *http.Transport {
 			return nil, err
 		}
 		Log(ctx, ProxyLogger.Load().Debug(), "sending doh request to: %s", conn.RemoteAddr())
-		return conn, nil
+		return uc.trackConn(conn), nil
 	}
 	runtime.SetFinalizer(transport, func(transport *http.Transport) {
 		transport.CloseIdleConnections()
@@ -542,6 +851,14 @@ func (uc *UpstreamConfig) newDOHTransport(addrs []string) *http.Transport {
 	return transport
 }
 
+// durationOrDefault returns the value of d if it is positive, or defaultDuration otherwise.
+func durationOrDefault(d *time.Duration, defaultDuration time.Duration) time.Duration {
+	if d != nil && *d > 0 {
+		return *d
+	}
+	return defaultDuration
+}
+
 // Ping warms up the connection to DoH/DoH3 upstream.
 func (uc *UpstreamConfig) Ping() {
 	_ = uc.ping()
@@ -625,7 +942,7 @@ func (uc *UpstreamConfig) dohTransport(dnsType uint16) http.RoundTripper {
 	uc.transportOnce.Do(func() {
 		uc.SetupTransport()
 	})
-	if uc.rebootstrap.CompareAndSwap(true, false) {
+	if uc.rebootstrap.CompareAndSwap(true, false) || uc.echLookupRetryDue(time.Now()) {
 		uc.SetupTransport()
 	}
 	switch uc.IPStack {
@@ -751,10 +1068,22 @@ func (uc *UpstreamConfig) initDnsStamps() error {
 
 // Init initialized necessary values for an ListenerConfig.
 func (lc *ListenerConfig) Init() {
-	if lc.Policy != nil {
-		lc.Policy.FailoverRcodeNumbers = make([]int, len(lc.Policy.FailoverRcodes))
-		for i, rcode := range lc.Policy.FailoverRcodes {
-			lc.Policy.FailoverRcodeNumbers[i] = dnsrcode.FromString(rcode)
+	for _, policy := range []*ListenerPolicyConfig{lc.Policy, lc.VPNPolicy} {
+		if policy == nil {
+			continue
+		}
+		policy.FailoverRcodeNumbers = make([]int, len(policy.FailoverRcodes))
+		for i, rcode := range policy.FailoverRcodes {
+			policy.FailoverRcodeNumbers[i] = dnsrcode.FromString(rcode)
+		}
+		policy.CompiledExpressions = make(map[string]*expr.Expr, len(policy.Expressions))
+		for _, rule := range policy.Expressions {
+			for source := range rule {
+				// Invalid expressions were reported when validating config.
+				if e, err := expr.Compile(source); err == nil {
+					policy.CompiledExpressions[source] = e
+				}
+			}
 		}
 	}
 }
@@ -764,10 +1093,127 @@ func ValidateConfig(validate *validator.Validate, cfg *Config) error {
 	_ = validate.RegisterValidation("dnsrcode", validateDnsRcode)
 	_ = validate.RegisterValidation("ipstack", validateIpStack)
 	_ = validate.RegisterValidation("iporempty", validateIpOrEmpty)
+	_ = validate.RegisterValidation("specialuseaction", validateSpecialUseDomainAction)
+	_ = validate.RegisterValidation("tlsciphersuite", validateTLSCipherSuite)
+	_ = validate.RegisterValidation("listenaddr", validateListenAddr)
+	_ = validate.RegisterValidation("bytesize", validateByteSize)
 	validate.RegisterStructValidation(upstreamConfigStructLevelValidation, UpstreamConfig{})
+	validate.RegisterStructValidation(configStructLevelValidation, Config{})
 	return validate.Struct(cfg)
 }
 
+// configStructLevelValidation ensures all domain list references are defined, and lists do not reference themselves.
+func configStructLevelValidation(sl validator.StructLevel) {
+	cfg := sl.Current().Addr().Interface().(*Config)
+	for name := range cfg.Lists {
+		if err := cfg.checkDomainList(name, nil); err != nil {
+			sl.ReportError(cfg.Lists, "lists", "Lists", "domainlist", err.Error())
+		}
+	}
+	for _, record := range cfg.Records {
+		for name, values := range record {
+			if err := checkLocalRecord(name, values); err != nil {
+				sl.ReportError(cfg.Records, "records", "Records", "record", err.Error())
+			}
+		}
+	}
+	for _, lc := range cfg.Listener {
+		for _, policy := range []*ListenerPolicyConfig{lc.Policy, lc.VPNPolicy} {
+			if policy == nil {
+				continue
+			}
+			for _, rule := range policy.Rules {
+				for source := range rule {
+					if name, ok := strings.CutPrefix(source, DomainListPrefix); ok && cfg.Lists[name] == nil {
+						sl.ReportError(policy.Rules, "rules", "Rules", "domainlist", source)
+					}
+				}
+			}
+			for _, rule := range policy.Expressions {
+				for source := range rule {
+					if err := cfg.checkExpression(source); err != nil {
+						sl.ReportError(policy.Expressions, "expressions", "Expressions", "expression", err.Error())
+					}
+				}
+			}
+		}
+	}
+}
+
+// checkDomainList reports an error if domain list name, or any lists it references, is undefined or cyclic.
+func (c *Config) checkDomainList(name string, seen []string) error {
+	if slices.Contains(seen, name) {
+		return fmt.Errorf("domain list cycle: %s", strings.Join(append(seen, name), " => "))
+	}
+	entries, ok := c.Lists[name]
+	if !ok {
+		return fmt.Errorf("undefined domain list: %s", name)
+	}
+	seen = append(seen, name)
+	for _, entry := range entries {
+		entry = strings.TrimPrefix(entry, DomainListExclude)
+		if ref, ok := strings.CutPrefix(entry, DomainListPrefix); ok {
+			if err := c.checkDomainList(ref, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkLocalRecord reports an error if local record name, or its values, is invalid. The values are either
+// IP addresses, or a single domain, which the name is an alias of.
+func checkLocalRecord(name string, values []string) error {
+	if _, ok := dns.IsDomainName(strings.TrimPrefix(name, "*.")); !ok || name == "" {
+		return fmt.Errorf("invalid record name: %s", name)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("%s: no record values", name)
+	}
+	for _, value := range values {
+		if net.ParseIP(value) != nil {
+			continue
+		}
+		if _, ok := dns.IsDomainName(value); !ok || len(values) > 1 {
+			return fmt.Errorf("%s: record values must be IP addresses, or a single domain: %s", name, value)
+		}
+	}
+	return nil
+}
+
+// checkExpression reports an error if expression rule src is invalid, or references undefined groups or domain lists.
+func (c *Config) checkExpression(src string) error {
+	e, err := expr.Compile(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	for _, group := range e.References(expr.FuncGroup) {
+		if c.NetworkGroup(group) == nil {
+			return fmt.Errorf("%s: undefined group: %s", src, group)
+		}
+	}
+	for _, list := range e.References(expr.FuncList) {
+		if c.Lists[list] == nil {
+			return fmt.Errorf("%s: undefined domain list: %s", src, list)
+		}
+	}
+	return nil
+}
+
+// NetworkGroup returns the network of expression group name, which is either the network number, or its name.
+// Names are compared case-insensitively, since config keys, including expressions, are lowercased when loading.
+func (c *Config) NetworkGroup(name string) *NetworkConfig {
+	if nc := c.Network[name]; nc != nil {
+		return nc
+	}
+	for _, nc := range c.Network {
+		if nc != nil && strings.EqualFold(nc.Name, name) {
+			return nc
+		}
+	}
+	return nil
+}
+
 func validateDnsRcode(fl validator.FieldLevel) bool {
 	return dnsrcode.FromString(fl.Field().String()) != -1
 }
@@ -781,6 +1227,17 @@ func validateIpStack(fl validator.FieldLevel) bool {
 	}
 }
 
+// validateSpecialUseDomainAction validates the action for special-use domains, which is either
+// an action constant, or the upstream (e.g: "upstream.1") the queries are forwarded to.
+func validateSpecialUseDomainAction(fl validator.FieldLevel) bool {
+	switch val := fl.Field().String(); val {
+	case SpecialUseDomainActionLocal, SpecialUseDomainActionNxdomain, "":
+		return true
+	default:
+		return strings.HasPrefix(val, "upstream.") && len(val) > len("upstream.")
+	}
+}
+
 func validateIpOrEmpty(fl validator.FieldLevel) bool {
 	val := fl.Field().String()
 	if val == "" {
@@ -789,6 +1246,21 @@ func validateIpOrEmpty(fl validator.FieldLevel) bool {
 	return net.ParseIP(val) != nil
 }
 
+// validateListenAddr validates an additional listener address, which is either an IP, or IP and port.
+func validateListenAddr(fl validator.FieldLevel) bool {
+	val := fl.Field().String()
+	host, port, err := net.SplitHostPort(val)
+	if err != nil {
+		host, port = strings.Trim(val, "[]"), "0"
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return false
+	}
+	_, err = netip.ParseAddr(host)
+	return err == nil
+}
+
 func upstreamConfigStructLevelValidation(sl validator.StructLevel) {
 	uc := sl.Current().Addr().Interface().(*UpstreamConfig)
 	if uc.Type == ResolverTypeOS {
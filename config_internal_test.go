@@ -3,6 +3,7 @@ package ctrld
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -481,6 +482,52 @@ func TestUpstreamConfig_IsDiscoverable(t *testing.T) {
 	}
 }
 
+func TestUpstreamConfig_newDOHTransport(t *testing.T) {
+	maxIdleConns := 10
+	idleConnTimeout := time.Minute
+	tests := []struct {
+		name                string
+		uc                  *UpstreamConfig
+		maxIdleConnsPerHost int
+		idleConnTimeout     time.Duration
+	}{
+		{"default", &UpstreamConfig{}, defaultMaxIdleConns, defaultIdleConnTimeout},
+		{"custom", &UpstreamConfig{MaxIdleConns: &maxIdleConns, IdleConnTimeout: &idleConnTimeout}, maxIdleConns, idleConnTimeout},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			transport := tc.uc.newDOHTransport(nil)
+			assert.Equal(t, tc.maxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+			assert.Equal(t, tc.idleConnTimeout, transport.IdleConnTimeout)
+		})
+	}
+}
+
+func TestListenerConfig_ListenAddrs(t *testing.T) {
+	tests := []struct {
+		name string
+		lc   *ListenerConfig
+		want []string
+	}{
+		{"ip and port only", &ListenerConfig{IP: "127.0.0.1", Port: 53}, []string{"127.0.0.1:53"}},
+		{"dual stack", &ListenerConfig{IP: "::", Port: 53}, []string{"[::]:53"}},
+		{
+			"addresses",
+			&ListenerConfig{IP: "192.168.1.1", Port: 53, Addresses: []string{"fd00::1", "[fe80::1%eth0]", "10.0.0.1:5353", "[fd00::2]:5353"}},
+			[]string{"192.168.1.1:53", "[fd00::1]:53", "[fe80::1%eth0]:53", "10.0.0.1:5353", "[fd00::2]:5353"},
+		},
+		{"duplicated addresses", &ListenerConfig{IP: "127.0.0.1", Port: 53, Addresses: []string{"127.0.0.1", "127.0.0.1:53"}}, []string{"127.0.0.1:53"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.lc.ListenAddrs())
+		})
+	}
+}
+
 func ptrBool(b bool) *bool {
 	return &b
 }
@@ -3,15 +3,15 @@ package ctrld
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"net"
 	"net/http"
 	"runtime"
-	"sync"
 
 	"github.com/miekg/dns"
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
+
+	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
 )
 
 func (uc *UpstreamConfig) setupDOH3Transport() {
@@ -35,7 +35,12 @@ func (uc *UpstreamConfig) setupDOH3Transport() {
 
 func (uc *UpstreamConfig) newDOH3Transport(addrs []string) http.RoundTripper {
 	rt := &http3.RoundTripper{}
-	rt.TLSClientConfig = &tls.Config{RootCAs: uc.certPool}
+	rt.TLSClientConfig = &tls.Config{
+		RootCAs:            uc.certPool,
+		ClientSessionCache: uc.tlsSessionCache(ResolverTypeDOH3),
+	}
+	uc.applyTLSPolicy(rt.TLSClientConfig)
+	uc.monitorCert(rt.TLSClientConfig)
 	rt.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
 		_, port, _ := net.SplitHostPort(addr)
 		// if we have a bootstrap ip set, use it to avoid DNS lookup
@@ -50,7 +55,12 @@ func (uc *UpstreamConfig) newDOH3Transport(addrs []string) http.RoundTripper {
 			if err != nil {
 				return nil, err
 			}
-			return quic.DialEarly(ctx, udpConn, remoteAddr, tlsCfg, cfg)
+			conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsCfg, cfg)
+			if err != nil {
+				return nil, err
+			}
+			uc.trackQUICConn(conn)
+			return conn, nil
 		}
 		dialAddrs := make([]string, len(addrs))
 		for i := range addrs {
@@ -62,6 +72,7 @@ func (uc *UpstreamConfig) newDOH3Transport(addrs []string) http.RoundTripper {
 			return nil, err
 		}
 		ProxyLogger.Load().Debug().Msgf("sending doh3 request to: %s", conn.RemoteAddr())
+		uc.trackQUICConn(conn)
 		return conn, err
 	}
 	runtime.SetFinalizer(rt, func(rt *http3.RoundTripper) {
@@ -95,66 +106,36 @@ func (uc *UpstreamConfig) doh3Transport(dnsType uint16) http.RoundTripper {
 //
 //   - quic dialer is different with net.Dialer
 //   - simplification for quic free version
-type parallelDialerResult struct {
-	conn quic.EarlyConnection
-	err  error
+type quicDialResult struct {
+	conn    quic.EarlyConnection
+	udpConn *net.UDPConn
 }
 
 type quicParallelDialer struct{}
 
-// Dial performs parallel dialing to the given address list.
+// Dial performs dialing to the given address list, using Happy Eyeballs algorithm.
 func (d *quicParallelDialer) Dial(ctx context.Context, addrs []string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
-	if len(addrs) == 0 {
-		return nil, errors.New("empty addresses")
-	}
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	done := make(chan struct{})
-	defer close(done)
-	ch := make(chan *parallelDialerResult, len(addrs))
-	var wg sync.WaitGroup
-	wg.Add(len(addrs))
-	go func() {
-		wg.Wait()
-		close(ch)
-	}()
-
-	for _, addr := range addrs {
-		go func(addr string) {
-			defer wg.Done()
-			remoteAddr, err := net.ResolveUDPAddr("udp", addr)
-			if err != nil {
-				ch <- &parallelDialerResult{conn: nil, err: err}
-				return
-			}
-			udpConn, err := net.ListenUDP("udp", nil)
-			if err != nil {
-				ch <- &parallelDialerResult{conn: nil, err: err}
-				return
-			}
-			conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsCfg, cfg)
-			select {
-			case ch <- &parallelDialerResult{conn: conn, err: err}:
-			case <-done:
-				if conn != nil {
-					conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
-				}
-				if udpConn != nil {
-					udpConn.Close()
-				}
-			}
-		}(addr)
-	}
-
-	errs := make([]error, 0, len(addrs))
-	for res := range ch {
-		if res.err == nil {
-			cancel()
-			return res.conn, res.err
+	res, err := ctrldnet.RaceDial(ctx, addrs, ctrldnet.ConnectionAttemptDelay, func(ctx context.Context, addr string) (*quicDialResult, error) {
+		remoteAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
 		}
-		errs = append(errs, res.err)
+		conn, err := quic.DialEarly(ctx, udpConn, remoteAddr, tlsCfg, cfg)
+		if err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+		return &quicDialResult{conn: conn, udpConn: udpConn}, nil
+	}, func(res *quicDialResult) {
+		res.conn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+		res.udpConn.Close()
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, errors.Join(errs...)
+	return res.conn, nil
 }
@@ -100,17 +100,77 @@ func TestConfigValidation(t *testing.T) {
 		{"os upstream", configWithOsUpstream(t), false},
 		{"invalid rules", configWithInvalidRules(t), true},
 		{"invalid dns rcodes", configWithInvalidRcodes(t), true},
+		{"invalid os resolver fallback", configWithInvalidOsResolverFallback(t), true},
 		{"invalid max concurrent requests", configWithInvalidMaxConcurrentRequests(t), true},
+		{"invalid icloud private relay", configWithInvalidICloudPrivateRelay(t), true},
+		{"invalid query log anonymize ip", configWithInvalidQueryLogAnonymizeIP(t), true},
+		{"invalid query log export type", configWithInvalidQueryLogExportType(t), true},
+		{"invalid sinkhole log backend", configWithInvalidSinkholeLogBackend(t), true},
+		{"invalid upstream recovery checks", configWithInvalidUpstreamRecoveryChecks(t), true},
+		{"special use domain action", configWithSpecialUseDomainAction(t), false},
+		{"invalid special use domain action", configWithInvalidSpecialUseDomainAction(t), true},
 		{"non-existed lease file", configWithNonExistedLeaseFile(t), true},
 		{"lease file format required if lease file exist", configWithExistedLeaseFile(t), true},
 		{"invalid lease file format", configWithInvalidLeaseFileFormat(t), true},
+		{"invalid static lease file format", configWithInvalidStaticLeaseFileFormat(t), true},
+		{"invalid ad domain controllers", configWithInvalidADDomainControllers(t), true},
+		{"invalid rrl slip", configWithInvalidRRLSlip(t), true},
+		{"invalid policy priority", configWithInvalidPolicyPriority(t), true},
+		{"duplicated policy priority", configWithDuplicatedPolicyPriority(t), true},
+		{"domain lists", configWithDomainLists(t), false},
+		{"cache peers without secret", configWithCachePeersWithoutSecret(t), true},
+		{"cache peers with short secret", configWithCachePeersSecret(t, "short secret"), true},
+		{"cache peers with secret", configWithCachePeersSecret(t, "a-long-random-secret"), false},
+		{"redis cache backend without url", configWithRedisCacheWithoutURL(t), true},
+		{"invalid cache backend", configWithInvalidCacheBackend(t), true},
+		{"ha peer without secret", configWithHAPeerWithoutSecret(t), true},
+		{"ha peer with short secret", configWithHAPeerSecret(t, "short secret"), true},
+		{"ha peer with secret", configWithHAPeerSecret(t, "a-long-random-secret"), false},
+		{"ha virtual ip without interface", configWithHAVirtualIPWithoutInterface(t), true},
+		{"invalid plugin event", configWithInvalidPluginEvent(t), true},
+		{"invalid webhook url", configWithInvalidWebhookURL(t), true},
+		{"invalid webhook event", configWithInvalidWebhookEvent(t), true},
+		{"upstream cert events", configWithUpstreamCertEvents(t), false},
+		{"smtp server", configWithSMTPServer(t, "admin@example.com"), false},
+		{"smtp server without recipients", configWithSMTPServer(t), true},
+		{"smtp invalid recipient", configWithSMTPServer(t, "admin"), true},
+		{"proxy protocol trusted proxies", configWithProxyProtocolTrusted(t, "10.0.0.0/8", "192.168.1.1"), false},
+		{"invalid proxy protocol trusted proxy", configWithProxyProtocolTrusted(t, "10.0.0.0/33"), true},
+		{"invalid firewall redirect mode", configWithFirewallRedirectMode(t, "masquerade"), true},
+		{"firewall redirect mode tproxy", configWithFirewallRedirectMode(t, ctrld.FirewallRedirectModeTProxy), false},
+		{"policy expressions", configWithPolicyExpressions(t, `client in group("0") && qname in list("ads")`), false},
+		{"invalid policy expression", configWithPolicyExpressions(t, `qtype = "A"`), true},
+		{"undefined policy expression group", configWithPolicyExpressions(t, `client in group("iot")`), true},
+		{"undefined policy expression list", configWithPolicyExpressions(t, `qname in list("social")`), true},
+		{"undefined domain list", configWithUndefinedDomainList(t), true},
+		{"undefined domain list rule", configWithUndefinedDomainListRule(t), true},
+		{"cyclic domain lists", configWithCyclicDomainLists(t), true},
 		{"invalid doh/doh3 endpoint", configWithInvalidDoHEndpoint(t), true},
 		{"invalid client id pref", configWithInvalidClientIDPref(t), true},
+		{"listener addresses", configWithListenerAddresses(t, "::1", "[::1]:5353", "fe80::1%eth0", "10.0.0.1:53"), false},
+		{"invalid listener address", configWithListenerAddresses(t, "localhost:53"), true},
+		{"invalid listener address port", configWithListenerAddresses(t, "10.0.0.1:65536"), true},
+		{"upstream ech required", configWithUpstreamECH(t, ctrld.ECHModeRequired, "ZWNoY29uZmln"), false},
+		{"upstream ech required without ech config", configWithUpstreamECH(t, ctrld.ECHModeRequired, ""), true},
+		{"invalid upstream ech config", configWithUpstreamECH(t, ctrld.ECHModeAuto, "not base64"), true},
+		{"invalid upstream ech", configWithUpstreamECH(t, "on", ""), true},
+		{"upstream doh method post", configWithUpstreamDoHMethod(t, ctrld.DoHMethodPost), false},
+		{"invalid upstream doh method", configWithUpstreamDoHMethod(t, "PUT"), true},
+		{"policy dnssec request", configWithPolicyDNSSEC(t, ctrld.DNSSECRequest), false},
+		{"invalid policy dnssec", configWithPolicyDNSSEC(t, "validate"), true},
+		{"cache eviction lfu", configWithCacheEviction(t, "lfu"), false},
+		{"invalid cache eviction", configWithCacheEviction(t, "fifo"), true},
+		{"tls policy", configWithTLSPolicy(t, ctrld.TLSVersion13, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"), false},
+		{"invalid tls min version", configWithTLSPolicy(t, "1.0"), true},
+		{"insecure tls cipher suite", configWithTLSPolicy(t, ctrld.TLSVersion12, "TLS_RSA_WITH_RC4_128_SHA"), true},
 		{"doh endpoint without scheme", dohUpstreamEndpointWithoutScheme(t), false},
 		{"doh endpoint without type", dohUpstreamEndpointWithoutType(t), true},
 		{"doh3 endpoint without type", doh3UpstreamEndpointWithoutType(t), false},
 		{"sdns endpoint without type", sdnsUpstreamEndpointWithoutType(t), false},
 		{"maximum number of flush cache domains", configWithInvalidFlushCacheDomain(t), true},
+		{"local records", configWithRecords(t, ctrld.Rule{"nas.lan": {"192.168.1.10", "fd00::10"}}, ctrld.Rule{"*.dev.lan": {"nas.lan"}}), false},
+		{"invalid local record name", configWithRecords(t, ctrld.Rule{"nas..lan": {"192.168.1.10"}}), true},
+		{"invalid local record values", configWithRecords(t, ctrld.Rule{"nas.lan": {"192.168.1.10", "nas.example"}}), true},
 	}
 
 	for _, tc := range tests {
@@ -278,6 +338,63 @@ func configWithInvalidRcodes(t *testing.T) *ctrld.Config {
 	return cfg
 }
 
+func configWithInvalidOsResolverFallback(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{
+		Name:               "Policy with invalid OS resolver fallback",
+		Networks:           []ctrld.Rule{{"*.com": []string{"upstream.0"}}},
+		OsResolverFallback: "foo",
+	}
+	return cfg
+}
+
+func configWithInvalidICloudPrivateRelay(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.ICloudPrivateRelay = "foo"
+	return cfg
+}
+
+func configWithInvalidQueryLogAnonymizeIP(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.QueryLogAnonymizeIP = "foo"
+	return cfg
+}
+
+func configWithInvalidQueryLogExportType(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.QueryLogExportURL = "http://127.0.0.1:8123"
+	cfg.Service.QueryLogExportType = "foo"
+	return cfg
+}
+
+func configWithInvalidSinkholeLogBackend(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.SinkholeLogPath = "sinkhole.log"
+	cfg.Service.SinkholeLogBackend = "foo"
+	return cfg
+}
+
+func configWithInvalidUpstreamRecoveryChecks(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	checks := 0
+	cfg.Service.UpstreamRecoveryChecks = &checks
+	return cfg
+}
+
+func configWithSpecialUseDomainAction(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.LocalDomainAction = ctrld.SpecialUseDomainActionNxdomain
+	cfg.Service.HomeArpaDomainAction = ctrld.SpecialUseDomainActionLocal
+	cfg.Service.LinkLocalPtrAction = "upstream.0"
+	return cfg
+}
+
+func configWithInvalidSpecialUseDomainAction(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.LocalDomainAction = "upstream."
+	return cfg
+}
+
 func configWithInvalidMaxConcurrentRequests(t *testing.T) *ctrld.Config {
 	cfg := defaultConfig(t)
 	n := -1
@@ -307,6 +424,179 @@ func configWithInvalidLeaseFileFormat(t *testing.T) *ctrld.Config {
 	return cfg
 }
 
+func configWithInvalidStaticLeaseFileFormat(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	f, err := os.CreateTemp(t.TempDir(), "config.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg.Service.StaticLeaseFiles = []ctrld.StaticLeaseFile{{Path: f.Name(), Format: "foo"}}
+	return cfg
+}
+
+func configWithInvalidADDomainControllers(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.ADDomain = "corp.example.com"
+	cfg.Service.ADDomainControllers = []string{"not a dc"}
+	return cfg
+}
+
+func configWithInvalidRRLSlip(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	slip := 20
+	cfg.Listener["0"].RRL = &ctrld.RRLConfig{ResponsesPerSecond: 10, Slip: &slip}
+	return cfg
+}
+
+func configWithInvalidPolicyPriority(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{Priority: []string{"rules", "clients"}}
+	return cfg
+}
+
+func configWithDuplicatedPolicyPriority(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{Priority: []string{"rules", "rules"}}
+	return cfg
+}
+
+func configWithCachePeersWithoutSecret(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.CachePeers = []string{"192.168.1.2:5380"}
+	return cfg
+}
+
+func configWithCachePeersSecret(t *testing.T, secret string) *ctrld.Config {
+	cfg := configWithCachePeersWithoutSecret(t)
+	cfg.Service.CachePeerSecret = secret
+	return cfg
+}
+
+func configWithRedisCacheWithoutURL(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.CacheBackend = ctrld.CacheBackendRedis
+	return cfg
+}
+
+func configWithInvalidCacheBackend(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.CacheBackend = "memcached"
+	return cfg
+}
+
+func configWithHAPeerWithoutSecret(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.HAPeer = "192.168.1.2:5381"
+	cfg.Service.HAListen = "192.168.1.1:5381"
+	return cfg
+}
+
+func configWithHAPeerSecret(t *testing.T, secret string) *ctrld.Config {
+	cfg := configWithHAPeerWithoutSecret(t)
+	cfg.Service.HASecret = secret
+	return cfg
+}
+
+func configWithHAVirtualIPWithoutInterface(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.HAVirtualIP = "192.168.1.53/24"
+	return cfg
+}
+
+func configWithPolicyExpressions(t *testing.T, src string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Network = map[string]*ctrld.NetworkConfig{"0": {Name: "Network 0", Cidrs: []string{"0.0.0.0/0"}}}
+	cfg.Lists = map[string][]string{"ads": {"*.ads.com"}}
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{
+		Name:        "My Policy",
+		Expressions: []ctrld.Rule{{src: []string{"upstream.0"}}},
+	}
+	return cfg
+}
+
+func configWithInvalidWebhookURL(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.WebhookURLs = []string{"not a url"}
+	return cfg
+}
+
+func configWithInvalidWebhookEvent(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.WebhookURLs = []string{"https://example.com/ctrld"}
+	cfg.Service.WebhookEvents = []string{"upstreams_down", "query"}
+	return cfg
+}
+
+func configWithUpstreamCertEvents(t *testing.T) *ctrld.Config {
+	cfg := configWithSMTPServer(t, "admin@example.com")
+	cfg.Service.WebhookURLs = []string{"https://example.com/ctrld"}
+	cfg.Service.WebhookEvents = []string{"upstream_cert_expiring", "upstream_cert_changed"}
+	cfg.Service.SMTPEvents = []string{"upstream_cert_expiring", "upstream_cert_changed"}
+	return cfg
+}
+
+func configWithSMTPServer(t *testing.T, to ...string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.SMTPServer = "smtp.example.com:587"
+	cfg.Service.SMTPFrom = "ctrld@example.com"
+	cfg.Service.SMTPTo = to
+	return cfg
+}
+
+func configWithProxyProtocolTrusted(t *testing.T, trusted ...string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].ProxyProtocol = true
+	cfg.Listener["0"].ProxyProtocolTrusted = trusted
+	return cfg
+}
+
+func configWithFirewallRedirectMode(t *testing.T, mode string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.FirewallRedirect = true
+	cfg.Service.FirewallRedirectMode = mode
+	return cfg
+}
+
+func configWithInvalidPluginEvent(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.PluginCommand = "/usr/local/bin/ctrld-plugin"
+	cfg.Service.PluginEvents = []string{"query", "reload"}
+	return cfg
+}
+
+func configWithDomainLists(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Lists = map[string][]string{
+		"streaming": {"*.netflix.com", "list.music"},
+		"music":     {"*.spotify.com"},
+		"video":     {"list.streaming", "!list.music"},
+	}
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{Rules: []ctrld.Rule{{"list.video": []string{"upstream.0"}}}}
+	return cfg
+}
+
+func configWithUndefinedDomainList(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Lists = map[string][]string{"streaming": {"*.netflix.com", "!list.music"}}
+	return cfg
+}
+
+func configWithUndefinedDomainListRule(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{Rules: []ctrld.Rule{{"list.streaming": []string{"upstream.0"}}}}
+	return cfg
+}
+
+func configWithCyclicDomainLists(t *testing.T) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Lists = map[string][]string{
+		"streaming": {"*.netflix.com", "list.music"},
+		"music":     {"*.spotify.com", "list.streaming"},
+	}
+	return cfg
+}
+
 func configWithInvalidDoHEndpoint(t *testing.T) *ctrld.Config {
 	cfg := defaultConfig(t)
 	cfg.Upstream["0"].Endpoint = "/1.1.1.1"
@@ -314,6 +604,52 @@ func configWithInvalidDoHEndpoint(t *testing.T) *ctrld.Config {
 	return cfg
 }
 
+func configWithUpstreamECH(t *testing.T, mode, echConfig string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Upstream["0"].ECH = mode
+	cfg.Upstream["0"].ECHConfig = echConfig
+	return cfg
+}
+
+func configWithUpstreamDoHMethod(t *testing.T, method string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Upstream["0"].DoHMethod = method
+	return cfg
+}
+
+func configWithPolicyDNSSEC(t *testing.T, mode string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Policy = &ctrld.ListenerPolicyConfig{Name: "DNSSEC", DNSSEC: mode}
+	return cfg
+}
+
+func configWithCacheEviction(t *testing.T, eviction string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.CacheEviction = eviction
+	return cfg
+}
+
+func configWithTLSPolicy(t *testing.T, minVersion string, cipherSuites ...string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Service.TLSMinVersion = minVersion
+	cfg.Service.TLSCipherSuites = cipherSuites
+	cfg.Upstream["0"].TLSMinVersion = minVersion
+	cfg.Upstream["0"].TLSCipherSuites = cipherSuites
+	return cfg
+}
+
+func configWithListenerAddresses(t *testing.T, addrs ...string) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Listener["0"].Addresses = addrs
+	return cfg
+}
+
+func configWithRecords(t *testing.T, records ...ctrld.Rule) *ctrld.Config {
+	cfg := defaultConfig(t)
+	cfg.Records = records
+	return cfg
+}
+
 func configWithInvalidClientIDPref(t *testing.T) *ctrld.Config {
 	cfg := defaultConfig(t)
 	cfg.Service.ClientIDPref = "foo"
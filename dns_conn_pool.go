@@ -0,0 +1,324 @@
+package ctrld
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// pipelinedConnWriteTimeout is the write timeout used if the query context has no deadline.
+	pipelinedConnWriteTimeout = 2 * time.Second
+	// maxPipelinedQueries is the maximum number of in-flight queries on a connection.
+	maxPipelinedQueries = 1024
+)
+
+var (
+	// errPipelinedConnClosed is returned when a query is sent on closed connection.
+	errPipelinedConnClosed = errors.New("connection closed")
+	// errPipelinedConnFull is returned when a query is sent on connection having too many in-flight queries.
+	errPipelinedConnFull = errors.New("too many in-flight queries")
+)
+
+// dnsConnPool keeps persistent TCP/DoT connections to upstream. Queries are pipelined over
+// a single connection (RFC 7766), which is kept alive using edns-tcp-keepalive (RFC 7828),
+// instead of re-connecting, and doing TLS handshake again for every query. Another connection
+// is opened when all connections have too many in-flight queries.
+type dnsConnPool struct {
+	// maxQueries is the maximum number of in-flight queries per connection, maxPipelinedQueries if zero.
+	maxQueries int
+
+	mu    sync.Mutex
+	conns map[string][]*pipelinedConn
+	// dials ensures a single dial for concurrent queries of the same key, without holding mu,
+	// so queries on other live connections are not blocked by a slow upstream.
+	dials singleflight.Group
+}
+
+// exchange sends msg to endpoint using a persistent connection dialed by client.
+// If a re-used connection was closed by the upstream, the query is retried on new connection.
+func (p *dnsConnPool) exchange(ctx context.Context, client *dns.Client, msg *dns.Msg, endpoint string, idleTimeout time.Duration) (*dns.Msg, error) {
+	key := client.Net + "|" + endpoint
+	for {
+		pc, reused, err := p.conn(ctx, key, client, endpoint, idleTimeout)
+		if err != nil {
+			return nil, err
+		}
+		answer, err := pc.exchange(ctx, msg)
+		if ctx.Err() != nil {
+			return answer, err
+		}
+		// The connection was filled, or closed, by concurrent queries before the query was sent.
+		if errors.Is(err, errPipelinedConnFull) || errors.Is(err, errPipelinedConnClosed) {
+			continue
+		}
+		if err != nil && reused && !pc.isAlive() {
+			continue
+		}
+		return answer, err
+	}
+}
+
+// conn returns a live connection for key having room for another query, dialing a new one if necessary.
+// The second return value reports whether the connection was re-used.
+func (p *dnsConnPool) conn(ctx context.Context, key string, client *dns.Client, endpoint string, idleTimeout time.Duration) (*pipelinedConn, bool, error) {
+	if pc := p.liveConn(key); pc != nil {
+		return pc, true, nil
+	}
+	ch := p.dials.DoChan(key, func() (any, error) {
+		// The dial is shared by concurrent queries, so it must not be canceled with the first query.
+		// It's still bounded by the dial timeout of client.
+		conn, err := client.DialContext(context.WithoutCancel(ctx), endpoint)
+		if err != nil {
+			return nil, err
+		}
+		pc := newPipelinedConn(conn, idleTimeout, p.maxQueries)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.conns == nil {
+			p.conns = make(map[string][]*pipelinedConn)
+		}
+		p.conns[key] = append(p.conns[key], pc)
+		return pc, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, false, res.Err
+		}
+		return res.Val.(*pipelinedConn), false, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// liveConn returns a live connection for key having room for another query, or nil if there's none.
+// Closed connections are removed.
+func (p *dnsConnPool) liveConn(key string) *pipelinedConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.conns[key]
+	var found *pipelinedConn
+	live := conns[:0]
+	for _, pc := range conns {
+		alive, full := pc.state()
+		if !alive {
+			continue
+		}
+		live = append(live, pc)
+		if found == nil && !full {
+			found = pc
+		}
+	}
+	clear(conns[len(live):])
+	if len(live) == 0 {
+		delete(p.conns, key)
+	} else {
+		p.conns[key] = live
+	}
+	return found
+}
+
+// openConns returns the number of live connections in the pool.
+func (p *dnsConnPool) openConns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, conns := range p.conns {
+		for _, pc := range conns {
+			if pc.isAlive() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// pipelinedResult is the result of a query sent on pipelinedConn.
+type pipelinedResult struct {
+	answer *dns.Msg
+	err    error
+}
+
+// pipelinedConn is a TCP/DoT connection which could have multiple in-flight queries.
+// Query ids are re-written, so queries from different clients never collide.
+type pipelinedConn struct {
+	conn *dns.Conn
+
+	maxQueries int
+
+	mu          sync.Mutex // guards below fields, and writing to conn.
+	pending     map[uint16]chan pipelinedResult
+	nextID      uint16
+	idleTimeout time.Duration
+	err         error
+}
+
+// newPipelinedConn returns a new pipelinedConn for conn, allowing maxQueries in-flight queries,
+// or maxPipelinedQueries if maxQueries is non-positive.
+func newPipelinedConn(conn *dns.Conn, idleTimeout time.Duration, maxQueries int) *pipelinedConn {
+	if maxQueries <= 0 {
+		maxQueries = maxPipelinedQueries
+	}
+	pc := &pipelinedConn{
+		conn:        conn,
+		maxQueries:  maxQueries,
+		pending:     make(map[uint16]chan pipelinedResult),
+		nextID:      dns.Id(),
+		idleTimeout: idleTimeout,
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	go pc.readLoop()
+	return pc
+}
+
+// isAlive reports whether new queries could be sent on the connection.
+func (pc *pipelinedConn) isAlive() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.err == nil
+}
+
+// state reports whether new queries could be sent on the connection, and whether it has too many
+// in-flight queries to accept another one.
+func (pc *pipelinedConn) state() (alive, full bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.err == nil, len(pc.pending) >= pc.maxQueries
+}
+
+// exchange sends msg on the connection, and waits for the answer.
+func (pc *pipelinedConn) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	req := withTCPKeepalive(msg)
+	ch := make(chan pipelinedResult, 1)
+
+	pc.mu.Lock()
+	if pc.err != nil {
+		pc.mu.Unlock()
+		return nil, errPipelinedConnClosed
+	}
+	if len(pc.pending) >= pc.maxQueries {
+		pc.mu.Unlock()
+		return nil, errPipelinedConnFull
+	}
+	for {
+		req.Id = pc.nextID
+		pc.nextID++
+		if _, ok := pc.pending[req.Id]; !ok {
+			break
+		}
+	}
+	pc.pending[req.Id] = ch
+	writeDeadline := time.Now().Add(pipelinedConnWriteTimeout)
+	if deadline, ok := ctx.Deadline(); ok {
+		writeDeadline = deadline
+	}
+	_ = pc.conn.SetWriteDeadline(writeDeadline)
+	err := pc.conn.WriteMsg(req)
+	if err == nil {
+		_ = pc.conn.SetReadDeadline(time.Now().Add(pc.idleTimeout))
+	}
+	pc.mu.Unlock()
+	if err != nil {
+		pc.close(err)
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		res.answer.Id = msg.Id
+		return res.answer, nil
+	case <-ctx.Done():
+		pc.mu.Lock()
+		delete(pc.pending, req.Id)
+		pc.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop reads answers from the connection, and delivers them to waiting queries.
+func (pc *pipelinedConn) readLoop() {
+	for {
+		answer, err := pc.conn.ReadMsg()
+		if err != nil {
+			pc.close(err)
+			return
+		}
+		timeout, closeAfter := tcpKeepaliveTimeout(answer)
+		pc.mu.Lock()
+		if ch, ok := pc.pending[answer.Id]; ok {
+			delete(pc.pending, answer.Id)
+			ch <- pipelinedResult{answer: answer}
+		}
+		if timeout > 0 && timeout < pc.idleTimeout {
+			pc.idleTimeout = timeout
+		}
+		// The upstream asks us to close the connection, so stop using it for new queries.
+		if closeAfter && pc.err == nil {
+			pc.err = errPipelinedConnClosed
+		}
+		done := pc.err != nil && len(pc.pending) == 0
+		_ = pc.conn.SetReadDeadline(time.Now().Add(pc.idleTimeout))
+		pc.mu.Unlock()
+		// Close the connection once all in-flight answers were read.
+		if done {
+			pc.close(errPipelinedConnClosed)
+			return
+		}
+	}
+}
+
+// close closes the connection, in-flight queries failed with err.
+func (pc *pipelinedConn) close(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.err = errPipelinedConnClosed
+	for id, ch := range pc.pending {
+		ch <- pipelinedResult{err: err}
+		delete(pc.pending, id)
+	}
+	_ = pc.conn.Close()
+}
+
+// withTCPKeepalive returns a copy of msg with edns-tcp-keepalive option, if msg supports EDNS0.
+// The message is always copied, since its id will be re-written.
+func withTCPKeepalive(msg *dns.Msg) *dns.Msg {
+	req := msg.Copy()
+	opt := req.IsEdns0()
+	if opt == nil {
+		return req
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			return req
+		}
+	}
+	// Clients must send the option without timeout value, see RFC 7828 section 3.2.1.
+	opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+	return req
+}
+
+// tcpKeepaliveTimeout returns the idle timeout sent by upstream in edns-tcp-keepalive option of answer.
+// The second return value reports whether the upstream asks for closing the connection.
+func tcpKeepaliveTimeout(answer *dns.Msg) (time.Duration, bool) {
+	opt := answer.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for i, o := range opt.Option {
+		if ka, ok := o.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			// The option is hop-by-hop, it must not be forwarded to clients.
+			opt.Option = append(opt.Option[:i:i], opt.Option[i+1:]...)
+			// The timeout is in units of 100 milliseconds.
+			return time.Duration(ka.Timeout) * 100 * time.Millisecond, ka.Timeout == 0
+		}
+	}
+	return 0, false
+}
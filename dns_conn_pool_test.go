@@ -0,0 +1,170 @@
+package ctrld
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingListener is a net.Listener counting accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func Test_dnsConnPool_exchange(t *testing.T) {
+	tests := []struct {
+		name             string
+		keepaliveTimeout uint16
+		wantReuse        bool
+	}{
+		{"keepalive", 100, true},
+		{"upstream closes connection", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cl := &countingListener{Listener: ln}
+			s := runLocalListenerTestServer(t, cl, dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+				m := new(dns.Msg)
+				m.SetReply(msg)
+				if opt := msg.IsEdns0(); opt != nil {
+					hasKeepalive := false
+					for _, o := range opt.Option {
+						if o.Option() == dns.EDNS0TCPKEEPALIVE {
+							hasKeepalive = true
+						}
+					}
+					if !hasKeepalive {
+						t.Error("missing edns-tcp-keepalive option")
+					}
+					m.SetEdns0(opt.UDPSize(), false)
+					ka := &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: tc.keepaliveTimeout}
+					m.IsEdns0().Option = append(m.IsEdns0().Option, ka)
+				}
+				w.WriteMsg(m)
+			}))
+			defer s.Shutdown()
+
+			var pool dnsConnPool
+			client := &dns.Client{Net: "tcp"}
+			for range 3 {
+				var wg sync.WaitGroup
+				for range 5 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						msg := new(dns.Msg)
+						msg.SetQuestion("example.com.", dns.TypeA)
+						msg.SetEdns0(1232, false)
+						ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+						defer cancel()
+						answer, err := pool.exchange(ctx, client, msg, ln.Addr().String(), time.Minute)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						assert.Equal(t, msg.Id, answer.Id)
+						if opt := answer.IsEdns0(); opt != nil {
+							assert.Empty(t, opt.Option, "edns-tcp-keepalive option must be stripped")
+						}
+					}()
+				}
+				wg.Wait()
+			}
+			assert.Equal(t, tc.wantReuse, cl.accepted.Load() == 1)
+		})
+	}
+}
+
+func Test_dnsConnPool_reconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cl := &countingListener{Listener: ln}
+	s := runLocalListenerTestServer(t, cl, dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(msg)
+		w.WriteMsg(m)
+	}))
+	defer s.Shutdown()
+
+	var pool dnsConnPool
+	client := &dns.Client{Net: "tcp"}
+	for i := range 2 {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := pool.exchange(ctx, client, msg, ln.Addr().String(), time.Minute)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			// Simulate the upstream closing idle connection.
+			for _, conns := range pool.conns {
+				for _, pc := range conns {
+					pc.close(errPipelinedConnClosed)
+				}
+			}
+			assert.Zero(t, pool.openConns())
+		}
+	}
+	assert.Equal(t, int32(2), cl.accepted.Load())
+	assert.Equal(t, 1, pool.openConns())
+}
+
+func Test_dnsConnPool_full(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cl := &countingListener{Listener: ln}
+	s := runLocalListenerTestServer(t, cl, dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		// Keep queries in-flight, so the connections are full.
+		time.Sleep(200 * time.Millisecond)
+		m := new(dns.Msg)
+		m.SetReply(msg)
+		w.WriteMsg(m)
+	}))
+	defer s.Shutdown()
+
+	pool := dnsConnPool{maxQueries: 2}
+	client := &dns.Client{Net: "tcp"}
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if _, err := pool.exchange(ctx, client, msg, ln.Addr().String(), time.Minute); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// Other connections are opened instead of failing queries.
+	assert.GreaterOrEqual(t, cl.accepted.Load(), int32(3))
+	assert.Equal(t, int(cl.accepted.Load()), pool.openConns())
+}
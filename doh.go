@@ -1,7 +1,9 @@
 package ctrld
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -12,9 +14,11 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/cuonglm/osinfo"
-
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/Control-D-Inc/ctrld/internal/dnspool"
+	"github.com/Control-D-Inc/ctrld/internal/osinfo"
 )
 
 const (
@@ -26,6 +30,14 @@ const (
 	headerApplicationDNS  = "application/dns-message"
 )
 
+// Possible values of UpstreamConfig.DoHMethod.
+const (
+	// DoHMethodGet sends queries in URL of GET requests, which could be cached by HTTP proxies.
+	DoHMethodGet = "get"
+	// DoHMethodPost sends queries in body of POST requests.
+	DoHMethodPost = "post"
+)
+
 // EncodeOsNameMap provides mapping from OS name to a shorter string, used for encoding x-cd-os value.
 var EncodeOsNameMap = map[string]string{
 	"windows": "1",
@@ -84,36 +96,41 @@ type dohResolver struct {
 
 // Resolve performs DNS query with given DNS message using DOH protocol.
 func (r *dohResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
-	data, err := msg.Pack()
-	if err != nil {
-		return nil, err
-	}
-
-	enc := base64.RawURLEncoding.EncodeToString(data)
-	query := r.endpoint.Query()
-	query.Add("dns", enc)
-
-	endpoint := *r.endpoint
-	endpoint.RawQuery = query.Encode()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not create request: %w", err)
-	}
-	addHeader(ctx, req, r.uc)
 	dnsTyp := uint16(0)
 	if len(msg.Question) > 0 {
 		dnsTyp = msg.Question[0].Qtype
 	}
-	c := http.Client{Transport: r.uc.dohTransport(dnsTyp)}
+	c := &http.Client{Transport: r.uc.dohTransport(dnsTyp)}
 	if r.isDoH3 {
 		transport := r.uc.doh3Transport(dnsTyp)
 		if transport == nil {
 			return nil, errors.New("DoH3 is not supported")
 		}
 		c.Transport = transport
+	} else if err := r.uc.echError(); err != nil {
+		return nil, err
+	}
+	return r.exchange(ctx, c, msg)
+}
+
+// exchange sends msg to the upstream using client c.
+func (r *dohResolver) exchange(ctx context.Context, c *http.Client, msg *dns.Msg) (*dns.Msg, error) {
+	packed, err := dnspool.Pack(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := r.newRequest(ctx, packed.Bytes())
+	packed.Release()
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
 	}
+	addHeader(ctx, req, r.uc)
 	resp, err := c.Do(req)
 	if err != nil {
+		var echErr *tls.ECHRejectionError
+		if errors.As(err, &echErr) {
+			r.uc.echRejected(echErr.RetryConfigList)
+		}
 		if r.isDoH3 {
 			if closer, ok := c.Transport.(io.Closer); ok {
 				closer.Close()
@@ -123,22 +140,42 @@ func (r *dohResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, erro
 	}
 	defer resp.Body.Close()
 
-	buf, err := io.ReadAll(resp.Body)
-	if err != nil {
+	buf := dnspool.GetBytesBuffer()
+	defer dnspool.PutBytesBuffer(buf)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
 		return nil, fmt.Errorf("could not read message from response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("wrong response from DOH server, got: %s, status: %d", string(buf), resp.StatusCode)
+		return nil, fmt.Errorf("wrong response from DOH server, got: %s, status: %d", buf.String(), resp.StatusCode)
 	}
 
 	answer := new(dns.Msg)
-	if err := answer.Unpack(buf); err != nil {
+	if err := answer.Unpack(buf.Bytes()); err != nil {
 		return nil, fmt.Errorf("answer.Unpack: %w", err)
 	}
 	return answer, nil
 }
 
+// newRequest returns the HTTP request of packed DNS message, using the DoH method of upstream.
+func (r *dohResolver) newRequest(ctx context.Context, packed []byte) (*http.Request, error) {
+	if r.uc.DoHMethod == DoHMethodPost {
+		// The body may be read after the request is done, so it must not share the pooled buffer.
+		return http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint.String(), bytes.NewReader(bytes.Clone(packed)))
+	}
+	query := r.endpoint.Query()
+	query.Add("dns", base64.RawURLEncoding.EncodeToString(packed))
+
+	endpoint := *r.endpoint
+	endpoint.RawQuery = query.Encode()
+	method := http.MethodGet
+	if r.isDoH3 {
+		// GET requests are idempotent, so they are safe to be sent in 0-RTT.
+		method = http3.MethodGet0RTT
+	}
+	return http.NewRequestWithContext(ctx, method, endpoint.String(), nil)
+}
+
 // addHeader adds necessary HTTP header to request based on upstream config.
 func addHeader(ctx context.Context, req *http.Request, uc *UpstreamConfig) {
 	printed := false
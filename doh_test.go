@@ -1,8 +1,18 @@
 package ctrld
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
 	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_dohOsHeaderValue(t *testing.T) {
@@ -21,3 +31,65 @@ func Test_dohOsHeaderValue(t *testing.T) {
 		t.Fatalf("missing decoding value for: %q", runtime.GOOS)
 	}
 }
+
+func Test_dohResolver_method(t *testing.T) {
+	type request struct{ method, proto string }
+	requests := make(chan request, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var packed []byte
+		if r.Method == http.MethodPost {
+			packed, _ = io.ReadAll(r.Body)
+		} else {
+			packed, _ = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packed); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request{r.Method, r.Proto}
+		answer := new(dns.Msg)
+		answer.SetReply(msg)
+		buf, _ := answer.Pack()
+		w.Header().Set("Content-Type", headerApplicationDNS)
+		_, _ = w.Write(buf)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	tests := []struct {
+		name   string
+		method string
+		http1  bool
+		want   request
+	}{
+		{"default", "", false, request{http.MethodGet, "HTTP/2.0"}},
+		{"post", DoHMethodPost, false, request{http.MethodPost, "HTTP/2.0"}},
+		{"get http/1.1", DoHMethodGet, true, request{http.MethodGet, "HTTP/1.1"}},
+		{"post http/1.1", DoHMethodPost, true, request{http.MethodPost, "HTTP/1.1"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			uc := &UpstreamConfig{
+				Type:        ResolverTypeDOH,
+				Endpoint:    ts.URL + "/dns-query",
+				BootstrapIP: "127.0.0.1",
+				DoHMethod:   tc.method,
+				DoHHTTP1:    tc.http1,
+			}
+			uc.Init()
+			uc.SetCertPool(pool)
+			r, err := NewResolver(uc)
+			require.NoError(t, err)
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			answer, err := r.Resolve(context.Background(), msg)
+			require.NoError(t, err)
+			assert.Equal(t, msg.Id, answer.Id)
+			assert.Equal(t, tc.want, <-requests)
+		})
+	}
+}
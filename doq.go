@@ -5,12 +5,15 @@ package ctrld
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/quic-go/quic-go"
+
+	ctrldnet "github.com/Control-D-Inc/ctrld/internal/net"
 )
 
 type doqResolver struct {
@@ -19,27 +22,42 @@ type doqResolver struct {
 
 func (r *doqResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
 	endpoint := r.uc.Endpoint
-	tlsConfig := &tls.Config{NextProtos: []string{"doq"}}
-	ip := r.uc.BootstrapIP
-	if ip == "" {
-		dnsTyp := uint16(0)
-		if msg != nil && len(msg.Question) > 0 {
-			dnsTyp = msg.Question[0].Qtype
-		}
-		ip = r.uc.bootstrapIPForDNSType(dnsTyp)
+	tlsConfig := &tls.Config{
+		NextProtos:         []string{"doq"},
+		ClientSessionCache: r.uc.tlsSessionCache(ResolverTypeDOQ),
+	}
+	r.uc.applyTLSPolicy(tlsConfig)
+	r.uc.monitorCert(tlsConfig)
+	dnsTyp := uint16(0)
+	if msg != nil && len(msg.Question) > 0 {
+		dnsTyp = msg.Question[0].Qtype
+	}
+	ips := []string{r.uc.BootstrapIP}
+	switch {
+	case r.uc.BootstrapIP != "":
+	case r.uc.IPStack == IpStackBoth || r.uc.IPStack == "":
+		// Racing all bootstrap IPs, so broken IPv6 won't slow down the query.
+		ips = r.uc.bootstrapIPs
+	default:
+		ips = []string{r.uc.bootstrapIPForDNSType(dnsTyp)}
 	}
 	tlsConfig.ServerName = r.uc.Domain
 	_, port, _ := net.SplitHostPort(endpoint)
-	endpoint = net.JoinHostPort(ip, port)
-	return resolve(ctx, msg, endpoint, tlsConfig)
+	endpoints := make([]string, len(ips))
+	for i := range ips {
+		endpoints[i] = net.JoinHostPort(ips[i], port)
+	}
+	return resolve(ctx, msg, endpoints, tlsConfig, r.uc.trackQUICConn)
 }
 
-func resolve(ctx context.Context, msg *dns.Msg, endpoint string, tlsConfig *tls.Config) (*dns.Msg, error) {
+func resolve(ctx context.Context, msg *dns.Msg, endpoints []string, tlsConfig *tls.Config, track func(quic.Connection)) (*dns.Msg, error) {
 	// DoQ quic-go server returns io.EOF error after running for a long time,
 	// even for a good stream. So retrying the query for 5 times before giving up.
 	for i := 0; i < 5; i++ {
-		answer, err := doResolve(ctx, msg, endpoint, tlsConfig)
-		if err == io.EOF {
+		answer, err := doResolve(ctx, msg, endpoints, tlsConfig, track)
+		// If 0-RTT was rejected, the session was resumed with full handshake,
+		// so retrying will use the new session ticket.
+		if err == io.EOF || errors.Is(err, quic.Err0RTTRejected) {
 			continue
 		}
 		if err != nil {
@@ -50,11 +68,17 @@ func resolve(ctx context.Context, msg *dns.Msg, endpoint string, tlsConfig *tls.
 	return nil, &quic.ApplicationError{ErrorCode: quic.ApplicationErrorCode(quic.InternalError), ErrorMessage: quic.InternalError.Message()}
 }
 
-func doResolve(ctx context.Context, msg *dns.Msg, endpoint string, tlsConfig *tls.Config) (*dns.Msg, error) {
-	session, err := quic.DialAddr(ctx, endpoint, tlsConfig, nil)
+func doResolve(ctx context.Context, msg *dns.Msg, endpoints []string, tlsConfig *tls.Config, track func(quic.Connection)) (*dns.Msg, error) {
+	// DNS queries are safe to be sent in 0-RTT, see RFC 9250 section 4.5.
+	session, err := ctrldnet.RaceDial(ctx, endpoints, ctrldnet.ConnectionAttemptDelay, func(ctx context.Context, endpoint string) (quic.EarlyConnection, error) {
+		return quic.DialAddrEarly(ctx, endpoint, tlsConfig, nil)
+	}, func(conn quic.EarlyConnection) {
+		conn.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "")
+	})
 	if err != nil {
 		return nil, err
 	}
+	track(session)
 	defer session.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "")
 
 	msgBytes, err := msg.Pack()
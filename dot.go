@@ -26,10 +26,15 @@ func (r *dotResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, erro
 
 	tcpNet, _ := r.uc.netForDNSType(dnsTyp)
 	dnsClient := &dns.Client{
-		Net:       tcpNet,
-		Dialer:    dialer,
-		TLSConfig: &tls.Config{RootCAs: r.uc.certPool},
+		Net:    tcpNet,
+		Dialer: dialer,
+		TLSConfig: &tls.Config{
+			RootCAs:            r.uc.certPool,
+			ClientSessionCache: r.uc.tlsSessionCache(ResolverTypeDOT),
+		},
 	}
+	r.uc.applyTLSPolicy(dnsClient.TLSConfig)
+	r.uc.monitorCert(dnsClient.TLSConfig)
 	endpoint := r.uc.Endpoint
 	if r.uc.BootstrapIP != "" {
 		dnsClient.TLSConfig.ServerName = r.uc.Domain
@@ -38,6 +43,5 @@ func (r *dotResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, erro
 		endpoint = net.JoinHostPort(r.uc.BootstrapIP, port)
 	}
 
-	answer, _, err := dnsClient.ExchangeContext(ctx, msg, endpoint)
-	return answer, err
+	return r.uc.dnsConns.exchange(ctx, dnsClient, msg, endpoint, durationOrDefault(r.uc.IdleConnTimeout, defaultIdleConnTimeout))
 }
@@ -0,0 +1,184 @@
+package ctrld
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Possible values of UpstreamConfig.ECH.
+const (
+	// ECHModeAuto uses Encrypted Client Hello if the upstream advertises it, plain TLS otherwise.
+	ECHModeAuto = "auto"
+	// ECHModeRequired fails queries to the upstream if it does not advertise Encrypted Client Hello.
+	ECHModeRequired = "required"
+)
+
+// errECHNotAdvertised is returned for queries to upstreams requiring ECH, but not advertising ECHConfig.
+var errECHNotAdvertised = errors.New("upstream does not advertise ECH config")
+
+// echHTTPSName returns the name of HTTPS records of the DoH endpoint u, following RFC 9460 section 9.1.
+func echHTTPSName(u *url.URL) string {
+	name := dns.Fqdn(u.Hostname())
+	if port := u.Port(); port != "" && port != "443" {
+		return "_" + port + "._https." + name
+	}
+	return name
+}
+
+// echConfigListFromAnswer returns the ECHConfigList of the most preferred HTTPS record in answer.
+// It returns nil if there is no HTTPS record advertising ECH.
+func echConfigListFromAnswer(answer []dns.RR) []byte {
+	var (
+		list     []byte
+		priority uint16
+	)
+	for _, rr := range answer {
+		https, ok := rr.(*dns.HTTPS)
+		// Priority 0 is the AliasMode, which does not carry service parameters.
+		if !ok || https.Priority == 0 {
+			continue
+		}
+		if list != nil && https.Priority >= priority {
+			continue
+		}
+		for _, kv := range https.Value {
+			if ech, ok := kv.(*dns.SVCBECHConfig); ok && len(ech.ECH) > 0 {
+				list, priority = ech.ECH, https.Priority
+				break
+			}
+		}
+	}
+	return list
+}
+
+// lookupECHConfigList looks up the ECHConfigList advertised by HTTPS records of name, through the upstream itself
+// using transport, so the lookup is encrypted, and the records could not be stripped, or tampered with, on path.
+func (uc *UpstreamConfig) lookupECHConfigList(name string, transport *http.Transport) ([]byte, error) {
+	timeoutMs := 2000
+	if uc.Timeout > 0 && uc.Timeout < timeoutMs {
+		timeoutMs = uc.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeHTTPS)
+	m.RecursionDesired = true
+	r, err := newDohResolver(uc).exchange(ctx, &http.Client{Transport: transport}, m)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("could not resolve %q, return code: %s", name, dns.RcodeToString[r.Rcode])
+	}
+	return echConfigListFromAnswer(r.Answer), nil
+}
+
+const (
+	// echLookupMinBackoff is the delay before retrying the first failed ECH config lookup.
+	echLookupMinBackoff = 5 * time.Second
+	// echLookupMaxBackoff caps the delay between failed ECH config lookups.
+	echLookupMaxBackoff = 10 * time.Minute
+)
+
+// setupECH sets up the ECHConfigList of the upstream, if it was not set up yet. It reports whether the
+// ECHConfigList was set, so transports must be set up again to use it.
+//
+// The ECHConfigList from config is used if present. Otherwise, in auto mode, it is looked up using transport.
+// In required mode, it is never looked up, because the lookup is done over plain TLS, exposing the server name
+// that required mode promises to hide.
+func (uc *UpstreamConfig) setupECH(transport *http.Transport) bool {
+	if uc.ECH == "" || uc.u == nil || uc.echConfigList.Load() != nil || transport == nil {
+		return false
+	}
+	if uc.ECHConfig != "" {
+		list, err := base64.StdEncoding.DecodeString(uc.ECHConfig)
+		if err != nil {
+			ProxyLogger.Load().Warn().Err(err).Msgf("invalid ECH config of upstream: %s", uc.Endpoint)
+			list = []byte{}
+		}
+		uc.echConfigList.Store(&list)
+		return true
+	}
+	if uc.ECH == ECHModeRequired {
+		return false
+	}
+	now := time.Now()
+	if at := uc.echLookupRetryAt.Load(); at != 0 && now.UnixNano() < at {
+		return false
+	}
+	name := echHTTPSName(uc.u)
+	list, err := uc.lookupECHConfigList(name, transport)
+	if err != nil {
+		// Plain TLS is used until the lookup succeeds, retrying with backoff.
+		backoff := echLookupBackoff(int(uc.echLookupFailures.Add(1)))
+		uc.echLookupRetryAt.Store(now.Add(backoff).UnixNano())
+		ProxyLogger.Load().Warn().Err(err).Msgf("could not lookup ECH config of %q, retrying in %s", name, backoff)
+		return false
+	}
+	uc.echLookupFailures.Store(0)
+	uc.echLookupRetryAt.Store(0)
+	if len(list) > 0 {
+		ProxyLogger.Load().Debug().Msgf("using ECH for upstream: %s", uc.Endpoint)
+	} else {
+		ProxyLogger.Load().Debug().Msgf("upstream does not advertise ECH config: %s", uc.Endpoint)
+	}
+	// Store a non-nil list even if ECH is not advertised, so the lookup is not repeated until re-bootstrapping.
+	if list == nil {
+		list = []byte{}
+	}
+	uc.echConfigList.Store(&list)
+	return true
+}
+
+// echLookupBackoff returns the delay before retrying the ECH config lookup after the given number of failures.
+func echLookupBackoff(failures int) time.Duration {
+	if failures > 10 {
+		return echLookupMaxBackoff
+	}
+	return min(echLookupMinBackoff<<(failures-1), echLookupMaxBackoff)
+}
+
+// echLookupRetryDue reports whether a failed ECH config lookup should be retried at the given time.
+// Only one caller gets true for each retry.
+func (uc *UpstreamConfig) echLookupRetryDue(now time.Time) bool {
+	at := uc.echLookupRetryAt.Load()
+	if at == 0 || now.UnixNano() < at || uc.echConfigList.Load() != nil {
+		return false
+	}
+	return uc.echLookupRetryAt.CompareAndSwap(at, 0)
+}
+
+// echConfig returns the ECHConfigList used for connecting to the upstream, if any.
+func (uc *UpstreamConfig) echConfig() []byte {
+	if list := uc.echConfigList.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// echError returns errECHNotAdvertised if the upstream requires ECH, but it could not be used.
+func (uc *UpstreamConfig) echError() error {
+	if uc.ECH == ECHModeRequired && len(uc.echConfig()) == 0 {
+		return errECHNotAdvertised
+	}
+	return nil
+}
+
+// echRejected updates the ECHConfigList of the upstream with retry configs sent by the server
+// after rejecting ECH, so the next connections use them. Empty retry configs mean the server
+// does not support ECH anymore.
+func (uc *UpstreamConfig) echRejected(retryConfigList []byte) {
+	ProxyLogger.Load().Debug().Msgf("ECH was rejected by upstream: %s, retry configs: %d bytes", uc.Endpoint, len(retryConfigList))
+	if retryConfigList == nil {
+		retryConfigList = []byte{}
+	}
+	uc.echConfigList.Store(&retryConfigList)
+	uc.rebootstrap.Store(true)
+}
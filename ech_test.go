@@ -0,0 +1,244 @@
+package ctrld
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_echHTTPSName(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"https://dns.example.com/dns-query", "dns.example.com."},
+		{"https://dns.example.com:443/dns-query", "dns.example.com."},
+		{"https://dns.example.com:8443/dns-query", "_8443._https.dns.example.com."},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.endpoint)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, echHTTPSName(u))
+	}
+}
+
+func Test_echConfigListFromAnswer(t *testing.T) {
+	https := func(priority uint16, ech []byte) dns.RR {
+		rr := &dns.HTTPS{SVCB: dns.SVCB{
+			Hdr:      dns.RR_Header{Name: "dns.example.com.", Rrtype: dns.TypeHTTPS, Class: dns.ClassINET},
+			Priority: priority,
+			Target:   ".",
+		}}
+		rr.Value = append(rr.Value, &dns.SVCBAlpn{Alpn: []string{"h2"}})
+		if ech != nil {
+			rr.Value = append(rr.Value, &dns.SVCBECHConfig{ECH: ech})
+		}
+		return rr
+	}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "dns.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}}
+
+	assert.Nil(t, echConfigListFromAnswer(nil))
+	assert.Nil(t, echConfigListFromAnswer([]dns.RR{a, https(1, nil)}))
+	// AliasMode records are ignored.
+	assert.Nil(t, echConfigListFromAnswer([]dns.RR{https(0, []byte("alias"))}))
+	assert.Equal(t, []byte("first"), echConfigListFromAnswer([]dns.RR{a, https(1, []byte("first"))}))
+	// The most preferred record wins, regardless of its position.
+	assert.Equal(t, []byte("preferred"), echConfigListFromAnswer([]dns.RR{https(2, []byte("other")), https(1, []byte("preferred"))}))
+}
+
+func TestUpstreamConfig_ECHTransport(t *testing.T) {
+	uc := &UpstreamConfig{
+		Name:        "doh",
+		Type:        ResolverTypeDOH,
+		Endpoint:    "https://dns.example.com/dns-query",
+		BootstrapIP: "127.0.0.1",
+		ECH:         ECHModeAuto,
+	}
+	uc.Init()
+	list := []byte("echconfig")
+	uc.echConfigList.Store(&list)
+	uc.SetupTransport()
+	tlsCfg := uc.transport.TLSClientConfig
+	assert.Equal(t, list, tlsCfg.EncryptedClientHelloConfigList)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsCfg.MinVersion)
+	assert.NoError(t, uc.echError())
+
+	// Rejected without retry configs, ECH is not used anymore.
+	uc.echRejected(nil)
+	assert.True(t, uc.rebootstrap.Load())
+	assert.Empty(t, uc.echConfig())
+	uc.SetupTransport()
+	assert.Nil(t, uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+	assert.NoError(t, uc.echError())
+
+	// Retry configs are used for next connections.
+	uc.echRejected([]byte("retry"))
+	uc.SetupTransport()
+	assert.Equal(t, []byte("retry"), uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+
+	// Re-bootstrapping looks up the ECH config again.
+	uc.rebootstrap.Store(false)
+	uc.ReBootstrap()
+	assert.Nil(t, uc.echConfigList.Load())
+}
+
+func TestUpstreamConfig_ECHRequired(t *testing.T) {
+	uc := &UpstreamConfig{
+		Name:        "doh",
+		Type:        ResolverTypeDOH,
+		Endpoint:    "https://dns.example.com/dns-query",
+		BootstrapIP: "127.0.0.1",
+		ECH:         ECHModeRequired,
+	}
+	uc.Init()
+	uc.echConfigList.Store(&[]byte{})
+	r, err := NewResolver(uc)
+	require.NoError(t, err)
+	_, err = r.Resolve(context.Background(), new(dns.Msg).SetQuestion("example.com.", dns.TypeA))
+	assert.ErrorIs(t, err, errECHNotAdvertised)
+}
+
+func TestUpstreamConfig_ECHLookupThroughUpstream(t *testing.T) {
+	queries := make(chan dns.Question, 1)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		packed, _ := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packed); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		queries <- msg.Question[0]
+		answer := new(dns.Msg)
+		answer.SetReply(msg)
+		rr := &dns.HTTPS{SVCB: dns.SVCB{
+			Hdr:      dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET},
+			Priority: 1,
+			Target:   ".",
+			Value:    []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte("echconfig")}},
+		}}
+		answer.Answer = append(answer.Answer, rr)
+		buf, _ := answer.Pack()
+		w.Header().Set("Content-Type", headerApplicationDNS)
+		_, _ = w.Write(buf)
+	}))
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	uc := &UpstreamConfig{
+		Name:        "doh",
+		Type:        ResolverTypeDOH,
+		Endpoint:    ts.URL + "/dns-query",
+		BootstrapIP: "127.0.0.1",
+		ECH:         ECHModeAuto,
+	}
+	uc.Init()
+	uc.SetCertPool(pool)
+	uc.SetupTransport()
+	// The HTTPS records are looked up through the upstream, not plain DNS.
+	q := <-queries
+	assert.Equal(t, dns.TypeHTTPS, q.Qtype)
+	assert.Equal(t, echHTTPSName(uc.u), q.Name)
+	assert.Equal(t, []byte("echconfig"), uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+}
+
+func TestUpstreamConfig_ECHRequiredNoLookup(t *testing.T) {
+	var queries atomic.Int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	uc := &UpstreamConfig{
+		Name:        "doh",
+		Type:        ResolverTypeDOH,
+		Endpoint:    ts.URL + "/dns-query",
+		BootstrapIP: "127.0.0.1",
+		ECH:         ECHModeRequired,
+	}
+	uc.Init()
+	uc.SetupTransport()
+	// Without ECH config in config, queries fail closed.
+	assert.Nil(t, uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+	assert.ErrorIs(t, uc.echError(), errECHNotAdvertised)
+
+	uc.ECHConfig = base64.StdEncoding.EncodeToString([]byte("echconfig"))
+	uc.SetupTransport()
+	assert.Equal(t, []byte("echconfig"), uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+	assert.NoError(t, uc.echError())
+	// The server name is never exposed by looking up HTTPS records over plain TLS.
+	assert.Zero(t, queries.Load())
+}
+
+func TestUpstreamConfig_ECHLookupRetry(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		packed, _ := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packed); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		answer := new(dns.Msg)
+		answer.SetReply(msg)
+		answer.Answer = append(answer.Answer, &dns.HTTPS{SVCB: dns.SVCB{
+			Hdr:      dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET},
+			Priority: 1,
+			Target:   ".",
+			Value:    []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte("echconfig")}},
+		}})
+		buf, _ := answer.Pack()
+		w.Header().Set("Content-Type", headerApplicationDNS)
+		_, _ = w.Write(buf)
+	}))
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	uc := &UpstreamConfig{
+		Name:        "doh",
+		Type:        ResolverTypeDOH,
+		Endpoint:    ts.URL + "/dns-query",
+		BootstrapIP: "127.0.0.1",
+		ECH:         ECHModeAuto,
+	}
+	uc.Init()
+	uc.SetCertPool(pool)
+	uc.SetupTransport()
+	// Failed lookups are not cached, plain TLS is used until the retry.
+	assert.Nil(t, uc.echConfigList.Load())
+	assert.Nil(t, uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+	assert.False(t, uc.echLookupRetryDue(time.Now()))
+
+	fail.Store(false)
+	retryAt := time.Now().Add(echLookupMinBackoff)
+	require.True(t, uc.echLookupRetryDue(retryAt))
+	// Only one caller retries.
+	assert.False(t, uc.echLookupRetryDue(retryAt))
+	uc.SetupTransport()
+	assert.Equal(t, []byte("echconfig"), uc.transport.TLSClientConfig.EncryptedClientHelloConfigList)
+	assert.Zero(t, uc.echLookupFailures.Load())
+}
+
+func Test_echLookupBackoff(t *testing.T) {
+	assert.Equal(t, echLookupMinBackoff, echLookupBackoff(1))
+	assert.Equal(t, 2*echLookupMinBackoff, echLookupBackoff(2))
+	assert.Equal(t, echLookupMaxBackoff, echLookupBackoff(100))
+}
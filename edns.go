@@ -0,0 +1,56 @@
+package ctrld
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultEDNSBufferSize is the default EDNS0 UDP buffer size, which avoids IP fragmentation
+	// on most networks, see: https://www.dnsflagday.net/2020/
+	DefaultEDNSBufferSize = 1232
+	// MinEDNSBufferSize is the smallest UDP message size which all DNS implementations must support.
+	MinEDNSBufferSize = dns.MinMsgSize
+	// ednsDowngradeDuration is how long the downgraded buffer size is used after an UDP timeout.
+	ednsDowngradeDuration = 5 * time.Minute
+)
+
+// EDNSBufferSizeOrDefault returns the EDNS0 UDP buffer size advertised to clients.
+func (lc *ListenerConfig) EDNSBufferSizeOrDefault() uint16 {
+	if lc == nil || lc.EDNSBufferSize == nil {
+		return DefaultEDNSBufferSize
+	}
+	return uint16(*lc.EDNSBufferSize)
+}
+
+// ednsBufferSize returns the EDNS0 UDP buffer size advertised to upstream. If the upstream
+// was downgraded recently, the minimum size is used, so large answers are truncated and
+// retried over TCP, instead of being lost as fragments.
+func (uc *UpstreamConfig) ednsBufferSize() uint16 {
+	if time.Now().UnixNano() < uc.ednsDowngradeUntil.Load() {
+		return MinEDNSBufferSize
+	}
+	if uc.EDNSBufferSize == nil {
+		return DefaultEDNSBufferSize
+	}
+	return uint16(*uc.EDNSBufferSize)
+}
+
+// downgradeEDNS makes the upstream advertise the minimum EDNS0 UDP buffer size for ednsDowngradeDuration.
+func (uc *UpstreamConfig) downgradeEDNS() {
+	uc.ednsDowngradeUntil.Store(time.Now().Add(ednsDowngradeDuration).UnixNano())
+}
+
+// ednsRequest returns msg with EDNS0 UDP buffer size set to the upstream buffer size.
+// The msg is copied if it needs to be changed, since it may be shared with other upstreams.
+func (uc *UpstreamConfig) ednsRequest(msg *dns.Msg) *dns.Msg {
+	opt := msg.IsEdns0()
+	size := uc.ednsBufferSize()
+	if opt == nil || opt.UDPSize() == size {
+		return msg
+	}
+	msg = msg.Copy()
+	msg.IsEdns0().SetUDPSize(size)
+	return msg
+}
@@ -0,0 +1,95 @@
+package clientinfo
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// activityIdleTimeout is how long a client could be idle before its activity is forgotten.
+	activityIdleTimeout = 24 * time.Hour
+	// activityPruneInterval is how often activity of idle clients is pruned.
+	activityPruneInterval = time.Hour
+)
+
+// activity represents queries activity of a client.
+type activity struct {
+	lastSeen time.Time
+	day      time.Time // start of the day, in local time, that queries are counted for.
+	queries  int64
+}
+
+// clientActivity records queries activity of clients, keyed by IP address.
+type clientActivity struct {
+	mu        sync.Mutex
+	ips       map[string]*activity
+	now       func() time.Time
+	lastPrune time.Time
+}
+
+func newClientActivity() *clientActivity {
+	return &clientActivity{ips: make(map[string]*activity), now: time.Now}
+}
+
+// record records a query made by the client with given ip, reporting whether it's the first query of the client.
+// A client which was idle longer than activityIdleTimeout is reported as a new one.
+func (ca *clientActivity) record(ip string) bool {
+	if ca == nil {
+		return false
+	}
+	now := ca.now()
+	day := startOfDay(now)
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if now.Sub(ca.lastPrune) >= activityPruneInterval {
+		ca.prune(now)
+		ca.lastPrune = now
+	}
+	a := ca.ips[ip]
+	first := a == nil
+	if first {
+		a = &activity{}
+		ca.ips[ip] = a
+	}
+	if !a.day.Equal(day) {
+		a.day = day
+		a.queries = 0
+	}
+	a.lastSeen = now
+	a.queries++
+	return first
+}
+
+// lookup returns the last time the client with given ip made a query,
+// and the number of queries it made today.
+func (ca *clientActivity) lookup(ip string) (time.Time, int64) {
+	if ca == nil {
+		return time.Time{}, 0
+	}
+	day := startOfDay(ca.now())
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	a := ca.ips[ip]
+	if a == nil {
+		return time.Time{}, 0
+	}
+	if !a.day.Equal(day) {
+		return a.lastSeen, 0
+	}
+	return a.lastSeen, a.queries
+}
+
+// prune removes activity of clients which were idle longer than activityIdleTimeout.
+// The caller must hold ca.mu.
+func (ca *clientActivity) prune(now time.Time) {
+	for ip, a := range ca.ips {
+		if now.Sub(a.lastSeen) > activityIdleTimeout {
+			delete(ca.ips, ip)
+		}
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,64 @@
+package clientinfo
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_clientActivity(t *testing.T) {
+	now := time.Date(2024, 1, 1, 23, 59, 0, 0, time.Local)
+	ca := newClientActivity()
+	ca.now = func() time.Time { return now }
+
+	ip := "192.168.1.10"
+	if !ca.record(ip) {
+		t.Fatal("first query of client is not reported")
+	}
+	if ca.record(ip) {
+		t.Fatal("second query of client is reported as first one")
+	}
+	if lastSeen, queries := ca.lookup(ip); !lastSeen.Equal(now) || queries != 2 {
+		t.Fatalf("unexpected activity, last seen: %v, queries: %d", lastSeen, queries)
+	}
+
+	// Queries counter must be reset on the next day.
+	seen := now
+	now = now.Add(2 * time.Minute)
+	if lastSeen, queries := ca.lookup(ip); !lastSeen.Equal(seen) || queries != 0 {
+		t.Fatalf("unexpected activity, last seen: %v, queries: %d", lastSeen, queries)
+	}
+	ca.record(ip)
+	if lastSeen, queries := ca.lookup(ip); !lastSeen.Equal(now) || queries != 1 {
+		t.Fatalf("unexpected activity, last seen: %v, queries: %d", lastSeen, queries)
+	}
+
+	if lastSeen, queries := ca.lookup("192.168.1.11"); !lastSeen.IsZero() || queries != 0 {
+		t.Fatalf("unexpected activity for unknown client, last seen: %v, queries: %d", lastSeen, queries)
+	}
+}
+
+func Test_clientActivity_prune(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	ca := newClientActivity()
+	ca.now = func() time.Time { return now }
+
+	ca.record("192.168.1.10")
+	ca.record("192.168.1.11")
+	now = now.Add(activityIdleTimeout / 2)
+	ca.record("192.168.1.11")
+
+	// Client idle longer than the timeout is forgotten, the active one is kept.
+	now = now.Add(activityIdleTimeout/2 + time.Minute)
+	if !ca.record("192.168.1.12") {
+		t.Fatal("first query of client is not reported")
+	}
+	if len(ca.ips) != 2 {
+		t.Fatalf("unexpected number of clients: %d", len(ca.ips))
+	}
+	if lastSeen, _ := ca.lookup("192.168.1.10"); !lastSeen.IsZero() {
+		t.Fatalf("idle client is not pruned, last seen: %v", lastSeen)
+	}
+	if !ca.record("192.168.1.10") {
+		t.Fatal("query of pruned client is not reported as first one")
+	}
+}
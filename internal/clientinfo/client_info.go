@@ -69,6 +69,9 @@ type Client struct {
 	Source            map[string]struct{}
 	QueryCount        int64
 	IncludeQueryCount bool
+	LastSeen          time.Time
+	QueriesToday      int64
+	DeviceType        string
 }
 
 type Table struct {
@@ -86,8 +89,12 @@ type Table struct {
 	ndp            *ndpDiscover
 	ptr            *ptrDiscover
 	mdns           *mdns
+	ssdp           *ssdp
+	netbios        *netbiosDiscover
 	hf             *hostsFile
 	vni            *virtualNetworkIface
+	haPeer         *haPeerClients
+	activity       *clientActivity
 	svcCfg         ctrld.ServiceConfig
 	quitCh         chan struct{}
 	selfIP         string
@@ -107,6 +114,8 @@ func NewTable(cfg *ctrld.Config, selfIP, cdUID string, ns []string) *Table {
 		cdUID:           cdUID,
 		ptrNameservers:  ns,
 		refreshInterval: refreshInterval,
+		activity:        newClientActivity(),
+		haPeer:          &haPeerClients{},
 	}
 }
 
@@ -272,11 +281,32 @@ func (t *Table) init() {
 			t.hostnameResolvers = append(t.hostnameResolvers, t.mdns)
 		}
 	}
+	// ssdp.
+	if t.discoverSSDP() {
+		t.ssdp = &ssdp{}
+		ctrld.ProxyLogger.Load().Debug().Msg("start ssdp discovery")
+		if err := t.ssdp.init(t.quitCh); err != nil {
+			ctrld.ProxyLogger.Load().Error().Err(err).Msg("could not init SSDP discover")
+		} else {
+			t.hostnameResolvers = append(t.hostnameResolvers, t.ssdp)
+			t.refreshers = append(t.refreshers, t.ssdp)
+		}
+	}
 	// VPN clients.
 	if t.discoverDHCP() || t.discoverARP() {
 		t.vni = &virtualNetworkIface{}
 		t.hostnameResolvers = append(t.hostnameResolvers, t.vni)
 	}
+	// NetBIOS, used as the last resort for clients which expose no other names.
+	if t.discoverNetBIOS() {
+		t.netbios = newNetbiosDiscover()
+		ctrld.ProxyLogger.Load().Debug().Msg("start netbios discovery")
+		t.hostnameResolvers = append(t.hostnameResolvers, t.netbios)
+	}
+	// Clients synced from high-availability peer, used as the last resort.
+	t.ipResolvers = append(t.ipResolvers, t.haPeer)
+	t.macResolvers = append(t.macResolvers, t.haPeer)
+	t.hostnameResolvers = append(t.hostnameResolvers, t.haPeer)
 }
 
 func (t *Table) LookupIP(mac string) string {
@@ -367,6 +397,14 @@ func (t *Table) lookupHostnameAll(ip, mac string) []*hostnameEntry {
 			}
 			continue
 		}
+		// For netbiosDiscover, only lookup from cache, so clients having names
+		// from other sources won't be probed.
+		if netbiosResolver, ok := r.(*netbiosDiscover); ok {
+			if name := netbiosResolver.lookupHostnameFromCache(ip); name != "" {
+				res = append(res, &hostnameEntry{name: name, src: src})
+			}
+			continue
+		}
 		if name := r.LookupHostnameByIP(ip); name != "" {
 			res = append(res, &hostnameEntry{name: name, src: src})
 			continue
@@ -385,7 +423,7 @@ func (t *Table) ListClients() []*Client {
 		_ = r.refresh()
 	}
 	ipMap := make(map[string]*Client)
-	il := []ipLister{t.dhcp, t.arp, t.ndp, t.ptr, t.mdns, t.vni}
+	il := []ipLister{t.dhcp, t.arp, t.ndp, t.ptr, t.mdns, t.ssdp, t.vni, t.netbios, t.haPeer}
 	for _, ir := range il {
 		for _, ip := range ir.List() {
 			c, ok := ipMap[ip]
@@ -428,6 +466,8 @@ func (t *Table) ListClients() []*Client {
 		if cFromMac := clientsByMAC[c.Mac]; cFromMac != nil && c.Hostname == "" {
 			c.Hostname = cFromMac.Hostname
 		}
+		c.LastSeen, c.QueriesToday = t.activity.lookup(c.IP.String())
+		c.DeviceType = t.ssdp.lookupDeviceType(c.IP.String())
 		clients = append(clients, c)
 	}
 	return clients
@@ -442,6 +482,22 @@ func (t *Table) StoreVPNClient(ci *ctrld.ClientInfo) {
 	t.vni.ip2name.Store(ci.IP, ci.Hostname)
 }
 
+// StorePeerClient stores client info synced from high-availability peer.
+func (t *Table) StorePeerClient(ip, mac, hostname string) {
+	if ip == "" || t.haPeer == nil {
+		return
+	}
+	t.haPeer.store(normalizeIP(ip), mac, hostname)
+}
+
+// RecordQuery records a query made by the client with given ip, reporting whether it's the first query of the client.
+func (t *Table) RecordQuery(ip string) bool {
+	if ip == "" {
+		return false
+	}
+	return t.activity.record(ip)
+}
+
 // ipFinder is the interface for retrieving IP address from hostname.
 type ipFinder interface {
 	lookupIPByHostname(name string, v6 bool) string
@@ -453,7 +509,7 @@ func (t *Table) LookupIPByHostname(hostname string, v6 bool) *netip.Addr {
 	if t == nil {
 		return nil
 	}
-	for _, finder := range []ipFinder{t.hf, t.ptr, t.mdns, t.dhcp} {
+	for _, finder := range []ipFinder{t.hf, t.ptr, t.mdns, t.dhcp, t.netbios} {
 		if addr := finder.lookupIPByHostname(hostname, v6); addr != "" {
 			if ip, err := netip.ParseAddr(addr); err == nil {
 				return &ip
@@ -491,6 +547,20 @@ func (t *Table) discoverPTR() bool {
 	return *t.svcCfg.DiscoverPtr
 }
 
+func (t *Table) discoverSSDP() bool {
+	if t.svcCfg.DiscoverSSDP == nil {
+		return false
+	}
+	return *t.svcCfg.DiscoverSSDP
+}
+
+func (t *Table) discoverNetBIOS() bool {
+	if t.svcCfg.DiscoverNetBIOS == nil {
+		return false
+	}
+	return *t.svcCfg.DiscoverNetBIOS
+}
+
 func (t *Table) discoverHosts() bool {
 	if t.svcCfg.DiscoverHosts == nil {
 		return true
@@ -72,3 +72,24 @@ func TestTable_ListClients(t *testing.T) {
 		}
 	}
 }
+
+func TestTable_StorePeerClient(t *testing.T) {
+	table := &Table{haPeer: &haPeerClients{}}
+	table.ipResolvers = append(table.ipResolvers, table.haPeer)
+	table.macResolvers = append(table.macResolvers, table.haPeer)
+	table.hostnameResolvers = append(table.hostnameResolvers, table.haPeer)
+
+	table.StorePeerClient("192.168.1.10%eth0", "aa:bb:cc:dd:ee:ff", "laptop")
+	if got := table.LookupMac("192.168.1.10"); got != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("LookupMac() = %v, want aa:bb:cc:dd:ee:ff", got)
+	}
+	if got := table.LookupIP("aa:bb:cc:dd:ee:ff"); got != "192.168.1.10" {
+		t.Errorf("LookupIP() = %v, want 192.168.1.10", got)
+	}
+	if got := table.LookupHostname("192.168.1.10", ""); got != "laptop" {
+		t.Errorf("LookupHostname() = %v, want laptop", got)
+	}
+
+	var noPeer Table
+	noPeer.StorePeerClient("192.168.1.10", "aa:bb:cc:dd:ee:ff", "laptop")
+}
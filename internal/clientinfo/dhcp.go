@@ -191,6 +191,12 @@ func (d *dhcp) readLeaseFile(name string, format ctrld.LeaseFileFormat) error {
 		return d.iscDHCPReadClientInfoFile(name)
 	case ctrld.KeaDHCP4:
 		return d.keaDhcp4ReadClientInfoFile(name)
+	case ctrld.DnsmasqConf:
+		return d.dnsmasqConfReadClientInfoFile(name)
+	case ctrld.KeaConf:
+		return d.keaConfReadClientInfoFile(name)
+	case ctrld.PfsenseXML:
+		return d.pfsenseXMLReadClientInfoFile(name)
 	}
 	return fmt.Errorf("unsupported format: %s, file: %s", format, name)
 }
@@ -16,4 +16,11 @@ var clientInfoFiles = map[string]ctrld.LeaseFileFormat{
 	"/var/dhcpd/var/db/dhcpd.leases":           ctrld.IscDhcpd, // Pfsense
 	"/home/pi/.router/run/dhcp/dnsmasq.leases": ctrld.Dnsmasq,  // Firewalla
 	"/var/lib/kea/dhcp4.leases":                ctrld.KeaDHCP4, // Pfsense
+
+	// Static leases/reservations.
+	"/etc/dnsmasq.conf":                 ctrld.DnsmasqConf, // dnsmasq
+	"/tmp/dnsmasq.conf":                 ctrld.DnsmasqConf, // ddwrt
+	"/etc/kea/kea-dhcp4.conf":           ctrld.KeaConf,     // Kea
+	"/usr/local/etc/kea/kea-dhcp4.conf": ctrld.KeaConf,     // Pfsense, OPNsense
+	"/conf/config.xml":                  ctrld.PfsenseXML,  // Pfsense, OPNsense
 }
@@ -0,0 +1,79 @@
+package clientinfo
+
+import (
+	"sync"
+)
+
+// haPeerClients is the manager for clients synced from the high-availability peer, so the standby
+// instance knows the clients discovered by the active one, and does not start with an empty table.
+type haPeerClients struct {
+	ip2name sync.Map // ip  => name
+	mac     sync.Map // ip  => mac
+}
+
+// LookupIP returns ip of the given mac.
+func (h *haPeerClients) LookupIP(mac string) string {
+	ip := ""
+	h.mac.Range(func(key, value any) bool {
+		if value.(string) == mac {
+			ip = key.(string)
+			return false
+		}
+		return true
+	})
+	return ip
+}
+
+// LookupMac returns mac of the given ip.
+func (h *haPeerClients) LookupMac(ip string) string {
+	val, ok := h.mac.Load(ip)
+	if !ok {
+		return ""
+	}
+	return val.(string)
+}
+
+// LookupHostnameByIP returns hostname of the given ip.
+func (h *haPeerClients) LookupHostnameByIP(ip string) string {
+	val, ok := h.ip2name.Load(ip)
+	if !ok {
+		return ""
+	}
+	return val.(string)
+}
+
+// LookupHostnameByMac returns hostname of the given mac.
+func (h *haPeerClients) LookupHostnameByMac(mac string) string {
+	if ip := h.LookupIP(mac); ip != "" {
+		return h.LookupHostnameByIP(ip)
+	}
+	return ""
+}
+
+// String returns the string representation of haPeerClients struct.
+func (h *haPeerClients) String() string {
+	return "ha_peer"
+}
+
+// List lists all known clients IP.
+func (h *haPeerClients) List() []string {
+	if h == nil {
+		return nil
+	}
+	var ips []string
+	h.mac.Range(func(key, value any) bool {
+		ips = append(ips, key.(string))
+		return true
+	})
+	return ips
+}
+
+// store stores client info synced from peer.
+func (h *haPeerClients) store(ip, mac, hostname string) {
+	if mac != "" {
+		h.mac.Store(ip, mac)
+	}
+	if hostname != "" {
+		h.ip2name.Store(ip, hostname)
+	}
+}
@@ -0,0 +1,231 @@
+package clientinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// netbiosPort is the NetBIOS name service port.
+	netbiosPort = "137"
+	// netbiosTimeout is the timeout for NetBIOS node status request.
+	netbiosTimeout = time.Second
+	// netbiosRetryInterval is the duration before failed lookup of an ip is retried.
+	netbiosRetryInterval = 10 * time.Minute
+	// netbiosTypeNBSTAT is the NetBIOS node status record type.
+	netbiosTypeNBSTAT = 0x21
+)
+
+// netbiosWildcardName is the encoded name "*", which every node answers to.
+var netbiosWildcardName = encodeNetbiosName("*")
+
+type netbiosDiscover struct {
+	hostname sync.Map // ip => hostname
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	failed   map[string]time.Time
+}
+
+func newNetbiosDiscover() *netbiosDiscover {
+	return &netbiosDiscover{
+		inflight: make(map[string]bool),
+		failed:   make(map[string]time.Time),
+	}
+}
+
+// LookupHostnameByIP returns the NetBIOS name of given ip. If the name is not known yet, the node
+// status request is sent in background, so the name will be available for next lookups.
+func (n *netbiosDiscover) LookupHostnameByIP(ip string) string {
+	if name := n.lookupHostnameFromCache(ip); name != "" {
+		return name
+	}
+	if n.shouldLookup(ip) {
+		go n.lookupHostname(ip)
+	}
+	return ""
+}
+
+func (n *netbiosDiscover) LookupHostnameByMac(mac string) string {
+	return ""
+}
+
+func (n *netbiosDiscover) String() string {
+	return "netbios"
+}
+
+func (n *netbiosDiscover) List() []string {
+	if n == nil {
+		return nil
+	}
+	var ips []string
+	n.hostname.Range(func(key, value any) bool {
+		ips = append(ips, key.(string))
+		return true
+	})
+	return ips
+}
+
+func (n *netbiosDiscover) lookupHostnameFromCache(ip string) string {
+	if val, ok := n.hostname.Load(ip); ok {
+		return val.(string)
+	}
+	return ""
+}
+
+// shouldLookup reports whether node status request should be sent to ip.
+func (n *netbiosDiscover) shouldLookup(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	// NetBIOS name service is only available on IPv4 LAN.
+	if err != nil || !addr.Is4() || !(addr.IsPrivate() || addr.IsLinkLocalUnicast()) {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.inflight[ip] {
+		return false
+	}
+	if t, ok := n.failed[ip]; ok && time.Since(t) < netbiosRetryInterval {
+		return false
+	}
+	n.inflight[ip] = true
+	return true
+}
+
+func (n *netbiosDiscover) lookupHostname(ip string) {
+	name, err := netbiosNodeStatus(ip)
+	n.mu.Lock()
+	delete(n.inflight, ip)
+	if err != nil {
+		n.failed[ip] = time.Now()
+	} else {
+		delete(n.failed, ip)
+	}
+	n.mu.Unlock()
+	if err != nil {
+		ctrld.ProxyLogger.Load().Debug().Err(err).Msgf("could not lookup NetBIOS name of %s", ip)
+		return
+	}
+	ctrld.ProxyLogger.Load().Debug().Msgf("found hostname: %q, ip: %q via netbios", name, ip)
+	n.hostname.Store(ip, name)
+}
+
+func (n *netbiosDiscover) lookupIPByHostname(name string, v6 bool) string {
+	if n == nil || v6 {
+		return ""
+	}
+	var ip string
+	n.hostname.Range(func(key, value any) bool {
+		if value == name {
+			ip = key.(string)
+			return false
+		}
+		return true
+	})
+	return ip
+}
+
+// netbiosNodeStatus sends NetBIOS node status request to ip, returning its workstation name.
+func netbiosNodeStatus(ip string) (string, error) {
+	conn, err := net.DialTimeout("udp4", net.JoinHostPort(ip, netbiosPort), netbiosTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(netbiosTimeout))
+	id := uint16(rand.Intn(1 << 16))
+	if _, err := conn.Write(netbiosNodeStatusRequest(id)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		// Ignore response of other requests.
+		if n < 2 || binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return parseNetbiosNodeStatus(buf[:n])
+	}
+}
+
+// netbiosNodeStatusRequest returns the node status request packet (RFC 1002, section 4.2.17).
+func netbiosNodeStatusRequest(id uint16) []byte {
+	b := make([]byte, 12, 12+len(netbiosWildcardName)+4)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[4:], 1) // QDCOUNT
+	b = append(b, netbiosWildcardName...)
+	b = binary.BigEndian.AppendUint16(b, netbiosTypeNBSTAT)
+	b = binary.BigEndian.AppendUint16(b, 1) // Class IN.
+	return b
+}
+
+// encodeNetbiosName returns the first-level encoded name (RFC 1001, section 14.1) in label format.
+func encodeNetbiosName(name string) []byte {
+	var raw [16]byte
+	copy(raw[:], name)
+	b := make([]byte, 0, 34)
+	b = append(b, 32)
+	for _, c := range raw {
+		b = append(b, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	return append(b, 0)
+}
+
+var errInvalidNetbiosResponse = errors.New("invalid NetBIOS node status response")
+
+// parseNetbiosNodeStatus parses node status response (RFC 1002, section 4.2.18), returning
+// the unique workstation name of the node.
+func parseNetbiosNodeStatus(b []byte) (string, error) {
+	if len(b) < 12 || binary.BigEndian.Uint16(b[6:]) == 0 { // ANCOUNT
+		return "", errInvalidNetbiosResponse
+	}
+	off := 12
+	// Skip RR_NAME, which is either a pointer or a label sequence.
+	for off < len(b) {
+		l := int(b[off])
+		if l&0xc0 == 0xc0 {
+			off += 2
+			break
+		}
+		off++
+		if l == 0 {
+			break
+		}
+		off += l
+	}
+	// RR_TYPE, RR_CLASS, TTL, RDLENGTH and NUM_NAMES.
+	if off+11 > len(b) || binary.BigEndian.Uint16(b[off:]) != netbiosTypeNBSTAT {
+		return "", errInvalidNetbiosResponse
+	}
+	off += 10
+	numNames := int(b[off])
+	off++
+	for i := 0; i < numNames; i++ {
+		// Each entry is 15 bytes name, 1 byte suffix and 2 bytes flags.
+		if off+18 > len(b) {
+			break
+		}
+		entry := b[off : off+18]
+		off += 18
+		suffix, flags := entry[15], binary.BigEndian.Uint16(entry[16:])
+		// Workstation service, which is not a group name.
+		if suffix != 0x00 || flags&0x8000 != 0 {
+			continue
+		}
+		if name := strings.TrimRight(string(entry[:15]), " \x00"); name != "" {
+			return strings.ToLower(name), nil
+		}
+	}
+	return "", errInvalidNetbiosResponse
+}
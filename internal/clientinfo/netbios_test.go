@@ -0,0 +1,101 @@
+package clientinfo
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func Test_encodeNetbiosName(t *testing.T) {
+	b := encodeNetbiosName("*")
+	want := "CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	if len(b) != 34 || b[0] != 32 || b[33] != 0 {
+		t.Fatalf("invalid encoded name: %v", b)
+	}
+	if got := string(b[1:33]); got != want {
+		t.Errorf("unexpected encoded name, want: %s, got: %s", want, got)
+	}
+}
+
+// netbiosNodeStatusResponse returns a node status response, with given names and their suffix, flags.
+func netbiosNodeStatusResponse(id uint16, names []string, suffixes []byte, flags []uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], 0x8400)
+	binary.BigEndian.PutUint16(b[6:], 1) // ANCOUNT
+	b = append(b, netbiosWildcardName...)
+	b = binary.BigEndian.AppendUint16(b, netbiosTypeNBSTAT)
+	b = binary.BigEndian.AppendUint16(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint16(b, uint16(1+18*len(names)+46))
+	b = append(b, byte(len(names)))
+	for i, name := range names {
+		var entry [15]byte
+		for j := range entry {
+			entry[j] = ' '
+		}
+		copy(entry[:], name)
+		b = append(b, entry[:]...)
+		b = append(b, suffixes[i])
+		b = binary.BigEndian.AppendUint16(b, flags[i])
+	}
+	// Statistics.
+	return append(b, make([]byte, 46)...)
+}
+
+func Test_parseNetbiosNodeStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			"workstation name",
+			netbiosNodeStatusResponse(1, []string{"WORKGROUP", "DESKTOP-ABC", "DESKTOP-ABC"}, []byte{0x00, 0x20, 0x00}, []uint16{0x8400, 0x0400, 0x0400}),
+			"desktop-abc",
+			false,
+		},
+		{
+			"group name only",
+			netbiosNodeStatusResponse(1, []string{"WORKGROUP"}, []byte{0x00}, []uint16{0x8400}),
+			"",
+			true,
+		},
+		{
+			"truncated",
+			netbiosNodeStatusResponse(1, []string{"DESKTOP-ABC"}, []byte{0x00}, []uint16{0x0400})[:50],
+			"",
+			true,
+		},
+		{"empty", nil, "", true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseNetbiosNodeStatus(tc.resp)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected name, want: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_netbiosDiscover_shouldLookup(t *testing.T) {
+	n := newNetbiosDiscover()
+	if n.shouldLookup("8.8.8.8") {
+		t.Error("public ip must not be looked up")
+	}
+	if n.shouldLookup("fe80::1") {
+		t.Error("ipv6 must not be looked up")
+	}
+	if !n.shouldLookup("192.168.1.10") {
+		t.Fatal("private ip must be looked up")
+	}
+	if n.shouldLookup("192.168.1.10") {
+		t.Error("in flight lookup must not be duplicated")
+	}
+}
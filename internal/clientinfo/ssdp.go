@@ -0,0 +1,339 @@
+package clientinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	// ssdpDescriptionMaxSize is the maximum size of device description document.
+	ssdpDescriptionMaxSize = 64 << 10
+	// ssdpDescriptionTimeout is the timeout for fetching device description document.
+	ssdpDescriptionTimeout = 3 * time.Second
+	// ssdpDescriptionTTL is the duration before device description is fetched again.
+	ssdpDescriptionTTL = 30 * time.Minute
+)
+
+var ssdpAddr = &net.UDPAddr{
+	IP:   net.ParseIP("239.255.255.250"),
+	Port: 1900,
+}
+
+// ssdpSearchMsg is the M-SEARCH request, asking all devices to announce themselves.
+var ssdpSearchMsg = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: upnp:rootdevice\r\n\r\n")
+
+// ssdpDevice contains information of a device, parsed from its UPnP description.
+type ssdpDevice struct {
+	friendlyName string
+	deviceType   string
+	location     string
+	fetchedAt    time.Time
+}
+
+// ssdpDescription is the UPnP device description document.
+type ssdpDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+	} `xml:"device"`
+}
+
+type ssdp struct {
+	device     sync.Map // ip => *ssdpDevice
+	searchConn *net.UDPConn
+
+	mu       sync.Mutex
+	fetching map[string]bool
+	client   *http.Client
+}
+
+func (s *ssdp) LookupHostnameByIP(ip string) string {
+	if d := s.lookupDevice(ip); d != nil {
+		return d.friendlyName
+	}
+	return ""
+}
+
+func (s *ssdp) LookupHostnameByMac(mac string) string {
+	return ""
+}
+
+func (s *ssdp) String() string {
+	return "ssdp"
+}
+
+func (s *ssdp) List() []string {
+	if s == nil {
+		return nil
+	}
+	var ips []string
+	s.device.Range(func(key, value any) bool {
+		ips = append(ips, key.(string))
+		return true
+	})
+	return ips
+}
+
+// lookupDeviceType returns the device type of given ip, if any.
+func (s *ssdp) lookupDeviceType(ip string) string {
+	if d := s.lookupDevice(ip); d != nil {
+		return d.deviceType
+	}
+	return ""
+}
+
+func (s *ssdp) lookupDevice(ip string) *ssdpDevice {
+	if s == nil {
+		return nil
+	}
+	val, ok := s.device.Load(ip)
+	if !ok {
+		return nil
+	}
+	return val.(*ssdpDevice)
+}
+
+func (s *ssdp) init(quitCh chan struct{}) error {
+	ifaces, err := multicastInterfaces()
+	if err != nil {
+		return err
+	}
+	s.fetching = make(map[string]bool)
+	s.client = &http.Client{
+		Timeout: ssdpDescriptionTimeout,
+		// Device description must be served by the device itself.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	// Listening for NOTIFY messages, which devices send when joining the network.
+	conns := make([]*net.UDPConn, 0, len(ifaces)+1)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if conn, err := net.ListenMulticastUDP("udp4", &iface, ssdpAddr); err == nil {
+			conns = append(conns, conn)
+			go s.readLoop(conn)
+		}
+	}
+	// Responses to M-SEARCH requests are sent using unicast to the source port.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	s.searchConn = conn
+	conns = append(conns, conn)
+	go s.readLoop(conn)
+	go func() {
+		<-quitCh
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	return s.refresh()
+}
+
+// refresh sends M-SEARCH request, so devices re-announce themselves.
+func (s *ssdp) refresh() error {
+	_ = s.searchConn.SetWriteDeadline(time.Now().Add(time.Second * 30))
+	_, err := s.searchConn.WriteTo(ssdpSearchMsg, ssdpAddr)
+	return err
+}
+
+// readLoop reads from ssdp connection, fetching description of any devices found.
+func (s *ssdp) readLoop(conn *net.UDPConn) {
+	defer conn.Close()
+	buf := make([]byte, 4096)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 30))
+		n, raddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if err, ok := err.(*net.OpError); ok && (err.Timeout() || err.Temporary()) {
+				continue
+			}
+			// Do not complain about use of closed network connection.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ctrld.ProxyLogger.Load().Debug().Err(err).Msg("ssdp readLoop error")
+			return
+		}
+		location := ssdpLocation(buf[:n])
+		if location == "" {
+			continue
+		}
+		ip := raddr.IP.String()
+		if !s.shouldFetch(ip, location) {
+			continue
+		}
+		go s.fetchDescription(ip, location)
+	}
+}
+
+// shouldFetch reports whether device description of ip should be fetched from location.
+func (s *ssdp) shouldFetch(ip, location string) bool {
+	if !validSsdpLocation(ip, location) {
+		return false
+	}
+	if d := s.lookupDevice(ip); d != nil && d.location == location && time.Since(d.fetchedAt) < ssdpDescriptionTTL {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetching[ip] {
+		return false
+	}
+	s.fetching[ip] = true
+	return true
+}
+
+// fetchDescription fetches the device description, then updating the device of given ip.
+func (s *ssdp) fetchDescription(ip, location string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.fetching, ip)
+		s.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), ssdpDescriptionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		ctrld.ProxyLogger.Load().Debug().Err(err).Msgf("could not fetch ssdp description: %s", location)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	d, err := parseSsdpDescription(io.LimitReader(resp.Body, ssdpDescriptionMaxSize))
+	if err != nil {
+		ctrld.ProxyLogger.Load().Debug().Err(err).Msgf("could not parse ssdp description: %s", location)
+		return
+	}
+	d.location = location
+	d.fetchedAt = time.Now()
+	if old := s.lookupDevice(ip); old == nil || old.friendlyName != d.friendlyName || old.deviceType != d.deviceType {
+		ctrld.ProxyLogger.Load().Debug().Msgf("found device: %q, type: %q, ip: %q via ssdp", d.friendlyName, d.deviceType, ip)
+	}
+	s.device.Store(ip, d)
+}
+
+// ssdpLocation returns the LOCATION header of ssdp response or NOTIFY message.
+func ssdpLocation(msg []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(msg))
+	if !scanner.Scan() {
+		return ""
+	}
+	// Only "HTTP/1.1 200 OK" response and "NOTIFY * HTTP/1.1" message contain device location.
+	first := scanner.Text()
+	if !strings.HasPrefix(first, "HTTP/1.1 200") && !strings.HasPrefix(first, "NOTIFY ") {
+		return ""
+	}
+	location, byebye := "", false
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "LOCATION":
+			location = value
+		case "NTS":
+			byebye = value == "ssdp:byebye"
+		}
+	}
+	if byebye {
+		return ""
+	}
+	return location
+}
+
+// validSsdpLocation reports whether location is an http URL served by the device with given ip,
+// so ctrld won't be used for sending requests to arbitrary hosts.
+func validSsdpLocation(ip, location string) bool {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	host, err := netip.ParseAddr(u.Hostname())
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return host.Unmap() == addr.Unmap() && (addr.IsPrivate() || addr.IsLinkLocalUnicast())
+}
+
+// parseSsdpDescription parses UPnP description document from r.
+func parseSsdpDescription(r io.Reader) (*ssdpDevice, error) {
+	var desc ssdpDescription
+	if err := xml.NewDecoder(r).Decode(&desc); err != nil {
+		return nil, err
+	}
+	d := &ssdpDevice{
+		friendlyName: strings.TrimSpace(desc.Device.FriendlyName),
+		deviceType:   ssdpDeviceClass(desc.Device.DeviceType, desc.Device.Manufacturer+" "+desc.Device.ModelName),
+	}
+	if d.friendlyName == "" && d.deviceType == "" {
+		return nil, errors.New("missing device information")
+	}
+	return d, nil
+}
+
+// ssdpDeviceClass returns the human friendly class of device from its UPnP device type and model.
+func ssdpDeviceClass(deviceType, model string) string {
+	model = strings.ToLower(model)
+	for _, console := range []string{"playstation", "xbox", "nintendo"} {
+		if strings.Contains(model, console) {
+			return "console"
+		}
+	}
+	// Device type is formed "urn:<domain>:device:<type>:<version>".
+	parts := strings.Split(deviceType, ":")
+	if len(parts) < 4 {
+		return ""
+	}
+	typ := parts[3]
+	switch strings.ToLower(typ) {
+	case "mediarenderer", "dial", "tvdevice":
+		return "tv"
+	case "mediaserver":
+		return "media server"
+	case "internetgatewaydevice", "wfadevice":
+		return "router"
+	case "printer":
+		return "printer"
+	case "digitalsecuritycamera":
+		return "camera"
+	case "zoneplayer":
+		return "speaker"
+	}
+	return typ
+}
@@ -0,0 +1,116 @@
+package clientinfo
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ssdpLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{
+			"search response",
+			"HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLocation: http://192.168.1.10:8008/ssdp/device-desc.xml\r\nST: upnp:rootdevice\r\n\r\n",
+			"http://192.168.1.10:8008/ssdp/device-desc.xml",
+		},
+		{
+			"notify alive",
+			"NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nLOCATION: http://192.168.1.20:49152/desc.xml\r\nNTS: ssdp:alive\r\n\r\n",
+			"http://192.168.1.20:49152/desc.xml",
+		},
+		{
+			"notify byebye",
+			"NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nLOCATION: http://192.168.1.20:49152/desc.xml\r\nNTS: ssdp:byebye\r\n\r\n",
+			"",
+		},
+		{
+			"search request",
+			"M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\n\r\n",
+			"",
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := ssdpLocation([]byte(tc.msg)); got != tc.want {
+				t.Errorf("unexpected location, want: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_validSsdpLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		location string
+		want     bool
+	}{
+		{"same host", "192.168.1.10", "http://192.168.1.10:8008/desc.xml", true},
+		{"other host", "192.168.1.10", "http://192.168.1.11:8008/desc.xml", false},
+		{"hostname", "192.168.1.10", "http://tv.lan:8008/desc.xml", false},
+		{"public ip", "1.1.1.1", "http://1.1.1.1/desc.xml", false},
+		{"https", "192.168.1.10", "https://192.168.1.10/desc.xml", false},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := validSsdpLocation(tc.ip, tc.location); got != tc.want {
+				t.Errorf("unexpected result, want: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_parseSsdpDescription(t *testing.T) {
+	desc := `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>Living Room TV</friendlyName>
+    <manufacturer>Samsung</manufacturer>
+    <modelName>UE55</modelName>
+  </device>
+</root>`
+	d, err := parseSsdpDescription(strings.NewReader(desc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.friendlyName != "Living Room TV" {
+		t.Errorf("unexpected friendly name: %q", d.friendlyName)
+	}
+	if d.deviceType != "tv" {
+		t.Errorf("unexpected device type: %q", d.deviceType)
+	}
+
+	if _, err := parseSsdpDescription(strings.NewReader("<root><device></device></root>")); err == nil {
+		t.Error("expected error for description without device information")
+	}
+}
+
+func Test_ssdpDeviceClass(t *testing.T) {
+	tests := []struct {
+		deviceType string
+		model      string
+		want       string
+	}{
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", "", "tv"},
+		{"urn:dial-multiscreen-org:device:dial:1", "", "tv"},
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", "Sony PlayStation 5", "console"},
+		{"urn:schemas-upnp-org:device:InternetGatewayDevice:1", "", "router"},
+		{"urn:schemas-upnp-org:device:DigitalSecurityCamera:1", "", "camera"},
+		{"urn:schemas-upnp-org:device:Basic:1", "", "Basic"},
+		{"invalid", "", ""},
+	}
+	for _, tc := range tests {
+		if got := ssdpDeviceClass(tc.deviceType, tc.model); got != tc.want {
+			t.Errorf("ssdpDeviceClass(%q, %q): want %q, got %q", tc.deviceType, tc.model, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package clientinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"tailscale.com/util/lineread"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+// storeStaticLease saves a static lease/reservation to dhcp table.
+// Any of ip, mac or hostname could be empty, the available mappings are stored.
+func (d *dhcp) storeStaticLease(ip, mac, hostname string) {
+	ip = normalizeIP(strings.Trim(ip, "[]"))
+	if net.ParseIP(ip) == nil {
+		ip = ""
+	}
+	mac = strings.ToLower(mac)
+	if _, err := net.ParseMAC(mac); err != nil {
+		mac = ""
+	}
+	if ip != "" && mac != "" {
+		d.mac.Store(ip, mac)
+		d.ip.Store(mac, ip)
+	}
+	if hostname == "" || hostname == "*" {
+		return
+	}
+	name := normalizeHostname(hostname)
+	if mac != "" {
+		d.mac2name.Store(mac, name)
+	}
+	if ip != "" {
+		d.ip2name.Store(ip, name)
+	}
+}
+
+// dnsmasqConfReadClientInfoFile populates dhcp table with static leases reading from dnsmasq config file.
+func (d *dhcp) dnsmasqConfReadClientInfoFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.dnsmasqConfReadClientInfoReader(f)
+}
+
+// dnsmasqConfReadClientInfoReader performs the same task as dnsmasqConfReadClientInfoFile,
+// but by reading from an io.Reader instead of file.
+//
+// Static leases are defined using "dhcp-host" option, with format:
+//
+//	dhcp-host=[<hwaddr>][,id:<client_id>|*][,set:<tag>][tag:<tag>][,<ipaddr>][,<hostname>][,<lease_time>][,ignore]
+func (d *dhcp) dnsmasqConfReadClientInfoReader(reader io.Reader) error {
+	return lineread.Reader(reader, func(line []byte) error {
+		value, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("dhcp-host="))
+		if !ok {
+			return nil
+		}
+		var ip, mac, hostname string
+		for _, field := range strings.Split(string(value), ",") {
+			field = strings.TrimSpace(field)
+			switch {
+			case field == "" || field == "ignore" || field == "infinite":
+			case strings.HasPrefix(field, "id:"), strings.HasPrefix(field, "set:"), strings.HasPrefix(field, "tag:"):
+			case net.ParseIP(strings.Trim(field, "[]")) != nil:
+				if ip == "" {
+					ip = field
+				}
+			case isMAC(field):
+				if mac == "" {
+					mac = field
+				}
+			case isDnsmasqLeaseTime(field):
+			default:
+				hostname = field
+			}
+		}
+		if ip == "" && hostname == "" {
+			return nil
+		}
+		d.storeStaticLease(ip, mac, hostname)
+		return nil
+	})
+}
+
+// keaConfReadClientInfoFile populates dhcp table with reservations reading from Kea config file.
+func (d *dhcp) keaConfReadClientInfoFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.keaConfReadClientInfoReader(f)
+}
+
+// keaConfReadClientInfoReader performs the same task as keaConfReadClientInfoFile,
+// but by reading from an io.Reader instead of file.
+//
+// Reservations could be defined globally, or per subnet, so all "reservations" in config are read.
+func (d *dhcp) keaConfReadClientInfoReader(r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var cfg any
+	if err := json.Unmarshal(stripJSONComments(content), &cfg); err != nil {
+		return err
+	}
+	walkKeaReservations(cfg, func(reservation map[string]any) {
+		mac, _ := reservation["hw-address"].(string)
+		hostname, _ := reservation["hostname"].(string)
+		ips := make([]string, 0, 1)
+		if ip, ok := reservation["ip-address"].(string); ok {
+			ips = append(ips, ip)
+		}
+		if addrs, ok := reservation["ip-addresses"].([]any); ok {
+			for _, addr := range addrs {
+				if ip, ok := addr.(string); ok {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		for _, ip := range ips {
+			d.storeStaticLease(ip, mac, hostname)
+		}
+	})
+	return nil
+}
+
+// walkKeaReservations calls fn for every reservation found in Kea config v.
+func walkKeaReservations(v any, fn func(map[string]any)) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if reservations, ok := val.([]any); ok && key == "reservations" {
+				for _, r := range reservations {
+					if reservation, ok := r.(map[string]any); ok {
+						fn(reservation)
+					}
+				}
+				continue
+			}
+			walkKeaReservations(val, fn)
+		}
+	case []any:
+		for _, val := range v {
+			walkKeaReservations(val, fn)
+		}
+	}
+}
+
+// stripJSONComments removes "//", "#" and "/* */" comments, which are allowed in Kea config, from content.
+func stripJSONComments(content []byte) []byte {
+	out := make([]byte, 0, len(content))
+	inString, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if inString {
+			out = append(out, c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '#', c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			if i < len(content) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := bytes.Index(content[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// pfsenseStaticMap is a static mapping in pfSense/OPNsense config. Both ISC DHCP "staticmap"
+// and OPNsense Kea "reservation" elements are read into it.
+type pfsenseStaticMap struct {
+	Mac       string `xml:"mac"`
+	IPAddr    string `xml:"ipaddr"`
+	IPAddrV6  string `xml:"ipaddrv6"`
+	Hostname  string `xml:"hostname"`
+	HwAddress string `xml:"hw_address"`
+	IPAddress string `xml:"ip_address"`
+}
+
+// pfsenseXMLReadClientInfoFile populates dhcp table with static mappings reading from pfSense/OPNsense config file.
+func (d *dhcp) pfsenseXMLReadClientInfoFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.pfsenseXMLReadClientInfoReader(f)
+}
+
+// pfsenseXMLReadClientInfoReader performs the same task as pfsenseXMLReadClientInfoFile,
+// but by reading from an io.Reader instead of file.
+func (d *dhcp) pfsenseXMLReadClientInfoReader(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "staticmap" && se.Name.Local != "reservation") {
+			continue
+		}
+		var sm pfsenseStaticMap
+		if err := decoder.DecodeElement(&sm, &se); err != nil {
+			ctrld.ProxyLogger.Load().Warn().Err(err).Msg("invalid static mapping entry")
+			continue
+		}
+		mac := sm.Mac
+		if mac == "" {
+			mac = sm.HwAddress
+		}
+		for _, ip := range []string{sm.IPAddr, sm.IPAddrV6, sm.IPAddress} {
+			if ip != "" {
+				d.storeStaticLease(ip, mac, sm.Hostname)
+			}
+		}
+	}
+}
+
+// isMAC reports whether s is a valid MAC address.
+func isMAC(s string) bool {
+	_, err := net.ParseMAC(s)
+	return err == nil
+}
+
+// isDnsmasqLeaseTime reports whether s is a dnsmasq lease time, e.g: "45m", "12h", "3600".
+func isDnsmasqLeaseTime(s string) bool {
+	s = strings.TrimRight(s, "smhdw")
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package clientinfo
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_staticLeasesReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		readFunc func(d *dhcp) func(r io.Reader) error
+		ip       string
+		mac      string
+		hostname string
+	}{
+		{
+			"dnsmasq conf",
+			`# static leases
+domain=lan
+dhcp-host=00:11:22:33:44:55,set:known,192.168.1.10,nas,infinite
+`,
+			func(d *dhcp) func(r io.Reader) error { return d.dnsmasqConfReadClientInfoReader },
+			"192.168.1.10",
+			"00:11:22:33:44:55",
+			"nas",
+		},
+		{
+			"dnsmasq conf ipv6",
+			`dhcp-host=00:11:22:33:44:56,[2001:db8::10],printer.lan,12h`,
+			func(d *dhcp) func(r io.Reader) error { return d.dnsmasqConfReadClientInfoReader },
+			"2001:db8::10",
+			"00:11:22:33:44:56",
+			"printer",
+		},
+		{
+			"kea conf",
+			`{
+  // Kea allows comments.
+  "Dhcp4": {
+    "reservations": [],
+    "subnet4": [{
+      "subnet": "192.168.1.0/24", # subnet of LAN
+      "reservations": [
+        /* reservation of NAS */
+        {"hw-address": "00:11:22:33:44:57", "ip-address": "192.168.1.11", "hostname": "nas-kea"}
+      ]
+    }]
+  }
+}`,
+			func(d *dhcp) func(r io.Reader) error { return d.keaConfReadClientInfoReader },
+			"192.168.1.11",
+			"00:11:22:33:44:57",
+			"nas-kea",
+		},
+		{
+			"pfsense xml",
+			`<?xml version="1.0"?>
+<pfsense>
+  <dhcpd>
+    <lan>
+      <staticmap>
+        <mac>00:11:22:33:44:58</mac>
+        <ipaddr>192.168.1.12</ipaddr>
+        <hostname>nas-pfsense</hostname>
+        <descr><![CDATA[NAS]]></descr>
+      </staticmap>
+    </lan>
+  </dhcpd>
+</pfsense>`,
+			func(d *dhcp) func(r io.Reader) error { return d.pfsenseXMLReadClientInfoReader },
+			"192.168.1.12",
+			"00:11:22:33:44:58",
+			"nas-pfsense",
+		},
+		{
+			"opnsense kea xml",
+			`<?xml version="1.0"?>
+<opnsense>
+  <OPNsense>
+    <Kea>
+      <dhcp4>
+        <reservations>
+          <reservation uuid="1">
+            <hw_address>00:11:22:33:44:59</hw_address>
+            <ip_address>192.168.1.13</ip_address>
+            <hostname>nas-opnsense</hostname>
+          </reservation>
+        </reservations>
+      </dhcp4>
+    </Kea>
+  </OPNsense>
+</opnsense>`,
+			func(d *dhcp) func(r io.Reader) error { return d.pfsenseXMLReadClientInfoReader },
+			"192.168.1.13",
+			"00:11:22:33:44:59",
+			"nas-opnsense",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &dhcp{}
+			if err := tc.readFunc(d)(strings.NewReader(tc.in)); err != nil {
+				t.Fatal(err)
+			}
+			if got := d.LookupMac(tc.ip); got != tc.mac {
+				t.Errorf("unexpected mac, want: %q, got: %q", tc.mac, got)
+			}
+			if got := d.LookupHostnameByIP(tc.ip); got != tc.hostname {
+				t.Errorf("unexpected hostname by ip, want: %q, got: %q", tc.hostname, got)
+			}
+			if got := d.LookupHostnameByMac(tc.mac); got != tc.hostname {
+				t.Errorf("unexpected hostname by mac, want: %q, got: %q", tc.hostname, got)
+			}
+		})
+	}
+}
+
+func Test_stripJSONComments(t *testing.T) {
+	in := `{"url": "http://example.com/#anchor", /* block */ "a": 1 // line
+# hash
+}`
+	want := "{\"url\": \"http://example.com/#anchor\",  \"a\": 1 \n\n}"
+	if got := string(stripJSONComments([]byte(in))); got != want {
+		t.Errorf("unexpected result, want: %q, got: %q", want, got)
+	}
+}
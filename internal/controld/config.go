@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Control-D-Inc/ctrld"
@@ -28,8 +31,61 @@ const (
 	resolverDataURLCom = "https://api.controld.com/utility"
 	resolverDataURLDev = "https://api.controld.dev/utility"
 	InvalidConfigCode  = 40402
+	utilityPath        = "/utility"
 )
 
+// apiURL is the custom Control D API base URL, set by SetAPIURL.
+var apiURL atomic.Pointer[url.URL]
+
+// apiRootCAs, if set, is used for verifying the certificate of Control D API, instead of the default roots.
+var apiRootCAs *x509.CertPool
+
+// SetAPIURL overrides the Control D API base URL, for using staging, regional or proxied
+// endpoints instead of the production one. An empty rawURL resets to the default API URL.
+//
+// Only https URLs are accepted, since API requests carry the resolver UID.
+func SetAPIURL(rawURL string) error {
+	if rawURL == "" {
+		apiURL.Store(nil)
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("invalid API URL scheme: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid API URL: %q", rawURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	apiURL.Store(u)
+	return nil
+}
+
+// resolverDataURL returns the URL of utility API.
+func resolverDataURL(cdDev bool) string {
+	if u := apiURL.Load(); u != nil {
+		return u.JoinPath(utilityPath).String()
+	}
+	if cdDev {
+		return resolverDataURLDev
+	}
+	return resolverDataURLCom
+}
+
+// apiDomain returns the domain of Control D API.
+func apiDomain(cdDev bool) string {
+	if u := apiURL.Load(); u != nil {
+		return u.Hostname()
+	}
+	if cdDev {
+		return apiDomainDev
+	}
+	return apiDomainCom
+}
+
 // ResolverConfig represents Control D resolver data.
 type ResolverConfig struct {
 	DOH   string `json:"doh"`
@@ -107,11 +163,7 @@ func UpdateCustomLastFailed(rawUID, version string, cdDev, lastUpdatedFailed boo
 }
 
 func postUtilityAPI(version string, cdDev, lastUpdatedFailed bool, body io.Reader) (*ResolverConfig, error) {
-	apiUrl := resolverDataURLCom
-	if cdDev {
-		apiUrl = resolverDataURLDev
-	}
-	req, err := http.NewRequest("POST", apiUrl, body)
+	req, err := http.NewRequest("POST", resolverDataURL(cdDev), body)
 	if err != nil {
 		return nil, fmt.Errorf("http.NewRequest: %w", err)
 	}
@@ -123,33 +175,9 @@ func postUtilityAPI(version string, cdDev, lastUpdatedFailed bool, body io.Reade
 	}
 	req.URL.RawQuery = q.Encode()
 	req.Header.Add("Content-Type", "application/json")
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		apiDomain := apiDomainCom
-		if cdDev {
-			apiDomain = apiDomainDev
-		}
-		ips := ctrld.LookupIP(apiDomain)
-		if len(ips) == 0 {
-			ctrld.ProxyLogger.Load().Warn().Msgf("No IPs found for %s, connecting to %s", apiDomain, addr)
-			return ctrldnet.Dialer.DialContext(ctx, network, addr)
-		}
-		ctrld.ProxyLogger.Load().Debug().Msgf("API IPs: %v", ips)
-		_, port, _ := net.SplitHostPort(addr)
-		addrs := make([]string, len(ips))
-		for i := range ips {
-			addrs[i] = net.JoinHostPort(ips[i], port)
-		}
-		d := &ctrldnet.ParallelDialer{}
-		return d.DialContext(ctx, network, addrs)
-	}
-
-	if router.Name() == ddwrt.Name || runtime.GOOS == "android" {
-		transport.TLSClientConfig = &tls.Config{RootCAs: certs.CACertPool()}
-	}
 	client := http.Client{
 		Timeout:   10 * time.Second,
-		Transport: transport,
+		Transport: apiTransport(cdDev),
 	}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -172,6 +200,39 @@ func postUtilityAPI(version string, cdDev, lastUpdatedFailed bool, body io.Reade
 	return &ur.Body.Resolver, nil
 }
 
+// apiTransport returns the http transport for connecting to Control D API.
+func apiTransport(cdDev bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		domain := apiDomain(cdDev)
+		host, port, _ := net.SplitHostPort(addr)
+		// Connecting to an IP address, or to an egress proxy instead of the API itself.
+		if host != domain || net.ParseIP(domain) != nil {
+			return ctrldnet.Dialer.DialContext(ctx, network, addr)
+		}
+		ips := ctrld.LookupIP(domain)
+		if len(ips) == 0 {
+			ctrld.ProxyLogger.Load().Warn().Msgf("No IPs found for %s, connecting to %s", domain, addr)
+			return ctrldnet.Dialer.DialContext(ctx, network, addr)
+		}
+		ctrld.ProxyLogger.Load().Debug().Msgf("API IPs: %v", ips)
+		addrs := make([]string, len(ips))
+		for i := range ips {
+			addrs[i] = net.JoinHostPort(ips[i], port)
+		}
+		d := &ctrldnet.ParallelDialer{}
+		return d.DialContext(ctx, network, addrs)
+	}
+
+	switch {
+	case apiRootCAs != nil:
+		transport.TLSClientConfig = &tls.Config{RootCAs: apiRootCAs}
+	case router.Name() == ddwrt.Name || runtime.GOOS == "android":
+		transport.TLSClientConfig = &tls.Config{RootCAs: certs.CACertPool()}
+	}
+	return transport
+}
+
 // ParseRawUID parse the input raw UID, returning real UID and ClientID.
 // The raw UID can have 2 forms:
 //
@@ -1,9 +1,12 @@
 package controld
 
 import (
+	"crypto/x509"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_parseUID(t *testing.T) {
@@ -29,3 +32,38 @@ func Test_parseUID(t *testing.T) {
 		})
 	}
 }
+
+func TestSetAPIURL(t *testing.T) {
+	t.Cleanup(func() { _ = SetAPIURL("") })
+
+	assert.Equal(t, resolverDataURLCom, resolverDataURL(false))
+	assert.Equal(t, resolverDataURLDev, resolverDataURL(true))
+	assert.Equal(t, apiDomainCom, apiDomain(false))
+
+	assert.NoError(t, SetAPIURL("https://api.example.com/controld/"))
+	assert.Equal(t, "https://api.example.com/controld/utility", resolverDataURL(false))
+	// Custom API URL takes precedence over dev API.
+	assert.Equal(t, "https://api.example.com/controld/utility", resolverDataURL(true))
+	assert.Equal(t, "api.example.com", apiDomain(true))
+
+	assert.Error(t, SetAPIURL("ftp://api.example.com"))
+	// The resolver UID must not be sent in cleartext.
+	assert.Error(t, SetAPIURL("http://api.example.com"))
+	assert.Error(t, SetAPIURL("https://"))
+
+	assert.NoError(t, SetAPIURL(""))
+	assert.Equal(t, resolverDataURLCom, resolverDataURL(false))
+}
+
+// useTestAPIServer uses TLS server ts as Control D API, until the test finishes.
+func useTestAPIServer(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	apiRootCAs = pool
+	t.Cleanup(func() {
+		apiRootCAs = nil
+		_ = SetAPIURL("")
+	})
+	require.NoError(t, SetAPIURL(ts.URL))
+}
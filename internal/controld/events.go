@@ -0,0 +1,88 @@
+package controld
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventsPath = "/utility/events"
+	// eventsWaitTime is the time which the API holds the long-poll request, waiting for config changes.
+	eventsWaitTime = 60 * time.Second
+)
+
+// ErrEventsUnsupported is returned by WaitConfigChange when the API does not support the events channel.
+var ErrEventsUnsupported = errors.New("config change events are not supported by API")
+
+// ConfigChangeEvent represents the config changed event, sent by Control D API.
+type ConfigChangeEvent struct {
+	Changed          bool  `json:"changed"`
+	CustomLastUpdate int64 `json:"custom_last_update"`
+}
+
+type eventsResponse struct {
+	Success bool              `json:"success"`
+	Body    ConfigChangeEvent `json:"body"`
+}
+
+// eventsURL returns the URL of config change events API.
+func eventsURL(cdDev bool) string {
+	if u := apiURL.Load(); u != nil {
+		return u.JoinPath(eventsPath).String()
+	}
+	return "https://" + apiDomain(cdDev) + eventsPath
+}
+
+// WaitConfigChange long-polls Control D API, waiting for the config of given uid to change after
+// the since unix timestamp. The API answers as soon as there's a change, or after the wait time
+// passed without any changes, in which case the returned event has Changed set to false.
+func WaitConfigChange(ctx context.Context, rawUID, version string, cdDev bool, since int64) (*ConfigChangeEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, eventsURL(cdDev), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+	uid, clientID := ParseRawUID(rawUID)
+	q := req.URL.Query()
+	q.Set("platform", "ctrld")
+	q.Set("version", version)
+	q.Set("uid", uid)
+	if clientID != "" {
+		q.Set("client_id", clientID)
+	}
+	q.Set("since", strconv.FormatInt(since, 10))
+	q.Set("timeout", strconv.Itoa(int(eventsWaitTime.Seconds())))
+	req.URL.RawQuery = q.Encode()
+	client := http.Client{
+		// Leave some room for the API to answer after the wait time passed.
+		Timeout:   eventsWaitTime + 30*time.Second,
+		Transport: apiTransport(cdDev),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return &ConfigChangeEvent{}, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrEventsUnsupported
+	default:
+		errResp := &UtilityErrorResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(errResp); err != nil {
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return nil, errResp
+	}
+	er := &eventsResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(er); err != nil {
+		return nil, err
+	}
+	return &er.Body, nil
+}
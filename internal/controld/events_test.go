@@ -0,0 +1,46 @@
+package controld
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitConfigChange(t *testing.T) {
+
+	status := http.StatusOK
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, eventsPath, r.URL.Path)
+		assert.Equal(t, "p2", r.URL.Query().Get("uid"))
+		assert.Equal(t, "client1", r.URL.Query().Get("client_id"))
+		assert.Equal(t, "100", r.URL.Query().Get("since"))
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"success":true,"body":{"changed":true,"custom_last_update":123}}`))
+		}
+	}))
+	defer ts.Close()
+	useTestAPIServer(t, ts)
+
+	ev, err := WaitConfigChange(context.Background(), "p2/client1", "dev-test", false, 100)
+	require.NoError(t, err)
+	assert.True(t, ev.Changed)
+	assert.Equal(t, int64(123), ev.CustomLastUpdate)
+
+	status = http.StatusNoContent
+	ev, err = WaitConfigChange(context.Background(), "p2/client1", "dev-test", false, 100)
+	require.NoError(t, err)
+	assert.False(t, ev.Changed)
+
+	status = http.StatusNotFound
+	_, err = WaitConfigChange(context.Background(), "p2/client1", "dev-test", false, 100)
+	assert.ErrorIs(t, err, ErrEventsUnsupported)
+
+	status = http.StatusBadGateway
+	_, err = WaitConfigChange(context.Background(), "p2/client1", "dev-test", false, 100)
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package controld
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	pairPath       = "/utility/pair"
+	pairStatusPath = "/utility/pair/status"
+
+	pairStatusPending  = "pending"
+	pairStatusApproved = "approved"
+	pairStatusDenied   = "denied"
+	pairStatusExpired  = "expired"
+)
+
+var (
+	// ErrPairingDenied is returned by WaitPairing when the user rejected the pairing request.
+	ErrPairingDenied = errors.New("device pairing was denied")
+	// ErrPairingExpired is returned by WaitPairing when the pairing code expired before being approved.
+	ErrPairingExpired = errors.New("device pairing code expired")
+)
+
+// pairingPollInterval is the default interval between pairing status checks,
+// used if the API does not specify one.
+var pairingPollInterval = 5 * time.Second
+
+// PairingSession is a pending device pairing, which the user approves in Control D
+// dashboard, by scanning the QR code of URL, or entering Code.
+type PairingSession struct {
+	Code string `json:"code"`
+	URL  string `json:"url"`
+	// Token identifies the session when polling for its status, it must not be shown to the user.
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+	Interval  int    `json:"interval"`
+}
+
+type pairingRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+type pairingResponse struct {
+	Success bool           `json:"success"`
+	Body    PairingSession `json:"body"`
+}
+
+type pairingStatusRequest struct {
+	Token string `json:"token"`
+}
+
+type pairingStatusResponse struct {
+	Success bool `json:"success"`
+	Body    struct {
+		Status   string         `json:"status"`
+		Resolver ResolverConfig `json:"resolver"`
+	} `json:"body"`
+}
+
+// pairURL returns the URL of device pairing API path.
+func pairURL(cdDev bool, path string) string {
+	if u := apiURL.Load(); u != nil {
+		return u.JoinPath(path).String()
+	}
+	return "https://" + apiDomain(cdDev) + path
+}
+
+// StartPairing requests a new device pairing session for hostname. If hostname is empty,
+// the OS hostname is used.
+func StartPairing(hostname, version string, cdDev bool) (*PairingSession, error) {
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	body, _ := json.Marshal(pairingRequest{Hostname: hostname})
+	pr := &pairingResponse{}
+	if err := postPairingAPI(context.Background(), pairURL(cdDev, pairPath), version, cdDev, body, pr); err != nil {
+		return nil, err
+	}
+	if pr.Body.Code == "" || pr.Body.Token == "" {
+		return nil, errors.New("invalid pairing session")
+	}
+	return &pr.Body, nil
+}
+
+// WaitPairing polls Control D API until the pairing session is approved, then returns the
+// resolver config of the paired device. It returns early if the session was denied, expired,
+// or ctx is done.
+func WaitPairing(ctx context.Context, session *PairingSession, version string, cdDev bool) (*ResolverConfig, error) {
+	interval := pairingPollInterval
+	if session.Interval > 0 {
+		interval = time.Duration(session.Interval) * time.Second
+	}
+	if session.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(session.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	body, _ := json.Marshal(pairingStatusRequest{Token: session.Token})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrPairingExpired
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		sr := &pairingStatusResponse{}
+		if err := postPairingAPI(ctx, pairURL(cdDev, pairStatusPath), version, cdDev, body, sr); err != nil {
+			// Network errors are retried until the session expires, API errors are not.
+			var errResp *UtilityErrorResponse
+			if ctx.Err() == nil && !errors.As(err, &errResp) {
+				continue
+			}
+			return nil, err
+		}
+		switch sr.Body.Status {
+		case pairStatusApproved:
+			if sr.Body.Resolver.UID == "" {
+				return nil, errors.New("invalid resolver uid")
+			}
+			return &sr.Body.Resolver, nil
+		case pairStatusDenied:
+			return nil, ErrPairingDenied
+		case pairStatusExpired:
+			return nil, ErrPairingExpired
+		case pairStatusPending:
+		default:
+			return nil, fmt.Errorf("unknown pairing status: %q", sr.Body.Status)
+		}
+	}
+}
+
+func postPairingAPI(ctx context.Context, url, version string, cdDev bool, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	q := req.URL.Query()
+	q.Set("platform", "ctrld")
+	q.Set("version", version)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Add("Content-Type", "application/json")
+	client := http.Client{
+		Timeout:   10 * time.Second,
+		Transport: apiTransport(cdDev),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		errResp := &UtilityErrorResponse{}
+		if err := d.Decode(errResp); err != nil {
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return errResp
+	}
+	return d.Decode(v)
+}
@@ -0,0 +1,69 @@
+package controld
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPairing(t *testing.T) {
+	oldInterval := pairingPollInterval
+	pairingPollInterval = time.Millisecond
+	t.Cleanup(func() { pairingPollInterval = oldInterval })
+
+	polls := 0
+	final := pairStatusApproved
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "ctrld", r.URL.Query().Get("platform"))
+		switch r.URL.Path {
+		case pairPath:
+			var req pairingRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "router", req.Hostname)
+			_, _ = w.Write([]byte(`{"success":true,"body":{"code":"ABCD-1234","url":"https://controld.com/pair/ABCD-1234","token":"secret","expires_in":60}}`))
+		case pairStatusPath:
+			var req pairingStatusRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "secret", req.Token)
+			polls++
+			status := pairStatusPending
+			if polls == 3 {
+				status = final
+			}
+			_, _ = w.Write([]byte(`{"success":true,"body":{"status":"` + status + `","resolver":{"uid":"p2"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	useTestAPIServer(t, ts)
+
+	session, err := StartPairing("router", "dev-test", false)
+	require.NoError(t, err)
+	assert.Equal(t, "ABCD-1234", session.Code)
+	assert.Equal(t, "https://controld.com/pair/ABCD-1234", session.URL)
+
+	rc, err := WaitPairing(context.Background(), session, "dev-test", false)
+	require.NoError(t, err)
+	assert.Equal(t, "p2", rc.UID)
+	assert.Equal(t, 3, polls)
+
+	polls, final = 0, pairStatusDenied
+	_, err = WaitPairing(context.Background(), session, "dev-test", false)
+	assert.ErrorIs(t, err, ErrPairingDenied)
+
+	polls, final = 0, pairStatusExpired
+	_, err = WaitPairing(context.Background(), session, "dev-test", false)
+	assert.ErrorIs(t, err, ErrPairingExpired)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = WaitPairing(ctx, session, "dev-test", false)
+	assert.ErrorIs(t, err, context.Canceled)
+}
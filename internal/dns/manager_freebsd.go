@@ -38,6 +38,11 @@ func NewOSConfigurator(logf logger.Logf, health *health.Tracker, _ *controlknobs
 			return newDirectManager(logf, health), nil
 		}
 	default:
+		// dhclient-script(8) passes DNS settings to resolvconf(8) if it's installed, which
+		// re-generates resolv.conf on every lease renewal, overriding settings written directly.
+		if resolvconfUpdatesResolvConf() {
+			return newOpenresolvManager(logf)
+		}
 		return newDirectManager(logf, health), nil
 	}
 }
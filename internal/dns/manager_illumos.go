@@ -0,0 +1,14 @@
+package dns
+
+import (
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+)
+
+// NewOSConfigurator creates a new OS configurator.
+//
+// On illumos, /etc/resolv.conf is managed directly, the interface name and knobs are ignored.
+func NewOSConfigurator(logf logger.Logf, health *health.Tracker, _ *controlknobs.Knobs, _ string) (OSConfigurator, error) {
+	return newDirectManager(logf, health), nil
+}
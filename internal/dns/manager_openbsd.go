@@ -0,0 +1,30 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+)
+
+// NewOSConfigurator creates a new OS configurator.
+//
+// The health tracker may be nil; the knobs may be nil and are ignored on this platform.
+func NewOSConfigurator(logf logger.Logf, health *health.Tracker, _ *controlknobs.Knobs, interfaceName string) (OSConfigurator, error) {
+	bs, err := os.ReadFile("/etc/resolv.conf")
+	if os.IsNotExist(err) {
+		return newDirectManager(logf, health), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading /etc/resolv.conf: %w", err)
+	}
+	// resolvd(8) marks nameservers it manages with "# resolvd: <iface>" comments.
+	if bytes.Contains(bs, []byte("# resolvd: ")) || exec.Command("pgrep", "-x", "resolvd").Run() == nil {
+		return newResolvdManager(logf, interfaceName), nil
+	}
+	return newDirectManager(logf, health), nil
+}
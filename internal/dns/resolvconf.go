@@ -6,8 +6,12 @@
 package dns
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"os"
 	"os/exec"
+	"strings"
 )
 
 func resolvconfStyle() string {
@@ -28,3 +32,45 @@ func resolvconfStyle() string {
 	// Treat everything else as openresolv, by far the more popular implementation.
 	return "openresolv"
 }
+
+// resolvconfConfPath is the path of resolvconf(8) config file.
+const resolvconfConfPath = "/etc/resolvconf.conf"
+
+// resolvconfUpdatesResolvConf reports whether openresolv is installed and updates resolv.conf,
+// that is, resolv.conf is not disabled by "resolvconf=NO" in resolvconf.conf(5).
+func resolvconfUpdatesResolvConf() bool {
+	if resolvconfStyle() != "openresolv" {
+		return false
+	}
+	f, err := os.Open(resolvconfConfPath)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	return resolvconfEnabled(f)
+}
+
+// resolvconfEnabled reports whether the resolvconf.conf(5) content read from r does not
+// disable updating resolv.conf.
+func resolvconfEnabled(r io.Reader) bool {
+	enabled := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value, ok := strings.CutPrefix(line, "resolvconf=")
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(value, '#'); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch strings.ToLower(value) {
+		case "no", "false", "off", "0":
+			enabled = false
+		default:
+			enabled = true
+		}
+	}
+	return enabled
+}
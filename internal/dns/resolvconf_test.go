@@ -0,0 +1,30 @@
+//go:build linux || freebsd || openbsd
+
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolvconfEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", true},
+		{"name servers only", "name_servers=127.0.0.1\n", true},
+		{"disabled", "resolvconf=NO\n", false},
+		{"disabled quoted", `resolvconf="no" # managed manually` + "\n", false},
+		{"re-enabled", "resolvconf=NO\nresolvconf=YES\n", true},
+		{"commented out", "#resolvconf=NO\n", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resolvconfEnabled(strings.NewReader(tc.content)); got != tc.want {
+				t.Errorf("resolvconfEnabled() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
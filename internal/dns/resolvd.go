@@ -0,0 +1,82 @@
+//go:build openbsd
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/types/logger"
+)
+
+// resolvdLoopbackInterface is the interface which nameservers are proposed for,
+// if the given interface does not exist.
+const resolvdLoopbackInterface = "lo0"
+
+// resolvdManager manages DNS configuration using resolvd(8) on OpenBSD. Nameservers are
+// proposed using route(8) "nameserver" command, the same way unwind(8) does, so they are
+// kept by resolvd, instead of being overwritten when resolvd re-generates resolv.conf.
+type resolvdManager struct {
+	logf   logger.Logf
+	ifName string
+}
+
+func newResolvdManager(logf logger.Logf, interfaceName string) *resolvdManager {
+	if _, err := net.InterfaceByName(interfaceName); err != nil {
+		interfaceName = resolvdLoopbackInterface
+	}
+	return &resolvdManager{logf: logf, ifName: interfaceName}
+}
+
+// proposeNameservers proposes nameservers for the interface, an empty list
+// removes nameservers proposed earlier.
+func (m *resolvdManager) proposeNameservers(nameservers []string) error {
+	args := append([]string{"nameserver", m.ifName}, nameservers...)
+	cmd := exec.Command("route", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s: %s: %w", cmd, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func (m *resolvdManager) SetDNS(config OSConfig) error {
+	if config.IsZero() {
+		return m.Close()
+	}
+	nameservers := make([]string, 0, len(config.Nameservers))
+	for _, ns := range config.Nameservers {
+		nameservers = append(nameservers, ns.String())
+	}
+	return m.proposeNameservers(nameservers)
+}
+
+func (m *resolvdManager) SupportsSplitDNS() bool {
+	return false
+}
+
+func (m *resolvdManager) GetBaseConfig() (OSConfig, error) {
+	bs, err := os.ReadFile(resolvConf)
+	if err != nil {
+		return OSConfig{}, err
+	}
+	// Exclude nameservers proposed by ctrld.
+	var sb strings.Builder
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.HasSuffix(strings.TrimSpace(line), "# resolvd: "+m.ifName) {
+			continue
+		}
+		sb.WriteString(line + "\n")
+	}
+	return readResolv(strings.NewReader(sb.String()))
+}
+
+func (m *resolvdManager) Close() error {
+	return m.proposeNameservers(nil)
+}
+
+func (m *resolvdManager) Mode() string {
+	return "resolvd"
+}
@@ -0,0 +1,182 @@
+package dnscache
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+var _ evictionCache = (*arcCache)(nil)
+
+// arcCache is a fixed size Adaptive Replacement Cache, balancing between recently and frequently used values.
+//
+// It's the same algorithm as lru.ARCCache, which does not report evicted values, so the memory used by
+// cached values could not be tracked.
+type arcCache struct {
+	size    int
+	onEvict func(Key, *Value)
+
+	mu sync.Mutex
+	p  int                           // the preference towards t1 or t2.
+	t1 *simplelru.LRU[Key, *Value]   // recently used values.
+	t2 *simplelru.LRU[Key, *Value]   // frequently used values.
+	b1 *simplelru.LRU[Key, struct{}] // keys recently evicted from t1.
+	b2 *simplelru.LRU[Key, struct{}] // keys recently evicted from t2.
+}
+
+// newARC creates a new arcCache with given size, onEvict is called for values removed from the cache, if not nil.
+func newARC(size int, onEvict func(Key, *Value)) (*arcCache, error) {
+	t1, err := simplelru.NewLRU[Key, *Value](size, nil)
+	if err != nil {
+		return nil, err
+	}
+	t2, _ := simplelru.NewLRU[Key, *Value](size, nil)
+	b1, _ := simplelru.NewLRU[Key, struct{}](size, nil)
+	b2, _ := simplelru.NewLRU[Key, struct{}](size, nil)
+	return &arcCache{size: size, onEvict: onEvict, t1: t1, t2: t2, b1: b1, b2: b2}, nil
+}
+
+// Get looks up key's value, promoting it to frequently used values.
+func (c *arcCache) Get(key Key) (*Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.t1.Peek(key); ok {
+		c.t1.Remove(key)
+		c.t2.Add(key, v)
+		return v, true
+	}
+	return c.t2.Get(key)
+}
+
+// Add adds a value to the cache, evicting a value if the cache is full.
+func (c *arcCache) Add(key Key, value *Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.t1.Contains(key) {
+		c.t1.Remove(key)
+		c.t2.Add(key, value)
+		return
+	}
+	if c.t2.Contains(key) {
+		c.t2.Add(key, value)
+		return
+	}
+	// The key was evicted recently, adapt the preference towards the list it was evicted from.
+	if c.b1.Contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.Len(), c.b2.Len(); b2Len > b1Len {
+			delta = b2Len / b1Len
+		}
+		c.p = min(c.p+delta, c.size)
+		if c.t1.Len()+c.t2.Len() >= c.size {
+			c.replace(false)
+		}
+		c.b1.Remove(key)
+		c.t2.Add(key, value)
+		return
+	}
+	if c.b2.Contains(key) {
+		delta := 1
+		if b1Len, b2Len := c.b1.Len(), c.b2.Len(); b1Len > b2Len {
+			delta = b1Len / b2Len
+		}
+		c.p = max(c.p-delta, 0)
+		if c.t1.Len()+c.t2.Len() >= c.size {
+			c.replace(true)
+		}
+		c.b2.Remove(key)
+		c.t2.Add(key, value)
+		return
+	}
+	if c.t1.Len()+c.t2.Len() >= c.size {
+		c.replace(false)
+	}
+	if c.b1.Len() > c.size-c.p {
+		c.b1.RemoveOldest()
+	}
+	if c.b2.Len() > c.p {
+		c.b2.RemoveOldest()
+	}
+	c.t1.Add(key, value)
+}
+
+// replace evicts a value from either t1 or t2, based on the current preference, the key is remembered
+// in the corresponding ghost list.
+func (c *arcCache) replace(b2ContainsKey bool) (Key, *Value, bool) {
+	if t1Len := c.t1.Len(); t1Len > 0 && (t1Len > c.p || (t1Len == c.p && b2ContainsKey)) {
+		k, v, ok := c.t1.RemoveOldest()
+		if ok {
+			c.b1.Add(k, struct{}{})
+			c.evicted(k, v)
+		}
+		return k, v, ok
+	}
+	k, v, ok := c.t2.RemoveOldest()
+	if ok {
+		c.b2.Add(k, struct{}{})
+		c.evicted(k, v)
+	}
+	return k, v, ok
+}
+
+// RemoveOldest evicts the value which would be evicted next.
+func (c *arcCache) RemoveOldest() (Key, *Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.replace(false)
+}
+
+// Peek returns key's value without promoting it.
+func (c *arcCache) Peek(key Key) (*Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.t1.Peek(key); ok {
+		return v, true
+	}
+	return c.t2.Peek(key)
+}
+
+// Remove removes key's value from the cache.
+func (c *arcCache) Remove(key Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range []*simplelru.LRU[Key, *Value]{c.t1, c.t2} {
+		if v, ok := t.Peek(key); ok {
+			t.Remove(key)
+			c.evicted(key, v)
+			return
+		}
+	}
+	if !c.b1.Remove(key) {
+		c.b2.Remove(key)
+	}
+}
+
+// Keys returns the keys of cached values, recently used values first.
+func (c *arcCache) Keys() []Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(c.t1.Keys(), c.t2.Keys()...)
+}
+
+// Purge clears the cache.
+func (c *arcCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range []*simplelru.LRU[Key, *Value]{c.t1, c.t2} {
+		for _, k := range t.Keys() {
+			v, _ := t.Peek(k)
+			c.evicted(k, v)
+		}
+		t.Purge()
+	}
+	c.b1.Purge()
+	c.b2.Purge()
+	c.p = 0
+}
+
+func (c *arcCache) evicted(key Key, value *Value) {
+	if c.onEvict != nil {
+		c.onEvict(key, value)
+	}
+}
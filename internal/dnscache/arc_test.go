@@ -0,0 +1,50 @@
+package dnscache
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestARCCache(t *testing.T) {
+	_, err := newARC(0, nil)
+	require.Error(t, err)
+
+	var evicted []Key
+	c, err := newARC(3, func(k Key, _ *Value) { evicted = append(evicted, k) })
+	require.NoError(t, err)
+	keys := make([]Key, 5)
+	for i, name := range []string{"a.com.", "b.com.", "c.com.", "d.com.", "e.com."} {
+		keys[i] = Key{Qtype: dns.TypeA, Qclass: dns.ClassINET, Name: name}
+	}
+	for _, k := range keys[:3] {
+		c.Add(k, &Value{})
+	}
+	// a.com is used again, so it's kept while b.com, only used once, is evicted.
+	c.Get(keys[0])
+	c.Add(keys[3], &Value{})
+	assert.Equal(t, []Key{keys[1]}, evicted)
+	_, ok := c.Peek(keys[1])
+	assert.False(t, ok)
+	_, ok = c.Peek(keys[0])
+	assert.True(t, ok)
+
+	// Replacing a value is not an eviction.
+	c.Add(keys[0], &Value{})
+	assert.Len(t, evicted, 1)
+
+	k, _, ok := c.RemoveOldest()
+	assert.True(t, ok)
+	assert.Equal(t, keys[2], k)
+	c.Remove(keys[3])
+	assert.Equal(t, []Key{keys[1], keys[2], keys[3]}, evicted)
+	assert.Equal(t, []Key{keys[0]}, c.Keys())
+
+	c.Purge()
+	assert.Empty(t, c.Keys())
+	assert.Equal(t, []Key{keys[1], keys[2], keys[3], keys[0]}, evicted)
+	_, ok = c.Get(keys[0])
+	assert.False(t, ok)
+}
@@ -1,7 +1,10 @@
 package dnscache
 
 import (
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
@@ -13,6 +16,10 @@ type Cacher interface {
 	Get(Key) *Value
 	Add(Key, *Value)
 	Purge()
+	// Keys returns the keys of values cached in memory.
+	Keys() []Key
+	// Remove removes the cached values of domain name, for all query types and upstreams.
+	Remove(name string)
 }
 
 // Key is the caching key for DNS message.
@@ -21,18 +28,106 @@ type Key struct {
 	Qclass   uint16
 	Name     string
 	Upstream string
+	// DNSSEC reports whether the DO bit was set, answers with DNSSEC records are cached separately.
+	DNSSEC bool
 }
 
+// Value is a cached DNS message. The message is stored in wire format, which takes much less memory than
+// the decoded *dns.Msg, since names are compressed, and there's no per record allocation.
 type Value struct {
 	Expire time.Time
-	Msg    *dns.Msg
+	packed []byte
+}
+
+// Msg returns a new DNS message, unpacked from the cached value.
+func (v *Value) Msg() (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(v.packed); err != nil {
+		return nil, err
+	}
+	// Compression is not set when unpacking, but answers should be sent to clients compressed.
+	msg.Compress = true
+	return msg, nil
+}
+
+// Packed returns the cached DNS message in wire format, which must not be modified.
+func (v *Value) Packed() []byte {
+	return v.packed
+}
+
+// valueOverhead is the estimated memory used by a cached value, besides its packed message and key name,
+// e.g: list element, map entry and Value struct.
+const valueOverhead = 160
+
+// memoryUsage returns the estimated memory used by the cached value of key.
+func memoryUsage(key Key, v *Value) int64 {
+	return int64(len(v.packed) + len(key.Name) + len(key.Upstream) + valueOverhead)
+}
+
+// Eviction policies of LRUCache.
+const (
+	// EvictionLRU evicts the least recently used values, this is the default.
+	EvictionLRU = "lru"
+	// EvictionLFU evicts the least frequently used values, so popular domains are kept while
+	// a long tail of domains queried once are evicted.
+	EvictionLFU = "lfu"
+	// EvictionARC balances between recency and frequency, adapting to the workload.
+	EvictionARC = "arc"
+)
+
+// evictionCache is the fixed size cache, which evicts values using an eviction policy.
+//
+// Keys returns the keys in eviction order, the first key is evicted first. RemoveOldest evicts the
+// value which would be evicted next, when the cache is full.
+type evictionCache interface {
+	Get(Key) (*Value, bool)
+	Add(Key, *Value)
+	Peek(Key) (*Value, bool)
+	Remove(Key)
+	RemoveOldest() (Key, *Value, bool)
+	Keys() []Key
+	Purge()
+}
+
+// lruEvictionCache wraps lru.Cache to implement evictionCache interface.
+type lruEvictionCache struct {
+	*lru.Cache[Key, *Value]
+}
+
+func (l lruEvictionCache) Add(key Key, value *Value) { l.Cache.Add(key, value) }
+func (l lruEvictionCache) Remove(key Key)            { l.Cache.Remove(key) }
+
+// newEvictionCache returns the cache with given size, using given eviction policy. If not nil, onEvict is
+// called for values removed from the cache, by evicting, Remove, RemoveOldest or Purge, but not for values
+// replaced by Add.
+func newEvictionCache(size int, eviction string, onEvict func(Key, *Value)) (evictionCache, error) {
+	switch eviction {
+	case EvictionLRU, "":
+		c, err := lru.NewWithEvict[Key, *Value](size, onEvict)
+		if err != nil {
+			return nil, err
+		}
+		return lruEvictionCache{c}, nil
+	case EvictionLFU:
+		return newLFU(size, onEvict)
+	case EvictionARC:
+		return newARC(size, onEvict)
+	}
+	return nil, fmt.Errorf("unknown eviction policy: %q", eviction)
 }
 
 var _ Cacher = (*LRUCache)(nil)
 
 // LRUCache implements Cacher interface.
 type LRUCache struct {
-	cacher *lru.ARCCache[Key, *Value]
+	cacher evictionCache
+
+	// maxMemory is the memory limit of cached values, no limit if non-positive.
+	maxMemory int64
+	// mu serializes Add, so the memory used by replaced values is accounted correctly.
+	mu sync.Mutex
+	// memory is the estimated memory used by cached values, decreased by the cacher eviction callback.
+	memory atomic.Int64
 }
 
 // Get looks up key's value from cache.
@@ -41,34 +136,86 @@ func (l *LRUCache) Get(key Key) *Value {
 	return v
 }
 
-// Add adds a value to cache.
+// Add adds a value to cache. If the memory limit is reached, values are evicted using the eviction policy.
 func (l *LRUCache) Add(key Key, value *Value) {
+	if l.maxMemory <= 0 {
+		l.cacher.Add(key, value)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if old, ok := l.cacher.Peek(key); ok {
+		l.memory.Add(-memoryUsage(key, old))
+	}
 	l.cacher.Add(key, value)
+	l.memory.Add(memoryUsage(key, value))
+	for l.memory.Load() > l.maxMemory {
+		if _, _, ok := l.cacher.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+// evicted accounts the memory of value removed by the cacher.
+func (l *LRUCache) evicted(key Key, value *Value) {
+	l.memory.Add(-memoryUsage(key, value))
 }
 
 // Purge clears the cache.
 func (l *LRUCache) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.cacher.Purge()
+	l.memory.Store(0)
+}
+
+// Keys returns the keys of cached values.
+func (l *LRUCache) Keys() []Key {
+	return l.cacher.Keys()
 }
 
-// NewLRUCache creates a new LRUCache instance with given size.
-func NewLRUCache(size int) (*LRUCache, error) {
-	cacher, err := lru.NewARC[Key, *Value](size)
-	return &LRUCache{cacher: cacher}, err
+// Remove removes the cached values of domain name.
+func (l *LRUCache) Remove(name string) {
+	name = dns.Fqdn(normalizeQname(name))
+	for _, key := range l.cacher.Keys() {
+		if key.Name == name {
+			l.cacher.Remove(key)
+		}
+	}
+}
+
+// NewLRUCache creates a new LRUCache instance with given size, and given memory limit in bytes,
+// the memory usage is not limited if maxMemory is non-positive. The eviction policy is one of
+// EvictionLRU, EvictionLFU or EvictionARC, EvictionLRU is used if eviction is empty.
+func NewLRUCache(size int, maxMemory int64, eviction string) (*LRUCache, error) {
+	l := &LRUCache{maxMemory: maxMemory}
+	var onEvict func(Key, *Value)
+	if maxMemory > 0 {
+		onEvict = l.evicted
+	}
+	cacher, err := newEvictionCache(size, eviction, onEvict)
+	if err != nil {
+		return nil, err
+	}
+	l.cacher = cacher
+	return l, nil
 }
 
 // NewKey creates a new cache key for given DNS message.
 func NewKey(msg *dns.Msg, upstream string) Key {
 	q := msg.Question[0]
-	return Key{Qtype: q.Qtype, Qclass: q.Qclass, Name: normalizeQname(q.Name), Upstream: upstream}
+	opt := msg.IsEdns0()
+	return Key{Qtype: q.Qtype, Qclass: q.Qclass, Name: normalizeQname(q.Name), Upstream: upstream, DNSSEC: opt != nil && opt.Do()}
 }
 
-// NewValue creates a new cache value for given DNS message.
-func NewValue(msg *dns.Msg, expire time.Time) *Value {
-	return &Value{
-		Expire: expire,
-		Msg:    msg,
+// NewValue creates a new cache value for given DNS message, which is packed with name compression.
+func NewValue(msg *dns.Msg, expire time.Time) (*Value, error) {
+	msg.Compress = true
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
 	}
+	return &Value{Expire: expire, packed: packed}, nil
 }
 
 func normalizeQname(name string) string {
@@ -0,0 +1,160 @@
+package dnscache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestValue(t *testing.T, msg *dns.Msg, expire time.Time) *Value {
+	t.Helper()
+	v, err := NewValue(msg, expire)
+	require.NoError(t, err)
+	return v
+}
+
+func TestLRUCache_Remove(t *testing.T) {
+	c, err := NewLRUCache(16, 0, "")
+	require.NoError(t, err)
+	var keys []Key
+	for _, q := range []struct {
+		name     string
+		qtype    uint16
+		upstream string
+	}{
+		{"example.com.", dns.TypeA, "upstream.0"},
+		{"EXAMPLE.com.", dns.TypeAAAA, "upstream.1"},
+		{"www.example.com.", dns.TypeA, "upstream.0"},
+	} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(q.name, q.qtype)
+		key := NewKey(msg, q.upstream)
+		keys = append(keys, key)
+		c.Add(key, newTestValue(t, msg, time.Now().Add(time.Minute)))
+	}
+	assert.ElementsMatch(t, keys, c.Keys())
+
+	c.Remove("Example.com")
+	assert.Equal(t, []Key{keys[2]}, c.Keys())
+	assert.Nil(t, c.Get(keys[0]))
+	assert.NotNil(t, c.Get(keys[2]))
+}
+
+func TestNewKey_DNSSEC(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	assert.False(t, NewKey(msg, "upstream.0").DNSSEC)
+	msg.SetEdns0(1232, false)
+	assert.False(t, NewKey(msg, "upstream.0").DNSSEC)
+	msg.IsEdns0().SetDo()
+	assert.True(t, NewKey(msg, "upstream.0").DNSSEC)
+}
+
+func TestValue_Msg(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	rr, err := dns.NewRR("example.com. 300 IN A 1.2.3.4")
+	require.NoError(t, err)
+	answer.Answer = append(answer.Answer, rr)
+
+	v := newTestValue(t, answer, time.Now().Add(time.Minute))
+	got, err := v.Msg()
+	require.NoError(t, err)
+	assert.True(t, got.Compress)
+	assert.Equal(t, answer.String(), got.String())
+
+	// Unpacked messages must not share state with the cached value.
+	got.Answer[0].(*dns.A).A[0] = 5
+	again, err := v.Msg()
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", again.Answer[0].(*dns.A).A.String())
+}
+
+func TestLRUCache_maxMemory(t *testing.T) {
+	newValue := func(name string) (Key, *Value) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeA)
+		return NewKey(msg, "upstream.0"), newTestValue(t, msg, time.Now().Add(time.Minute))
+	}
+	key, value := newValue("example00.com.")
+	usage := memoryUsage(key, value)
+
+	for _, eviction := range []string{EvictionLRU, EvictionLFU, EvictionARC} {
+		t.Run(eviction, func(t *testing.T) {
+			c, err := NewLRUCache(15, 10*usage, eviction)
+			require.NoError(t, err)
+			// The memory used by cached values is tracked exactly, including replaced, removed and evicted ones.
+			assertMemory := func() {
+				t.Helper()
+				var memory int64
+				for _, k := range c.Keys() {
+					if v, ok := c.cacher.Peek(k); ok {
+						memory += memoryUsage(k, v)
+					}
+				}
+				assert.Equal(t, memory, c.memory.Load())
+				assert.LessOrEqual(t, memory, c.maxMemory)
+			}
+			var keys []Key
+			for i := 0; i < 20; i++ {
+				key, value := newValue(fmt.Sprintf("example%02d.com.", i))
+				keys = append(keys, key)
+				c.Add(key, value)
+				assertMemory()
+			}
+			assert.Len(t, c.Keys(), 10)
+			// Oldest values are evicted first.
+			assert.Nil(t, c.Get(keys[0]))
+			assert.NotNil(t, c.Get(keys[19]))
+
+			_, value := newValue("example19.com.")
+			c.Add(keys[19], value)
+			assertMemory()
+			c.Remove("example18.com")
+			assertMemory()
+			assert.Len(t, c.Keys(), 9)
+
+			c.Purge()
+			assert.Empty(t, c.Keys())
+			assert.Zero(t, c.memory.Load())
+		})
+	}
+}
+
+func TestNewLRUCache_eviction(t *testing.T) {
+	for _, eviction := range []string{"", EvictionLRU, EvictionLFU, EvictionARC} {
+		t.Run(eviction, func(t *testing.T) {
+			c, err := NewLRUCache(2, 0, eviction)
+			require.NoError(t, err)
+			var keys []Key
+			for _, name := range []string{"a.com.", "b.com.", "c.com."} {
+				msg := new(dns.Msg)
+				msg.SetQuestion(name, dns.TypeA)
+				key := NewKey(msg, "upstream.0")
+				keys = append(keys, key)
+				c.Add(key, newTestValue(t, msg, time.Now().Add(time.Minute)))
+				// The first value is used more, and more recently than the second one.
+				if len(keys) == 2 {
+					c.Get(keys[0])
+				}
+			}
+			assert.Len(t, c.Keys(), 2)
+			assert.Nil(t, c.Get(keys[1]))
+			assert.NotNil(t, c.Get(keys[0]))
+			assert.NotNil(t, c.Get(keys[2]))
+		})
+	}
+	_, err := NewLRUCache(2, 0, "fifo")
+	assert.Error(t, err)
+
+	// LRU is the default eviction policy.
+	c, err := NewLRUCache(2, 0, "")
+	require.NoError(t, err)
+	assert.IsType(t, lruEvictionCache{}, c.cacher)
+}
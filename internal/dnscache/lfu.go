@@ -0,0 +1,168 @@
+package dnscache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+var _ evictionCache = (*lfuCache)(nil)
+
+// lfuCache is a fixed size cache, which evicts the least frequently used value when full.
+// Values having the same frequency are evicted from the least recently used one.
+//
+// All operations are O(1), using a list of frequencies, each holds the list of values used that often.
+type lfuCache struct {
+	size    int
+	onEvict func(Key, *Value)
+
+	mu      sync.Mutex
+	items   map[Key]*lfuEntry
+	buckets *list.List // of *lfuBucket, ordered by ascending frequency.
+}
+
+type lfuBucket struct {
+	freq    uint64
+	entries *list.List // of *lfuEntry, ordered from least to most recently used.
+}
+
+type lfuEntry struct {
+	key    Key
+	value  *Value
+	bucket *list.Element
+	elem   *list.Element
+}
+
+// newLFU creates a new lfuCache with given size, onEvict is called for values removed from the cache, if not nil.
+func newLFU(size int, onEvict func(Key, *Value)) (*lfuCache, error) {
+	if size <= 0 {
+		return nil, errors.New("must provide a positive size")
+	}
+	return &lfuCache{size: size, onEvict: onEvict, items: make(map[Key]*lfuEntry), buckets: list.New()}, nil
+}
+
+// Get looks up key's value, increasing its frequency.
+func (c *lfuCache) Get(key Key) (*Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.increment(e)
+	return e.value, true
+}
+
+// Add adds a value to the cache, evicting the least frequently used value if the cache is full.
+// The frequency of existing value is increased, as it was used again.
+func (c *lfuCache) Add(key Key, value *Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.value = value
+		c.increment(e)
+		return
+	}
+	if len(c.items) >= c.size {
+		c.removeOldest()
+	}
+	front := c.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = c.buckets.PushFront(&lfuBucket{freq: 1, entries: list.New()})
+	}
+	e := &lfuEntry{key: key, value: value, bucket: front}
+	e.elem = front.Value.(*lfuBucket).entries.PushBack(e)
+	c.items[key] = e
+}
+
+// Peek returns key's value without updating its frequency.
+func (c *lfuCache) Peek(key Key) (*Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		return e.value, true
+	}
+	return nil, false
+}
+
+// RemoveOldest evicts the value which would be evicted next.
+func (c *lfuCache) RemoveOldest() (Key, *Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removeOldest()
+}
+
+// Remove removes key's value from the cache.
+func (c *lfuCache) Remove(key Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeEntry(e)
+	}
+}
+
+// Keys returns the keys of cached values, in eviction order.
+func (c *lfuCache) Keys() []Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]Key, 0, len(c.items))
+	for b := c.buckets.Front(); b != nil; b = b.Next() {
+		for el := b.Value.(*lfuBucket).entries.Front(); el != nil; el = el.Next() {
+			keys = append(keys, el.Value.(*lfuEntry).key)
+		}
+	}
+	return keys
+}
+
+// Purge clears the cache.
+func (c *lfuCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.onEvict != nil {
+		for k, e := range c.items {
+			c.onEvict(k, e.value)
+		}
+	}
+	c.items = make(map[Key]*lfuEntry)
+	c.buckets.Init()
+}
+
+// increment moves e to the bucket of next frequency.
+func (c *lfuCache) increment(e *lfuEntry) {
+	cur := e.bucket
+	freq := cur.Value.(*lfuBucket).freq + 1
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != freq {
+		next = c.buckets.InsertAfter(&lfuBucket{freq: freq, entries: list.New()}, cur)
+	}
+	c.unlink(e)
+	e.bucket = next
+	e.elem = next.Value.(*lfuBucket).entries.PushBack(e)
+}
+
+func (c *lfuCache) removeOldest() (Key, *Value, bool) {
+	front := c.buckets.Front()
+	if front == nil {
+		return Key{}, nil, false
+	}
+	e := front.Value.(*lfuBucket).entries.Front().Value.(*lfuEntry)
+	c.removeEntry(e)
+	return e.key, e.value, true
+}
+
+func (c *lfuCache) removeEntry(e *lfuEntry) {
+	c.unlink(e)
+	delete(c.items, e.key)
+	if c.onEvict != nil {
+		c.onEvict(e.key, e.value)
+	}
+}
+
+// unlink removes e from its bucket, the bucket is removed too if it becomes empty.
+func (c *lfuCache) unlink(e *lfuEntry) {
+	b := e.bucket.Value.(*lfuBucket)
+	b.entries.Remove(e.elem)
+	if b.entries.Len() == 0 {
+		c.buckets.Remove(e.bucket)
+	}
+}
@@ -0,0 +1,48 @@
+package dnscache
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLFUCache(t *testing.T) {
+	_, err := newLFU(0, nil)
+	require.Error(t, err)
+
+	c, err := newLFU(3, nil)
+	require.NoError(t, err)
+	keys := make([]Key, 4)
+	for i, name := range []string{"a.com.", "b.com.", "c.com.", "d.com."} {
+		keys[i] = Key{Qtype: dns.TypeA, Qclass: dns.ClassINET, Name: name}
+	}
+	for _, k := range keys[:3] {
+		c.Add(k, &Value{})
+	}
+	// a.com is used the most, then c.com, b.com is used only once.
+	c.Get(keys[0])
+	c.Get(keys[0])
+	c.Get(keys[2])
+	assert.Equal(t, []Key{keys[1], keys[2], keys[0]}, c.Keys())
+
+	// Peek does not change the frequency.
+	_, ok := c.Peek(keys[1])
+	assert.True(t, ok)
+	c.Add(keys[3], &Value{})
+	_, ok = c.Get(keys[1])
+	assert.False(t, ok)
+	assert.Equal(t, []Key{keys[3], keys[2], keys[0]}, c.Keys())
+
+	// Values with the same frequency are evicted from the least recently used one.
+	c.Get(keys[3])
+	assert.Equal(t, []Key{keys[2], keys[3], keys[0]}, c.Keys())
+
+	c.Remove(keys[0])
+	assert.Equal(t, []Key{keys[2], keys[3]}, c.Keys())
+	c.Purge()
+	assert.Empty(t, c.Keys())
+	_, ok = c.Get(keys[2])
+	assert.False(t, ok)
+}
@@ -0,0 +1,181 @@
+//go:build redis
+
+package dnscache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix = "ctrld:cache:"
+	// redisTimeout is the maximum time waiting for Redis, queries are resolved using upstreams if exceeded.
+	redisTimeout = 100 * time.Millisecond
+	// redisPurgeTimeout is the maximum time for removing all cache entries from Redis.
+	redisPurgeTimeout = time.Minute
+	// redisRetryInterval is the time which Redis is not used after failures, so an unreachable
+	// Redis does not delay all queries.
+	redisRetryInterval = 5 * time.Second
+)
+
+// RedisSupported reports whether the Redis cache backend is compiled in.
+const RedisSupported = true
+
+var _ Cacher = (*RedisCache)(nil)
+
+// RedisCache implements Cacher interface using Redis, so cached answers are shared by
+// multiple ctrld instances. An in-memory LRU cache is used in front of Redis, so hot
+// entries do not require round trips to Redis.
+type RedisCache struct {
+	local     *LRUCache
+	client    *redis.Client
+	downUntil atomic.Int64
+}
+
+// NewRedisCache creates a new RedisCache instance with given Redis URL, e.g: "redis://:password@localhost:6379/0",
+// and given size, memory limit and eviction policy for in-memory cache.
+func NewRedisCache(rawURL string, size int, maxMemory int64, eviction string) (*RedisCache, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	local, err := NewLRUCache(size, maxMemory, eviction)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisCache{local: local, client: redis.NewClient(opts)}, nil
+}
+
+// Get looks up key's value from in-memory cache, then from Redis. The expired in-memory
+// value is returned if there's no value in Redis, so stale answers could still be served.
+func (r *RedisCache) Get(key Key) *Value {
+	local := r.local.Get(key)
+	if local != nil && time.Now().Before(local.Expire) {
+		return local
+	}
+	if !r.available() {
+		return local
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	b, err := r.client.Get(ctx, redisKey(key)).Bytes()
+	if err != nil {
+		r.checkErr(err)
+		return local
+	}
+	v, err := decodeRedisValue(b)
+	if err != nil {
+		return local
+	}
+	r.local.Add(key, v)
+	return v
+}
+
+// Add adds a value to in-memory cache, and to Redis in background.
+func (r *RedisCache) Add(key Key, value *Value) {
+	r.local.Add(key, value)
+	ttl := time.Until(value.Expire)
+	if ttl <= 0 || !r.available() {
+		return
+	}
+	b := encodeRedisValue(value)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		r.checkErr(r.client.Set(ctx, redisKey(key), b, ttl).Err())
+	}()
+}
+
+// Purge clears the in-memory cache, and all ctrld entries in Redis.
+func (r *RedisCache) Purge() {
+	r.local.Purge()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisPurgeTimeout)
+		defer cancel()
+		iter := r.client.Scan(ctx, 0, redisKeyPrefix+"*", 1000).Iterator()
+		keys := make([]string, 0, 1000)
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+			if len(keys) == cap(keys) {
+				_ = r.client.Unlink(ctx, keys...).Err()
+				keys = keys[:0]
+			}
+		}
+		if len(keys) > 0 {
+			_ = r.client.Unlink(ctx, keys...).Err()
+		}
+	}()
+}
+
+// Keys returns the keys of values in the in-memory cache, entries only cached in Redis are not listed.
+func (r *RedisCache) Keys() []Key {
+	return r.local.Keys()
+}
+
+// Remove removes the cached values of domain name from in-memory cache, and from Redis in background.
+func (r *RedisCache) Remove(name string) {
+	r.local.Remove(name)
+	name = dns.Fqdn(normalizeQname(name))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisPurgeTimeout)
+		defer cancel()
+		// The name is the last part of Redis keys, see redisKey.
+		iter := r.client.Scan(ctx, 0, redisKeyPrefix+"*:"+name, 1000).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if len(keys) > 0 {
+			_ = r.client.Unlink(ctx, keys...).Err()
+		}
+	}()
+}
+
+// Ping reports whether Redis is reachable.
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// available reports whether Redis should be used, it's not after recent failures.
+func (r *RedisCache) available() bool {
+	return time.Now().UnixNano() >= r.downUntil.Load()
+}
+
+// checkErr marks Redis unavailable for a while if err is not a cache miss.
+func (r *RedisCache) checkErr(err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		r.downUntil.Store(time.Now().Add(redisRetryInterval).UnixNano())
+	}
+}
+
+// redisKey returns the Redis key of cache key.
+func redisKey(key Key) string {
+	prefix := redisKeyPrefix
+	if key.DNSSEC {
+		prefix += "do:"
+	}
+	return prefix + strconv.Itoa(int(key.Qtype)) + ":" + strconv.Itoa(int(key.Qclass)) + ":" + key.Upstream + ":" + key.Name
+}
+
+// encodeRedisValue encodes v as the expiration unix time in milliseconds, followed by the packed DNS message.
+func encodeRedisValue(v *Value) []byte {
+	b := make([]byte, 8, 8+len(v.packed))
+	binary.BigEndian.PutUint64(b, uint64(v.Expire.UnixMilli()))
+	return append(b, v.packed...)
+}
+
+// decodeRedisValue decodes value encoded by encodeRedisValue.
+func decodeRedisValue(b []byte) (*Value, error) {
+	// The packed message must have at least the DNS header.
+	if len(b) < 8+12 {
+		return nil, errors.New("invalid cache value")
+	}
+	return &Value{Expire: time.UnixMilli(int64(binary.BigEndian.Uint64(b[:8]))), packed: b[8:]}, nil
+}
@@ -0,0 +1,28 @@
+//go:build !redis
+
+package dnscache
+
+import (
+	"context"
+	"errors"
+)
+
+// RedisSupported reports whether the Redis cache backend is compiled in, which requires the "redis" build tag.
+const RedisSupported = false
+
+var errRedisUnsupported = errors.New(`redis cache backend is not supported by this build of ctrld, it requires the "redis" build tag`)
+
+// RedisCache is the Redis cache backend, which is not available in this build.
+type RedisCache struct {
+	*LRUCache
+}
+
+// NewRedisCache always returns an error, since the Redis cache backend is not compiled in.
+func NewRedisCache(rawURL string, size int, maxMemory int64, eviction string) (*RedisCache, error) {
+	return nil, errRedisUnsupported
+}
+
+// Ping always returns an error, since the Redis cache backend is not compiled in.
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return errRedisUnsupported
+}
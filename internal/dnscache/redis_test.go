@@ -0,0 +1,56 @@
+//go:build redis
+
+package dnscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_redisValue(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Answer = append(msg.Answer, &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}})
+	expire := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+
+	v, err := decodeRedisValue(encodeRedisValue(newTestValue(t, msg, expire)))
+	require.NoError(t, err)
+	assert.True(t, expire.Equal(v.Expire))
+	got, err := v.Msg()
+	require.NoError(t, err)
+	assert.Equal(t, msg.String(), got.String())
+
+	_, err = decodeRedisValue([]byte{1, 2})
+	assert.Error(t, err)
+}
+
+func TestRedisCache_unreachable(t *testing.T) {
+	_, err := NewRedisCache("not a url", 16, 0, "")
+	assert.Error(t, err)
+
+	rc, err := NewRedisCache("redis://127.0.0.1:1/0", 16, 0, "")
+	require.NoError(t, err)
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	key := NewKey(msg, "upstream.0")
+
+	assert.Nil(t, rc.Get(key))
+	assert.False(t, rc.available())
+
+	// In-memory cache keeps working, including stale entries.
+	rc.Add(key, newTestValue(t, msg, time.Now().Add(-time.Second)))
+	v := rc.Get(key)
+	require.NotNil(t, v)
+	assert.True(t, v.Expire.Before(time.Now()))
+}
+
+func Test_redisKey_DNSSEC(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.SetEdns0(1232, true)
+	assert.NotEqual(t, redisKey(NewKey(msg, "upstream.0")), redisKey(Key{Qtype: dns.TypeA, Qclass: dns.ClassINET, Name: "example.com.", Upstream: "upstream.0"}))
+}
@@ -0,0 +1,77 @@
+// Package dnspool provides sync.Pool based reuse of DNS packing buffers,
+// reducing allocations in the DNS proxy hot path.
+package dnspool
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// bufSize is the initial size of pooled packing buffers, which is large enough for
+// most DNS messages. Larger messages cause miekg/dns to allocate a new buffer.
+const bufSize = 4096
+
+// maxBufSize is the maximum capacity of a buffer that is returned to the pool,
+// so an unusually large message won't keep a large buffer alive.
+const maxBufSize = dns.MaxMsgSize
+
+var packedPool = sync.Pool{
+	New: func() any {
+		return &Packed{buf: make([]byte, bufSize)}
+	},
+}
+
+var bytesBufferPool = sync.Pool{
+	New: func() any {
+		return bytes.NewBuffer(make([]byte, 0, bufSize))
+	},
+}
+
+// Packed is a DNS message packed into a pooled buffer.
+type Packed struct {
+	buf  []byte
+	data []byte
+}
+
+// Bytes returns the wire format of the packed message. It is only valid until Release is called.
+func (p *Packed) Bytes() []byte {
+	return p.data
+}
+
+// Release puts the buffer back to the pool. The caller must not use p after calling Release.
+func (p *Packed) Release() {
+	// PackBuffer may allocate a new buffer if the pooled one is too small, keep the larger one.
+	if c := cap(p.data); c > cap(p.buf) && c <= maxBufSize {
+		p.buf = p.data[:c]
+	}
+	p.data = nil
+	packedPool.Put(p)
+}
+
+// Pack packs the DNS message into a pooled buffer.
+func Pack(msg *dns.Msg) (*Packed, error) {
+	p := packedPool.Get().(*Packed)
+	data, err := msg.PackBuffer(p.buf)
+	if err != nil {
+		p.Release()
+		return nil, err
+	}
+	p.data = data
+	return p, nil
+}
+
+// GetBytesBuffer returns an empty bytes.Buffer from the pool.
+func GetBytesBuffer() *bytes.Buffer {
+	return bytesBufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBytesBuffer resets the bytes.Buffer then puts it back to the pool.
+func PutBytesBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxBufSize {
+		return
+	}
+	b.Reset()
+	bytesBufferPool.Put(b)
+}
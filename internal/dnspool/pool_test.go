@@ -0,0 +1,59 @@
+package dnspool
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAnswer() *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	answer := new(dns.Msg)
+	answer.SetReply(msg)
+	for i := 0; i < 4; i++ {
+		answer.Answer = append(answer.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(192, 0, 2, byte(i)),
+		})
+	}
+	return answer
+}
+
+func TestPack(t *testing.T) {
+	answer := newAnswer()
+	want, err := answer.Pack()
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		packed, err := Pack(answer)
+		require.NoError(t, err)
+		assert.Equal(t, want, packed.Bytes())
+		packed.Release()
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	answer := newAnswer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packed, err := Pack(answer)
+		if err != nil {
+			b.Fatal(err)
+		}
+		packed.Release()
+	}
+}
+
+func BenchmarkPackNoPool(b *testing.B) {
+	answer := newAnswer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := answer.Pack(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+// Package expr implements the expression language of policy rules, e.g:
+//
+//	qtype == "A" && client in group("iot") && match(qname, "*.telemetry.*")
+//
+// Expressions are compiled when loading config, so syntax errors are reported early,
+// and evaluating them for each query does not require parsing.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Variables of expressions.
+const (
+	// VarQname is the queried domain, without trailing dot.
+	VarQname = "qname"
+	// VarQtype is the query type, e.g: "A", "AAAA".
+	VarQtype = "qtype"
+	// VarClient is the client IP address.
+	VarClient = "client"
+	// VarMac is the client MAC address.
+	VarMac = "mac"
+	// VarHostname is the client hostname.
+	VarHostname = "hostname"
+	// VarListener is the listener number which received the query.
+	VarListener = "listener"
+)
+
+// Functions of expressions.
+const (
+	// FuncMatch reports whether its first argument matches the wildcard pattern of the second one.
+	FuncMatch = "match"
+	// FuncGroup is the set of clients in the named group.
+	FuncGroup = "group"
+	// FuncList is the set of domains in the named domain list.
+	FuncList = "list"
+	// FuncCidr is the set of IP addresses in the network.
+	FuncCidr = "cidr"
+)
+
+var variables = []string{VarQname, VarQtype, VarClient, VarMac, VarHostname, VarListener}
+
+// Env provides the values of variables, and the members of named sets, when evaluating expressions.
+type Env interface {
+	// Var returns the value of variable name.
+	Var(name string) string
+	// InGroup reports whether value is a client in the named group.
+	InGroup(group, value string) bool
+	// InList reports whether value is a domain in the named domain list.
+	InList(list, value string) bool
+}
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile compiles the expression src, which must evaluate to a boolean.
+func Compile(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	if root.typ() != typeBool {
+		return nil, errors.New("expression must be a boolean")
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval reports whether the expression is true in env.
+func (e *Expr) Eval(env Env) bool {
+	return evalBool(e.root, env)
+}
+
+// References returns names of the sets of function fn, e.g: groups used by the expression.
+func (e *Expr) References(fn string) []string {
+	var names []string
+	walk(e.root, func(n node) {
+		if s, ok := n.(*setNode); ok && s.fn == fn && !slices.Contains(names, s.name) {
+			names = append(names, s.name)
+		}
+	})
+	return names
+}
+
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeBool
+	typeSet
+)
+
+type node interface {
+	typ() valueType
+}
+
+type (
+	// stringNode is a string literal.
+	stringNode struct{ value string }
+	// boolNode is a boolean literal.
+	boolNode struct{ value bool }
+	// varNode is a variable reference.
+	varNode struct{ name string }
+	// listNode is a list of string literals.
+	listNode struct{ values []string }
+	// setNode is a named set, i.e: group, domain list or network.
+	setNode struct {
+		fn    string
+		name  string
+		ipNet *net.IPNet
+	}
+	// matchNode is a call of match function.
+	matchNode struct {
+		value   node
+		pattern node
+	}
+	// notNode is the negation of a boolean.
+	notNode struct{ x node }
+	// binaryNode is a binary operation: "&&", "||", "==", "!=".
+	binaryNode struct {
+		op   string
+		l, r node
+	}
+	// inNode is the membership test of a string in a list, or a set.
+	inNode struct {
+		value node
+		set   node
+	}
+)
+
+func (*stringNode) typ() valueType { return typeString }
+func (*boolNode) typ() valueType   { return typeBool }
+func (*varNode) typ() valueType    { return typeString }
+func (*listNode) typ() valueType   { return typeSet }
+func (*setNode) typ() valueType    { return typeSet }
+func (*matchNode) typ() valueType  { return typeBool }
+func (*notNode) typ() valueType    { return typeBool }
+func (*binaryNode) typ() valueType { return typeBool }
+func (*inNode) typ() valueType     { return typeBool }
+
+// walk calls fn for n and all its descendants.
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch n := n.(type) {
+	case *matchNode:
+		walk(n.value, fn)
+		walk(n.pattern, fn)
+	case *notNode:
+		walk(n.x, fn)
+	case *binaryNode:
+		walk(n.l, fn)
+		walk(n.r, fn)
+	case *inNode:
+		walk(n.value, fn)
+		walk(n.set, fn)
+	}
+}
+
+func evalBool(n node, env Env) bool {
+	switch n := n.(type) {
+	case *boolNode:
+		return n.value
+	case *notNode:
+		return !evalBool(n.x, env)
+	case *binaryNode:
+		switch n.op {
+		case "&&":
+			return evalBool(n.l, env) && evalBool(n.r, env)
+		case "||":
+			return evalBool(n.l, env) || evalBool(n.r, env)
+		case "==":
+			return strings.EqualFold(evalString(n.l, env), evalString(n.r, env))
+		case "!=":
+			return !strings.EqualFold(evalString(n.l, env), evalString(n.r, env))
+		}
+	case *matchNode:
+		matched, _ := path.Match(strings.ToLower(evalString(n.pattern, env)), strings.ToLower(evalString(n.value, env)))
+		return matched
+	case *inNode:
+		return contains(n.set, env, evalString(n.value, env))
+	}
+	return false
+}
+
+func evalString(n node, env Env) string {
+	switch n := n.(type) {
+	case *stringNode:
+		return n.value
+	case *varNode:
+		return env.Var(n.name)
+	}
+	return ""
+}
+
+func contains(n node, env Env, value string) bool {
+	switch n := n.(type) {
+	case *listNode:
+		return slices.ContainsFunc(n.values, func(v string) bool { return strings.EqualFold(v, value) })
+	case *setNode:
+		switch n.fn {
+		case FuncGroup:
+			return env.InGroup(n.name, value)
+		case FuncList:
+			return env.InList(n.name, value)
+		case FuncCidr:
+			ip := net.ParseIP(value)
+			return ip != nil && n.ipNet.Contains(ip)
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.value)
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+// tokenize splits src into tokens.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(src) && src[end] != '"'; end++ {
+				if src[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			value, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value, pos: i})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(src) && (src[end] == '_' || unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: src[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, value: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// parser is a recursive descent parser of expressions, operators precedence from lowest to highest
+// are: "||", "&&", "!", then comparisons "==", "!=" and "in".
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOp && tok.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, got %s at offset %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary("&&", p.parseNot)
+}
+
+func (p *parser) parseBinary(op string, operand func() (node, error)) (node, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept(op) {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != typeBool || r.typ() != typeBool {
+			return nil, fmt.Errorf("operands of %q at offset %d must be booleans", op, pos)
+		}
+		l = &binaryNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	pos := p.peek().pos
+	if !p.accept("!") {
+		return p.parseComparison()
+	}
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if x.typ() != typeBool {
+		return nil, fmt.Errorf("operand of \"!\" at offset %d must be a boolean", pos)
+	}
+	return &notNode{x: x}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	switch {
+	case tok.kind == tokenOp && (tok.value == "==" || tok.value == "!="):
+		p.next()
+		r, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != typeString || r.typ() != typeString {
+			return nil, fmt.Errorf("operands of %q at offset %d must be strings", tok.value, tok.pos)
+		}
+		return &binaryNode{op: tok.value, l: l, r: r}, nil
+	case tok.kind == tokenIdent && tok.value == "in":
+		p.next()
+		r, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != typeString || r.typ() != typeSet {
+			return nil, fmt.Errorf("\"in\" at offset %d requires a string, and a list or a set", tok.pos)
+		}
+		return &inNode{value: l, set: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return &stringNode{value: tok.value}, nil
+	case tokenOp:
+		switch tok.value {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokenIdent:
+		switch tok.value {
+		case "true", "false":
+			return &boolNode{value: tok.value == "true"}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(tok)
+		}
+		if !slices.Contains(variables, tok.value) {
+			return nil, fmt.Errorf("unknown variable %q at offset %d", tok.value, tok.pos)
+		}
+		return &varNode{name: tok.value}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+// parseList parses a list of string literals, after the opening bracket.
+func (p *parser) parseList() (node, error) {
+	list := &listNode{}
+	for !p.accept("]") {
+		if len(list.values) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		tok := p.next()
+		if tok.kind != tokenString {
+			return nil, fmt.Errorf("list items must be strings, got %s at offset %d", tok, tok.pos)
+		}
+		list.values = append(list.values, tok.value)
+	}
+	return list, nil
+}
+
+// parseCall parses the call of function fn, after the opening parenthesis.
+func (p *parser) parseCall(fn token) (node, error) {
+	var args []node
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	switch fn.value {
+	case FuncMatch:
+		if len(args) != 2 || args[0].typ() != typeString || args[1].typ() != typeString {
+			return nil, fmt.Errorf("%s at offset %d requires 2 string arguments", fn.value, fn.pos)
+		}
+		if pattern, ok := args[1].(*stringNode); ok {
+			if _, err := path.Match(pattern.value, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q at offset %d", pattern.value, fn.pos)
+			}
+		}
+		return &matchNode{value: args[0], pattern: args[1]}, nil
+	case FuncGroup, FuncList, FuncCidr:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s at offset %d requires 1 string argument", fn.value, fn.pos)
+		}
+		name, ok := args[0].(*stringNode)
+		if !ok {
+			return nil, fmt.Errorf("argument of %s at offset %d must be a string literal", fn.value, fn.pos)
+		}
+		set := &setNode{fn: fn.value, name: name.value}
+		if fn.value == FuncCidr {
+			_, ipNet, err := net.ParseCIDR(name.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %q at offset %d", name.value, fn.pos)
+			}
+			set.ipNet = ipNet
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("unknown function %q at offset %d", fn.value, fn.pos)
+}
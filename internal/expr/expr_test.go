@@ -0,0 +1,116 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEnv struct {
+	vars   map[string]string
+	groups map[string][]string
+	lists  map[string][]string
+}
+
+func (e *testEnv) Var(name string) string { return e.vars[name] }
+
+func (e *testEnv) InGroup(group, value string) bool {
+	for _, v := range e.groups[group] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *testEnv) InList(list, value string) bool {
+	for _, v := range e.lists[list] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExpr_Eval(t *testing.T) {
+	env := &testEnv{
+		vars: map[string]string{
+			VarQname:    "a.telemetry.example.com",
+			VarQtype:    "A",
+			VarClient:   "192.168.1.10",
+			VarMac:      "AA:BB:CC:DD:EE:FF",
+			VarHostname: "camera",
+			VarListener: "0",
+		},
+		groups: map[string][]string{"iot": {"192.168.1.10"}},
+		lists:  map[string][]string{"ads": {"ads.example.com"}},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`qtype == "A" && client in group("iot") && match(qname, "*.telemetry.*")`, true},
+		{`qtype == "a"`, true},
+		{`qtype != "A"`, false},
+		{`qtype in ["AAAA", "HTTPS"]`, false},
+		{`qtype in ["A", "AAAA"]`, true},
+		{`client in group("guest")`, false},
+		{`client in cidr("192.168.1.0/24")`, true},
+		{`client in cidr("10.0.0.0/8")`, false},
+		{`qname in list("ads")`, false},
+		{`!(qname in list("ads"))`, true},
+		{`mac == "aa:bb:cc:dd:ee:ff" && hostname == "camera"`, true},
+		{`listener == "1" || match(hostname, "cam*")`, true},
+		{`!qtype == "A" || false`, false},
+		{`true && !false`, true},
+		{`match(qname, "*.example.org")`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := Compile(tc.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, e.Eval(env))
+			assert.Equal(t, tc.expr, e.String())
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []string{
+		``,
+		`qtype`,
+		`qtype == `,
+		`qtype = "A"`,
+		`qtype == "A" &&`,
+		`unknown == "A"`,
+		`qtype == "A`,
+		`qtype in "A"`,
+		`qtype in [qname]`,
+		`group("iot")`,
+		`client in group(qname)`,
+		`client in cidr("invalid")`,
+		`match(qname)`,
+		`match(qname, "[")`,
+		`nope(qname)`,
+		`(qtype == "A"`,
+		`qtype == "A" qname == "b"`,
+		`!qtype`,
+		`qtype == "A" && qname`,
+		`qtype == "A" # comment`,
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			_, err := Compile(src)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpr_References(t *testing.T) {
+	e, err := Compile(`client in group("iot") || client in group("guest") || client in group("iot") || qname in list("ads")`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iot", "guest"}, e.References(FuncGroup))
+	assert.Equal(t, []string{"ads"}, e.References(FuncList))
+	assert.Empty(t, e.References(FuncCidr))
+}
@@ -0,0 +1,137 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ConnectionAttemptDelay is the time to wait before starting the next connection
+// attempt, recommended by RFC 8305 section 5.
+const ConnectionAttemptDelay = 250 * time.Millisecond
+
+// SortAddrs returns the addresses with IPv6 and IPv4 addresses interleaved,
+// starting with IPv6, as described in RFC 8305 section 4.
+//
+// The addresses could be either IP or IP:port.
+func SortAddrs(addrs []string) []string {
+	var v4, v6 []string
+	for _, addr := range addrs {
+		host := addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+		if IsIPv6(host) {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	sorted := make([]string, 0, len(addrs))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+type raceResult[T any] struct {
+	conn T
+	err  error
+}
+
+// RaceDial dials the given addresses using Happy Eyeballs algorithm (RFC 8305). The addresses are
+// sorted using SortAddrs, then connection attempts are started one after another, after delay or
+// as soon as the previous attempt failed. The first established connection is returned, others
+// are closed using closeConn.
+func RaceDial[T any](ctx context.Context, addrs []string, delay time.Duration, dial func(ctx context.Context, addr string) (T, error), closeConn func(T)) (T, error) {
+	var zero T
+	if len(addrs) == 0 {
+		return zero, errors.New("empty addresses")
+	}
+	addrs = SortAddrs(addrs)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan raceResult[T], len(addrs))
+	attempt := func(addr string) {
+		conn, err := dial(ctx, addr)
+		ch <- raceResult[T]{conn: conn, err: err}
+	}
+
+	errs := make([]error, 0, len(addrs))
+	next, pending := 0, 0
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	startNext := func() {
+		go attempt(addrs[next])
+		next++
+		pending++
+		if next < len(addrs) {
+			if timer == nil {
+				timer = time.NewTimer(delay)
+			} else {
+				timer.Reset(delay)
+			}
+			timerC = timer.C
+		} else {
+			timerC = nil
+		}
+	}
+	startNext()
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for pending > 0 {
+		select {
+		case <-timerC:
+			startNext()
+		case res := <-ch:
+			pending--
+			if res.err == nil {
+				cancel()
+				// Close other connections established concurrently.
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if r := <-ch; r.err == nil {
+							closeConn(r.conn)
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			// Previous attempt failed, start the next one immediately.
+			if next < len(addrs) {
+				if timer != nil {
+					timer.Stop()
+				}
+				startNext()
+			}
+		}
+	}
+	return zero, errors.Join(errs...)
+}
+
+// HappyEyeballsDialer is like ParallelDialer, but dials the addresses using RaceDial,
+// so a broken IPv6 network does not slow down connecting to dual-stack servers.
+type HappyEyeballsDialer struct {
+	net.Dialer
+}
+
+// DialContext connects to one of the addresses on the named network.
+func (d *HappyEyeballsDialer) DialContext(ctx context.Context, network string, addrs []string) (net.Conn, error) {
+	return RaceDial(ctx, addrs, ConnectionAttemptDelay, func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}, func(conn net.Conn) {
+		conn.Close()
+	})
+}
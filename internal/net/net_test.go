@@ -22,3 +22,44 @@ func TestProbeStackTimeout(t *testing.T) {
 	case <-done:
 	}
 }
+
+func TestSortAddrs(t *testing.T) {
+	addrs := []string{"1.1.1.1:443", "1.0.0.1:443", "[2606:4700::1111]:443", "2606:4700::1001"}
+	want := []string{"[2606:4700::1111]:443", "1.1.1.1:443", "2606:4700::1001", "1.0.0.1:443"}
+	got := SortAddrs(addrs)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("unexpected result, want: %v, got: %v", want, got)
+		}
+	}
+}
+
+func TestRaceDial(t *testing.T) {
+	// The IPv6 address never connects, the IPv4 one must be used after connection attempt delay.
+	dial := func(ctx context.Context, addr string) (string, error) {
+		if IsIPv6(addr) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return addr, nil
+	}
+	start := time.Now()
+	got, err := RaceDial(context.Background(), []string{"192.0.2.1", "2001:db8::1"}, 50*time.Millisecond, dial, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "192.0.2.1" {
+		t.Errorf("unexpected address, want: 192.0.2.1, got: %s", got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time: %s", elapsed)
+	}
+
+	// All attempts failed.
+	_, err = RaceDial(context.Background(), []string{"192.0.2.1", "2001:db8::1"}, time.Second, func(ctx context.Context, addr string) (string, error) {
+		return "", context.DeadlineExceeded
+	}, func(string) {})
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,19 @@
+// Package osinfo provides information of the running OS. It wraps github.com/cuonglm/osinfo,
+// which does not support all platforms that ctrld runs on.
+package osinfo
+
+import "fmt"
+
+// UnknownRelease is the version used when the OS release could not be determined.
+const UnknownRelease = "unknown"
+
+// OSInfo contains information of running OS.
+type OSInfo struct {
+	Name    string
+	Version string
+	Dist    string
+}
+
+func (oi *OSInfo) String() string {
+	return fmt.Sprintf("%s %s", oi.Name, oi.Version)
+}
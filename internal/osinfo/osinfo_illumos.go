@@ -0,0 +1,30 @@
+package osinfo
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// New returns an instance of OSInfo.
+func New() *OSInfo {
+	oi := &OSInfo{Name: runtime.GOOS, Version: UnknownRelease}
+	// On illumos, "uname -v" prints the distribution build, e.g: "omnios-r151050-8fe4e1c6a8".
+	if out, err := exec.Command("uname", "-v").Output(); err == nil {
+		oi.Version = strings.TrimSpace(string(out))
+	}
+	// The first line of /etc/release contains the distribution name, e.g: "OmniOS v11 r151050y".
+	if f, err := os.Open("/etc/release"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				oi.Dist = line
+				break
+			}
+		}
+	}
+	return oi
+}
@@ -0,0 +1,21 @@
+package osinfo
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// New returns an instance of OSInfo.
+func New() *OSInfo {
+	oi := &OSInfo{Name: runtime.GOOS, Version: UnknownRelease}
+	// On OpenBSD, "uname -r" prints the release, e.g: "7.5", while "uname -v" prints the kernel
+	// config name and build number, e.g: "GENERIC.MP#82".
+	if out, err := exec.Command("uname", "-r").Output(); err == nil {
+		oi.Version = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("uname", "-v").Output(); err == nil {
+		oi.Dist = strings.TrimSpace(string(out))
+	}
+	return oi
+}
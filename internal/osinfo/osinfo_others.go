@@ -0,0 +1,11 @@
+//go:build !openbsd && !illumos
+
+package osinfo
+
+import "github.com/cuonglm/osinfo"
+
+// New returns an instance of OSInfo.
+func New() *OSInfo {
+	oi := osinfo.New()
+	return &OSInfo{Name: oi.Name, Version: oi.Version, Dist: oi.Dist}
+}
@@ -0,0 +1,212 @@
+package android
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+	"tailscale.com/logtail/backoff"
+
+	"github.com/Control-D-Inc/ctrld"
+)
+
+const (
+	Name = "android"
+
+	// iptablesChain is the iptables chain containing ctrld rules, in both nat and filter tables.
+	iptablesChain = "CTRLD_ANDROID"
+	// dotPort is the port used by Android Private DNS.
+	dotPort = "853"
+)
+
+// Private DNS modes, reported by "settings get global private_dns_mode".
+const (
+	PrivateDNSModeOff           = "off"
+	PrivateDNSModeOpportunistic = "opportunistic"
+	PrivateDNSModeHostname      = "hostname"
+)
+
+var errRootRequired = errors.New("redirecting DNS traffic requires root, use \"ctrld run\" and point apps to ctrld listener")
+
+// Android is the router.Router for running ctrld on Android devices, e.g: Android TV boxes.
+//
+// Android does not use /etc/resolv.conf, apps queries are sent by netd to the network nameservers,
+// so on rooted devices, DNS traffic is redirected to ctrld using iptables instead. In Termux without
+// root, ctrld could only run as a foreground process.
+type Android struct {
+	cfg *ctrld.Config
+}
+
+// New returns a router.Router for configuring/setup/run ctrld on Android devices.
+func New(cfg *ctrld.Config) *Android {
+	return &Android{cfg: cfg}
+}
+
+func (a *Android) ConfigureService(_ *service.Config) error {
+	return nil
+}
+
+func (a *Android) Install(_ *service.Config) error {
+	return nil
+}
+
+func (a *Android) Uninstall(_ *service.Config) error {
+	return nil
+}
+
+// PreRun waits until Android finished booting, since Magisk runs boot scripts before the network is up.
+// In Termux, a wake lock is acquired instead, so Android does not suspend ctrld while the device is idle.
+func (a *Android) PreRun() error {
+	if IsTermux() {
+		_ = exec.Command("termux-wake-lock").Run()
+		return nil
+	}
+	b := backoff.NewBackoff("android.PreRun", func(format string, args ...any) {}, 10*time.Second)
+	for {
+		out, err := exec.Command("getprop", "sys.boot_completed").Output()
+		if err != nil {
+			return fmt.Errorf("getprop: %w", err)
+		}
+		if string(bytes.TrimSpace(out)) == "1" {
+			return nil
+		}
+		b.BackOff(context.Background(), errors.New("boot not completed"))
+	}
+}
+
+// Setup installs iptables rules redirecting DNS traffic of the device to ctrld.
+func (a *Android) Setup() error {
+	if os.Geteuid() != 0 {
+		return errRootRequired
+	}
+	lc := a.cfg.FirstListener()
+	if lc == nil {
+		return errors.New("missing listener config")
+	}
+	blockDoT := false
+	switch mode, specifier := PrivateDNSMode(); mode {
+	case PrivateDNSModeHostname:
+		// Apps queries are sent to the Private DNS provider using DoT, they can't be redirected.
+		ctrld.ProxyLogger.Load().Warn().Msgf("Private DNS is set to %q, queries will bypass ctrld, set Private DNS to \"Off\" or \"Automatic\"", specifier)
+	case PrivateDNSModeOpportunistic:
+		// Rejecting DoT, so Android falls back to plain DNS, which is redirected to ctrld.
+		blockDoT = true
+	}
+	_ = a.Cleanup()
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		for _, args := range redirectRules(lc.IP, lc.Port, os.Geteuid(), bin == "ip6tables", blockDoT) {
+			if out, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+				// IPv6 nat table may not be available on old kernels.
+				if bin == "ip6tables" {
+					ctrld.ProxyLogger.Load().Warn().Err(err).Msgf("could not install ip6tables rules: %s", string(out))
+					break
+				}
+				return fmt.Errorf("%s %s: %s: %w", bin, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Cleanup removes iptables rules installed by Setup.
+func (a *Android) Cleanup() error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		for _, table := range []string{"nat", "filter"} {
+			if err := exec.Command(bin, "-t", table, "-n", "-L", iptablesChain).Run(); err != nil {
+				continue
+			}
+			// Delete all jumps to ctrld chain, then the chain itself.
+			for {
+				if err := exec.Command(bin, "-t", table, "-D", "OUTPUT", "-j", iptablesChain).Run(); err != nil {
+					break
+				}
+			}
+			if out, err := exec.Command(bin, "-t", table, "-F", iptablesChain).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s -F: %s: %w", bin, strings.TrimSpace(string(out)), err)
+			}
+			if out, err := exec.Command(bin, "-t", table, "-X", iptablesChain).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s -X: %s: %w", bin, strings.TrimSpace(string(out)), err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsTermux reports whether ctrld is running inside Termux.
+func IsTermux() bool {
+	return os.Getenv("TERMUX_VERSION") != "" || strings.Contains(os.Getenv("PREFIX"), "/com.termux/")
+}
+
+// ReleaseWakeLock releases Termux wake lock acquired by PreRun.
+func ReleaseWakeLock() {
+	if IsTermux() {
+		_ = exec.Command("termux-wake-unlock").Run()
+	}
+}
+
+// PrivateDNSMode returns the Private DNS mode of the device, and the provider hostname in "hostname" mode.
+func PrivateDNSMode() (mode, specifier string) {
+	out, err := exec.Command("settings", "get", "global", "private_dns_mode").Output()
+	if err != nil {
+		return "", ""
+	}
+	mode = parseSetting(out)
+	if mode == PrivateDNSModeHostname {
+		out, _ := exec.Command("settings", "get", "global", "private_dns_specifier").Output()
+		specifier = parseSetting(out)
+	}
+	// Private DNS is opportunistic by default, if the user did not change it.
+	if mode == "" {
+		mode = PrivateDNSModeOpportunistic
+	}
+	return mode, specifier
+}
+
+// parseSetting returns the value printed by "settings get", which is "null" if not set.
+func parseSetting(out []byte) string {
+	v := string(bytes.TrimSpace(out))
+	if v == "null" {
+		return ""
+	}
+	return v
+}
+
+// redirectRules returns the iptables arguments redirecting DNS traffic of the device to ctrld
+// listening on ip:port. Queries sent by uid, which is ctrld itself, are not redirected. It's
+// safe since netd sends queries on behalf of apps using sockets owned by the apps uid.
+func redirectRules(ip string, port, uid int, v6, blockDoT bool) [][]string {
+	owner := []string{"-m", "owner", "--uid-owner", strconv.Itoa(uid), "-j", "RETURN"}
+	target := []string{"-j", "REDIRECT", "--to-ports", strconv.Itoa(port)}
+	if addr := net.ParseIP(ip); !v6 && addr != nil && addr.To4() != nil && !addr.IsUnspecified() {
+		target = []string{"-j", "DNAT", "--to-destination", ip + ":" + strconv.Itoa(port)}
+	}
+	rules := [][]string{
+		{"-t", "nat", "-N", iptablesChain},
+		append([]string{"-t", "nat", "-A", iptablesChain}, owner...),
+	}
+	for _, proto := range []string{"udp", "tcp"} {
+		rules = append(rules, append([]string{"-t", "nat", "-A", iptablesChain, "-p", proto, "--dport", "53"}, target...))
+	}
+	rules = append(rules, []string{"-t", "nat", "-I", "OUTPUT", "-j", iptablesChain})
+	if !blockDoT {
+		return rules
+	}
+	rules = append(rules,
+		[]string{"-t", "filter", "-N", iptablesChain},
+		append([]string{"-t", "filter", "-A", iptablesChain}, owner...),
+		[]string{"-t", "filter", "-A", iptablesChain, "-p", "tcp", "--dport", dotPort, "-j", "REJECT", "--reject-with", "tcp-reset"},
+		[]string{"-t", "filter", "-I", "OUTPUT", "-j", iptablesChain},
+	)
+	return rules
+}
@@ -0,0 +1,60 @@
+package android
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_redirectRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		v6       bool
+		blockDoT bool
+		target   []string
+		numRules int
+	}{
+		{"loopback", "127.0.0.1", false, false, []string{"-j", "DNAT", "--to-destination", "127.0.0.1:5354"}, 5},
+		{"unspecified", "0.0.0.0", false, false, []string{"-j", "REDIRECT", "--to-ports", "5354"}, 5},
+		{"v6", "127.0.0.1", true, false, []string{"-j", "REDIRECT", "--to-ports", "5354"}, 5},
+		{"block DoT", "127.0.0.1", false, true, []string{"-j", "DNAT", "--to-destination", "127.0.0.1:5354"}, 9},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := redirectRules(tc.ip, 5354, 0, tc.v6, tc.blockDoT)
+			if len(rules) != tc.numRules {
+				t.Fatalf("unexpected number of rules, want: %d, got: %d", tc.numRules, len(rules))
+			}
+			owner := []string{"-t", "nat", "-A", iptablesChain, "-m", "owner", "--uid-owner", "0", "-j", "RETURN"}
+			if !slices.Equal(rules[1], owner) {
+				t.Errorf("ctrld queries must not be redirected, got: %v", rules[1])
+			}
+			redirect := append([]string{"-t", "nat", "-A", iptablesChain, "-p", "udp", "--dport", "53"}, tc.target...)
+			if !slices.Equal(rules[2], redirect) {
+				t.Errorf("unexpected redirect rule, want: %v, got: %v", redirect, rules[2])
+			}
+			if !slices.Equal(rules[4], []string{"-t", "nat", "-I", "OUTPUT", "-j", iptablesChain}) {
+				t.Errorf("unexpected jump rule: %v", rules[4])
+			}
+			if tc.blockDoT && !slices.Contains(rules[len(rules)-2], dotPort) {
+				t.Errorf("DoT must be rejected, got: %v", rules[len(rules)-2])
+			}
+		})
+	}
+}
+
+func Test_parseSetting(t *testing.T) {
+	tests := []struct {
+		out  string
+		want string
+	}{
+		{"hostname\n", PrivateDNSModeHostname},
+		{"null\n", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := parseSetting([]byte(tc.out)); got != tc.want {
+			t.Errorf("parseSetting(%q): want: %q, got: %q", tc.out, tc.want, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -46,6 +47,10 @@ func (or *osRouter) ConfigureService(svc *service.Config) error {
 		or.svcName,
 	}
 
+	// /etc/rc.conf.d does not exist on fresh FreeBSD installations.
+	if err := os.MkdirAll(rcConfPath, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
 	f, err := os.Create(rcFile)
 	if err != nil {
 		return fmt.Errorf("os.Create: %w", err)
@@ -77,7 +82,7 @@ func (or *osRouter) Uninstall(_ *service.Config) error {
 		rcFiles = append(rcFiles, filepath.Join(rcPath, or.svcName+".sh"))
 	}
 	for _, filename := range rcFiles {
-		if err := os.Remove(filename); err != nil {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return fmt.Errorf("os.Remove: %w", err)
 		}
 	}
@@ -130,11 +135,15 @@ func isPfsense() bool {
 	return err == nil && bytes.HasPrefix(b, []byte("pfSense"))
 }
 
+// bsdInitScript is the rc.d script of ctrld service. The service is supervised by daemon(8),
+// which restarts ctrld if it crashes. The script does not have "nojail" keyword, so it works
+// inside jails, too.
 const bsdInitScript = `#!/bin/sh
 
 # PROVIDE: {{.Name}}
 # REQUIRE: SERVERS
-# REQUIRE: unbound dnsmasq securelevel
+# REQUIRE: unbound local_unbound dnsmasq securelevel
+# BEFORE: DAEMON
 # KEYWORD: shutdown
 
 . /etc/rc.subr
@@ -147,6 +156,30 @@ child_pidfile="/var/run/${name}_child.pid"
 command="/usr/sbin/daemon"
 daemon_args="-r -P ${pidfile} -p ${child_pidfile} -t \"${name}: daemon\"{{if .WorkingDirectory}} -c {{.WorkingDirectory}}{{end}}"
 command_args="${daemon_args} {{.Path}}{{range .Arguments}} {{.}}{{end}}"
+start_precmd="${name}_prestart"
+stop_postcmd="${name}_poststop"
+extra_commands="reload"
+reload_cmd="${name}_reload"
+
+{{.Name}}_prestart()
+{
+	# Remove pidfiles left by unclean shutdown, so status is reported correctly.
+	for f in "${pidfile}" "${child_pidfile}"; do
+		if [ -f "${f}" ] && ! pgrep -F "${f}" >/dev/null 2>&1; then
+			rm -f "${f}"
+		fi
+	done
+}
+
+{{.Name}}_poststop()
+{
+	rm -f "${pidfile}" "${child_pidfile}"
+}
+
+{{.Name}}_reload()
+{
+	{{.Path}} reload
+}
 
 load_rc_config "${name}"
 run_rc_command "$1"
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync/atomic"
 
@@ -14,6 +15,7 @@ import (
 
 	"github.com/Control-D-Inc/ctrld"
 	"github.com/Control-D-Inc/ctrld/internal/certs"
+	"github.com/Control-D-Inc/ctrld/internal/router/android"
 	"github.com/Control-D-Inc/ctrld/internal/router/ddwrt"
 	"github.com/Control-D-Inc/ctrld/internal/router/dnsmasq"
 	"github.com/Control-D-Inc/ctrld/internal/router/edgeos"
@@ -70,15 +72,49 @@ func New(cfg *ctrld.Config, cdMode bool) Router {
 		return firewalla.New(cfg)
 	case netgear.Name:
 		return netgear.New(cfg)
+	case android.Name:
+		return android.New(cfg)
 	}
 	return newOsRouter(cfg, cdMode)
 }
 
+// Platforms returns names of router platforms which ctrld supports on the current OS.
+func Platforms() []string {
+	if runtime.GOOS != "linux" {
+		if osName != "" {
+			return []string{osName}
+		}
+		return nil
+	}
+	return []string{
+		ddwrt.Name,
+		merlin.Name,
+		openwrt.Name,
+		edgeos.Name,
+		ubios.Name,
+		synology.Name,
+		tomato.Name,
+		firewalla.Name,
+		netgear.Name,
+		android.Name,
+	}
+}
+
 // IsNetGearOrbi reports whether the router is a Netgear Orbi router.
 func IsNetGearOrbi() bool {
 	return Name() == netgear.Name
 }
 
+// IsAndroid reports whether ctrld is running on an Android device.
+func IsAndroid() bool {
+	return Name() == android.Name
+}
+
+// IsTermux reports whether ctrld is running inside Termux on an Android device.
+func IsTermux() bool {
+	return IsAndroid() && android.IsTermux()
+}
+
 // IsGLiNet reports whether the router is an GL.iNet router.
 func IsGLiNet() bool {
 	if Name() != openwrt.Name {
@@ -238,6 +274,9 @@ func distroName() string {
 		return edgeos.Name // For 2.x
 	case haveFile("/etc/firewalla_release"):
 		return firewalla.Name
+	case runtime.GOOS == "linux" && haveFile("/system/build.prop"):
+		// GOOS "android" is used for the mobile library, the CLI runs Linux binaries on Android.
+		return android.Name
 	}
 	return osName
 }
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"os"
 	"os/exec"
+	"runtime"
 
 	"github.com/kardianos/service"
 
+	"github.com/Control-D-Inc/ctrld/internal/router/android"
 	"github.com/Control-D-Inc/ctrld/internal/router/ddwrt"
 	"github.com/Control-D-Inc/ctrld/internal/router/merlin"
 	"github.com/Control-D-Inc/ctrld/internal/router/tomato"
@@ -62,6 +64,24 @@ func init() {
 			},
 			new: newTomatoService,
 		},
+		&linuxSystemService{
+			name:   "android",
+			detect: func() bool { return Name() == android.Name },
+			interactive: func() bool {
+				is, _ := isInteractive()
+				return is
+			},
+			new: newAndroidService,
+		},
+		&linuxSystemService{
+			name:   "openbsd-rcctl",
+			detect: func() bool { return runtime.GOOS == "openbsd" },
+			interactive: func() bool {
+				is, _ := isInteractive()
+				return is
+			},
+			new: newRcctlService,
+		},
 	}
 	systems = append(systems, service.AvailableSystems()...)
 	service.ChooseSystem(systems...)
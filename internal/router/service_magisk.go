@@ -0,0 +1,236 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/template"
+
+	"github.com/kardianos/service"
+)
+
+// androidMagiskServiceDir is the directory of Magisk boot scripts, which are run in late_start service mode.
+const androidMagiskServiceDir = "/data/adb/service.d"
+
+type androidSvc struct {
+	i        service.Interface
+	platform string
+	*service.Config
+}
+
+func newAndroidService(i service.Interface, platform string, c *service.Config) (service.Service, error) {
+	s := &androidSvc{
+		i:        i,
+		platform: platform,
+		Config:   c,
+	}
+	return s, nil
+}
+
+func (s *androidSvc) String() string {
+	if len(s.DisplayName) > 0 {
+		return s.DisplayName
+	}
+	return s.Name
+}
+
+func (s *androidSvc) Platform() string {
+	return s.platform
+}
+
+func (s *androidSvc) configPath() string {
+	return filepath.Join(androidMagiskServiceDir, s.Name+".sh")
+}
+
+func (s *androidSvc) template() *template.Template {
+	return template.Must(template.New("").Parse(androidSvcScript))
+}
+
+func (s *androidSvc) Install() error {
+	if os.Geteuid() != 0 {
+		return errors.New(`installing service requires root, on Termux, use "ctrld run" instead`)
+	}
+	if _, err := os.Stat(filepath.Dir(androidMagiskServiceDir)); err != nil {
+		return errors.New("could not install service without Magisk")
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	confPath := s.configPath()
+	if _, err := os.Stat(confPath); err == nil {
+		return fmt.Errorf("already installed: %s", confPath)
+	}
+
+	var to = &struct {
+		*service.Config
+		Path string
+	}{
+		s.Config,
+		exePath,
+	}
+
+	if err := os.MkdirAll(androidMagiskServiceDir, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	f, err := os.Create(confPath)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+	defer f.Close()
+
+	if err := s.template().Execute(f, to); err != nil {
+		return fmt.Errorf("s.template.Execute: %w", err)
+	}
+
+	if err = os.Chmod(confPath, 0755); err != nil {
+		return fmt.Errorf("os.Chmod: startup script: %w", err)
+	}
+	return nil
+}
+
+func (s *androidSvc) Uninstall() error {
+	if err := os.Remove(s.configPath()); err != nil {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	return nil
+}
+
+func (s *androidSvc) Logger(errs chan<- error) (service.Logger, error) {
+	if service.Interactive() {
+		return service.ConsoleLogger, nil
+	}
+	return s.SystemLogger(errs)
+}
+
+func (s *androidSvc) SystemLogger(errs chan<- error) (service.Logger, error) {
+	// Android does not have syslog, logs are written to ctrld log file if configured.
+	return &noopLogger{}, nil
+}
+
+func (s *androidSvc) Run() (err error) {
+	err = s.i.Start(s)
+	if err != nil {
+		return err
+	}
+
+	if interactice, _ := isInteractive(); !interactice {
+		signal.Ignore(syscall.SIGHUP)
+	}
+
+	var sigChan = make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	<-sigChan
+
+	return s.i.Stop(s)
+}
+
+func (s *androidSvc) Status() (service.Status, error) {
+	if _, err := os.Stat(s.configPath()); os.IsNotExist(err) {
+		return service.StatusUnknown, service.ErrNotInstalled
+	}
+	out, err := exec.Command(s.configPath(), "status").CombinedOutput()
+	if err != nil {
+		return service.StatusUnknown, err
+	}
+	switch string(bytes.TrimSpace(out)) {
+	case "running":
+		return service.StatusRunning, nil
+	default:
+		return service.StatusStopped, nil
+	}
+}
+
+func (s *androidSvc) Start() error {
+	return exec.Command(s.configPath(), "start").Run()
+}
+
+func (s *androidSvc) Stop() error {
+	return exec.Command(s.configPath(), "stop").Run()
+}
+
+func (s *androidSvc) Restart() error {
+	return exec.Command(s.configPath(), "restart").Run()
+}
+
+// Magisk runs the script without any arguments at boot, which starts ctrld.
+const androidSvcScript = `#!/system/bin/sh
+
+NAME="{{.Name}}"
+CMD="{{.Path}}{{range .Arguments}} {{.}}{{end}}"
+PID_FILE="/data/local/tmp/$NAME.pid"
+
+COND=$1
+[ $# -eq 0 ] && COND="start"
+
+get_pid() {
+  cat "$PID_FILE"
+}
+
+is_running() {
+  [ -f "$PID_FILE" ] && kill -0 "$(get_pid)" 2>/dev/null
+}
+
+start() {
+  if is_running; then
+    log -t "$NAME" "$NAME is already running."
+    return 0
+  fi
+  log -t "$NAME" "Starting $NAME"
+  $CMD >/dev/null 2>&1 &
+  echo $! > "$PID_FILE"
+  chmod 600 "$PID_FILE"
+  if ! is_running; then
+    log -t "$NAME" "Failed to start $NAME"
+    exit 1
+  fi
+}
+
+stop() {
+  if ! is_running; then
+    return 0
+  fi
+  log -t "$NAME" "Stopping $NAME"
+  kill "$(get_pid)"
+  for _ in 1 2 3 4 5; do
+    if ! is_running; then
+      rm -f "$PID_FILE"
+      return 0
+    fi
+    sleep 2
+  done
+  log -t "$NAME" "Failed to stop $NAME"
+  exit 1
+}
+
+case "$COND" in
+start)
+  start
+  ;;
+stop)
+  stop
+  ;;
+restart)
+  stop
+  start
+  ;;
+status)
+  if is_running; then
+    echo "running"
+  else
+    echo "stopped"
+  fi
+  ;;
+*)
+  echo "Usage: $0 {start|stop|restart|status}"
+  exit 1
+  ;;
+esac
+exit 0
+`
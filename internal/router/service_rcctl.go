@@ -0,0 +1,180 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/template"
+
+	"github.com/kardianos/service"
+)
+
+// rcctlRcPath is the directory containing OpenBSD rc.d scripts.
+const rcctlRcPath = "/etc/rc.d"
+
+// rcctlSvc is the service.Service implementation for OpenBSD, which is not supported
+// by kardianos/service. The service is managed using rc.d(8) script and rcctl(8).
+type rcctlSvc struct {
+	i        service.Interface
+	platform string
+	*service.Config
+}
+
+func newRcctlService(i service.Interface, platform string, c *service.Config) (service.Service, error) {
+	s := &rcctlSvc{
+		i:        i,
+		platform: platform,
+		Config:   c,
+	}
+	return s, nil
+}
+
+func (s *rcctlSvc) String() string {
+	if len(s.DisplayName) > 0 {
+		return s.DisplayName
+	}
+	return s.Name
+}
+
+func (s *rcctlSvc) Platform() string {
+	return s.platform
+}
+
+func (s *rcctlSvc) configPath() string {
+	return filepath.Join(rcctlRcPath, s.Config.Name)
+}
+
+func (s *rcctlSvc) template() *template.Template {
+	return template.Must(template.New("").Parse(rcctlSvcScript))
+}
+
+func (s *rcctlSvc) Install() error {
+	confPath := s.configPath()
+	if _, err := os.Stat(confPath); err == nil {
+		return fmt.Errorf("already installed: %s", confPath)
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var to = &struct {
+		*service.Config
+		Path string
+	}{
+		s.Config,
+		exePath,
+	}
+
+	f, err := os.Create(confPath)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+	defer f.Close()
+
+	if err := s.template().Execute(f, to); err != nil {
+		return fmt.Errorf("s.template.Execute: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("f.Close: %w", err)
+	}
+
+	if err = os.Chmod(confPath, 0555); err != nil {
+		return fmt.Errorf("os.Chmod: rc.d script: %w", err)
+	}
+	if out, err := exec.Command("rcctl", "enable", s.Config.Name).CombinedOutput(); err != nil {
+		return fmt.Errorf("rcctl enable: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (s *rcctlSvc) Uninstall() error {
+	_ = exec.Command("rcctl", "disable", s.Config.Name).Run()
+	if err := os.Remove(s.configPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	return nil
+}
+
+func (s *rcctlSvc) Logger(errs chan<- error) (service.Logger, error) {
+	if service.Interactive() {
+		return service.ConsoleLogger, nil
+	}
+	return s.SystemLogger(errs)
+}
+
+func (s *rcctlSvc) SystemLogger(errs chan<- error) (service.Logger, error) {
+	return newSysLogger(s.Name, errs)
+}
+
+func (s *rcctlSvc) Run() (err error) {
+	err = s.i.Start(s)
+	if err != nil {
+		return err
+	}
+
+	if interactice, _ := isInteractive(); !interactice {
+		signal.Ignore(syscall.SIGHUP)
+	}
+
+	var sigChan = make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+	<-sigChan
+
+	return s.i.Stop(s)
+}
+
+func (s *rcctlSvc) Status() (service.Status, error) {
+	if _, err := os.Stat(s.configPath()); os.IsNotExist(err) {
+		return service.StatusUnknown, service.ErrNotInstalled
+	}
+	// "rcctl check" exits with non-zero status if the daemon is not running.
+	if err := exec.Command("rcctl", "check", s.Config.Name).Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return service.StatusStopped, nil
+		}
+		return service.StatusUnknown, err
+	}
+	return service.StatusRunning, nil
+}
+
+func (s *rcctlSvc) Start() error {
+	return exec.Command("rcctl", "start", s.Config.Name).Run()
+}
+
+func (s *rcctlSvc) Stop() error {
+	return exec.Command("rcctl", "stop", s.Config.Name).Run()
+}
+
+func (s *rcctlSvc) Restart() error {
+	return exec.Command("rcctl", "restart", s.Config.Name).Run()
+}
+
+// rcctlSvcScript is the rc.d script of ctrld service on OpenBSD.
+// See: https://man.openbsd.org/rc.subr.8
+const rcctlSvcScript = `#!/bin/ksh
+#
+# {{.Description}}
+
+daemon="{{.Path}}"
+daemon_flags="{{range $i, $arg := .Arguments}}{{if $i}} {{end}}{{$arg}}{{end}}"
+{{- if .WorkingDirectory}}
+daemon_execdir="{{.WorkingDirectory}}"
+{{- end}}
+
+. /etc/rc.d/rc.subr
+
+rc_bg=YES
+
+rc_reload() {
+	${daemon} reload
+}
+
+rc_cmd $1
+`
@@ -7,7 +7,7 @@ func nameservers() []string {
 	var dns []string
 	seen := make(map[string]bool)
 	ch := make(chan []string)
-	fns := dnsFns()
+	fns := append(dnsFns(), dnsFromIPv6Announcements)
 
 	for _, fn := range fns {
 		go func(fn dnsFn) {
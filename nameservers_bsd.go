@@ -13,7 +13,7 @@ import (
 )
 
 func dnsFns() []dnsFn {
-	return []dnsFn{dnsFromRIB, dnsFromIPConfig}
+	return []dnsFn{dnsFromRIB, dnsFromIPConfig, dnsFromScutil}
 }
 
 func dnsFromRIB() []string {
@@ -61,6 +61,32 @@ func dnsFromIPConfig() []string {
 	return nil
 }
 
+// dnsFromScutil returns nameservers of both global and per-interface resolvers of macOS DNS configuration.
+func dnsFromScutil() []string {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+	return scutilNameservers(scutilResolvers())
+}
+
+// supplementalResolvers returns resolvers of macOS DNS configuration which have match domains,
+// e.g: the ones pushed by VPN clients.
+func supplementalResolvers() []supplementalResolver {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+	return scutilSupplementalResolvers(scutilResolvers())
+}
+
+// scutilResolvers returns resolvers reported by "scutil --dns".
+func scutilResolvers() []scutilResolver {
+	out, err := exec.Command("scutil", "--dns").Output()
+	if err != nil {
+		return nil
+	}
+	return parseScutilDNS(out)
+}
+
 func toNetIP(addr route.Addr) net.IP {
 	switch t := addr.(type) {
 	case *route.Inet4Addr:
@@ -0,0 +1,52 @@
+package ctrld
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+)
+
+func dnsFns() []dnsFn {
+	return []dnsFn{dnsFromDHCP, dnsFromSMF}
+}
+
+// dnsFromDHCP returns DNS servers received from DHCP on all interfaces.
+// See: https://illumos.org/man/1/dhcpinfo
+func dnsFromDHCP() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var dns []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		out, err := exec.Command("/sbin/dhcpinfo", "-i", iface.Name, "DNSserv").Output()
+		if err != nil {
+			continue
+		}
+		dns = append(dns, parseIPList(string(out))...)
+	}
+	return dns
+}
+
+// dnsFromSMF returns DNS servers configured in the network/dns/client SMF service.
+func dnsFromSMF() []string {
+	out, err := exec.Command("/usr/bin/svcprop", "-p", "config/nameserver", "network/dns/client").Output()
+	if err != nil {
+		return nil
+	}
+	return parseIPList(string(out))
+}
+
+// parseIPList returns valid IP addresses in space separated list s.
+func parseIPList(s string) []string {
+	var ips []string
+	for _, field := range strings.Fields(s) {
+		if ip := net.ParseIP(field); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
@@ -0,0 +1,50 @@
+package ctrld
+
+import (
+	"sync"
+	"time"
+)
+
+// ipv6Announced holds IPv6 DNS servers announced by the network through Router Advertisement RDNSS
+// option (RFC 8106), which are not always visible in system settings, with their expiration time.
+var ipv6Announced = struct {
+	sync.Mutex
+	expiry map[string]time.Time
+}{expiry: make(map[string]time.Time)}
+
+// AddIPv6Nameservers records IPv6 DNS servers announced by the network, valid for lifetime.
+// A zero lifetime removes them. It reports whether the set of announced servers was changed.
+func AddIPv6Nameservers(servers []string, lifetime time.Duration) bool {
+	ipv6Announced.Lock()
+	defer ipv6Announced.Unlock()
+	now := time.Now()
+	changed := false
+	for _, ns := range servers {
+		exp, ok := ipv6Announced.expiry[ns]
+		known := ok && now.Before(exp)
+		if lifetime <= 0 {
+			delete(ipv6Announced.expiry, ns)
+			changed = changed || known
+			continue
+		}
+		ipv6Announced.expiry[ns] = now.Add(lifetime)
+		changed = changed || !known
+	}
+	return changed
+}
+
+// dnsFromIPv6Announcements returns unexpired IPv6 DNS servers announced by the network.
+func dnsFromIPv6Announcements() []string {
+	ipv6Announced.Lock()
+	defer ipv6Announced.Unlock()
+	now := time.Now()
+	var dns []string
+	for ns, exp := range ipv6Announced.expiry {
+		if !now.Before(exp) {
+			delete(ipv6Announced.expiry, ns)
+			continue
+		}
+		dns = append(dns, ns)
+	}
+	return dns
+}
@@ -0,0 +1,35 @@
+package ctrld
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAddIPv6Nameservers(t *testing.T) {
+	t.Cleanup(func() { AddIPv6Nameservers(dnsFromIPv6Announcements(), 0) })
+
+	servers := []string{"2001:db8::53", "fe80::1%eth0"}
+	if !AddIPv6Nameservers(servers, time.Hour) {
+		t.Fatal("new nameservers must change the announced set")
+	}
+	if AddIPv6Nameservers(servers, time.Hour) {
+		t.Fatal("known nameservers must not change the announced set")
+	}
+	got := dnsFromIPv6Announcements()
+	slices.Sort(got)
+	if !slices.Equal(got, servers) {
+		t.Fatalf("unexpected nameservers, want: %v, got: %v", servers, got)
+	}
+	if !AddIPv6Nameservers(servers[:1], 0) {
+		t.Fatal("removing nameservers must change the announced set")
+	}
+	if got := dnsFromIPv6Announcements(); !slices.Equal(got, servers[1:]) {
+		t.Fatalf("unexpected nameservers, want: %v, got: %v", servers[1:], got)
+	}
+	AddIPv6Nameservers(servers[1:], time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if got := dnsFromIPv6Announcements(); len(got) != 0 {
+		t.Fatalf("expired nameservers must be removed, got: %v", got)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/Control-D-Inc/ctrld/internal/dns/resolvconffile"
@@ -16,8 +20,15 @@ const (
 	v6RouteFile = "/proc/net/ipv6_route"
 )
 
+// dhclient6LeasesPatterns are patterns of DHCPv6 leases files written by ISC dhclient.
+var dhclient6LeasesPatterns = []string{
+	"/var/lib/dhcp/dhclient6*.leases",
+	"/var/lib/dhclient/dhclient6*.leases",
+	"/var/lib/NetworkManager/dhclient6-*.lease",
+}
+
 func dnsFns() []dnsFn {
-	return []dnsFn{dns4, dns6, dnsFromSystemdResolver}
+	return []dnsFn{dns4, dns6, dnsFromSystemdResolver, dnsFromDHCPv6Leases, dnsFromAndroidProperties}
 }
 
 func dns4() []string {
@@ -106,6 +117,63 @@ func dnsFromSystemdResolver() []string {
 	return ns
 }
 
+// dnsFromAndroidProperties returns DNS servers from "net.dns*" system properties, which are set by
+// older Android versions, since Android does not use /etc/resolv.conf.
+func dnsFromAndroidProperties() []string {
+	if _, err := os.Stat("/system/build.prop"); err != nil {
+		return nil
+	}
+	var dns []string
+	for i := 1; i <= 4; i++ {
+		out, err := exec.Command("getprop", fmt.Sprintf("net.dns%d", i)).Output()
+		if err != nil {
+			return dns
+		}
+		if ip := net.ParseIP(string(bytes.TrimSpace(out))); ip != nil {
+			dns = append(dns, ip.String())
+		}
+	}
+	return dns
+}
+
+// dnsFromDHCPv6Leases returns DNS servers received from DHCPv6 servers, using leases files of dhclient.
+func dnsFromDHCPv6Leases() []string {
+	var dns []string
+	for _, pattern := range dhclient6LeasesPatterns {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			dns = append(dns, parseDhclient6Leases(f)...)
+			f.Close()
+		}
+	}
+	return dns
+}
+
+// parseDhclient6Leases returns DNS servers of the latest lease in dhclient leases file content.
+func parseDhclient6Leases(r io.Reader) []string {
+	var dns []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		value, ok := strings.CutPrefix(line, "option dhcp6.name-servers ")
+		if !ok {
+			continue
+		}
+		// Leases are appended to the file, so the last one wins.
+		dns = dns[:0]
+		for _, ns := range strings.Split(strings.TrimSuffix(value, ";"), ",") {
+			if ip := net.ParseIP(strings.TrimSpace(ns)); ip != nil {
+				dns = append(dns, ip.String())
+			}
+		}
+	}
+	return dns
+}
+
 type set map[string]struct{}
 
 func (s *set) add(e string) {
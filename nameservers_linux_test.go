@@ -1,6 +1,8 @@
 package ctrld
 
 import (
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -8,3 +10,30 @@ func Test_virtualInterfaces(t *testing.T) {
 	vis := virtualInterfaces()
 	t.Log(vis)
 }
+
+func Test_parseDhclient6Leases(t *testing.T) {
+	leases := `default-duid "\000\001\000\001";
+lease6 {
+  interface "eth0";
+  ia-na 1e:4b:7c:35 {
+    starts 1700000000;
+    iaaddr 2001:db8::100 {
+      starts 1700000000;
+      preferred-life 7200;
+      max-life 7200;
+    }
+  }
+  option dhcp6.name-servers 2001:db8::1;
+}
+lease6 {
+  interface "eth0";
+  option dhcp6.client-id 0:1:0:1;
+  option dhcp6.name-servers 2001:db8::53,2001:db8::54;
+}
+`
+	got := parseDhclient6Leases(strings.NewReader(leases))
+	want := []string{"2001:db8::53", "2001:db8::54"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected nameservers, want: %v, got: %v", want, got)
+	}
+}
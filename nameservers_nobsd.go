@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package ctrld
+
+// supplementalResolvers returns nil, supplemental resolvers are only reported by macOS.
+func supplementalResolvers() []supplementalResolver {
+	return nil
+}
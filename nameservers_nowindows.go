@@ -0,0 +1,8 @@
+//go:build !windows
+
+package ctrld
+
+// dohTemplates returns nil, DoH templates of nameservers are only configured by Windows.
+func dohTemplates() map[string]string {
+	return nil
+}
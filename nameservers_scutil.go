@@ -0,0 +1,103 @@
+package ctrld
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// scutilResolver is a resolver of macOS DNS configuration, reported by "scutil --dns".
+type scutilResolver struct {
+	domain      string
+	nameservers []string
+	iface       string
+	scoped      bool
+	mdns        bool
+}
+
+// supplementalResolver is the resolver used for queries of domain, instead of the default nameservers,
+// e.g: the resolver pushed by VPN clients for the domain of the corporate network.
+type supplementalResolver struct {
+	domain      string
+	nameservers []string
+}
+
+// parseScutilDNS parses the output of "scutil --dns", returning both the resolvers of global
+// DNS configuration, and of scoped queries, which are the per-interface resolvers.
+func parseScutilDNS(out []byte) []scutilResolver {
+	var (
+		resolvers []scutilResolver
+		cur       *scutilResolver
+		scoped    bool
+	)
+	flush := func() {
+		if cur != nil {
+			resolvers = append(resolvers, *cur)
+			cur = nil
+		}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "DNS configuration"):
+			flush()
+			scoped = strings.Contains(line, "scoped")
+			continue
+		case strings.HasPrefix(line, "resolver #"):
+			flush()
+			cur = &scutilResolver{scoped: scoped}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case key == "domain":
+			cur.domain = strings.TrimSuffix(strings.ToLower(value), ".")
+		case strings.HasPrefix(key, "nameserver["):
+			cur.nameservers = append(cur.nameservers, value)
+		case key == "if_index":
+			// e.g: "if_index : 20 (utun3)"
+			if _, name, ok := strings.Cut(value, "("); ok {
+				cur.iface = strings.TrimSuffix(name, ")")
+			}
+		case key == "options":
+			cur.mdns = strings.Contains(value, "mdns")
+		}
+	}
+	flush()
+	return resolvers
+}
+
+// scutilNameservers returns nameservers of resolvers which are not specific to any domains,
+// including the scoped ones, so nameservers of all interfaces are known, not only the primary one.
+func scutilNameservers(resolvers []scutilResolver) []string {
+	var nss []string
+	for _, r := range resolvers {
+		if r.domain != "" || r.mdns {
+			continue
+		}
+		nss = append(nss, r.nameservers...)
+	}
+	return nss
+}
+
+// scutilSupplementalResolvers returns resolvers which are used for queries of their match domains.
+func scutilSupplementalResolvers(resolvers []scutilResolver) []supplementalResolver {
+	var res []supplementalResolver
+	seen := make(map[string]bool)
+	for _, r := range resolvers {
+		if r.domain == "" || r.mdns || len(r.nameservers) == 0 || r.scoped || seen[r.domain] {
+			continue
+		}
+		seen[r.domain] = true
+		res = append(res, supplementalResolver{domain: r.domain, nameservers: r.nameservers})
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package ctrld
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const scutilDNSOutput = `DNS configuration
+
+resolver #1
+  nameserver[0] : 192.168.1.1
+  nameserver[1] : 2001:db8::1
+  if_index : 14 (en0)
+  flags    : Request A records, Request AAAA records
+  reach    : 0x00020002 (Reachable,Directly Reachable Address)
+
+resolver #2
+  domain   : local
+  options  : mdns
+  timeout  : 5
+  flags    : Request A records, Request AAAA records
+  reach    : 0x00000000 (Not Reachable)
+  order    : 300000
+
+resolver #3
+  domain   : corp.example.
+  nameserver[0] : 10.8.0.1
+  if_index : 20 (utun3)
+  flags    : Supplemental, Request A records
+  reach    : 0x00000003 (Reachable,Transient Connection)
+  order    : 102400
+
+DNS configuration (for scoped queries)
+
+resolver #1
+  nameserver[0] : 192.168.1.1
+  if_index : 14 (en0)
+  flags    : Scoped, Request A records
+  reach    : 0x00020002 (Reachable,Directly Reachable Address)
+
+resolver #2
+  nameserver[0] : 10.8.0.1
+  if_index : 20 (utun3)
+  flags    : Scoped, Request A records
+  reach    : 0x00000003 (Reachable,Transient Connection)
+
+resolver #3
+  domain   : lab.example
+  nameserver[0] : 10.9.0.1
+  if_index : 21 (utun4)
+  flags    : Scoped, Request A records
+  reach    : 0x00000003 (Reachable,Transient Connection)
+`
+
+func Test_parseScutilDNS(t *testing.T) {
+	resolvers := parseScutilDNS([]byte(scutilDNSOutput))
+	if !assert.Len(t, resolvers, 6) {
+		return
+	}
+	assert.Equal(t, scutilResolver{nameservers: []string{"192.168.1.1", "2001:db8::1"}, iface: "en0"}, resolvers[0])
+	assert.True(t, resolvers[1].mdns)
+	assert.Equal(t, scutilResolver{domain: "corp.example", nameservers: []string{"10.8.0.1"}, iface: "utun3"}, resolvers[2])
+	assert.Equal(t, scutilResolver{nameservers: []string{"10.8.0.1"}, iface: "utun3", scoped: true}, resolvers[4])
+
+	assert.Equal(t, []string{"192.168.1.1", "2001:db8::1", "192.168.1.1", "10.8.0.1"}, scutilNameservers(resolvers))
+	assert.Equal(t, []supplementalResolver{{domain: "corp.example", nameservers: []string{"10.8.0.1"}}}, scutilSupplementalResolvers(resolvers))
+}
@@ -3,9 +3,18 @@ package ctrld
 import (
 	"syscall"
 
+	"golang.org/x/sys/windows/registry"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
+const (
+	dohInterfaceSettingsKeyPath = `SYSTEM\CurrentControlSet\Services\Dnscache\InterfaceSpecificParameters`
+	dohWellKnownServersKeyPath  = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DohWellKnownServers`
+	dnsClientPolicyKeyPath      = `SOFTWARE\Policies\Microsoft\Windows NT\DNSClient`
+	// dohPolicyRequire is the "DoHPolicy" value which requires DoH for all name resolution.
+	dohPolicyRequire = 3
+)
+
 func dnsFns() []dnsFn {
 	return []dnsFn{dnsFromAdapter}
 }
@@ -36,9 +45,95 @@ func dnsFromAdapter() []string {
 			ns = append(ns, ip.String())
 		}
 	}
+	// All DNS servers are the machine itself, e.g: adapters DNS were pointed at ctrld,
+	// re-deriving the network nameservers using the adapters gateways.
+	if len(ns) == 0 {
+		for _, aa := range aas {
+			if aa.OperStatus != winipcfg.IfOperStatusUp {
+				continue
+			}
+			for gw := aa.FirstGatewayAddress; gw != nil; gw = gw.Next {
+				ip := gw.Address.IP()
+				if ip == nil || ip.IsUnspecified() || seen[ip.String()] {
+					continue
+				}
+				seen[ip.String()] = true
+				ns = append(ns, ip.String())
+			}
+		}
+	}
 	return ns
 }
 
 func nameserversFromResolvconf() []string {
 	return nil
 }
+
+// dohTemplates returns DoH templates configured for nameservers of all network adapters, keyed by nameserver IP.
+func dohTemplates() map[string]string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, dohInterfaceSettingsKeyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+	guids, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil
+	}
+	templates := make(map[string]string)
+	for _, guid := range guids {
+		for ip, template := range InterfaceDohTemplates(guid) {
+			templates[ip] = template
+		}
+	}
+	return templates
+}
+
+// InterfaceDohTemplates returns DoH templates configured for nameservers of the network adapter
+// with given GUID, keyed by nameserver IP. If the adapter uses the automatic template, the template
+// of Windows well known DoH servers is used.
+func InterfaceDohTemplates(guid string) map[string]string {
+	templates := make(map[string]string)
+	for _, family := range []string{"Doh", "Doh6"} {
+		path := dohInterfaceSettingsKeyPath + `\` + guid + `\DohInterfaceSettings\` + family
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		ips, _ := k.ReadSubKeyNames(-1)
+		k.Close()
+		for _, ip := range ips {
+			template := registryStringValue(path+`\`+ip, "DohTemplate")
+			if template == "" {
+				template = registryStringValue(dohWellKnownServersKeyPath+`\`+ip, "Template")
+			}
+			if template != "" {
+				templates[ip] = template
+			}
+		}
+	}
+	return templates
+}
+
+// SystemDohRequired reports whether the DNS client policy requires DoH for all name resolution,
+// in which case Windows does not send plain DNS queries to ctrld listener.
+func SystemDohRequired() bool {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, dnsClientPolicyKeyPath, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer k.Close()
+	v, _, err := k.GetIntegerValue("DoHPolicy")
+	return err == nil && v == dohPolicyRequire
+}
+
+// registryStringValue returns the string value name of registry key path, or empty string if not found.
+func registryStringValue(path, name string) string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer k.Close()
+	v, _, _ := k.GetStringValue(name)
+	return v
+}
@@ -32,6 +32,8 @@ const (
 	ResolverTypeLegacy = "legacy"
 	// ResolverTypePrivate is like ResolverTypeOS, but use for local resolver only.
 	ResolverTypePrivate = "private"
+	// ResolverTypeBypass is like ResolverTypeOS, but use only nameservers provided by the network.
+	ResolverTypeBypass = "bypass"
 	// ResolverTypeSDNS specifies resolver with information encoded using DNS Stamps.
 	// See: https://dnscrypt.info/stamps-specifications/
 	ResolverTypeSDNS = "sdns"
@@ -47,9 +49,9 @@ var controldPublicDnsWithPort = net.JoinHostPort(controldPublicDns, "53")
 // or is the Resolver used for ResolverTypeOS.
 var or = newResolverWithNameserver(defaultNameservers())
 
-// defaultNameservers is like nameservers with each element formed "ip:53".
+// defaultNameservers is like networkNameservers with each element formed "ip:53".
 func defaultNameservers() []string {
-	ns := nameservers()
+	ns := networkNameservers()
 	nss := make([]string, len(ns))
 	for i := range ns {
 		nss[i] = net.JoinHostPort(ns[i], "53")
@@ -58,23 +60,56 @@ func defaultNameservers() []string {
 }
 
 // availableNameservers returns list of current available DNS servers of the system.
-func availableNameservers() []string {
+// Nameservers which have DoH templates are always available, since they may not answer plain DNS.
+func availableNameservers(dohTemplates map[string]string) []string {
 	var nss []string
-	// Ignore local addresses to prevent loop.
-	regularIPs, loopbackIPs, _ := netmon.LocalAddresses()
-	machineIPsMap := make(map[string]struct{}, len(regularIPs))
-	for _, v := range slices.Concat(regularIPs, loopbackIPs) {
-		machineIPsMap[v.String()] = struct{}{}
+	for _, ns := range networkNameservers() {
+		if _, ok := dohTemplates[ns]; ok || testNameserver(ns) {
+			nss = append(nss, ns)
+		}
 	}
-	for _, ns := range nameservers() {
-		if _, ok := machineIPsMap[ns]; ok {
+	return nss
+}
+
+// networkNameservers returns list of DNS servers of the system, excluding local addresses,
+// which are likely ctrld itself. The remaining ones are DNS servers provided by the network.
+func networkNameservers() []string {
+	regularIPs, loopbackIPs, _ := netmon.LocalAddresses()
+	return withoutSelfNameservers(nameservers(), slices.Concat(regularIPs, loopbackIPs))
+}
+
+// withoutSelfNameservers returns nss without nameservers which are the machine itself.
+//
+// When the system DNS was pointed at ctrld, the system may report ctrld listener address
+// as a nameserver. Using it for resolving queries that ctrld sends to the OS resolver
+// would deadlock, so any loopback, unspecified or local addresses are ignored.
+func withoutSelfNameservers(nss []string, localAddrs []netip.Addr) []string {
+	machineIPsMap := make(map[netip.Addr]struct{}, len(localAddrs))
+	for _, v := range localAddrs {
+		machineIPsMap[v.Unmap()] = struct{}{}
+	}
+	res := make([]string, 0, len(nss))
+	for _, ns := range nss {
+		addr, err := netip.ParseAddr(ns)
+		if err != nil {
 			continue
 		}
-		if testNameserver(ns) {
-			nss = append(nss, ns)
+		addr = addr.Unmap()
+		if addr.IsLoopback() || addr.IsUnspecified() {
+			continue
+		}
+		if _, ok := machineIPsMap[addr]; ok {
+			continue
 		}
+		res = append(res, ns)
 	}
-	return nss
+	return res
+}
+
+// OutboundNameservers returns the DNS servers which ctrld itself may send plain DNS queries to,
+// which are Control D bootstrap DNS servers, and DNS servers provided by the network.
+func OutboundNameservers() []string {
+	return append([]string{controldBootstrapDns, controldPublicDns}, networkNameservers()...)
 }
 
 // InitializeOsResolver initializes OS resolver using the current system DNS settings.
@@ -83,7 +118,45 @@ func availableNameservers() []string {
 // It's the caller's responsibility to ensure the system DNS is in a clean state before
 // calling this function.
 func InitializeOsResolver() []string {
-	return initializeOsResolver(availableNameservers())
+	regularIPs, loopbackIPs, _ := netmon.LocalAddresses()
+	localAddrs := slices.Concat(regularIPs, loopbackIPs)
+	var srs []supplementalResolver
+	for _, sr := range supplementalResolvers() {
+		nss := withoutSelfNameservers(sr.nameservers, localAddrs)
+		if len(nss) == 0 {
+			continue
+		}
+		sr.nameservers = make([]string, 0, len(nss))
+		for _, ns := range nss {
+			sr.nameservers = append(sr.nameservers, net.JoinHostPort(ns, "53"))
+		}
+		srs = append(srs, sr)
+	}
+	or.supplemental.Store(&srs)
+	templates := dohTemplates()
+	dohResolvers := newOsDohResolvers(templates)
+	or.doh.Store(&dohResolvers)
+	return initializeOsResolver(availableNameservers(templates))
+}
+
+// newOsDohResolvers returns DoH resolvers for nameservers using given DoH templates, keyed by nameserver address.
+func newOsDohResolvers(templates map[string]string) map[string]Resolver {
+	resolvers := make(map[string]Resolver, len(templates))
+	for ip, template := range templates {
+		uc := &UpstreamConfig{
+			Name: "OS DoH",
+			Type: ResolverTypeDOH,
+			// Windows templates may be in RFC 8484 URI template form.
+			Endpoint:    strings.TrimSuffix(template, "{?dns}"),
+			BootstrapIP: ip,
+		}
+		uc.Init()
+		if uc.u == nil {
+			continue
+		}
+		resolvers[net.JoinHostPort(ip, "53")] = newDohResolver(uc)
+	}
+	return resolvers
 }
 func initializeOsResolver(servers []string) []string {
 	var (
@@ -141,6 +214,51 @@ func initializeOsResolver(servers []string) []string {
 	return nss
 }
 
+// supplementalNameservers returns nameservers of the supplemental resolver which has the longest
+// match domain of msg question, or nil if there's no such resolver.
+func (o *osResolver) supplementalNameservers(msg *dns.Msg) []string {
+	p := o.supplemental.Load()
+	if p == nil || len(msg.Question) == 0 {
+		return nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(msg.Question[0].Name), ".")
+	var (
+		nss   []string
+		match string
+	)
+	for _, sr := range *p {
+		if (name == sr.domain || strings.HasSuffix(name, "."+sr.domain)) && len(sr.domain) > len(match) {
+			match, nss = sr.domain, sr.nameservers
+		}
+	}
+	return nss
+}
+
+// resolveSupplemental resolves msg using nameservers of a supplemental resolver concurrently,
+// returning the first answer.
+func (o *osResolver) resolveSupplemental(ctx context.Context, msg *dns.Msg, nss []string) (*dns.Msg, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dnsClient := &dns.Client{Net: "udp"}
+	ch := make(chan *osResolverResult, len(nss))
+	for _, ns := range nss {
+		go func(server string) {
+			answer, _, err := dnsClient.ExchangeContext(ctx, msg.Copy(), server)
+			ch <- &osResolverResult{answer: answer, err: err, server: server}
+		}(ns)
+	}
+	errs := make([]error, 0, len(nss))
+	for range nss {
+		res := <-ch
+		if res.err == nil && res.answer != nil {
+			Log(ctx, ProxyLogger.Load().Debug(), "got answer from supplemental nameserver: %s", res.server)
+			return res.answer, nil
+		}
+		errs = append(errs, res.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
 // testPlainDnsNameserver sends a test query to DNS nameserver to check if the server is available.
 func testNameserver(addr string) bool {
 	msg := new(dns.Msg)
@@ -180,6 +298,8 @@ func NewResolver(uc *UpstreamConfig) (Resolver, error) {
 		return &legacyResolver{uc: uc}, nil
 	case ResolverTypePrivate:
 		return NewPrivateResolver(), nil
+	case ResolverTypeBypass:
+		return br, nil
 	}
 	return nil, fmt.Errorf("%w: %s", errUnknownResolver, typ)
 }
@@ -188,6 +308,9 @@ type osResolver struct {
 	currentLanServer atomic.Pointer[netip.Addr]
 	lastLanServer    atomic.Pointer[netip.Addr]
 	publicServer     atomic.Pointer[[]string]
+	supplemental     atomic.Pointer[[]supplementalResolver]
+	// doh holds resolvers of nameservers which the system configured DoH templates for, keyed by nameserver address.
+	doh atomic.Pointer[map[string]Resolver]
 }
 
 type osResolverResult struct {
@@ -201,6 +324,9 @@ type osResolverResult struct {
 // Query is sent to all nameservers concurrently, and the first
 // success response will be returned.
 func (o *osResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if nss := o.supplementalNameservers(msg); len(nss) > 0 {
+		return o.resolveSupplemental(ctx, msg, nss)
+	}
 	publicServers := *o.publicServer.Load()
 	nss := make([]string, 0, 2)
 	if p := o.currentLanServer.Load(); p != nil {
@@ -229,7 +355,7 @@ func (o *osResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error
 		for _, server := range servers {
 			go func(server string) {
 				defer wg.Done()
-				answer, _, err := dnsClient.ExchangeContext(ctx, msg.Copy(), server)
+				answer, err := o.exchange(ctx, dnsClient, msg, server)
 				ch <- &osResolverResult{answer: answer, err: err, server: server, lan: isLan}
 			}(server)
 		}
@@ -286,6 +412,55 @@ func (o *osResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error
 	return nil, errors.Join(errs...)
 }
 
+// exchange sends msg to server, using DoH if the system configured a DoH template for server,
+// then falling back to plain DNS if the DoH query failed.
+func (o *osResolver) exchange(ctx context.Context, dnsClient *dns.Client, msg *dns.Msg, server string) (*dns.Msg, error) {
+	if p := o.doh.Load(); p != nil {
+		if r := (*p)[server]; r != nil {
+			answer, err := r.Resolve(ctx, msg.Copy())
+			if err == nil {
+				return answer, nil
+			}
+			Log(ctx, ProxyLogger.Load().Debug().Err(err), "DoH query to nameserver %s failed, falling back to plain DNS", server)
+		}
+	}
+	answer, _, err := dnsClient.ExchangeContext(ctx, msg.Copy(), server)
+	return answer, err
+}
+
+// bypassNameserversTTL is the duration which network nameservers are re-used by bypass resolver,
+// before being detected again, so changes of network are followed.
+const bypassNameserversTTL = 30 * time.Second
+
+// br is the Resolver used for ResolverTypeBypass.
+var br = &bypassResolver{}
+
+// bypassResolver resolves DNS queries using nameservers provided by the network (DHCP/RA),
+// without falling back to any public nameservers.
+type bypassResolver struct {
+	mu       sync.Mutex
+	resolver *osResolver
+	expire   time.Time
+}
+
+// Resolve resolves DNS queries using current network nameservers.
+func (r *bypassResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	r.mu.Lock()
+	if r.resolver == nil || time.Now().After(r.expire) {
+		ns := networkNameservers()
+		nss := make([]string, len(ns))
+		for i := range ns {
+			nss[i] = net.JoinHostPort(ns[i], "53")
+		}
+		Log(ctx, ProxyLogger.Load().Debug(), "using network nameservers: %v", nss)
+		r.resolver = newResolverWithNameserver(nss)
+		r.expire = time.Now().Add(bypassNameserversTTL)
+	}
+	resolver := r.resolver
+	r.mu.Unlock()
+	return resolver.Resolve(ctx, msg)
+}
+
 type legacyResolver struct {
 	uc *UpstreamConfig
 }
@@ -309,8 +484,29 @@ func (r *legacyResolver) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, e
 		endpoint = net.JoinHostPort(r.uc.BootstrapIP, port)
 	}
 
-	answer, _, err := dnsClient.ExchangeContext(ctx, msg, endpoint)
-	return answer, err
+	msg = r.uc.ednsRequest(msg)
+	// Leave half of the time budget for retrying over TCP.
+	udpCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		udpCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+		defer cancel()
+	}
+	answer, _, err := dnsClient.ExchangeContext(udpCtx, msg, endpoint)
+	var netErr net.Error
+	switch {
+	case err == nil && answer.Truncated:
+		Log(ctx, ProxyLogger.Load().Debug(), "truncated answer from %s, retrying over TCP", r.uc.Endpoint)
+	case errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil:
+		// The answer may be lost because of fragmentation, advertise smaller buffer size
+		// for next queries, and retry this query over TCP, following DNS Flag Day 2020.
+		Log(ctx, ProxyLogger.Load().Debug(), "UDP query to %s timed out, retrying over TCP", r.uc.Endpoint)
+		r.uc.downgradeEDNS()
+	default:
+		return answer, err
+	}
+	dnsClient.Net = strings.Replace(dnsClient.Net, "udp", "tcp", 1)
+	return r.uc.dnsConns.exchange(ctx, dnsClient, msg, endpoint, durationOrDefault(r.uc.IdleConnTimeout, defaultIdleConnTimeout))
 }
 
 type dummyResolver struct{}
@@ -2,9 +2,12 @@ package ctrld
 
 import (
 	"context"
+	"errors"
 	"net"
+	"net/netip"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -191,3 +194,267 @@ func Test_initializeOsResolver(t *testing.T) {
 	assert.Nil(t, or.lastLanServer.Load())
 	assert.True(t, slices.Equal(*or.publicServer.Load(), publicServers))
 }
+
+func Test_withoutSelfNameservers(t *testing.T) {
+	localAddrs := []netip.Addr{netip.MustParseAddr("192.168.1.10"), netip.MustParseAddr("127.0.0.1")}
+	nss := []string{"127.0.0.1", "127.0.0.2", "::1", "0.0.0.0", "192.168.1.10", "192.168.1.1", "2001:db8::1", "invalid"}
+	got := withoutSelfNameservers(nss, localAddrs)
+	assert.Equal(t, []string{"192.168.1.1", "2001:db8::1"}, got)
+}
+
+func Test_bypassResolver_Resolve(t *testing.T) {
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, addr, err := runLocalPacketConnTestServer(t, pc, dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(msg, dns.RcodeSuccess)
+		w.WriteMsg(m)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Shutdown()
+
+	// Network nameservers are re-used until expired.
+	resolver := &osResolver{}
+	resolver.publicServer.Store(&[]string{addr})
+	r := &bypassResolver{resolver: resolver, expire: time.Now().Add(time.Minute)}
+	msg := new(dns.Msg)
+	msg.SetQuestion("printer.lan.", dns.TypeA)
+	answer, err := r.Resolve(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Errorf("unexpected return code: %s", dns.RcodeToString[answer.Rcode])
+	}
+}
+
+func runLocalListenerTestServer(t *testing.T, ln net.Listener, handler dns.Handler) *dns.Server {
+	t.Helper()
+
+	server := &dns.Server{Listener: ln, Handler: handler}
+	waitLock := sync.Mutex{}
+	waitLock.Lock()
+	server.NotifyStartedFunc = waitLock.Unlock
+	go func() {
+		if err := server.ActivateAndServe(); err != nil {
+			t.Error(err)
+		}
+		ln.Close()
+	}()
+	waitLock.Lock()
+	return server
+}
+
+func Test_legacyResolver_RetryOverTCP(t *testing.T) {
+	tcpHandler := dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(msg)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("1.2.3.4"),
+		})
+		w.WriteMsg(m)
+	})
+	var udpSize atomic.Uint32
+	truncatedHandler := dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		if opt := msg.IsEdns0(); opt != nil {
+			udpSize.Store(uint32(opt.UDPSize()))
+		}
+		m := new(dns.Msg)
+		m.SetReply(msg)
+		m.Truncated = true
+		w.WriteMsg(m)
+	})
+
+	bufferSize := 4096
+	tests := []struct {
+		name          string
+		udpHandler    dns.Handler
+		bufferSize    *int
+		wantSize      uint32
+		wantDowngrade bool
+	}{
+		{"truncated", truncatedHandler, nil, DefaultEDNSBufferSize, false},
+		{"truncated custom buffer size", truncatedHandler, &bufferSize, 4096, false},
+		{"timeout", nil, nil, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer pc.Close()
+			ln, err := net.Listen("tcp", pc.LocalAddr().String())
+			if err != nil {
+				t.Skipf("could not listen tcp on the same port: %v", err)
+			}
+			// Without UDP handler, the UDP query is never answered.
+			if tc.udpHandler != nil {
+				s, _, err := runLocalPacketConnTestServer(t, pc, tc.udpHandler)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				defer s.Shutdown()
+			}
+			s := runLocalListenerTestServer(t, ln, tcpHandler)
+			defer s.Shutdown()
+
+			udpSize.Store(0)
+			uc := &UpstreamConfig{Endpoint: pc.LocalAddr().String(), Type: ResolverTypeLegacy, EDNSBufferSize: tc.bufferSize}
+			r := &legacyResolver{uc: uc}
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			msg.SetEdns0(4096, false)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			answer, err := r.Resolve(ctx, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if answer.Truncated || len(answer.Answer) != 1 {
+				t.Errorf("unexpected answer: %v", answer)
+			}
+			assert.Equal(t, tc.wantSize, udpSize.Load())
+			assert.Equal(t, tc.wantDowngrade, uc.ednsBufferSize() == MinEDNSBufferSize)
+			// The original message must not be changed.
+			assert.Equal(t, uint16(4096), msg.IsEdns0().UDPSize())
+		})
+	}
+}
+
+func Test_osResolver_ResolveSupplemental(t *testing.T) {
+	answerWith := func(ip string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, msg *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(msg)
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: msg.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+			w.WriteMsg(m)
+		}
+	}
+	startServer := func(h dns.Handler) string {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		s, addr, err := runLocalPacketConnTestServer(t, pc, h)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(func() { s.Shutdown() })
+		return addr
+	}
+	public := startServer(answerWith("1.1.1.1"))
+	corp := startServer(answerWith("10.0.0.1"))
+	dev := startServer(answerWith("10.0.0.2"))
+
+	resolver := &osResolver{}
+	resolver.publicServer.Store(&[]string{public})
+	resolver.supplemental.Store(&[]supplementalResolver{
+		{domain: "corp.example", nameservers: []string{corp}},
+		{domain: "dev.corp.example", nameservers: []string{dev}},
+	})
+
+	tests := []struct {
+		name  string
+		qname string
+		want  string
+	}{
+		{"match domain", "corp.example.", "10.0.0.1"},
+		{"sub domain", "host.corp.example.", "10.0.0.1"},
+		{"longest match", "host.DEV.corp.example.", "10.0.0.2"},
+		{"not match label", "mycorp.example.", "1.1.1.1"},
+		{"no match", "controld.com.", "1.1.1.1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.SetQuestion(tc.qname, dns.TypeA)
+			answer, err := resolver.Resolve(context.Background(), msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !assert.Len(t, answer.Answer, 1) {
+				return
+			}
+			assert.Equal(t, tc.want, answer.Answer[0].(*dns.A).A.String())
+		})
+	}
+}
+
+type resolverFunc func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	return f(ctx, msg)
+}
+
+func Test_osResolver_ResolveWithDohTemplate(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plainHandler := dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(msg, dns.RcodeRefused)
+		w.WriteMsg(m)
+	})
+	s, addr, err := runLocalPacketConnTestServer(t, pc, plainHandler)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Shutdown()
+
+	var dohQueries atomic.Int32
+	dohFailed := false
+	doh := resolverFunc(func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+		dohQueries.Add(1)
+		if dohFailed {
+			return nil, errors.New("DoH failed")
+		}
+		m := new(dns.Msg)
+		m.SetRcode(msg, dns.RcodeSuccess)
+		return m, nil
+	})
+	resolver := &osResolver{}
+	resolver.publicServer.Store(&[]string{addr})
+	resolver.doh.Store(&map[string]Resolver{addr: doh})
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("controld.com.", dns.TypeA)
+	answer, err := resolver.Resolve(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dns.RcodeSuccess, answer.Rcode, "DoH answer must be used")
+
+	dohFailed = true
+	answer, err = resolver.Resolve(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dns.RcodeRefused, answer.Rcode, "plain DNS answer must be used after DoH failure")
+	assert.Equal(t, int32(2), dohQueries.Load())
+}
+
+func Test_newOsDohResolvers(t *testing.T) {
+	resolvers := newOsDohResolvers(map[string]string{
+		"1.1.1.1":              "https://cloudflare-dns.com/dns-query",
+		"2001:4860:4860::8888": "https://dns.google/dns-query{?dns}",
+	})
+	if !assert.Len(t, resolvers, 2) {
+		return
+	}
+	r := resolvers["1.1.1.1:53"].(*dohResolver)
+	assert.Equal(t, "https://cloudflare-dns.com/dns-query", r.endpoint.String())
+	assert.Equal(t, "1.1.1.1", r.uc.BootstrapIP)
+	r = resolvers["[2001:4860:4860::8888]:53"].(*dohResolver)
+	assert.Equal(t, "https://dns.google/dns-query", r.endpoint.String())
+	assert.Equal(t, "2001:4860:4860::8888", r.uc.BootstrapIP)
+}
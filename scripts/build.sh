@@ -59,7 +59,8 @@ compress() {
 build() {
   goos=$1
   goarch=$2
-  ldflags="-s -w -X github.com/Control-D-Inc/ctrld/cmd/cli.version="${CI_COMMIT_TAG:-dev}" -X github.com/Control-D-Inc/ctrld/cmd/cli.commit=$(git rev-parse HEAD)"
+  tags=${CTRLD_BUILD_TAGS:-}
+  ldflags="-s -w -X github.com/Control-D-Inc/ctrld/cmd/cli.version="${CI_COMMIT_TAG:-dev}" -X github.com/Control-D-Inc/ctrld/cmd/cli.commit=$(git rev-parse HEAD) -X github.com/Control-D-Inc/ctrld/cmd/cli.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
 
   case $3 in
     5 | 6 | 7)
@@ -73,7 +74,7 @@ build() {
         binary=${binary}-nocgo
       fi
       GOOS=${goos} GOARCH=${goarch} GOARM=${3} "$go" generate ./...
-      GOOS=${goos} GOARCH=${goarch} GOARM=${3} "$go" build -ldflags="$ldflags" -o "$binary" ./cmd/ctrld
+      GOOS=${goos} GOARCH=${goarch} GOARM=${3} "$go" build -tags="$tags" -ldflags="$ldflags" -o "$binary" ./cmd/ctrld
       compress "$binary"
       ;;
     *)
@@ -83,7 +84,7 @@ build() {
         binary=${binary}-nocgo
       fi
       GOOS=${goos} GOARCH=${goarch} GOMIPS=softfloat "$go" generate ./...
-      GOOS=${goos} GOARCH=${goarch} GOMIPS=softfloat "$go" build -ldflags="$ldflags" -o "$binary" ./cmd/ctrld
+      GOOS=${goos} GOARCH=${goarch} GOMIPS=softfloat "$go" build -tags="$tags" -ldflags="$ldflags" -o "$binary" ./cmd/ctrld
       compress "$binary"
       ;;
   esac
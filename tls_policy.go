@@ -0,0 +1,65 @@
+package ctrld
+
+import (
+	"crypto/tls"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Possible values of tls_min_version.
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// tlsVersions maps tls_min_version values to their TLS versions.
+var tlsVersions = map[string]uint16{
+	TLSVersion12: tls.VersionTLS12,
+	TLSVersion13: tls.VersionTLS13,
+}
+
+// tlsCipherSuiteIDs maps names of secure cipher suites to their IDs.
+var tlsCipherSuiteIDs = func() map[string]uint16 {
+	m := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		m[cs.Name] = cs.ID
+	}
+	return m
+}()
+
+// SetDefaultTLSPolicy sets the minimum TLS version and cipher suites used by the upstream,
+// if they are not set in the upstream config itself.
+func (uc *UpstreamConfig) SetDefaultTLSPolicy(minVersion string, cipherSuites []string) {
+	uc.defaultTLSMinVersion = minVersion
+	uc.defaultTLSCipherSuites = cipherSuites
+}
+
+// applyTLSPolicy sets the minimum TLS version and cipher suites of the upstream to cfg.
+func (uc *UpstreamConfig) applyTLSPolicy(cfg *tls.Config) {
+	minVersion := uc.TLSMinVersion
+	if minVersion == "" {
+		minVersion = uc.defaultTLSMinVersion
+	}
+	if v := tlsVersions[minVersion]; v > cfg.MinVersion {
+		cfg.MinVersion = v
+	}
+	cipherSuites := uc.TLSCipherSuites
+	if len(cipherSuites) == 0 {
+		cipherSuites = uc.defaultTLSCipherSuites
+	}
+	if len(cipherSuites) == 0 {
+		return
+	}
+	cfg.CipherSuites = make([]uint16, 0, len(cipherSuites))
+	for _, name := range cipherSuites {
+		if id, ok := tlsCipherSuiteIDs[name]; ok {
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+}
+
+// validateTLSCipherSuite validates the name of a TLS cipher suite, only secure ones are allowed.
+func validateTLSCipherSuite(fl validator.FieldLevel) bool {
+	_, ok := tlsCipherSuiteIDs[fl.Field().String()]
+	return ok
+}
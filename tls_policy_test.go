@@ -0,0 +1,35 @@
+package ctrld
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamConfig_applyTLSPolicy(t *testing.T) {
+	uc := &UpstreamConfig{}
+	cfg := &tls.Config{}
+	uc.applyTLSPolicy(cfg)
+	assert.Zero(t, cfg.MinVersion)
+	assert.Nil(t, cfg.CipherSuites)
+
+	// Service policy is used if the upstream does not set its own.
+	uc.SetDefaultTLSPolicy(TLSVersion13, []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"})
+	cfg = &tls.Config{}
+	uc.applyTLSPolicy(cfg)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, cfg.CipherSuites)
+
+	uc.TLSMinVersion = TLSVersion12
+	uc.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}
+	cfg = &tls.Config{}
+	uc.applyTLSPolicy(cfg)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, cfg.CipherSuites)
+
+	// The policy never lowers the minimum version required by the protocol.
+	cfg = &tls.Config{MinVersion: tls.VersionTLS13}
+	uc.applyTLSPolicy(cfg)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+}
@@ -0,0 +1,180 @@
+package ctrld
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// tlsSessionCacheCapacity is the maximum number of sessions kept in TLSSessionCache.
+	tlsSessionCacheCapacity = 256
+	// tlsSessionCacheSaveDelay is the delay before saving sessions to disk, so multiple
+	// new sessions in a short time are saved at once.
+	tlsSessionCacheSaveDelay = 5 * time.Second
+)
+
+// TLSSessionCache is a tls.ClientSessionCache shared by all upstreams, so TLS/QUIC
+// sessions survive re-creating upstream transports. If path is not empty, sessions
+// are also persisted to disk, so they survive ctrld restarts.
+type TLSSessionCache struct {
+	path string
+
+	mu          sync.Mutex
+	sessions    map[string]*tls.ClientSessionState
+	saveTimer   *time.Timer
+	savePending bool
+}
+
+// persistedSession is a session in the TLSSessionCache file.
+type persistedSession struct {
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// defaultTLSSessionCache is used by upstreams if SetTLSSessionCache was never called.
+var defaultTLSSessionCache = NewTLSSessionCache("")
+
+// NewTLSSessionCache returns new TLSSessionCache, loading persisted sessions from path, if any.
+func NewTLSSessionCache(path string) *TLSSessionCache {
+	c := &TLSSessionCache{
+		path:     path,
+		sessions: make(map[string]*tls.ClientSessionState),
+	}
+	if path != "" {
+		if err := c.load(); err != nil && !os.IsNotExist(err) {
+			ProxyLogger.Load().Warn().Err(err).Msgf("could not load tls session cache: %s", path)
+		}
+	}
+	return c
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *TLSSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cs, ok := c.sessions[sessionKey]
+	return cs, ok
+}
+
+// Put implements tls.ClientSessionCache.
+func (c *TLSSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs == nil {
+		delete(c.sessions, sessionKey)
+	} else {
+		if _, ok := c.sessions[sessionKey]; !ok && len(c.sessions) >= tlsSessionCacheCapacity {
+			// Map iteration order is random, so this evicts a random session.
+			for k := range c.sessions {
+				delete(c.sessions, k)
+				break
+			}
+		}
+		c.sessions[sessionKey] = cs
+	}
+	c.scheduleSave()
+}
+
+// Save writes all sessions to disk immediately. It's a no-op if the cache is in-memory only.
+func (c *TLSSessionCache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	persisted := make(map[string]persistedSession, len(c.sessions))
+	for k, cs := range c.sessions {
+		ticket, state, err := cs.ResumptionState()
+		if err != nil || state == nil {
+			continue
+		}
+		stateBytes, err := state.Bytes()
+		if err != nil {
+			continue
+		}
+		persisted[k] = persistedSession{Ticket: ticket, State: stateBytes}
+	}
+	c.savePending = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	// Write to temporary file then rename, so the cache file is never half-written.
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// scheduleSave schedules saving sessions to disk. The caller must hold c.mu.
+func (c *TLSSessionCache) scheduleSave() {
+	if c.path == "" || c.savePending {
+		return
+	}
+	c.savePending = true
+	if c.saveTimer == nil {
+		c.saveTimer = time.AfterFunc(tlsSessionCacheSaveDelay, func() {
+			if err := c.Save(); err != nil {
+				ProxyLogger.Load().Warn().Err(err).Msgf("could not save tls session cache: %s", c.path)
+			}
+		})
+		return
+	}
+	c.saveTimer.Reset(tlsSessionCacheSaveDelay)
+}
+
+func (c *TLSSessionCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	var persisted map[string]persistedSession
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, ps := range persisted {
+		state, err := tls.ParseSessionState(ps.State)
+		if err != nil {
+			continue
+		}
+		cs, err := tls.NewResumptionState(ps.Ticket, state)
+		if err != nil {
+			continue
+		}
+		c.sessions[k] = cs
+	}
+	return nil
+}
+
+// prefixedSessionCache is a tls.ClientSessionCache which namespaces session keys
+// of a TLSSessionCache, so sessions of different protocols are not mixed up.
+type prefixedSessionCache struct {
+	prefix string
+	cache  tls.ClientSessionCache
+}
+
+// Get implements tls.ClientSessionCache.
+func (p *prefixedSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return p.cache.Get(p.prefix + sessionKey)
+}
+
+// Put implements tls.ClientSessionCache.
+func (p *prefixedSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	p.cache.Put(p.prefix+sessionKey, cs)
+}
@@ -0,0 +1,55 @@
+package ctrld
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSSessionCache_Persist(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	get := func(cache tls.ClientSessionCache) *http.Response {
+		t.Helper()
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, ClientSessionCache: cache},
+			DisableKeepAlives: true,
+		}}
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	path := filepath.Join(t.TempDir(), "tls_sessions.json")
+	cache := NewTLSSessionCache(path)
+	resp := get(cache)
+	assert.False(t, resp.TLS.DidResume)
+	require.NoError(t, cache.Save())
+
+	// New cache loaded from disk must resume the session.
+	resp = get(NewTLSSessionCache(path))
+	assert.True(t, resp.TLS.DidResume)
+}
+
+func TestTLSSessionCache_Capacity(t *testing.T) {
+	cache := NewTLSSessionCache("")
+	for i := 0; i < tlsSessionCacheCapacity+10; i++ {
+		cache.Put(string(rune(i)), &tls.ClientSessionState{})
+	}
+	assert.Len(t, cache.sessions, tlsSessionCacheCapacity)
+	cache.Put(string(rune(0)), nil)
+	_, ok := cache.Get(string(rune(0)))
+	assert.False(t, ok)
+}
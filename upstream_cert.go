@@ -0,0 +1,78 @@
+package ctrld
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
+// UpstreamCert describes the leaf certificate presented by an encrypted upstream during TLS handshakes.
+type UpstreamCert struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"`
+	SPKI        string    `json:"spki"`
+}
+
+func newUpstreamCert(c *x509.Certificate) *UpstreamCert {
+	sum := sha256.Sum256(c.Raw)
+	spki := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return &UpstreamCert{
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		SPKI:        hex.EncodeToString(spki[:]),
+	}
+}
+
+// IsRenewalOf reports whether c looks like a renewal of prev, that's issued for the same subject, and either
+// does not expire earlier, or uses the same key. The issuer is not compared, as CAs rotate their intermediates.
+func (c *UpstreamCert) IsRenewalOf(prev *UpstreamCert) bool {
+	if c.Subject != prev.Subject {
+		return false
+	}
+	return !c.NotAfter.Before(prev.NotAfter) || (c.SPKI != "" && c.SPKI == prev.SPKI)
+}
+
+// SetCertObserver sets the function called when the upstream presents a certificate other than
+// the last seen one, prev is nil for the first seen certificate. The function is called during
+// TLS handshakes, so it must not block.
+func (uc *UpstreamConfig) SetCertObserver(f func(prev, cert *UpstreamCert)) {
+	uc.certObserver = f
+}
+
+// Cert returns the last certificate presented by the upstream, or nil if there's none yet.
+func (uc *UpstreamConfig) Cert() *UpstreamCert {
+	return uc.cert.Load()
+}
+
+// monitorCert sets cfg to record the certificates presented by the upstream.
+func (uc *UpstreamConfig) monitorCert(cfg *tls.Config) {
+	cfg.VerifyConnection = uc.verifyConnection
+}
+
+// verifyConnection records the leaf certificate of verified connection cs, it never rejects the connection.
+func (uc *UpstreamConfig) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := newUpstreamCert(cs.PeerCertificates[0])
+	prev := uc.cert.Load()
+	if prev != nil && prev.Fingerprint == cert.Fingerprint {
+		return nil
+	}
+	// Concurrent handshakes may see the same new certificate, only the first one reports it.
+	if !uc.cert.CompareAndSwap(prev, cert) {
+		return nil
+	}
+	if uc.certObserver != nil {
+		uc.certObserver(prev, cert)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package ctrld
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCert(t *testing.T, cn string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	c, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return c
+}
+
+func TestUpstreamConfig_verifyConnection(t *testing.T) {
+	uc := &UpstreamConfig{}
+	cfg := &tls.Config{}
+	uc.monitorCert(cfg)
+	require.NotNil(t, cfg.VerifyConnection)
+
+	type change struct{ prev, cert *UpstreamCert }
+	var changes []change
+	uc.SetCertObserver(func(prev, cert *UpstreamCert) {
+		changes = append(changes, change{prev, cert})
+	})
+	assert.Nil(t, uc.Cert())
+	assert.NoError(t, cfg.VerifyConnection(tls.ConnectionState{}))
+	assert.Nil(t, uc.Cert())
+
+	now := time.Now().Truncate(time.Second)
+	c1 := testCert(t, "dns.example.com", now.Add(30*24*time.Hour))
+	assert.NoError(t, cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{c1}}))
+	require.Len(t, changes, 1)
+	assert.Nil(t, changes[0].prev)
+	assert.Equal(t, "CN=dns.example.com", changes[0].cert.Subject)
+	assert.True(t, changes[0].cert.NotAfter.Equal(c1.NotAfter))
+	assert.Len(t, changes[0].cert.Fingerprint, 64)
+	assert.Len(t, changes[0].cert.SPKI, 64)
+	assert.Same(t, changes[0].cert, uc.Cert())
+
+	// Same certificate is not reported again.
+	assert.NoError(t, cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{c1}}))
+	assert.Len(t, changes, 1)
+
+	c2 := testCert(t, "dns.example.com", now.Add(90*24*time.Hour))
+	assert.NoError(t, cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{c2}}))
+	require.Len(t, changes, 2)
+	assert.Same(t, changes[0].cert, changes[1].prev)
+	assert.True(t, changes[1].cert.IsRenewalOf(changes[1].prev))
+}
+
+func TestUpstreamCert_IsRenewalOf(t *testing.T) {
+	now := time.Now()
+	prev := &UpstreamCert{Subject: "CN=dns.example.com", Issuer: "CN=CA", NotAfter: now, SPKI: "key"}
+	tests := []struct {
+		name string
+		cert *UpstreamCert
+		want bool
+	}{
+		{"renewed", &UpstreamCert{Subject: prev.Subject, Issuer: prev.Issuer, NotAfter: now.Add(time.Hour)}, true},
+		{"earlier expiry", &UpstreamCert{Subject: prev.Subject, Issuer: prev.Issuer, NotAfter: now.Add(-time.Hour)}, false},
+		{"other issuer", &UpstreamCert{Subject: prev.Subject, Issuer: "CN=Other CA", NotAfter: now.Add(time.Hour)}, true},
+		{"earlier expiry same key", &UpstreamCert{Subject: prev.Subject, Issuer: prev.Issuer, NotAfter: now.Add(-time.Hour), SPKI: "key"}, true},
+		{"other subject", &UpstreamCert{Subject: "CN=other.example.com", Issuer: prev.Issuer, NotAfter: now.Add(time.Hour)}, false},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, tc.cert.IsRenewalOf(prev))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package ctrld
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+)
+
+// trackedConn is a connection to upstream, which is counted as open until it's closed.
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+	open *atomic.Int64
+}
+
+// Close closes the connection, and stops counting it as open.
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// trackConn counts conn as open connection of the upstream, until it's closed.
+func (uc *UpstreamConfig) trackConn(conn net.Conn) net.Conn {
+	uc.openConns.Add(1)
+	return &trackedConn{Conn: conn, open: &uc.openConns}
+}
+
+// trackQUICConn counts conn as open connection of the upstream, until it's closed,
+// either by ctrld, by the upstream, or because of idle timeout.
+func (uc *UpstreamConfig) trackQUICConn(conn quic.Connection) {
+	uc.openConns.Add(1)
+	go func() {
+		<-conn.Context().Done()
+		uc.openConns.Add(-1)
+	}()
+}
+
+// OpenConns returns the number of open connections to the upstream, including idle ones kept alive
+// for re-using. Plain DNS queries over UDP are not counted, since they do not keep connections.
+func (uc *UpstreamConfig) OpenConns() int64 {
+	return uc.openConns.Load() + int64(uc.dnsConns.openConns())
+}
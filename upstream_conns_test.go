@@ -0,0 +1,27 @@
+package ctrld
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamConfig_trackConn(t *testing.T) {
+	uc := &UpstreamConfig{}
+	c1, c2 := net.Pipe()
+
+	conn := uc.trackConn(c1)
+	assert.Equal(t, int64(1), uc.OpenConns())
+	other := uc.trackConn(c2)
+	assert.Equal(t, int64(2), uc.OpenConns())
+
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, int64(1), uc.OpenConns())
+	// Closing again must not be counted twice.
+	_ = conn.Close()
+	assert.Equal(t, int64(1), uc.OpenConns())
+
+	assert.NoError(t, other.Close())
+	assert.Zero(t, uc.OpenConns())
+}